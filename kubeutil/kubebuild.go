@@ -0,0 +1,285 @@
+package kubeutil
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Labels added to every generated manifest
+const (
+	labelName      = "app.kubernetes.io/name"
+	labelManagedBy = "app.kubernetes.io/managed-by"
+)
+
+// DeploymentParams describes a single container deployment
+type DeploymentParams struct {
+	Name      string
+	Namespace string
+	Image     string
+	Replicas  int32 // 1 if zero
+	Port      int32 // container port, none if zero
+	Env       map[string]string
+	Labels    map[string]string
+}
+
+// ServiceParams describes a service exposing a single port
+type ServiceParams struct {
+	Name       string
+	Namespace  string
+	Type       string // ClusterIP if empty
+	Port       int32
+	TargetPort int32             // Port if zero
+	Selector   map[string]string // app.kubernetes.io/name: Name if nil
+	Labels     map[string]string
+}
+
+// ConfigMapParams describes a config map
+type ConfigMapParams struct {
+	Name      string
+	Namespace string
+	Data      map[string]string
+	Labels    map[string]string
+}
+
+// SecretParams describes a secret, values are stored as stringData
+type SecretParams struct {
+	Name      string
+	Namespace string
+	Type      string // Opaque if empty
+	Data      map[string]string
+	Labels    map[string]string
+}
+
+// NewDeployment returns an apps/v1 Deployment manifest
+func NewDeployment(p DeploymentParams) ([]byte, error) {
+	if err := validateMeta(p.Name, p.Namespace, p.Labels); err != nil {
+		return nil, err
+	}
+	if p.Image == "" {
+		return nil, errors.New("Deployment image cannot be empty")
+	}
+	if p.Replicas < 0 {
+		return nil, errors.New("Deployment replicas cannot be negative")
+	}
+	if err := validatePort("Deployment port", p.Port, true); err != nil {
+		return nil, err
+	}
+
+	replicas := p.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+	selector := map[string]interface{}{labelName: p.Name}
+	// pods carry the labels too, the selector label is kept so they match
+	podLabels := map[string]interface{}{}
+	for k, v := range p.Labels {
+		podLabels[k] = v
+	}
+	for k, v := range selector {
+		podLabels[k] = v
+	}
+
+	container := map[string]interface{}{
+		"name":  p.Name,
+		"image": p.Image,
+	}
+	if p.Port != 0 {
+		container["ports"] = []interface{}{
+			map[string]interface{}{"containerPort": p.Port},
+		}
+	}
+	if len(p.Env) > 0 {
+		env := []interface{}{}
+		for _, name := range sortedKeys(p.Env) {
+			env = append(env, map[string]interface{}{"name": name, "value": p.Env[name]})
+		}
+		container["env"] = env
+	}
+
+	return buildManifest("apps/v1", "Deployment", p.Name, p.Namespace, p.Labels,
+		map[string]interface{}{
+			"spec": map[string]interface{}{
+				"replicas": replicas,
+				"selector": map[string]interface{}{"matchLabels": selector},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": podLabels},
+					"spec": map[string]interface{}{
+						"containers": []interface{}{container},
+					},
+				},
+			},
+		})
+}
+
+// NewService returns a v1 Service manifest
+func NewService(p ServiceParams) ([]byte, error) {
+	if err := validateMeta(p.Name, p.Namespace, p.Labels); err != nil {
+		return nil, err
+	}
+	if err := validatePort("Service port", p.Port, false); err != nil {
+		return nil, err
+	}
+	if err := validatePort("Service target port", p.TargetPort, true); err != nil {
+		return nil, err
+	}
+
+	serviceType := p.Type
+	switch serviceType {
+	case "":
+		serviceType = "ClusterIP"
+	case "ClusterIP", "NodePort", "LoadBalancer":
+	default:
+		return nil, errors.New("Unsupported service type: " + serviceType)
+	}
+
+	targetPort := p.TargetPort
+	if targetPort == 0 {
+		targetPort = p.Port
+	}
+	selector := map[string]interface{}{labelName: p.Name}
+	if p.Selector != nil {
+		selector = map[string]interface{}{}
+		for k, v := range p.Selector {
+			selector[k] = v
+		}
+	}
+
+	return buildManifest("v1", "Service", p.Name, p.Namespace, p.Labels,
+		map[string]interface{}{
+			"spec": map[string]interface{}{
+				"type":     serviceType,
+				"selector": selector,
+				"ports": []interface{}{
+					map[string]interface{}{"port": p.Port, "targetPort": targetPort},
+				},
+			},
+		})
+}
+
+// NewConfigMap returns a v1 ConfigMap manifest
+func NewConfigMap(p ConfigMapParams) ([]byte, error) {
+	if err := validateMeta(p.Name, p.Namespace, p.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateDataKeys("ConfigMap", p.Data); err != nil {
+		return nil, err
+	}
+
+	return buildManifest("v1", "ConfigMap", p.Name, p.Namespace, p.Labels,
+		map[string]interface{}{"data": stringMap(p.Data)})
+}
+
+// NewSecret returns a v1 Secret manifest
+func NewSecret(p SecretParams) ([]byte, error) {
+	if err := validateMeta(p.Name, p.Namespace, p.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateDataKeys("Secret", p.Data); err != nil {
+		return nil, err
+	}
+
+	secretType := p.Type
+	if secretType == "" {
+		secretType = "Opaque"
+	}
+
+	return buildManifest("v1", "Secret", p.Name, p.Namespace, p.Labels,
+		map[string]interface{}{
+			"type":       secretType,
+			"stringData": stringMap(p.Data),
+		})
+}
+
+// buildManifest returns the manifest yaml with the metadata and labels set
+func buildManifest(apiVersion, kind, name, namespace string,
+	labels map[string]string, fields map[string]interface{}) ([]byte, error) {
+
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	manifestLabels := map[string]interface{}{
+		labelName:      name,
+		labelManagedBy: fieldManager,
+	}
+	for k, v := range labels {
+		manifestLabels[k] = v
+	}
+	metadata["labels"] = manifestLabels
+
+	manifest := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   metadata,
+	}
+	for k, v := range fields {
+		manifest[k] = v
+	}
+	return yaml.Marshal(manifest)
+}
+
+// validateMeta returns an error if the name, namespace or labels are invalid
+func validateMeta(name, namespace string, labels map[string]string) error {
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("Invalid name %q: %s", name, strings.Join(errs, ", "))
+	}
+	if namespace != "" {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("Invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+	}
+	for k, v := range labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("Invalid label key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("Invalid label value %q: %s", v, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// validatePort returns an error if the port is out of range
+func validatePort(what string, port int32, optional bool) error {
+	if optional && port == 0 {
+		return nil
+	}
+	if errs := validation.IsValidPortNum(int(port)); len(errs) > 0 {
+		return fmt.Errorf("Invalid %s %d: %s", strings.ToLower(what), port, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateDataKeys returns an error if a data key is not a valid file name
+func validateDataKeys(kind string, data map[string]string) error {
+	for k := range data {
+		if errs := validation.IsConfigMapKey(k); len(errs) > 0 {
+			return fmt.Errorf("Invalid %s key %q: %s", kind, k, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// stringMap returns the map with interface values for marshaling
+func stringMap(m map[string]string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
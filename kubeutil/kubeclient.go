@@ -0,0 +1,238 @@
+package kubeutil
+
+import (
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// BackendKubectl executes commands with the kubectl binary
+	BackendKubectl = "kubectl"
+
+	// BackendClientGo executes commands with the client-go dynamic client
+	BackendClientGo = "client-go"
+)
+
+// fieldManager identifies the server-side apply changes made by KubeUtil
+const fieldManager = "go-core-kubeutil"
+
+// SetClient sets the dynamic client and REST mapper used by the client-go
+// backend, otherwise they are created from the kubeconfig context
+func (k *KubeUtil) SetClient(client dynamic.Interface, mapper meta.RESTMapper) {
+	k._client = client
+	k._mapper = mapper
+}
+
+// RESTConfig returns the REST config of the config kubeconfig context, for
+// clients of operations not covered by the commands
+func (k *KubeUtil) RESTConfig(conf *KubeConfig) (*rest.Config, error) {
+	if err := k.setConfig(conf); err != nil {
+		return nil, err
+	}
+	return k.restConfig()
+}
+
+// Client returns the dynamic client and REST mapper used by the client-go
+// backend, those set with SetClient or else created from the config
+// kubeconfig context, for operations not covered by the commands
+func (k *KubeUtil) Client(conf *KubeConfig) (dynamic.Interface, meta.RESTMapper, error) {
+	if err := k.setConfig(conf); err != nil {
+		return nil, nil, err
+	}
+	if k._client == nil || k._mapper == nil {
+		if err := k.newClient(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return k._client, k._mapper, nil
+}
+
+// Clientset returns the clientset used to get pod logs, the one set with
+// SetClientset or else created from the config kubeconfig context
+func (k *KubeUtil) Clientset(conf *KubeConfig) (kubernetes.Interface, error) {
+	if err := k.setConfig(conf); err != nil {
+		return nil, err
+	}
+	if k._clientset == nil {
+		if err := k.newClientset(); err != nil {
+			return nil, err
+		}
+	}
+	return k._clientset, nil
+}
+
+// setConfig validates and sets the config of the clients
+func (k *KubeUtil) setConfig(conf *KubeConfig) error {
+	if validConf := conf.New(*conf); validConf != nil {
+		return validConf
+	}
+	k._config = conf
+	return nil
+}
+
+// restConfig returns the REST config of the kubeconfig context
+func (k *KubeUtil) restConfig() (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: k._config.GetKubectx()}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules, overrides).ClientConfig()
+}
+
+func (k *KubeUtil) newClient() error {
+	restConfig, err := k.restConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	k._client = client
+	k._mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	return nil
+}
+
+// resource returns the dynamic resource client for the manifest object
+func (k *KubeUtil) resource(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := k._mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return k._client.Resource(mapping.Resource), nil
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = k._config.GetNamespace()
+		obj.SetNamespace(namespace)
+	}
+	return k._client.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// clientResource returns the manifest object and its dynamic resource client
+func (k *KubeUtil) clientResource() (*unstructured.Unstructured, dynamic.ResourceInterface, error) {
+	if k._client == nil || k._mapper == nil {
+		if err := k.newClient(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data, err := yaml.YAMLToJSON(k._manifestRaw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, nil, err
+	}
+
+	ri, err := k.resource(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, ri, nil
+}
+
+func (k *KubeUtil) executeClient() error {
+	if k._command == kuDescribe {
+		return k.executeDescribe()
+	}
+
+	obj, ri, err := k.clientResource()
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+
+	var dryRun []string
+	if k._dryRun {
+		dryRun = []string{metav1.DryRunAll}
+	}
+
+	var result runtime.Object
+	switch k._command {
+	case kuApply:
+		var data []byte
+		data, err = obj.MarshalJSON()
+		if err == nil {
+			force := true
+			result, err = ri.Patch(k._ctx, obj.GetName(), types.ApplyPatchType, data,
+				metav1.PatchOptions{DryRun: dryRun, FieldManager: fieldManager, Force: &force})
+		}
+
+	case kuCreate:
+		result, err = ri.Create(k._ctx, obj, metav1.CreateOptions{DryRun: dryRun, FieldManager: fieldManager})
+
+	case kuDelete:
+		err = ri.Delete(k._ctx, obj.GetName(), metav1.DeleteOptions{DryRun: dryRun})
+
+	case kuGet:
+		result, err = ri.Get(k._ctx, obj.GetName(), metav1.GetOptions{})
+
+	case kuList:
+		list := fmt.Sprintf("CustomerID=%v", k._user.CustomerID)
+		result, err = ri.List(k._ctx, metav1.ListOptions{LabelSelector: list})
+
+	default:
+		err = errors.New("Unsupported client-go command: " + k._command)
+	}
+
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+
+	k._result = ""
+	if result != nil {
+		out, err := k.marshalOutput(result)
+		if err != nil {
+			k._error = err.Error()
+			return err
+		}
+		k._result = k.redactResult(string(out))
+	}
+	return nil
+}
+
+// executeDescribe sets the result to the structured description as yaml or
+// json
+func (k *KubeUtil) executeDescribe() error {
+	description, err := k.describe()
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+
+	out, err := k.marshalOutput(description)
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+	k._result = string(out)
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
 )
 
 var _kinds = []string{"KubeConfig"}
@@ -27,6 +30,22 @@ type KubeConfig struct {
 	Name string `json:"name"`
 
 	ManifestDirectory string `json:"manifestDirectory"`
+
+	// Backend - kubectl (default) or client-go
+	Backend string `json:"backend"`
+
+	// KubectlPath - kubectl binary, looked up in PATH if not a path
+	KubectlPath string `json:"kubectlPath"`
+
+	// KubectlMinVersion - oldest kubectl client version allowed, e.g. 1.27
+	KubectlMinVersion string `json:"kubectlMinVersion"`
+
+	// CommandTimeout - limit of each command, none if zero
+	CommandTimeout time.Duration `json:"commandTimeout"`
+
+	// KillGracePeriod - wait between SIGTERM and SIGKILL of kubectl when
+	// the command is cancelled, killed at once if zero
+	KillGracePeriod time.Duration `json:"killGracePeriod"`
 }
 
 func (k *KubeConfig) New(conf KubeConfig) error {
@@ -56,6 +75,28 @@ func (k *KubeConfig) New(conf KubeConfig) error {
 	k.Name = conf.Name
 	k.Namespace = conf.Namespace
 
+	if !k.SupportedBackend(conf.Backend) {
+		return errors.New("Unsupported backend: " + conf.Backend)
+	}
+	k.Backend = conf.Backend
+
+	if conf.KubectlMinVersion != "" {
+		if _, err := version.ParseGeneric(conf.KubectlMinVersion); err != nil {
+			return errors.New("Invalid kubectl minimum version: " + conf.KubectlMinVersion)
+		}
+	}
+	k.KubectlPath = conf.KubectlPath
+	k.KubectlMinVersion = conf.KubectlMinVersion
+
+	if conf.CommandTimeout < 0 {
+		return errors.New("Command timeout cannot be negative")
+	}
+	if conf.KillGracePeriod < 0 {
+		return errors.New("Kill grace period cannot be negative")
+	}
+	k.CommandTimeout = conf.CommandTimeout
+	k.KillGracePeriod = conf.KillGracePeriod
+
 	return nil
 }
 
@@ -105,7 +146,17 @@ func (k *KubeConfig) SupportedResource(resource string) bool {
 
 func (k *KubeConfig) SupportedCommand(cmd string) bool {
 	switch cmd {
-	case kuAttach, kuApply, kuAutoscale, kuCreate, kuDelete, kuDescribe, kuExplain, kuExspose, kuGet, kuList, kuLogs, kuRollout, kuSet, kuScale, kuWatch:
+	case kuAttach, kuApply, kuAutoscale, kuCreate, kuDelete, kuDescribe, kuExplain, kuExspose, kuGet, kuList, kuLogs, kuRollout, kuSet, kuScale, kuWatch, kuWait:
+		return true
+
+	default:
+		return false
+	}
+}
+
+func (k *KubeConfig) SupportedBackend(backend string) bool {
+	switch backend {
+	case "", BackendKubectl, BackendClientGo:
 		return true
 
 	default:
@@ -137,3 +188,17 @@ func (k *KubeConfig) GetName() string {
 func (k *KubeConfig) GetManifestDirectory() string {
 	return k.ManifestDirectory
 }
+
+func (k *KubeConfig) GetBackend() string {
+	if k.Backend == "" {
+		return BackendKubectl
+	}
+	return k.Backend
+}
+
+func (k *KubeConfig) GetKubectlPath() string {
+	if k.KubectlPath == "" {
+		return defaultKubectlPath
+	}
+	return k.KubectlPath
+}
@@ -0,0 +1,179 @@
+package kubeutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// defaultKubectlPath is the kubectl binary looked up in PATH
+const defaultKubectlPath = "kubectl"
+
+// kubectlVersions caches the client version of each kubectl binary
+var kubectlVersions sync.Map
+
+// kubectlCmd provides a kubectl command stopping the SIGKILL of its group
+// once waited, the group id may be reused after
+type kubectlCmd struct {
+	*exec.Cmd
+	grace  time.Duration
+	mutex  sync.Mutex
+	timer  *time.Timer
+	waited bool
+}
+
+// kubectlCommand returns the kubectl command run in its own process group,
+// the group is sent SIGTERM when the context is done then SIGKILL after the
+// grace period, killed at once if the grace period is zero
+func kubectlCommand(ctx context.Context, path string, grace time.Duration,
+	args ...string) *kubectlCmd {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := &kubectlCmd{Cmd: exec.CommandContext(ctx, path, args...), grace: grace}
+	setProcessGroup(cmd.Cmd)
+	cmd.Cancel = cmd.cancel
+	// output pipes held by orphaned children are closed after the grace period
+	cmd.WaitDelay = grace + time.Second
+	return cmd
+}
+
+// cancel terminates the process group then kills it after the grace period
+func (c *kubectlCmd) cancel() error {
+	if c.grace <= 0 {
+		return killProcessGroup(c.Cmd)
+	}
+	c.mutex.Lock()
+	c.timer = time.AfterFunc(c.grace, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if !c.waited {
+			killProcessGroup(c.Cmd)
+		}
+	})
+	c.mutex.Unlock()
+	return terminateProcessGroup(c.Cmd)
+}
+
+// done stops the SIGKILL of the group once the command is waited
+func (c *kubectlCmd) done() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waited = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// Run meets the exec.Cmd method
+func (c *kubectlCmd) Run() error {
+	defer c.done()
+	return c.Cmd.Run()
+}
+
+// Wait meets the exec.Cmd method
+func (c *kubectlCmd) Wait() error {
+	defer c.done()
+	return c.Cmd.Wait()
+}
+
+// Output meets the exec.Cmd method
+func (c *kubectlCmd) Output() ([]byte, error) {
+	defer c.done()
+	return c.Cmd.Output()
+}
+
+// CombinedOutput meets the exec.Cmd method
+func (c *kubectlCmd) CombinedOutput() ([]byte, error) {
+	defer c.done()
+	return c.Cmd.CombinedOutput()
+}
+
+// outputs runs the command and returns its standard output and standard
+// error separately, so warnings are not mixed into the parsed output
+func (c *kubectlCmd) outputs() ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// kubectl returns the kubectl command of the config for the context of the
+// current command
+func (k *KubeUtil) kubectl(args ...string) *kubectlCmd {
+	return kubectlCommand(k._ctx, k._config.GetKubectlPath(),
+		k._config.KillGracePeriod, args...)
+}
+
+// kubectlError returns the context error in place of the error of kubectl
+// killed when the command is cancelled
+func (k *KubeUtil) kubectlError(err error) error {
+	if err != nil && k._ctx != nil && k._ctx.Err() != nil {
+		return fmt.Errorf("kubectl cancelled: %w", k._ctx.Err())
+	}
+	return err
+}
+
+// kubectlVersion returns the client version of the kubectl binary
+func (k *KubeUtil) kubectlVersion(path string) (*version.Version, error) {
+	if v, ok := kubectlVersions.Load(path); ok {
+		return v.(*version.Version), nil
+	}
+
+	data, err := kubectlCommand(k._ctx, path, k._config.KillGracePeriod,
+		"version", "--client", "-o", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl version failed: %s: %w", path, k.kubectlError(err))
+	}
+
+	var info struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("kubectl version unreadable: %s: %v", path, err)
+	}
+
+	v, err := version.ParseSemantic(info.ClientVersion.GitVersion)
+	if err != nil {
+		return nil, fmt.Errorf("kubectl version unreadable: %s: %v", path, err)
+	}
+	kubectlVersions.Store(path, v)
+	return v, nil
+}
+
+// checkKubectl returns an error if the kubectl binary is missing or older
+// than the minimum version of the config
+func (k *KubeUtil) checkKubectl() error {
+	path := k._config.GetKubectlPath()
+	if _, err := exec.LookPath(path); err != nil {
+		return errors.New("kubectl not found: " + path)
+	}
+
+	if k._config.KubectlMinVersion == "" {
+		return nil
+	}
+	minVersion, err := version.ParseGeneric(k._config.KubectlMinVersion)
+	if err != nil {
+		return err
+	}
+
+	v, err := k.kubectlVersion(path)
+	if err != nil {
+		return err
+	}
+	if v.LessThan(minVersion) {
+		return fmt.Errorf("kubectl %s is older than the minimum version %s: %s",
+			v, k._config.KubectlMinVersion, path)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package kubeutil
+
+import "os/exec"
+
+// setProcessGroup leaves the command in the process group of the caller,
+// process groups are not supported
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the command, processes cannot be asked to
+// terminate without process groups
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills the command, its children are left running
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
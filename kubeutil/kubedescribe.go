@@ -0,0 +1,159 @@
+package kubeutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// eventsResource is the core v1 events resource
+var eventsResource = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// Description is the structured state of a resource as shown by describe
+type Description struct {
+	Kind       string                 `json:"kind"`
+	Name       string                 `json:"name"`
+	Namespace  string                 `json:"namespace,omitempty"`
+	Labels     map[string]string      `json:"labels,omitempty"`
+	Conditions []DescribeCondition    `json:"conditions,omitempty"`
+	Status     map[string]interface{} `json:"status,omitempty"`
+	Events     []DescribeEvent        `json:"events,omitempty"`
+}
+
+// DescribeCondition is a status condition of the resource
+type DescribeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// DescribeEvent is an event involving the resource, oldest first
+type DescribeEvent struct {
+	Type          string `json:"type"`
+	Reason        string `json:"reason"`
+	Message       string `json:"message"`
+	Source        string `json:"source,omitempty"`
+	Count         int64  `json:"count,omitempty"`
+	LastTimestamp string `json:"lastTimestamp,omitempty"`
+}
+
+// Describe returns the structured description of the manifest resource
+// Describe always uses the client-go dynamic client whatever the backend
+func (k *KubeUtil) Describe(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	manifest []byte) (*Description, error) {
+
+	k._ctx = ctx
+	if validConf := conf.New(*conf); validConf != nil {
+		return nil, k.respondWithError("Bad config", validConf)
+	}
+
+	if err := k.init(user, conf, kuDescribe, manifest, ""); err != nil {
+		return nil, k.respondWithError("Failed to initialize", err)
+	}
+
+	description, err := k.describe()
+	if err != nil {
+		k._error = err.Error()
+		return nil, k.respondWithError("describe", err)
+	}
+	return description, nil
+}
+
+// describe gets the manifest resource and the events involving it
+func (k *KubeUtil) describe() (*Description, error) {
+	obj, ri, err := k.clientResource()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := ri.Get(k._ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	description := &Description{
+		Kind:      current.GetKind(),
+		Name:      current.GetName(),
+		Namespace: current.GetNamespace(),
+		Labels:    current.GetLabels(),
+	}
+	description.Status, _, _ = unstructured.NestedMap(current.Object, "status")
+	conditions, _, _ := unstructured.NestedSlice(current.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		description.Conditions = append(description.Conditions, DescribeCondition{
+			Type:               nestedString(condition, "type"),
+			Status:             nestedString(condition, "status"),
+			Reason:             nestedString(condition, "reason"),
+			Message:            nestedString(condition, "message"),
+			LastTransitionTime: nestedString(condition, "lastTransitionTime"),
+		})
+	}
+
+	description.Events, err = k.describeEvents(current)
+	if err != nil {
+		return nil, err
+	}
+	return description, nil
+}
+
+// describeEvents returns the events involving the object, oldest first
+func (k *KubeUtil) describeEvents(obj *unstructured.Unstructured) ([]DescribeEvent, error) {
+	selector := fields.Set{
+		"involvedObject.kind": obj.GetKind(),
+		"involvedObject.name": obj.GetName(),
+	}.AsSelector().String()
+	list, err := k._client.Resource(eventsResource).Namespace(obj.GetNamespace()).
+		List(k._ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []DescribeEvent{}
+	for _, item := range list.Items {
+		// the selector may be ignored, as by fake clients
+		if nestedString(item.Object, "involvedObject", "kind") != obj.GetKind() ||
+			nestedString(item.Object, "involvedObject", "name") != obj.GetName() {
+			continue
+		}
+		count, _, _ := unstructured.NestedInt64(item.Object, "count")
+		events = append(events, DescribeEvent{
+			Type:          nestedString(item.Object, "type"),
+			Reason:        nestedString(item.Object, "reason"),
+			Message:       nestedString(item.Object, "message"),
+			Source:        nestedString(item.Object, "source", "component"),
+			Count:         count,
+			LastTimestamp: nestedString(item.Object, "lastTimestamp"),
+		})
+	}
+	// RFC 3339 timestamps sort in time order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp < events[j].LastTimestamp
+	})
+	return events, nil
+}
+
+// nestedString returns the string at the path or "" if missing
+func nestedString(obj map[string]interface{}, path ...string) string {
+	value, found, err := unstructured.NestedFieldNoCopy(obj, path...)
+	if !found || err != nil || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
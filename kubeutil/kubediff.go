@@ -0,0 +1,271 @@
+package kubeutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// ExecResult provides the outcome of the last command executed
+type ExecResult struct {
+	Command string        `json:"command"`
+	Output  string        `json:"output,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	DryRun  bool          `json:"dryRun,omitempty"`
+	Diff    *ManifestDiff `json:"diff,omitempty"` // set in diff mode
+}
+
+// ManifestDiff describes the changes an apply or create would make
+type ManifestDiff struct {
+	Kind      string       `json:"kind"`
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace,omitempty"`
+	Exists    bool         `json:"exists"`            // false if created
+	Changes   []DiffChange `json:"changes,omitempty"` // none if created
+	Output    string       `json:"output,omitempty"`  // unified diff
+}
+
+// DiffChange provides a changed field, Old is nil if added and New is nil
+// if removed
+type DiffChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Changed returns true if the manifest would create or change the object
+func (d *ManifestDiff) Changed() bool {
+	return !d.Exists || len(d.Changes) > 0
+}
+
+// serverFields are set by the cluster on every write and not compared
+var serverFields = map[string]bool{
+	"status":                     true,
+	"metadata.managedFields":     true,
+	"metadata.resourceVersion":   true,
+	"metadata.generation":        true,
+	"metadata.creationTimestamp": true,
+	"metadata.uid":               true,
+}
+
+// SetDryRun validates apply, create and delete on the server without
+// persisting the changes, manifests are not saved
+func (k *KubeUtil) SetDryRun(dryRun bool) {
+	k._dryRun = dryRun
+}
+
+// SetDiff reports the changes of apply and create in place of executing
+// them, manifests are not saved
+func (k *KubeUtil) SetDiff(diff bool) {
+	k._diff = diff
+}
+
+// Result returns the outcome of the last command executed
+func (k *KubeUtil) Result() ExecResult {
+	return ExecResult{
+		Command: k._command,
+		Output:  k._result,
+		Error:   k._error,
+		DryRun:  k._dryRun,
+		Diff:    k._diffResult,
+	}
+}
+
+// saveTemporary writes the manifest to a temporary file for kubectl
+func (k *KubeUtil) saveTemporary() error {
+	f, err := os.CreateTemp("", "kubeutil-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	k._location = f.Name()
+	_, err = f.Write(k._manifestRaw)
+	return err
+}
+
+// executeDiff sets the diff result to the changes the command would make
+// to the existing object
+func (k *KubeUtil) executeDiff() error {
+	if k._command != kuApply && k._command != kuCreate {
+		err := errors.New("Diff not supported for command: " + k._command)
+		k._error = err.Error()
+		return err
+	}
+
+	current, exists, err := k.currentObject()
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+	proposed, err := k.proposedObject()
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+
+	diff := &ManifestDiff{
+		Kind:      proposed.GetKind(),
+		Name:      proposed.GetName(),
+		Namespace: proposed.GetNamespace(),
+		Exists:    exists,
+	}
+	if exists {
+		diff.Changes = diffObjects("", current.Object, proposed.Object)
+	}
+
+	// kubectl diff output is replaced for secrets as it may show values
+	redact := !k._showSecrets && diff.Kind == "Secret"
+	if redact {
+		redactDiff(diff)
+		redactSecrets(proposed.Object)
+	}
+
+	if k._config.GetBackend() == BackendClientGo || redact {
+		diff.Output, err = renderDiff(diff, proposed)
+	} else {
+		diff.Output, err = k.kubectlDiff()
+	}
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+	k._diffResult = diff
+	k._result = diff.Output
+	return nil
+}
+
+// proposedObject returns the object as the server would persist it
+func (k *KubeUtil) proposedObject() (*unstructured.Unstructured, error) {
+	if k._config.GetBackend() == BackendClientGo {
+		obj, ri, err := k.clientResource()
+		if err != nil {
+			return nil, err
+		}
+		dryRun := []string{metav1.DryRunAll}
+		if k._command == kuCreate {
+			return ri.Create(k._ctx, obj, metav1.CreateOptions{
+				DryRun: dryRun, FieldManager: fieldManager})
+		}
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		force := true
+		return ri.Patch(k._ctx, obj.GetName(), types.ApplyPatchType, data,
+			metav1.PatchOptions{DryRun: dryRun, FieldManager: fieldManager, Force: &force})
+	}
+
+	if err := k.checkKubectl(); err != nil {
+		return nil, err
+	}
+	cmd := []string{"--context", k._config.GetKubectx(),
+		"--namespace", k._config.GetNamespace(),
+		k._command, "-f", k._location, "--dry-run=server", "-o", "json"}
+	data, err := k.kubectl(cmd...).Output()
+	if err != nil {
+		return nil, k.kubectlError(err)
+	}
+	proposed := &unstructured.Unstructured{}
+	if err := proposed.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return proposed, nil
+}
+
+// kubectlDiff returns the unified diff output of kubectl diff, which exits
+// with 1 when there are differences
+func (k *KubeUtil) kubectlDiff() (string, error) {
+	cmd := []string{"--context", k._config.GetKubectx(),
+		"--namespace", k._config.GetNamespace(),
+		"diff", "-f", k._location}
+	data, stderr, err := k.kubectl(cmd...).outputs()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return string(data), nil
+	} else if err != nil {
+		return "", fmt.Errorf("kubectl diff failed: %w: %s", k.kubectlError(err), stderr)
+	}
+	return string(data), nil
+}
+
+// renderDiff returns the changes one per line, or the proposed object as
+// yaml if created
+func renderDiff(diff *ManifestDiff, proposed *unstructured.Unstructured) (string, error) {
+	if !diff.Exists {
+		data, err := yaml.Marshal(proposed.Object)
+		return string(data), err
+	}
+
+	var sb strings.Builder
+	for _, change := range diff.Changes {
+		if change.Old != nil {
+			fmt.Fprintf(&sb, "- %s: %v\n", change.Path, change.Old)
+		}
+		if change.New != nil {
+			fmt.Fprintf(&sb, "+ %s: %v\n", change.Path, change.New)
+		}
+	}
+	return sb.String(), nil
+}
+
+// diffObjects returns the changed fields between the old and new values,
+// fields set by the server are ignored
+func diffObjects(path string, old, new interface{}) []DiffChange {
+	if serverFields[path] {
+		return nil
+	}
+
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]interface{}{}
+		for key := range oldMap {
+			keys[key] = nil
+		}
+		for key := range newMap {
+			keys[key] = nil
+		}
+		var changes []DiffChange
+		for _, key := range sortedFieldKeys(keys) {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			oldValue, inOld := oldMap[key]
+			newValue, inNew := newMap[key]
+			switch {
+			case !inOld && !serverFields[field]:
+				changes = append(changes, DiffChange{Path: field, New: newValue})
+			case !inNew && !serverFields[field]:
+				changes = append(changes, DiffChange{Path: field, Old: oldValue})
+			case inOld && inNew:
+				changes = append(changes, diffObjects(field, oldValue, newValue)...)
+			}
+		}
+		return changes
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+	if oldIsList && newIsList && len(oldList) == len(newList) {
+		var changes []DiffChange
+		for i := range oldList {
+			changes = append(changes,
+				diffObjects(fmt.Sprintf("%s[%d]", path, i), oldList[i], newList[i])...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(old, new) {
+		return nil
+	}
+	return []DiffChange{{Path: path, Old: old, New: new}}
+}
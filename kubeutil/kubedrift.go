@@ -0,0 +1,287 @@
+package kubeutil
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Changes reported for a drifted resource
+const (
+	// DriftAdded is a customer object in the cluster without a stored manifest
+	DriftAdded = "added"
+
+	// DriftChanged is an object differing from its stored manifest
+	DriftChanged = "changed"
+
+	// DriftRemoved is a stored manifest without an object in the cluster
+	DriftRemoved = "removed"
+)
+
+// DriftResource describes a resource differing from its stored manifest
+type DriftResource struct {
+	Change    string   `json:"change"`
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace,omitempty"`
+	File      string   `json:"file,omitempty"`   // stored manifest, empty if added
+	Fields    []string `json:"fields,omitempty"` // paths of changed fields
+}
+
+// DriftReport lists the resources of a customer that drifted
+type DriftReport struct {
+	CustomerID int             `json:"customerID"`
+	Resources  []DriftResource `json:"resources"`
+}
+
+// Drifted returns true if any resource drifted
+func (r *DriftReport) Drifted() bool {
+	return len(r.Resources) > 0
+}
+
+// driftKey identifies an object by kind, namespace and name
+type driftKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// Drift compares the stored manifests of the customer against the live
+// objects, fields set by the cluster, such as defaults, status and managed
+// fields, are ignored
+// Added objects are found by the CustomerID label among the kinds and
+// namespaces of the stored manifests
+// Drift always uses the client-go dynamic client whatever the backend
+func (k *KubeUtil) Drift(
+	ctx context.Context,
+	conf *KubeConfig,
+	customer int) (*DriftReport, error) {
+
+	k._startTime = time.Now()
+	k._ctx = ctx
+	if validConf := conf.New(*conf); validConf != nil {
+		return nil, k.respondWithError("Bad config", validConf)
+	}
+	k._config = conf
+	if k._client == nil || k._mapper == nil {
+		if err := k.newClient(); err != nil {
+			return nil, k.respondWithError("drift", err)
+		}
+	}
+
+	report, err := k.drift(customer)
+	if err != nil {
+		k._error = err.Error()
+		return nil, k.respondWithError("drift", err)
+	}
+	return report, nil
+}
+
+// drift compares each stored manifest with its object then lists the
+// customer objects without a stored manifest
+func (k *KubeUtil) drift(customer int) (*DriftReport, error) {
+	report := &DriftReport{CustomerID: customer}
+	stored := map[driftKey]bool{}
+	listed := map[driftKey]bool{} // kinds and namespaces, without names
+
+	// the customer directory is named as configured, e.g. 0042 for 42
+	customerDir := strings.Split(k._config.GetManifestDirectory(), "/")[0]
+	if id, err := strconv.Atoi(customerDir); err != nil || id != customer {
+		return nil, errors.New("Manifest directory not of customer: " +
+			k._config.GetManifestDirectory())
+	}
+	dir, _ := filepath.Abs(filepath.Join(manifestLocation, customerDir))
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".yaml" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		obj, err := storedObject(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		normalizeSecret(obj)
+		ri, err := k.resource(obj)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		key := driftKey{obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName()}
+		stored[key] = true
+		listed[driftKey{gvk: key.gvk, namespace: key.namespace}] = true
+
+		resource := DriftResource{Kind: obj.GetKind(), Name: obj.GetName(),
+			Namespace: obj.GetNamespace(), File: path}
+		current, err := ri.Get(k._ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			resource.Change = DriftRemoved
+			report.Resources = append(report.Resources, resource)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if fields := driftFields("", obj.Object, current.Object); len(fields) > 0 {
+			resource.Change = DriftChanged
+			resource.Fields = fields
+			report.Resources = append(report.Resources, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	selector := fmt.Sprintf("CustomerID=%v", customer)
+	for kind := range listed {
+		template := &unstructured.Unstructured{}
+		template.SetGroupVersionKind(kind.gvk)
+		template.SetNamespace(kind.namespace)
+		ri, err := k.resource(template)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := ri.List(k._ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			key := driftKey{kind.gvk, item.GetNamespace(), item.GetName()}
+			if stored[key] {
+				continue
+			}
+			report.Resources = append(report.Resources, DriftResource{
+				Change:    DriftAdded,
+				Kind:      item.GetKind(),
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+			})
+		}
+	}
+
+	sort.Slice(report.Resources, func(i, j int) bool {
+		a, b := report.Resources[i], report.Resources[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+// storedObject returns the object of the stored manifest
+func storedObject(data []byte) (*unstructured.Unstructured, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// normalizeSecret moves the stringData of a stored Secret to its data
+// base64 encoded as the API server does, stringData wins over data
+func normalizeSecret(obj *unstructured.Unstructured) {
+	if obj.GetKind() != "Secret" || obj.GroupVersionKind().Group != "" {
+		return
+	}
+	stringData, ok := obj.Object["stringData"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	data, ok := obj.Object["data"].(map[string]interface{})
+	if !ok {
+		data = map[string]interface{}{}
+	}
+	for key, value := range stringData {
+		data[key] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+	}
+	obj.Object["data"] = data
+	delete(obj.Object, "stringData")
+}
+
+// driftFields returns the paths of the stored fields differing from the
+// live fields, fields only set in the live object are ignored
+func driftFields(path string, stored, live interface{}) []string {
+	switch s := stored.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return []string{path}
+		}
+		var fields []string
+		for _, key := range sortedFieldKeys(s) {
+			if path == "" && key == "status" {
+				continue
+			}
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			value, ok := l[key]
+			if !ok {
+				fields = append(fields, field)
+				continue
+			}
+			fields = append(fields, driftFields(field, s[key], value)...)
+		}
+		return fields
+
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(s) {
+			return []string{path}
+		}
+		var fields []string
+		for i := range s {
+			fields = append(fields, driftFields(fmt.Sprintf("%s[%d]", path, i), s[i], l[i])...)
+		}
+		return fields
+	}
+
+	if !reflect.DeepEqual(stored, live) {
+		return []string{path}
+	}
+	return nil
+}
+
+// sortedFieldKeys returns the keys of the object in order
+func sortedFieldKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,197 @@
+package kubeutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultJobLogLines is the number of log lines kept per container if none
+// is set
+const defaultJobLogLines = 100
+
+// jobPodLabels are the labels selecting the pods of a job or workflow
+var jobPodLabels = map[string]string{
+	"Job":      "job-name",
+	"Workflow": "workflows.argoproj.io/workflow",
+}
+
+// JobOptions configures how RunJob waits for the job
+type JobOptions struct {
+	Timeout  time.Duration // no timeout but the config CommandTimeout if zero
+	Interval time.Duration // the wait interval if zero
+	LogLines int64         // log lines kept per container, 100 if zero
+}
+
+// JobResult is the outcome of a job or workflow run by RunJob
+type JobResult struct {
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Succeeded bool              `json:"succeeded"`
+	ExitCode  int               `json:"exitCode"` // -1 if failed without one
+	Message   string            `json:"message,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Logs      map[string]string `json:"logs,omitempty"` // pod/container on failure
+	LogsError string            `json:"logsError,omitempty"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+}
+
+// SetClientset sets the clientset used to get pod logs, otherwise it is
+// created from the kubeconfig context
+func (k *KubeUtil) SetClientset(clientset kubernetes.Interface) {
+	k._clientset = clientset
+}
+
+// RunJob creates the manifest job or Argo workflow, waits for it to finish
+// and returns its exit status, with the logs of its pods if it failed
+// The manifest must set the object name, it is saved as for create
+// The completion is also reported to the completion handler and sender
+func (k *KubeUtil) RunJob(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	manifest []byte,
+	opts JobOptions) (*JobResult, error) {
+
+	started := time.Now()
+	obj, err := storedObject(manifest)
+	if err != nil {
+		return nil, k.respondWithError("Failed to initialize", err)
+	}
+	if _, ok := jobPodLabels[obj.GetKind()]; !ok {
+		return nil, k.respondWithError("Failed to initialize",
+			errors.New("RunJob not supported for kind: "+obj.GetKind()))
+	}
+	if obj.GetName() == "" {
+		return nil, k.respondWithError("Failed to initialize",
+			errors.New("RunJob requires the manifest name"))
+	}
+
+	runCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if opts.Interval > 0 {
+		interval := k._waitInterval
+		k._waitInterval = opts.Interval
+		defer func() { k._waitInterval = interval }()
+	}
+
+	if err := k.ExecWithContext(runCtx, conf, user, kuCreate, manifest, obj.GetName()); err != nil {
+		return nil, err
+	}
+
+	event, err := k.Wait(runCtx, conf, user, manifest)
+	if event == nil {
+		return nil, err
+	}
+	result := &JobResult{
+		Kind:      event.Kind,
+		Name:      event.Name,
+		Namespace: event.Namespace,
+		Succeeded: event.Succeeded,
+		Message:   event.Message,
+		Error:     event.Error,
+		Started:   started,
+		Finished:  event.Finished,
+	}
+	if !result.Succeeded {
+		// the logs are still collected once the run timed out
+		k._ctx = ctx
+		if logsErr := k.jobLogs(result, opts.LogLines); logsErr != nil {
+			result.LogsError = logsErr.Error()
+		}
+	}
+	return result, err
+}
+
+// jobLogs sets the exit code and the logs of the containers of the job pods
+// that did not succeed
+func (k *KubeUtil) jobLogs(result *JobResult, lines int64) error {
+	if lines <= 0 {
+		lines = defaultJobLogLines
+	}
+	result.ExitCode = -1
+
+	selector := fmt.Sprintf("%s=%s", jobPodLabels[result.Kind], result.Name)
+	list, err := k._client.Resource(podsResource).Namespace(result.Namespace).
+		List(k._ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	if k._clientset == nil {
+		if err := k.newClientset(); err != nil {
+			return err
+		}
+	}
+
+	result.Logs = map[string]string{}
+	for _, pod := range list.Items {
+		if nestedString(pod.Object, "status", "phase") == "Succeeded" {
+			continue
+		}
+		for _, container := range podContainers(pod.Object) {
+			if code := containerExitCode(pod.Object, container); code != 0 && result.ExitCode == -1 {
+				result.ExitCode = code
+			}
+			data, err := k._clientset.CoreV1().Pods(pod.GetNamespace()).
+				GetLogs(pod.GetName(), &corev1.PodLogOptions{Container: container, TailLines: &lines}).
+				DoRaw(k._ctx)
+			if err != nil {
+				return err
+			}
+			result.Logs[pod.GetName()+"/"+container] = k.redactResult(string(data))
+		}
+	}
+	return nil
+}
+
+// newClientset creates the clientset from the kubeconfig context
+func (k *KubeUtil) newClientset() error {
+	restConfig, err := k.restConfig()
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	k._clientset = clientset
+	return nil
+}
+
+// podContainers returns the container names of the pod spec
+func podContainers(obj map[string]interface{}) []string {
+	var names []string
+	containers, _, _ := unstructured.NestedSlice(obj, "spec", "containers")
+	for _, c := range containers {
+		if container, ok := c.(map[string]interface{}); ok {
+			names = append(names, nestedString(container, "name"))
+		}
+	}
+	return names
+}
+
+// containerExitCode returns the exit code of the terminated container, or 0
+func containerExitCode(obj map[string]interface{}, name string) int {
+	statuses, _, _ := unstructured.NestedSlice(obj, "status", "containerStatuses")
+	for _, s := range statuses {
+		status, ok := s.(map[string]interface{})
+		if !ok || nestedString(status, "name") != name {
+			continue
+		}
+		code, _, _ := unstructured.NestedInt64(status, "state", "terminated", "exitCode")
+		return int(code)
+	}
+	return 0
+}
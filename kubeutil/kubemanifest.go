@@ -0,0 +1,206 @@
+package kubeutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxManifestSize limits the size of a manifest read from a URL
+const maxManifestSize = 10 << 20
+
+// Formats of manifests, detected by DetectManifestFormat
+const (
+	ManifestJSON = "json"
+	ManifestYAML = "yaml"
+)
+
+// Errors wrapped by the error returned for a manifest that cannot be parsed
+// or does not identify its object
+var (
+	ErrManifestFormat = errors.New("Manifest is neither a JSON nor a YAML object")
+	ErrManifestKind   = errors.New("Manifest missing kind")
+	ErrManifestName   = errors.New("Manifest missing metadata.name")
+)
+
+// ManifestSource provides the location of a manifest, exactly one of
+// URL, FS with Path, or Raw is expected to be set
+type ManifestSource struct {
+	// URL is an http or https location of the manifest
+	URL string
+
+	// Checksum is the hex sha256 of the manifest at URL, optionally
+	// prefixed with "sha256:", the manifest is rejected on mismatch
+	// It is required unless SkipChecksum is set
+	Checksum string
+
+	// SkipChecksum loads the manifest at URL without verifying it, for
+	// trusted locations only
+	SkipChecksum bool
+
+	// FS and Path locate the manifest in a file system such as embed.FS
+	FS   fs.FS
+	Path string
+
+	// Raw is the manifest itself
+	Raw []byte
+}
+
+// Load returns the manifest from the source
+func (s ManifestSource) Load(ctx context.Context) ([]byte, error) {
+	switch {
+	case s.URL != "":
+		return s.loadURL(ctx)
+	case s.FS != nil:
+		if s.Path == "" {
+			return nil, errors.New("Manifest FS requires a path")
+		}
+		return fs.ReadFile(s.FS, s.Path)
+	case s.Raw != nil:
+		return s.Raw, nil
+	default:
+		return nil, errors.New("Manifest source is empty")
+	}
+}
+
+func (s ManifestSource) loadURL(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+		return nil, errors.New("Unsupported manifest URL: " + s.URL)
+	}
+	if s.Checksum == "" && !s.SkipChecksum {
+		return nil, errors.New("Manifest URL requires a checksum: " + s.URL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get manifest %s: %s", s.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxManifestSize {
+		return nil, errors.New("Manifest too large: " + s.URL)
+	}
+
+	if s.Checksum != "" {
+		sum := sha256.Sum256(data)
+		expected := strings.ToLower(strings.TrimPrefix(s.Checksum, "sha256:"))
+		if hex.EncodeToString(sum[:]) != expected {
+			return nil, errors.New("Manifest checksum mismatch: " + s.URL)
+		}
+	}
+	return data, nil
+}
+
+// ExecSourceWithContext loads the manifest from the source and executes
+// the command as ExecWithContext does
+func (k *KubeUtil) ExecSourceWithContext(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	cmd string,
+	source ManifestSource,
+	filename string) error {
+
+	manifest, err := source.Load(ctx)
+	if err != nil {
+		k._error = err.Error()
+		return err
+	}
+	return k.ExecWithContext(ctx, conf, user, cmd, manifest, filename)
+}
+
+// DetectManifestFormat returns ManifestJSON if the manifest is a JSON
+// object, otherwise ManifestYAML
+func DetectManifestFormat(manifest []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(manifest), []byte("{")) {
+		return ManifestJSON
+	}
+	return ManifestYAML
+}
+
+// NormalizeManifest returns the JSON or YAML manifest in the canonical form
+// it is stored and hashed in, YAML with the keys sorted
+func NormalizeManifest(manifest []byte) ([]byte, error) {
+	parsed, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(parsed)
+}
+
+// parseManifest returns the JSON or YAML manifest as decoded by yaml, an
+// error if it does not have a kind and metadata.name
+func parseManifest(manifest []byte) (map[string]interface{}, error) {
+	parsed := make(map[string]interface{})
+	if DetectManifestFormat(manifest) == ManifestJSON {
+		decoder := json.NewDecoder(bytes.NewReader(manifest))
+		decoder.UseNumber()
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrManifestFormat, err)
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, fmt.Errorf("%w: data after JSON object", ErrManifestFormat)
+		}
+		for key, value := range obj {
+			parsed[key] = yamlValue(value)
+		}
+	} else if err := yaml.Unmarshal(manifest, &parsed); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrManifestFormat, err)
+	}
+
+	if kind, _ := parsed["kind"].(string); kind == "" {
+		return nil, ErrManifestKind
+	}
+	metadata, _ := parsed["metadata"].(map[interface{}]interface{})
+	if name, _ := metadata["name"].(string); name == "" {
+		return nil, ErrManifestName
+	}
+	return parsed, nil
+}
+
+// yamlValue returns the JSON value as yaml decodes it, objects as
+// map[interface{}]interface{} and numbers as int or float64
+func yamlValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[key] = yamlValue(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlValue(v[i])
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
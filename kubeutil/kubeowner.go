@@ -0,0 +1,158 @@
+package kubeutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Annotations recording the owner of applied objects
+const (
+	AnnotationOwner        = "go-core.pavedroad.io/owner"
+	AnnotationVersion      = "go-core.pavedroad.io/version"
+	AnnotationManifestHash = "go-core.pavedroad.io/manifest-hash"
+)
+
+// modulePath is the module recorded in the version annotation
+const modulePath = "github.com/pavedroad-io/go-core/kubeutil"
+
+// ErrNotOwner is wrapped by the error returned when modifying an object
+// owned by another customer, or by none, without force
+var ErrNotOwner = errors.New("Object not owned by customer")
+
+// SetForce allows apply and delete of objects not owned by the customer,
+// the objects applied are adopted by the customer
+func (k *KubeUtil) SetForce(force bool) {
+	k._force = force
+}
+
+// goCoreVersion returns the version of this module in the binary
+func goCoreVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			return info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				return dep.Version
+			}
+		}
+	}
+	return "(devel)"
+}
+
+// annotateManifest records the owner, version and hash of the labeled
+// manifest in its annotations
+func (k *KubeUtil) annotateManifest() error {
+	metadata := k._manifest["metadata"].(map[interface{}]interface{})
+	annotations, ok := metadata["annotations"].(map[interface{}]interface{})
+	if !ok {
+		annotations = make(map[interface{}]interface{})
+		metadata["annotations"] = annotations
+	}
+
+	// hashed without the annotations of a previous apply
+	delete(annotations, AnnotationOwner)
+	delete(annotations, AnnotationVersion)
+	delete(annotations, AnnotationManifestHash)
+	data, err := yaml.Marshal(&k._manifest)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+
+	annotations[AnnotationOwner] = strconv.Itoa(k._user.CustomerID)
+	annotations[AnnotationVersion] = goCoreVersion()
+	annotations[AnnotationManifestHash] = hex.EncodeToString(sum[:])
+	return nil
+}
+
+// checkOwner returns an error if the command modifies an existing object
+// not owned by the customer, unless forced
+func (k *KubeUtil) checkOwner() error {
+	switch k._command {
+	case kuApply, kuDelete:
+	default:
+		return nil
+	}
+	if k._force {
+		return nil
+	}
+
+	annotations, found, err := k.currentAnnotations()
+	if err != nil || !found {
+		return err
+	}
+
+	owner := annotations[AnnotationOwner]
+	if owner == strconv.Itoa(k._user.CustomerID) {
+		return nil
+	}
+	if owner == "" {
+		owner = "none"
+	}
+	return fmt.Errorf("%w: %s %s is owned by %s", ErrNotOwner,
+		k._manifest["kind"], k.manifestName(), owner)
+}
+
+// manifestName returns the metadata name of the manifest
+func (k *KubeUtil) manifestName() string {
+	metadata, _ := k._manifest["metadata"].(map[interface{}]interface{})
+	name, _ := metadata["name"].(string)
+	return name
+}
+
+// currentAnnotations returns the annotations of the existing object
+func (k *KubeUtil) currentAnnotations() (map[string]string, bool, error) {
+	current, found, err := k.currentObject()
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return current.GetAnnotations(), true, nil
+}
+
+// currentObject returns the existing object of the manifest
+func (k *KubeUtil) currentObject() (*unstructured.Unstructured, bool, error) {
+	if k._config.GetBackend() == BackendClientGo {
+		obj, ri, err := k.clientResource()
+		if err != nil {
+			return nil, false, err
+		}
+		current, err := ri.Get(k._ctx, obj.GetName(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		return current, true, nil
+	}
+
+	if err := k.checkKubectl(); err != nil {
+		return nil, false, err
+	}
+	cmd := []string{"--context", k._config.GetKubectx(),
+		"--namespace", k._config.GetNamespace(),
+		"get", fmt.Sprint(k._manifest["kind"]), k.manifestName(),
+		"--ignore-not-found", "-o", "json"}
+	data, err := k.kubectl(cmd...).Output()
+	if err != nil {
+		return nil, false, k.kubectlError(err)
+	}
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+
+	current := &unstructured.Unstructured{}
+	if err := current.UnmarshalJSON(data); err != nil {
+		return nil, false, err
+	}
+	return current, true, nil
+}
@@ -0,0 +1,291 @@
+package kubeutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+)
+
+// States of a queued job
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Queue errors
+var (
+	ErrJobNotFound    = errors.New("Job not found")
+	ErrJobNotFinished = errors.New("Job not finished")
+	ErrQueueClosed    = errors.New("Queue closed")
+)
+
+// JobStatus is the state and timing of a queued job
+type JobStatus struct {
+	ID         string    `json:"id"`
+	Command    string    `json:"command"`
+	CustomerID int       `json:"customerID"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	Submitted  time.Time `json:"submitted"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+}
+
+// Done returns true once the job succeeded, failed or was cancelled
+func (s JobStatus) Done() bool {
+	return s.State != JobQueued && s.State != JobRunning
+}
+
+// queueJob provides a job and its outcome
+type queueJob struct {
+	status   JobStatus
+	result   ExecResult
+	ctx      context.Context
+	cancel   context.CancelFunc
+	conf     KubeConfig
+	user     KubeUser
+	manifest []byte
+}
+
+// Queue executes commands asynchronously in order of submission with
+// bounded concurrency, each with its own KubeUtil, and keeps their status
+// and results until removed
+type Queue struct {
+	mutex       sync.Mutex
+	jobs        map[string]*queueJob
+	pending     []*queueJob
+	running     int
+	concurrency int
+	client      dynamic.Interface
+	mapper      meta.RESTMapper
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// NewQueue returns a queue running at most concurrency jobs at a time
+func NewQueue(concurrency int) *Queue {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Queue{
+		jobs:        make(map[string]*queueJob),
+		concurrency: concurrency,
+	}
+}
+
+// SetClient sets the dynamic client and REST mapper of the jobs run with
+// the client-go backend
+func (q *Queue) SetClient(client dynamic.Interface, mapper meta.RESTMapper) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.client = client
+	q.mapper = mapper
+}
+
+// Submit queues the command for the manifest and returns the job ID
+// The job keeps the context values but not its cancellation, so it
+// outlives the request submitting it, use Cancel to stop it
+// The manifest is saved under its metadata name
+func (q *Queue) Submit(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	cmd string,
+	manifest []byte) (string, error) {
+
+	obj, err := storedObject(manifest)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &queueJob{
+		ctx:      jobCtx,
+		cancel:   cancel,
+		conf:     *conf,
+		user:     user,
+		manifest: manifest,
+		status: JobStatus{
+			ID:         hex.EncodeToString(id),
+			Command:    cmd,
+			CustomerID: user.CustomerID,
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+			State:      JobQueued,
+			Submitted:  time.Now(),
+		},
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		cancel()
+		return "", ErrQueueClosed
+	}
+	q.jobs[job.status.ID] = job
+	q.pending = append(q.pending, job)
+	q.dispatch()
+	return job.status.ID, nil
+}
+
+// dispatch starts the pending jobs while below the concurrency, called
+// with the mutex held
+func (q *Queue) dispatch() {
+	for q.running < q.concurrency && len(q.pending) > 0 {
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		q.running++
+		q.wg.Add(1)
+		job.status.State = JobRunning
+		job.status.Started = time.Now()
+		var k KubeUtil
+		if q.client != nil {
+			k.SetClient(q.client, q.mapper)
+		}
+		go q.run(job, &k)
+	}
+}
+
+// run executes the job then starts the next pending job
+func (q *Queue) run(job *queueJob, k *KubeUtil) {
+	defer q.wg.Done()
+	defer job.cancel()
+	err := k.ExecWithContext(job.ctx, &job.conf, job.user, job.status.Command,
+		job.manifest, job.status.Name)
+	if err == nil && job.ctx.Err() != nil {
+		err = job.ctx.Err()
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.finish(job, k.Result(), err)
+	q.running--
+	q.dispatch()
+}
+
+// finish sets the outcome of the job, called with the mutex held
+func (q *Queue) finish(job *queueJob, result ExecResult, err error) {
+	job.manifest = nil
+	job.result = result
+	job.status.Finished = time.Now()
+	switch {
+	case err == nil:
+		job.status.State = JobSucceeded
+	case errors.Is(err, context.Canceled):
+		job.status.State = JobCancelled
+		job.status.Error = err.Error()
+	default:
+		job.status.State = JobFailed
+		job.status.Error = err.Error()
+	}
+}
+
+// GetStatus returns the status of the job
+func (q *Queue) GetStatus(id string) (JobStatus, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return job.status, nil
+}
+
+// Results returns the outcome of the finished job
+func (q *Queue) Results(id string) (ExecResult, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return ExecResult{}, ErrJobNotFound
+	}
+	if !job.status.Done() {
+		return ExecResult{}, ErrJobNotFinished
+	}
+	return job.result, nil
+}
+
+// Jobs returns the status of all the jobs in order of submission
+func (q *Queue) Jobs() []JobStatus {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	jobs := make([]JobStatus, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, job.status)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Submitted.Before(jobs[j].Submitted)
+	})
+	return jobs
+}
+
+// Cancel cancels the job if queued or running
+func (q *Queue) Cancel(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	q.cancel(job)
+	return nil
+}
+
+// cancel cancels the job, a queued job is finished at once, called with
+// the mutex held
+func (q *Queue) cancel(job *queueJob) {
+	job.cancel()
+	if job.status.State != JobQueued {
+		return
+	}
+	for i, pending := range q.pending {
+		if pending == job {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+	q.finish(job, ExecResult{Command: job.status.Command}, job.ctx.Err())
+}
+
+// Remove forgets the finished job
+func (q *Queue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if !job.status.Done() {
+		return ErrJobNotFinished
+	}
+	delete(q.jobs, id)
+	return nil
+}
+
+// Close cancels the queued and running jobs and waits for them to finish,
+// jobs can no longer be submitted
+func (q *Queue) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	for _, job := range q.jobs {
+		q.cancel(job)
+	}
+	q.mutex.Unlock()
+	q.wg.Wait()
+}
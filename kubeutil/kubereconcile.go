@@ -0,0 +1,155 @@
+package kubeutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+)
+
+// Actions reported in reconcile events
+const (
+	// ReconcileApplied is a changed resource applied from its stored manifest
+	ReconcileApplied = "applied"
+
+	// ReconcileCreated is a removed resource created from its stored manifest
+	ReconcileCreated = "created"
+
+	// ReconcileIgnored is an added resource left in place
+	ReconcileIgnored = "ignored"
+
+	// ReconcileFailed is a resource or drift check that failed to reconcile
+	ReconcileFailed = "failed"
+)
+
+// EventSender sends reconcile events, met by the logger package Sender
+type EventSender interface {
+	Send(topic, key string, value []byte) error
+}
+
+// ReconcileEvent describes the action taken for a drifted resource
+type ReconcileEvent struct {
+	Time       time.Time `json:"time"`
+	CustomerID int       `json:"customerID"`
+	Action     string    `json:"action"`
+	Change     string    `json:"change,omitempty"`
+	Kind       string    `json:"kind,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Fields     []string  `json:"fields,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Reconciler re-applies the stored manifests of a customer that drifted
+// from the cluster at each interval
+// Added resources are reported but never deleted
+type Reconciler struct {
+	Config   *KubeConfig
+	User     KubeUser
+	Interval time.Duration
+	Sender   EventSender // events not sent if nil
+	Topic    string      // the sender default topic if empty
+	kube     KubeUtil
+}
+
+// SetClient sets the dynamic client and REST mapper used for drift checks
+// and client-go commands
+func (r *Reconciler) SetClient(client dynamic.Interface, mapper meta.RESTMapper) {
+	r.kube.SetClient(client, mapper)
+}
+
+// Run reconciles at each interval until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) error {
+	if r.Interval <= 0 {
+		return errors.New("Reconciler interval must be greater than zero")
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		r.Reconcile(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile applies the drifted resources once and returns the events sent
+func (r *Reconciler) Reconcile(ctx context.Context) []ReconcileEvent {
+	events := []ReconcileEvent{}
+	report, err := r.kube.Drift(ctx, r.Config, r.User.CustomerID)
+	if err != nil {
+		return append(events, r.emit(ReconcileEvent{Action: ReconcileFailed,
+			Error: err.Error()}))
+	}
+
+	for _, resource := range report.Resources {
+		event := ReconcileEvent{
+			Change:    resource.Change,
+			Kind:      resource.Kind,
+			Name:      resource.Name,
+			Namespace: resource.Namespace,
+			Fields:    resource.Fields,
+		}
+		switch resource.Change {
+		case DriftAdded:
+			event.Action = ReconcileIgnored
+		case DriftChanged:
+			event.Action = ReconcileApplied
+			err = r.restore(ctx, resource.File, kuApply)
+		case DriftRemoved:
+			event.Action = ReconcileCreated
+			err = r.restore(ctx, resource.File, kuCreate)
+		}
+		if err != nil {
+			event.Action = ReconcileFailed
+			event.Error = err.Error()
+			err = nil
+		}
+		events = append(events, r.emit(event))
+	}
+	return events
+}
+
+// restore executes the command with the stored manifest file, saved again
+// in place
+func (r *Reconciler) restore(ctx context.Context, file, cmd string) error {
+	manifest, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// the manifest directory is the customerNumber/resourceType of the file
+	base, _ := filepath.Abs(manifestLocation)
+	rel, err := filepath.Rel(base, filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	conf := *r.Config
+	conf.ManifestDirectory = filepath.ToSlash(rel)
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+	return r.kube.ExecWithContext(ctx, &conf, r.User, cmd, manifest, name)
+}
+
+// emit timestamps the event and sends it if there is a sender
+func (r *Reconciler) emit(event ReconcileEvent) ReconcileEvent {
+	event.Time = time.Now().UTC()
+	event.CustomerID = r.User.CustomerID
+	if r.Sender == nil {
+		return event
+	}
+	if value, err := json.Marshal(event); err == nil {
+		r.Sender.Send(r.Topic, strconv.Itoa(r.User.CustomerID), value)
+	}
+	return event
+}
@@ -0,0 +1,162 @@
+package kubeutil
+
+import (
+	"encoding/json"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// RedactedValue replaces the data values of secrets
+const RedactedValue = "REDACTED"
+
+// secretFields are the secret fields holding its values
+var secretFields = []string{"data", "stringData"}
+
+// lastAppliedAnnotation is set by kubectl apply to the applied manifest,
+// including the secret values
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// secretPaths are the diff paths of the secret values
+var secretPaths = append(secretFields, "metadata.annotations."+lastAppliedAnnotation)
+
+// SetShowSecrets shows the data values of secrets in results, diffs and
+// watch events, they are redacted by default so they are never logged
+// Saved manifests are not redacted as the Reconciler applies them again
+func (k *KubeUtil) SetShowSecrets(show bool) {
+	k._showSecrets = show
+}
+
+// redactResult returns the command output with secret values redacted
+// unless they are shown
+func (k *KubeUtil) redactResult(out string) string {
+	if k._showSecrets {
+		return out
+	}
+	return redactOutput(out)
+}
+
+// redactOutput returns the yaml or json output with secret values redacted,
+// output that is not an object is returned unchanged
+func redactOutput(out string) string {
+	if !strings.Contains(out, "Secret") {
+		return out
+	}
+	var obj interface{}
+	if err := yaml.Unmarshal([]byte(out), &obj); err != nil || !redactSecrets(obj) {
+		return out
+	}
+	marshal := yaml.Marshal
+	if strings.HasPrefix(strings.TrimSpace(out), "{") {
+		marshal = func(obj interface{}) ([]byte, error) {
+			return json.MarshalIndent(obj, "", "    ")
+		}
+	}
+	data, err := marshal(obj)
+	if err != nil {
+		return RedactedValue
+	}
+	return string(data)
+}
+
+// redactSecrets replaces in place the values of the secrets found in the
+// object, including list items, returns true if a value was replaced
+func redactSecrets(obj interface{}) bool {
+	redacted := false
+	switch o := obj.(type) {
+	case map[string]interface{}:
+		if kind, _ := o["kind"].(string); kind == "Secret" {
+			for _, field := range secretFields {
+				redacted = redactValues(o[field]) || redacted
+			}
+			redacted = redactLastApplied(o["metadata"]) || redacted
+		}
+		for _, value := range o {
+			redacted = redactSecrets(value) || redacted
+		}
+	case map[interface{}]interface{}:
+		if kind, _ := o["kind"].(string); kind == "Secret" {
+			for _, field := range secretFields {
+				redacted = redactValues(o[field]) || redacted
+			}
+			redacted = redactLastApplied(o["metadata"]) || redacted
+		}
+		for _, value := range o {
+			redacted = redactSecrets(value) || redacted
+		}
+	case []interface{}:
+		for _, value := range o {
+			redacted = redactSecrets(value) || redacted
+		}
+	}
+	return redacted
+}
+
+// redactValues replaces the values of the secret field
+func redactValues(values interface{}) bool {
+	redacted := false
+	switch v := values.(type) {
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = RedactedValue
+			redacted = true
+		}
+	case map[interface{}]interface{}:
+		for key := range v {
+			v[key] = RedactedValue
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+// redactLastApplied replaces the last applied configuration annotation
+// of the metadata
+func redactLastApplied(metadata interface{}) bool {
+	var annotations interface{}
+	switch m := metadata.(type) {
+	case map[string]interface{}:
+		annotations = m["annotations"]
+	case map[interface{}]interface{}:
+		annotations = m["annotations"]
+	}
+	switch a := annotations.(type) {
+	case map[string]interface{}:
+		if _, ok := a[lastAppliedAnnotation]; ok {
+			a[lastAppliedAnnotation] = RedactedValue
+			return true
+		}
+	case map[interface{}]interface{}:
+		if _, ok := a[lastAppliedAnnotation]; ok {
+			a[lastAppliedAnnotation] = RedactedValue
+			return true
+		}
+	}
+	return false
+}
+
+// redactDiff replaces the old and new values of the secret fields changed,
+// and of the last applied configuration annotation
+func redactDiff(diff *ManifestDiff) {
+	for i, change := range diff.Changes {
+		switch change.Path {
+		case "metadata":
+			redactLastApplied(change.Old)
+			redactLastApplied(change.New)
+		case "metadata.annotations":
+			redactLastApplied(map[string]interface{}{"annotations": change.Old})
+			redactLastApplied(map[string]interface{}{"annotations": change.New})
+		}
+		for _, field := range secretPaths {
+			if change.Path != field && !strings.HasPrefix(change.Path, field+".") {
+				continue
+			}
+			if change.Old != nil {
+				diff.Changes[i].Old = RedactedValue
+			}
+			if change.New != nil {
+				diff.Changes[i].New = RedactedValue
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package kubeutil
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ResultYAML is the format of results by default
+	ResultYAML = "yaml"
+	// ResultJSON is the format of results once SetJSONOutput is set
+	ResultJSON = "json"
+)
+
+// Result provides the object returned by the server for the last command
+// parsed from its output, the object fields are empty if the output is not
+// an object, e.g. for delete
+type Result struct {
+	Kind       string            `json:"kind,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	UID        string            `json:"uid,omitempty"`
+	Conditions []ResultCondition `json:"conditions,omitempty"`
+	Items      []Result          `json:"items,omitempty"` // set for lists
+	Raw        string            `json:"raw,omitempty"`   // secrets redacted
+	Format     string            `json:"format"`
+	Duration   time.Duration     `json:"duration"`
+	ExitStatus int               `json:"exitStatus"` // -1 if failed without one
+
+	// Object is the parsed output, set with JSON output only
+	Object *unstructured.Unstructured `json:"-"`
+}
+
+// ResultCondition provides a status condition of the object
+type ResultCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// SetJSONOutput requests JSON in place of yaml output, the Result object
+// is then also unmarshalled into an unstructured object
+func (k *KubeUtil) SetJSONOutput(json bool) {
+	k._jsonOutput = json
+}
+
+// outputFormat returns the format of the command output
+func (k *KubeUtil) outputFormat() string {
+	if k._jsonOutput {
+		return ResultJSON
+	}
+	return ResultYAML
+}
+
+// marshalOutput returns the object encoded in the output format
+func (k *KubeUtil) marshalOutput(obj interface{}) ([]byte, error) {
+	if k._jsonOutput {
+		return json.MarshalIndent(obj, "", "    ")
+	}
+	return yaml.Marshal(obj)
+}
+
+// setExitStatus records the exit status of kubectl, -1 if it did not run
+func (k *KubeUtil) setExitStatus(err error) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		k._exitStatus = 0
+	case errors.As(err, &exitErr):
+		k._exitStatus = exitErr.ExitCode()
+	default:
+		k._exitStatus = -1
+	}
+}
+
+// ParsedResult returns the output of the last command parsed into a
+// Result, or the error output if the command failed
+// An error is returned if JSON output was requested and cannot be parsed
+func (k *KubeUtil) ParsedResult() (Result, error) {
+	raw := k._result
+	if k._error != "" {
+		raw = k._error
+	}
+	result, err := parseResult(raw, k.outputFormat())
+	result.Duration = k._endTime.Sub(k._startTime)
+	if k._endTime.IsZero() {
+		result.Duration = 0
+	}
+	result.ExitStatus = k._exitStatus
+	return result, err
+}
+
+// parseResult returns the result of the output in the format
+func parseResult(raw, format string) (Result, error) {
+	result := Result{Raw: raw, Format: format}
+	var obj map[string]interface{}
+	if format == ResultJSON {
+		if !strings.HasPrefix(strings.TrimSpace(raw), "{") {
+			return result, nil
+		}
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			return result, err
+		}
+		result.Object = &unstructured.Unstructured{Object: obj}
+	} else if err := yaml.Unmarshal([]byte(raw), &obj); err != nil {
+		// plain text output such as delete messages is not an object
+		return result, nil
+	}
+	result.setObject(obj)
+	return result, nil
+}
+
+// setObject sets the object fields of the result including list items
+func (r *Result) setObject(obj map[string]interface{}) {
+	u := unstructured.Unstructured{Object: obj}
+	r.Kind = u.GetKind()
+	r.Name = u.GetName()
+	r.Namespace = u.GetNamespace()
+	r.UID = string(u.GetUID())
+
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		r.Conditions = append(r.Conditions, ResultCondition{
+			Type:    stringField(condition, "type"),
+			Status:  stringField(condition, "status"),
+			Reason:  stringField(condition, "reason"),
+			Message: stringField(condition, "message"),
+		})
+	}
+
+	items, _, _ := unstructured.NestedSlice(obj, "items")
+	for _, i := range items {
+		item, ok := i.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var itemResult Result
+		itemResult.setObject(item)
+		r.Items = append(r.Items, itemResult)
+	}
+}
+
+// stringField returns the string value of the field, empty if not a string
+func stringField(obj map[string]interface{}, field string) string {
+	value, _ := obj[field].(string)
+	return value
+}
@@ -0,0 +1,222 @@
+package kubeutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resources whose readiness is reported by Status
+var (
+	deploymentsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	podsResource        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	jobsResource        = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+)
+
+// ResourceStatus is the readiness of a customer resource
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Ready     bool   `json:"ready"`
+	Message   string `json:"message,omitempty"`
+}
+
+// StatusCount is the number of resources of a kind and how many are ready
+type StatusCount struct {
+	Total int `json:"total"`
+	Ready int `json:"ready"`
+}
+
+// CustomerStatus summarizes the readiness of the resources of a customer
+type CustomerStatus struct {
+	CustomerID  int              `json:"customerID"`
+	Ready       bool             `json:"ready"` // all resources ready
+	Deployments StatusCount      `json:"deployments"`
+	Pods        StatusCount      `json:"pods"`
+	Jobs        StatusCount      `json:"jobs"`
+	Resources   []ResourceStatus `json:"resources"`
+}
+
+// Status returns the readiness of the deployments, pods and jobs labeled
+// for the customer, in the config namespace or all namespaces if none
+// Deployments are ready when all replicas of the current generation are
+// updated and available, pods when ready or succeeded and jobs when complete
+// Pods selected by the customer deployments are included without the label
+// Status always uses the client-go dynamic client whatever the backend
+func (k *KubeUtil) Status(
+	ctx context.Context,
+	conf *KubeConfig,
+	customer int) (*CustomerStatus, error) {
+
+	k._startTime = time.Now()
+	k._ctx = ctx
+	if validConf := conf.New(*conf); validConf != nil {
+		return nil, k.respondWithError("Bad config", validConf)
+	}
+	k._config = conf
+	if k._client == nil {
+		if err := k.newClient(); err != nil {
+			return nil, k.respondWithError("status", err)
+		}
+	}
+
+	status, err := k.status(customer)
+	if err != nil {
+		k._error = err.Error()
+		return nil, k.respondWithError("status", err)
+	}
+	return status, nil
+}
+
+// status lists the customer resources of each kind and counts the ready ones
+func (k *KubeUtil) status(customer int) (*CustomerStatus, error) {
+	status := &CustomerStatus{CustomerID: customer, Resources: []ResourceStatus{}}
+	kinds := []struct {
+		gvr   schema.GroupVersionResource
+		count *StatusCount
+		ready func(obj map[string]interface{}) (bool, string)
+	}{
+		{deploymentsResource, &status.Deployments, deploymentReady},
+		{podsResource, &status.Pods, podReady},
+		{jobsResource, &status.Jobs, jobReady},
+	}
+
+	selector := fmt.Sprintf("CustomerID=%v", customer)
+	var deployments []unstructured.Unstructured
+	for _, kind := range kinds {
+		list, err := k._client.Resource(kind.gvr).Namespace(k._config.GetNamespace()).
+			List(k._ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		items := list.Items
+		switch kind.gvr {
+		case deploymentsResource:
+			deployments = items
+		case podsResource:
+			if items, err = k.deploymentPods(deployments, items); err != nil {
+				return nil, err
+			}
+		}
+		for _, item := range items {
+			ready, message := kind.ready(item.Object)
+			kind.count.Total++
+			if ready {
+				kind.count.Ready++
+			}
+			status.Resources = append(status.Resources, ResourceStatus{
+				Kind:      item.GetKind(),
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+				Ready:     ready,
+				Message:   message,
+			})
+		}
+	}
+
+	status.Ready = status.Deployments.Ready == status.Deployments.Total &&
+		status.Pods.Ready == status.Pods.Total &&
+		status.Jobs.Ready == status.Jobs.Total
+	sort.SliceStable(status.Resources, func(i, j int) bool {
+		a, b := status.Resources[i], status.Resources[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return status, nil
+}
+
+// deploymentPods returns the pods with the pods selected by the deployments
+// added, the pod template labels may not include the customer label
+func (k *KubeUtil) deploymentPods(deployments,
+	pods []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	listed := map[string]bool{}
+	for _, pod := range pods {
+		listed[pod.GetNamespace()+"/"+pod.GetName()] = true
+	}
+	for _, deployment := range deployments {
+		raw, found, _ := unstructured.NestedMap(deployment.Object, "spec", "selector")
+		if !found {
+			continue
+		}
+		var labelSelector metav1.LabelSelector
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &labelSelector); err != nil {
+			return nil, err
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		if selector.Empty() {
+			// an empty selector would select all the pods
+			continue
+		}
+		list, err := k._client.Resource(podsResource).Namespace(deployment.GetNamespace()).
+			List(k._ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range list.Items {
+			if key := pod.GetNamespace() + "/" + pod.GetName(); !listed[key] {
+				listed[key] = true
+				pods = append(pods, pod)
+			}
+		}
+	}
+	return pods, nil
+}
+
+// deploymentReady returns true once the rollout of the current generation
+// is observed and all the desired replicas are updated and available
+func deploymentReady(obj map[string]interface{}) (bool, string) {
+	finished, succeeded, message := rolloutFinished(obj)
+	return finished && succeeded, message
+}
+
+// podReady returns true if the pod is ready or has succeeded
+func podReady(obj map[string]interface{}) (bool, string) {
+	phase := nestedString(obj, "status", "phase")
+	if phase == "Succeeded" {
+		return true, phase
+	}
+	return conditionTrue(obj, "Ready"), phase
+}
+
+// jobReady returns true if the job is complete
+func jobReady(obj map[string]interface{}) (bool, string) {
+	switch {
+	case conditionTrue(obj, "Complete"):
+		return true, "Complete"
+	case conditionTrue(obj, "Failed"):
+		return false, "Failed"
+	}
+	succeeded, _, _ := unstructured.NestedInt64(obj, "status", "succeeded")
+	completions, found, _ := unstructured.NestedInt64(obj, "spec", "completions")
+	if !found {
+		completions = 1
+	}
+	return false, fmt.Sprintf("%d/%d completions succeeded", succeeded, completions)
+}
+
+// conditionTrue returns true if the status condition of the type is True
+func conditionTrue(obj map[string]interface{}, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && nestedString(condition, "type") == conditionType {
+			return nestedString(condition, "status") == "True"
+		}
+	}
+	return false
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 )
 
 const (
@@ -28,6 +30,7 @@ const (
 	kuSet       = "set"
 	kuScale     = "scale"
 	kuWatch     = "watch"
+	kuWait      = "wait"
 )
 
 const (
@@ -35,19 +38,33 @@ const (
 )
 
 type KubeUtil struct {
-	_startTime        time.Time
-	_endTime          time.Time
-	_command          string
-	_manifestRaw      []byte
-	_manifest         map[string]interface{}
-	_fileName         string
-	_result           string
-	_error            string
-	_user             KubeUser
-	_config           *KubeConfig
-	_ctx              context.Context
-	_location         string
-	_additionalLabels []Label
+	_startTime         time.Time
+	_endTime           time.Time
+	_command           string
+	_manifestRaw       []byte
+	_manifest          map[string]interface{}
+	_fileName          string
+	_result            string
+	_error             string
+	_user              KubeUser
+	_config            *KubeConfig
+	_ctx               context.Context
+	_location          string
+	_additionalLabels  []Label
+	_client            dynamic.Interface
+	_mapper            meta.RESTMapper
+	_force             bool
+	_dryRun            bool
+	_diff              bool
+	_diffResult        *ManifestDiff
+	_waitInterval      time.Duration
+	_completionHandler CompletionHandler
+	_completionSender  EventSender
+	_completionTopic   string
+	_showSecrets       bool
+	_clientset         kubernetes.Interface
+	_jsonOutput        bool
+	_exitStatus        int
 }
 
 func (k *KubeUtil) ExecWithContext(
@@ -58,23 +75,45 @@ func (k *KubeUtil) ExecWithContext(
 	manifest []byte,
 	filename string) error {
 
-	k._ctx = ctx
-	if validConf := k._config.New(*conf); validConf != nil {
+	if validConf := conf.New(*conf); validConf != nil {
+		k._ctx = ctx
 		return k.respondWithError("Bad config", validConf)
 	}
 
+	// the timeout covers the command including kubectl children
+	if conf.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.CommandTimeout)
+		defer cancel()
+	}
+	k._ctx = ctx
+
 	if err := k.init(user, conf, cmd, manifest, filename); err != nil {
 		return k.respondWithError("Failed to initialize", err)
 	}
 
-	if err := k.checkAndSave(); err != nil {
-		return k.respondWithError("checkAndSave", err)
+	// commands run with a temporary copy of the manifest, it is stored
+	// once the command succeeds
+	if err := k.saveTemporary(); err != nil {
+		return k.respondWithError("saveTemporary", err)
+	}
+	defer os.Remove(k._location)
+
+	if err := k.checkOwner(); err != nil {
+		k._error = err.Error()
+		return k.respondWithError("checkOwner", err)
 	}
 
 	if err := k.execute(); err != nil {
 		return k.respondWithError("execute", err)
 	}
 
+	if err := k.storeManifest(); err != nil {
+		k._error = err.Error()
+		return k.respondWithError("storeManifest", err)
+	}
+
+	k._endTime = time.Now()
 	return (nil)
 }
 
@@ -98,6 +137,10 @@ func (k *KubeUtil) buildCommandOptions(cmd []string) []string {
 		cmd = append(cmd, "-f")
 		cmd = append(cmd, k._location)
 
+		if k._dryRun {
+			cmd = append(cmd, "--dry-run=server")
+		}
+
 	// Commands that create a list of resource types
 	case kuList:
 		// Add the command
@@ -120,65 +163,78 @@ func (k *KubeUtil) buildCommandOptions(cmd []string) []string {
 	switch k._command {
 	case kuApply, kuCreate, kuDescribe, kuExplain, kuExspose, kuGet, kuList, kuLogs, kuRollout, kuScale, kuWatch:
 		cmd = append(cmd, "-o")
-		cmd = append(cmd, "yaml")
+		cmd = append(cmd, k.outputFormat())
 
 	}
 	return cmd
 }
 
 func (k *KubeUtil) execute() error {
+	if k._diff {
+		return k.executeDiff()
+	}
+	if k._config.GetBackend() == BackendClientGo {
+		return k.executeClient()
+	}
+	return k.executeKubectl()
+}
+
+func (k *KubeUtil) executeKubectl() error {
+	if err := k.checkKubectl(); err != nil {
+		k._error = err.Error()
+		return err
+	}
+
 	var kubecmd = []string{}
 	kubecmd = k.buildCommandOptions(kubecmd)
 
-	debug := "kubectl " + strings.Join(kubecmd, " ")
+	path := k._config.GetKubectlPath()
+	debug := path + " " + strings.Join(kubecmd, " ")
 	fmt.Println(debug)
-	data, err := exec.Command("kubectl", kubecmd...).CombinedOutput()
+	data, stderr, err := k.kubectl(kubecmd...).outputs()
+	k.setExitStatus(err)
 	if err != nil {
-		k._error = string(data)
-		return err
+		k._error = k.redactResult(string(stderr))
+		return k.kubectlError(err)
 	}
-	k._result = string(data)
+	k._result = k.redactResult(string(data))
 	return nil
 }
 
 func (k *KubeUtil) respondWithError(where string, err error) error {
 	k._endTime = time.Now()
+	if k._exitStatus == 0 {
+		k._exitStatus = -1
+	}
 	fmt.Println(where, " : ", k._command, "failed in", k._endTime.Sub(k._startTime).String())
 	return err
 }
 
-func (k *KubeUtil) checkAndSave() error {
+// storedLocation returns the file the manifest of the customer is stored in
+func (k *KubeUtil) storedLocation() string {
 	saveLocation, _ := filepath.Abs(filepath.Join(manifestLocation, k._config.GetManifestDirectory()))
+	return filepath.Join(saveLocation, k._fileName+".yaml")
+}
 
-	if _, err := os.Stat(saveLocation); os.IsNotExist(err) {
-		os.MkdirAll(saveLocation, 0755)
-	} else if err != nil {
-		return err
+// storeManifest stores the manifest once applied or created and removes it
+// once deleted, dry runs, diffs and other commands leave it as is
+func (k *KubeUtil) storeManifest() error {
+	if k._dryRun || k._diff {
+		return nil
 	}
 
-	// Save the manifest
-	k._location = filepath.Join(saveLocation, k._fileName+".yaml")
-
-	if _, err := os.Stat(k._location); os.IsNotExist(err) {
-		f, err := os.Create(k._location)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		if _, err := f.Write(k._manifestRaw); err != nil {
-			return err
-		}
-	} else {
-		f, err := os.OpenFile(k._location, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-		if err != nil {
+	location := k.storedLocation()
+	switch k._command {
+	case kuApply, kuCreate:
+		if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
 			return err
 		}
-		defer f.Close()
-		if _, err := f.Write(k._manifestRaw); err != nil {
+		return os.WriteFile(location, k._manifestRaw, 0644)
+	case kuDelete:
+		if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	}
-
 	return nil
 }
 
@@ -187,20 +243,28 @@ func (k *KubeUtil) init(user KubeUser, conf *KubeConfig, cmd string, manifest []
 	k._command = cmd
 	k._manifestRaw = manifest
 	k._fileName = filename
-	k._manifest = make(map[string]interface{})
 	k._user = user
 	k._config = conf
 	k._additionalLabels = user.GenerateLables()
 
-	// Parse the manifest
-	err := yaml.Unmarshal([]byte(k._manifestRaw), &k._manifest)
+	// Parse the JSON or YAML manifest, stored as YAML
+	parsed, err := parseManifest(k._manifestRaw)
 	if err != nil {
+		k._manifest = make(map[string]interface{})
 		k._error = err.Error()
 		return err
 	}
+	k._manifest = parsed
 
 	k.LabelManifest()
 
+	if cmd == kuApply || cmd == kuCreate {
+		if err := k.annotateManifest(); err != nil {
+			k._error = err.Error()
+			return err
+		}
+	}
+
 	data, err := yaml.Marshal(&k._manifest)
 
 	if err != nil {
@@ -212,18 +276,22 @@ func (k *KubeUtil) init(user KubeUser, conf *KubeConfig, cmd string, manifest []
 
 	k._result = ""
 	k._error = ""
+	k._exitStatus = 0
+	k._endTime = time.Time{}
+	k._diffResult = nil
 	return nil
 }
 
 func (k *KubeUtil) LabelManifest() {
 	// Add lbels to the manifest if missing
-	_, ok := k._manifest["metadata"].(map[interface{}]interface{})["labels"]
+	metadata := k._manifest["metadata"].(map[interface{}]interface{})
+	labels, ok := metadata["labels"].(map[interface{}]interface{})
 	if !ok {
-		k._manifest["metadata"].(map[interface{}]interface{})["labels"] = make(map[string]string)
-
+		labels = make(map[interface{}]interface{})
+		metadata["labels"] = labels
 	}
 
 	for _, v := range k._additionalLabels {
-		k._manifest["metadata"].(map[interface{}]interface{})["labels"].(map[string]string)[v.Key] = interface{}(v.Value).(string)
+		labels[v.Key] = v.Value
 	}
 }
@@ -2,8 +2,31 @@ package kubeutil
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic/fake"
+	kfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 /*
@@ -152,3 +175,1828 @@ func TestExecWithContext(t *testing.T) {
 		fmt.Println("Success: ", testCommand._result)
 	}
 }
+
+func TestCheckKubectl(t *testing.T) {
+	dir := t.TempDir()
+	writeKubectl := func(name, gitVersion string) string {
+		path := filepath.Join(dir, name)
+		script := "#!/bin/sh\necho '{\"clientVersion\":{\"gitVersion\":\"" + gitVersion + "\"}}'\n"
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatalf("write kubectl failed: %v", err)
+		}
+		return path
+	}
+	oldKubectl := writeKubectl("kubectl-old", "v1.20.4")
+	newKubectl := writeKubectl("kubectl-new", "v1.29.3")
+
+	tests := []struct {
+		name       string
+		path       string
+		minVersion string
+		wantErr    string
+	}{
+		{"missing", filepath.Join(dir, "kubectl-missing"), "", "kubectl not found"},
+		{"no minimum", oldKubectl, "", ""},
+		{"too old", oldKubectl, "1.27", "older than the minimum version 1.27"},
+		{"new enough", newKubectl, "v1.27.0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := KubeUtil{_config: &KubeConfig{KubectlPath: tt.path, KubectlMinVersion: tt.minVersion}}
+			err := k.checkKubectl()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkKubectl() unexpected error: %v", err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkKubectl() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	badConfig := KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Name:              "test-config",
+		ManifestDirectory: "1/Workflow",
+		KubectlMinVersion: "latest",
+	}
+	if err := badConfig.New(badConfig); err == nil {
+		t.Errorf("expected invalid kubectl minimum version to fail")
+	}
+}
+
+func TestExecClientGo(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{
+		CustomerID:  1,
+		UserID:      "test",
+		Kind:        "KubeUser",
+		ReferenceID: "123",
+	}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-deployment"},"spec":{"replicas":1}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"})
+
+	saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-client-manifest.yaml")
+	t.Cleanup(func() {
+		os.Remove(saved)
+	})
+
+	ctx := context.Background()
+	testCommand.init(testUser, testConf, "create", testManifest, "test-client-manifest")
+	testCommand.SetClient(client, mapper)
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "create", testManifest, "test-client-manifest"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	obj, err := client.Resource(gvr).Namespace("argo-events").Get(ctx, "test-deployment", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get created deployment failed: %v", err)
+	}
+	if obj.GetLabels()["CustomerID"] != "1" {
+		t.Errorf("expected CustomerID label, got %v", obj.GetLabels())
+	}
+	if _, err := os.Stat(saved); err != nil {
+		t.Errorf("expected created manifest stored: %v", err)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-client-manifest"); err != nil {
+		t.Errorf("get failed: %v", err)
+	} else if !strings.Contains(testCommand._result, "name: test-deployment") {
+		t.Errorf("expected deployment in result, got %s", testCommand._result)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "list", testManifest, "test-client-manifest"); err != nil {
+		t.Errorf("list failed: %v", err)
+	} else if !strings.Contains(testCommand._result, "name: test-deployment") {
+		t.Errorf("expected deployment in list, got %s", testCommand._result)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "delete", testManifest, "test-client-manifest"); err != nil {
+		t.Errorf("delete failed: %v", err)
+	}
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("expected deleted manifest removed, got %v", err)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-client-manifest"); err == nil {
+		t.Errorf("expected get of deleted deployment to fail")
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "logs", testManifest, "test-client-manifest"); err == nil {
+		t.Errorf("expected unsupported command to fail")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{
+		CustomerID:  1,
+		UserID:      "test",
+		Kind:        "KubeUser",
+		ReferenceID: "123",
+	}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-deployment"},"spec":{"replicas":1}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"})
+
+	started := make(chan struct{})
+	client.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		close(started)
+		return false, nil, nil
+	})
+	testCommand.SetClient(client, mapper)
+
+	events := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- testCommand.Watch(ctx, testConf, testUser, testManifest,
+			func(eventType string, obj map[string]interface{}) {
+				events <- eventType
+			})
+	}()
+	<-started
+
+	ri := client.Resource(gvr).Namespace("argo-events")
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName("test-deployment")
+	if _, err := ri.Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	obj.SetLabels(map[string]string{"CustomerID": "1"})
+	if _, err := ri.Update(context.Background(), obj, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if err := ri.Delete(context.Background(), "test-deployment", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for _, expected := range []string{WatchAdded, WatchModified, WatchDeleted} {
+		select {
+		case eventType := <-events:
+			if eventType != expected {
+				t.Errorf("expected %s event, got %s", expected, eventType)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s event", expected)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected nil error after cancel, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch did not return after cancel")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{
+		CustomerID:  1,
+		UserID:      "test",
+		Kind:        "KubeUser",
+		ReferenceID: "123",
+	}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-deployment"}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+	}
+
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "test-deployment", "namespace": "argo-events"},
+		"status": map[string]interface{}{
+			"replicas": int64(1),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True", "reason": "MinimumReplicasAvailable"},
+			},
+		},
+	}}
+	newEvent := func(name, objName, reason, timestamp string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion":     "v1",
+			"kind":           "Event",
+			"metadata":       map[string]interface{}{"name": name, "namespace": "argo-events"},
+			"involvedObject": map[string]interface{}{"kind": "Deployment", "name": objName},
+			"type":           "Normal",
+			"reason":         reason,
+			"message":        reason + " message",
+			"count":          int64(1),
+			"lastTimestamp":  timestamp,
+		}}
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList", eventsResource: "EventList"},
+		deployment,
+		newEvent("scaled", "test-deployment", "ScalingReplicaSet", "2024-01-01T00:00:02Z"),
+		newEvent("created", "test-deployment", "Created", "2024-01-01T00:00:01Z"),
+		newEvent("other", "other-deployment", "Other", "2024-01-01T00:00:00Z"))
+	testCommand.SetClient(client, mapper)
+
+	description, err := testCommand.Describe(context.Background(), testConf, testUser, testManifest)
+	if err != nil {
+		t.Fatalf("describe failed: %v", err)
+	}
+	if description.Kind != "Deployment" || description.Name != "test-deployment" || description.Namespace != "argo-events" {
+		t.Errorf("unexpected description object: %+v", description)
+	}
+	if len(description.Conditions) != 1 || description.Conditions[0].Type != "Available" ||
+		description.Conditions[0].Status != "True" {
+		t.Errorf("unexpected conditions: %+v", description.Conditions)
+	}
+	if description.Status["replicas"] != int64(1) {
+		t.Errorf("unexpected status: %v", description.Status)
+	}
+	if len(description.Events) != 2 || description.Events[0].Reason != "Created" ||
+		description.Events[1].Reason != "ScalingReplicaSet" {
+		t.Errorf("unexpected events: %+v", description.Events)
+	}
+
+	testConf.Backend = BackendClientGo
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-describe-manifest.yaml"))
+	})
+	if err := testCommand.ExecWithContext(context.Background(), testConf, testUser, "describe", testManifest, "test-describe-manifest"); err != nil {
+		t.Fatalf("describe command failed: %v", err)
+	}
+	if !strings.Contains(testCommand._result, "reason: ScalingReplicaSet") {
+		t.Errorf("expected events in result, got %s", testCommand._result)
+	}
+}
+
+func TestManifestSource(t *testing.T) {
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-deployment"}}`)
+	sum := sha256.Sum256(testManifest)
+	checksum := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/deployment.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testManifest)
+	}))
+	defer server.Close()
+
+	testFS := fstest.MapFS{"manifests/deployment.yaml": &fstest.MapFile{Data: testManifest}}
+
+	tests := []struct {
+		name    string
+		source  ManifestSource
+		wantErr bool
+	}{
+		{"url no checksum", ManifestSource{URL: server.URL + "/deployment.yaml"}, true},
+		{"url skip checksum", ManifestSource{URL: server.URL + "/deployment.yaml", SkipChecksum: true}, false},
+		{"url checksum", ManifestSource{URL: server.URL + "/deployment.yaml", Checksum: checksum}, false},
+		{"url prefixed checksum", ManifestSource{URL: server.URL + "/deployment.yaml", Checksum: "sha256:" + checksum}, false},
+		{"url bad checksum", ManifestSource{URL: server.URL + "/deployment.yaml", Checksum: strings.Repeat("0", 64)}, true},
+		{"url not found", ManifestSource{URL: server.URL + "/missing.yaml", SkipChecksum: true}, true},
+		{"url bad scheme", ManifestSource{URL: "file:///etc/passwd"}, true},
+		{"fs", ManifestSource{FS: testFS, Path: "manifests/deployment.yaml"}, false},
+		{"fs missing", ManifestSource{FS: testFS, Path: "manifests/missing.yaml"}, true},
+		{"fs no path", ManifestSource{FS: testFS}, true},
+		{"raw", ManifestSource{Raw: testManifest}, false},
+		{"empty", ManifestSource{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(testManifest) {
+				t.Errorf("Load() = %s, want %s", got, testManifest)
+			}
+		})
+	}
+}
+
+func TestNormalizeManifest(t *testing.T) {
+	jsonManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-deployment"},"spec":{"replicas":2,"ratio":0.5,"paused":false,"args":["a","b"]}}`)
+	yamlManifest := []byte(`
+spec:
+  args: [a, b]
+  paused: false
+  ratio: 0.5
+  replicas: 2
+metadata:
+  name: test-deployment
+apiVersion: apps/v1
+kind: Deployment
+`)
+
+	if format := DetectManifestFormat(jsonManifest); format != ManifestJSON {
+		t.Errorf("DetectManifestFormat() = %s, want %s", format, ManifestJSON)
+	}
+	if format := DetectManifestFormat(yamlManifest); format != ManifestYAML {
+		t.Errorf("DetectManifestFormat() = %s, want %s", format, ManifestYAML)
+	}
+	fromJSON, err := NormalizeManifest(jsonManifest)
+	if err != nil {
+		t.Fatalf("NormalizeManifest() json error = %v", err)
+	}
+	fromYAML, err := NormalizeManifest(yamlManifest)
+	if err != nil {
+		t.Fatalf("NormalizeManifest() yaml error = %v", err)
+	}
+	if string(fromJSON) != string(fromYAML) {
+		t.Errorf("NormalizeManifest() json = %s, yaml = %s", fromJSON, fromYAML)
+	}
+	if !strings.HasPrefix(string(fromJSON), "apiVersion: apps/v1\nkind: Deployment\n") {
+		t.Errorf("NormalizeManifest() keys not sorted: %s", fromJSON)
+	}
+
+	// the same manifest is hashed the same in either format
+	hashes := map[string]bool{}
+	for _, manifest := range [][]byte{jsonManifest, yamlManifest} {
+		var k KubeUtil
+		if err := k.init(KubeUser{CustomerID: 1}, &KubeConfig{}, kuApply, manifest, "test"); err != nil {
+			t.Fatalf("init() error = %v", err)
+		}
+		metadata := k._manifest["metadata"].(map[interface{}]interface{})
+		annotations := metadata["annotations"].(map[interface{}]interface{})
+		hashes[annotations[AnnotationManifestHash].(string)] = true
+	}
+	if len(hashes) != 1 {
+		t.Errorf("expected one manifest hash, got %v", hashes)
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     error
+	}{
+		{"json missing kind", `{"apiVersion":"v1","metadata":{"name":"test"}}`, ErrManifestKind},
+		{"yaml missing kind", "apiVersion: v1\nmetadata:\n  name: test\n", ErrManifestKind},
+		{"json missing name", `{"kind":"ConfigMap","metadata":{}}`, ErrManifestName},
+		{"yaml missing metadata", "kind: ConfigMap\n", ErrManifestName},
+		{"yaml name not a string", "kind: ConfigMap\nmetadata:\n  name: [a]\n", ErrManifestName},
+		{"invalid json", `{"kind":"ConfigMap",}`, ErrManifestFormat},
+		{"trailing json", `{"kind":"ConfigMap","metadata":{"name":"test"}} {}`, ErrManifestFormat},
+		{"invalid yaml", "kind: [ConfigMap\n", ErrManifestFormat},
+		{"empty", "", ErrManifestKind},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NormalizeManifest([]byte(tt.manifest)); !errors.Is(err, tt.want) {
+				t.Errorf("NormalizeManifest() error = %v, want %v", err, tt.want)
+			}
+			var k KubeUtil
+			if err := k.init(KubeUser{CustomerID: 1}, &KubeConfig{}, kuApply, []byte(tt.manifest), "test"); !errors.Is(err, tt.want) {
+				t.Errorf("init() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestBuilders(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{
+		CustomerID:  1,
+		UserID:      "test",
+		Kind:        "KubeUser",
+		ReferenceID: "123",
+	}
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+
+	deployment, err := NewDeployment(DeploymentParams{
+		Name:   "test-builder",
+		Image:  "test-image:1.0",
+		Port:   8080,
+		Env:    map[string]string{"B": "2", "A": "1"},
+		Labels: map[string]string{"tier": "backend"},
+	})
+	if err != nil {
+		t.Fatalf("NewDeployment failed: %v", err)
+	}
+	for _, expected := range []string{"replicas: 1", "containerPort: 8080", "app.kubernetes.io/name: test-builder", "tier: backend"} {
+		if !strings.Contains(string(deployment), expected) {
+			t.Errorf("expected %q in deployment:\n%s", expected, deployment)
+		}
+	}
+	if strings.Index(string(deployment), "name: A") > strings.Index(string(deployment), "name: B") {
+		t.Errorf("expected env in name order:\n%s", deployment)
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"})
+	testCommand.SetClient(client, mapper)
+
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-builder-manifest.yaml"))
+	})
+	if err := testCommand.ExecWithContext(context.Background(), testConf, testUser, "create", deployment, "test-builder-manifest"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	obj, err := client.Resource(gvr).Namespace("argo-events").Get(context.Background(), "test-builder", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get created deployment failed: %v", err)
+	}
+	if labels := obj.GetLabels(); labels["CustomerID"] != "1" || labels["tier"] != "backend" {
+		t.Errorf("expected builder and user labels, got %v", labels)
+	}
+	podLabels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+	if podLabels["tier"] != "backend" || podLabels["app.kubernetes.io/name"] != "test-builder" {
+		t.Errorf("expected pod template labels, got %v", podLabels)
+	}
+
+	service, err := NewService(ServiceParams{Name: "test-builder", Port: 80, TargetPort: 8080})
+	if err != nil {
+		t.Fatalf("NewService failed: %v", err)
+	}
+	if !strings.Contains(string(service), "type: ClusterIP") || !strings.Contains(string(service), "targetPort: 8080") {
+		t.Errorf("unexpected service:\n%s", service)
+	}
+
+	configMap, err := NewConfigMap(ConfigMapParams{Name: "test-builder", Data: map[string]string{"app.yaml": "x: 1"}})
+	if err != nil {
+		t.Fatalf("NewConfigMap failed: %v", err)
+	}
+	if !strings.Contains(string(configMap), "app.yaml: 'x: 1'") {
+		t.Errorf("unexpected config map:\n%s", configMap)
+	}
+
+	secret, err := NewSecret(SecretParams{Name: "test-builder", Data: map[string]string{"password": "secret"}})
+	if err != nil {
+		t.Fatalf("NewSecret failed: %v", err)
+	}
+	if !strings.Contains(string(secret), "type: Opaque") || !strings.Contains(string(secret), "password: secret") {
+		t.Errorf("unexpected secret:\n%s", secret)
+	}
+
+	invalid := []struct {
+		name  string
+		build func() ([]byte, error)
+	}{
+		{"bad name", func() ([]byte, error) { return NewDeployment(DeploymentParams{Name: "Bad_Name", Image: "i"}) }},
+		{"no image", func() ([]byte, error) { return NewDeployment(DeploymentParams{Name: "test"}) }},
+		{"bad port", func() ([]byte, error) { return NewService(ServiceParams{Name: "test", Port: 70000}) }},
+		{"no port", func() ([]byte, error) { return NewService(ServiceParams{Name: "test"}) }},
+		{"bad type", func() ([]byte, error) { return NewService(ServiceParams{Name: "test", Port: 80, Type: "Internal"}) }},
+		{"bad label", func() ([]byte, error) {
+			return NewConfigMap(ConfigMapParams{Name: "test", Labels: map[string]string{"bad key!": "v"}})
+		}},
+		{"bad key", func() ([]byte, error) {
+			return NewSecret(SecretParams{Name: "test", Data: map[string]string{"../key": "v"}})
+		}},
+	}
+	for _, tt := range invalid {
+		if _, err := tt.build(); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	var testCommand KubeUtil
+	owner := KubeUser{CustomerID: 1, UserID: "owner", Kind: "KubeUser", ReferenceID: "123"}
+	other := KubeUser{CustomerID: 2, UserID: "other", Kind: "KubeUser", ReferenceID: "456"}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-owned"}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	unowned := &unstructured.Unstructured{}
+	unowned.SetGroupVersionKind(gvk)
+	unowned.SetName("test-unowned")
+	unowned.SetNamespace("argo-events")
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"}, unowned)
+	testCommand.SetClient(client, mapper)
+
+	t.Cleanup(func() {
+		os.Remove(filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-owned-manifest.yaml"))
+	})
+	ctx := context.Background()
+	if err := testCommand.ExecWithContext(ctx, testConf, owner, "create", testManifest, "test-owned-manifest"); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	obj, err := client.Resource(gvr).Namespace("argo-events").Get(ctx, "test-owned", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get created deployment failed: %v", err)
+	}
+	annotations := obj.GetAnnotations()
+	if annotations[AnnotationOwner] != "1" || len(annotations[AnnotationManifestHash]) != 64 ||
+		annotations[AnnotationVersion] == "" {
+		t.Errorf("expected ownership annotations, got %v", annotations)
+	}
+
+	for _, cmd := range []string{"apply", "delete"} {
+		err := testCommand.ExecWithContext(ctx, testConf, other, cmd, testManifest, "test-owned-manifest")
+		if !errors.Is(err, ErrNotOwner) {
+			t.Errorf("expected %s by other customer to fail with ErrNotOwner, got %v", cmd, err)
+		}
+	}
+
+	unownedManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-unowned"}}`)
+	if err := testCommand.ExecWithContext(ctx, testConf, owner, "delete", unownedManifest, "test-owned-manifest"); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("expected delete of unowned object to fail with ErrNotOwner, got %v", err)
+	}
+
+	testCommand.SetForce(true)
+	defer testCommand.SetForce(false)
+	if err := testCommand.ExecWithContext(ctx, testConf, owner, "delete", unownedManifest, "test-owned-manifest"); err != nil {
+		t.Errorf("expected forced delete of unowned object, got %v", err)
+	}
+	if err := testCommand.ExecWithContext(ctx, testConf, other, "delete", testManifest, "test-owned-manifest"); err != nil {
+		t.Errorf("expected forced delete by other customer, got %v", err)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	var testCommand KubeUtil
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "4242/Workflow",
+	}
+
+	dir := filepath.Join(manifestLocation, testConf.ManifestDirectory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create manifest directory failed: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Join(manifestLocation, "4242"))
+	})
+	storedManifest := func(name, image string) string {
+		return fmt.Sprintf(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %s
+  labels:
+    CustomerID: "4242"
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: %s
+`, name, image)
+	}
+	for name, image := range map[string]string{
+		"test-same": "app:1", "test-changed": "app:1", "test-removed": "app:1"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(storedManifest(name, image)), 0644); err != nil {
+			t.Fatalf("write manifest failed: %v", err)
+		}
+	}
+
+	liveDeployment := func(name, customer string, replicas int64, image string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":            name,
+				"namespace":       "argo-events",
+				"labels":          map[string]interface{}{"CustomerID": customer},
+				"resourceVersion": "12",
+				"managedFields":   []interface{}{map[string]interface{}{"manager": fieldManager}},
+			},
+			"spec": map[string]interface{}{
+				"replicas":             replicas,
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{map[string]interface{}{
+							"name":            "app",
+							"image":           image,
+							"imagePullPolicy": "IfNotPresent",
+						}},
+					},
+				},
+			},
+			"status": map[string]interface{}{"replicas": replicas},
+		}}
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"},
+		liveDeployment("test-same", "4242", 2, "app:1"),
+		liveDeployment("test-changed", "4242", 3, "app:2"),
+		liveDeployment("test-added", "4242", 1, "app:1"),
+		liveDeployment("test-other", "1", 1, "app:1"))
+	testCommand.SetClient(client, mapper)
+
+	report, err := testCommand.Drift(context.Background(), testConf, 4242)
+	if err != nil {
+		t.Fatalf("drift failed: %v", err)
+	}
+	if !report.Drifted() || len(report.Resources) != 3 {
+		t.Fatalf("expected 3 drifted resources, got %+v", report.Resources)
+	}
+	added, changed, removed := report.Resources[0], report.Resources[1], report.Resources[2]
+	if added.Name != "test-added" || added.Change != DriftAdded || added.File != "" {
+		t.Errorf("expected added test-added, got %+v", added)
+	}
+	if changed.Name != "test-changed" || changed.Change != DriftChanged ||
+		strings.Join(changed.Fields, ",") != "spec.replicas,spec.template.spec.containers[0].image" {
+		t.Errorf("expected changed replicas and image of test-changed, got %+v", changed)
+	}
+	if removed.Name != "test-removed" || removed.Change != DriftRemoved ||
+		!strings.HasSuffix(removed.File, "test-removed.yaml") {
+		t.Errorf("expected removed test-removed, got %+v", removed)
+	}
+
+	if _, err := testCommand.Drift(context.Background(), testConf, 4343); err == nil {
+		t.Errorf("expected error for manifest directory of other customer")
+	}
+	otherConf := *testConf
+	otherConf.ManifestDirectory = "4343/Workflow"
+	report, err = testCommand.Drift(context.Background(), &otherConf, 4343)
+	if err != nil || report.Drifted() {
+		t.Errorf("expected no drift without stored manifests, got %v %+v", err, report)
+	}
+}
+
+func TestDriftSecret(t *testing.T) {
+	var testCommand KubeUtil
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "0042/Secret",
+	}
+
+	dir := filepath.Join(manifestLocation, testConf.ManifestDirectory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create manifest directory failed: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Join(manifestLocation, "0042"))
+	})
+	storedManifest := func(name, password string) string {
+		return fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: %s
+  namespace: argo-events
+stringData:
+  password: %s
+`, name, password)
+	}
+	for name, password := range map[string]string{
+		"test-same": "secret", "test-changed": "changed"} {
+		if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(storedManifest(name, password)), 0644); err != nil {
+			t.Fatalf("write manifest failed: %v", err)
+		}
+	}
+
+	liveSecret := func(name string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "argo-events",
+			},
+			"type": "Opaque",
+			"data": map[string]interface{}{
+				"password": base64.StdEncoding.EncodeToString([]byte("secret")),
+			},
+		}}
+	}
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "SecretList"},
+		liveSecret("test-same"), liveSecret("test-changed"))
+	testCommand.SetClient(client, mapper)
+
+	report, err := testCommand.Drift(context.Background(), testConf, 42)
+	if err != nil {
+		t.Fatalf("drift failed: %v", err)
+	}
+	if len(report.Resources) != 1 {
+		t.Fatalf("expected 1 drifted secret, got %+v", report.Resources)
+	}
+	if changed := report.Resources[0]; changed.Name != "test-changed" ||
+		strings.Join(changed.Fields, ",") != "data.password" {
+		t.Errorf("expected changed password of test-changed, got %+v", changed)
+	}
+}
+
+// testSender records the events sent
+type testSender struct {
+	values []string
+}
+
+func (s *testSender) Send(topic, key string, value []byte) error {
+	s.values = append(s.values, topic+" "+key+" "+string(value))
+	return nil
+}
+
+func TestReconciler(t *testing.T) {
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "4343/Workflow",
+		Backend:           BackendClientGo,
+	}
+	testUser := KubeUser{CustomerID: 4343, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+
+	dir := filepath.Join(manifestLocation, testConf.ManifestDirectory)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create manifest directory failed: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(filepath.Join(manifestLocation, "4343"))
+	})
+	for _, name := range []string{"test-changed", "test-removed"} {
+		manifest := fmt.Sprintf("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: %s\nspec:\n  replicas: 2\n", name)
+		if err := os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(manifest), 0644); err != nil {
+			t.Fatalf("write manifest failed: %v", err)
+		}
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	liveDeployment := func(name string, replicas int64) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"replicas": replicas},
+		}}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetName(name)
+		obj.SetNamespace("argo-events")
+		obj.SetLabels(map[string]string{"CustomerID": "4343"})
+		obj.SetAnnotations(map[string]string{AnnotationOwner: "4343"})
+		return obj
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"},
+		liveDeployment("test-changed", 3), liveDeployment("test-added", 1))
+	// the fake client cannot apply unstructured objects, replace them instead
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		return true, obj, client.Tracker().Update(gvr, obj, patch.GetNamespace())
+	})
+
+	sender := &testSender{}
+	reconciler := &Reconciler{
+		Config:   testConf,
+		User:     testUser,
+		Interval: 10 * time.Millisecond,
+		Sender:   sender,
+		Topic:    "reconcile",
+	}
+	reconciler.SetClient(client, mapper)
+
+	ctx := context.Background()
+	events := reconciler.Reconcile(ctx)
+	actions := []string{}
+	for _, event := range events {
+		actions = append(actions, event.Name+"="+event.Action)
+		if event.Error != "" {
+			t.Errorf("unexpected reconcile error for %s: %s", event.Name, event.Error)
+		}
+	}
+	if strings.Join(actions, ",") != "test-added=ignored,test-changed=applied,test-removed=created" {
+		t.Errorf("unexpected reconcile actions: %v", actions)
+	}
+	if len(sender.values) != 3 || !strings.HasPrefix(sender.values[0], "reconcile 4343 {") ||
+		!strings.Contains(sender.values[1], `"fields":["spec.replicas"]`) {
+		t.Errorf("unexpected reconcile events sent: %v", sender.values)
+	}
+
+	for _, name := range []string{"test-changed", "test-removed"} {
+		obj, err := client.Resource(gvr).Namespace("argo-events").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get reconciled deployment failed: %v", err)
+		}
+		if replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); replicas != 2 {
+			t.Errorf("expected %s replicas 2, got %d", name, replicas)
+		}
+	}
+
+	// reconciled resources no longer drift
+	events = reconciler.Reconcile(ctx)
+	if len(events) != 1 || events[0].Name != "test-added" {
+		t.Errorf("expected only the added resource, got %+v", events)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := reconciler.Run(ctx); err != nil {
+		t.Errorf("run failed: %v", err)
+	}
+	if len(sender.values) < 5 {
+		t.Errorf("expected events on each interval, got %d", len(sender.values))
+	}
+
+	reconciler.Interval = 0
+	if err := reconciler.Run(ctx); err == nil {
+		t.Errorf("expected error for zero interval")
+	}
+}
+
+func TestDryRunAndDiff(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-diff"},"spec":{"replicas":3}}`)
+	newManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-new"},"spec":{"replicas":1}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+	saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-diff-manifest.yaml")
+	t.Cleanup(func() { os.Remove(saved) })
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	current := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"replicas": int64(1)},
+		"status": map[string]interface{}{"replicas": int64(1)},
+	}}
+	current.SetGroupVersionKind(gvk)
+	current.SetName("test-diff")
+	current.SetNamespace("argo-events")
+	current.SetResourceVersion("7")
+	current.SetAnnotations(map[string]string{AnnotationOwner: "1"})
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"}, current)
+	// the fake client ignores dry run, return the objects without saving them
+	dryRunReactor := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := &unstructured.Unstructured{}
+		switch a := action.(type) {
+		case k8stesting.PatchAction:
+			if err := obj.UnmarshalJSON(a.GetPatch()); err != nil {
+				return true, nil, err
+			}
+		case k8stesting.CreateAction:
+			obj = a.GetObject().(*unstructured.Unstructured)
+		}
+		obj.SetResourceVersion("8")
+		return true, obj, nil
+	}
+	client.PrependReactor("patch", "deployments", dryRunReactor)
+	client.PrependReactor("create", "deployments", dryRunReactor)
+	testCommand.SetClient(client, mapper)
+
+	ctx := context.Background()
+	testCommand.SetDiff(true)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "apply", testManifest, "test-diff-manifest"); err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	result := testCommand.Result()
+	if result.Diff == nil || !result.Diff.Exists || !result.Diff.Changed() {
+		t.Fatalf("expected changes to existing object, got %+v", result.Diff)
+	}
+	var replicas *DiffChange
+	for i, change := range result.Diff.Changes {
+		if strings.HasPrefix(change.Path, "status") || change.Path == "metadata.resourceVersion" {
+			t.Errorf("unexpected server field change: %+v", change)
+		}
+		if change.Path == "spec.replicas" {
+			replicas = &result.Diff.Changes[i]
+		}
+	}
+	if replicas == nil || replicas.Old != int64(1) || replicas.New != int64(3) {
+		t.Errorf("expected replicas change from 1 to 3, got %+v", result.Diff.Changes)
+	}
+	if !strings.Contains(result.Output, "- spec.replicas: 1\n+ spec.replicas: 3\n") {
+		t.Errorf("unexpected diff output: %s", result.Output)
+	}
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("expected diff not to save the manifest")
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "create", newManifest, "test-diff-manifest"); err != nil {
+		t.Fatalf("create diff failed: %v", err)
+	}
+	if diff := testCommand.Result().Diff; diff == nil || diff.Exists || !diff.Changed() ||
+		!strings.Contains(diff.Output, "name: test-new") {
+		t.Errorf("expected creation diff, got %+v", diff)
+	}
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "delete", testManifest, "test-diff-manifest"); err == nil {
+		t.Errorf("expected diff of delete to fail")
+	}
+	testCommand.SetDiff(false)
+
+	// kubectl dry run and diff
+	dir := t.TempDir()
+	kubectlLog := filepath.Join(dir, "kubectl.log")
+	kubectl := filepath.Join(dir, "kubectl")
+	script := `#!/bin/sh
+echo "$@" >> ` + kubectlLog + `
+case "$*" in
+*version*) echo '{"clientVersion":{"gitVersion":"v1.29.3"}}' ;;
+*" get "*) echo '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"test-diff","annotations":{"` + AnnotationOwner + `":"1"}},"spec":{"replicas":1}}' ;;
+*" diff "*) echo '-  replicas: 1'; echo '+  replicas: 3'; exit 1 ;;
+*"-o json"*) echo '{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"test-diff"},"spec":{"replicas":3}}' ;;
+*) echo 'deployment.apps/test-diff configured (server dry run)' ;;
+esac
+`
+	if err := os.WriteFile(kubectl, []byte(script), 0755); err != nil {
+		t.Fatalf("write kubectl failed: %v", err)
+	}
+	testConf.Backend = BackendKubectl
+	testConf.KubectlPath = kubectl
+
+	testCommand.SetDryRun(true)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "apply", testManifest, "test-diff-manifest"); err != nil {
+		t.Fatalf("kubectl dry run failed: %v", err)
+	}
+	if result := testCommand.Result(); !result.DryRun || !strings.Contains(result.Output, "server dry run") {
+		t.Errorf("unexpected dry run result: %+v", result)
+	}
+	testCommand.SetDryRun(false)
+
+	testCommand.SetDiff(true)
+	defer testCommand.SetDiff(false)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "apply", testManifest, "test-diff-manifest"); err != nil {
+		t.Fatalf("kubectl diff failed: %v", err)
+	}
+	diff := testCommand.Result().Diff
+	if diff == nil || len(diff.Changes) == 0 || diff.Output != "-  replicas: 1\n+  replicas: 3\n" {
+		t.Errorf("unexpected kubectl diff: %+v", diff)
+	}
+
+	data, _ := os.ReadFile(kubectlLog)
+	if !strings.Contains(string(data), "apply -f ") || !strings.Contains(string(data), "--dry-run=server -o yaml") ||
+		!strings.Contains(string(data), " diff -f ") {
+		t.Errorf("unexpected kubectl commands:\n%s", data)
+	}
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("expected dry run and diff not to save the manifest")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-timeout"}}`)
+
+	// kubectl ignores SIGTERM and leaves a child behind
+	dir := t.TempDir()
+	childPid := filepath.Join(dir, "child.pid")
+	kubectl := filepath.Join(dir, "kubectl")
+	script := `#!/bin/sh
+trap '' TERM
+sleep 30 &
+echo $! > ` + childPid + `
+wait
+`
+	if err := os.WriteFile(kubectl, []byte(script), 0755); err != nil {
+		t.Fatalf("writing kubectl failed: %v", err)
+	}
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		KubectlPath:       kubectl,
+		CommandTimeout:    200 * time.Millisecond,
+		KillGracePeriod:   200 * time.Millisecond,
+	}
+	saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-timeout-manifest.yaml")
+	t.Cleanup(func() { os.Remove(saved) })
+
+	start := time.Now()
+	err := testCommand.ExecWithContext(context.Background(), testConf, testUser, "apply", testManifest, "test-timeout-manifest")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected cancelled kubectl to return promptly, took %v", elapsed)
+	}
+
+	data, err := os.ReadFile(childPid)
+	if err != nil {
+		t.Fatalf("reading child pid failed: %v", err)
+	}
+	pid := strings.TrimSpace(string(data))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// the killed child may remain a zombie until reaped
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("expected kubectl child %s to be killed", pid)
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	badConf := *testConf
+	badConf.KillGracePeriod = -time.Second
+	if err := testCommand.ExecWithContext(context.Background(), &badConf, testUser, "apply", testManifest, "test-timeout-manifest"); err == nil {
+		t.Errorf("expected negative kill grace period to fail")
+	}
+}
+
+func TestKubectlKillTimer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cmd := kubectlCommand(ctx, "sleep", time.Hour, "30")
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("expected cancelled command to fail")
+	}
+	if cmd.timer == nil || cmd.timer.Stop() {
+		t.Errorf("expected SIGKILL timer stopped once waited")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var testCommand KubeUtil
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "4444/Workflow",
+	}
+
+	object := func(apiVersion, kind, name, customer string, spec, status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "argo-events",
+				"labels":    map[string]interface{}{"CustomerID": customer},
+			},
+			"spec":   spec,
+			"status": status,
+		}}
+	}
+	condition := func(conditionType, status string) []interface{} {
+		return []interface{}{map[string]interface{}{"type": conditionType, "status": status}}
+	}
+	replicas := func(desired int64) map[string]interface{} {
+		return map[string]interface{}{"replicas": desired, "selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "test-available"}}}
+	}
+	unobserved := object("apps/v1", "Deployment", "test-unobserved", "4444", replicas(1),
+		map[string]interface{}{"observedGeneration": int64(2), "updatedReplicas": int64(1), "availableReplicas": int64(1)})
+	unobserved.SetGeneration(3)
+	selected := object("v1", "Pod", "test-selected", "", nil,
+		map[string]interface{}{"phase": "Running", "conditions": condition("Ready", "True")})
+	selected.SetLabels(map[string]string{"app": "test-available"})
+	unselected := object("v1", "Pod", "test-unselected", "", nil,
+		map[string]interface{}{"phase": "Pending"})
+	unselected.SetLabels(map[string]string{"app": "test-other"})
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			deploymentsResource: "DeploymentList",
+			podsResource:        "PodList",
+			jobsResource:        "JobList",
+		},
+		object("apps/v1", "Deployment", "test-available", "4444", replicas(2),
+			map[string]interface{}{"updatedReplicas": int64(2), "availableReplicas": int64(2)}),
+		object("apps/v1", "Deployment", "test-scaling", "4444", replicas(3),
+			map[string]interface{}{"updatedReplicas": int64(3), "availableReplicas": int64(1)}),
+		object("apps/v1", "Deployment", "test-rolling", "4444", replicas(2),
+			map[string]interface{}{"updatedReplicas": int64(1), "availableReplicas": int64(2)}),
+		unobserved, selected, unselected,
+		object("v1", "Pod", "test-ready", "4444", nil,
+			map[string]interface{}{"phase": "Running", "conditions": condition("Ready", "True")}),
+		object("v1", "Pod", "test-done", "4444", nil,
+			map[string]interface{}{"phase": "Succeeded"}),
+		object("batch/v1", "Job", "test-complete", "4444", nil,
+			map[string]interface{}{"conditions": condition("Complete", "True")}),
+		object("batch/v1", "Job", "test-failed", "4444", nil,
+			map[string]interface{}{"conditions": condition("Failed", "True")}),
+		object("apps/v1", "Deployment", "test-other", "1",
+			map[string]interface{}{"replicas": int64(1)}, nil))
+	testCommand.SetClient(client, nil)
+
+	status, err := testCommand.Status(context.Background(), testConf, 4444)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if status.Ready || len(status.Resources) != 9 {
+		t.Fatalf("expected 9 resources not all ready, got %+v", status)
+	}
+	if status.Deployments != (StatusCount{Total: 4, Ready: 1}) ||
+		status.Pods != (StatusCount{Total: 3, Ready: 3}) ||
+		status.Jobs != (StatusCount{Total: 2, Ready: 1}) {
+		t.Errorf("unexpected counts, got %+v %+v %+v", status.Deployments, status.Pods, status.Jobs)
+	}
+	scaling := status.Resources[2]
+	if scaling.Name != "test-scaling" || scaling.Ready || scaling.Message != "3/3 replicas updated, 1 available" {
+		t.Errorf("expected test-scaling not ready, got %+v", scaling)
+	}
+	if rolling := status.Resources[1]; rolling.Name != "test-rolling" || rolling.Ready {
+		t.Errorf("expected test-rolling not ready, got %+v", rolling)
+	}
+	if unobserved := status.Resources[3]; unobserved.Name != "test-unobserved" || unobserved.Ready {
+		t.Errorf("expected test-unobserved not ready, got %+v", unobserved)
+	}
+	if selected := status.Resources[8]; selected.Name != "test-selected" || !selected.Ready {
+		t.Errorf("expected test-selected pod of test-available, got %+v", selected)
+	}
+	failed := status.Resources[5]
+	if failed.Name != "test-failed" || failed.Ready || failed.Message != "Failed" {
+		t.Errorf("expected test-failed not ready, got %+v", failed)
+	}
+
+	status, err = testCommand.Status(context.Background(), testConf, 4545)
+	if err != nil || !status.Ready || len(status.Resources) != 0 {
+		t.Errorf("expected ready without resources, got %v %+v", err, status)
+	}
+}
+
+func TestWait(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+	}
+
+	object := func(apiVersion, kind, name string, status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":       name,
+				"namespace":  "argo-events",
+				"generation": int64(2),
+			},
+			"spec":   map[string]interface{}{"replicas": int64(2)},
+			"status": status,
+		}}
+	}
+	rolling := map[string]interface{}{"observedGeneration": int64(2),
+		"updatedReplicas": int64(1), "availableReplicas": int64(1)}
+	gvks := []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+		{Group: "batch", Version: "v1", Kind: "Job"},
+	}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvks[0].GroupVersion(), gvks[1].GroupVersion()})
+	for _, gvk := range gvks {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			deploymentsResource: "DeploymentList",
+			jobsResource:        "JobList",
+		},
+		object("apps/v1", "Deployment", "test-rollout", rolling),
+		object("apps/v1", "Deployment", "test-stuck", rolling),
+		object("batch/v1", "Job", "test-failed", map[string]interface{}{
+			"conditions": []interface{}{map[string]interface{}{"type": "Failed", "status": "True"}}}))
+	testCommand.SetClient(client, mapper)
+	testCommand.SetWaitInterval(10 * time.Millisecond)
+	var handled []CompletionEvent
+	testCommand.SetCompletionHandler(func(event CompletionEvent) {
+		handled = append(handled, event)
+	})
+	sender := &testSender{}
+	testCommand.SetCompletionSender(sender, "completions")
+
+	// the rollout finishes while waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		done := object("apps/v1", "Deployment", "test-rollout", map[string]interface{}{
+			"observedGeneration": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2)})
+		client.Tracker().Update(deploymentsResource, done, "argo-events")
+	}()
+	manifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-rollout"}}`)
+	event, err := testCommand.Wait(context.Background(), testConf, testUser, manifest)
+	if err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if event.Operation != OperationRollout || !event.Succeeded ||
+		event.Message != "2/2 replicas updated, 2 available" || event.Finished.Before(event.Started) {
+		t.Errorf("expected rollout succeeded, got %+v", event)
+	}
+	if len(handled) != 1 || handled[0].Name != "test-rollout" {
+		t.Errorf("expected completion handled, got %+v", handled)
+	}
+	if len(sender.values) != 1 || !strings.HasPrefix(sender.values[0], "completions 1 ") {
+		t.Fatalf("expected completion sent, got %v", sender.values)
+	}
+	var ce struct {
+		SpecVersion string          `json:"specversion"`
+		Type        string          `json:"type"`
+		Subject     string          `json:"subject"`
+		Data        CompletionEvent `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(strings.SplitN(sender.values[0], " ", 3)[2]), &ce); err != nil {
+		t.Fatalf("decode completion cloudevent failed: %v", err)
+	}
+	if ce.SpecVersion != "1.0" || ce.Type != completionType ||
+		ce.Subject != "Deployment/test-rollout" || !ce.Data.Succeeded {
+		t.Errorf("unexpected completion cloudevent: %+v", ce)
+	}
+
+	manifest = []byte(`{"kind":"Job","apiVersion":"batch/v1","metadata":{"name":"test-failed"}}`)
+	event, err = testCommand.Wait(context.Background(), testConf, testUser, manifest)
+	if err != nil || event.Operation != OperationJob || event.Succeeded || event.Message != "Failed" {
+		t.Errorf("expected job failed, got %v %+v", err, event)
+	}
+
+	timeoutConf := *testConf
+	timeoutConf.CommandTimeout = 50 * time.Millisecond
+	manifest = []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-stuck"}}`)
+	event, err = testCommand.Wait(context.Background(), &timeoutConf, testUser, manifest)
+	if !errors.Is(err, context.DeadlineExceeded) || event == nil || event.Succeeded || event.Error == "" {
+		t.Errorf("expected abandoned rollout, got %v %+v", err, event)
+	}
+	if len(handled) != 3 || len(sender.values) != 3 {
+		t.Errorf("expected 3 completions, got %d handled %d sent", len(handled), len(sender.values))
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testManifest := []byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"test-secret"},"stringData":{"password":"changed"}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Secret",
+		Backend:           BackendClientGo,
+	}
+	saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-secret.yaml")
+	t.Cleanup(func() { os.Remove(saved) })
+
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	current := &unstructured.Unstructured{Object: map[string]interface{}{
+		"data":       map[string]interface{}{"password": "aHVudGVyMg=="},
+		"stringData": map[string]interface{}{"password": "hunter2"},
+	}}
+	current.SetGroupVersionKind(gvk)
+	current.SetName("test-secret")
+	current.SetNamespace("argo-events")
+	current.SetAnnotations(map[string]string{AnnotationOwner: "1",
+		lastAppliedAnnotation: `{"kind":"Secret","stringData":{"password":"hunter2"}}`})
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "SecretList"}, current)
+	client.PrependReactor("patch", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := &unstructured.Unstructured{}
+		err := obj.UnmarshalJSON(action.(k8stesting.PatchAction).GetPatch())
+		return true, obj, err
+	})
+	testCommand.SetClient(client, mapper)
+
+	ctx := context.Background()
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-secret"); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	output := testCommand.Result().Output
+	if strings.Contains(output, "hunter2") || strings.Contains(output, "aHVudGVyMg==") ||
+		!strings.Contains(output, "password: "+RedactedValue) || !strings.Contains(output, "name: test-secret") {
+		t.Errorf("expected redacted secret, got:\n%s", output)
+	}
+	if _, err := os.Stat(saved); !os.IsNotExist(err) {
+		t.Errorf("expected get not to store the manifest, got %v", err)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "list", testManifest, "test-secret"); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if output := testCommand.Result().Output; strings.Contains(output, "hunter2") {
+		t.Errorf("expected redacted secret list, got:\n%s", output)
+	}
+
+	testCommand.SetDiff(true)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "apply", testManifest, "test-secret"); err != nil {
+		t.Fatalf("diff failed: %v", err)
+	}
+	diff := testCommand.Result().Diff
+	if diff == nil || !diff.Changed() || strings.Contains(diff.Output, "hunter2") ||
+		strings.Contains(diff.Output, "changed") {
+		t.Errorf("expected redacted diff, got %+v", diff)
+	}
+	for _, change := range diff.Changes {
+		if strings.HasPrefix(change.Path, "stringData") && (change.Old != RedactedValue || change.New != RedactedValue) {
+			t.Errorf("expected redacted change, got %+v", change)
+		}
+	}
+	testCommand.SetDiff(false)
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "apply", testManifest, "test-secret"); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if data, _ := os.ReadFile(saved); !strings.Contains(string(data), "changed") {
+		t.Errorf("expected saved manifest not to be redacted, got:\n%s", data)
+	}
+
+	testCommand.SetShowSecrets(true)
+	defer testCommand.SetShowSecrets(false)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-secret"); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if output := testCommand.Result().Output; !strings.Contains(output, "hunter2") {
+		t.Errorf("expected secret shown, got:\n%s", output)
+	}
+
+	annotated := &ManifestDiff{Kind: "Secret", Changes: []DiffChange{
+		{Path: "metadata.annotations", New: map[string]interface{}{lastAppliedAnnotation: "hunter2"}},
+		{Path: "metadata.annotations." + lastAppliedAnnotation, Old: "hunter2", New: "changed"},
+	}}
+	redactDiff(annotated)
+	if out := fmt.Sprintf("%v", annotated.Changes); strings.Contains(out, "hunter2") || strings.Contains(out, "changed") {
+		t.Errorf("expected redacted annotation changes, got %s", out)
+	}
+
+	if out := redactOutput("secret/test-secret deleted\n"); out != "secret/test-secret deleted\n" {
+		t.Errorf("expected plain output unchanged, got %s", out)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+	for _, name := range []string{"test-job", "test-workflow", "test-stuck"} {
+		saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, name+".yaml")
+		t.Cleanup(func() { os.Remove(saved) })
+	}
+
+	workflowsResource := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+	gvks := []schema.GroupVersionKind{
+		{Group: "batch", Version: "v1", Kind: "Job"},
+		{Group: "argoproj.io", Version: "v1alpha1", Kind: "Workflow"},
+	}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvks[0].GroupVersion(), gvks[1].GroupVersion()})
+	for _, gvk := range gvks {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	pod := func(name, job, phase string, exitCode int64) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "argo-events",
+				"labels":    map[string]interface{}{"job-name": job},
+			},
+			"spec": map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"name": "main"}}},
+			"status": map[string]interface{}{
+				"phase": phase,
+				"containerStatuses": []interface{}{map[string]interface{}{
+					"name":  "main",
+					"state": map[string]interface{}{"terminated": map[string]interface{}{"exitCode": exitCode}},
+				}},
+			},
+		}}
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			jobsResource:      "JobList",
+			workflowsResource: "WorkflowList",
+			podsResource:      "PodList",
+		},
+		pod("test-job-1", "test-job", "Failed", 3),
+		pod("test-job-2", "test-job", "Succeeded", 0))
+	// the created objects finish at once except test-stuck
+	finish := func(gvr schema.GroupVersionResource, status map[string]interface{}) k8stesting.ReactionFunc {
+		return func(action k8stesting.Action) (bool, runtime.Object, error) {
+			name := action.(k8stesting.GetAction).GetName()
+			obj, err := client.Tracker().Get(gvr, "argo-events", name)
+			if err != nil || name == "test-stuck" {
+				return false, nil, nil
+			}
+			finished := obj.(*unstructured.Unstructured).DeepCopy()
+			finished.Object["status"] = status
+			return true, finished, nil
+		}
+	}
+	client.PrependReactor("get", "jobs", finish(jobsResource, map[string]interface{}{
+		"conditions": []interface{}{map[string]interface{}{"type": "Failed", "status": "True"}}}))
+	client.PrependReactor("get", "workflows", finish(workflowsResource, map[string]interface{}{
+		"phase": "Succeeded"}))
+	testCommand.SetClient(client, mapper)
+	testCommand.SetClientset(kfake.NewSimpleClientset())
+
+	ctx := context.Background()
+	opts := JobOptions{Timeout: time.Second, Interval: 10 * time.Millisecond}
+	manifest := []byte(`{"kind":"Job","apiVersion":"batch/v1","metadata":{"name":"test-job"}}`)
+	result, err := testCommand.RunJob(ctx, testConf, testUser, manifest, opts)
+	if err != nil {
+		t.Fatalf("run job failed: %v", err)
+	}
+	if result.Succeeded || result.ExitCode != 3 || result.Message != "Failed" ||
+		len(result.Logs) != 1 || result.Logs["test-job-1/main"] == "" || result.LogsError != "" {
+		t.Errorf("expected failed job with logs, got %+v", result)
+	}
+
+	manifest = []byte(`{"kind":"Workflow","apiVersion":"argoproj.io/v1alpha1","metadata":{"name":"test-workflow"}}`)
+	result, err = testCommand.RunJob(ctx, testConf, testUser, manifest, opts)
+	if err != nil || !result.Succeeded || result.ExitCode != 0 || len(result.Logs) != 0 {
+		t.Errorf("expected workflow succeeded, got %v %+v", err, result)
+	}
+
+	opts.Timeout = 50 * time.Millisecond
+	manifest = []byte(`{"kind":"Workflow","apiVersion":"argoproj.io/v1alpha1","metadata":{"name":"test-stuck"}}`)
+	result, err = testCommand.RunJob(ctx, testConf, testUser, manifest, opts)
+	if !errors.Is(err, context.DeadlineExceeded) || result == nil || result.Succeeded ||
+		result.ExitCode != -1 || result.Error == "" {
+		t.Errorf("expected timed out workflow, got %v %+v", err, result)
+	}
+
+	manifest = []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-job"}}`)
+	if _, err := testCommand.RunJob(ctx, testConf, testUser, manifest, opts); err == nil {
+		t.Errorf("expected deployment to fail")
+	}
+}
+
+func TestClientAccess(t *testing.T) {
+	var testCommand KubeUtil
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "test-context",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+	}
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	data := `apiVersion: v1
+kind: Config
+clusters:
+- name: test-cluster
+  cluster:
+    server: https://test.example.com:6443
+contexts:
+- name: test-context
+  context:
+    cluster: test-cluster
+    user: test-user
+users:
+- name: test-user
+  user:
+    token: test-token
+`
+	if err := os.WriteFile(kubeconfig, []byte(data), 0600); err != nil {
+		t.Fatalf("write kubeconfig failed: %v", err)
+	}
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	restConfig, err := testCommand.RESTConfig(testConf)
+	if err != nil {
+		t.Fatalf("rest config failed: %v", err)
+	}
+	if restConfig.Host != "https://test.example.com:6443" || restConfig.BearerToken != "test-token" {
+		t.Errorf("unexpected rest config: %s %s", restConfig.Host, restConfig.BearerToken)
+	}
+	if clientset, err := testCommand.Clientset(testConf); err != nil || clientset == nil {
+		t.Errorf("expected clientset, got %v", err)
+	}
+
+	client := fake.NewSimpleDynamicClient(runtime.NewScheme())
+	mapper := meta.NewDefaultRESTMapper(nil)
+	testCommand.SetClient(client, mapper)
+	if c, m, err := testCommand.Client(testConf); err != nil || c != client || m != mapper {
+		t.Errorf("expected the client set, got %v", err)
+	}
+
+	badConf := *testConf
+	badConf.Kind = "Bad"
+	if _, err := testCommand.RESTConfig(&badConf); err == nil {
+		t.Errorf("expected bad config to fail")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+	for _, name := range []string{"test-queued-1", "test-queued-2", "test-queued-3"} {
+		saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, name+".yaml")
+		t.Cleanup(func() { os.Remove(saved) })
+	}
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{deploymentsResource: "DeploymentList"})
+	// the first create blocks until released
+	release := make(chan struct{})
+	client.PrependReactor("create", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() == "test-queued-1" {
+			<-release
+		}
+		return false, nil, nil
+	})
+
+	queue := NewQueue(1)
+	queue.SetClient(client, mapper)
+	manifest := func(name string) []byte {
+		return []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"` + name + `"}}`)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := queue.Submit(ctx, testConf, testUser, "create", manifest("test-queued-1"))
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	// the jobs outlive the submitting context
+	cancel()
+	second, _ := queue.Submit(context.Background(), testConf, testUser, "create", manifest("test-queued-2"))
+	third, _ := queue.Submit(context.Background(), testConf, testUser, "create", manifest("test-queued-3"))
+
+	waitState := func(id string, states ...string) JobStatus {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status, err := queue.GetStatus(id)
+			if err != nil {
+				t.Fatalf("status failed: %v", err)
+			}
+			for _, state := range states {
+				if status.State == state {
+					return status
+				}
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected job %s %v, got %+v", id, states, status)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitState(first, JobRunning)
+	if status, _ := queue.GetStatus(second); status.State != JobQueued {
+		t.Errorf("expected second job queued, got %+v", status)
+	}
+	if _, err := queue.Results(first); !errors.Is(err, ErrJobNotFinished) {
+		t.Errorf("expected results of running job to fail, got %v", err)
+	}
+	if err := queue.Cancel(third); err != nil {
+		t.Errorf("cancel failed: %v", err)
+	}
+	close(release)
+
+	status := waitState(first, JobSucceeded, JobFailed)
+	if status.State != JobSucceeded || status.Name != "test-queued-1" || status.Started.Before(status.Submitted) ||
+		status.Finished.Before(status.Started) {
+		t.Errorf("expected first job succeeded, got %+v", status)
+	}
+	if result, err := queue.Results(first); err != nil || result.Command != "create" ||
+		!strings.Contains(result.Output, "name: test-queued-1") {
+		t.Errorf("unexpected first job results: %v %+v", err, result)
+	}
+	waitState(second, JobSucceeded)
+	if status := waitState(third, JobCancelled, JobSucceeded); status.State != JobCancelled {
+		t.Errorf("expected third job cancelled, got %+v", status)
+	}
+	if jobs := queue.Jobs(); len(jobs) != 3 || jobs[0].ID != first || jobs[2].ID != third {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+
+	if err := queue.Remove(first); err != nil {
+		t.Errorf("remove failed: %v", err)
+	}
+	if _, err := queue.GetStatus(first); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected removed job not found, got %v", err)
+	}
+	queue.Close()
+	if _, err := queue.Submit(context.Background(), testConf, testUser, "create", manifest("test-queued-1")); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("expected closed queue to fail, got %v", err)
+	}
+}
+
+func TestParsedResult(t *testing.T) {
+	var testCommand KubeUtil
+	testUser := KubeUser{CustomerID: 1, UserID: "test", Kind: "KubeUser", ReferenceID: "123"}
+	testManifest := []byte(`{"kind":"Deployment","apiVersion":"apps/v1","metadata":{"name":"test-result"}}`)
+
+	testConf := &KubeConfig{
+		ApiVersion:        "eventorchestrator/v1alpha1",
+		Kind:              "KubeConfig",
+		Kubectx:           "microk8s",
+		Name:              "test-config",
+		Namespace:         "argo-events",
+		ManifestDirectory: "1/Workflow",
+		Backend:           BackendClientGo,
+	}
+	saved := filepath.Join(manifestLocation, testConf.ManifestDirectory, "test-result-manifest.yaml")
+	t.Cleanup(func() { os.Remove(saved) })
+
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	current := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Available", "status": "True", "reason": "MinimumReplicasAvailable"},
+		}},
+	}}
+	current.SetGroupVersionKind(gvk)
+	current.SetName("test-result")
+	current.SetNamespace("argo-events")
+	current.SetUID("uid-1")
+	current.SetLabels(map[string]string{"CustomerID": "1"})
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "DeploymentList"}, current)
+	testCommand.SetClient(client, mapper)
+
+	ctx := context.Background()
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-result-manifest"); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	result, err := testCommand.ParsedResult()
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if result.Kind != "Deployment" || result.Name != "test-result" || result.Namespace != "argo-events" ||
+		result.UID != "uid-1" || result.Format != ResultYAML || result.Object != nil || result.ExitStatus != 0 ||
+		result.Duration <= 0 || !strings.Contains(result.Raw, "name: test-result") {
+		t.Errorf("unexpected yaml result: %+v", result)
+	}
+	if len(result.Conditions) != 1 || result.Conditions[0] != (ResultCondition{
+		Type: "Available", Status: "True", Reason: "MinimumReplicasAvailable"}) {
+		t.Errorf("unexpected conditions: %+v", result.Conditions)
+	}
+
+	testCommand.SetJSONOutput(true)
+	defer testCommand.SetJSONOutput(false)
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "get", testManifest, "test-result-manifest"); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	result, err = testCommand.ParsedResult()
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if result.Format != ResultJSON || result.Object == nil || result.Object.GetName() != "test-result" ||
+		!strings.HasPrefix(result.Raw, "{") || len(result.Conditions) != 1 {
+		t.Errorf("unexpected json result: %+v", result)
+	}
+
+	if err := testCommand.ExecWithContext(ctx, testConf, testUser, "list", testManifest, "test-result-manifest"); err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	result, err = testCommand.ParsedResult()
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if result.Kind != "DeploymentList" || len(result.Items) != 1 || result.Items[0].Name != "test-result" ||
+		result.Items[0].UID != "uid-1" {
+		t.Errorf("unexpected list result: %+v", result)
+	}
+
+	if result, err := parseResult("deployment.apps \"test-result\" deleted\n", ResultYAML); err != nil ||
+		result.Kind != "" || result.Raw == "" {
+		t.Errorf("expected plain output without object, got %v %+v", err, result)
+	}
+	if _, err := parseResult("{not json", ResultJSON); err == nil {
+		t.Errorf("expected invalid json to fail")
+	}
+	if out := redactOutput(`{"kind":"Secret","data":{"password":"aHVudGVyMg=="}}`); !strings.HasPrefix(out, "{") ||
+		strings.Contains(out, "aHVudGVyMg==") {
+		t.Errorf("expected redacted json, got %s", out)
+	}
+
+	// the exit status of a failed kubectl is kept
+	dir := t.TempDir()
+	kubectl := filepath.Join(dir, "kubectl")
+	script := `#!/bin/sh
+if [ "$1" = version ]; then
+  echo '{"clientVersion":{"gitVersion":"v1.29.3"}}'
+  exit 0
+fi
+echo 'Error from server (NotFound): deployments.apps "test-result" not found' >&2
+exit 3
+`
+	if err := os.WriteFile(kubectl, []byte(script), 0755); err != nil {
+		t.Fatalf("writing kubectl failed: %v", err)
+	}
+	kubectlConf := *testConf
+	kubectlConf.Backend = ""
+	kubectlConf.KubectlPath = kubectl
+	if err := testCommand.ExecWithContext(ctx, &kubectlConf, testUser, "get", testManifest, "test-result-manifest"); err == nil {
+		t.Fatalf("expected kubectl get to fail")
+	}
+	result, _ = testCommand.ParsedResult()
+	if result.ExitStatus != 3 || !strings.Contains(result.Raw, "NotFound") {
+		t.Errorf("unexpected failed result: %+v", result)
+	}
+}
@@ -0,0 +1,266 @@
+package kubeutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Long running operations reported on completion
+const (
+	// OperationRollout is the rollout of a deployment
+	OperationRollout = "rollout"
+
+	// OperationJob is the completion of a job
+	OperationJob = "job"
+
+	// OperationWorkflow is the completion of an Argo workflow
+	OperationWorkflow = "workflow"
+)
+
+// Completion cloudevent attributes
+const (
+	completionSource      = "//pavedroad.io/kubeutil"
+	completionType        = "io.pavedroad.kubeutil.completion"
+	completionSpecVersion = "1.0"
+)
+
+// defaultWaitInterval is the readiness polling interval if none is set
+const defaultWaitInterval = 2 * time.Second
+
+// CompletionEvent describes a long running operation that finished, or
+// was abandoned when Error is set
+type CompletionEvent struct {
+	CustomerID int       `json:"customerID"`
+	Operation  string    `json:"operation"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Succeeded  bool      `json:"succeeded"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+}
+
+// CompletionHandler is called once a long running operation finishes
+type CompletionHandler func(event CompletionEvent)
+
+// completionCloudEvent is the cloudevent sent with the completion event
+type completionCloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            CompletionEvent `json:"data"`
+}
+
+// SetCompletionHandler sets the handler called when Wait finishes
+func (k *KubeUtil) SetCompletionHandler(handler CompletionHandler) {
+	k._completionHandler = handler
+}
+
+// SetCompletionSender sets the sender of the completion cloudevent when
+// Wait finishes, the sender default topic is used if topic is empty
+func (k *KubeUtil) SetCompletionSender(sender EventSender, topic string) {
+	k._completionSender = sender
+	k._completionTopic = topic
+}
+
+// SetWaitInterval sets the readiness polling interval of Wait
+func (k *KubeUtil) SetWaitInterval(interval time.Duration) {
+	k._waitInterval = interval
+}
+
+// Wait blocks until the rollout of the manifest deployment, the manifest
+// job or the manifest workflow finishes, then reports the completion to the handler and sender
+// Cancelling the context or reaching the command timeout abandons the wait,
+// which is also reported
+// Run Wait in a goroutine with its own KubeUtil to be notified
+// asynchronously
+// Wait always uses the client-go dynamic client whatever the backend
+func (k *KubeUtil) Wait(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	manifest []byte) (*CompletionEvent, error) {
+
+	k._ctx = ctx
+	if validConf := conf.New(*conf); validConf != nil {
+		return nil, k.respondWithError("Bad config", validConf)
+	}
+
+	if conf.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.CommandTimeout)
+		defer cancel()
+		k._ctx = ctx
+	}
+
+	if err := k.init(user, conf, kuWait, manifest, ""); err != nil {
+		return nil, k.respondWithError("Failed to initialize", err)
+	}
+
+	event, err := k.wait()
+	if event != nil {
+		event.Started = k._startTime
+		event.Finished = time.Now()
+		if err != nil {
+			event.Error = err.Error()
+		}
+		k.complete(*event)
+	}
+	if err != nil {
+		k._error = err.Error()
+		return event, k.respondWithError("wait", err)
+	}
+	return event, nil
+}
+
+// wait polls the manifest object until the operation finishes
+func (k *KubeUtil) wait() (*CompletionEvent, error) {
+	obj, ri, err := k.clientResource()
+	if err != nil {
+		return nil, err
+	}
+
+	event := &CompletionEvent{
+		CustomerID: k._user.CustomerID,
+		Kind:       obj.GetKind(),
+		Name:       obj.GetName(),
+		Namespace:  obj.GetNamespace(),
+	}
+	var finished func(obj map[string]interface{}) (bool, bool, string)
+	switch obj.GetKind() {
+	case "Deployment":
+		event.Operation = OperationRollout
+		finished = rolloutFinished
+	case "Job":
+		event.Operation = OperationJob
+		finished = jobFinished
+	case "Workflow":
+		event.Operation = OperationWorkflow
+		finished = workflowFinished
+	default:
+		return nil, errors.New("Wait not supported for kind: " + obj.GetKind())
+	}
+
+	interval := k._waitInterval
+	if interval <= 0 {
+		interval = defaultWaitInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		current, err := ri.Get(k._ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return event, err
+		}
+		done, succeeded, message := finished(current.Object)
+		event.Message = message
+		if done {
+			event.Succeeded = succeeded
+			return event, nil
+		}
+
+		select {
+		case <-k._ctx.Done():
+			return event, k._ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// complete calls the completion handler and sends the completion cloudevent
+func (k *KubeUtil) complete(event CompletionEvent) {
+	if k._completionHandler != nil {
+		k._completionHandler(event)
+	}
+	if k._completionSender == nil {
+		return
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	ce := completionCloudEvent{
+		SpecVersion:     completionSpecVersion,
+		ID:              hex.EncodeToString(id),
+		Source:          completionSource,
+		Type:            completionType,
+		Subject:         event.Kind + "/" + event.Name,
+		Time:            event.Finished.UTC(),
+		DataContentType: "application/json",
+		Data:            event,
+	}
+	if value, err := json.Marshal(ce); err == nil {
+		k._completionSender.Send(k._completionTopic,
+			strconv.Itoa(event.CustomerID), value)
+	}
+}
+
+// rolloutFinished returns true once all the replicas of the current
+// generation are updated and available, or the rollout exceeded its
+// progress deadline
+func rolloutFinished(obj map[string]interface{}) (bool, bool, string) {
+	generation, _, _ := unstructured.NestedInt64(obj, "metadata", "generation")
+	observed, _, _ := unstructured.NestedInt64(obj, "status", "observedGeneration")
+	if observed < generation {
+		return false, false, "Waiting for the rollout to be observed"
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && nestedString(condition, "type") == "Progressing" &&
+			nestedString(condition, "reason") == "ProgressDeadlineExceeded" {
+			return true, false, nestedString(condition, "message")
+		}
+	}
+
+	desired, found, _ := unstructured.NestedInt64(obj, "spec", "replicas")
+	if !found {
+		desired = 1
+	}
+	updated, _, _ := unstructured.NestedInt64(obj, "status", "updatedReplicas")
+	available, _, _ := unstructured.NestedInt64(obj, "status", "availableReplicas")
+	message := fmt.Sprintf("%d/%d replicas updated, %d available", updated,
+		desired, available)
+	return updated >= desired && available >= desired, true, message
+}
+
+// jobFinished returns true once the job is complete or failed
+func jobFinished(obj map[string]interface{}) (bool, bool, string) {
+	ready, message := jobReady(obj)
+	if ready || conditionTrue(obj, "Failed") {
+		return true, ready, message
+	}
+	return false, false, message
+}
+
+// workflowFinished returns true once the workflow succeeded, failed or
+// errored
+func workflowFinished(obj map[string]interface{}) (bool, bool, string) {
+	phase := nestedString(obj, "status", "phase")
+	message := nestedString(obj, "status", "message")
+	if message == "" {
+		message = phase
+	}
+	switch phase {
+	case "Succeeded":
+		return true, true, message
+	case "Failed", "Error":
+		return true, false, message
+	}
+	return false, false, message
+}
@@ -0,0 +1,117 @@
+package kubeutil
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Event types passed to a WatchHandler
+const (
+	WatchAdded    = string(watch.Added)
+	WatchModified = string(watch.Modified)
+	WatchDeleted  = string(watch.Deleted)
+)
+
+// WatchHandler is called with the event type and object for each event
+type WatchHandler func(eventType string, obj map[string]interface{})
+
+// Watch streams the events for the manifest resource to the handler until
+// the context is cancelled, a closed watch is restarted where it left off
+// Watch always uses the client-go dynamic client whatever the backend
+func (k *KubeUtil) Watch(
+	ctx context.Context,
+	conf *KubeConfig,
+	user KubeUser,
+	manifest []byte,
+	handler WatchHandler) error {
+
+	k._ctx = ctx
+	if validConf := conf.New(*conf); validConf != nil {
+		return k.respondWithError("Bad config", validConf)
+	}
+
+	if err := k.init(user, conf, kuWatch, manifest, ""); err != nil {
+		return k.respondWithError("Failed to initialize", err)
+	}
+
+	obj, ri, err := k.clientResource()
+	if err != nil {
+		k._error = err.Error()
+		return k.respondWithError("watch", err)
+	}
+
+	options := metav1.ListOptions{}
+	if name := obj.GetName(); name != "" {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	} else {
+		options.LabelSelector = fmt.Sprintf("CustomerID=%v", k._user.CustomerID)
+	}
+
+	for {
+		w, err := ri.Watch(ctx, options)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			k._error = err.Error()
+			return k.respondWithError("watch", err)
+		}
+
+		if err := k.watchEvents(ctx, w, &options, handler); err != nil {
+			k._error = err.Error()
+			return k.respondWithError("watch", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// watchEvents passes events to the handler until the watch closes or the
+// context is cancelled, options is updated with the last resource version
+func (k *KubeUtil) watchEvents(
+	ctx context.Context,
+	w watch.Interface,
+	options *metav1.ListOptions,
+	handler WatchHandler) error {
+
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				obj, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				options.ResourceVersion = obj.GetResourceVersion()
+				if !k._showSecrets {
+					redactSecrets(obj.Object)
+				}
+				handler(string(event.Type), obj.Object)
+
+			case watch.Bookmark:
+				if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+					options.ResourceVersion = obj.GetResourceVersion()
+				}
+
+			case watch.Error:
+				return apierrors.FromObject(event.Object)
+			}
+		}
+	}
+}
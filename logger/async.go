@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncPolicyType provided to select handling of a full async buffer
+type AsyncPolicyType string
+
+// Types of full async buffer handling
+const (
+	AsyncDropOldest AsyncPolicyType = "dropoldest" // default
+	AsyncBlock      AsyncPolicyType = "block"
+)
+
+// defaultAsyncBufferSize is the records buffered when AsyncBufferSize is zero
+const defaultAsyncBufferSize = 1024
+
+// ErrWriterClosed is returned for writes after an async writer is closed
+var ErrWriterClosed = errors.New("Async writer closed")
+
+// asyncWriter writes records to the writer from a bounded buffer
+// Records are written in order by a single goroutine
+type asyncWriter struct {
+	out     io.Writer
+	policy  AsyncPolicyType
+	records chan []byte
+	mutex   sync.RWMutex // closed and sends on records
+	closed  bool
+	pending int64 // records buffered or being written
+	drained *sync.Cond
+	dropped uint64
+	done    chan struct{}
+}
+
+// newAsyncWriter returns an async writer instance and starts its goroutine
+func newAsyncWriter(out io.Writer, size int,
+	policy AsyncPolicyType) *asyncWriter {
+
+	if size <= 0 {
+		size = defaultAsyncBufferSize
+	}
+	if policy == "" {
+		policy = AsyncDropOldest
+	}
+	w := &asyncWriter{
+		out:     out,
+		policy:  policy,
+		records: make(chan []byte, size),
+		drained: sync.NewCond(&sync.Mutex{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run writes the buffered records until the buffer is closed
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	for msg := range w.records {
+		_, err := w.out.Write(msg)
+		recordError(err)
+		w.release()
+	}
+}
+
+// release marks a record written or dropped, waking Drain when none pending
+func (w *asyncWriter) release() {
+	if atomic.AddInt64(&w.pending, -1) == 0 {
+		w.drained.L.Lock()
+		w.drained.Broadcast()
+		w.drained.L.Unlock()
+	}
+}
+
+// Write buffers a copy of the record, applying the policy if the buffer is full
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	// callers may reuse the buffer once Write returns
+	msg := append([]byte(nil), p...)
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		countDropped(DropClosed)
+		return 0, ErrWriterClosed
+	}
+	atomic.AddInt64(&w.pending, 1)
+
+	if w.policy == AsyncBlock {
+		w.records <- msg
+		return len(p), nil
+	}
+	for {
+		select {
+		case w.records <- msg:
+			return len(p), nil
+		default:
+		}
+		select {
+		case <-w.records:
+			atomic.AddUint64(&w.dropped, 1)
+			countDropped(DropOverflow)
+			w.release()
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of records dropped by the drop oldest policy
+func (w *asyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Drain waits until the buffered records are written
+func (w *asyncWriter) Drain() {
+	w.drained.L.Lock()
+	defer w.drained.L.Unlock()
+	for atomic.LoadInt64(&w.pending) > 0 {
+		w.drained.Wait()
+	}
+}
+
+// Sync drains the buffer then flushes or syncs the writer, zap syncs its
+// outputs when the logger is synced
+func (w *asyncWriter) Sync() error {
+	w.Drain()
+	switch out := w.out.(type) {
+	case interface{ Sync() error }:
+		return out.Sync()
+	case flusher:
+		return out.Flush(0)
+	}
+	return nil
+}
+
+// Close drains the buffer and stops the goroutine
+// The writer is not closed, it is closed by its owner
+func (w *asyncWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+
+	w.Drain()
+	close(w.records)
+	<-w.done
+	return nil
+}
+
+// asyncOutput wraps the writer in an async writer if enabled
+func (o *logOutputs) asyncOutput(w io.Writer,
+	config LoggerConfiguration) io.Writer {
+
+	if !config.EnableAsync {
+		return w
+	}
+	async := newAsyncWriter(w, config.AsyncBufferSize, config.AsyncPolicy)
+	o.asyncs = append(o.asyncs, async)
+	return async
+}
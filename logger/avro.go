@@ -0,0 +1,382 @@
+package logger
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Avro types of the schemas
+const (
+	avroNull    = "null"
+	avroBoolean = "boolean"
+	avroInt     = "int"
+	avroLong    = "long"
+	avroFloat   = "float"
+	avroDouble  = "double"
+	avroBytes   = "bytes"
+	avroString  = "string"
+	avroRecord  = "record"
+	avroEnum    = "enum"
+	avroArray   = "array"
+	avroMap     = "map"
+	avroUnion   = "union"
+)
+
+// avroSchema provides a parsed avro schema the records are encoded with
+type avroSchema struct {
+	kind     string
+	fields   []avroField   // record
+	items    *avroSchema   // array items and map values
+	symbols  []string      // enum
+	branches []*avroSchema // union
+}
+
+// avroField provides a record field, encoded from the record key of its name
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	def        interface{}
+	hasDefault bool
+}
+
+// parseAvroSchema parses the schema, fixed types are not supported
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(schema), &decoded); err != nil {
+		return nil, fmt.Errorf("Invalid avro schema: %s", err.Error())
+	}
+	return newAvroSchema(decoded, "", map[string]*avroSchema{})
+}
+
+// newAvroSchema returns the schema of the decoded JSON definition, named
+// types are kept by full name to be referenced by later definitions
+func newAvroSchema(decoded interface{}, namespace string,
+	named map[string]*avroSchema) (*avroSchema, error) {
+
+	switch d := decoded.(type) {
+	case string:
+		switch d {
+		case avroNull, avroBoolean, avroInt, avroLong, avroFloat,
+			avroDouble, avroBytes, avroString:
+			return &avroSchema{kind: d}, nil
+		}
+		if s, ok := named[avroFullName(d, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := named[d]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Unknown avro type: %s", d)
+
+	case []interface{}:
+		union := &avroSchema{kind: avroUnion}
+		for _, branch := range d {
+			s, err := newAvroSchema(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, s)
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		kind, _ := d["type"].(string)
+		switch kind {
+		case avroRecord, avroEnum:
+			name, _ := d["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("Avro %s missing name", kind)
+			}
+			if ns, ok := d["namespace"].(string); ok {
+				namespace = ns
+			}
+			name = avroFullName(name, namespace)
+			if i := strings.LastIndexByte(name, '.'); i != -1 {
+				namespace = name[:i]
+			}
+			s := &avroSchema{kind: kind}
+			named[name] = s
+			if kind == avroEnum {
+				symbols, _ := d["symbols"].([]interface{})
+				for _, symbol := range symbols {
+					str, ok := symbol.(string)
+					if !ok {
+						return nil, fmt.Errorf("Invalid avro enum symbol: %v", symbol)
+					}
+					s.symbols = append(s.symbols, str)
+				}
+				return s, nil
+			}
+			fields, _ := d["fields"].([]interface{})
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				fieldName, _ := field["name"].(string)
+				if fieldName == "" {
+					return nil, fmt.Errorf("Avro record %s field missing name", name)
+				}
+				fs, err := newAvroSchema(field["type"], namespace, named)
+				if err != nil {
+					return nil, err
+				}
+				def, hasDefault := field["default"]
+				s.fields = append(s.fields, avroField{name: fieldName,
+					schema: fs, def: def, hasDefault: hasDefault})
+			}
+			return s, nil
+
+		case avroArray, avroMap:
+			key := "items"
+			if kind == avroMap {
+				key = "values"
+			}
+			items, err := newAvroSchema(d[key], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			return &avroSchema{kind: kind, items: items}, nil
+		}
+		// primitive types may be given as objects
+		return newAvroSchema(d["type"], namespace, named)
+	}
+	return nil, fmt.Errorf("Invalid avro schema: %v", decoded)
+}
+
+// avroFullName returns the name qualified by the namespace unless it is
+func avroFullName(name, namespace string) string {
+	if strings.ContainsRune(name, '.') || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// encode appends the avro binary encoding of the value
+// Map keys are sorted so the encoding is deterministic, values without a
+// matching type are encoded as JSON strings where a string is accepted
+func (s *avroSchema) encode(buf []byte, value interface{}) ([]byte, error) {
+	switch s.kind {
+	case avroNull:
+		if value != nil {
+			return nil, fmt.Errorf("Avro null given %v", value)
+		}
+		return buf, nil
+
+	case avroBoolean:
+		v, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Avro boolean given %v", value)
+		}
+		if v {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+
+	case avroInt, avroLong:
+		v, ok := avroNumber(value)
+		if !ok || v != math.Trunc(v) {
+			return nil, fmt.Errorf("Avro %s given %v", s.kind, value)
+		}
+		return avroAppendLong(buf, int64(v)), nil
+
+	case avroFloat:
+		v, ok := avroNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("Avro float given %v", value)
+		}
+		var bits [4]byte
+		binary.LittleEndian.PutUint32(bits[:], math.Float32bits(float32(v)))
+		return append(buf, bits[:]...), nil
+
+	case avroDouble:
+		v, ok := avroNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("Avro double given %v", value)
+		}
+		var bits [8]byte
+		binary.LittleEndian.PutUint64(bits[:], math.Float64bits(v))
+		return append(buf, bits[:]...), nil
+
+	case avroBytes, avroString:
+		v, ok := value.(string)
+		if !ok {
+			nested, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			v = string(nested)
+		}
+		return avroAppendString(buf, v), nil
+
+	case avroEnum:
+		v, _ := value.(string)
+		for i, symbol := range s.symbols {
+			if symbol == v {
+				return avroAppendLong(buf, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("Avro enum symbol not found: %v", value)
+
+	case avroArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Avro array given %v", value)
+		}
+		if len(items) > 0 {
+			buf = avroAppendLong(buf, int64(len(items)))
+		}
+		for _, item := range items {
+			var err error
+			if buf, err = s.items.encode(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		// zero count terminates the blocks
+		return avroAppendLong(buf, 0), nil
+
+	case avroMap:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Avro map given %v", value)
+		}
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			buf = avroAppendLong(buf, int64(len(keys)))
+		}
+		for _, key := range keys {
+			buf = avroAppendString(buf, key)
+			var err error
+			if buf, err = s.items.encode(buf, m[key]); err != nil {
+				return nil, err
+			}
+		}
+		return avroAppendLong(buf, 0), nil
+
+	case avroRecord:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Avro record given %v", value)
+		}
+		for _, field := range s.fields {
+			fieldValue, ok := m[field.name]
+			if !ok {
+				if !field.hasDefault && !field.schema.accepts(nil) {
+					return nil, fmt.Errorf("Avro record field missing: %s",
+						field.name)
+				}
+				fieldValue = field.def
+			}
+			var err error
+			if buf, err = field.schema.encode(buf, fieldValue); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+
+	case avroUnion:
+		index := s.branch(value)
+		if index == -1 {
+			return nil, fmt.Errorf("Avro union has no type for %v", value)
+		}
+		buf = avroAppendLong(buf, int64(index))
+		return s.branches[index].encode(buf, value)
+	}
+	return nil, fmt.Errorf("Unsupported avro type: %s", s.kind)
+}
+
+// branch returns the index of the first union type accepting the value,
+// else of the first string type, -1 if none
+func (s *avroSchema) branch(value interface{}) int {
+	for i, b := range s.branches {
+		if b.accepts(value) {
+			return i
+		}
+	}
+	for i, b := range s.branches {
+		if b.kind == avroString {
+			return i
+		}
+	}
+	return -1
+}
+
+// accepts returns true if the value is of the type, integral numbers are
+// accepted by int and long and all numbers by float and double
+func (s *avroSchema) accepts(value interface{}) bool {
+	switch s.kind {
+	case avroNull:
+		return value == nil
+	case avroBoolean:
+		_, ok := value.(bool)
+		return ok
+	case avroInt, avroLong:
+		v, ok := avroNumber(value)
+		return ok && v == math.Trunc(v) && math.Abs(v) < 1<<53
+	case avroFloat, avroDouble:
+		_, ok := avroNumber(value)
+		return ok
+	case avroBytes, avroString:
+		_, ok := value.(string)
+		return ok
+	case avroEnum:
+		v, ok := value.(string)
+		for _, symbol := range s.symbols {
+			if ok && symbol == v {
+				return true
+			}
+		}
+		return false
+	case avroArray:
+		_, ok := value.([]interface{})
+		return ok
+	case avroMap, avroRecord:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case avroUnion:
+		for _, b := range s.branches {
+			if b.accepts(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// avroNumber returns the value of a JSON decoded or native number
+func avroNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// avroAppendLong appends a zigzag varint encoded long
+func avroAppendLong(buf []byte, n int64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	size := binary.PutVarint(varint[:], n)
+	return append(buf, varint[:size]...)
+}
+
+// avroAppendString appends a length prefixed string
+func avroAppendString(buf []byte, s string) []byte {
+	buf = avroAppendLong(buf, int64(len(s)))
+	return append(buf, s...)
+}
@@ -0,0 +1,222 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// AWSCredentialsConfiguration provides the credentials of the aws sinks
+// The static keys are used if set, otherwise the default credentials chain
+// of the AWS SDK: environment, shared config and credentials files, web
+// identity (AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN), container then
+// instance role
+type AWSCredentialsConfiguration struct {
+	AccessKeyID     string
+	SecretAccessKey string `secret:"true"`
+	SessionToken    string `secret:"true"`
+	Profile         string // shared config profile, AWS_PROFILE or default
+}
+
+// ErrAWSCredentials is wrapped by the error returned when no credentials
+// are found in the chain
+var ErrAWSCredentials = errors.New("AWS credentials not found")
+
+// newAWSCredentials returns the provider of the configured keys or of the
+// default chain, credentials of the chain are cached until they expire
+func newAWSCredentials(config AWSCredentialsConfiguration,
+	region string) (aws.CredentialsProvider, error) {
+	if config.AccessKeyID != "" {
+		return credentials.NewStaticCredentialsProvider(config.AccessKeyID,
+			config.SecretAccessKey, config.SessionToken), nil
+	}
+	options := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(region),
+	}
+	if config.Profile != "" {
+		options = append(options,
+			awsconfig.WithSharedConfigProfile(config.Profile))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		options...)
+	if err != nil {
+		return nil, err
+	}
+	if awsCfg.Credentials == nil {
+		return nil, ErrAWSCredentials
+	}
+	return awsCfg.Credentials, nil
+}
+
+// awsRegion returns the region, AWS_REGION or AWS_DEFAULT_REGION if not set
+func awsRegion(region string) string {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return region
+}
+
+// awsError is returned for requests not accepted by the service
+type awsError struct {
+	status  int
+	code    string
+	message string
+}
+
+// Error meets the interface for the error
+func (e *awsError) Error() string {
+	return "AWS request failed: " + strconv.Itoa(e.status) + " " + e.code +
+		": " + e.message
+}
+
+// awsThrottlingCodes are the error codes of throttled requests
+var awsThrottlingCodes = map[string]bool{
+	"ThrottlingException":                    true,
+	"ProvisionedThroughputExceededException": true,
+	"RequestThrottled":                       true,
+	"KMSThrottlingException":                 true,
+}
+
+// awsRetryable returns true if the request may succeed when sent again
+// Requests rejected by the service are not retried unless throttled
+func awsRetryable(err error) bool {
+	awsErr, ok := err.(*awsError)
+	return !ok || awsErr.status >= http.StatusInternalServerError ||
+		awsErr.status == http.StatusTooManyRequests ||
+		awsThrottlingCodes[awsErr.code]
+}
+
+// awsClient provides calls to the JSON protocol API of an aws service
+type awsClient struct {
+	service     string // signing name
+	region      string
+	endpoint    string
+	contentType string
+	client      *http.Client
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+}
+
+// newAWSClient returns an aws client instance, endpoint defaults to the
+// regional endpoint of the service
+func newAWSClient(service, region, endpoint, contentType string,
+	credentials AWSCredentialsConfiguration,
+	timeout time.Duration) (*awsClient, error) {
+	region = awsRegion(region)
+	if region == "" {
+		return nil, errors.New("AWS region not set for " + service)
+	}
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + region + ".amazonaws.com"
+	}
+	provider, err := newAWSCredentials(credentials, region)
+	if err != nil {
+		return nil, err
+	}
+	return &awsClient{
+		service:     service,
+		region:      region,
+		endpoint:    endpoint,
+		contentType: contentType,
+		client:      &http.Client{Timeout: timeout},
+		credentials: provider,
+		signer:      v4.NewSigner(),
+	}, nil
+}
+
+// call sends the input to the target operation and decodes the output
+func (c *awsClient) call(target string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if c.client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.client.Timeout)
+		defer cancel()
+	}
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAWSCredentials, err.Error())
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", c.contentType)
+	req.Header.Set("X-Amz-Target", target)
+	payloadHash := sha256.Sum256(body)
+	err = c.signer.SignHTTP(ctx, creds, req,
+		hex.EncodeToString(payloadHash[:]), c.service, c.region, time.Now())
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Type         string `json:"__type"`
+			Message      string `json:"message"`
+			MessageUpper string `json:"Message"`
+		}
+		json.Unmarshal(data, &failure)
+		code := failure.Type[strings.LastIndexByte(failure.Type, '#')+1:]
+		if failure.Message == "" {
+			failure.Message = failure.MessageUpper
+		}
+		return &awsError{resp.StatusCode, code, failure.Message}
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
+
+// awsRecordKey returns the partition key of the record for the key type
+// with the semantics of the kafka keys, the level if the extracted field is
+// missing or the key type is FunctionKey
+func awsRecordKey(keyType kafkaKeyType, keyName string, record sinkRecord) string {
+	switch keyType {
+	case FixedKey:
+		if keyName != "" {
+			return keyName
+		}
+	case ExtractedKey:
+		if value, ok := recordMember(record.line, keyName); ok && value != "" {
+			return value
+		}
+	case TimeSecondKey:
+		return strconv.FormatInt(record.entry.Time.Unix(), 10)
+	case TimeNanoSecondKey:
+		return strconv.FormatInt(record.entry.Time.UnixNano(), 10)
+	}
+	return string(record.entry.Level)
+}
@@ -0,0 +1,251 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// sinkRecord provides a record waiting to be sent
+type sinkRecord struct {
+	entry Entry
+	line  []byte
+}
+
+// partialError is returned when some records of a batch failed, only the
+// failed records are retried
+type partialError struct {
+	failed []sinkRecord
+	err    error
+}
+
+// Error meets the interface for the error
+func (e *partialError) Error() string {
+	return e.err.Error()
+}
+
+// maxQueuedBatches bounds the records written and not yet sent to this
+// number of batches, further writes wait for the sender
+const maxQueuedBatches = 4
+
+// batchSink provides batching of records sent in the background
+// Records are sent in batches of batchSize or every flushInterval by post,
+// failed batches are retried as by the retry policy
+// Batches are queued in the order written and sent by a single sender,
+// writes waiting longer than writeTimeout for the queue are dropped
+type batchSink struct {
+	name          string // sink name of the timeout counter
+	batchSize     int
+	flushInterval time.Duration
+	writeTimeout  time.Duration // 0 waits until queued
+	retry         RetryPolicy
+	post          func(batch []sinkRecord) error
+	closedErr     error // returned when writing once closed
+	mutex         sync.Mutex
+	batch         []sinkRecord
+	queue         [][]sinkRecord // batches in the order to be sent
+	slots         chan struct{}  // one per record queued or partial
+	queued        chan struct{}  // signalled when a batch is queued
+	sent          sync.Cond      // signalled when a batch is sent or failed
+	pending       int            // records not yet sent or failed
+	done          chan struct{}
+	stopped       chan struct{}
+	closeMutex    sync.RWMutex // held for reading while queueing batches
+	closed        bool
+}
+
+// start initializes the batch sink and sends in the background
+func (s *batchSink) start() {
+	s.slots = make(chan struct{}, s.batchSize*maxQueuedBatches)
+	s.queued = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+	s.sent.L = &s.mutex
+	go s.run()
+}
+
+// Write adds the record to the batch, a full batch is queued to be sent
+// The record is dropped if the queue is full for longer than writeTimeout
+func (s *batchSink) Write(msg []byte, entry Entry) error {
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+	if s.closed {
+		countDropped(DropClosed)
+		return s.closedErr
+	}
+
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	line := bytes.TrimRight(msg, "\n")
+	s.mutex.Lock()
+	s.batch = append(s.batch, sinkRecord{entry, append([]byte{}, line...)})
+	s.pending++
+	if len(s.batch) >= s.batchSize {
+		s.enqueue()
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+// acquire reserves the queue slot of a record, waiting up to writeTimeout
+func (s *batchSink) acquire() error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if s.writeTimeout <= 0 {
+		s.slots <- struct{}{}
+		return nil
+	}
+	timer := time.NewTimer(s.writeTimeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		countTimeout(s.name)
+		countDropped(DropTimeout)
+		return ErrWriteTimeout
+	}
+}
+
+// enqueue queues the partial batch after the batches written before it and
+// signals the sender, the mutex must be held
+func (s *batchSink) enqueue() {
+	if len(s.batch) == 0 {
+		return
+	}
+	s.queue = append(s.queue, s.batch)
+	s.batch = nil
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the first queued batch, nil if none
+func (s *batchSink) next() []sinkRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.queue) == 0 {
+		return nil
+	}
+	batch := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return batch
+}
+
+// run sends the queued batches in order, the partial batch is queued every
+// flush interval
+func (s *batchSink) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.queued:
+		case <-ticker.C:
+			s.mutex.Lock()
+			s.enqueue()
+			s.mutex.Unlock()
+		case <-s.done:
+			s.mutex.Lock()
+			s.enqueue()
+			s.mutex.Unlock()
+			for batch := s.next(); batch != nil; batch = s.next() {
+				s.send(batch)
+			}
+			return
+		}
+		for batch := s.next(); batch != nil; batch = s.next() {
+			s.send(batch)
+		}
+	}
+}
+
+// send posts the batch with retries, failed records are dropped
+func (s *batchSink) send(batch []sinkRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	size := len(batch)
+	failed := batch
+	err := s.post(failed)
+	for attempt := 0; s.retry.ShouldRetry(attempt, err); attempt++ {
+		if partial, ok := err.(*partialError); ok {
+			failed = partial.failed
+		}
+		// retries are made without delay once closing
+		timer := time.NewTimer(s.retry.Delay(attempt))
+		select {
+		case <-timer.C:
+		case <-s.done:
+		}
+		timer.Stop()
+		err = s.post(failed)
+	}
+	if err != nil {
+		recordError(err)
+		if partial, ok := err.(*partialError); ok {
+			failed = partial.failed
+		}
+		for range failed {
+			countDropped(DropDelivery)
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		<-s.slots
+	}
+	s.mutex.Lock()
+	s.pending -= size
+	s.sent.Broadcast()
+	s.mutex.Unlock()
+}
+
+// Flush waits until the records written are sent or failed
+// A timeout of zero waits without limit
+func (s *batchSink) Flush(timeout time.Duration) error {
+	s.mutex.Lock()
+	s.enqueue()
+	s.mutex.Unlock()
+
+	expired := false
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			s.mutex.Lock()
+			expired = true
+			s.sent.Broadcast()
+			s.mutex.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.pending > 0 && !expired {
+		s.sent.Wait()
+	}
+	if s.pending > 0 {
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
+// Close sends the records written and stops the sink
+func (s *batchSink) Close() error {
+	s.closeMutex.Lock()
+	if s.closed {
+		s.closeMutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.closeMutex.Unlock()
+
+	close(s.done)
+	<-s.stopped
+	return nil
+}
@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zapcore"
+)
+
+// Keys of the caller fields added to records
+const (
+	CallerKey         = "caller"   // file:line
+	CallerFunctionKey = "function" // package qualified function name
+)
+
+// reloadLoggerPrefix is the function prefix of the package logger methods
+var reloadLoggerPrefix = packagePath + ".(*reloadLogger)"
+
+// wrapperFunctions are the package level logging functions
+var wrapperFunctions = []string{
+	"Print", "Debug", "Info", "Warn", "Error", "Fatal", "Panic",
+}
+
+// callerPrefixes returns the function prefixes of the logging call frames
+// of the backend package and of the logger methods and package functions
+func callerPrefixes(backend ...string) []string {
+	prefixes := append([]string{}, backend...)
+	for _, name := range wrapperFunctions {
+		prefixes = append(prefixes, packagePath+"."+name)
+	}
+	return append(prefixes, reloadLoggerPrefix)
+}
+
+var (
+	zapCallerPrefixes = callerPrefixes("go.uber.org/zap",
+		packagePath+".(*zapCallerCore)", packagePath+".(*zapLogger)")
+	logrusCallerPrefixes = callerPrefixes("github.com/sirupsen/logrus.",
+		packagePath+".(*LogrusCallerHook)", packagePath+".(*logrusLog")
+	slogCallerPrefixes = callerPrefixes("log/slog.",
+		packagePath+".(*slogCallerHandler)", packagePath+".(*slogLogger)")
+)
+
+// callerFrame returns the frame of the logging call, the first frame not
+// matching the prefixes, then skipping skip frames
+func callerFrame(skip int, prefixes []string) (runtime.Frame, bool) {
+	pcs := make([]uintptr, maxStackDepth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	leading := true
+	for {
+		frame, more := frames.Next()
+		if leading && hasAnyPrefix(frame.Function, prefixes) {
+			if !more {
+				return runtime.Frame{}, false
+			}
+			continue
+		}
+		leading = false
+		if skip <= 0 {
+			return frame, true
+		}
+		skip--
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
+
+// shortCaller returns the file:line of the caller with the file trimmed to
+// its package directory, as in the zap short caller encoding
+func shortCaller(file string, line int) string {
+	if idx := strings.LastIndexByte(file, '/'); idx >= 0 {
+		if idx = strings.LastIndexByte(file[:idx], '/'); idx >= 0 {
+			file = file[idx+1:]
+		}
+	}
+	return file + ":" + strconv.Itoa(line)
+}
+
+// zapCallerCore sets the caller of entries written from the logging call
+type zapCallerCore struct {
+	zapcore.Core
+	skip int
+}
+
+// With adds the fields to the wrapped core
+func (c *zapCallerCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapCallerCore{Core: c.Core.With(fields), skip: c.skip}
+}
+
+// Check adds the core if the entry level is enabled
+func (c *zapCallerCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+	return checked.AddCore(entry, c)
+}
+
+// Write sets the caller then writes to the cores enabled for the entry
+func (c *zapCallerCore) Write(entry zapcore.Entry,
+	fields []zapcore.Field) error {
+	if frame, ok := callerFrame(c.skip, zapCallerPrefixes); ok {
+		entry.Caller = zapcore.NewEntryCaller(frame.PC, frame.File,
+			frame.Line, true)
+		entry.Caller.Function = frame.Function
+	}
+	if downstream := c.Core.Check(entry, nil); downstream != nil {
+		downstream.Write(fields...)
+	}
+	return nil
+}
+
+// LogrusCallerHook provides a hook setting the caller of the entry to the
+// logging call rather than the logger methods
+type LogrusCallerHook struct {
+	skip int
+}
+
+// Levels returns all log levels
+func (h *LogrusCallerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire sets the caller of the entry
+func (h *LogrusCallerHook) Fire(entry *logrus.Entry) error {
+	if frame, ok := callerFrame(h.skip, logrusCallerPrefixes); ok {
+		entry.Caller = &frame
+	}
+	return nil
+}
+
+// slogCallerHandler sets the source of records to the logging call rather
+// than the logger methods
+type slogCallerHandler struct {
+	slog.Handler
+	skip int
+}
+
+// Handle sets the program counter of the record to the logging call
+func (h *slogCallerHandler) Handle(ctx context.Context,
+	record slog.Record) error {
+	if frame, ok := callerFrame(h.skip, slogCallerPrefixes); ok {
+		// slog expects a return address as from runtime.Callers, the frame
+		// program counter is that of the call instruction
+		record.PC = frame.PC + 1
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs meets the interface for the slog handler
+func (h *slogCallerHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogCallerHandler{Handler: h.Handler.WithAttrs(attrs), skip: h.skip}
+}
+
+// WithGroup meets the interface for the slog handler
+func (h *slogCallerHandler) WithGroup(name string) slog.Handler {
+	return &slogCallerHandler{Handler: h.Handler.WithGroup(name), skip: h.skip}
+}
+
+// slogCallerAttr returns the source of the record as the zap caller fields
+func slogCallerAttr(source *slog.Source) slog.Attr {
+	return slog.Attr{Value: slog.GroupValue(
+		slog.String(CallerKey, shortCaller(source.File, source.Line)),
+		slog.String(CallerFunctionKey, source.Function))}
+}
+
+// logrusCallerPrettyfier formats the caller as the zap caller fields
+func logrusCallerPrettyfier(frame *runtime.Frame) (string, string) {
+	return frame.Function, shortCaller(frame.File, frame.Line)
+}
@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time for timestamps and kafka keys
+// Replaced by a fixed or manual clock for deterministic tests
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock provides the system time
+type systemClock struct{}
+
+// Now returns the system time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock provides a clock set manually, safe for concurrent use
+type FixedClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewFixedClock returns a clock instance set to the time
+func NewFixedClock(now time.Time) *FixedClock {
+	return &FixedClock{now: now}
+}
+
+// Now returns the time the clock is set to
+func (c *FixedClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Set sets the clock to the time
+func (c *FixedClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+// Add advances the clock by the duration
+func (c *FixedClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var (
+	clockMutex  sync.RWMutex
+	loggerClock Clock = systemClock{}
+)
+
+// SetClock sets the clock used by all loggers, nil restores the system clock
+func SetClock(clock Clock) {
+	clockMutex.Lock()
+	defer clockMutex.Unlock()
+	if clock == nil {
+		clock = systemClock{}
+	}
+	loggerClock = clock
+}
+
+// now returns the current time from the logger clock
+func now() time.Time {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+	return loggerClock.Now()
+}
@@ -1,11 +1,21 @@
 package logger
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gofrs/uuid"
 )
@@ -26,9 +36,14 @@ type CloudEventsConfiguration struct {
 	SetID           ceSetIDType
 	HMACKey         string
 	Source          string
+	DeriveSource    bool
+	ServiceName     string
 	SpecVersion     string
 	Type            string
+	LevelTypes      map[LevelType]string
+	EventTypes      map[string]string
 	SetSubjectLevel bool
+	Extensions      map[string]string // extension attributes of every event
 }
 
 // Keys for cloudevents fields, values must be non-empty strings
@@ -44,6 +59,65 @@ const (
 	CEDataKey         = "data"            // Optional - no specific format
 )
 
+// Keys for the cloudevents distributed tracing extension attributes
+const (
+	CETraceParentKey = "traceparent" // W3C trace context traceparent
+	CETraceStateKey  = "tracestate"  // W3C trace context tracestate
+)
+
+// ceExtensionName matches valid extension attribute names
+var ceExtensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// ceCoreAttributes are the attributes that cannot be extensions
+var ceCoreAttributes = map[string]bool{
+	CEIDKey: true, CESourceKey: true, CESpecVersionKey: true, CETypeKey: true,
+	CEDataContentType: true, CEDataSchemaKey: true, CESubjectKey: true,
+	CETimeKey: true, CEDataKey: true,
+}
+
+// CEExtensionFunc returns extension attributes for the record
+// Example: func(r map[string]interface{}) map[string]string {
+// return map[string]string{"tenant": r["tenant"].(string)} }
+type CEExtensionFunc func(record map[string]interface{}) map[string]string
+
+var (
+	ceExtensionMutex sync.RWMutex
+	ceExtensionFuncs []CEExtensionFunc
+	ceExtensionCount int32 // read without the mutex by the kafka fast path
+)
+
+// RegisterCEExtension adds a function returning extension attributes for
+// each cloudevents record, applied in order of registration after the
+// configured extensions, invalid names are ignored
+func RegisterCEExtension(fn CEExtensionFunc) {
+	ceExtensionMutex.Lock()
+	defer ceExtensionMutex.Unlock()
+	ceExtensionFuncs = append(ceExtensionFuncs, fn)
+	atomic.StoreInt32(&ceExtensionCount, int32(len(ceExtensionFuncs)))
+}
+
+// ceExtensionsRegistered returns true if extension functions are registered
+func ceExtensionsRegistered() bool {
+	return atomic.LoadInt32(&ceExtensionCount) > 0
+}
+
+// Cloudevents kafka protocol binding binary mode headers
+const (
+	CEHeaderPrefix      = "ce_"
+	CEContentTypeHeader = "content-type"
+	CEJSONContentType   = "application/json"
+)
+
+// EventNameKey is the record field that selects a type from EventTypes
+// Example: WithFields(LogFields{EventNameKey: "access"})
+const EventNameKey = "event"
+
+// Derived source settings, pod name falls back to the hostname
+const (
+	CESourcePrefix = "//pavedroad.io/"
+	PodNameEnvName = "POD_NAME"
+)
+
 type incrementalFn func() string
 
 // CloudEvents provides the cloudevents object type
@@ -51,29 +125,52 @@ type CloudEvents struct {
 	config           CloudEventsConfiguration
 	fields           LogFields
 	genIncrementalID incrementalFn
-	hmacHash         hash.Hash
+	hmacPool         sync.Pool
+	levelKey         string
 }
 
-// incrementalID returns function that returns IDs starting with zero
+// incrementalID returns function that returns IDs starting with one
+// The returned function is safe for concurrent use
 func incrementalID() func() string {
 	var i uint64
 	return func() string {
-		i++
-		return fmt.Sprintf("%020d", i)
+		return fmt.Sprintf("%020d", atomic.AddUint64(&i, 1))
 	}
 }
 
+// deriveSource returns a source of the form //pavedroad.io/{service}/{pod}
+func deriveSource(service string) string {
+	if service == "" {
+		service = filepath.Base(os.Args[0])
+	}
+	pod := os.Getenv(PodNameEnvName)
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	if pod == "" {
+		return CESourcePrefix + service
+	}
+	return CESourcePrefix + service + "/" + pod
+}
+
 // newCloudEvents returns a cloudevents instance
-func newCloudEvents(config CloudEventsConfiguration) *CloudEvents {
+func newCloudEvents(config CloudEventsConfiguration,
+	fieldMap FieldMap) *CloudEvents {
 	// use passed configuration and replace empty strings with defaults
 	ce := CloudEvents{
-		config: config,
+		config:   config,
+		levelKey: fieldMap.resolve(FieldKeyLevel),
+	}
+	if config.SetSubjectLevel {
+		ce.levelKey = CESubjectKey
 	}
 	// cloudevents fields must contain non-empty strings
 	if config.HMACKey == "" {
 		ce.config.HMACKey = defaultCloudEventsConfiguration.HMACKey
 	}
-	if config.Source == "" {
+	if config.DeriveSource {
+		ce.config.Source = deriveSource(config.ServiceName)
+	} else if config.Source == "" {
 		ce.config.Source = defaultCloudEventsConfiguration.Source
 	}
 	if config.SpecVersion == "" {
@@ -87,6 +184,9 @@ func newCloudEvents(config CloudEventsConfiguration) *CloudEvents {
 	fields[CESourceKey] = ce.config.Source
 	fields[CESpecVersionKey] = ce.config.SpecVersion
 	fields[CETypeKey] = ce.config.Type
+	for name, value := range config.Extensions {
+		fields[name] = value
+	}
 	ce.fields = fields
 
 	switch config.SetID {
@@ -95,14 +195,44 @@ func newCloudEvents(config CloudEventsConfiguration) *CloudEvents {
 	case CEHMAC:
 		fallthrough
 	default:
-		key := []byte(config.HMACKey)
-		ce.hmacHash = hmac.New(sha256.New, key)
+		// hmac hashes are not safe for concurrent use so pool them
+		key := []byte(ce.config.HMACKey)
+		ce.hmacPool.New = func() interface{} {
+			return hmac.New(sha256.New, key)
+		}
 	}
 	return &ce
 }
 
+// ceSourceContextKey is the context key for the source set by WithCESource
+const ceSourceContextKey contextKey = "cesource"
+
+// contextCESource returns the cloudevents source carried by the context
+// Returns an empty string if the configured source is used
+func contextCESource(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	source, _ := ctx.Value(ceSourceContextKey).(string)
+	return source
+}
+
+// withCESource returns the context carrying the cloudevents source
+func withCESource(ctx context.Context, source string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ceSourceContextKey, source)
+}
+
 // ceGetID returns the cloudevents id field for the message
 func (ce *CloudEvents) ceGetID(msgMap map[string]interface{}) (string, error) {
+	data, _ := msgMap[string(CEDataKey)].(string)
+	return ce.ceDataID([]byte(data))
+}
+
+// ceDataID returns the cloudevents id field for the message data
+func (ce *CloudEvents) ceDataID(data []byte) (string, error) {
 	switch ce.config.SetID {
 	case CEFuncID:
 		// set when using FilterFn or WithFields to supply id
@@ -118,22 +248,148 @@ func (ce *CloudEvents) ceGetID(msgMap map[string]interface{}) (string, error) {
 	case CEHMAC:
 		fallthrough
 	default:
-		ce.hmacHash.Write([]byte(msgMap[string(CEDataKey)].(string)))
-		id := base64.StdEncoding.EncodeToString(ce.hmacHash.Sum(nil))
+		// signature of the message data only, identical data same id
+		hmacHash := ce.hmacPool.Get().(hash.Hash)
+		hmacHash.Reset()
+		hmacHash.Write(data)
+		id := base64.StdEncoding.EncodeToString(hmacHash.Sum(nil))
+		ce.hmacPool.Put(hmacHash)
 		return id, nil
 	}
 }
 
-// ceAddFields adds the cloudevents id field to the message
-func (ce *CloudEvents) ceAddFields(msgMap map[string]interface{}) error {
-	// Other cloudevents fields could be added here based on config
+// ceGetType returns the cloudevents type for the event name or log level
+// Returns an empty string if neither is mapped, leaving the global type
+func (ce *CloudEvents) ceGetType(msgMap map[string]interface{}) string {
+	event, _ := msgMap[EventNameKey].(string)
+	level, _ := msgMap[ce.levelKey].(string)
+	return ce.ceTypeOf(event, level)
+}
+
+// ceTypeOf returns the cloudevents type for the event name or log level
+// An empty event name or level is not mapped
+func (ce *CloudEvents) ceTypeOf(event, level string) string {
+	if event != "" {
+		if ceType, ok := ce.config.EventTypes[event]; ok {
+			return ceType
+		}
+	}
+	if level != "" {
+		// logrus spells out the warning level
+		level = strings.ToLower(level)
+		if level == "warning" {
+			level = string(WarnType)
+		}
+		if ceType, ok := ce.config.LevelTypes[LevelType(level)]; ok {
+			return ceType
+		}
+	}
+	return ""
+}
+
+// ceAddFields adds the cloudevents id, mapped type and extension fields to
+// the message, returns the names of the extension attributes in the message
+func (ce *CloudEvents) ceAddFields(msgMap map[string]interface{}) ([]string, error) {
+	if ceType := ce.ceGetType(msgMap); ceType != "" {
+		msgMap[string(CETypeKey)] = ceType
+	}
 
 	id, err := ce.ceGetID(msgMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if id != "" {
 		msgMap[string(CEIDKey)] = id
 	}
-	return nil
+	return ce.ceAddExtensions(msgMap), nil
+}
+
+// ceAddExtensions adds the extension attributes of the registered functions
+// to the message, returns the names of the extension attributes in the
+// message, the configured and tracing extensions included, in order
+func (ce *CloudEvents) ceAddExtensions(msgMap map[string]interface{}) []string {
+	names := map[string]bool{}
+	for name := range ce.config.Extensions {
+		names[name] = true
+	}
+	for _, name := range []string{CETraceParentKey, CETraceStateKey} {
+		if _, ok := msgMap[name].(string); ok {
+			names[name] = true
+		}
+	}
+
+	if ceExtensionsRegistered() {
+		ceExtensionMutex.RLock()
+		for _, fn := range ceExtensionFuncs {
+			for name, value := range fn(msgMap) {
+				if validCEExtension(name) && value != "" {
+					msgMap[name] = value
+					names[name] = true
+				}
+			}
+		}
+		ceExtensionMutex.RUnlock()
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// validCEExtension returns true if the name is a valid extension attribute
+func validCEExtension(name string) bool {
+	return ceExtensionName.MatchString(name) && !ceCoreAttributes[name]
+}
+
+// ceWriter provides an io.Writer that adds the cloudevents id field
+// Used for console and file output, kafka adds the id in sendMessage
+type ceWriter struct {
+	out io.Writer
+	ce  *CloudEvents
+}
+
+// newCEWriter returns a cloudevents writer instance
+func newCEWriter(out io.Writer, cloudEvents *CloudEvents) *ceWriter {
+	return &ceWriter{
+		out: out,
+		ce:  cloudEvents,
+	}
+}
+
+// ceTransform returns the JSON record with the cloudevents id field added
+func (ce *CloudEvents) ceTransform(msg []byte) ([]byte, error) {
+	var msgMap map[string]interface{}
+
+	err := json.Unmarshal(msg, &msgMap)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ce.ceAddFields(msgMap)
+	if err != nil {
+		return nil, err
+	}
+
+	newmsg, err := json.Marshal(msgMap)
+	if err != nil {
+		return nil, err
+	}
+	return append(newmsg, '\n'), nil
+}
+
+// Write adds the cloudevents id field to the record before writing it
+func (w *ceWriter) Write(msg []byte) (int, error) {
+	newmsg, err := w.ce.ceTransform(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.out.Write(newmsg)
+	if err != nil {
+		return 0, err
+	}
+	return len(msg), nil
 }
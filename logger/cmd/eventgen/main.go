@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pavedroad-io/go-core/logger/eventgen"
+)
+
+// Generate typed event constructors from YAML event definitions
+// Example: //go:generate go run github.com/pavedroad-io/go-core/logger/cmd/eventgen -in events.yaml
+
+func main() {
+	in := flag.String("in", "events.yaml", "event definitions file")
+	out := flag.String("out", "", "generated file, <in>.go if empty")
+	flag.Parse()
+
+	defs, err := eventgen.ParseFile(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read %s: %s\n", *in, err.Error())
+		os.Exit(1)
+	}
+	source, err := eventgen.Generate(defs, filepath.Base(*in))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not generate events: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(*in, filepath.Ext(*in)) + ".go"
+	}
+	if err := ioutil.WriteFile(*out, source, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %s: %s\n", *out, err.Error())
+		os.Exit(1)
+	}
+}
@@ -1,17 +1,26 @@
 package logger
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
 	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 )
@@ -28,18 +37,25 @@ const (
 
 // Supported auto init/config environment names
 const (
-	LogTestInitEnvName = "PRTEST_INIT"
-	LogAutoInitEnvName = "PRLOG_AUTOINIT"
-	ConfigTypeEnvName  = "PRLOG_CFGTYPE"
-	ConfigFileEnvName  = "PRLOG_CFGFILE"
+	LogTestInitEnvName  = "PRTEST_INIT"
+	LogAutoInitEnvName  = "PRLOG_AUTOINIT"
+	ConfigTypeEnvName   = "PRLOG_CFGTYPE"
+	ConfigFileEnvName   = "PRLOG_CFGFILE" // comma separated, later override
+	ProfileEnvName      = "PRLOG_PROFILE"
+	UninitPolicyEnvName = "PRLOG_UNINITPOLICY"
 )
 
+// ProfilesKey is the config file key holding named profiles
+// Example: profiles: {dev: {loglevel: debug}, prod: {enablekafka: true}}
+const ProfilesKey = "profiles"
+
 // Supported environment name prefixes
 const (
 	LogEnvPrefix         = "PRLOG"
 	KafkaEnvPrefix       = "PRKAFKA"
 	CloudEventsEnvPrefix = "PRCE"
 	RotationEnvPrefix    = "PRROT"
+	RetryEnvPrefix       = "PRRETRY" // e.g. PRRETRY_HTTP="attempts=5,jitter=0.2"
 )
 
 // Default config file name without extension
@@ -49,6 +65,9 @@ const (
 	ExportConfigFileName = "pr_export_config.yaml"
 )
 
+// configPaths are searched in order for the config file
+var configPaths = []string{".", "$HOME", "$HOME/.pavedroad.d"}
+
 // Supported error messages
 const (
 	errInvalid     = "Invalid configuration type"
@@ -56,56 +75,183 @@ const (
 	errKafka       = "Could not create kafka configuration"
 	errCloudevents = "Could not create cloudevents configuration"
 	errRotation    = "Could not create rotation configuration"
+	errRetry       = "Could not create retry configuration"
+)
+
+// UninitPolicyType provided to select handling of an uninitialized logger
+type UninitPolicyType string
+
+// Types of uninitialized logger handling
+const (
+	UninitStderr   UninitPolicyType = "stderr" // default, log to stderr
+	UninitAutoInit UninitPolicyType = "autoinit"
+	UninitPanic    UninitPolicyType = "panic"
 )
 
 // logger global for go log pkg emulation
 var logger Logger
 
+// loggerMutex guards the logger global and its uninitialized policy
+var loggerMutex sync.RWMutex
+
+// uninitPolicy selects handling of package level calls before initialization
+var uninitPolicy = UninitStderr
+
+// fallbackLogger global used by the UninitStderr policy
+var fallbackLogger Logger
+var fallbackOnce sync.Once
+
 // debug global for testing auto init
 var debugCapture *os.File
 
 var ErrFatal = errors.New("fatal")
 var ErrNonFatal = errors.New("nonfatal")
+var ErrInitialized = errors.New("Logger already initialized")
 
 var defaultLoggerConfiguration = LoggerConfiguration{
 	LogPackage:        ZapType,
 	LogLevel:          InfoType,
 	EnableTimeStamps:  true,
 	EnableColorLevels: true,
+	ServiceName:       "", // PRLOG_SERVICENAME
+	ServiceVersion:    "", // PRLOG_SERVICEVERSION
+	Environment:       "", // PRLOG_ENVIRONMENT
+	HostName:          "", // PRLOG_HOSTNAME, os.Hostname() with ServiceName
+	EnableStartup:     false,
+	EnableConfigHash:  false,
 	EnableCloudEvents: true,
 	EnableKafka:       false,
 	KafkaFormat:       CEFormat,
+	KafkaLevel:        "", // LogLevel
 	EnableConsole:     false,
 	ConsoleFormat:     TextFormat,
 	ConsoleWriter:     Stdout,
+	ConsoleLevel:      "", // LogLevel
+	PrettyConsole:     false,
 	EnableFile:        true,
 	FileFormat:        JSONFormat,
 	FileLocation:      "pavedroad.log",
+	FileLevel:         "", // LogLevel
+	FileCompression:   CompressionNone,
+	FileRetryPolicy:   RetryPolicy{}, // no retries
 	EnableRotation:    false,
+	EnableSampling:    false,
+	EnableAsync:       false,
+	AsyncBufferSize:   defaultAsyncBufferSize,
+	AsyncPolicy:       AsyncDropOldest,
+	MaxFields:         0,
+	MaxFieldLength:    0,
+	EnableStackTrace:  false,
+	StackTraceLevel:   ErrorType,
+	EnableCaller:      false,
+	CallerSkip:        0,
+	EnableHTTP:        false,
+	HTTPFormat:        JSONFormat,
+	HTTPLevel:         "", // LogLevel
+	EnableSyslog:      false,
+	SyslogFormat:      JSONFormat,
+	SyslogLevel:       "", // LogLevel
+	EnableKinesis:     false,
+	KinesisFormat:     JSONFormat,
+	KinesisLevel:      "", // LogLevel
+	EnableSQS:         false,
+	SQSFormat:         JSONFormat,
+	SQSLevel:          "", // LogLevel
 	EnableDebug:       false,
 }
 
 var defaultProducerConfiguration = ProducerConfiguration{
-	Brokers:       []string{"localhost:9092"},
-	Topic:         "logs",
-	Partition:     RandomPartition,
-	Key:           FixedKey,
-	KeyName:       "username",
-	Compression:   CompressionSnappy,
-	AckWait:       WaitForLocal,
-	ProdFlushFreq: 500 * time.Millisecond,
-	ProdRetryMax:  10,
-	ProdRetryFreq: 100 * time.Millisecond,
-	MetaRetryMax:  10,
-	MetaRetryFreq: 2000 * time.Millisecond,
-	EnableTLS:     false,
-	EnableDebug:   false,
+	Brokers:              []string{"localhost:9092"},
+	Topic:                "logs",
+	KafkaVersion:         "", // sarama default
+	ClientID:             "", // sarama default
+	Partition:            RandomPartition,
+	Key:                  FixedKey,
+	KeyName:              "username",
+	Compression:          CompressionSnappy,
+	CompressionLevel:     0, // codec default
+	AckWait:              WaitForLocal,
+	EnableIdempotent:     false,
+	TransactionalID:      "", // unsupported
+	ProdFlushFreq:        500 * time.Millisecond,
+	ProdRetryMax:         10,
+	ProdRetryFreq:        100 * time.Millisecond,
+	MetaRetryMax:         10,
+	MetaRetryFreq:        2000 * time.Millisecond,
+	ProdRetryPolicy:      RetryPolicy{}, // ProdRetryMax and ProdRetryFreq
+	MetaRetryPolicy:      RetryPolicy{}, // MetaRetryMax and MetaRetryFreq
+	WriteTimeout:         0,             // block until enqueued
+	DeadLetterTopic:      "",
+	DeadLetterFile:       "",
+	DeliveryRetryMax:     3,
+	DeliveryRetryFreq:    100 * time.Millisecond,
+	DeliveryRetryPolicy:  RetryPolicy{}, // DeliveryRetryMax and DeliveryRetryFreq
+	SpillFile:            "",
+	SpoolDir:             "", // disabled
+	SpoolMaxBytes:        100 << 20,
+	SpoolFileBytes:       10 << 20,
+	SpoolRetryFreq:       5 * time.Second,
+	EnableOffline:        false,
+	ThrottleLatency:      0, // disabled
+	ThrottleMaxDelay:     time.Second,
+	HeartbeatInterval:    0, // disabled
+	HeartbeatFields:      nil,
+	QuotaKey:             "",
+	QuotaRate:            0, // disabled
+	QuotaBurst:           0, // QuotaRate
+	QuotaInterval:        10 * time.Second,
+	QuotaMetricValues:    100,
+	DedupWindow:          0, // disabled
+	DedupFields:          nil,
+	MaxMessageBytes:      0, // sarama default
+	EnablePreflight:      false,
+	PreflightPrincipal:   "",
+	CreateTopics:         false,
+	TopicPartitions:      1,
+	TopicReplication:     1,
+	TopicRetention:       0,                          // broker default
+	TopicNaming:          TopicNamingConfiguration{}, // no convention
+	CEBinaryMode:         false,
+	EnableSchemaRegistry: false,
+	SchemaRegistryCfg:    defaultSchemaRegistryConfiguration,
+	EnableMock:           false,
+	EnableTLS:            false,
+	CACertFile:           "",
+	CertFile:             "",
+	KeyFile:              "",
+	InsecureSkipVerify:   false,
+	SASLMechanism:        SASLNone,
+	SASLUser:             "",
+	SASLPassword:         "",
+	EnableDebug:          false,
+}
+
+var defaultSchemaRegistryConfiguration = SchemaRegistryConfiguration{
+	URL:     "http://localhost:8081",
+	Subject: "", // <topic>-value
+	Format:  SchemaAvro,
+	Schema:  "", // generic log record schema
+	Timeout: 10 * time.Second,
+}
+
+var defaultConsumerConfiguration = ConsumerConfiguration{
+	Brokers:      []string{"localhost:9092"},
+	Group:        "",
+	Topics:       []string{"logs"},
+	KafkaVersion: "2.0.0",
+	Offset:       OffsetNewest,
+	Rebalance:    RebalanceRange,
+	CommitFreq:   time.Second,
+	EnableTLS:    false,
+	EnableDebug:  false,
 }
 
 var defaultCloudEventsConfiguration = CloudEventsConfiguration{
 	SetID:           CEHMAC,
 	HMACKey:         "pavedroad",
 	Source:          "http://github.com/pavedroad-io/go-core/logger",
+	DeriveSource:    false,
+	ServiceName:     "",
 	SpecVersion:     "1.0",
 	Type:            "io.pavedroad.cloudevents.log",
 	SetSubjectLevel: true,
@@ -117,6 +263,63 @@ var defaultRotationConfiguration = RotationConfiguration{
 	MaxBackups: 0,   // keep all
 	LocalTime:  false,
 	Compress:   false,
+	Interval:   RotateNever, // size based only
+}
+
+var defaultSamplingConfiguration = SamplingConfiguration{
+	Initial:    100,
+	Thereafter: 100,
+	Tick:       defaultSamplingTick,
+	BurstRate:  0, // no rate limit
+	BurstSize:  0,
+}
+
+var defaultHTTPSinkConfiguration = HTTPSinkConfiguration{
+	URL:           "http://localhost:3100/loki/api/v1/push",
+	Mode:          HTTPLoki,
+	BatchSize:     100,
+	FlushInterval: time.Second,
+	EnableGzip:    false,
+	RetryMax:      3,
+	RetryFreq:     100 * time.Millisecond,
+	RetryPolicy:   RetryPolicy{}, // RetryMax and RetryFreq
+	Timeout:       10 * time.Second,
+	WriteTimeout:  time.Second,
+}
+
+var defaultSyslogConfiguration = SyslogConfiguration{
+	Network:     SyslogUDP,
+	Address:     "localhost:514",
+	Facility:    "user",
+	Tag:         "", // program name
+	Format:      SyslogRFC5424,
+	Hostname:    "", // os.Hostname
+	Timeout:     10 * time.Second,
+	RetryPolicy: RetryPolicy{}, // one immediate retry
+}
+
+var defaultKinesisConfiguration = KinesisConfiguration{
+	StreamName:    "",
+	Region:        "", // AWS_REGION
+	Key:           LevelKey,
+	BatchSize:     100,
+	FlushInterval: time.Second,
+	RetryMax:      3,
+	RetryFreq:     100 * time.Millisecond,
+	RetryPolicy:   RetryPolicy{}, // RetryMax and RetryFreq
+	Timeout:       10 * time.Second,
+}
+
+var defaultSQSConfiguration = SQSConfiguration{
+	QueueURL:      "",
+	Region:        "", // AWS_REGION
+	Key:           LevelKey,
+	BatchSize:     maxSQSBatchSize,
+	FlushInterval: time.Second,
+	RetryMax:      3,
+	RetryFreq:     100 * time.Millisecond,
+	RetryPolicy:   RetryPolicy{}, // RetryMax and RetryFreq
+	Timeout:       10 * time.Second,
 }
 
 // DefaultLoggerCfg returns default log configuration
@@ -129,6 +332,11 @@ func DefaultProducerCfg() ProducerConfiguration {
 	return defaultProducerConfiguration
 }
 
+// DefaultConsumerCfg returns default kafka consumer configuration
+func DefaultConsumerCfg() ConsumerConfiguration {
+	return defaultConsumerConfiguration
+}
+
 // DefaultCloudEventsCfg returns default cloudevents configuration
 func DefaultCloudEventsCfg() CloudEventsConfiguration {
 	return defaultCloudEventsConfiguration
@@ -139,18 +347,51 @@ func DefaultRotationCfg() RotationConfiguration {
 	return defaultRotationConfiguration
 }
 
+// DefaultSamplingCfg returns default sampling configuration
+func DefaultSamplingCfg() SamplingConfiguration {
+	return defaultSamplingConfiguration
+}
+
+// DefaultHTTPCfg returns default http sink configuration
+func DefaultHTTPCfg() HTTPSinkConfiguration {
+	return defaultHTTPSinkConfiguration
+}
+
+// DefaultSyslogCfg returns default syslog sink configuration
+func DefaultSyslogCfg() SyslogConfiguration {
+	return defaultSyslogConfiguration
+}
+
+// DefaultKinesisCfg returns default kinesis sink configuration
+func DefaultKinesisCfg() KinesisConfiguration {
+	return defaultKinesisConfiguration
+}
+
+// DefaultSQSCfg returns default sqs sink configuration
+func DefaultSQSCfg() SQSConfiguration {
+	return defaultSQSConfiguration
+}
+
 // DefaultLoggerCfg returns default log configuration
 func DefaultCompleteCfg() *LoggerConfiguration {
 	config := defaultLoggerConfiguration
 	config.CloudEventsCfg = defaultCloudEventsConfiguration
 	config.KafkaProducerCfg = defaultProducerConfiguration
 	config.RotationCfg = defaultRotationConfiguration
+	config.SamplingCfg = defaultSamplingConfiguration
+	config.HTTPCfg = defaultHTTPSinkConfiguration
+	config.SyslogCfg = defaultSyslogConfiguration
+	config.KinesisCfg = defaultKinesisConfiguration
+	config.SQSCfg = defaultSQSConfiguration
 	return &config
 }
 
 // init called on package import to configure and initialize default logger
 func init() {
-	var err error
+	// set PRLOG_UNINITPOLICY to select handling of an uninitialized logger
+	if policy := os.Getenv(UninitPolicyEnvName); policy != "" {
+		SetUninitializedPolicy(UninitPolicyType(policy))
+	}
 
 	// set PRLOG_AUTOINIT=true to initialize logger with default configuration
 	autoInit := os.Getenv(LogAutoInitEnvName)
@@ -158,6 +399,34 @@ func init() {
 		return
 	}
 
+	config, err := environmentConfiguration()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		if errors.Is(err, ErrFatal) {
+			os.Exit(1)
+		}
+	}
+
+	// initialize the logger with the customized configuration
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	newLogger, err := NewLogger(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not instantiate %s logger package: %s\n",
+			config.LogPackage, err.Error())
+		os.Exit(1)
+	}
+	logger = newReloadLogger(newLogger)
+}
+
+// environmentConfiguration generates config as selected by the environment
+func environmentConfiguration() (LoggerConfiguration, error) {
+	return GetLoggerConfiguration(environmentConfigType())
+}
+
+// environmentConfigType returns the config type and file name selected by
+// the environment
+func environmentConfigType() (configType, string) {
 	// set PRLOG_CFGTYPE as needed to specify how to override logger defaults
 	cfgType := configType(os.Getenv(ConfigTypeEnvName))
 	if cfgType == "" {
@@ -165,28 +434,114 @@ func init() {
 		cfgType = EnvConfig
 	}
 
-	// set PRLOG_CFGFILE to override default config file name
+	// set PRLOG_CFGFILE to override default config file name, or to a list
+	// of names, e.g. a platform base file and an application override file
 	cfgFile := os.Getenv(ConfigFileEnvName)
 	if cfgFile == "" {
 		cfgFile = ConfigFileName
 	}
+	return cfgType, cfgFile
+}
+
+// Init initializes the package logger with the configuration
+// Thread-safe, only the first initialization takes effect including auto init
+func Init(config LoggerConfiguration) error {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	if logger != nil {
+		return ErrInitialized
+	}
 
-	config, err := GetLoggerConfiguration(cfgType, cfgFile)
+	newLogger, err := NewLogger(config)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		if errors.Is(err, ErrFatal) {
-			os.Exit(1)
-		}
+		return err
+	}
+	logger = newReloadLogger(newLogger)
+	return nil
+}
+
+// InitDefault initializes the package logger with the default configuration
+func InitDefault() error {
+	return Init(*DefaultCompleteCfg())
+}
+
+// MustInit initializes the logger from the environment if not initialized
+// Panics if the configuration is invalid or the logger cannot be created
+func MustInit() {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	if logger != nil {
+		return
 	}
 
-	// initialize the logger with the customized configuration
-	if logger, err = NewLogger(config); err != nil {
-		fmt.Fprintf(os.Stderr, "Could not instantiate %s logger package: %s\n",
-			config.LogPackage, err.Error())
-		os.Exit(1)
+	config, err := environmentConfiguration()
+	if err != nil {
+		panic(err)
+	}
+	newLogger, err := NewLogger(config)
+	if err != nil {
+		panic(fmt.Errorf("Could not instantiate %s logger package: %w",
+			config.LogPackage, err))
+	}
+	logger = newReloadLogger(newLogger)
+}
+
+// SetUninitializedPolicy selects handling of package level log calls
+// made before the logger is initialized
+func SetUninitializedPolicy(policy UninitPolicyType) {
+	switch policy {
+	case UninitStderr:
+	case UninitAutoInit:
+	case UninitPanic:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid UninitPolicy type: %s\n", policy)
+		return
+	}
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	uninitPolicy = policy
+}
+
+// packageLogger returns the logger used by the package level functions
+func packageLogger() Logger {
+	loggerMutex.RLock()
+	current, policy := logger, uninitPolicy
+	loggerMutex.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	switch policy {
+	case UninitAutoInit:
+		MustInit()
+		loggerMutex.RLock()
+		defer loggerMutex.RUnlock()
+		return logger
+	case UninitPanic:
+		panic("Logger not initialized")
+	case UninitStderr:
+		fallthrough
+	default:
+		return stderrLogger()
 	}
 }
 
+// stderrLogger returns the fallback logger for an uninitialized logger
+func stderrLogger() Logger {
+	fallbackOnce.Do(func() {
+		fmt.Fprintf(os.Stderr, "Logger not initialized, logging to stderr\n")
+		fallbackLogger, _ = newZapLogger(LoggerConfiguration{
+			LogLevel:          InfoType,
+			EnableTimeStamps:  true,
+			EnableColorLevels: false,
+			EnableConsole:     true,
+			ConsoleFormat:     TextFormat,
+			ConsoleWriter:     Stderr,
+		})
+	})
+	return fallbackLogger
+}
+
 // GetLoggerConfiguration generates config from defaults/config-file/environment
 func GetLoggerConfiguration(cfgType configType,
 	cfgFileName string) (LoggerConfiguration, error) {
@@ -209,17 +564,22 @@ func GetLoggerConfiguration(cfgType configType,
 	config := new(LoggerConfiguration)
 	// read config file and/or environment to override defaults
 	// single config file covers basic log config and all sub configs
-	// only gets environment overrides for the basic log config
+	// environment overrides of sub configs use nested keys
+	// Example: PRLOG_KAFKAPRODUCERCFG_TOPIC=logs
 	err = FillConfiguration(DefaultCompleteCfg(), config, cfgType, cfgFileName,
 		LogEnvPrefix)
 	if err != nil {
 		return cfg, fmt.Errorf("%s: %s %w\n", errLogger, err.Error(), ErrFatal)
 	}
 
+	// the sub config prefixes override the sub configs read above in all
+	// config types, e.g. PRKAFKA_TOPIC has precedence over the config file
+	// and PRLOG_KAFKAPRODUCERCFG_TOPIC
+
 	// get environment overrides for the kafka sub config
 	kafkaConfig := new(ProducerConfiguration)
-	err = FillConfiguration(DefaultProducerCfg(), kafkaConfig, EnvConfig, "",
-		KafkaEnvPrefix)
+	err = FillConfiguration(config.KafkaProducerCfg, kafkaConfig, EnvConfig,
+		"", KafkaEnvPrefix)
 	if err == nil {
 		config.KafkaProducerCfg = *kafkaConfig
 	} else {
@@ -231,7 +591,7 @@ func GetLoggerConfiguration(cfgType configType,
 
 	// get environment overrides for the cloudevents sub config
 	ceConfig := new(CloudEventsConfiguration)
-	err = FillConfiguration(DefaultCloudEventsCfg(), ceConfig, EnvConfig, "",
+	err = FillConfiguration(config.CloudEventsCfg, ceConfig, EnvConfig, "",
 		CloudEventsEnvPrefix)
 	if err == nil {
 		config.CloudEventsCfg = *ceConfig
@@ -245,7 +605,7 @@ func GetLoggerConfiguration(cfgType configType,
 
 	// get environment overrides for the rotation sub config
 	rotConfig := new(RotationConfiguration)
-	err = FillConfiguration(DefaultRotationCfg(), rotConfig, EnvConfig, "",
+	err = FillConfiguration(config.RotationCfg, rotConfig, EnvConfig, "",
 		RotationEnvPrefix)
 	if err == nil {
 		config.RotationCfg = *rotConfig
@@ -256,10 +616,36 @@ func GetLoggerConfiguration(cfgType configType,
 		return cfg, fmt.Errorf("%s: %s %w\n", errRotation, err.Error(),
 			errSetting)
 	}
+
+	// get environment overrides for the retry policies
+	*config, err = config.withRetryEnvironment()
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %s %w\n", errRetry, err.Error(), ErrFatal)
+	}
 	return *config, nil
 }
 
+// configFileNames returns the names of the comma separated list of config
+// files, the name is returned as is if the list is empty
+func configFileNames(filename string) []string {
+	var names []string
+	for _, name := range strings.Split(filename, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{filename}
+	}
+	return names
+}
+
 // FillConfiguration fills config from defaults, config file and environment
+// Nested settings are overridden by the environment with their keys joined
+// by underscores, e.g. PRLOG_KAFKAPRODUCERCFG_TOPIC for kafkaproducercfg.topic
+// The filename may be a comma separated list of config files merged in
+// order, settings of later files override those of earlier files, and the
+// environment overrides all files
 func FillConfiguration(defaultCfg interface{}, config interface{},
 	cfgType configType, filename string, prefix string) error {
 
@@ -280,17 +666,35 @@ func FillConfiguration(defaultCfg interface{}, config interface{},
 	}
 	if cfgType == EnvConfig || cfgType == BothConfig {
 		v.SetEnvPrefix(prefix)
+		v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 		v.AutomaticEnv()
 	}
 
 	if cfgType == FileConfig || cfgType == BothConfig {
-		v.SetConfigName(filename)
-		v.AddConfigPath(".")
-		v.AddConfigPath("$HOME")
-		v.AddConfigPath("$HOME/.pavedroad.d")
-		if err := v.ReadInConfig(); err != nil {
+		for _, path := range configPaths {
+			v.AddConfigPath(path)
+		}
+		for i, name := range configFileNames(filename) {
+			v.SetConfigName(name)
+			readConfig := v.MergeInConfig
+			if i == 0 {
+				readConfig = v.ReadInConfig
+			}
+			if err := readConfig(); err != nil {
+				return err
+			}
+		}
+		// warn of unknown keys and apply deprecated keys before profiles
+		warnings, err := checkConfigKeys(v, config)
+		if err != nil {
+			return err
+		}
+		reportConfigWarnings(warnings)
+		// set PRLOG_PROFILE to overlay a named profile from the config file
+		if err := applyProfile(v, os.Getenv(ProfileEnvName)); err != nil {
 			return err
 		}
+		expandEnvironment(v)
 	}
 
 	if err := v.Unmarshal(config); err != nil {
@@ -299,12 +703,97 @@ func FillConfiguration(defaultCfg interface{}, config interface{},
 	return nil
 }
 
+// applyProfile overlays the settings of the named profile on the config file
+func applyProfile(v *viper.Viper, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	// viper keys are case insensitive
+	sub := v.Sub(ProfilesKey + "." + strings.ToLower(profile))
+	if sub == nil {
+		return fmt.Errorf("Profile %s not found in %s", profile,
+			v.ConfigFileUsed())
+	}
+	return v.MergeConfigMap(sub.AllSettings())
+}
+
+// envReference matches ${VAR} references in config file values
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvironment replaces ${VAR} references with environment values
+// Only string and string list values are expanded, unset variables are empty
+func expandEnvironment(v *viper.Viper) {
+	expand := func(value string) string {
+		return envReference.ReplaceAllStringFunc(value, func(ref string) string {
+			return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+		})
+	}
+
+	for _, key := range v.AllKeys() {
+		switch value := v.Get(key).(type) {
+		case string:
+			if envReference.MatchString(value) {
+				v.Set(key, expand(value))
+			}
+		case []interface{}:
+			var expanded bool
+			values := make([]interface{}, len(value))
+			for i, item := range value {
+				values[i] = item
+				if str, ok := item.(string); ok && envReference.MatchString(str) {
+					values[i] = expand(str)
+					expanded = true
+				}
+			}
+			if expanded {
+				v.Set(key, values)
+			}
+		}
+	}
+}
+
+// ExportFormatType provided to select configuration export format
+type ExportFormatType string
+
+// Types of configuration export formats
+const (
+	YAMLExport ExportFormatType = "yaml" // default
+	JSONExport ExportFormatType = "json"
+)
+
 func ExportConfiguration(file string, config LoggerConfiguration) error {
 
-	ybytes, err := yaml.Marshal(config)
+	ybytes, err := marshalConfiguration(config, YAMLExport)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		err = ioutil.WriteFile(file, ybytes, 0644)
+		if err != nil {
+			return fmt.Errorf("Failed to export config %s\n", err.Error())
+		}
+	}
+	if config.EnableDebug {
+		os.Stderr.Write(ybytes)
+	}
+	return nil
+}
+
+// ExportState exports the current configuration followed by the producer
+// statistics under ProducerStatsKey, the file is written on SIGUSR1
+func ExportState(file string) error {
+	config := CurrentConfiguration()
+	ybytes, err := marshalConfiguration(config, YAMLExport)
+	if err != nil {
+		return err
+	}
+	stats, err := yaml.Marshal(map[string][]ProducerState{
+		ProducerStatsKey: CurrentState().Producers,
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to marshal config %s\n", err.Error())
+		return fmt.Errorf("Failed to marshal producer stats %s\n", err.Error())
 	}
+	ybytes = append(ybytes, stats...)
 	if file != "" {
 		err = ioutil.WriteFile(file, ybytes, 0644)
 		if err != nil {
@@ -317,21 +806,97 @@ func ExportConfiguration(file string, config LoggerConfiguration) error {
 	return nil
 }
 
+// CurrentConfiguration returns the configuration of the latest logger created
+func CurrentConfiguration() LoggerConfiguration {
+	globalConfigMutex.RLock()
+	defer globalConfigMutex.RUnlock()
+	return globalLoggerConfiguration
+}
+
+// WriteConfiguration writes the current configuration in the given format
+func WriteConfiguration(w io.Writer, format ExportFormatType) error {
+	bytes, err := marshalConfiguration(CurrentConfiguration(), format)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes)
+	return err
+}
+
+// redactedSecret replaces the value of secret fields in exported views
+const redactedSecret = "****"
+
+// redacted returns a copy of the configuration with the fields tagged
+// secret masked, for the exported and dumped configurations
+func (lc LoggerConfiguration) redacted() LoggerConfiguration {
+	redactSecrets(reflect.ValueOf(&lc).Elem())
+	return lc
+}
+
+// redactSecrets masks the set string fields tagged secret of the struct
+// and of its nested structs, slices are left as is as they are shared
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			redactSecrets(v.Field(i))
+		case field.Type.Kind() == reflect.String &&
+			field.Tag.Get("secret") == "true" && v.Field(i).String() != "":
+			v.Field(i).SetString(redactedSecret)
+		}
+	}
+}
+
+// marshalConfiguration returns the configuration in the given format, its
+// secrets redacted
+func marshalConfiguration(config LoggerConfiguration,
+	format ExportFormatType) ([]byte, error) {
+	var bytes []byte
+	var err error
+
+	config = config.redacted()
+
+	switch format {
+	case JSONExport:
+		bytes, err = json.MarshalIndent(config, "", "  ")
+		if err == nil {
+			bytes = append(bytes, '\n')
+		}
+	case YAMLExport, "":
+		bytes, err = yaml.Marshal(config)
+	default:
+		return nil, fmt.Errorf("Invalid export format: %s\n", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal config %s\n", err.Error())
+	}
+	return bytes, nil
+}
+
 var globalLoggerConfiguration LoggerConfiguration
+var globalConfigMutex sync.RWMutex
+
+// signalOnce starts a single signal catcher for all the loggers created
+var signalOnce sync.Once
 
+// signalCatcher exports the state on each SIGUSR1
 func signalCatcher() {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGUSR1)
-	<-ch
-	ExportConfiguration(ExportConfigFileName, globalLoggerConfiguration)
-	go signalCatcher()
+	for range ch {
+		ExportState(ExportConfigFileName)
+	}
 }
 
 func checkConfig(config LoggerConfiguration) error {
 	var errCount int
 
-	globalLoggerConfiguration = config
-	go signalCatcher()
+	signalOnce.Do(func() { go signalCatcher() })
 	if config.EnableDebug {
 		ExportConfiguration("", config)
 	}
@@ -347,6 +912,22 @@ func checkConfig(config LoggerConfiguration) error {
 	if config.EnableRotation {
 		checkRotationConfig(config.RotationCfg, &errCount)
 	}
+	checkFileSinkConfig(config, &errCount)
+	if config.EnableSampling {
+		checkSamplingConfig(config.SamplingCfg, &errCount)
+	}
+	if config.EnableHTTP {
+		checkHTTPConfig(config.HTTPCfg, &errCount)
+	}
+	if config.EnableSyslog {
+		checkSyslogConfig(config.SyslogCfg, &errCount)
+	}
+	if config.EnableKinesis {
+		checkKinesisConfig(config.KinesisCfg, &errCount)
+	}
+	if config.EnableSQS {
+		checkSQSConfig(config.SQSCfg, &errCount)
+	}
 
 	if errCount > 0 {
 		return errors.New("Invalid configuration")
@@ -359,16 +940,67 @@ func checkLoggerConfig(lc LoggerConfiguration, errCount *int) {
 	checkLoggerTypes(lc, errCount)
 
 	if (lc.ConsoleFormat == CEFormat || lc.FileFormat == CEFormat ||
-		lc.KafkaFormat == CEFormat) && !lc.EnableCloudEvents {
+		lc.KafkaFormat == CEFormat ||
+		(lc.EnableHTTP && lc.HTTPFormat == CEFormat) ||
+		(lc.EnableSyslog && lc.SyslogFormat == CEFormat) ||
+		(lc.EnableKinesis && lc.KinesisFormat == CEFormat) ||
+		(lc.EnableSQS && lc.SQSFormat == CEFormat)) && !lc.EnableCloudEvents {
 		fmt.Fprintf(os.Stderr, "CEFormat requires EnableCloudEvents\n")
 		*errCount++
 	}
+
+	if lc.MaxFields < 0 {
+		fmt.Fprintf(os.Stderr, "MaxFields less than zero\n")
+		*errCount++
+	}
+	if lc.MaxFieldLength < 0 {
+		fmt.Fprintf(os.Stderr, "MaxFieldLength less than zero\n")
+		*errCount++
+	}
+	if lc.CallerSkip < 0 {
+		fmt.Fprintf(os.Stderr, "CallerSkip less than zero\n")
+		*errCount++
+	}
+
+	if lc.AsyncBufferSize < 0 {
+		fmt.Fprintf(os.Stderr, "AsyncBufferSize less than zero\n")
+		*errCount++
+	}
+
+	for _, sc := range lc.Sinks {
+		if _, ok := lookupSink(sc.Type); !ok {
+			fmt.Fprintf(os.Stderr, "Sink type not registered: %s\n", sc.Type)
+			*errCount++
+		}
+		switch sc.Format {
+		case JSONFormat:
+		case TextFormat:
+		case CEFormat:
+			if !lc.EnableCloudEvents {
+				fmt.Fprintf(os.Stderr, "CEFormat requires EnableCloudEvents\n")
+				*errCount++
+			}
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid Sink Format type: %s\n", sc.Format)
+			*errCount++
+		}
+	}
 }
 
 func checkProducerConfig(pc ProducerConfiguration, errCount *int) {
 	checkProducerTypes(pc, errCount)
-	if pc.EnableTLS && pc.TLSCfg == nil {
-		fmt.Fprintf(os.Stderr, "Producer missing TLS config\n")
+	if !pc.EnableTLS && (pc.CACertFile != "" || pc.CertFile != "" ||
+		pc.KeyFile != "") {
+		fmt.Fprintf(os.Stderr, "Producer TLS config requires EnableTLS\n")
+		*errCount++
+	}
+	if (pc.CertFile == "") != (pc.KeyFile == "") {
+		fmt.Fprintf(os.Stderr, "Producer CertFile and KeyFile must both be set\n")
+		*errCount++
+	}
+	if pc.SASLMechanism != SASLNone && pc.SASLUser == "" {
+		fmt.Fprintf(os.Stderr, "Producer SASLMechanism requires SASLUser\n")
 		*errCount++
 	}
 	if pc.ProdFlushFreq < 0 {
@@ -391,79 +1023,591 @@ func checkProducerConfig(pc ProducerConfiguration, errCount *int) {
 		fmt.Fprintf(os.Stderr, "Producer MetaRetryFreq less than zero\n")
 		*errCount++
 	}
-}
-
-func checkRotationConfig(rc RotationConfiguration, errCount *int) {
-	if rc.MaxSize < 0 {
-		fmt.Fprintf(os.Stderr, "Rotation MaxSize less than zero\n")
+	checkRetryPolicy("Producer ProdRetryPolicy", pc.ProdRetryPolicy, errCount)
+	checkRetryPolicy("Producer MetaRetryPolicy", pc.MetaRetryPolicy, errCount)
+	if pc.WriteTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "Producer WriteTimeout less than zero\n")
 		*errCount++
 	}
-	if rc.MaxAge < 0 {
-		fmt.Fprintf(os.Stderr, "Rotation MaxAge less than zero\n")
+	if !validCompressionLevel(pc.Compression, pc.CompressionLevel) {
+		fmt.Fprintf(os.Stderr, "Invalid CompressionLevel for %s: %d\n",
+			pc.Compression, pc.CompressionLevel)
 		*errCount++
 	}
-	if rc.MaxBackups < 0 {
-		fmt.Fprintf(os.Stderr, "Rotation MaxBackups less than zero\n")
+	if pc.MaxMessageBytes < 0 {
+		fmt.Fprintf(os.Stderr, "Producer MaxMessageBytes less than zero\n")
 		*errCount++
 	}
-}
-
-func checkLoggerTypes(lc LoggerConfiguration, errCount *int) {
-	switch lc.LogPackage {
-	case ZapType:
-	case LogrusType:
-	case "":
-	default:
-		fmt.Fprintf(os.Stderr, "Invalid LogPackage type: %s\n", lc.LogPackage)
+	if pc.DeliveryRetryMax < 0 {
+		fmt.Fprintf(os.Stderr, "Producer DeliveryRetryMax less than zero\n")
 		*errCount++
 	}
-
-	switch lc.LogLevel {
-	case DebugType:
-	case InfoType:
-	case WarnType:
-	case ErrorType:
-	case FatalType:
-	case PanicType:
-	case "":
-	default:
-		fmt.Fprintf(os.Stderr, "Invalid LogLevel type: %s\n", lc.LogLevel)
+	if pc.DeliveryRetryFreq < 0 {
+		fmt.Fprintf(os.Stderr, "Producer DeliveryRetryFreq less than zero\n")
 		*errCount++
 	}
-
-	switch lc.ConsoleFormat {
-	case JSONFormat:
-	case TextFormat:
-	case "":
-	case CEFormat:
-		fallthrough
-	default:
-		fmt.Fprintf(os.Stderr, "Invalid ConsoleFormat type: %s\n",
-			lc.ConsoleFormat)
-		*errCount++
+	checkRetryPolicy("Producer DeliveryRetryPolicy", pc.DeliveryRetryPolicy,
+		errCount)
+	if pc.KafkaVersion != "" {
+		if _, err := sarama.ParseKafkaVersion(pc.KafkaVersion); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid Producer KafkaVersion: %s\n",
+				pc.KafkaVersion)
+			*errCount++
+		}
 	}
-
-	switch lc.ConsoleWriter {
-	case Stdout:
-	case Stderr:
-	case "":
-	default:
-		testInit := os.Getenv(LogTestInitEnvName)
-		if testInit == "true" {
-			file, err := os.Create(string(lc.ConsoleWriter))
-			if err == nil {
-				debugCapture = file
-			} else {
-				fmt.Fprintf(os.Stderr, "debugCapture failed: <%s>\n",
-					err.Error())
-			}
-		} else {
-			fmt.Fprintf(os.Stderr, "Invalid ConsoleWriter type: %s\n",
-				lc.ConsoleWriter)
+	for _, rule := range pc.RouteRules {
+		if rule.Topic == "" {
+			fmt.Fprintf(os.Stderr, "Producer RouteRules Topic missing\n")
+			*errCount++
+		}
+		_, ok := levelSeverities[normalizeLevel(rule.Level)]
+		if !ok && rule.Level != "" {
+			fmt.Fprintf(os.Stderr, "Invalid RouteRules Level type: %s\n",
+				rule.Level)
 			*errCount++
 		}
 	}
-
+	if pc.TopicPartitions < 0 {
+		fmt.Fprintf(os.Stderr, "Producer TopicPartitions less than zero\n")
+		*errCount++
+	}
+	if pc.TopicReplication < 0 {
+		fmt.Fprintf(os.Stderr, "Producer TopicReplication less than zero\n")
+		*errCount++
+	}
+	if pc.TopicRetention < 0 {
+		fmt.Fprintf(os.Stderr, "Producer TopicRetention less than zero\n")
+		*errCount++
+	}
+	if pc.TopicNaming.enabled() {
+		namer, err := NewTopicNamer(pc.TopicNaming)
+		if err == nil {
+			_, err = namer.nameTopics(pc)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Producer TopicNaming: %s\n", err.Error())
+			*errCount++
+		}
+	}
+	if pc.CreateTopics && pc.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(pc.KafkaVersion)
+		if err == nil && !version.IsAtLeast(sarama.V0_10_1_0) {
+			fmt.Fprintf(os.Stderr, "CreateTopics requires KafkaVersion 0.10.1\n")
+			*errCount++
+		}
+	}
+	if pc.SpoolDir != "" {
+		if pc.SpoolMaxBytes <= 0 {
+			fmt.Fprintf(os.Stderr, "Producer SpoolMaxBytes not greater than zero\n")
+			*errCount++
+		}
+		if pc.SpoolFileBytes <= 0 {
+			fmt.Fprintf(os.Stderr, "Producer SpoolFileBytes not greater than zero\n")
+			*errCount++
+		}
+		if pc.SpoolRetryFreq <= 0 {
+			fmt.Fprintf(os.Stderr, "Producer SpoolRetryFreq not greater than zero\n")
+			*errCount++
+		}
+	}
+	if pc.EnableOffline && pc.SpoolDir == "" {
+		fmt.Fprintf(os.Stderr, "Producer EnableOffline requires SpoolDir\n")
+		*errCount++
+	}
+	if pc.QuotaRate < 0 {
+		fmt.Fprintf(os.Stderr, "Producer QuotaRate less than zero\n")
+		*errCount++
+	}
+	if pc.QuotaRate > 0 {
+		if pc.QuotaKey == "" {
+			fmt.Fprintf(os.Stderr, "Producer QuotaRate requires QuotaKey\n")
+			*errCount++
+		}
+		if pc.QuotaBurst < 0 {
+			fmt.Fprintf(os.Stderr, "Producer QuotaBurst less than zero\n")
+			*errCount++
+		}
+		if pc.QuotaInterval <= 0 {
+			fmt.Fprintf(os.Stderr, "Producer QuotaInterval not greater than zero\n")
+			*errCount++
+		}
+		if pc.QuotaMetricValues < 0 {
+			fmt.Fprintf(os.Stderr, "Producer QuotaMetricValues less than zero\n")
+			*errCount++
+		}
+	}
+	if pc.DedupWindow < 0 {
+		fmt.Fprintf(os.Stderr, "Producer DedupWindow less than zero\n")
+		*errCount++
+	}
+	for _, field := range pc.DedupFields {
+		if field == "" {
+			fmt.Fprintf(os.Stderr, "Producer DedupFields field name missing\n")
+			*errCount++
+		}
+	}
+	if pc.HeartbeatInterval < 0 {
+		fmt.Fprintf(os.Stderr, "Producer HeartbeatInterval less than zero\n")
+		*errCount++
+	}
+	if pc.ThrottleLatency < 0 {
+		fmt.Fprintf(os.Stderr, "Producer ThrottleLatency less than zero\n")
+		*errCount++
+	}
+	if pc.ThrottleMaxDelay < 0 {
+		fmt.Fprintf(os.Stderr, "Producer ThrottleMaxDelay less than zero\n")
+		*errCount++
+	}
+	if pc.CEBinaryMode && pc.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(pc.KafkaVersion)
+		if err == nil && !version.IsAtLeast(sarama.V0_11_0_0) {
+			fmt.Fprintf(os.Stderr, "CEBinaryMode requires KafkaVersion 0.11\n")
+			*errCount++
+		}
+	}
+	if pc.EnableIdempotent {
+		checkIdempotentConfig(pc, errCount)
+	}
+	if pc.TransactionalID != "" {
+		// transactional producers require sarama 1.37, EnableIdempotent
+		// provides exactly once delivery per partition without them
+		fmt.Fprintf(os.Stderr,
+			"TransactionalID not supported by the sarama version\n")
+		*errCount++
+	}
+	if pc.EnableSchemaRegistry {
+		checkSchemaRegistryConfig(pc.SchemaRegistryCfg, errCount)
+	}
+	if pc.DeadLetterTopic != "" && pc.DeadLetterTopic == pc.Topic {
+		fmt.Fprintf(os.Stderr, "Producer DeadLetterTopic same as Topic\n")
+		*errCount++
+	}
+}
+
+func checkSchemaRegistryConfig(sc SchemaRegistryConfiguration, errCount *int) {
+	if sc.URL == "" {
+		fmt.Fprintf(os.Stderr, "Schema registry missing URL\n")
+		*errCount++
+	}
+	switch sc.Format {
+	case SchemaAvro:
+	case SchemaJSON:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid Schema Format type: %s\n", sc.Format)
+		*errCount++
+	}
+	if sc.Schema != "" && (sc.Format == SchemaAvro || sc.Format == "") {
+		if _, err := parseAvroSchema(sc.Schema); err != nil {
+			fmt.Fprintf(os.Stderr, "Schema registry %s\n", err.Error())
+			*errCount++
+		}
+	}
+	if sc.Timeout < 0 {
+		fmt.Fprintf(os.Stderr, "Schema registry Timeout less than zero\n")
+		*errCount++
+	}
+}
+
+// validCompressionLevel checks the level is supported by the compression
+func validCompressionLevel(compression compressionType, level int) bool {
+	if level == 0 {
+		return true
+	}
+	switch compression {
+	case CompressionGZIP:
+		return level >= 1 && level <= 9
+	case CompressionZSTD:
+		return level >= 1 && level <= 22
+	default:
+		return false
+	}
+}
+
+func checkConsumerConfig(cc ConsumerConfiguration, errCount *int) {
+	checkConsumerTypes(cc, errCount)
+	if cc.Group == "" {
+		fmt.Fprintf(os.Stderr, "Consumer missing group\n")
+		*errCount++
+	}
+	if len(cc.Topics) == 0 {
+		fmt.Fprintf(os.Stderr, "Consumer missing topics\n")
+		*errCount++
+	}
+	if cc.EnableTLS && cc.TLSCfg == nil {
+		fmt.Fprintf(os.Stderr, "Consumer missing TLS config\n")
+		*errCount++
+	}
+}
+
+func checkIdempotentConfig(pc ProducerConfiguration, errCount *int) {
+	if pc.AckWait == WaitForNone {
+		fmt.Fprintf(os.Stderr, "EnableIdempotent requires AckWait all\n")
+		*errCount++
+	}
+	retries := pc.ProdRetryPolicy.resolve(pc.ProdRetryMax, pc.ProdRetryFreq,
+		nil).Retries()
+	if retries < 1 {
+		fmt.Fprintf(os.Stderr, "EnableIdempotent requires producer retries\n")
+		*errCount++
+	}
+	if pc.ProdRetryPolicy.Retryable != nil {
+		// only sarama retries the idempotent producer, unclassified
+		fmt.Fprintf(os.Stderr,
+			"EnableIdempotent does not support ProdRetryPolicy Retryable\n")
+		*errCount++
+	}
+	if pc.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(pc.KafkaVersion)
+		if err == nil && !version.IsAtLeast(sarama.V0_11_0_0) {
+			fmt.Fprintf(os.Stderr, "EnableIdempotent requires KafkaVersion 0.11\n")
+			*errCount++
+		}
+	}
+}
+
+func checkRotationConfig(rc RotationConfiguration, errCount *int) {
+	if rc.MaxSize < 0 {
+		fmt.Fprintf(os.Stderr, "Rotation MaxSize less than zero\n")
+		*errCount++
+	}
+	if rc.MaxAge < 0 {
+		fmt.Fprintf(os.Stderr, "Rotation MaxAge less than zero\n")
+		*errCount++
+	}
+	if rc.MaxBackups < 0 {
+		fmt.Fprintf(os.Stderr, "Rotation MaxBackups less than zero\n")
+		*errCount++
+	}
+	switch rc.Interval {
+	case RotateNever:
+	case RotateHourly:
+	case RotateDaily:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid rotation Interval type: %s\n",
+			rc.Interval)
+		*errCount++
+	}
+	if rc.FileTemplate != "" {
+		if !strings.Contains(rc.FileTemplate, RotationDatePlaceholder) {
+			fmt.Fprintf(os.Stderr, "Rotation FileTemplate missing %s\n",
+				RotationDatePlaceholder)
+			*errCount++
+		}
+		if filepath.Base(rc.FileTemplate) != rc.FileTemplate {
+			fmt.Fprintf(os.Stderr, "Rotation FileTemplate not a file name: %s\n",
+				rc.FileTemplate)
+			*errCount++
+		}
+	}
+}
+
+func checkHTTPConfig(hc HTTPSinkConfiguration, errCount *int) {
+	switch hc.Mode {
+	case HTTPLoki:
+	case HTTPNDJSON:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid HTTP Mode type: %s\n", hc.Mode)
+		*errCount++
+	}
+	if u, err := url.Parse(hc.URL); hc.URL != "" &&
+		(err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+		fmt.Fprintf(os.Stderr, "Invalid HTTP URL: %s\n", hc.URL)
+		*errCount++
+	}
+	if hc.BatchSize < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP BatchSize less than zero\n")
+		*errCount++
+	}
+	if hc.FlushInterval < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP FlushInterval less than zero\n")
+		*errCount++
+	}
+	if hc.RetryMax < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP RetryMax less than zero\n")
+		*errCount++
+	}
+	if hc.RetryFreq < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP RetryFreq less than zero\n")
+		*errCount++
+	}
+	checkRetryPolicy("HTTP RetryPolicy", hc.RetryPolicy, errCount)
+	if hc.Timeout < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP Timeout less than zero\n")
+		*errCount++
+	}
+	if hc.WriteTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "HTTP WriteTimeout less than zero\n")
+		*errCount++
+	}
+}
+
+func checkSyslogConfig(sc SyslogConfiguration, errCount *int) {
+	switch sc.Network {
+	case SyslogUDP:
+	case SyslogTCP:
+	case SyslogTLS:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid Syslog Network type: %s\n", sc.Network)
+		*errCount++
+	}
+	switch sc.Format {
+	case SyslogRFC5424:
+	case SyslogRFC3164:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid Syslog Format type: %s\n", sc.Format)
+		*errCount++
+	}
+	if _, ok := syslogFacilities[sc.Facility]; sc.Facility != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Invalid Syslog Facility: %s\n", sc.Facility)
+		*errCount++
+	}
+	if _, _, err := net.SplitHostPort(sc.Address); sc.Address != "" &&
+		err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid Syslog Address: %s\n", sc.Address)
+		*errCount++
+	}
+	if sc.Timeout < 0 {
+		fmt.Fprintf(os.Stderr, "Syslog Timeout less than zero\n")
+		*errCount++
+	}
+	checkRetryPolicy("Syslog RetryPolicy", sc.RetryPolicy, errCount)
+	if sc.Network != SyslogTLS && (sc.CACertFile != "" || sc.CertFile != "" ||
+		sc.KeyFile != "") {
+		fmt.Fprintf(os.Stderr, "Syslog certificate files require tls Network\n")
+		*errCount++
+	}
+}
+
+func checkKinesisConfig(kc KinesisConfiguration, errCount *int) {
+	if kc.StreamName == "" {
+		fmt.Fprintf(os.Stderr, "Kinesis StreamName not set\n")
+		*errCount++
+	}
+	checkAWSKey("Kinesis", kc.Key, kc.KeyName, errCount)
+	if kc.BatchSize < 0 || kc.BatchSize > maxKinesisBatchSize {
+		fmt.Fprintf(os.Stderr, "Kinesis BatchSize not between 0 and %d\n",
+			maxKinesisBatchSize)
+		*errCount++
+	}
+	checkAWSBatch("Kinesis", kc.FlushInterval, kc.RetryMax, kc.RetryFreq,
+		kc.Timeout, errCount)
+	checkRetryPolicy("Kinesis RetryPolicy", kc.RetryPolicy, errCount)
+}
+
+func checkSQSConfig(sc SQSConfiguration, errCount *int) {
+	if sc.QueueURL == "" && sc.QueueName == "" {
+		fmt.Fprintf(os.Stderr, "SQS QueueURL or QueueName not set\n")
+		*errCount++
+	}
+	if u, err := url.Parse(sc.QueueURL); sc.QueueURL != "" &&
+		(err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+		fmt.Fprintf(os.Stderr, "Invalid SQS QueueURL: %s\n", sc.QueueURL)
+		*errCount++
+	}
+	checkAWSKey("SQS", sc.Key, sc.KeyName, errCount)
+	if sc.BatchSize < 0 || sc.BatchSize > maxSQSBatchSize {
+		fmt.Fprintf(os.Stderr, "SQS BatchSize not between 0 and %d\n",
+			maxSQSBatchSize)
+		*errCount++
+	}
+	checkAWSBatch("SQS", sc.FlushInterval, sc.RetryMax, sc.RetryFreq,
+		sc.Timeout, errCount)
+	checkRetryPolicy("SQS RetryPolicy", sc.RetryPolicy, errCount)
+}
+
+func checkAWSKey(sink string, key kafkaKeyType, keyName string,
+	errCount *int) {
+	switch key {
+	case LevelKey:
+	case TimeSecondKey:
+	case TimeNanoSecondKey:
+	case FixedKey:
+	case ExtractedKey:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid %s Key type: %s\n", sink, key)
+		*errCount++
+	}
+	if (key == FixedKey || key == ExtractedKey) && keyName == "" {
+		fmt.Fprintf(os.Stderr, "%s KeyName not set for Key type: %s\n",
+			sink, key)
+		*errCount++
+	}
+}
+
+func checkAWSBatch(sink string, flushInterval time.Duration, retryMax int,
+	retryFreq, timeout time.Duration, errCount *int) {
+	if flushInterval < 0 {
+		fmt.Fprintf(os.Stderr, "%s FlushInterval less than zero\n", sink)
+		*errCount++
+	}
+	if retryMax < 0 {
+		fmt.Fprintf(os.Stderr, "%s RetryMax less than zero\n", sink)
+		*errCount++
+	}
+	if retryFreq < 0 {
+		fmt.Fprintf(os.Stderr, "%s RetryFreq less than zero\n", sink)
+		*errCount++
+	}
+	if timeout < 0 {
+		fmt.Fprintf(os.Stderr, "%s Timeout less than zero\n", sink)
+		*errCount++
+	}
+}
+
+func checkSamplingConfig(sc SamplingConfiguration, errCount *int) {
+	if sc.Initial < 0 {
+		fmt.Fprintf(os.Stderr, "Sampling Initial less than zero\n")
+		*errCount++
+	}
+	if sc.Thereafter < 0 {
+		fmt.Fprintf(os.Stderr, "Sampling Thereafter less than zero\n")
+		*errCount++
+	}
+	if sc.Tick < 0 {
+		fmt.Fprintf(os.Stderr, "Sampling Tick less than zero\n")
+		*errCount++
+	}
+	if sc.BurstRate < 0 {
+		fmt.Fprintf(os.Stderr, "Sampling BurstRate less than zero\n")
+		*errCount++
+	}
+	if sc.BurstSize < 0 {
+		fmt.Fprintf(os.Stderr, "Sampling BurstSize less than zero\n")
+		*errCount++
+	}
+	for level, override := range sc.Levels {
+		switch level {
+		case DebugType:
+		case InfoType:
+		case WarnType:
+		case ErrorType:
+		case FatalType:
+		case PanicType:
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid Sampling level type: %s\n", level)
+			*errCount++
+		}
+		if override.Initial < 0 || override.Thereafter < 0 {
+			fmt.Fprintf(os.Stderr, "Sampling %s override less than zero\n",
+				level)
+			*errCount++
+		}
+	}
+}
+
+func checkLoggerTypes(lc LoggerConfiguration, errCount *int) {
+	switch lc.LogPackage {
+	case ZapType:
+	case LogrusType:
+	case SlogType:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid LogPackage type: %s\n", lc.LogPackage)
+		*errCount++
+	}
+
+	switch lc.LogLevel {
+	case DebugType:
+	case InfoType:
+	case WarnType:
+	case ErrorType:
+	case FatalType:
+	case PanicType:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid LogLevel type: %s\n", lc.LogLevel)
+		*errCount++
+	}
+
+	outputLevels := []struct {
+		name  string
+		level LevelType
+	}{
+		{"ConsoleLevel", lc.ConsoleLevel},
+		{"FileLevel", lc.FileLevel},
+		{"KafkaLevel", lc.KafkaLevel},
+		{"StackTraceLevel", lc.StackTraceLevel},
+		{"HTTPLevel", lc.HTTPLevel},
+		{"SyslogLevel", lc.SyslogLevel},
+		{"KinesisLevel", lc.KinesisLevel},
+		{"SQSLevel", lc.SQSLevel},
+	}
+	for _, sc := range lc.Sinks {
+		outputLevels = append(outputLevels, struct {
+			name  string
+			level LevelType
+		}{"Sink Level", sc.Level})
+	}
+	for _, fc := range lc.Files {
+		outputLevels = append(outputLevels, struct {
+			name  string
+			level LevelType
+		}{"Files Level", fc.Level})
+	}
+	for _, ol := range outputLevels {
+		switch ol.level {
+		case DebugType:
+		case InfoType:
+		case WarnType:
+		case ErrorType:
+		case FatalType:
+		case PanicType:
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid %s type: %s\n", ol.name, ol.level)
+			*errCount++
+		}
+	}
+
+	switch lc.ConsoleFormat {
+	case JSONFormat:
+	case TextFormat:
+	case CEFormat:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid ConsoleFormat type: %s\n",
+			lc.ConsoleFormat)
+		*errCount++
+	}
+
+	switch lc.ConsoleWriter {
+	case Stdout:
+	case Stderr:
+	case "":
+	default:
+		testInit := os.Getenv(LogTestInitEnvName)
+		if testInit == "true" {
+			file, err := os.Create(string(lc.ConsoleWriter))
+			if err == nil {
+				if debugCapture != nil {
+					releaseLockedWriter(debugCapture)
+				}
+				debugCapture = file
+			} else {
+				fmt.Fprintf(os.Stderr, "debugCapture failed: <%s>\n",
+					err.Error())
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "Invalid ConsoleWriter type: %s\n",
+				lc.ConsoleWriter)
+			*errCount++
+		}
+	}
+
+	switch lc.AsyncPolicy {
+	case AsyncDropOldest:
+	case AsyncBlock:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid AsyncPolicy type: %s\n", lc.AsyncPolicy)
+		*errCount++
+	}
+
 	switch lc.KafkaFormat {
 	case JSONFormat:
 	case TextFormat:
@@ -477,13 +1621,84 @@ func checkLoggerTypes(lc LoggerConfiguration, errCount *int) {
 	switch lc.FileFormat {
 	case JSONFormat:
 	case TextFormat:
-	case "":
 	case CEFormat:
-		fallthrough
+	case "":
 	default:
 		fmt.Fprintf(os.Stderr, "Invalid FileFormat type: %s\n", lc.FileFormat)
 		*errCount++
 	}
+
+	switch lc.FileCompression {
+	case CompressionNone:
+	case CompressionGZIP:
+	case CompressionZSTD:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid FileCompression type: %s\n",
+			lc.FileCompression)
+		*errCount++
+	}
+	checkRetryPolicy("FileRetryPolicy", lc.FileRetryPolicy, errCount)
+	if lc.EnableRotation && lc.FileCompression != CompressionNone &&
+		lc.FileCompression != "" {
+		// rotated files are compressed by RotationCfg Compress instead
+		fmt.Fprintf(os.Stderr, "FileCompression not supported with "+
+			"EnableRotation, use RotationCfg Compress\n")
+		*errCount++
+	}
+
+	switch lc.HTTPFormat {
+	case JSONFormat:
+	case TextFormat:
+	case CEFormat:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid HTTPFormat type: %s\n", lc.HTTPFormat)
+		*errCount++
+	}
+
+	switch lc.SyslogFormat {
+	case JSONFormat:
+	case TextFormat:
+	case CEFormat:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid SyslogFormat type: %s\n",
+			lc.SyslogFormat)
+		*errCount++
+	}
+
+	switch lc.KinesisFormat {
+	case JSONFormat:
+	case TextFormat:
+	case CEFormat:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid KinesisFormat type: %s\n",
+			lc.KinesisFormat)
+		*errCount++
+	}
+
+	switch lc.SQSFormat {
+	case JSONFormat:
+	case TextFormat:
+	case CEFormat:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid SQSFormat type: %s\n", lc.SQSFormat)
+		*errCount++
+	}
+
+	for key := range lc.FieldMap {
+		switch key {
+		case FieldKeyTime:
+		case FieldKeyLevel:
+		case FieldKeyMsg:
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid FieldMap key: %s\n", key)
+			*errCount++
+		}
+	}
 }
 
 func checkCETypes(cc CloudEventsConfiguration, errCount *int) {
@@ -497,6 +1712,40 @@ func checkCETypes(cc CloudEventsConfiguration, errCount *int) {
 		fmt.Fprintf(os.Stderr, "Invalid SetID type: %s\n", cc.SetID)
 		*errCount++
 	}
+
+	for level, ceType := range cc.LevelTypes {
+		switch level {
+		case DebugType:
+		case InfoType:
+		case WarnType:
+		case ErrorType:
+		case FatalType:
+		case PanicType:
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid LevelTypes level: %s\n", level)
+			*errCount++
+		}
+		if ceType == "" {
+			fmt.Fprintf(os.Stderr, "Empty LevelTypes type for: %s\n", level)
+			*errCount++
+		}
+	}
+	for event, ceType := range cc.EventTypes {
+		if ceType == "" {
+			fmt.Fprintf(os.Stderr, "Empty EventTypes type for: %s\n", event)
+			*errCount++
+		}
+	}
+	for name, value := range cc.Extensions {
+		if !validCEExtension(name) {
+			fmt.Fprintf(os.Stderr, "Invalid Extensions name: %s\n", name)
+			*errCount++
+		}
+		if value == "" {
+			fmt.Fprintf(os.Stderr, "Empty Extensions value for: %s\n", name)
+			*errCount++
+		}
+	}
 }
 
 func checkProducerTypes(pc ProducerConfiguration, errCount *int) {
@@ -504,6 +1753,7 @@ func checkProducerTypes(pc ProducerConfiguration, errCount *int) {
 	case RandomPartition:
 	case HashPartition:
 	case RoundRobinPartition:
+	case ManualPartition:
 	case "":
 	default:
 		fmt.Fprintf(os.Stderr, "Invalid Partition type: %s\n", pc.Partition)
@@ -529,6 +1779,7 @@ func checkProducerTypes(pc ProducerConfiguration, errCount *int) {
 	case CompressionSnappy:
 	case CompressionLZ4:
 	case CompressionZSTD:
+	case CompressionAuto:
 	case "":
 	default:
 		fmt.Fprintf(os.Stderr, "Invalid Compression type: %s\n", pc.Compression)
@@ -544,193 +1795,217 @@ func checkProducerTypes(pc ProducerConfiguration, errCount *int) {
 		fmt.Fprintf(os.Stderr, "Invalid AckWait type: %s\n", pc.AckWait)
 		*errCount++
 	}
+
+	switch pc.SASLMechanism {
+	case SASLPlain:
+	case SASLSCRAMSHA256:
+	case SASLSCRAMSHA512:
+	case SASLNone:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid SASLMechanism type: %s\n",
+			pc.SASLMechanism)
+		*errCount++
+	}
+}
+
+func checkConsumerTypes(cc ConsumerConfiguration, errCount *int) {
+	switch cc.Offset {
+	case OffsetNewest:
+	case OffsetOldest:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid Offset type: %s\n", cc.Offset)
+		*errCount++
+	}
+
+	switch cc.Rebalance {
+	case RebalanceRange:
+	case RebalanceRoundRobin:
+	case RebalanceSticky:
+	case "":
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid Rebalance type: %s\n", cc.Rebalance)
+		*errCount++
+	}
 }
 
 // Print emulates function from go log pkg
 func Print(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Info(args...)
+	packageLogger().Info(args...)
 }
 
 // Printf emulates function from go log pkg
 func Printf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Infof(format, args...)
+	packageLogger().Infof(format, args...)
 }
 
 // Println emulates function from go log pkg
 func Println(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Debug emulates function from go log pkg
 func Debug(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Debug(args...)
+	packageLogger().Debug(args...)
 }
 
 // Debugf emulates function from go log pkg
 func Debugf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Debugf(format, args...)
+	packageLogger().Debugf(format, args...)
 }
 
 // Debugln emulates function from go log pkg
 func Debugln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Debug(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Debug(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Info emulates function from go log pkg
 func Info(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Info(args...)
+	packageLogger().Info(args...)
 }
 
 // Infof emulates function from go log pkg
 func Infof(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Infof(format, args...)
+	packageLogger().Infof(format, args...)
 }
 
 // Infoln emulates function from go log pkg
 func Infoln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Warn emulates function from go log pkg
 func Warn(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Warn(args...)
+	packageLogger().Warn(args...)
 }
 
 // Warnf emulates function from go log pkg
 func Warnf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Warnf(format, args...)
+	packageLogger().Warnf(format, args...)
 }
 
 // Warnln emulates function from go log pkg
 func Warnln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Warn(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Warn(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Error emulates function from go log pkg
 func Error(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Error(args...)
+	packageLogger().Error(args...)
 }
 
 // Errorf emulates function from go log pkg
 func Errorf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Errorf(format, args...)
+	packageLogger().Errorf(format, args...)
 }
 
 // Errorln emulates function from go log pkg
 func Errorln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Error(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Error(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Fatal emulates function from go log pkg
 func Fatal(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Fatal(args...)
+	packageLogger().Fatal(args...)
 }
 
 // Fatalf emulates function from go log pkg
 func Fatalf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Fatalf(format, args...)
+	packageLogger().Fatalf(format, args...)
 }
 
 // Fatalln emulates function from go log pkg
 func Fatalln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Fatal(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Fatal(strings.TrimRight(fmt.Sprintln(args...), "\n"))
 }
 
 // Panic emulates function from go log pkg
 func Panic(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Panic(args...)
+	packageLogger().Panic(args...)
 }
 
 // Panicf emulates function from go log pkg
 func Panicf(format string, args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Fatalf(format, args...)
+	packageLogger().Panicf(format, args...)
 }
 
 // Panicln emulates function from go log pkg
 func Panicln(args ...interface{}) {
-	if logger == nil {
-		fmt.Fprintf(os.Stderr, "Logger not initialized\n")
-		return
-	}
-	logger.Panic(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	packageLogger().Panic(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+}
+
+// Flush waits for records of the package logger to be sent
+func Flush(timeout time.Duration) error {
+	return packageLogger().Flush(timeout)
+}
+
+// Close flushes and closes the outputs of the package logger
+func Close() error {
+	return packageLogger().Close()
+}
+
+// With returns the package logger with typed fields
+func With(fields ...Field) Logger {
+	return packageLogger().With(fields...)
+}
+
+// WithError returns the package logger adding the error to records
+func WithError(err error) Logger {
+	return packageLogger().WithError(err)
+}
+
+// WithLazyFields returns the package logger with fields computed when emitted
+func WithLazyFields(fieldsFn func() LogFields) Logger {
+	return packageLogger().WithLazyFields(fieldsFn)
+}
+
+// WithSampledFields returns the package logger with field values kept on a
+// rate fraction of records and hashed on the others
+func WithSampledFields(rate float64, keyValues LogFields) Logger {
+	return packageLogger().WithSampledFields(rate, keyValues)
+}
+
+// WithTopic returns the package logger sending kafka records to the topic
+func WithTopic(topic string) Logger {
+	return packageLogger().WithTopic(topic)
+}
+
+// WithCESource returns the package logger emitting events with the source
+func WithCESource(source string) Logger {
+	return packageLogger().WithCESource(source)
+}
+
+// WithContext returns the package logger with fields from the context
+func WithContext(ctx context.Context) Logger {
+	return packageLogger().WithContext(ctx)
+}
+
+// DebugCtx logs at debug level with fields from the context
+func DebugCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().DebugCtx(ctx, args...)
+}
+
+// InfoCtx logs at info level with fields from the context
+func InfoCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().InfoCtx(ctx, args...)
+}
+
+// WarnCtx logs at warn level with fields from the context
+func WarnCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().WarnCtx(ctx, args...)
+}
+
+// ErrorCtx logs at error level with fields from the context
+func ErrorCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().ErrorCtx(ctx, args...)
+}
+
+// FatalCtx logs at fatal level with fields from the context
+func FatalCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().FatalCtx(ctx, args...)
+}
+
+// PanicCtx logs at panic level with fields from the context
+func PanicCtx(ctx context.Context, args ...interface{}) {
+	packageLogger().PanicCtx(ctx, args...)
 }
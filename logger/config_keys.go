@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigWarning provides an unknown or deprecated key of the config files,
+// deprecated keys are applied to their replacement unless it is also set
+type ConfigWarning struct {
+	Key         string `json:"key" yaml:"key"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Deprecated  bool   `json:"deprecated" yaml:"deprecated"`
+}
+
+// String returns the warning message
+func (w ConfigWarning) String() string {
+	switch {
+	case w.Deprecated:
+		return fmt.Sprintf("Deprecated config key %s, use %s", w.Key,
+			w.Replacement)
+	case w.Replacement != "":
+		return fmt.Sprintf("Unknown config key %s, did you mean %s?", w.Key,
+			w.Replacement)
+	default:
+		return fmt.Sprintf("Unknown config key %s", w.Key)
+	}
+}
+
+// deprecatedKeys maps the keys of earlier config schema versions to their
+// replacements by configuration type
+var deprecatedKeys = map[reflect.Type]map[string]string{
+	reflect.TypeOf(LoggerConfiguration{}): {
+		"enablecolors":    "enablecolorlevels",
+		"enabletimestamp": "enabletimestamps",
+	},
+	reflect.TypeOf(ProducerConfiguration{}): {
+		"flushfreq": "prodflushfreq",
+		"retrymax":  "prodretrymax",
+		"retryfreq": "prodretryfreq",
+	},
+	reflect.TypeOf(CloudEventsConfiguration{}): {
+		"cloudeventsid": "setid",
+	},
+}
+
+var (
+	configWarningsMutex sync.RWMutex
+	configWarnings      []ConfigWarning
+)
+
+// ConfigWarnings returns the warnings of the config files read last
+func ConfigWarnings() []ConfigWarning {
+	configWarningsMutex.RLock()
+	defer configWarningsMutex.RUnlock()
+	return append([]ConfigWarning(nil), configWarnings...)
+}
+
+// configSchema provides the keys of a configuration type as named by viper
+type configSchema struct {
+	keys       map[string]bool
+	open       []string // prefixes of map fields, any key below is known
+	deprecated map[string]string
+}
+
+// newConfigSchema returns the schema of the configuration struct type
+func newConfigSchema(t reflect.Type) *configSchema {
+	s := &configSchema{
+		keys:       map[string]bool{},
+		deprecated: map[string]string{},
+	}
+	s.add(t, "")
+	return s
+}
+
+// add adds the keys of the struct fields with the prefix
+func (s *configSchema) add(t reflect.Type, prefix string) {
+	for old, replacement := range deprecatedKeys[t] {
+		s.deprecated[prefix+old] = prefix + replacement
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		key := prefix + strings.ToLower(field.Name)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			s.add(field.Type, key+".")
+		case reflect.Map, reflect.Interface:
+			s.open = append(s.open, key+".")
+		}
+		s.keys[key] = true
+	}
+}
+
+// known returns true if the key is a field of the configuration
+func (s *configSchema) known(key string) bool {
+	if s.keys[key] {
+		return true
+	}
+	for _, prefix := range s.open {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns the known key closest to the unknown key, if any
+func (s *configSchema) suggest(key string) string {
+	keys := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return closestName(key, keys)
+}
+
+// checkConfigKeys returns the warnings of the keys read from the config
+// files, the values of deprecated keys are merged into their replacements
+// Keys of profiles are checked as keys of the configuration
+func checkConfigKeys(v *viper.Viper, config interface{}) ([]ConfigWarning,
+	error) {
+	t := reflect.TypeOf(config)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	schema := newConfigSchema(t)
+
+	var warnings []ConfigWarning
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		profile, base := "", key
+		if parts := strings.SplitN(key, ".", 3); len(parts) == 3 &&
+			parts[0] == ProfilesKey {
+			profile = parts[0] + "." + parts[1] + "."
+			base = parts[2]
+		}
+		if replacement, ok := schema.deprecated[base]; ok {
+			warnings = append(warnings, ConfigWarning{Key: key,
+				Replacement: profile + replacement, Deprecated: true})
+			if v.InConfig(profile + replacement) {
+				continue
+			}
+			err := v.MergeConfigMap(nestedSetting(profile+replacement,
+				v.Get(key)))
+			if err != nil {
+				return warnings, err
+			}
+			continue
+		}
+		if (profile == "" && key == ProfilesKey) || schema.known(base) {
+			continue
+		}
+		warning := ConfigWarning{Key: key}
+		if suggestion := schema.suggest(base); suggestion != "" {
+			warning.Replacement = profile + suggestion
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings, nil
+}
+
+// nestedSetting returns the setting of the dotted key as nested maps
+func nestedSetting(key string, value interface{}) map[string]interface{} {
+	parts := strings.Split(key, ".")
+	setting := map[string]interface{}{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		setting = map[string]interface{}{parts[i]: setting}
+	}
+	return setting
+}
+
+// reportConfigWarnings writes the warnings to stderr and keeps them for
+// ConfigWarnings
+func reportConfigWarnings(warnings []ConfigWarning) {
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "%s\n", warning)
+	}
+	configWarningsMutex.Lock()
+	configWarnings = warnings
+	configWarningsMutex.Unlock()
+}
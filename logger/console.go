@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// lockedWriter serializes writes so each record is written as a whole line
+// Records from concurrent goroutines and loggers cannot interleave
+type lockedWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+// Write writes the complete record while holding the lock
+func (w *lockedWriter) Write(msg []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	written := 0
+	for written < len(msg) {
+		n, err := w.out.Write(msg[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+var (
+	lockedMutex   sync.Mutex
+	lockedWriters = make(map[io.Writer]*lockedWriter)
+)
+
+// getLockedWriter returns the locked writer shared by all users of the writer
+func getLockedWriter(out io.Writer) *lockedWriter {
+	lockedMutex.Lock()
+	defer lockedMutex.Unlock()
+	writer, ok := lockedWriters[out]
+	if !ok {
+		writer = &lockedWriter{out: out}
+		lockedWriters[out] = writer
+	}
+	return writer
+}
+
+// releaseLockedWriter removes the locked writer of a writer being closed
+func releaseLockedWriter(out io.Writer) {
+	lockedMutex.Lock()
+	defer lockedMutex.Unlock()
+	delete(lockedWriters, out)
+}
+
+// getConsoleWriter returns the locked console writer for the configuration
+func getConsoleWriter(writer ConsoleType) io.Writer {
+	if debugCapture != nil {
+		return getLockedWriter(debugCapture)
+	} else if writer == Stderr {
+		return getLockedWriter(os.Stderr)
+	}
+	return getLockedWriter(os.Stdout)
+}
@@ -0,0 +1,232 @@
+package logger
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	stdlog "log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetType provides kafka initial offset type
+type offsetType string
+
+// Types of initial offsets to map to sarama
+const (
+	OffsetNewest offsetType = "newest" // default
+	OffsetOldest offsetType = "oldest"
+)
+
+// rebalanceType provides kafka consumer group rebalance strategy type
+type rebalanceType string
+
+// Types of rebalance strategies to map to sarama
+const (
+	RebalanceRange      rebalanceType = "range" // default
+	RebalanceRoundRobin rebalanceType = "roundrobin"
+	RebalanceSticky     rebalanceType = "sticky"
+)
+
+// MessageHandler processes a consumed message
+// The offset is marked only if nil is returned, else the message is redelivered
+type MessageHandler func(msg *sarama.ConsumerMessage) error
+
+// RebalanceFunc receives the topic partitions claimed by a group session
+type RebalanceFunc func(claims map[string][]int32)
+
+// ConsumerConfiguration provides kafka consumer configuration type
+type ConsumerConfiguration struct {
+	Brokers      []string
+	Group        string
+	Topics       []string
+	KafkaVersion string
+	Offset       offsetType
+	Rebalance    rebalanceType
+	CommitFreq   time.Duration
+	EnableTLS    bool
+	TLSCfg       *tls.Config
+	EnableDebug  bool
+}
+
+// Consumer wraps sarama consumer group with config
+type Consumer struct {
+	group     sarama.ConsumerGroup
+	config    ConsumerConfiguration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	assignFn  RebalanceFunc
+	revokeFn  RebalanceFunc
+	messages  chan *sarama.ConsumerMessage
+	startOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewConsumer returns a kafka consumer group member
+func NewConsumer(config ConsumerConfiguration) (*Consumer, error) {
+	errCount := 0
+	checkConsumerConfig(config, &errCount)
+	if errCount > 0 {
+		return nil, errors.New("Invalid consumer configuration")
+	}
+
+	if config.EnableDebug {
+		sarama.Logger = stdlog.New(os.Stdout, "[sarama] ", stdlog.LstdFlags)
+	}
+
+	if len(config.Brokers) == 0 || config.Brokers[0] == "" {
+		config.Brokers = defaultConsumerConfiguration.Brokers
+	}
+	if config.KafkaVersion == "" {
+		config.KafkaVersion = defaultConsumerConfiguration.KafkaVersion
+	}
+	version, err := sarama.ParseKafkaVersion(config.KafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = version
+	cfg.Consumer.Return.Errors = true
+	if config.CommitFreq > 0 {
+		cfg.Consumer.Offsets.AutoCommit.Interval = config.CommitFreq
+	}
+
+	switch config.Offset {
+	case OffsetOldest:
+		cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case OffsetNewest:
+		fallthrough
+	default:
+		cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+
+	switch config.Rebalance {
+	case RebalanceRoundRobin:
+		cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case RebalanceSticky:
+		cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+	case RebalanceRange:
+		fallthrough
+	default:
+		cfg.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	}
+
+	if config.EnableTLS {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = config.TLSCfg
+	}
+
+	group, err := sarama.NewConsumerGroup(config.Brokers, config.Group, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Consumer{
+		group:  group,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// SetRebalanceFns sets functions called when partitions are assigned/revoked
+// Must be called before Consume or Messages
+func (c *Consumer) SetRebalanceFns(assignFn, revokeFn RebalanceFunc) {
+	c.assignFn = assignFn
+	c.revokeFn = revokeFn
+}
+
+// Consume passes messages to the handler until the consumer is closed
+// The group is rejoined after each rebalance
+func (c *Consumer) Consume(handler MessageHandler) error {
+	groupHandler := &consumerGroupHandler{
+		consumer: c,
+		handler:  handler,
+	}
+	for {
+		err := c.group.Consume(c.ctx, c.config.Topics, groupHandler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) ||
+			c.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Messages returns a channel of consumed messages
+// Offsets are marked once a message is received from the channel
+func (c *Consumer) Messages() <-chan *sarama.ConsumerMessage {
+	c.startOnce.Do(func() {
+		c.messages = make(chan *sarama.ConsumerMessage)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer close(c.messages)
+			c.Consume(func(msg *sarama.ConsumerMessage) error {
+				select {
+				case c.messages <- msg:
+					return nil
+				case <-c.ctx.Done():
+					return c.ctx.Err()
+				}
+			})
+		}()
+	})
+	return c.messages
+}
+
+// Errors returns a channel of consumer errors, it must be read
+func (c *Consumer) Errors() <-chan error {
+	return c.group.Errors()
+}
+
+// Close leaves the group and commits marked offsets
+func (c *Consumer) Close() error {
+	c.cancel()
+	err := c.group.Close()
+	c.wg.Wait()
+	return err
+}
+
+// consumerGroupHandler meets the interface for the sarama group handler
+type consumerGroupHandler struct {
+	consumer *Consumer
+	handler  MessageHandler
+}
+
+// Setup is called after partitions are assigned to the session
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	if h.consumer.assignFn != nil {
+		h.consumer.assignFn(session.Claims())
+	}
+	return nil
+}
+
+// Cleanup is called before partitions are revoked from the session
+func (h *consumerGroupHandler) Cleanup(
+	session sarama.ConsumerGroupSession) error {
+	if h.consumer.revokeFn != nil {
+		h.consumer.revokeFn(session.Claims())
+	}
+	return nil
+}
+
+// ConsumeClaim passes messages of a claimed partition to the handler
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		// unmarked messages are redelivered after the session restarts
+		if err := h.handler(msg); err != nil {
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// contextKey provides a private type for context values set by this package
+type contextKey string
+
+// Keys for context values set by this package
+const (
+	requestIDContextKey contextKey = "requestid"
+	traceIDContextKey   contextKey = "traceid"
+	traceContextKey     contextKey = "tracecontext"
+)
+
+// Keys for log fields extracted from a context
+const (
+	RequestIDKey = "requestid"
+	TraceIDKey   = "traceid"
+	DeadlineKey  = "deadline"
+)
+
+// ContextExtractor returns log fields from values stored in a context
+type ContextExtractor func(ctx context.Context) LogFields
+
+// TraceContextFunc returns the W3C traceparent and tracestate of the span
+// in the context, such as an OpenTelemetry span context
+// Example: func(ctx context.Context) (string, string) {
+// carrier := propagation.MapCarrier{}
+// propagation.TraceContext{}.Inject(ctx, carrier)
+// return carrier["traceparent"], carrier["tracestate"] }
+type TraceContextFunc func(ctx context.Context) (traceparent, tracestate string)
+
+// traceContext provides the W3C trace context carried by a context
+type traceContext struct {
+	traceparent string
+	tracestate  string
+}
+
+// traceParentFormat matches a W3C traceparent, version 00 or later
+var traceParentFormat = regexp.MustCompile(
+	`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}(-.*)?$`)
+
+var (
+	extractorMutex    sync.RWMutex
+	contextExtractors []ContextExtractor
+	traceContextFn    TraceContextFunc
+)
+
+// ContextWithRequestID returns a context carrying the request id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// ContextWithTraceID returns a context carrying the trace id
+func ContextWithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDContextKey, id)
+}
+
+// ContextWithTraceParent returns a context carrying the W3C trace context
+// Records logged with the context have the traceparent and tracestate
+// fields, the cloudevents distributed tracing extension attributes
+func ContextWithTraceParent(ctx context.Context,
+	traceparent, tracestate string) context.Context {
+	return context.WithValue(ctx, traceContextKey,
+		traceContext{traceparent, tracestate})
+}
+
+// SetTraceContextFunc sets the function returning the trace context of the
+// span in a context, used if the context has no trace context set by
+// ContextWithTraceParent
+func SetTraceContextFunc(fn TraceContextFunc) {
+	extractorMutex.Lock()
+	defer extractorMutex.Unlock()
+	traceContextFn = fn
+}
+
+// RegisterContextExtractor adds an extractor for application context values
+// Extractors are applied in order of registration after the built-in fields
+func RegisterContextExtractor(extractor ContextExtractor) {
+	extractorMutex.Lock()
+	defer extractorMutex.Unlock()
+	contextExtractors = append(contextExtractors, extractor)
+}
+
+// contextFields returns the log fields extracted from the context
+func contextFields(ctx context.Context) LogFields {
+	fields := LogFields{}
+	if ctx == nil {
+		return fields
+	}
+
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		fields[RequestIDKey] = id
+	}
+	if id, ok := ctx.Value(traceIDContextKey).(string); ok {
+		fields[TraceIDKey] = id
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		fields[DeadlineKey] = deadline.Format(time.RFC3339)
+	}
+	for key, val := range Diagnostics(ctx) {
+		fields[key] = val
+	}
+
+	extractorMutex.RLock()
+	defer extractorMutex.RUnlock()
+	trace, ok := ctx.Value(traceContextKey).(traceContext)
+	if !ok && traceContextFn != nil {
+		trace.traceparent, trace.tracestate = traceContextFn(ctx)
+	}
+	// an invalid traceparent invalidates the tracestate
+	if traceParentFormat.MatchString(trace.traceparent) {
+		fields[CETraceParentKey] = trace.traceparent
+		if trace.tracestate != "" {
+			fields[CETraceStateKey] = trace.tracestate
+		}
+	}
+
+	for _, extractor := range contextExtractors {
+		for key, val := range extractor(ctx) {
+			fields[key] = val
+		}
+	}
+	return fields
+}
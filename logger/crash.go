@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Keys of the crash fields added to crash records
+const (
+	PanicKey  = "panic"
+	SignalKey = "signal"
+)
+
+// crashFlushTimeout bounds the wait for the records sent before exiting
+const crashFlushTimeout = 5 * time.Second
+
+// maxCrashStackSize bounds the stacks of all goroutines logged on a signal
+const maxCrashStackSize = 1 << 20
+
+// crashExit exits the process after a fatal signal, replaced by tests
+var crashExit = os.Exit
+
+// RecoverAndLog logs a panic with its stack trace at error level, flushes
+// the package logger then panics again so the process still dies, the
+// logger is not closed as the panic may still be recovered by a caller
+// It must be deferred directly, at the top of main and of goroutines as a
+// panic in one goroutine is not recovered by another
+// Example: defer logger.RecoverAndLog()
+func RecoverAndLog() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	logCrash(LogFields{
+		PanicKey:      fmt.Sprint(value),
+		StackTraceKey: captureStack(packagePath+".RecoverAndLog", "runtime."),
+	}, "Unhandled panic", false)
+	panic(value)
+}
+
+// EnableCrashHandler logs the stacks of all goroutines at error level when
+// a fatal signal is received, flushes and closes the package logger, then
+// exits with status 128 plus the signal number
+// The signals are SIGQUIT and SIGABRT if none is given, SIGINT and SIGTERM
+// are left to the graceful shutdown of the application
+// Returns a function to stop handling the signals
+func EnableCrashHandler(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGQUIT, syscall.SIGABRT}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-ch:
+			signal.Stop(ch)
+			stack := make([]byte, maxCrashStackSize)
+			stack = stack[:runtime.Stack(stack, true)]
+			logCrash(LogFields{
+				SignalKey:     sig.String(),
+				StackTraceKey: string(stack),
+			}, "Fatal signal", true)
+			code := 1
+			if num, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(num)
+			}
+			crashExit(code)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// logCrash logs the crash record then flushes the package logger, closed
+// too when the process exits
+func logCrash(fields LogFields, msg string, exiting bool) {
+	log := packageLogger()
+	log.WithFields(fields).Error(msg)
+	if err := log.Flush(crashFlushTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to flush crash record: %s\n", err)
+	}
+	if !exiting {
+		return
+	}
+	if err := log.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close logger: %s\n", err)
+	}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Dead letter record keys
+const (
+	DeadLetterTimeKey   = "time"
+	DeadLetterErrorKey  = "error"
+	DeadLetterRecordKey = "record"
+)
+
+// deadLetter provides the outputs for records that fail processing
+type deadLetter struct {
+	topic string
+	file  io.WriteCloser
+	out   io.Writer
+}
+
+// newDeadLetter returns a dead letter instance or nil if not configured
+func newDeadLetter(config ProducerConfiguration) (*deadLetter, error) {
+	if config.DeadLetterTopic == "" && config.DeadLetterFile == "" {
+		return nil, nil
+	}
+	dl := &deadLetter{topic: config.DeadLetterTopic}
+	if config.DeadLetterFile != "" {
+		file, err := os.OpenFile(config.DeadLetterFile,
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		dl.file = file
+		dl.out = getLockedWriter(file)
+	}
+	return dl, nil
+}
+
+// record returns the raw formatted record with the processing error
+func (dl *deadLetter) record(msg []byte, err error) ([]byte, error) {
+	return json.Marshal(map[string]string{
+		DeadLetterTimeKey:   now().UTC().Format(time.RFC3339),
+		DeadLetterErrorKey:  err.Error(),
+		DeadLetterRecordKey: string(msg),
+	})
+}
+
+// close closes the dead letter file
+func (dl *deadLetter) close() error {
+	if dl.file == nil {
+		return nil
+	}
+	releaseLockedWriter(dl.file)
+	return dl.file.Close()
+}
+
+// sendDeadLetter writes a record that failed processing to the dead letter
+// outputs, the processing error is returned
+func (kp *KafkaProducer) sendDeadLetter(msg []byte, err error) error {
+	if kp.deadLetter == nil {
+		return err
+	}
+	record, merr := kp.deadLetter.record(msg, err)
+	if merr != nil {
+		return err
+	}
+	if kp.deadLetter.out != nil {
+		kp.deadLetter.out.Write(append(record, '\n'))
+	}
+	if kp.deadLetter.topic != "" {
+		kp.enqueue(&sarama.ProducerMessage{
+			Topic: kp.deadLetter.topic,
+			Value: sarama.ByteEncoder(record),
+		})
+	}
+	return err
+}
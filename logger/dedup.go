@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+)
+
+// DedupEventName is the event name of repeated record summaries, mapped to
+// a cloudevents type by EventTypes
+const DedupEventName = "dedup"
+
+// Keys of the fields of repeated record summaries, which also have the
+// DedupFields of the record
+const (
+	DedupRepeatedKey = "repeated" // records suppressed in the window
+	DedupWindowKey   = "dedupwindow"
+)
+
+// dedupTicks is the number of checks for closed windows per window
+const dedupTicks = 4
+
+// dedupEntry provides the window of a record, the record is summarized
+// with its level, message and fields if repeated
+type dedupEntry struct {
+	opened   time.Time
+	repeated int64
+	level    LevelType
+	message  string
+	fields   LogFields
+}
+
+// dedup suppresses the records identical to a record sent within the window,
+// records are identical if they have the same key, the HMAC of their fields
+type dedup struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	fields  []string // all but the time and id if empty
+	ignored map[string]bool
+	hmac    func(data []byte) string
+	entries map[string]*dedupEntry
+}
+
+// newDedup returns a dedup instance for the producer, nil if disabled
+func (kp *KafkaProducer) newDedup() *dedup {
+	if kp.config.DedupWindow <= 0 {
+		return nil
+	}
+	d := &dedup{
+		window: kp.config.DedupWindow,
+		fields: kp.config.DedupFields,
+		ignored: map[string]bool{
+			kp.timeKey:      true,
+			kp.recordTime:   true,
+			string(CEIDKey): true,
+		},
+		entries: make(map[string]*dedupEntry),
+	}
+	// identical records have the same key as their cloudevents hmac id
+	if kp.enableCE && (kp.cloudEvents.config.SetID == CEHMAC ||
+		kp.cloudEvents.config.SetID == "") {
+		d.hmac = func(data []byte) string {
+			id, _ := kp.cloudEvents.ceDataID(data)
+			return id
+		}
+	} else {
+		var pool sync.Pool
+		pool.New = func() interface{} {
+			return sha256.New()
+		}
+		d.hmac = func(data []byte) string {
+			h := pool.Get().(hash.Hash)
+			h.Reset()
+			h.Write(data)
+			key := base64.StdEncoding.EncodeToString(h.Sum(nil))
+			pool.Put(h)
+			return key
+		}
+	}
+	return d
+}
+
+// allowDedup returns true if the record opens a window or is not an object,
+// the record is counted as repeated otherwise
+func (kp *KafkaProducer) allowDedup(msg []byte) bool {
+	d := kp.dedup
+	var msgMap map[string]interface{}
+	if err := json.Unmarshal(msg, &msgMap); err != nil {
+		return true
+	}
+	identity := make(map[string]interface{}, len(msgMap))
+	if len(d.fields) > 0 {
+		for _, field := range d.fields {
+			identity[field] = msgMap[field]
+		}
+	} else {
+		for field, value := range msgMap {
+			if !d.ignored[field] {
+				identity[field] = value
+			}
+		}
+	}
+	// maps are encoded with sorted keys
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return true
+	}
+	key := d.hmac(data)
+
+	level, _ := msgMap[kp.levelKey].(string)
+	if level == "" {
+		level, _ = msgMap[kp.recordLevel].(string)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if entry, ok := d.entries[key]; ok {
+		entry.repeated++
+		countMetric(MetricSuppressed, "level", level)
+		return false
+	}
+	message, ok := msgMap[kp.recordMsg]
+	if !ok {
+		message = msgMap[kp.messageKey]
+	}
+	entry := &dedupEntry{opened: now(), message: fmt.Sprint(message)}
+	entry.level, err = ParseLevel(level)
+	if err != nil {
+		entry.level = InfoType
+	}
+	if len(d.fields) > 0 {
+		entry.fields = LogFields{}
+		for _, field := range d.fields {
+			if value, ok := msgMap[field]; ok {
+				entry.fields[field] = value
+			}
+		}
+	}
+	d.entries[key] = entry
+	return true
+}
+
+// closeWindows returns the entries of the windows closed at the time, all
+// if the time is zero, the entries not repeated are forgotten
+func (d *dedup) closeWindows(t time.Time) []*dedupEntry {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var repeated []*dedupEntry
+	for key, entry := range d.entries {
+		if !t.IsZero() && t.Sub(entry.opened) < d.window {
+			continue
+		}
+		delete(d.entries, key)
+		if entry.repeated > 0 {
+			repeated = append(repeated, entry)
+		}
+	}
+	return repeated
+}
+
+// summarizeDedup sends a summary event for each record repeated once its
+// window closes until closed
+func (kp *KafkaProducer) summarizeDedup() {
+	tick := kp.dedup.window / dedupTicks
+	if tick < time.Millisecond {
+		tick = time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kp.done:
+			return
+		case <-ticker.C:
+		}
+		kp.sendRepeated(kp.dedup.closeWindows(now()))
+	}
+}
+
+// sendRepeated sends the summary events of the repeated records
+func (kp *KafkaProducer) sendRepeated(entries []*dedupEntry) {
+	for _, entry := range entries {
+		fields := LogFields{
+			DedupRepeatedKey: entry.repeated,
+			DedupWindowKey:   kp.dedup.window.String(),
+		}
+		for key, value := range entry.fields {
+			fields[key] = value
+		}
+		kp.sendEvent(entry.level, fmt.Sprintf("%s (repeated %d times)",
+			entry.message, entry.repeated), DedupEventName, fields)
+	}
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// diagnosticsContextKey is the context key of the diagnostic context
+const diagnosticsContextKey contextKey = "diagnostics"
+
+// RequestIDHeader is the request header read by DiagnosticsMiddleware
+const RequestIDHeader = "X-Request-ID"
+
+// diagnostics provides the fields accumulated during a flow, shared by the
+// contexts derived from the context it was started with
+type diagnostics struct {
+	mutex  sync.RWMutex
+	fields LogFields
+}
+
+// ContextWithDiagnostics returns a context starting a diagnostic context
+// with the fields, inheriting the fields of a parent diagnostic context
+// Fields added to the diagnostic context are logged with every record
+// logged with the context or a context derived from it, by the Ctx methods
+// or by WithContext at the time it is called
+func ContextWithDiagnostics(ctx context.Context,
+	fields LogFields) context.Context {
+	d := &diagnostics{fields: Diagnostics(ctx)}
+	for key, val := range fields {
+		d.fields[key] = val
+	}
+	return context.WithValue(ctx, diagnosticsContextKey, d)
+}
+
+// AddDiagnostics adds the fields to the diagnostic context of the context,
+// visible to every holder of the diagnostic context such as a middleware
+// Returns false if the context has no diagnostic context
+func AddDiagnostics(ctx context.Context, fields LogFields) bool {
+	d, ok := ctx.Value(diagnosticsContextKey).(*diagnostics)
+	if !ok {
+		return false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, val := range fields {
+		d.fields[key] = val
+	}
+	return true
+}
+
+// Diagnostics returns a copy of the fields of the diagnostic context
+func Diagnostics(ctx context.Context) LogFields {
+	fields := LogFields{}
+	if ctx == nil {
+		return fields
+	}
+	d, ok := ctx.Value(diagnosticsContextKey).(*diagnostics)
+	if !ok {
+		return fields
+	}
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for key, val := range d.fields {
+		fields[key] = val
+	}
+	return fields
+}
+
+// DiagnosticsMiddleware starts a diagnostic context for each request,
+// carrying the request id of the X-Request-ID header if set
+func DiagnosticsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if id := r.Header.Get(RequestIDHeader); id != "" {
+			ctx = ContextWithRequestID(ctx, id)
+		}
+		ctx = ContextWithDiagnostics(ctx, nil)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
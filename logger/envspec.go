@@ -0,0 +1,281 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of the environment variable values
+const (
+	EnvString   = "string"
+	EnvBool     = "bool"
+	EnvInt      = "int"
+	EnvFloat    = "float"
+	EnvDuration = "duration" // e.g. 10s
+	EnvList     = "list"     // comma separated
+)
+
+// EnvVar provides a supported environment variable with its default and
+// the value in effect as read from the environment and config file now
+type EnvVar struct {
+	Name    string `json:"name" yaml:"name"`
+	Type    string `json:"type" yaml:"type"`
+	Default string `json:"default" yaml:"default"`
+	Value   string `json:"value" yaml:"value"`
+	Set     bool   `json:"set" yaml:"set"`       // set in the environment
+	Secret  bool   `json:"secret" yaml:"secret"` // default and value redacted
+}
+
+// ErrUnknownEnv is wrapped by the error returned by ValidateEnv
+var ErrUnknownEnv = errors.New("Unknown environment variable")
+
+// envPrefixes are the prefixes of the environment variables read as
+// configuration, including those of the sub configs
+var envPrefixes = []string{LogEnvPrefix, KafkaEnvPrefix,
+	CloudEventsEnvPrefix, RotationEnvPrefix, RetryEnvPrefix}
+
+// maxPrefixEdits is the number of edits of a misspelled prefix, prefixes
+// shorter than minMisspelledPrefix are not matched to avoid other variables
+const (
+	maxPrefixEdits      = 2
+	minMisspelledPrefix = 5
+)
+
+// durationType is the type of duration settings
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ConfigSpec returns the supported environment variables, the nested
+// PRLOG settings of the sub configs included, in configuration order
+// The value is the default or environment value if the configuration
+// cannot be read, the default and value of secrets, including those of
+// nested configurations such as the AWS credentials, are redacted
+func ConfigSpec() []EnvVar {
+	effective, err := environmentConfiguration()
+	if err != nil {
+		effective = *DefaultCompleteCfg()
+	}
+	defaults := *DefaultCompleteCfg()
+
+	vars := []EnvVar{
+		envSetting(LogAutoInitEnvName, EnvBool, "false"),
+		envSetting(ConfigTypeEnvName, EnvString, string(EnvConfig)),
+		envSetting(ConfigFileEnvName, EnvList, ConfigFileName),
+		envSetting(ProfileEnvName, EnvString, ""),
+		envSetting(UninitPolicyEnvName, EnvString, string(UninitStderr)),
+	}
+	vars = appendEnvVars(vars, LogEnvPrefix, reflect.ValueOf(defaults),
+		reflect.ValueOf(effective))
+	vars = appendEnvVars(vars, KafkaEnvPrefix,
+		reflect.ValueOf(defaults.KafkaProducerCfg),
+		reflect.ValueOf(effective.KafkaProducerCfg))
+	vars = appendEnvVars(vars, CloudEventsEnvPrefix,
+		reflect.ValueOf(defaults.CloudEventsCfg),
+		reflect.ValueOf(effective.CloudEventsCfg))
+	vars = appendEnvVars(vars, RotationEnvPrefix,
+		reflect.ValueOf(defaults.RotationCfg),
+		reflect.ValueOf(effective.RotationCfg))
+	for _, name := range []string{RetryProducer, RetryMetadata,
+		RetryDelivery, RetryHTTP, RetryKinesis, RetrySQS, RetryFile} {
+		vars = append(vars, envSetting(RetryEnvPrefix+"_"+name, EnvList, ""))
+	}
+
+	if err != nil {
+		for i, v := range vars {
+			if value, ok := os.LookupEnv(v.Name); ok && v.Secret {
+				vars[i].Value = redactEnvValue(value)
+			} else if ok {
+				vars[i].Value = value
+			}
+		}
+	}
+	return vars
+}
+
+// envSetting returns a variable read by the package, not a configuration
+// field, its value is the environment value or default
+func envSetting(name, envType, defaultValue string) EnvVar {
+	value, set := os.LookupEnv(name)
+	if !set {
+		value = defaultValue
+	}
+	return EnvVar{Name: name, Type: envType, Default: defaultValue,
+		Value: value, Set: set}
+}
+
+// appendEnvVars appends the variables of the fields of the configuration
+// struct, nested structs are joined by underscores as by FillConfiguration
+// Fields that cannot be set from a string, such as maps, are skipped and
+// the values of fields tagged secret are redacted
+func appendEnvVars(vars []EnvVar, prefix string, defaults,
+	effective reflect.Value) []EnvVar {
+	t := defaults.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(field.Name)
+		if field.Type.Kind() == reflect.Struct {
+			vars = appendEnvVars(vars, name, defaults.Field(i),
+				effective.Field(i))
+			continue
+		}
+		envType := envValueType(field.Type)
+		if envType == "" {
+			continue
+		}
+		_, set := os.LookupEnv(name)
+		v := EnvVar{
+			Name:    name,
+			Type:    envType,
+			Default: formatEnvValue(defaults.Field(i)),
+			Value:   formatEnvValue(effective.Field(i)),
+			Set:     set,
+			Secret:  field.Tag.Get("secret") == "true",
+		}
+		if v.Secret {
+			v.Default = redactEnvValue(v.Default)
+			v.Value = redactEnvValue(v.Value)
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// envValueType returns the type of the variables of fields of the type,
+// empty if the type cannot be set from a string
+func envValueType(t reflect.Type) string {
+	if t == durationType {
+		return EnvDuration
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return EnvString
+	case reflect.Bool:
+		return EnvBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return EnvInt
+	case reflect.Float32, reflect.Float64:
+		return EnvFloat
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return EnvList
+		}
+	}
+	return ""
+}
+
+// formatEnvValue returns the value as it is set in the environment
+func formatEnvValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = v.Index(i).String()
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// redactEnvValue returns the value of a secret variable masked if set
+func redactEnvValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedSecret
+}
+
+// ValidateEnv returns an error wrapping ErrUnknownEnv listing the variables
+// with a prefix of the package, or a misspelled prefix, that are not
+// supported, each with the closest supported name if any
+// Example: PRKAFKA_TOPICS (did you mean PRKAFKA_TOPIC?)
+func ValidateEnv() error {
+	known := map[string]bool{}
+	var names []string
+	for _, v := range ConfigSpec() {
+		known[v.Name] = true
+		names = append(names, v.Name)
+	}
+
+	var unknown []string
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if known[name] || !envPrefixed(name) {
+			continue
+		}
+		if closest := closestName(name, names); closest != "" {
+			name = fmt.Sprintf("%s (did you mean %s?)", name, closest)
+		}
+		unknown = append(unknown, name)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("%w: %s", ErrUnknownEnv, strings.Join(unknown, ", "))
+}
+
+// envPrefixed returns true if the name has a prefix of the package or a
+// prefix misspelled by a few edits
+func envPrefixed(name string) bool {
+	index := strings.IndexByte(name, '_')
+	if index == -1 {
+		return false
+	}
+	prefix := name[:index]
+	for _, p := range envPrefixes {
+		if prefix == p || (len(p) >= minMisspelledPrefix &&
+			editDistance(prefix, p) <= maxPrefixEdits) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestName returns the name closest to the misspelled name, empty if
+// none is within a third of its length
+func closestName(name string, names []string) string {
+	closest, best := "", len(name)/3+1
+	for _, n := range names {
+		if d := editDistance(name, n); d < best {
+			closest, best = n, d
+		}
+	}
+	return closest
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and transpositions of adjacent characters turning a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
@@ -0,0 +1,218 @@
+// Package eventgen generates typed Go constructors for the events defined
+// in a YAML file, the generated functions publish the events with a
+// logger.Sender in cloudevents structured mode
+//
+// Example definitions:
+//
+//	package: billing
+//	source: //pavedroad.io/billing
+//	topic: orders
+//	events:
+//	  - name: OrderCreated
+//	    type: io.pavedroad.billing.order.created
+//	    key: id
+//	    subject: id
+//	    fields:
+//	      - name: id
+//	        type: string
+//	        required: true
+//	      - name: amount
+//	        type: float
+package eventgen
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Types of the payload fields and the Go types they are generated as
+const (
+	StringType  = "string"  // string
+	IntType     = "int"     // int64
+	FloatType   = "float"   // float64
+	BoolType    = "bool"    // bool
+	TimeType    = "time"    // time.Time
+	StringsType = "strings" // []string
+	ObjectType  = "object"  // map[string]interface{}
+)
+
+// goTypes maps the field types to Go types
+var goTypes = map[string]string{
+	StringType:  "string",
+	IntType:     "int64",
+	FloatType:   "float64",
+	BoolType:    "bool",
+	TimeType:    "time.Time",
+	StringsType: "[]string",
+	ObjectType:  "map[string]interface{}",
+}
+
+// Definitions provides the events of a definitions file
+type Definitions struct {
+	Package string            `yaml:"package"`
+	Source  string            `yaml:"source"` // default of the events
+	Topic   string            `yaml:"topic"`  // default of the events
+	Events  []EventDefinition `yaml:"events"`
+}
+
+// EventDefinition provides an event type, its cloudevents attributes and
+// payload schema
+type EventDefinition struct {
+	Name        string            `yaml:"name"` // Go type name
+	Description string            `yaml:"description"`
+	Type        string            `yaml:"type"` // cloudevents type
+	Source      string            `yaml:"source"`
+	Topic       string            `yaml:"topic"`      // sender default if empty
+	Key         string            `yaml:"key"`        // string field, message key
+	Subject     string            `yaml:"subject"`    // string field, subject
+	DataSchema  string            `yaml:"dataschema"` // URI-reference
+	Extensions  map[string]string `yaml:"extensions"`
+	Fields      []FieldDefinition `yaml:"fields"`
+}
+
+// FieldDefinition provides a payload field, required fields must not have
+// their zero value
+type FieldDefinition struct {
+	Name        string `yaml:"name"` // JSON name
+	Description string `yaml:"description"`
+	Type        string `yaml:"type"`
+	Required    bool   `yaml:"required"`
+}
+
+var (
+	goIdentifier  = regexp.MustCompile(`^[A-Z][a-zA-Z0-9]*$`)
+	goPackage     = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	fieldName     = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	extensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`) // as cloudevents
+)
+
+// ceAttributes are the attributes that cannot be extensions
+var ceAttributes = map[string]bool{
+	"id": true, "source": true, "specversion": true, "type": true,
+	"datacontenttype": true, "dataschema": true, "subject": true,
+	"time": true, "data": true,
+}
+
+// Parse returns the definitions of the YAML data once validated
+func Parse(data []byte) (*Definitions, error) {
+	var defs Definitions
+	if err := yaml.UnmarshalStrict(data, &defs); err != nil {
+		return nil, err
+	}
+	if err := defs.Validate(); err != nil {
+		return nil, err
+	}
+	return &defs, nil
+}
+
+// ParseFile returns the definitions of the YAML file once validated
+func ParseFile(path string) (*Definitions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Validate returns an error unless the definitions can be generated
+func (d *Definitions) Validate() error {
+	if !goPackage.MatchString(d.Package) {
+		return fmt.Errorf("Invalid package name: %q", d.Package)
+	}
+	if len(d.Events) == 0 {
+		return errors.New("No events defined")
+	}
+	names := map[string]bool{}
+	for _, event := range d.Events {
+		if err := event.validate(); err != nil {
+			return err
+		}
+		for _, name := range []string{event.Name, event.Name + "Type",
+			"Send" + event.Name} {
+			if names[name] {
+				return fmt.Errorf("Event %s: duplicate name %s", event.Name,
+					name)
+			}
+			names[name] = true
+		}
+	}
+	return nil
+}
+
+// validate returns an error unless the event can be generated
+func (e EventDefinition) validate() error {
+	if !goIdentifier.MatchString(e.Name) {
+		return fmt.Errorf("Invalid event name: %q", e.Name)
+	}
+	if e.Type == "" {
+		return fmt.Errorf("Event %s: type missing", e.Name)
+	}
+	for name := range e.Extensions {
+		if !extensionName.MatchString(name) || ceAttributes[name] {
+			return fmt.Errorf("Event %s: invalid extension %s", e.Name, name)
+		}
+	}
+	fields := map[string]FieldDefinition{}
+	goNames := map[string]bool{}
+	for _, field := range e.Fields {
+		if !fieldName.MatchString(field.Name) {
+			return fmt.Errorf("Event %s: invalid field name %q", e.Name,
+				field.Name)
+		}
+		if _, ok := goTypes[field.Type]; !ok {
+			return fmt.Errorf("Event %s: field %s has invalid type %q",
+				e.Name, field.Name, field.Type)
+		}
+		if field.Required && field.Type == BoolType {
+			return fmt.Errorf("Event %s: bool field %s cannot be required",
+				e.Name, field.Name)
+		}
+		goName := exportedName(field.Name)
+		if _, ok := fields[field.Name]; ok || goNames[goName] {
+			return fmt.Errorf("Event %s: duplicate field %s", e.Name,
+				field.Name)
+		}
+		fields[field.Name] = field
+		goNames[goName] = true
+	}
+	for _, attribute := range [][2]string{{"key", e.Key},
+		{"subject", e.Subject}} {
+		name := attribute[1]
+		if name == "" {
+			continue
+		}
+		if field, ok := fields[name]; !ok || field.Type != StringType {
+			return fmt.Errorf("Event %s: %s %s not a string field", e.Name,
+				attribute[0], name)
+		}
+	}
+	return nil
+}
+
+// initialisms are the names spelled in upper case as by Go conventions
+var initialisms = map[string]bool{
+	"api": true, "http": true, "id": true, "ip": true, "json": true,
+	"uid": true, "uri": true, "url": true, "uuid": true,
+}
+
+// exportedName returns the Go field name of the JSON field name
+// Example: "order_id" returns "OrderID"
+func exportedName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package eventgen
+
+import (
+	"flag"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/pavedroad-io/go-core/logger/logtest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerate(t *testing.T) {
+	defs, err := ParseFile("testdata/events.yaml")
+	if err != nil {
+		t.Fatalf("Failed to parse definitions: %s\n", err.Error())
+	}
+	source, err := Generate(defs, "events.yaml")
+	if err != nil {
+		t.Fatalf("Failed to generate events: %s\n", err.Error())
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "events.go", source,
+		0); err != nil {
+		t.Fatalf("Failed to parse generated code: %s\n", err.Error())
+	}
+	logtest.Golden(t, "testdata/events.golden", source, *update)
+}
+
+func TestParseErrors(t *testing.T) {
+	valid := `
+package: billing
+events:
+  - name: OrderCreated
+    type: io.pavedroad.order.created
+    key: order_id
+    fields:
+      - name: order_id
+        type: string
+        required: true
+`
+	if _, err := Parse([]byte(valid)); err != nil {
+		t.Fatalf("Failed to parse valid definitions: %s\n", err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		message string
+	}{
+		{"package", "package: billing", "package: Billing", "package name"},
+		{"unknown", "package: billing", "package: billing\nprefix: x",
+			"field prefix not found"},
+		{"name", "name: OrderCreated", "name: orderCreated", "event name"},
+		{"type", "type: io.pavedroad.order.created", "type: \"\"",
+			"type missing"},
+		{"field type", "type: string", "type: uuid", "invalid type"},
+		{"bool", "type: string", "type: bool", "cannot be required"},
+		{"key", "key: order_id", "key: customer", "not a string field"},
+		{"extension", "key: order_id", "key: order_id\n    extensions:\n" +
+			"      subject: x", "invalid extension subject"},
+		{"duplicate", "      - name: order_id", "      - name: OrderID\n" +
+			"        type: string\n      - name: order_id",
+			"duplicate field"},
+	}
+	for _, test := range tests {
+		definitions := strings.Replace(valid, test.from, test.to, 1)
+		_, err := Parse([]byte(definitions))
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("Expected %s error %q, got %v\n", test.name,
+				test.message, err)
+		}
+	}
+}
+
+func TestExportedName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"order_id": "OrderID", "amount": "Amount", "placedAt": "PlacedAt",
+		"callback_url": "CallbackURL", "a__b": "AB",
+	} {
+		if actual := exportedName(name); actual != expected {
+			t.Errorf("Expected %s from %s, got %s\n", expected, name, actual)
+		}
+	}
+}
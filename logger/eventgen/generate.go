@@ -0,0 +1,193 @@
+package eventgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// genEvent provides an event to the template
+type genEvent struct {
+	EventDefinition
+	Source     string
+	Topic      string
+	KeyField   string // Go name of the key field
+	SubjField  string // Go name of the subject field
+	Fields     []genField
+	Extensions []genExtension
+}
+
+// genField provides a payload field to the template
+type genField struct {
+	FieldDefinition
+	GoName string
+	GoType string
+	Zero   string // condition of the field having its zero value
+}
+
+// genExtension provides an extension attribute to the template
+type genExtension struct {
+	Name  string
+	Value string
+}
+
+// zeroConditions returns the condition of a field of the type having its
+// zero value, %s is replaced with the field
+var zeroConditions = map[string]string{
+	StringType:  `%s == ""`,
+	IntType:     `%s == 0`,
+	FloatType:   `%s == 0`,
+	TimeType:    `%s.IsZero()`,
+	StringsType: `len(%s) == 0`,
+	ObjectType:  `len(%s) == 0`,
+}
+
+var eventTemplate = template.Must(template.New("events").Funcs(
+	template.FuncMap{"line": line}).Parse(`
+// Code generated by eventgen from {{.File}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if .Errors}}
+	"errors"
+{{- end}}
+{{- if .Time}}
+	"time"
+{{- end}}
+
+	"github.com/pavedroad-io/go-core/logger"
+)
+{{range .Events}}{{$event := .Name}}
+// {{.Name}}Type is the cloudevents type of {{.Name}} events
+const {{.Name}}Type = {{printf "%q" .Type}}
+
+// {{.Name}} provides the data of {{.Name}}Type events
+{{- if .Description}}
+// {{line .Description}}
+{{- end}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.GoName}} {{.GoType}} ` + "`" + `json:"{{.Name}}{{if not .Required}},omitempty{{end}}"` + "`" +
+	`{{if .Description}} // {{line .Description}}{{end}}
+{{- end}}
+}
+
+// Validate returns an error if a required field of the event is missing
+func (e {{.Name}}) Validate() error {
+{{- range .Fields}}{{if .Required}}
+	if {{.Zero}} {
+		return errors.New("{{$event}} {{.Name}} missing")
+	}
+{{- end}}{{end}}
+	return nil
+}
+
+// Event returns the cloudevents event of the data
+func (e {{.Name}}) Event() logger.Event {
+	return logger.Event{
+		Type:       {{.Name}}Type,
+		Source:     {{printf "%q" .Source}},
+{{- if .SubjField}}
+		Subject:    e.{{.SubjField}},
+{{- end}}
+{{- if .DataSchema}}
+		DataSchema: {{printf "%q" .DataSchema}},
+{{- end}}
+{{- if .Extensions}}
+		Extensions: map[string]string{
+{{- range .Extensions}}
+			{{printf "%q" .Name}}: {{printf "%q" .Value}},
+{{- end}}
+		},
+{{- end}}
+		Data:       e,
+	}
+}
+
+// Send{{.Name}} validates the event and sends it with the sender to the
+{{- if .Topic}}
+// {{.Topic}} topic
+{{- else}}
+// default topic
+{{- end}}
+func Send{{.Name}}(s *logger.Sender, e {{.Name}}) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	return s.SendEvent({{printf "%q" .Topic}}, {{if .KeyField}}e.{{.KeyField}}{{else}}""{{end}}, e.Event())
+}
+{{end}}`))
+
+// line returns the text on a single line for comments
+func line(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Generate returns the Go source of the definitions read from the file,
+// the file is named in the generated code header only
+func Generate(defs *Definitions, file string) ([]byte, error) {
+	if err := defs.Validate(); err != nil {
+		return nil, err
+	}
+	data := struct {
+		File    string
+		Package string
+		Errors  bool
+		Time    bool
+		Events  []genEvent
+	}{File: file, Package: defs.Package}
+
+	for _, event := range defs.Events {
+		gen := genEvent{
+			EventDefinition: event,
+			Source:          event.Source,
+			Topic:           event.Topic,
+		}
+		if gen.Source == "" {
+			gen.Source = defs.Source
+		}
+		if gen.Topic == "" {
+			gen.Topic = defs.Topic
+		}
+		for _, field := range event.Fields {
+			goName := exportedName(field.Name)
+			gen.Fields = append(gen.Fields, genField{
+				FieldDefinition: field,
+				GoName:          goName,
+				GoType:          goTypes[field.Type],
+				Zero: fmt.Sprintf(zeroConditions[field.Type],
+					"e."+goName),
+			})
+			if field.Name == event.Key {
+				gen.KeyField = goName
+			}
+			if field.Name == event.Subject {
+				gen.SubjField = goName
+			}
+			data.Errors = data.Errors || field.Required
+			data.Time = data.Time || field.Type == TimeType
+		}
+		for name, value := range event.Extensions {
+			gen.Extensions = append(gen.Extensions, genExtension{name, value})
+		}
+		sort.Slice(gen.Extensions, func(i, j int) bool {
+			return gen.Extensions[i].Name < gen.Extensions[j].Name
+		})
+		data.Events = append(data.Events, gen)
+	}
+
+	var buf bytes.Buffer
+	if err := eventTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	source, err := format.Source(bytes.TrimLeft(buf.Bytes(), "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to format generated code: %s\n%s",
+			err.Error(), strings.TrimSpace(buf.String()))
+	}
+	return source, nil
+}
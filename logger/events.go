@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Event provides a cloudevents event sent by SendEvent, typically returned
+// by the constructors generated by eventgen from event definitions
+type Event struct {
+	Type       string            // required
+	Source     string            // default cloudevents source if empty
+	Subject    string            // optional
+	DataSchema string            // optional URI-reference
+	Extensions map[string]string // extension attributes
+	Data       interface{}       // encoded as JSON
+}
+
+// encode returns the event in cloudevents JSON structured mode with a uuid
+// id and the current time
+func (e Event) encode() ([]byte, error) {
+	if e.Type == "" {
+		return nil, errors.New("Event type missing")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	record := map[string]interface{}{}
+	for name, value := range e.Extensions {
+		if !validCEExtension(name) {
+			return nil, fmt.Errorf("Invalid event extension: %s", name)
+		}
+		record[name] = value
+	}
+	record[CEIDKey] = id.String()
+	record[CESourceKey] = e.Source
+	if e.Source == "" {
+		record[CESourceKey] = defaultCloudEventsConfiguration.Source
+	}
+	record[CESpecVersionKey] = defaultCloudEventsConfiguration.SpecVersion
+	record[CETypeKey] = e.Type
+	record[CETimeKey] = now().Format(time.RFC3339)
+	if e.Subject != "" {
+		record[CESubjectKey] = e.Subject
+	}
+	if e.DataSchema != "" {
+		record[CEDataSchemaKey] = e.DataSchema
+	}
+	if e.Data != nil {
+		record[CEDataContentType] = CEJSONContentType
+		record[CEDataKey] = e.Data
+	}
+	return json.Marshal(record)
+}
+
+// SendEvent sends the event in cloudevents structured mode to the topic,
+// the default topic if empty
+func (s *Sender) SendEvent(topic, key string, event Event) error {
+	value, err := event.encode()
+	if err != nil {
+		return err
+	}
+	return s.SendWithCallback(topic, key, value, nil)
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"time"
-
 	log "github.com/pavedroad-io/go-core/logger"
 )
 
@@ -14,5 +12,5 @@ func main() {
 	log.Printf("Logging using env config: %s", "Printf")
 	log.Print("Logging using env config:", "Print")
 	log.Println("Logging using env config:", "Println")
-	time.Sleep(time.Second)
+	log.Close()
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pavedroad-io/go-core/logger"
+)
+
+// Send the events generated from events.yaml, regenerate events.go with
+// go generate once events.yaml is changed
+
+//go:generate go run github.com/pavedroad-io/go-core/logger/cmd/eventgen -in events.yaml
+
+func main() {
+	sender, err := logger.NewSender(logger.ProducerConfiguration{
+		Brokers: []string{"localhost:9092"},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create sender: %s\n", err.Error())
+		os.Exit(1)
+	}
+	defer sender.Close()
+
+	err = SendOrderCreated(sender, OrderCreated{
+		OrderID:  "o-1001",
+		Customer: "c-42",
+		Amount:   19.99,
+		Items:    []string{"book"},
+		PlacedAt: time.Now(),
+	})
+	if err == nil {
+		err = SendOrderCancelled(sender, OrderCancelled{
+			OrderID:  "o-1001",
+			Refunded: true,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not send event: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if err := sender.Flush(5 * time.Second); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not flush events: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
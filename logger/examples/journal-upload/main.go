@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pavedroad-io/go-core/logger"
+)
+
+// Upload the kafka messages journaled by offline loggers
+// The producer configuration is read from the PRKAFKA environment, e.g.
+// PRKAFKA_SPOOLDIR=/var/spool/mycli PRKAFKA_BROKERS=kafka:9092 go run main.go
+
+func main() {
+	timeout := flag.Duration("timeout", time.Minute, "upload timeout")
+	flag.Parse()
+
+	config := logger.ProducerConfiguration{}
+	err := logger.FillConfiguration(logger.DefaultProducerCfg(), &config,
+		logger.EnvConfig, "", logger.KafkaEnvPrefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read producer configuration: %s\n",
+			err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := logger.UploadJournal(ctx, config); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not upload journal %s: %s\n",
+			config.SpoolDir, err.Error())
+		os.Exit(1)
+	}
+}
@@ -2,47 +2,46 @@ package main
 
 import (
 	"fmt"
-	// stdlog "log"
 	"os"
 	"os/signal"
 
-	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
+	"github.com/pavedroad-io/go-core/logger"
 )
 
-var (
-	brokers = []string{"localhost:9092"}
-	group   = "mygroup"
-	topics  = []string{"logs"}
-	config  = cluster.NewConfig()
-)
-
-// Use sarama-cluster to consume logs
+// Use the logger consumer group to consume logs
 
 func main() {
 
-	// init config
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// init config, set EnableDebug to enable connection debugging
+	config := logger.DefaultConsumerCfg()
+	config.Group = "mygroup"
+	config.Topics = []string{"logs"}
+	config.Offset = logger.OffsetOldest
 
 	// init consumer
-	consumer, err := cluster.NewConsumer(brokers, group, topics, config)
+	consumer, err := logger.NewConsumer(config)
 	if err != nil {
 		panic(err)
 	}
-	// uncomment to enable connection debugging
-	// sarama.Logger = stdlog.New(os.Stdout, "[sarama] ", stdlog.LstdFlags)
+	consumer.SetRebalanceFns(
+		func(claims map[string][]int32) {
+			fmt.Printf("Assigned partitions: %v\n\n", claims)
+		},
+		func(claims map[string][]int32) {
+			fmt.Printf("Revoked partitions: %v\n\n", claims)
+		})
 
 	// init exit
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 	defer consumer.Close()
 
-	// consume log messages
+	// consume log messages, offsets are marked as messages are received
+	messages := consumer.Messages()
 	for {
 		select {
-		case msg := <-consumer.Messages():
+		case msg := <-messages:
 			fmt.Printf("P:%d K:%s V:%s\n\n", msg.Partition, msg.Key, msg.Value)
-			consumer.MarkOffset(msg, "kafka-test")
 		case err := <-consumer.Errors():
 			fmt.Fprintf(os.Stderr, "Message error: %s\n", err)
 		case <-interrupt:
@@ -3,14 +3,13 @@ package main
 import (
 	"fmt"
 	"os/user"
-	"time"
 
 	"github.com/pavedroad-io/go-core/logger"
 )
 
 // Create loggers for zap and logrus
 // Note that Kafka producer flush frequency is set to one half second
-// Thus the one second sleeps below will cause the queue to be flushed
+// Thus Close is called below to send the queue before the next logger
 
 func main() {
 	user, _ := user.Current()
@@ -57,7 +56,7 @@ func main() {
 		log.Printf("Zap using %s", "Printf")
 		log.Print("Zap using", "Print")
 		log.Println("Zap using", "Println")
-		time.Sleep(time.Second)
+		log.Close()
 	}
 
 	// try a logrus logger
@@ -77,7 +76,7 @@ func main() {
 		log.Printf("Logrus using %s", "Printf")
 		log.Print("Logrus using", "Print")
 		log.Println("Logrus using", "Println")
-		time.Sleep(time.Second)
+		log.Close()
 	}
 
 	// try setting key to message subject field value
@@ -89,7 +88,7 @@ func main() {
 		fmt.Printf("Could not instantiate logrus logger: %s\n", err.Error())
 	} else {
 		log.Infof("Logrus using Infof and subject key (level)")
-		time.Sleep(time.Second)
+		log.Close()
 	}
 
 	// try setting key to current time in seconds
@@ -100,7 +99,7 @@ func main() {
 		fmt.Printf("Could not instantiate logrus logger: %s\n", err.Error())
 	} else {
 		log.Infof("Logrus using Infof and seconds key")
-		time.Sleep(time.Second)
+		log.Close()
 	}
 
 	// try setting key to current time in nanoseconds
@@ -111,6 +110,6 @@ func main() {
 		fmt.Printf("Could not instantiate logrus logger: %s\n", err.Error())
 	} else {
 		log.Infof("Logrus using Infof and nanoseconds key")
-		time.Sleep(time.Second)
+		log.Close()
 	}
 }
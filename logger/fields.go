@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"log/slog"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fieldType provides the type of value held by a field
+type fieldType uint8
+
+// Types of field values
+const (
+	anyField fieldType = iota
+	stringField
+	intField
+	boolField
+	float64Field
+	durationField
+	errorField
+)
+
+// ErrorKey is the field key used by Err
+const ErrorKey = "error"
+
+// Field provides a typed key value pair for structured logging
+// Fields passed to With are not boxed into interfaces on the zap path
+type Field struct {
+	Key     string
+	ftype   fieldType
+	integer int64
+	str     string
+	iface   interface{}
+}
+
+// String returns a string field
+func String(key string, val string) Field {
+	return Field{Key: key, ftype: stringField, str: val}
+}
+
+// Int returns an int field
+func Int(key string, val int) Field {
+	return Field{Key: key, ftype: intField, integer: int64(val)}
+}
+
+// Int64 returns an int64 field
+func Int64(key string, val int64) Field {
+	return Field{Key: key, ftype: intField, integer: val}
+}
+
+// Bool returns a bool field
+func Bool(key string, val bool) Field {
+	var integer int64
+	if val {
+		integer = 1
+	}
+	return Field{Key: key, ftype: boolField, integer: integer}
+}
+
+// Float64 returns a float64 field
+func Float64(key string, val float64) Field {
+	return Field{Key: key, ftype: float64Field,
+		integer: int64(math.Float64bits(val))}
+}
+
+// Duration returns a duration field
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, ftype: durationField, integer: int64(val)}
+}
+
+// Err returns an error field with the ErrorKey key
+func Err(err error) Field {
+	return Field{Key: ErrorKey, ftype: errorField, iface: err}
+}
+
+// Any returns a field of arbitrary type
+func Any(key string, val interface{}) Field {
+	return Field{Key: key, ftype: anyField, iface: val}
+}
+
+// Value returns the field value as an interface
+func (f Field) Value() interface{} {
+	switch f.ftype {
+	case stringField:
+		return f.str
+	case intField:
+		return f.integer
+	case boolField:
+		return f.integer == 1
+	case float64Field:
+		return math.Float64frombits(uint64(f.integer))
+	case durationField:
+		return time.Duration(f.integer).String()
+	case errorField:
+		if f.iface == nil {
+			return nil
+		}
+		return f.iface.(error).Error()
+	default:
+		return f.iface
+	}
+}
+
+// zapField converts the field to a zap field without boxing the value
+func (f Field) zapField() zap.Field {
+	switch f.ftype {
+	case stringField:
+		return zap.String(f.Key, f.str)
+	case intField:
+		return zap.Int64(f.Key, f.integer)
+	case boolField:
+		return zap.Bool(f.Key, f.integer == 1)
+	case float64Field:
+		return zap.Float64(f.Key, math.Float64frombits(uint64(f.integer)))
+	case durationField:
+		return zap.Duration(f.Key, time.Duration(f.integer))
+	case errorField:
+		if f.iface == nil {
+			return zap.Skip()
+		}
+		return zap.NamedError(f.Key, f.iface.(error))
+	default:
+		return zap.Any(f.Key, f.iface)
+	}
+}
+
+// slogAttr converts the field to a slog attribute without boxing the value
+func (f Field) slogAttr() slog.Attr {
+	switch f.ftype {
+	case stringField:
+		return slog.String(f.Key, f.str)
+	case intField:
+		return slog.Int64(f.Key, f.integer)
+	case boolField:
+		return slog.Bool(f.Key, f.integer == 1)
+	case float64Field:
+		return slog.Float64(f.Key, math.Float64frombits(uint64(f.integer)))
+	case durationField:
+		return slog.String(f.Key, time.Duration(f.integer).String())
+	case errorField:
+		return slog.String(f.Key, f.iface.(error).Error())
+	default:
+		return slog.Any(f.Key, f.iface)
+	}
+}
+
+// fieldsToLogFields converts fields to LogFields
+func fieldsToLogFields(fields []Field) LogFields {
+	logFields := LogFields{}
+	for _, field := range fields {
+		if field.ftype == errorField && field.iface == nil {
+			continue
+		}
+		logFields[field.Key] = field.Value()
+	}
+	return logFields
+}
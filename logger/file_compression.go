@@ -0,0 +1,146 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionExtensions are the file extensions of the compression types
+var compressionExtensions = map[compressionType]string{
+	CompressionGZIP: ".gz",
+	CompressionZSTD: ".zst",
+}
+
+// fileLocation returns the log file location, with the extension of the
+// file compression added if missing
+func (lc LoggerConfiguration) fileLocation() string {
+	location := lc.FileLocation
+	if location == "" {
+		location = defaultLoggerConfiguration.FileLocation
+	}
+	if ext, ok := compressionExtensions[lc.FileCompression]; ok &&
+		!strings.HasSuffix(location, ext) {
+		location += ext
+	}
+	return location
+}
+
+// compressFlushInterval is how often records compressed since the last
+// flush are written to the file, so they can be read while it is open
+const compressFlushInterval = time.Second
+
+// compressor provides a streaming compression encoder
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses records as they are written to the log file
+// Compressed data is written to the file as the encoder blocks fill up, and
+// when flushed or closed, appending a gzip member or zstd frame to an
+// existing file
+type compressWriter struct {
+	mutex   sync.Mutex
+	file    io.WriteCloser
+	encoder compressor
+	written bool // since the last flush
+	closed  bool
+	done    chan struct{}
+}
+
+// openLogFile opens the log file for append as by FileRetryPolicy,
+// compressing the records written if FileCompression is set
+func (lc LoggerConfiguration) openLogFile() (io.Writer, error) {
+	var file *os.File
+	retry := lc.FileRetryPolicy.resolve(0, 0, fileRetryable)
+	err := retry.do(func() (err error) {
+		file, err = os.OpenFile(lc.fileLocation(),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var encoder compressor
+	switch lc.FileCompression {
+	case CompressionGZIP:
+		encoder = gzip.NewWriter(file)
+	case CompressionZSTD:
+		encoder, err = zstd.NewWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	default:
+		return file, nil
+	}
+	w := &compressWriter{file: file, encoder: encoder,
+		done: make(chan struct{})}
+	go w.flushPeriodically()
+	return w, nil
+}
+
+// flushPeriodically flushes the records written every compressFlushInterval
+// until closed
+func (w *compressWriter) flushPeriodically() {
+	ticker := time.NewTicker(compressFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			recordError(w.flushWritten())
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// flushWritten flushes the records written since the last flush
+func (w *compressWriter) flushWritten() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.written || w.closed {
+		return nil
+	}
+	w.written = false
+	return w.encoder.Flush()
+}
+
+// Write compresses the record
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.written = true
+	return w.encoder.Write(p)
+}
+
+// Flush writes the records compressed so far to the file
+func (w *compressWriter) Flush(timeout time.Duration) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.written = false
+	return w.encoder.Flush()
+}
+
+// Close completes the compressed stream and closes the file
+func (w *compressWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	err := w.encoder.Close()
+	if ferr := w.file.Close(); ferr != nil && err == nil {
+		err = ferr
+	}
+	return err
+}
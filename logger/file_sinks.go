@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSinkConfiguration stores the config of a log file written in
+// addition to the FileLocation file, with its own format, level and
+// rotation
+// Example: errors.log at warn in JSON and debug.log at debug in text
+type FileSinkConfiguration struct {
+	Location       string
+	Format         FormatType
+	Level          LevelType // LogLevel if not set
+	EnableRotation bool
+	RotationCfg    RotationConfiguration
+}
+
+// outputName returns the output name of the additional log file
+func (fc FileSinkConfiguration) outputName() string {
+	return FileSinkType + ":" + fc.Location
+}
+
+// openFileSink returns the writer of the additional log file, opened as
+// by FileRetryPolicy unless rotated
+func (lc LoggerConfiguration) openFileSink(fc FileSinkConfiguration) (io.Writer,
+	error) {
+	if fc.EnableRotation {
+		return rotationLogger(fc.Location, fc.RotationCfg), nil
+	}
+	lc.FileLocation = fc.Location
+	lc.FileCompression = CompressionNone
+	return lc.openLogFile()
+}
+
+func checkFileSinkConfig(lc LoggerConfiguration, errCount *int) {
+	locations := map[string]bool{}
+	if lc.EnableFile {
+		locations[filepath.Clean(lc.fileLocation())] = true
+	}
+	for _, fc := range lc.Files {
+		if fc.Location == "" {
+			fmt.Fprintf(os.Stderr, "Files Location missing\n")
+			*errCount++
+		} else if location := filepath.Clean(fc.Location); locations[location] {
+			fmt.Fprintf(os.Stderr, "Files Location written twice: %s\n",
+				fc.Location)
+			*errCount++
+		} else {
+			locations[location] = true
+		}
+
+		switch fc.Format {
+		case JSONFormat:
+		case TextFormat:
+		case CEFormat:
+			if !lc.EnableCloudEvents {
+				fmt.Fprintf(os.Stderr, "CEFormat requires EnableCloudEvents\n")
+				*errCount++
+			}
+		case "":
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid Files Format type: %s\n",
+				fc.Format)
+			*errCount++
+		}
+
+		if fc.EnableRotation {
+			checkRotationConfig(fc.RotationCfg, errCount)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Markers added to records when fields are limited
+const (
+	TruncatedSuffix  = "...[truncated]" // appended to truncated values
+	DroppedFieldsKey = "dropped_fields" // number of fields dropped
+)
+
+// fieldLimits limits the number of fields and length of string field values
+// Protects downstream indexers from unbounded structured data
+type fieldLimits struct {
+	maxFields int // 0 is unlimited
+	maxLength int // 0 is unlimited
+}
+
+// fieldLimits returns the field limits for the configuration
+func (lc LoggerConfiguration) fieldLimits() fieldLimits {
+	return fieldLimits{
+		maxFields: lc.MaxFields,
+		maxLength: lc.MaxFieldLength,
+	}
+}
+
+// enabled returns true if any limit is set
+func (fl fieldLimits) enabled() bool {
+	return fl.maxFields > 0 || fl.maxLength > 0
+}
+
+// keep returns the number of fields kept when adding to the fields present
+func (fl fieldLimits) keep(present, adding int) int {
+	if fl.maxFields <= 0 || present+adding <= fl.maxFields {
+		return adding
+	}
+	if present >= fl.maxFields {
+		return 0
+	}
+	return fl.maxFields - present
+}
+
+// truncate returns the value cut to the max length on a rune boundary
+func (fl fieldLimits) truncate(value string) string {
+	if fl.maxLength <= 0 || len(value) <= fl.maxLength {
+		return value
+	}
+	cut := fl.maxLength
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut] + TruncatedSuffix
+}
+
+// zapGuardCore applies the field limits to the fields of entries written
+type zapGuardCore struct {
+	zapcore.Core
+	limits  fieldLimits
+	fields  int
+	dropped int
+}
+
+// limit returns the fields kept with string values truncated
+func (c *zapGuardCore) limit(fields []zapcore.Field) []zapcore.Field {
+	kept := fields[:c.limits.keep(c.fields, len(fields))]
+	limited := make([]zapcore.Field, len(kept))
+	for i, field := range kept {
+		if field.Type == zapcore.StringType {
+			field.String = c.limits.truncate(field.String)
+		}
+		limited[i] = field
+	}
+	return limited
+}
+
+// With meets the interface for the zap core
+func (c *zapGuardCore) With(fields []zapcore.Field) zapcore.Core {
+	kept := c.limit(fields)
+	return &zapGuardCore{
+		Core:    c.Core.With(kept),
+		limits:  c.limits,
+		fields:  c.fields + len(kept),
+		dropped: c.dropped + len(fields) - len(kept),
+	}
+}
+
+// Check meets the interface for the zap core
+func (c *zapGuardCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+	return checked.AddCore(entry, c)
+}
+
+// Write limits the fields then writes to the cores enabled for the entry
+func (c *zapGuardCore) Write(entry zapcore.Entry,
+	fields []zapcore.Field) error {
+	kept := c.limit(fields)
+	if dropped := c.dropped + len(fields) - len(kept); dropped > 0 {
+		kept = append(kept, zap.Int(DroppedFieldsKey, dropped))
+	}
+	if downstream := c.Core.Check(entry, nil); downstream != nil {
+		downstream.Write(kept...)
+	}
+	return nil
+}
+
+// LogrusGuardHook provides a hook applying the field limits to the entry
+// Fields beyond the limit are dropped in key order
+type LogrusGuardHook struct {
+	limits fieldLimits
+}
+
+// Levels returns all log levels
+func (h *LogrusGuardHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire limits the fields, entry data is a copy made for the record
+func (h *LogrusGuardHook) Fire(entry *logrus.Entry) error {
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kept := h.limits.keep(0, len(keys))
+	for _, key := range keys[kept:] {
+		delete(entry.Data, key)
+	}
+	for _, key := range keys[:kept] {
+		if value, ok := entry.Data[key].(string); ok {
+			entry.Data[key] = h.limits.truncate(value)
+		}
+	}
+	if dropped := len(keys) - kept; dropped > 0 {
+		entry.Data[DroppedFieldsKey] = dropped
+	}
+	return nil
+}
+
+// slogGuardHandler applies the field limits to the attributes of records
+type slogGuardHandler struct {
+	slog.Handler
+	limits  fieldLimits
+	fields  int
+	dropped int
+}
+
+// limit returns the attributes kept with string values truncated
+func (h *slogGuardHandler) limit(attrs []slog.Attr) []slog.Attr {
+	kept := attrs[:h.limits.keep(h.fields, len(attrs))]
+	limited := make([]slog.Attr, len(kept))
+	for i, attr := range kept {
+		attr.Value = attr.Value.Resolve()
+		if attr.Value.Kind() == slog.KindString {
+			attr.Value = slog.StringValue(
+				h.limits.truncate(attr.Value.String()))
+		}
+		limited[i] = attr
+	}
+	return limited
+}
+
+// Handle meets the interface for the slog handler
+func (h *slogGuardHandler) Handle(ctx context.Context, record slog.Record) error {
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	kept := h.limit(attrs)
+	if dropped := h.dropped + len(attrs) - len(kept); dropped > 0 {
+		kept = append(kept, slog.Int(DroppedFieldsKey, dropped))
+	}
+	limited := slog.NewRecord(record.Time, record.Level, record.Message,
+		record.PC)
+	limited.AddAttrs(kept...)
+	return h.Handler.Handle(ctx, limited)
+}
+
+// WithAttrs meets the interface for the slog handler
+func (h *slogGuardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	kept := h.limit(attrs)
+	return &slogGuardHandler{
+		Handler: h.Handler.WithAttrs(kept),
+		limits:  h.limits,
+		fields:  h.fields + len(kept),
+		dropped: h.dropped + len(attrs) - len(kept),
+	}
+}
+
+// WithGroup meets the interface for the slog handler
+func (h *slogGuardHandler) WithGroup(name string) slog.Handler {
+	return &slogGuardHandler{
+		Handler: h.Handler.WithGroup(name),
+		limits:  h.limits,
+		fields:  h.fields,
+		dropped: h.dropped,
+	}
+}
@@ -0,0 +1,59 @@
+package logger
+
+import "time"
+
+// HeartbeatMessage is the message of heartbeat events
+const HeartbeatMessage = "heartbeat"
+
+// HeartbeatEventName is the event name of heartbeat events, mapped to a
+// cloudevents type by EventTypes
+const HeartbeatEventName = "heartbeat"
+
+// Keys of the liveness fields of heartbeat events
+const (
+	HeartbeatSequenceKey = "sequence" // heartbeats sent by the producer
+	UptimeKey            = "uptime"   // seconds since the producer started
+)
+
+// withHeartbeatService returns the config with the service metadata added
+// to the heartbeat fields, so heartbeats identify the service
+func (lc LoggerConfiguration) withHeartbeatService() LoggerConfiguration {
+	if lc.KafkaProducerCfg.HeartbeatInterval <= 0 {
+		return lc
+	}
+	fields := map[string]string{}
+	for key, value := range lc.serviceFields() {
+		fields[key] = value.(string)
+	}
+	for key, value := range lc.KafkaProducerCfg.HeartbeatFields {
+		fields[key] = value
+	}
+	lc.KafkaProducerCfg.HeartbeatFields = fields
+	return lc
+}
+
+// heartbeat sends a heartbeat event at the interval until closed, they are
+// sent whatever the log level so consumers can tell a silent producer from
+// an idle service
+func (kp *KafkaProducer) heartbeat() {
+	started := time.Now()
+	ticker := time.NewTicker(kp.config.HeartbeatInterval)
+	defer ticker.Stop()
+	var sequence int64
+	for {
+		select {
+		case <-kp.done:
+			return
+		case <-ticker.C:
+		}
+		sequence++
+		fields := LogFields{
+			HeartbeatSequenceKey: sequence,
+			UptimeKey:            int64(time.Since(started).Seconds()),
+		}
+		for key, value := range kp.config.HeartbeatFields {
+			fields[key] = value
+		}
+		kp.sendEvent(InfoType, HeartbeatMessage, HeartbeatEventName, fields)
+	}
+}
@@ -0,0 +1,223 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpModeType provides http sink request body type
+type httpModeType string
+
+// Types of http sink request bodies
+const (
+	HTTPLoki   httpModeType = "loki"   // default, Loki push API
+	HTTPNDJSON httpModeType = "ndjson" // newline delimited records
+)
+
+// HTTPSinkType is the output name of the http sink
+const HTTPSinkType = "http"
+
+// ErrHTTPSinkClosed is returned when writing to a closed http sink
+var ErrHTTPSinkClosed = errors.New("HTTP sink closed")
+
+// Content types of http sink requests
+const (
+	lokiContentType   = "application/json"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// HTTPSinkConfiguration provides http log shipping configuration type
+// Records are sent in batches of BatchSize or every FlushInterval
+type HTTPSinkConfiguration struct {
+	URL           string
+	Mode          httpModeType
+	Headers       map[string]string // e.g. Authorization or X-Scope-OrgID
+	Labels        map[string]string // Loki stream labels, level is added
+	BatchSize     int
+	FlushInterval time.Duration
+	EnableGzip    bool
+	RetryMax      int
+	RetryFreq     time.Duration // doubled on each retry
+	RetryPolicy   RetryPolicy   // overrides RetryMax and RetryFreq
+	Timeout       time.Duration
+	WriteTimeout  time.Duration // records not queued in time are dropped
+}
+
+// httpSink provides a sink sending batches of records to an http endpoint
+type httpSink struct {
+	*batchSink
+	config HTTPSinkConfiguration
+	client *http.Client
+}
+
+// newHTTPSink returns an http sink instance sending in the background
+func newHTTPSink(config HTTPSinkConfiguration) (*httpSink, error) {
+	if config.URL == "" {
+		config.URL = defaultHTTPSinkConfiguration.URL
+	}
+	if config.Mode == "" {
+		config.Mode = defaultHTTPSinkConfiguration.Mode
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultHTTPSinkConfiguration.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultHTTPSinkConfiguration.FlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHTTPSinkConfiguration.Timeout
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultHTTPSinkConfiguration.WriteTimeout
+	}
+
+	s := &httpSink{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+	retry := config.RetryPolicy.resolve(config.RetryMax, config.RetryFreq,
+		retryable)
+	s.batchSink = &batchSink{
+		name:          HTTPSinkType,
+		batchSize:     config.BatchSize,
+		flushInterval: config.FlushInterval,
+		writeTimeout:  config.WriteTimeout,
+		retry:         retry,
+		post:          s.post,
+		closedErr:     ErrHTTPSinkClosed,
+	}
+	s.start()
+	return s, nil
+}
+
+// httpSinkFactory returns the http sink of HTTPCfg if enabled, else of the
+// default configuration with the Sinks Options "url"
+func httpSinkFactory(config SinkConfiguration) (Sink, error) {
+	httpCfg := defaultHTTPSinkConfiguration
+	if config.http != nil {
+		httpCfg = *config.http
+	} else if url := config.Options["url"]; url != "" {
+		httpCfg.URL = url
+	}
+	sink, err := newHTTPSink(httpCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// httpStatusError is returned for requests not accepted by the endpoint
+type httpStatusError struct {
+	status int
+}
+
+// Error meets the interface for the error
+func (e *httpStatusError) Error() string {
+	return "HTTP sink request failed: " + strconv.Itoa(e.status) + " " +
+		http.StatusText(e.status)
+}
+
+// retryable returns true if the request may succeed when sent again
+// Requests rejected by the endpoint are not retried unless throttled
+func retryable(err error) bool {
+	statusErr, ok := err.(*httpStatusError)
+	return !ok || statusErr.status == http.StatusTooManyRequests ||
+		statusErr.status >= http.StatusInternalServerError
+}
+
+// post sends the batch in a single request
+func (s *httpSink) post(batch []sinkRecord) error {
+	body, contentType, err := s.encode(batch)
+	if err != nil {
+		return err
+	}
+	if s.config.EnableGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL,
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.config.EnableGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpStatusError{resp.StatusCode}
+	}
+	return nil
+}
+
+// lokiStream provides a Loki push API stream
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encode returns the request body for the batch and its content type
+func (s *httpSink) encode(batch []sinkRecord) ([]byte, string, error) {
+	switch s.config.Mode {
+	case HTTPNDJSON:
+		var buf bytes.Buffer
+		for _, record := range batch {
+			buf.Write(record.line)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), ndjsonContentType, nil
+	case HTTPLoki:
+		// one stream per level, in the order first logged
+		streams := map[LevelType]*lokiStream{}
+		order := []LevelType{}
+		for _, record := range batch {
+			stream, ok := streams[record.entry.Level]
+			if !ok {
+				stream = &lokiStream{Stream: map[string]string{
+					"level": string(record.entry.Level)}}
+				for key, value := range s.config.Labels {
+					stream.Stream[key] = value
+				}
+				streams[record.entry.Level] = stream
+				order = append(order, record.entry.Level)
+			}
+			stream.Values = append(stream.Values, [2]string{
+				strconv.FormatInt(record.entry.Time.UnixNano(), 10),
+				string(record.line)})
+		}
+		push := struct {
+			Streams []*lokiStream `json:"streams"`
+		}{}
+		for _, level := range order {
+			push.Streams = append(push.Streams, streams[level])
+		}
+		body, err := json.Marshal(push)
+		return body, lokiContentType, err
+	default:
+		return nil, "", fmt.Errorf("Invalid HTTP sink Mode: %s", s.config.Mode)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"io"
 	stdlog "log"
+	"math"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -16,6 +20,48 @@ import (
 // Example: log.WithFields(LogFields{TopicKey: "mytopic"}).Infof(...)
 const TopicKey string = "topic"
 
+// PartitionKey is LogFields key to pin the partition of a record with the
+// ManualPartition partitioning
+// Example: log.WithFields(LogFields{PartitionKey: 2}).Infof(...)
+const PartitionKey string = "partition"
+
+// kafkaRoute provides per logger routing and record functions applied at
+// the producer level, derived loggers merge a copy so parents are unchanged
+// Routing is never added to the message payload
+type kafkaRoute struct {
+	topic       string
+	filterFn    FilterFunc
+	keyFn       KeyFunc
+	partitionFn PartitionFunc
+}
+
+// merge returns a copy of the route overridden by set values of next
+func (r *kafkaRoute) merge(next *kafkaRoute) *kafkaRoute {
+	merged := kafkaRoute{}
+	if r != nil {
+		merged = *r
+	}
+	if next.topic != "" {
+		merged.topic = next.topic
+	}
+	if next.filterFn != nil {
+		merged.filterFn = next.filterFn
+	}
+	if next.keyFn != nil {
+		merged.keyFn = next.keyFn
+	}
+	if next.partitionFn != nil {
+		merged.partitionFn = next.partitionFn
+	}
+	return &merged
+}
+
+// ErrProducerClosed is returned when writing to a closed kafka producer
+var ErrProducerClosed = errors.New("Producer closed")
+
+// flushPollFreq is the interval for checking pending messages on flush
+const flushPollFreq = 10 * time.Millisecond
+
 // kafkaPartitionType provides kafka partition type
 type kafkaPartitionType string
 
@@ -24,6 +70,7 @@ const (
 	RandomPartition     kafkaPartitionType = "random" // default
 	HashPartition       kafkaPartitionType = "hash"
 	RoundRobinPartition kafkaPartitionType = "roundrobin"
+	ManualPartition     kafkaPartitionType = "manual" // PartitionKey or func
 )
 
 // kafkaKeyType provides kafka key type
@@ -49,6 +96,7 @@ const (
 	CompressionSnappy compressionType = "snappy"
 	CompressionLZ4    compressionType = "lz4"
 	CompressionZSTD   compressionType = "zstd"
+	CompressionAuto   compressionType = "auto" // best supported by version and brokers
 )
 
 // ackWaitType provides kafka acknowledgment wait type
@@ -70,60 +118,183 @@ type FilterFunc func(*map[string]interface{})
 // KeyFunc func to return key calculated from kafka message contents
 type KeyFunc func(*map[string]interface{}) string
 
+// PartitionFunc func to return partition calculated from kafka message
+// contents with the ManualPartition partitioning
+type PartitionFunc func(*map[string]interface{}) int32
+
 // ProducerConfiguration provides kafka producer configuration type
 type ProducerConfiguration struct {
-	Brokers       []string
-	Topic         string
-	Partition     kafkaPartitionType
-	Key           kafkaKeyType
-	KeyName       string
-	Compression   compressionType
-	AckWait       ackWaitType
-	ProdFlushFreq time.Duration
-	ProdRetryMax  int
-	ProdRetryFreq time.Duration
-	MetaRetryMax  int
-	MetaRetryFreq time.Duration
-	EnableTLS     bool
-	TLSCfg        *tls.Config
-	EnableDebug   bool
-	filterFn      FilterFunc
-	keyFn         KeyFunc
+	Brokers              []string
+	Topic                string
+	RouteRules           []RouteRule // first match overrides Topic
+	KafkaVersion         string
+	ClientID             string
+	Partition            kafkaPartitionType
+	Key                  kafkaKeyType
+	KeyName              string
+	Compression          compressionType
+	CompressionLevel     int // gzip 1-9, zstd 1-22, 0 for codec default
+	AckWait              ackWaitType
+	EnableIdempotent     bool   // exactly once per partition, failed messages not resent
+	TransactionalID      string // not supported, the sarama version has no transactions
+	ProdFlushFreq        time.Duration
+	ProdRetryMax         int
+	ProdRetryFreq        time.Duration
+	MetaRetryMax         int
+	MetaRetryFreq        time.Duration
+	ProdRetryPolicy      RetryPolicy // overrides ProdRetryMax and ProdRetryFreq
+	MetaRetryPolicy      RetryPolicy // overrides MetaRetryMax and MetaRetryFreq
+	WriteTimeout         time.Duration
+	DeadLetterTopic      string
+	DeadLetterFile       string
+	DeliveryRetryMax     int
+	DeliveryRetryFreq    time.Duration
+	DeliveryRetryPolicy  RetryPolicy // overrides DeliveryRetryMax and DeliveryRetryFreq
+	SpillFile            string
+	SpoolDir             string        // spool while unavailable if set
+	SpoolMaxBytes        int64         // messages dropped once full
+	SpoolFileBytes       int64         // segment file rotation size
+	SpoolRetryFreq       time.Duration // reachability check frequency
+	EnableOffline        bool          // journal to SpoolDir, sent by Upload
+	ThrottleLatency      time.Duration
+	HeartbeatInterval    time.Duration     // 0 disables heartbeat events
+	HeartbeatFields      map[string]string // added to heartbeat events
+	QuotaKey             string            // field limited per value, e.g. tenant
+	QuotaRate            float64           // records per second, 0 disables
+	QuotaBurst           int               // the rate rounded up if zero
+	QuotaInterval        time.Duration     // of quota exceeded summaries
+	QuotaMetricValues    int               // labelled in metrics, others as QuotaOtherValue
+	DedupWindow          time.Duration     // identical records suppressed, 0 disables
+	DedupFields          []string          // identify records, all but time if empty
+	ThrottleMaxDelay     time.Duration
+	MaxMessageBytes      int // 0 for sarama default 1000000
+	EnablePreflight      bool
+	PreflightPrincipal   string // ACL principal checked, e.g. User:logger
+	CreateTopics         bool   // create missing topics on startup
+	TopicPartitions      int32
+	TopicReplication     int16
+	TopicRetention       time.Duration            // 0 for broker default
+	TopicNaming          TopicNamingConfiguration // no convention if empty
+	CEBinaryMode         bool                     // data as value, kafka 0.11
+	EnableSchemaRegistry bool
+	SchemaRegistryCfg    SchemaRegistryConfiguration
+	EnableMock           bool
+	EnableTLS            bool
+	TLSCfg               *tls.Config `json:"-" yaml:"-" mapstructure:"-"` // built from the files below if nil
+	CACertFile           string      // system roots if empty
+	CertFile             string      // client certificate, PEM
+	KeyFile              string      // client key, PEM
+	InsecureSkipVerify   bool
+	SASLMechanism        saslMechanismType
+	SASLUser             string
+	SASLPassword         string `secret:"true"`
+	EnableDebug          bool
+	deliveryErrorFn      DeliveryErrorFunc
+}
+
+// messageMeta provides the producer state carried with each message
+type messageMeta struct {
+	attempts int // delivery retries
+	retries  int // producer retries
+	sent     time.Time
+	callback SendCallback
+	replay   *spoolReplay // of the segment replayed
+	line     int          // of the segment replayed
 }
 
 // KafkaProducer wraps sarama producer with config
 type KafkaProducer struct {
+	pending     int64 // unacknowledged messages, first for atomic alignment
 	producer    sarama.AsyncProducer
+	metadata    kafkaMetadata
 	config      ProducerConfiguration
 	cloudEvents *CloudEvents
 	enableCE    bool
 	levelKey    string
+	recordLevel string // level key of records not setting the subject
+	recordMsg   string // message and time keys of records before cloudevents
+	recordTime  string
+	messageKey  string
+	timeKey     string
+	partitioner sarama.PartitionerConstructor
+	deadLetter  *deadLetter
+	spill       io.WriteCloser
+	spool       *spool
+	throttle    *throttle
+	quota       *quota
+	dedup       *dedup
+	registry    *schemaRegistry
+	namer       *TopicNamer // nil without naming convention
+	drained     chan struct{}
+	done        chan struct{}
+	retries     sync.WaitGroup
+	retryPolicy RetryPolicy // delivery retries
+	prodRetry   RetryPolicy // producer retries classified by the logger
+	closeMutex  sync.RWMutex
+	closed      bool
+	stats       producerStats
 }
 
 // newKafkaProducer returns a kafka producer instance
 func newKafkaProducer(config ProducerConfiguration, cloudEvents *CloudEvents,
-	ceConfig CloudEventsConfiguration) (*KafkaProducer, error) {
+	ceConfig CloudEventsConfiguration, fieldMap FieldMap) (*KafkaProducer,
+	error) {
 
 	if config.EnableDebug {
 		sarama.Logger = stdlog.New(os.Stdout, "[sarama] ", stdlog.LstdFlags)
 	}
 
 	cfg := sarama.NewConfig()
-	// TODO Consider reading Errors or Successes channels with goroutines
-	cfg.Producer.Return.Errors = false
-	cfg.Producer.Return.Successes = false
+	if config.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(config.KafkaVersion)
+		if err != nil {
+			return &KafkaProducer{}, err
+		}
+		cfg.Version = version
+	}
+	if config.ClientID != "" {
+		cfg.ClientID = config.ClientID
+	}
+
+	// Errors and Successes are read by drain to count pending messages
+	cfg.Producer.Return.Errors = true
+	cfg.Producer.Return.Successes = true
 
 	cfg.Producer.Flush.Frequency = config.ProdFlushFreq
-	cfg.Producer.Retry.Max = config.ProdRetryMax
-	cfg.Producer.Retry.Backoff = config.ProdRetryFreq
-	cfg.Metadata.Retry.Max = config.MetaRetryMax
-	cfg.Metadata.Retry.Backoff = config.MetaRetryFreq
+	// sarama retries cannot classify errors, with a Retryable the logger
+	// retries failed messages and metadata requests by the policy instead
+	prodRetry := config.ProdRetryPolicy.resolve(config.ProdRetryMax,
+		config.ProdRetryFreq, nil)
+	if prodRetry.Retryable != nil && !config.EnableIdempotent {
+		cfg.Producer.Retry.Max = 0
+	} else {
+		cfg.Producer.Retry.Max = prodRetry.Retries()
+		cfg.Producer.Retry.BackoffFunc = func(retries,
+			maxRetries int) time.Duration {
+			return prodRetry.Delay(retries - 1)
+		}
+		prodRetry = RetryPolicy{}
+	}
+	metaRetry := config.MetaRetryPolicy.resolve(config.MetaRetryMax,
+		config.MetaRetryFreq, nil)
+	if metaRetry.Retryable != nil {
+		cfg.Metadata.Retry.Max = 0
+	} else {
+		cfg.Metadata.Retry.Max = metaRetry.Retries()
+		cfg.Metadata.Retry.BackoffFunc = func(retries,
+			maxRetries int) time.Duration {
+			return metaRetry.Delay(retries)
+		}
+		metaRetry = RetryPolicy{}
+	}
 
 	switch config.Partition {
 	case HashPartition:
 		cfg.Producer.Partitioner = sarama.NewHashPartitioner
 	case RoundRobinPartition:
 		cfg.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	case ManualPartition:
+		cfg.Producer.Partitioner = sarama.NewManualPartitioner
 	case RandomPartition:
 		fallthrough
 	default:
@@ -139,12 +310,23 @@ func newKafkaProducer(config ProducerConfiguration, cloudEvents *CloudEvents,
 		cfg.Producer.Compression = sarama.CompressionLZ4
 	case CompressionZSTD:
 		cfg.Producer.Compression = sarama.CompressionZSTD
+	case CompressionAuto:
+		cfg.Producer.Compression = autoCompression(cfg.Version)
 	case CompressionNone:
 		fallthrough
 	default:
 		cfg.Producer.Compression = sarama.CompressionNone
 	}
 
+	if config.MaxMessageBytes != 0 {
+		cfg.Producer.MaxMessageBytes = config.MaxMessageBytes
+	}
+
+	// sarama ignores the zstd level before v1.31, passed for later versions
+	if config.CompressionLevel != 0 {
+		cfg.Producer.CompressionLevel = config.CompressionLevel
+	}
+
 	switch config.AckWait {
 	case WaitForNone:
 		cfg.Producer.RequiredAcks = sarama.NoResponse
@@ -156,18 +338,52 @@ func newKafkaProducer(config ProducerConfiguration, cloudEvents *CloudEvents,
 		cfg.Producer.RequiredAcks = sarama.WaitForLocal
 	}
 
-	if config.EnableTLS {
-		cfg.Net.TLS.Enable = true
-		cfg.Net.TLS.Config = config.TLSCfg
+	// idempotent producer numbers the batches of each partition so brokers
+	// discard those resent by retries, requiring kafka 0.11 and one request
+	// in flight per broker to keep the order
+	if config.EnableIdempotent {
+		cfg.Producer.Idempotent = true
+		cfg.Producer.RequiredAcks = sarama.WaitForAll
+		cfg.Net.MaxOpenRequests = 1
+		if config.KafkaVersion == "" {
+			cfg.Version = sarama.V0_11_0_0
+		}
+	}
+
+	// cloudevents binary mode sends the attributes as record headers,
+	// requiring kafka 0.11
+	if config.CEBinaryMode && config.KafkaVersion == "" {
+		cfg.Version = sarama.V0_11_0_0
+	}
+
+	if err := setSecurity(cfg, config); err != nil {
+		return &KafkaProducer{}, err
 	}
 
 	var enableCE bool = false
-	var levelKey string = "level"
+	var levelKey string = fieldMap.resolve(FieldKeyLevel)
+	var messageKey string = fieldMap.resolve(FieldKeyMsg)
+	var timeKey string = fieldMap.resolve(FieldKeyTime)
 	if cloudEvents != nil {
 		enableCE = true
 		if ceConfig.SetSubjectLevel {
 			levelKey = CESubjectKey
 		}
+		messageKey = CEDataKey
+		timeKey = CETimeKey
+	}
+
+	// topics of the configuration are named once
+	var namer *TopicNamer
+	if config.TopicNaming.enabled() {
+		var err error
+		namer, err = NewTopicNamer(config.TopicNaming)
+		if err != nil {
+			return &KafkaProducer{}, err
+		}
+		if config, err = namer.nameTopics(config); err != nil {
+			return &KafkaProducer{}, err
+		}
 	}
 
 	kp := KafkaProducer{
@@ -176,6 +392,22 @@ func newKafkaProducer(config ProducerConfiguration, cloudEvents *CloudEvents,
 		cloudEvents: cloudEvents,
 		enableCE:    enableCE,
 		levelKey:    levelKey,
+		recordLevel: fieldMap.resolve(FieldKeyLevel),
+		recordMsg:   fieldMap.resolve(FieldKeyMsg),
+		recordTime:  fieldMap.resolve(FieldKeyTime),
+		messageKey:  messageKey,
+		timeKey:     timeKey,
+		partitioner: cfg.Producer.Partitioner,
+		retryPolicy: config.DeliveryRetryPolicy.resolve(
+			config.DeliveryRetryMax, config.DeliveryRetryFreq, nil),
+		prodRetry: prodRetry,
+		namer:     namer,
+	}
+	// messages sent again by the logger are new to the idempotent producer
+	// so would be duplicated if delivered before failing, only sarama
+	// retries them, failed messages are neither retried nor spooled
+	if config.EnableIdempotent {
+		kp.retryPolicy = RetryPolicy{}
 	}
 
 	if len(config.Brokers) == 0 || config.Brokers[0] == "" {
@@ -188,27 +420,360 @@ func newKafkaProducer(config ProducerConfiguration, cloudEvents *CloudEvents,
 		kp.config.KeyName = defaultProducerConfiguration.KeyName
 	}
 
-	producer, err := sarama.NewAsyncProducer(kp.config.Brokers, cfg)
+	// the files opened are closed if the producer is not created
+	created := false
+	defer func() {
+		if created {
+			return
+		}
+		if kp.spill != nil {
+			releaseLockedWriter(kp.spill)
+			kp.spill.Close()
+		}
+		if kp.deadLetter != nil {
+			kp.deadLetter.close()
+		}
+	}()
+
+	deadLetter, err := newDeadLetter(config)
+	if err != nil {
+		return &KafkaProducer{}, err
+	}
+	kp.deadLetter = deadLetter
+
+	spill, err := openSpill(config.SpillFile)
+	if err != nil {
+		return &KafkaProducer{}, err
+	}
+	kp.spill = spill
+	kp.throttle = newThrottle(kp.config)
+	kp.quota = newQuota(kp.config)
+	kp.dedup = kp.newDedup()
+
+	spool, err := newSpool(kp.config)
 	if err != nil {
 		return &KafkaProducer{}, err
 	}
-	kp.producer = producer
+	kp.spool = spool
+
+	if config.EnableSchemaRegistry {
+		registry, err := newSchemaRegistry(config.SchemaRegistryCfg,
+			kp.config.Topic)
+		if err != nil {
+			return &KafkaProducer{}, err
+		}
+		kp.registry = registry
+	}
+
+	// offline producer journals messages, no broker contacted
+	// mock producer records messages in memory, no broker required
+	// factory producer is provided by tests, e.g. a sarama mock
+	if config.EnableOffline {
+		kp.producer = newOfflineProducer()
+		kp.metadata = offlineMetadata{}
+	} else if config.EnableMock {
+		kp.producer = newMockProducer(kp.partitioner)
+		kp.metadata = &mockMetadata{}
+	} else if factory := getProducerFactory(); factory != nil {
+		producer, err := factory(cfg)
+		if err != nil {
+			return &KafkaProducer{}, err
+		}
+		kp.producer = producer
+		kp.metadata = &mockMetadata{}
+	} else {
+		// producer uses a client owned by the kafka producer for metadata
+		client, err := sarama.NewClient(kp.config.Brokers, cfg)
+		if err != nil {
+			return &KafkaProducer{}, err
+		}
+		// the client shares the config read by the producer once created
+		if config.Compression == CompressionAuto {
+			cfg.Producer.Compression = negotiateCompression(client)
+		}
+		producer, err := sarama.NewAsyncProducerFromClient(client)
+		if err != nil {
+			client.Close()
+			return &KafkaProducer{}, err
+		}
+		kp.producer = producer
+		kp.metadata = &saramaMetadata{client, metaRetry}
+	}
+
+	// topics created before preflight so they are validated once created
+	if config.CreateTopics && !config.EnableOffline {
+		if err := kp.createTopics(); err != nil {
+			kp.producer.Close()
+			kp.metadata.Close()
+			return &KafkaProducer{}, err
+		}
+	}
+
+	// preflight reports configuration problems now rather than on delivery
+	if config.EnablePreflight && !config.EnableOffline {
+		if err := kp.preflight(cfg.Producer.MaxMessageBytes); err != nil {
+			kp.producer.Close()
+			kp.metadata.Close()
+			return &KafkaProducer{}, err
+		}
+	}
+
+	kp.drained = make(chan struct{})
+	kp.done = make(chan struct{})
+	go kp.drain()
+	if kp.spool != nil {
+		if kp.config.EnableOffline {
+			close(kp.spool.stopped) // journaled until uploaded
+		} else {
+			go kp.replay()
+		}
+	}
+	if kp.config.HeartbeatInterval > 0 {
+		go kp.heartbeat()
+	}
+	if kp.quota != nil {
+		go kp.summarizeQuota()
+	}
+	if kp.dedup != nil {
+		go kp.summarizeDedup()
+	}
+	created = true
+	addProducer(&kp)
 
 	return &kp, nil
 }
 
-// setFilterFn sets the kafka message filter function
-func (kp *KafkaProducer) setFilterFn(filterFn FilterFunc) {
-	kp.config.filterFn = filterFn
+// produceAPIKey is the kafka API key of produce requests
+const produceAPIKey = 0
+
+// negotiateCompression returns the best compression supported by both the
+// kafka version and the brokers, gzip accepted by every broker if their
+// versions cannot be requested
+func negotiateCompression(client sarama.Client) sarama.CompressionCodec {
+	version := client.Config().Version
+	if !version.IsAtLeast(sarama.V0_10_0_0) {
+		// brokers before 0.10 do not list versions, snappy is accepted
+		return autoCompression(version)
+	}
+	brokers := client.Brokers()
+	if len(brokers) == 0 {
+		return sarama.CompressionGZIP
+	}
+	for _, broker := range brokers {
+		produce, err := produceVersion(broker, client.Config())
+		if err != nil {
+			return sarama.CompressionGZIP
+		}
+		version = negotiatedVersion(version, produce)
+	}
+	return autoCompression(version)
 }
 
-// setKeyFn sets the kafka message key function
-func (kp *KafkaProducer) setKeyFn(keyFn KeyFunc) {
-	kp.config.keyFn = keyFn
+// produceVersion returns the latest produce request version of the broker
+func produceVersion(broker *sarama.Broker, conf *sarama.Config) (int16,
+	error) {
+	if err := broker.Open(conf); err != nil &&
+		err != sarama.ErrAlreadyConnected {
+		return 0, err
+	}
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return 0, err
+	}
+	if response.Err != sarama.ErrNoError {
+		return 0, response.Err
+	}
+	for _, api := range response.ApiVersions {
+		if api.ApiKey == produceAPIKey {
+			return api.MaxVersion, nil
+		}
+	}
+	return 0, errors.New("Broker produce version missing")
 }
 
+// negotiatedVersion returns the kafka version capped at the first version
+// accepting the produce request version, 7 adding zstd and 2 lz4
+func negotiatedVersion(version sarama.KafkaVersion,
+	produce int16) sarama.KafkaVersion {
+	broker := sarama.V0_8_2_0
+	switch {
+	case produce >= 7:
+		broker = sarama.V2_1_0_0
+	case produce >= 2:
+		broker = sarama.V0_10_0_0
+	}
+	if version.IsAtLeast(broker) {
+		return broker
+	}
+	return version
+}
+
+// autoCompression returns the best compression supported by the kafka version
+func autoCompression(version sarama.KafkaVersion) sarama.CompressionCodec {
+	switch {
+	case version.IsAtLeast(sarama.V2_1_0_0):
+		return sarama.CompressionZSTD
+	case version.IsAtLeast(sarama.V0_10_0_0):
+		return sarama.CompressionLZ4
+	default:
+		return sarama.CompressionSnappy
+	}
+}
+
+// drain reads producer results until closed, counting down pending messages
+// Failed messages are retried and remain pending until delivered or failed
+func (kp *KafkaProducer) drain() {
+	defer close(kp.drained)
+	successes := kp.producer.Successes()
+	failures := kp.producer.Errors()
+	for successes != nil || failures != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			meta, _ := msg.Metadata.(messageMeta)
+			countMetric(MetricKafkaSuccesses, "", "")
+			kp.stats.delivered(msg.Topic, msg.Partition)
+			observeLatency(time.Since(meta.sent))
+			if kp.throttle != nil {
+				kp.throttle.acknowledged(time.Since(meta.sent))
+			}
+			if meta.callback != nil {
+				meta.callback(int64(msg.Partition), msg.Offset, nil)
+			}
+			if meta.replay != nil {
+				meta.replay.done(meta.line, false)
+			}
+		case perr, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			countMetric(MetricKafkaFailures, "", "")
+			kp.stats.failed(perr.Err)
+			if !kp.spoolFailed(perr) {
+				if kp.retry(perr) {
+					continue
+				}
+				kp.deliveryFailed(perr.Msg, perr.Err)
+			}
+		}
+		atomic.AddInt64(&kp.pending, -1)
+	}
+}
+
+// flush waits until all pending messages are acknowledged
+// A timeout of zero waits without limit
+func (kp *KafkaProducer) flush(timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	ticker := time.NewTicker(flushPollFreq)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&kp.pending) > 0 {
+		select {
+		case <-ticker.C:
+		case <-expired:
+			return ErrFlushTimeout
+		}
+	}
+	return nil
+}
+
+// close sends pending messages and closes the producer
+func (kp *KafkaProducer) close() error {
+	// the windows open are closed while records can be sent
+	if kp.dedup != nil {
+		kp.sendRepeated(kp.dedup.closeWindows(time.Time{}))
+	}
+	kp.closeMutex.Lock()
+	if kp.closed {
+		kp.closeMutex.Unlock()
+		return nil
+	}
+	kp.closed = true
+	if kp.done != nil {
+		close(kp.done)
+	}
+	kp.closeMutex.Unlock()
+	removeProducer(kp)
+
+	err := kp.producer.Close()
+	if kp.drained != nil {
+		<-kp.drained
+	}
+	kp.retries.Wait()
+	if kp.spool != nil {
+		<-kp.spool.stopped
+		if serr := kp.spool.close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if kp.metadata != nil {
+		if merr := kp.metadata.Close(); merr != nil && err == nil {
+			err = merr
+		}
+	}
+	if kp.spill != nil {
+		releaseLockedWriter(kp.spill)
+		if serr := kp.spill.Close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if kp.deadLetter != nil {
+		if derr := kp.deadLetter.close(); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+// getPartition returns the partition pinned by the record partition field,
+// deleted from the record, or else by the partition function of the logger,
+// 0 if neither
+func (kp *KafkaProducer) getPartition(msgMap map[string]interface{},
+	partitionFn PartitionFunc) (int32, error) {
+	value, ok := msgMap[PartitionKey]
+	if !ok {
+		if partitionFn != nil {
+			return partitionFn(&msgMap), nil
+		}
+		return 0, nil
+	}
+	delete(msgMap, PartitionKey)
+
+	var partition int64
+	var err error
+	switch v := value.(type) {
+	case float64:
+		partition = int64(v)
+		if float64(partition) != v {
+			err = errors.New("Partition not an integer")
+		}
+	case string:
+		partition, err = strconv.ParseInt(v, 10, 32)
+	default:
+		err = errors.New("Partition not an integer")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if partition < 0 || partition > math.MaxInt32 {
+		return 0, errors.New("Partition out of range")
+	}
+	return int32(partition), nil
+}
+
+// getKey sets the key of the record as by the key type, the key function
+// of the logger is used with the FunctionKey type
 func (kp *KafkaProducer) getKey(msgMap map[string]interface{},
-	key *sarama.Encoder) error {
+	keyFn KeyFunc, key *sarama.Encoder) error {
 
 	// get key based on kp config
 	switch kp.config.Key {
@@ -222,72 +787,261 @@ func (kp *KafkaProducer) getKey(msgMap map[string]interface{},
 			return errors.New("Extracted key missing")
 		}
 	case TimeSecondKey:
-		*key = sarama.StringEncoder(strconv.Itoa(int(time.Now().Unix())))
+		*key = sarama.StringEncoder(strconv.Itoa(int(now().Unix())))
 	case TimeNanoSecondKey:
-		*key = sarama.StringEncoder(strconv.Itoa(int(time.Now().UnixNano())))
+		*key = sarama.StringEncoder(strconv.Itoa(int(now().UnixNano())))
 	case FunctionKey:
-		if kp.config.keyFn != nil {
-			*key = sarama.StringEncoder(kp.config.keyFn(&msgMap))
+		if keyFn != nil {
+			*key = sarama.StringEncoder(keyFn(&msgMap))
 			break
 		}
 		fallthrough
 	case LevelKey:
 		fallthrough
 	default:
-		*key = sarama.StringEncoder(msgMap[kp.levelKey].(string))
+		if level, ok := msgMap[kp.levelKey].(string); ok {
+			*key = sarama.StringEncoder(level)
+		} else {
+			return errors.New("Level key missing")
+		}
 	}
 	return nil
 }
 
 // sendMessage adds key and cloudevents ID before sending message to kafka
-func (kp *KafkaProducer) sendMessage(msg []byte) error {
+// Records that fail processing are written to the dead letter outputs
+// Records exceeding the quota of their key value are dropped
+// Records repeated within the dedup window are suppressed
+func (kp *KafkaProducer) sendMessage(msg []byte, route *kafkaRoute) error {
+	if kp.dedup != nil && !kp.allowDedup(msg) {
+		return nil
+	}
+	if kp.quota != nil && !kp.quota.allow(msg) {
+		return nil
+	}
+	pmsg, err := kp.processMessage(msg, route)
+	if err != nil {
+		recordError(err)
+		countDropped(DropProcessing)
+		return kp.sendDeadLetter(msg, err)
+	}
+	err = kp.enqueue(pmsg)
+	recordError(err)
+	return err
+}
+
+// sendEvent sends an event of the producer formatted as by the loggers,
+// such as heartbeats, events are exempt from quotas
+func (kp *KafkaProducer) sendEvent(level LevelType, message, event string,
+	fields LogFields) {
+	record := map[string]interface{}{}
+	if kp.enableCE {
+		for key, value := range kp.cloudEvents.fields {
+			record[key] = value
+		}
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	record[kp.levelKey] = string(level)
+	record[kp.messageKey] = message
+	record[kp.timeKey] = now().Format(time.RFC3339)
+	record[EventNameKey] = event
+
+	msg, err := json.Marshal(record)
+	if err != nil {
+		recordError(err)
+		return
+	}
+	pmsg, err := kp.processMessage(msg, nil)
+	if err != nil {
+		recordError(err)
+		countDropped(DropProcessing)
+		return
+	}
+	recordError(kp.enqueue(pmsg))
+}
+
+// processMessage returns the kafka message for the formatted record
+func (kp *KafkaProducer) processMessage(msg []byte,
+	route *kafkaRoute) (*sarama.ProducerMessage, error) {
+	if kp.fastPath(route) {
+		if pmsg, ok := kp.processFast(msg, route); ok {
+			return pmsg, nil
+		}
+	}
+
 	var msgMap map[string]interface{}
 
 	// unmarshal message to access fields
 	err := json.Unmarshal(msg, &msgMap)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// capture topic if passed else use default
-	topic, ok := msgMap[TopicKey]
-	if ok {
+	// capture topic if passed else use the first matching route rule or
+	// default, route topic has precedence
+	topic, named := msgMap[TopicKey]
+	if named {
 		delete(msgMap, TopicKey)
+	} else if ruleTopic, ok := kp.routeTopic(msgMap); ok {
+		topic = ruleTopic
 	} else {
 		topic = kp.config.Topic
 	}
+	if route != nil && route.topic != "" {
+		topic = route.topic
+		named = true
+	}
+	topicName, ok := topic.(string)
+	if !ok {
+		return nil, errors.New("Topic not a string")
+	}
+	// topics given to the logger follow the naming convention
+	if named {
+		if topicName, err = kp.topicName(topicName); err != nil {
+			return nil, err
+		}
+	}
+
+	var filterFn FilterFunc
+	var keyFn KeyFunc
+	var partitionFn PartitionFunc
+	if route != nil {
+		filterFn, keyFn = route.filterFn, route.keyFn
+		partitionFn = route.partitionFn
+	}
 
 	// get kafka key, may delete key from map
 	var key sarama.Encoder
-	err = kp.getKey(msgMap, &key)
+	err = kp.getKey(msgMap, keyFn, &key)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// get pinned partition, may delete partition from map
+	var partition int32
+	if kp.config.Partition == ManualPartition {
+		if partition, err = kp.getPartition(msgMap, partitionFn); err != nil {
+			return nil, err
+		}
 	}
 
 	// filter function performs field manipulation
-	if kp.config.filterFn != nil {
-		kp.config.filterFn(&msgMap)
+	if filterFn != nil {
+		filterFn(&msgMap)
 	}
 
 	// add cloudevents fields like id (possibly dependent of message)
 	// thus must be after all message map manipulation before re-marshal
+	var extensions []string
 	if kp.enableCE {
-		err = kp.cloudEvents.ceAddFields(msgMap)
+		extensions, err = kp.cloudEvents.ceAddFields(msgMap)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	// binary mode moves cloudevents attributes to headers and sends the
+	// event data alone as the value
+	var headers []sarama.RecordHeader
+	var value interface{} = msgMap
+	if kp.enableCE && kp.config.CEBinaryMode {
+		headers = ceBinaryHeaders(msgMap, extensions)
+		value = msgMap[CEDataKey]
+	}
+
 	// re-marshal message after field manipulation
-	newmsg, err := json.Marshal(msgMap)
+	var newmsg []byte
+	if kp.registry != nil {
+		newmsg, err = kp.registry.encode(value)
+	} else {
+		newmsg, err = json.Marshal(value)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &sarama.ProducerMessage{
+		Key:       key,
+		Topic:     topicName,
+		Value:     sarama.ByteEncoder(newmsg),
+		Headers:   headers,
+		Partition: partition,
+	}, nil
+}
 
-	kp.producer.Input() <- &sarama.ProducerMessage{
-		Key:   key,
-		Topic: topic.(string),
-		Value: sarama.ByteEncoder(newmsg),
+// ceBinaryHeaders removes the cloudevents attributes from the record and
+// returns them as headers per the cloudevents kafka protocol binding
+// Extension attributes follow the core attributes
+func ceBinaryHeaders(msgMap map[string]interface{},
+	extensions []string) []sarama.RecordHeader {
+	contentType := CEJSONContentType
+	if value, ok := msgMap[CEDataContentType].(string); ok {
+		contentType = value
+		delete(msgMap, CEDataContentType)
+	}
+	headers := []sarama.RecordHeader{{
+		Key:   []byte(CEContentTypeHeader),
+		Value: []byte(contentType),
+	}}
+
+	attrs := append([]string{CEIDKey, CESourceKey, CESpecVersionKey,
+		CETypeKey, CEDataSchemaKey, CESubjectKey, CETimeKey}, extensions...)
+	for _, attr := range attrs {
+		value, ok := msgMap[attr].(string)
+		if !ok {
+			continue
+		}
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(CEHeaderPrefix + attr),
+			Value: []byte(value),
+		})
+		delete(msgMap, attr)
+	}
+	return headers
+}
+
+// enqueue passes the message to the producer, or to the spool while kafka
+// is unavailable so messages are sent in order, or offline
+func (kp *KafkaProducer) enqueue(msg *sarama.ProducerMessage) error {
+	if kp.spool != nil && (kp.config.EnableOffline || kp.spool.spooling()) {
+		return kp.spoolMessage(msg)
+	}
+	return kp.produce(msg)
+}
+
+// produce passes the message to the producer, dropping it on write timeout
+// Messages are delayed while the broker is throttling the producer
+func (kp *KafkaProducer) produce(msg *sarama.ProducerMessage) error {
+	if kp.throttle != nil {
+		kp.throttle.wait()
+	}
+	meta, _ := msg.Metadata.(messageMeta)
+	meta.sent = time.Now()
+	msg.Metadata = meta
+
+	kp.closeMutex.RLock()
+	defer kp.closeMutex.RUnlock()
+	if kp.closed {
+		countDropped(DropClosed)
+		return ErrProducerClosed
+	}
+
+	atomic.AddInt64(&kp.pending, 1)
+	if kp.config.WriteTimeout <= 0 {
+		kp.producer.Input() <- msg
+		return nil
+	}
+
+	timer := time.NewTimer(kp.config.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case kp.producer.Input() <- msg:
+		return nil
+	case <-timer.C:
+		atomic.AddInt64(&kp.pending, -1)
+		countTimeout(KafkaSink)
+		countDropped(DropTimeout)
+		return ErrWriteTimeout
 	}
-	return nil
 }
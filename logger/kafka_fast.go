@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+// jsonMember provides the raw key and value of a top level JSON member
+type jsonMember struct {
+	key   []byte // unquoted
+	value []byte
+}
+
+// maxStackMembers is the number of members scanned without allocation
+const maxStackMembers = 32
+
+// fastPath returns true if records of the route can be sent without
+// decoding to a map
+// Functions of the record map, schema encoding, binary mode, extension
+// functions, manual partitioning and routing rules require the map
+func (kp *KafkaProducer) fastPath(route *kafkaRoute) bool {
+	if route != nil && (route.filterFn != nil ||
+		(kp.config.Key == FunctionKey && route.keyFn != nil)) {
+		return false
+	}
+	return kp.registry == nil &&
+		!(kp.enableCE && kp.config.CEBinaryMode) &&
+		!(kp.enableCE && ceExtensionsRegistered()) &&
+		len(kp.config.RouteRules) == 0 &&
+		kp.config.Partition != ManualPartition &&
+		kp.config.Key != ExtractedKey
+}
+
+// processFast returns the kafka message for the formatted record by
+// editing the record in place of decoding it to a map and encoding it
+// Returns false for records the map is required for, such as those with
+// a topic field, the members are sorted by key as when encoding a map
+func (kp *KafkaProducer) processFast(msg []byte,
+	route *kafkaRoute) (*sarama.ProducerMessage, bool) {
+	if !json.Valid(msg) {
+		return nil, false
+	}
+	var stack [maxStackMembers]jsonMember
+	members, ok := scanMembers(msg, stack[:0])
+	if !ok {
+		return nil, false
+	}
+	sortMembers(members)
+	if _, ok := findMember(members, TopicKey); ok {
+		return nil, false
+	}
+
+	topic := kp.config.Topic
+	if route != nil && route.topic != "" {
+		var err error
+		if topic, err = kp.topicName(route.topic); err != nil {
+			return nil, false
+		}
+	}
+
+	var key sarama.Encoder
+	switch kp.config.Key {
+	case FixedKey:
+		key = sarama.StringEncoder(kp.config.KeyName)
+	case TimeSecondKey:
+		key = sarama.StringEncoder(strconv.Itoa(int(now().Unix())))
+	case TimeNanoSecondKey:
+		key = sarama.StringEncoder(strconv.Itoa(int(now().UnixNano())))
+	default:
+		level, ok := stringMember(members, kp.levelKey)
+		if !ok {
+			return nil, false
+		}
+		key = sarama.StringEncoder(level)
+	}
+
+	var id, ceType string
+	if kp.enableCE {
+		event, _ := stringMember(members, EventNameKey)
+		level, _ := stringMember(members, kp.cloudEvents.levelKey)
+		ceType = kp.cloudEvents.ceTypeOf(event, level)
+
+		var data []byte
+		if raw, ok := findMember(members, string(CEDataKey)); ok &&
+			raw[0] == '"' {
+			if bytes.IndexByte(raw, '\\') < 0 {
+				data = raw[1 : len(raw)-1]
+			} else if s, ok := stringMember(members, string(CEDataKey)); ok {
+				data = []byte(s)
+			}
+		}
+		var err error
+		if id, err = kp.cloudEvents.ceDataID(data); err != nil {
+			return nil, false
+		}
+	}
+
+	// added string fields in key order, replacing members with the same key
+	var stackAdded [2][2]string
+	added := stackAdded[:0]
+	if id != "" {
+		added = append(added, [2]string{string(CEIDKey), id})
+	}
+	if ceType != "" {
+		added = append(added, [2]string{string(CETypeKey), ceType})
+	}
+
+	out := make([]byte, 0, len(msg)+len(id)+len(ceType)+16)
+	out = append(out, '{')
+	writeKey := func(key []byte) {
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		out = append(out, '"')
+		out = append(out, key...)
+		out = append(out, '"', ':')
+	}
+	for i, m := range members {
+		// duplicate keys, the last member wins as when decoding
+		if i+1 < len(members) && bytes.Equal(m.key, members[i+1].key) {
+			continue
+		}
+		replaced := false
+		for len(added) > 0 && added[0][0] <= string(m.key) {
+			replaced = added[0][0] == string(m.key)
+			writeKey([]byte(added[0][0]))
+			out = appendJSONString(out, added[0][1])
+			added = added[1:]
+		}
+		if !replaced {
+			writeKey(m.key)
+			out = append(out, m.value...)
+		}
+	}
+	for _, a := range added {
+		writeKey([]byte(a[0]))
+		out = appendJSONString(out, a[1])
+	}
+	out = append(out, '}')
+
+	return &sarama.ProducerMessage{
+		Key:   key,
+		Topic: topic,
+		Value: sarama.ByteEncoder(out),
+	}, true
+}
+
+// scanMembers appends the top level members of the valid JSON object
+// Returns false if not an object or a key has escapes
+func scanMembers(msg []byte, members []jsonMember) ([]jsonMember, bool) {
+	i := skipSpace(msg, 0)
+	if i >= len(msg) || msg[i] != '{' {
+		return nil, false
+	}
+	i = skipSpace(msg, i+1)
+	if i < len(msg) && msg[i] == '}' {
+		return members, true
+	}
+	for i < len(msg) {
+		end := skipString(msg, i)
+		key := msg[i+1 : end-1]
+		if bytes.IndexByte(key, '\\') >= 0 {
+			return nil, false
+		}
+		i = skipSpace(msg, end)
+		i = skipSpace(msg, i+1) // colon
+		end = skipValue(msg, i)
+		members = append(members, jsonMember{key, msg[i:end]})
+		i = skipSpace(msg, end)
+		if i >= len(msg) || msg[i] == '}' {
+			break
+		}
+		i = skipSpace(msg, i+1) // comma
+	}
+	return members, true
+}
+
+// skipSpace returns the index of the next non whitespace byte
+func skipSpace(msg []byte, i int) int {
+	for i < len(msg) && (msg[i] == ' ' || msg[i] == '\t' ||
+		msg[i] == '\n' || msg[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipString returns the index after the string starting at i
+func skipString(msg []byte, i int) int {
+	for i++; i < len(msg); i++ {
+		switch msg[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(msg)
+}
+
+// skipValue returns the index after the value starting at i
+func skipValue(msg []byte, i int) int {
+	depth := 0
+	for i < len(msg) {
+		switch msg[i] {
+		case '"':
+			i = skipString(msg, i)
+			if depth == 0 {
+				return i
+			}
+			continue
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i
+			}
+		}
+		i++
+	}
+	return i
+}
+
+// sortMembers sorts the members by key, keeping the order of duplicates
+func sortMembers(members []jsonMember) {
+	for i := 1; i < len(members); i++ {
+		for j := i; j > 0 &&
+			bytes.Compare(members[j-1].key, members[j].key) > 0; j-- {
+			members[j-1], members[j] = members[j], members[j-1]
+		}
+	}
+}
+
+// findMember returns the raw value of the last member with the key
+func findMember(members []jsonMember, key string) ([]byte, bool) {
+	for i := len(members) - 1; i >= 0; i-- {
+		if string(members[i].key) == key {
+			return members[i].value, true
+		}
+	}
+	return nil, false
+}
+
+// stringMember returns the value of the string member with the key
+func stringMember(members []jsonMember, key string) (string, bool) {
+	raw, ok := findMember(members, key)
+	if !ok || raw[0] != '"' {
+		return "", false
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw[1 : len(raw)-1]), true
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// appendJSONString appends the string quoted as encoded by json.Marshal
+func appendJSONString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x80 || c == '"' || c == '\\' ||
+			c == '<' || c == '>' || c == '&' {
+			quoted, _ := json.Marshal(s)
+			return append(buf, quoted...)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
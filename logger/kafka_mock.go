@@ -0,0 +1,393 @@
+package logger
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// ProducerFactory returns the sarama producer of kafka producers in place of
+// connecting to the brokers, e.g. a sarama mocks.AsyncProducer
+type ProducerFactory func(config *sarama.Config) (sarama.AsyncProducer, error)
+
+var (
+	factoryMutex    sync.RWMutex
+	producerFactory ProducerFactory
+)
+
+// SetProducerFactory sets the factory of the sarama producers of the kafka
+// producers created next, nil restores connecting to the brokers
+// The mock broker provides the metadata of the producers
+func SetProducerFactory(factory ProducerFactory) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	producerFactory = factory
+}
+
+// getProducerFactory returns the factory of the sarama producers if set
+func getProducerFactory() ProducerFactory {
+	factoryMutex.RLock()
+	defer factoryMutex.RUnlock()
+	return producerFactory
+}
+
+// MockMessage provides a kafka message recorded by the mock broker
+type MockMessage struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       string
+	Value     string
+	Headers   map[string]string
+}
+
+// mockPartition identifies a topic partition of the mock broker
+type mockPartition struct {
+	topic     string
+	partition int32
+}
+
+// mockBroker records messages from all mock producers in order
+type mockBroker struct {
+	mutex           sync.Mutex
+	producers       map[*mockProducer]bool
+	messages        []MockMessage
+	offsets         map[mockPartition]int64
+	failures        int
+	latency         time.Duration
+	topics          map[string]bool // nil if all topics exist
+	denyWrite       map[string]bool
+	maxMessageBytes int
+	created         map[string]sarama.TopicDetail
+	down            bool
+	partitions      int32 // of every topic
+}
+
+// mockMaxMessageBytes is the max.message.bytes default of kafka topics
+const mockMaxMessageBytes = 1048588
+
+var broker = mockBroker{
+	producers:       make(map[*mockProducer]bool),
+	offsets:         make(map[mockPartition]int64),
+	maxMessageBytes: mockMaxMessageBytes,
+	partitions:      1,
+}
+
+// MockBrokerMessages returns the messages recorded by the mock broker
+// Messages already passed to a mock producer are always included
+func MockBrokerMessages() []MockMessage {
+	broker.mutex.Lock()
+	producers := make([]*mockProducer, 0, len(broker.producers))
+	for producer := range broker.producers {
+		producers = append(producers, producer)
+	}
+	broker.mutex.Unlock()
+
+	for _, producer := range producers {
+		producer.sync()
+	}
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	messages := make([]MockMessage, len(broker.messages))
+	copy(messages, broker.messages)
+	return messages
+}
+
+// ResetMockBroker discards the messages recorded by the mock broker
+func ResetMockBroker() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.messages = nil
+	broker.offsets = make(map[mockPartition]int64)
+	broker.failures = 0
+	broker.latency = 0
+	broker.topics = nil
+	broker.denyWrite = nil
+	broker.maxMessageBytes = mockMaxMessageBytes
+	broker.created = nil
+	broker.down = false
+	broker.partitions = 1
+}
+
+// MockBrokerTopics sets the topics existing on the mock broker
+// All topics exist until set or after ResetMockBroker
+func MockBrokerTopics(topics ...string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.topics = make(map[string]bool)
+	for _, topic := range topics {
+		broker.topics[topic] = true
+	}
+}
+
+// MockBrokerPartitions sets the number of partitions of every topic,
+// messages are partitioned by the producer Partition type
+// Topics have a single partition until set or after ResetMockBroker
+func MockBrokerPartitions(partitions int32) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.partitions = partitions
+}
+
+// HashPartitionFor returns the partition of the key with HashPartition
+// partitioning, to check the partition of keys in tests
+func HashPartitionFor(key string, partitions int32) (int32, error) {
+	msg := &sarama.ProducerMessage{Key: sarama.StringEncoder(key)}
+	return sarama.NewHashPartitioner("").Partition(msg, partitions)
+}
+
+// MockBrokerDenyWrite denies write to the topics by their ACLs
+func MockBrokerDenyWrite(topics ...string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.denyWrite = make(map[string]bool)
+	for _, topic := range topics {
+		broker.denyWrite[topic] = true
+	}
+}
+
+// MockBrokerMaxMessageBytes sets the max.message.bytes of all topics
+func MockBrokerMaxMessageBytes(maxBytes int) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.maxMessageBytes = maxBytes
+}
+
+// MockBrokerLatency delays the acknowledgment of each message
+func MockBrokerLatency(latency time.Duration) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.latency = latency
+}
+
+// MockBrokerDown makes the mock broker unreachable until set up again,
+// messages fail delivery with sarama.ErrOutOfBrokers
+func MockBrokerDown(down bool) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.down = down
+}
+
+// ErrMockDelivery is the delivery error returned for failed mock messages
+var ErrMockDelivery = errors.New("Mock delivery failure")
+
+// MockBrokerFail causes the next count messages to fail delivery
+func MockBrokerFail(count int) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.failures = count
+}
+
+// record encodes and appends a message to the mock broker
+// The message partition is set by the partitioner and the offset as by a
+// kafka broker
+func (b *mockBroker) record(msg *sarama.ProducerMessage,
+	partitioner sarama.Partitioner) error {
+	var key, value []byte
+	var err error
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+
+	b.mutex.Lock()
+	latency := b.latency
+	b.mutex.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.down {
+		return sarama.ErrOutOfBrokers
+	}
+	if b.failures > 0 {
+		b.failures--
+		return ErrMockDelivery
+	}
+	partition, err := partitioner.Partition(msg, b.partitions)
+	if err != nil {
+		return err
+	}
+	if partition < 0 || partition >= b.partitions {
+		return sarama.ErrInvalidPartition
+	}
+	msg.Partition = partition
+	msg.Offset = b.offsets[mockPartition{msg.Topic, partition}]
+	b.offsets[mockPartition{msg.Topic, partition}]++
+	var headers map[string]string
+	if len(msg.Headers) > 0 {
+		headers = make(map[string]string)
+		for _, header := range msg.Headers {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	b.messages = append(b.messages, MockMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(key),
+		Value:     string(value),
+		Headers:   headers,
+	})
+	return nil
+}
+
+// mockProducer provides an in-memory sarama.AsyncProducer
+// Messages are recorded by the mock broker instead of sent to kafka
+type mockProducer struct {
+	partitioner  sarama.PartitionerConstructor
+	partitioners map[string]sarama.Partitioner // by topic
+	input        chan *sarama.ProducerMessage
+	successes    chan *sarama.ProducerMessage
+	errors       chan *sarama.ProducerError
+	syncs        chan chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// newMockProducer returns a mock producer registered with the mock broker
+// Messages are partitioned as by a sarama producer
+func newMockProducer(partitioner sarama.PartitionerConstructor) *mockProducer {
+	if partitioner == nil {
+		partitioner = sarama.NewRandomPartitioner
+	}
+	mp := &mockProducer{
+		partitioner:  partitioner,
+		partitioners: make(map[string]sarama.Partitioner),
+		input:        make(chan *sarama.ProducerMessage),
+		successes:    make(chan *sarama.ProducerMessage),
+		errors:       make(chan *sarama.ProducerError),
+		syncs:        make(chan chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	broker.mutex.Lock()
+	broker.producers[mp] = true
+	broker.mutex.Unlock()
+
+	go mp.run()
+	return mp
+}
+
+// run records input messages until the producer is closed
+func (mp *mockProducer) run() {
+	defer close(mp.done)
+	defer close(mp.errors)
+	defer close(mp.successes)
+	for {
+		select {
+		case msg, ok := <-mp.input:
+			if !ok {
+				return
+			}
+			partitioner, ok := mp.partitioners[msg.Topic]
+			if !ok {
+				partitioner = mp.partitioner(msg.Topic)
+				mp.partitioners[msg.Topic] = partitioner
+			}
+			if err := broker.record(msg, partitioner); err != nil {
+				mp.errors <- &sarama.ProducerError{Msg: msg, Err: err}
+			} else {
+				mp.successes <- msg
+			}
+		case ack := <-mp.syncs:
+			close(ack)
+		}
+	}
+}
+
+// sync waits until all messages passed to the producer are recorded
+func (mp *mockProducer) sync() {
+	ack := make(chan struct{})
+	select {
+	case mp.syncs <- ack:
+		<-ack
+	case <-mp.done:
+	}
+}
+
+// The following methods meet the contract for the sarama.AsyncProducer
+
+func (mp *mockProducer) AsyncClose() {
+	mp.closeOnce.Do(func() {
+		broker.mutex.Lock()
+		delete(broker.producers, mp)
+		broker.mutex.Unlock()
+		close(mp.input)
+	})
+}
+
+func (mp *mockProducer) Close() error {
+	mp.AsyncClose()
+	<-mp.done
+	return nil
+}
+
+func (mp *mockProducer) Input() chan<- *sarama.ProducerMessage {
+	return mp.input
+}
+
+func (mp *mockProducer) Successes() <-chan *sarama.ProducerMessage {
+	return mp.successes
+}
+
+func (mp *mockProducer) Errors() <-chan *sarama.ProducerError {
+	return mp.errors
+}
+
+// mockSyncProducer provides an in-memory sarama.SyncProducer
+// Messages are recorded by the mock broker one at a time
+type mockSyncProducer struct {
+	mutex    sync.Mutex
+	producer *mockProducer
+}
+
+// newMockSyncProducer returns a mock sync producer registered with the
+// mock broker
+func newMockSyncProducer(
+	partitioner sarama.PartitionerConstructor) *mockSyncProducer {
+	return &mockSyncProducer{producer: newMockProducer(partitioner)}
+}
+
+// The following methods meet the contract for the sarama.SyncProducer
+
+func (sp *mockSyncProducer) SendMessage(
+	msg *sarama.ProducerMessage) (int32, int64, error) {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+	sp.producer.input <- msg
+	select {
+	case msg := <-sp.producer.successes:
+		return msg.Partition, msg.Offset, nil
+	case perr := <-sp.producer.errors:
+		return -1, -1, perr.Err
+	}
+}
+
+func (sp *mockSyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var errs sarama.ProducerErrors
+	for _, msg := range msgs {
+		if _, _, err := sp.SendMessage(msg); err != nil {
+			errs = append(errs, &sarama.ProducerError{Msg: msg, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (sp *mockSyncProducer) Close() error {
+	return sp.producer.Close()
+}
@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// DeliveryErrorFunc func called with each message that could not be delivered
+type DeliveryErrorFunc func(msg []byte, err error)
+
+// Spill record keys
+const (
+	SpillTopicKey = "topic"
+	SpillKeyKey   = "key"
+)
+
+// OnDeliveryError sets the func called when a message could not be delivered
+// after all delivery retries
+func (pc *ProducerConfiguration) OnDeliveryError(fn DeliveryErrorFunc) {
+	pc.deliveryErrorFn = fn
+}
+
+// retry schedules a failed message to be sent again as by the producer
+// retry policy classified by the logger, then the delivery retry policy
+// Returns false if no retries remain, the message remains pending otherwise
+func (kp *KafkaProducer) retry(perr *sarama.ProducerError) bool {
+	meta, _ := perr.Msg.Metadata.(messageMeta)
+	var backoff time.Duration
+	switch {
+	case kp.prodRetry.ShouldRetry(meta.retries, perr.Err):
+		backoff = kp.prodRetry.Delay(meta.retries)
+		meta.retries++
+	case kp.retryPolicy.ShouldRetry(meta.attempts, perr.Err):
+		backoff = kp.retryPolicy.Delay(meta.attempts)
+		meta.attempts++
+	default:
+		return false
+	}
+
+	kp.stats.retried()
+	kp.retries.Add(1)
+	go func() {
+		defer kp.retries.Done()
+		defer atomic.AddInt64(&kp.pending, -1)
+
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-kp.done:
+			kp.deliveryFailed(perr.Msg, perr.Err)
+			return
+		}
+
+		// sarama messages must not be reused, send a copy
+		err := kp.enqueue(&sarama.ProducerMessage{
+			Key:      perr.Msg.Key,
+			Topic:    perr.Msg.Topic,
+			Value:    perr.Msg.Value,
+			Headers:  perr.Msg.Headers,
+			Metadata: meta,
+		})
+		if err != nil {
+			kp.deliveryFailed(perr.Msg, err)
+		}
+	}()
+	return true
+}
+
+// deliveryFailed passes an undelivered message to the delivery error func
+// and writes it to the spill file
+func (kp *KafkaProducer) deliveryFailed(msg *sarama.ProducerMessage,
+	err error) {
+	recordError(err)
+	if err != ErrProducerClosed && err != ErrWriteTimeout &&
+		err != ErrSpoolFull {
+		countDropped(DropDelivery)
+	}
+	var key, value []byte
+	if msg.Key != nil {
+		key, _ = msg.Key.Encode()
+	}
+	if msg.Value != nil {
+		value, _ = msg.Value.Encode()
+	}
+
+	if meta, ok := msg.Metadata.(messageMeta); ok {
+		if meta.callback != nil {
+			meta.callback(-1, -1, err)
+		}
+		if meta.replay != nil {
+			meta.replay.done(meta.line, false)
+		}
+	}
+	if kp.config.deliveryErrorFn != nil {
+		kp.config.deliveryErrorFn(value, err)
+	}
+
+	if kp.spill == nil {
+		return
+	}
+	record, merr := json.Marshal(map[string]string{
+		DeadLetterTimeKey:   now().UTC().Format(time.RFC3339),
+		DeadLetterErrorKey:  err.Error(),
+		SpillTopicKey:       msg.Topic,
+		SpillKeyKey:         string(key),
+		DeadLetterRecordKey: string(value),
+	})
+	if merr != nil {
+		return
+	}
+	getLockedWriter(kp.spill).Write(append(record, '\n'))
+}
+
+// openSpill opens the spill file for undelivered messages if configured
+func openSpill(location string) (io.WriteCloser, error) {
+	if location == "" {
+		return nil, nil
+	}
+	return os.OpenFile(location, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
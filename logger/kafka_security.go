@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// saslMechanismType provides kafka SASL mechanism type
+type saslMechanismType string
+
+// Types of SASL mechanisms to map to sarama
+const (
+	SASLNone        saslMechanismType = ""      // default, SASL disabled
+	SASLPlain       saslMechanismType = "PLAIN" // use with TLS
+	SASLSCRAMSHA256 saslMechanismType = "SCRAM-SHA-256"
+	SASLSCRAMSHA512 saslMechanismType = "SCRAM-SHA-512"
+)
+
+// newTLSConfig returns the TLS config built from the certificate files
+// TLSCfg takes precedence, system roots are used if CACertFile is not set
+func newTLSConfig(config ProducerConfiguration) (*tls.Config, error) {
+	if config.TLSCfg != nil {
+		return config.TLSCfg, nil
+	}
+	return loadTLSConfig(config.CACertFile, config.CertFile, config.KeyFile,
+		config.InsecureSkipVerify)
+}
+
+// loadTLSConfig returns the TLS config built from the certificate files
+// System roots are used if caCertFile is not set
+func loadTLSConfig(caCertFile, certFile, keyFile string,
+	insecureSkipVerify bool) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caCertFile != "" {
+		caCert, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("No certificates in CACertFile: " +
+				caCertFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// setSecurity sets the sarama TLS and SASL config for the producer config
+func setSecurity(cfg *sarama.Config, config ProducerConfiguration) error {
+	if config.EnableTLS {
+		tlsCfg, err := newTLSConfig(config)
+		if err != nil {
+			return err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsCfg
+	}
+
+	if config.SASLMechanism == SASLNone {
+		return nil
+	}
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = config.SASLUser
+	cfg.Net.SASL.Password = config.SASLPassword
+
+	switch config.SASLMechanism {
+	case SASLPlain:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLSCRAMSHA256:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha256.New}
+		}
+	case SASLSCRAMSHA512:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha512.New}
+		}
+	default:
+		return errors.New("Invalid SASLMechanism: " +
+			string(config.SASLMechanism))
+	}
+	return nil
+}
+
+// scramClient performs the SCRAM exchange for sarama
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+// The following methods meet the contract for the sarama SCRAM client
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	c.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
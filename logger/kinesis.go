@@ -0,0 +1,147 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KinesisSinkType is the output name of the kinesis sink
+const KinesisSinkType = "kinesis"
+
+// ErrKinesisSinkClosed is returned when writing to a closed kinesis sink
+var ErrKinesisSinkClosed = errors.New("Kinesis sink closed")
+
+// maxKinesisBatchSize is the most records of a PutRecords request
+const maxKinesisBatchSize = 500
+
+// KinesisConfiguration provides Amazon Kinesis Data Streams configuration
+// Records are sent with PutRecords in batches of BatchSize or every
+// FlushInterval, the partition key is set as the kafka Key and KeyName
+type KinesisConfiguration struct {
+	StreamName    string
+	Region        string // AWS_REGION or AWS_DEFAULT_REGION if empty
+	Endpoint      string // regional endpoint if empty
+	Credentials   AWSCredentialsConfiguration
+	Key           kafkaKeyType
+	KeyName       string
+	BatchSize     int // at most 500
+	FlushInterval time.Duration
+	RetryMax      int
+	RetryFreq     time.Duration // doubled on each retry
+	RetryPolicy   RetryPolicy   // overrides RetryMax and RetryFreq
+	Timeout       time.Duration
+}
+
+// kinesisSink provides a sink putting batches of records to a stream
+type kinesisSink struct {
+	*batchSink
+	config KinesisConfiguration
+	client *awsClient
+}
+
+// newKinesisSink returns a kinesis sink instance sending in the background
+func newKinesisSink(config KinesisConfiguration) (*kinesisSink, error) {
+	if config.StreamName == "" {
+		return nil, errors.New("Kinesis StreamName not set")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultKinesisConfiguration.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultKinesisConfiguration.FlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultKinesisConfiguration.Timeout
+	}
+	client, err := newAWSClient("kinesis", config.Region, config.Endpoint,
+		"application/x-amz-json-1.1", config.Credentials, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &kinesisSink{
+		config: config,
+		client: client,
+	}
+	retry := config.RetryPolicy.resolve(config.RetryMax, config.RetryFreq,
+		awsRetryable)
+	s.batchSink = &batchSink{
+		name:          KinesisSinkType,
+		batchSize:     config.BatchSize,
+		flushInterval: config.FlushInterval,
+		retry:         retry,
+		post:          s.post,
+		closedErr:     ErrKinesisSinkClosed,
+	}
+	s.start()
+	return s, nil
+}
+
+// kinesisSinkFactory returns the kinesis sink of KinesisCfg if enabled,
+// else of the default configuration with the Sinks Options "stream"
+func kinesisSinkFactory(config SinkConfiguration) (Sink, error) {
+	kinesisCfg := defaultKinesisConfiguration
+	if config.kinesis != nil {
+		kinesisCfg = *config.kinesis
+	} else if stream := config.Options["stream"]; stream != "" {
+		kinesisCfg.StreamName = stream
+	}
+	sink, err := newKinesisSink(kinesisCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// kinesisRecord provides a PutRecords request entry, data is base64 encoded
+type kinesisRecord struct {
+	Data         []byte
+	PartitionKey string
+}
+
+// post puts the batch in a single request, the records failed are returned
+// in a partial error
+func (s *kinesisSink) post(batch []sinkRecord) error {
+	input := struct {
+		StreamName string
+		Records    []kinesisRecord
+	}{StreamName: s.config.StreamName}
+	for _, record := range batch {
+		input.Records = append(input.Records, kinesisRecord{
+			Data: record.line,
+			PartitionKey: awsRecordKey(s.config.Key, s.config.KeyName,
+				record),
+		})
+	}
+
+	var output struct {
+		FailedRecordCount int
+		Records           []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := s.client.call("Kinesis_20131202.PutRecords", input,
+		&output); err != nil {
+		return err
+	}
+	if output.FailedRecordCount == 0 {
+		return nil
+	}
+	partial := &partialError{}
+	for i, result := range output.Records {
+		if result.ErrorCode != "" && i < len(batch) {
+			partial.failed = append(partial.failed, batch[i])
+			if partial.err == nil {
+				partial.err = fmt.Errorf("Kinesis put %d records failed: %s: %s",
+					output.FailedRecordCount, result.ErrorCode,
+					result.ErrorMessage)
+			}
+		}
+	}
+	if partial.err == nil {
+		return nil
+	}
+	return partial
+}
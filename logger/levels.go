@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// levelNames maps level names used by other logging systems to log levels
+// Names are matched in lower case
+var levelNames = map[string]LevelType{
+	"trace":         DebugType,
+	"debug":         DebugType,
+	"info":          InfoType,
+	"information":   InfoType,
+	"informational": InfoType,
+	"notice":        InfoType,
+	"warn":          WarnType,
+	"warning":       WarnType,
+	"err":           ErrorType,
+	"error":         ErrorType,
+	"crit":          FatalType,
+	"critical":      FatalType,
+	"alert":         FatalType,
+	"fatal":         FatalType,
+	"emerg":         PanicType,
+	"emergency":     PanicType,
+	"panic":         PanicType,
+}
+
+// syslogLevels maps syslog severities 0 (emergency) to 7 (debug) to log levels
+var syslogLevels = []LevelType{
+	PanicType, // emergency
+	FatalType, // alert
+	FatalType, // critical
+	ErrorType, // error
+	WarnType,  // warning
+	InfoType,  // notice
+	InfoType,  // informational
+	DebugType, // debug
+}
+
+// ParseLevel returns the log level for a level name or syslog severity
+// Common names such as TRACE, NOTICE and CRITICAL are accepted in any case
+func ParseLevel(name string) (LevelType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if level, ok := levelNames[name]; ok {
+		return level, nil
+	}
+	if severity, err := strconv.Atoi(name); err == nil {
+		return SyslogLevel(severity)
+	}
+	return "", errors.New("Unknown level: " + name)
+}
+
+// SyslogLevel returns the log level for a syslog severity
+func SyslogLevel(severity int) (LevelType, error) {
+	if severity < 0 || severity >= len(syslogLevels) {
+		return "", errors.New("Invalid syslog severity: " +
+			strconv.Itoa(severity))
+	}
+	return syslogLevels[severity], nil
+}
+
+// normalizeLevel returns the log level for the name if it can be parsed
+// Otherwise the name is returned unchanged for validation to report
+func normalizeLevel(name LevelType) LevelType {
+	if name == "" {
+		return name
+	}
+	if level, err := ParseLevel(string(name)); err == nil {
+		return level
+	}
+	return name
+}
+
+// normalizeLevels returns the configuration with the level names normalized
+func (lc LoggerConfiguration) normalizeLevels() LoggerConfiguration {
+	lc.LogLevel = normalizeLevel(lc.LogLevel)
+	lc.ConsoleLevel = normalizeLevel(lc.ConsoleLevel)
+	lc.FileLevel = normalizeLevel(lc.FileLevel)
+	lc.KafkaLevel = normalizeLevel(lc.KafkaLevel)
+	lc.HTTPLevel = normalizeLevel(lc.HTTPLevel)
+	lc.SyslogLevel = normalizeLevel(lc.SyslogLevel)
+	lc.KinesisLevel = normalizeLevel(lc.KinesisLevel)
+	lc.SQSLevel = normalizeLevel(lc.SQSLevel)
+	lc.StackTraceLevel = normalizeLevel(lc.StackTraceLevel)
+	sinks := make([]SinkConfiguration, len(lc.Sinks))
+	for i, sc := range lc.Sinks {
+		sc.Level = normalizeLevel(sc.Level)
+		sinks[i] = sc
+	}
+	if lc.Sinks != nil {
+		lc.Sinks = sinks
+	}
+	files := make([]FileSinkConfiguration, len(lc.Files))
+	for i, fc := range lc.Files {
+		fc.Level = normalizeLevel(fc.Level)
+		files[i] = fc
+	}
+	if lc.Files != nil {
+		lc.Files = files
+	}
+	return lc
+}
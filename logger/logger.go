@@ -2,6 +2,11 @@
 
 package logger
 
+import (
+	"context"
+	"time"
+)
+
 // LogFields provided for calls to WithFields for structured logging
 type LogFields map[string]interface{}
 
@@ -12,6 +17,7 @@ type PackageType string
 const (
 	ZapType    PackageType = "zap"
 	LogrusType PackageType = "logrus"
+	SlogType   PackageType = "slog"
 )
 
 // LevelType provided to select log level
@@ -46,42 +52,137 @@ const (
 	Stderr ConsoleType = "stderr"
 )
 
+// FieldKeyType provided to select a standard record key to rename
+type FieldKeyType string
+
+// Standard record keys that can be renamed
+const (
+	FieldKeyTime  FieldKeyType = "time"
+	FieldKeyLevel FieldKeyType = "level"
+	FieldKeyMsg   FieldKeyType = "msg"
+)
+
+// FieldMap provided to rename standard record keys
+// Example: FieldMap{FieldKeyMsg: "message", FieldKeyLevel: "severity"}
+type FieldMap map[FieldKeyType]string
+
+// resolve returns the renamed key or the standard key if not renamed
+func (f FieldMap) resolve(key FieldKeyType) string {
+	if name, ok := f[key]; ok && name != "" {
+		return name
+	}
+	return string(key)
+}
+
+// outputLevel returns the output level, the log level if not set
+func (lc LoggerConfiguration) outputLevel(level LevelType) LevelType {
+	if level != "" {
+		return level
+	}
+	if lc.LogLevel != "" {
+		return lc.LogLevel
+	}
+	return defaultLoggerConfiguration.LogLevel
+}
+
 // LoggerConfiguration stores the config for the logger
 type LoggerConfiguration struct {
 	LogPackage        PackageType
 	LogLevel          LevelType
 	EnableTimeStamps  bool
 	EnableColorLevels bool
+	FieldMap          FieldMap
+	ServiceName       string // service metadata added to every record
+	ServiceVersion    string
+	Environment       string
+	HostName          string // os.Hostname() if not set and ServiceName is
+	EnableStartup     bool   // log the startup event once per process
+	EnableConfigHash  bool   // add the configuration hash to every record
 	EnableCloudEvents bool
 	CloudEventsCfg    CloudEventsConfiguration
 	EnableKafka       bool
 	KafkaFormat       FormatType
+	KafkaLevel        LevelType
 	KafkaProducerCfg  ProducerConfiguration
 	EnableConsole     bool
 	ConsoleFormat     FormatType
 	ConsoleWriter     ConsoleType
+	ConsoleLevel      LevelType
+	PrettyConsole     bool // multi-line colorized records for development
 	EnableFile        bool
 	FileFormat        FormatType
 	FileLocation      string
+	FileLevel         LevelType
+	FileCompression   compressionType // streaming, without rotation
+	FileRetryPolicy   RetryPolicy     // opening the log file, no retries if unset
 	EnableRotation    bool
 	RotationCfg       RotationConfiguration
+	Files             []FileSinkConfiguration // additional log files
+	EnableSampling    bool
+	SamplingCfg       SamplingConfiguration
+	EnableAsync       bool
+	AsyncBufferSize   int
+	AsyncPolicy       AsyncPolicyType
+	MaxFields         int // 0 is unlimited
+	MaxFieldLength    int // 0 is unlimited
+	EnableStackTrace  bool
+	StackTraceLevel   LevelType // ErrorType if not set
+	EnableCaller      bool      // file:line and function of the logging call
+	CallerSkip        int       // frames skipped above the logging call
+	EnableHTTP        bool
+	HTTPFormat        FormatType
+	HTTPLevel         LevelType
+	HTTPCfg           HTTPSinkConfiguration
+	EnableSyslog      bool
+	SyslogFormat      FormatType
+	SyslogLevel       LevelType
+	SyslogCfg         SyslogConfiguration
+	EnableKinesis     bool
+	KinesisFormat     FormatType
+	KinesisLevel      LevelType
+	KinesisCfg        KinesisConfiguration
+	EnableSQS         bool
+	SQSFormat         FormatType
+	SQSLevel          LevelType
+	SQSCfg            SQSConfiguration
+	Sinks             []SinkConfiguration
 	EnableDebug       bool
+	configHash        string // set when created if EnableConfigHash
 }
 
 // NewLogger returns a Logger instance
 func NewLogger(config LoggerConfiguration) (Logger, error) {
+	config = config.normalizeLevels().withHostName().
+		expandServiceTemplates().withConfigHash().withHeartbeatService()
 	err := checkConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	var log Logger
 	switch config.LogPackage {
 	case LogrusType:
-		return newLogrusLogger(config)
+		log, err = newLogrusLogger(config)
+	case SlogType:
+		log, err = newSlogLogger(config)
 	case ZapType:
 		fallthrough
 	default:
-		return newZapLogger(config)
+		log, err = newZapLogger(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// reported as the current configuration once the logger is created
+	globalConfigMutex.Lock()
+	globalLoggerConfiguration = config
+	globalConfigMutex.Unlock()
+	if fields := config.serviceFields(); len(fields) > 0 {
+		log = log.WithFields(fields)
 	}
+	if config.EnableStartup {
+		config.logStartup(log)
+	}
+	return log, nil
 }
 
 // Logger is the contract for the logger interface
@@ -130,7 +231,39 @@ type Logger interface {
 
 	WithFields(keyValues LogFields) Logger
 
+	WithError(err error) Logger
+
+	WithLazyFields(fieldsFn func() LogFields) Logger
+
+	WithSampledFields(rate float64, keyValues LogFields) Logger
+
+	With(fields ...Field) Logger
+
+	WithTopic(topic string) Logger
+
+	WithCESource(source string) Logger
+
 	WithKafkaFilterFn(filter FilterFunc) Logger
 
 	WithKafkaKeyFn(filter KeyFunc) Logger
+
+	WithKafkaPartitionFn(partition PartitionFunc) Logger
+
+	WithContext(ctx context.Context) Logger
+
+	DebugCtx(ctx context.Context, args ...interface{})
+
+	InfoCtx(ctx context.Context, args ...interface{})
+
+	WarnCtx(ctx context.Context, args ...interface{})
+
+	ErrorCtx(ctx context.Context, args ...interface{})
+
+	FatalCtx(ctx context.Context, args ...interface{})
+
+	PanicCtx(ctx context.Context, args ...interface{})
+
+	Flush(timeout time.Duration) error
+
+	Close() error
 }
@@ -3,653 +3,5446 @@ package logger
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
-	"os/exec"
-	"os/signal"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
-	cluster "github.com/bsm/sarama-cluster"
+	"github.com/klauspost/compress/zstd"
 	"gopkg.in/yaml.v2"
 )
 
-var (
-	update   = flag.Bool("update", false, "update golden files")
-	debug    = flag.Bool("d", false, "Enable debug")
-	rewrite  = flag.Bool("r", false, "Rewrite config")
-	explicit = flag.String("t", "", "Explicit test")
-)
+func TestConsole(t *testing.T) {
+	var testCases = []TestCases{
+		{tNil, tLru, tCon, tNil, tNil, "Default",
+			"logrus logger to console with default config"},
+		{tNil, tZap, tCon, tNil, tNil, "Default",
+			"zap logger to console with default config"},
+		{tNil, tSlg, tCon, tNil, tNil, "Default",
+			"slog logger to console with default config"},
+		{tNil, tLru, tCon, tNil, tNil, "CEFormat",
+			"logrus logger to console with cloudevents format"},
+		{tNil, tZap, tCon, tNil, tNil, "CEFormat",
+			"zap logger to console with cloudevents format"},
+	}
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	runTestCases(t, testCases)
+}
+
+func TestLogfile(t *testing.T) {
+	var testCases = []TestCases{
+		{tNil, tLru, tNil, tLog, tNil, "Default",
+			"logrus logger to file with default config"},
+		{tNil, tZap, tNil, tLog, tNil, "Default",
+			"zap logger to file with default config"},
+		{tNil, tSlg, tNil, tLog, tNil, "Default",
+			"slog logger to file with default config"},
+		{tNil, tLru, tNil, tLog, tNil, "FieldMap",
+			"logrus logger to file with renamed keys"},
+		{tNil, tZap, tNil, tLog, tNil, "FieldMap",
+			"zap logger to file with renamed keys"},
+	}
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	runTestCases(t, testCases)
+}
+
+func TestPubsub(t *testing.T) {
+	var testCases = []TestCases{
+		{tNil, tLru, tNil, tNil, tPub, "Default",
+			"logrus logger to kafka with default config"},
+		{tNil, tZap, tNil, tNil, tPub, "Default",
+			"zap logger to kafka with default config"},
+		{tNil, tSlg, tNil, tNil, tPub, "Default",
+			"slog logger to kafka with default config"},
+		{tNil, tLru, tNil, tNil, tPub, "Topic",
+			"logrus logger to kafka with test topic"},
+		{tNil, tZap, tNil, tNil, tPub, "Topic",
+			"zap logger to kafka with test topic"},
+		{tNil, tLru, tNil, tNil, tPub, "IncrID",
+			"logrus logger to kafka with incremental ID"},
+		{tNil, tZap, tNil, tNil, tPub, "IncrID",
+			"zap logger to kafka with incremental ID"},
+	}
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	runTestCases(t, testCases)
+}
+
+func TestEnv(t *testing.T) {
+	var testCases = []TestCases{
+		{tEnv, tLru, tCon, tNil, tNil, "Default", tNil},
+		{tEnv, tLru, tNil, tLog, tNil, "Default", tNil},
+		{tEnv, tLru, tNil, tNil, tPub, "Default", tNil},
+		{tEnv, tZap, tCon, tNil, tNil, "Default", tNil},
+		{tEnv, tZap, tNil, tLog, tNil, "Default", tNil},
+		{tEnv, tZap, tNil, tNil, tPub, "Default", tNil},
+	}
+	if !testenv {
+		t.SkipNow()
+	}
+	runTestCases(t, testCases)
+}
+
+func TestInit(t *testing.T) {
+	var testCases = []TestCases{
+		{tIni, tLru, tCon, tNil, tNil, "Default", tNil},
+		{tIni, tLru, tNil, tLog, tNil, "Default", tNil},
+		{tIni, tLru, tNil, tNil, tPub, "Default", tNil},
+		{tIni, tZap, tCon, tNil, tNil, "Default", tNil},
+		{tIni, tZap, tNil, tLog, tNil, "Default", tNil},
+		{tIni, tZap, tNil, tNil, tPub, "Default", tNil},
+	}
+	if !testinit {
+		t.SkipNow()
+	}
+	runTestCases(t, testCases)
+}
+
+func TestConfigExpandEnv(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	t.Setenv("PRTEST_LOGDIR", "/var/log")
+	t.Setenv("PRTEST_HMACKEY", "secret")
+	t.Setenv("PRTEST_BROKER", "kafka:9093")
+
+	cfg := new(LoggerConfiguration)
+	err := FillConfiguration(DefaultCompleteCfg(), cfg, FileConfig,
+		"testdata/ConfigExpandEnv", LogEnvPrefix)
+	if err != nil {
+		t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+	}
+
+	if cfg.FileLocation != "/var/log/pavedroad.log" {
+		t.Errorf("FileLocation not expanded: %s\n", cfg.FileLocation)
+	}
+	if cfg.CloudEventsCfg.HMACKey != "secret" {
+		t.Errorf("HMACKey not expanded: %s\n", cfg.CloudEventsCfg.HMACKey)
+	}
+	brokers := cfg.KafkaProducerCfg.Brokers
+	if len(brokers) != 2 || brokers[0] != "kafka:9093" ||
+		brokers[1] != "localhost:9092" {
+		t.Errorf("Brokers not expanded: %v\n", brokers)
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, profile := range []string{"", "dev", "prod", "missing"} {
+		t.Run(profile, func(t *testing.T) {
+			t.Setenv(ProfileEnvName, profile)
+			cfg := new(LoggerConfiguration)
+			err := FillConfiguration(DefaultCompleteCfg(), cfg, FileConfig,
+				"testdata/ConfigProfiles", LogEnvPrefix)
+			if profile == "missing" {
+				if err == nil {
+					t.Errorf("Missing profile should fail\n")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+			}
+
+			level, console, kafka, topic := InfoType, false, false, "logs"
+			switch profile {
+			case "dev":
+				level, console = DebugType, true
+			case "prod":
+				level, kafka, topic = WarnType, true, "prod-logs"
+			}
+			if cfg.LogLevel != level || cfg.EnableConsole != console ||
+				cfg.EnableKafka != kafka ||
+				cfg.KafkaProducerCfg.Topic != topic {
+				t.Errorf("Profile %q not applied: %+v\n", profile, cfg)
+			}
+			if cfg.KafkaProducerCfg.ProdRetryMax !=
+				defaultProducerConfiguration.ProdRetryMax {
+				t.Errorf("Profile %q lost defaults\n", profile)
+			}
+		})
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	t.Setenv(ProfileEnvName, "dev")
+	t.Setenv("PRLOG_LOGPACKAGE", "slog")
+	cfg := new(LoggerConfiguration)
+	err := FillConfiguration(DefaultCompleteCfg(), cfg, BothConfig,
+		"testdata/ConfigBase, testdata/ConfigOverride", LogEnvPrefix)
+	if err != nil {
+		t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+	}
+
+	// override file settings replace base settings, lists are replaced
+	if cfg.LogLevel != DebugType {
+		t.Errorf("Expected override level %s, got %s\n", DebugType,
+			cfg.LogLevel)
+	}
+	if brokers := cfg.KafkaProducerCfg.Brokers; len(brokers) != 1 ||
+		brokers[0] != "kafka:9093" {
+		t.Errorf("Expected override brokers, got %v\n", brokers)
+	}
+	// base settings not overridden are kept, profiles apply to the merge
+	if cfg.KafkaProducerCfg.Topic != "platform-logs" || !cfg.EnableConsole {
+		t.Errorf("Expected base settings, got %+v\n", cfg)
+	}
+	// the environment overrides all files
+	if cfg.LogPackage != SlogType {
+		t.Errorf("Expected environment package %s, got %s\n", SlogType,
+			cfg.LogPackage)
+	}
+
+	err = FillConfiguration(DefaultCompleteCfg(), cfg, FileConfig,
+		"testdata/ConfigBase,testdata/ConfigMissing", LogEnvPrefix)
+	if err == nil {
+		t.Errorf("Missing override file should fail\n")
+	}
+	if names := configFileNames(" a, ,b "); len(names) != 2 ||
+		names[0] != "a" || names[1] != "b" {
+		t.Errorf("Unexpected config file names: %v\n", names)
+	}
+}
+
+func TestConfigNestedEnv(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	t.Setenv("PRLOG_KAFKAPRODUCERCFG_TOPIC", "env-topic")
+	t.Setenv("PRLOG_KAFKAPRODUCERCFG_KEYNAME", "log-key")
+	t.Setenv("PRLOG_KAFKAPRODUCERCFG_PRODRETRYPOLICY_MAXATTEMPTS", "3")
+	t.Setenv("PRLOG_CLOUDEVENTSCFG_TYPE", "io.pavedroad.env")
+	t.Setenv("PRKAFKA_KEYNAME", "kafka-key")
+
+	for _, cfgType := range []configType{BothConfig, FileConfig} {
+		cfg, err := GetLoggerConfiguration(cfgType, "testdata/ConfigNested")
+		if err != nil {
+			t.Fatalf("Failed to get %s configuration: %s\n", cfgType,
+				err.Error())
+		}
+		kafka := cfg.KafkaProducerCfg
+
+		// file sub config settings survive the sub config prefixes
+		if len(kafka.Brokers) != 1 || kafka.Brokers[0] != "file:9092" ||
+			cfg.CloudEventsCfg.Source != "file-source" ||
+			cfg.RotationCfg.MaxSize != 7 {
+			t.Errorf("Expected %s file sub configs, got %+v\n", cfgType, cfg)
+		}
+		// sub config prefixes have precedence in all config types
+		if kafka.KeyName != "kafka-key" {
+			t.Errorf("Expected %s PRKAFKA key name, got %s\n", cfgType,
+				kafka.KeyName)
+		}
+
+		// nested PRLOG keys are environment overrides as top level keys
+		topic, attempts, ceType := "file-topic", 0,
+			defaultCloudEventsConfiguration.Type
+		if cfgType == BothConfig {
+			topic, attempts, ceType = "env-topic", 3, "io.pavedroad.env"
+		}
+		if kafka.Topic != topic || kafka.ProdRetryPolicy.MaxAttempts != attempts ||
+			cfg.CloudEventsCfg.Type != ceType {
+			t.Errorf("Expected %s topic %s attempts %d type %s, got %s %d %s\n",
+				cfgType, topic, attempts, ceType, kafka.Topic,
+				kafka.ProdRetryPolicy.MaxAttempts, cfg.CloudEventsCfg.Type)
+		}
+	}
+}
+
+func TestConfigSpec(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	t.Setenv(ConfigTypeEnvName, string(EnvConfig))
+	t.Setenv("PRKAFKA_TOPIC", "spec-topic")
+	t.Setenv("PRLOG_KAFKAPRODUCERCFG_BROKERS", "a:9092,b:9092")
+	t.Setenv("PRLOG_KAFKAPRODUCERCFG_SCHEMAREGISTRYCFG_PASSWORD", "secret")
+	t.Setenv("PRLOG_KINESISCFG_CREDENTIALS_SECRETACCESSKEY", "secret")
+
+	spec := map[string]EnvVar{}
+	for _, v := range ConfigSpec() {
+		spec[v.Name] = v
+	}
+	expected := []EnvVar{
+		{ConfigTypeEnvName, EnvString, "env", "env", true, false},
+		{"PRLOG_LOGLEVEL", EnvString, "info", "info", false, false},
+		{"PRLOG_KAFKAPRODUCERCFG_BROKERS", EnvList, "localhost:9092",
+			"a:9092,b:9092", true, false},
+		{"PRKAFKA_TOPIC", EnvString, "logs", "spec-topic", true, false},
+		{"PRKAFKA_QUOTAINTERVAL", EnvDuration, "10s", "10s", false, false},
+		{"PRKAFKA_PRODRETRYPOLICY_JITTER", EnvFloat, "0", "0", false, false},
+		{"PRCE_SETSUBJECTLEVEL", EnvBool, "true", "true", false, false},
+		{"PRROT_MAXSIZE", EnvInt, "100", "100", false, false},
+		{RetryEnvPrefix + "_" + RetryHTTP, EnvList, "", "", false, false},
+		// nested secrets are redacted
+		{"PRKAFKA_SASLPASSWORD", EnvString, "", "", false, true},
+		{"PRLOG_KAFKAPRODUCERCFG_SCHEMAREGISTRYCFG_PASSWORD", EnvString, "",
+			redactedSecret, true, true},
+		{"PRLOG_KINESISCFG_CREDENTIALS_SECRETACCESSKEY", EnvString, "",
+			redactedSecret, true, true},
+		{"PRLOG_SQSCFG_CREDENTIALS_SESSIONTOKEN", EnvString, "", "", false, true},
+	}
+	for _, e := range expected {
+		if v, ok := spec[e.Name]; !ok || v != e {
+			t.Errorf("Expected %+v, got %+v\n", e, v)
+		}
+	}
+	for _, name := range []string{"PRLOG_FIELDMAP", "PRLOG_SINKS",
+		"PRKAFKA_PRODRETRYPOLICY_RETRYABLE"} {
+		if _, ok := spec[name]; ok {
+			t.Errorf("Unexpected variable %s\n", name)
+		}
+	}
+
+	if err := ValidateEnv(); err != nil {
+		t.Fatalf("Unexpected environment error: %s\n", err.Error())
+	}
+	t.Setenv("PRKAFKA_TOPICS", "x")
+	t.Setenv("PRLGO_LOGLEVEL", "debug")
+	t.Setenv("PROXY_HOST", "proxy")
+	t.Setenv("PRCE_NOTHINGLIKEIT", "x")
+	err := ValidateEnv()
+	if !errors.Is(err, ErrUnknownEnv) {
+		t.Fatalf("Expected unknown environment error, got %v\n", err)
+	}
+	message := err.Error()
+	for _, part := range []string{
+		"PRKAFKA_TOPICS (did you mean PRKAFKA_TOPIC?)",
+		"PRLGO_LOGLEVEL (did you mean PRLOG_LOGLEVEL?)",
+		"PRCE_NOTHINGLIKEIT",
+	} {
+		if !strings.Contains(message, part) {
+			t.Errorf("Expected %q in %s\n", part, message)
+		}
+	}
+	if strings.Contains(message, "PROXY_HOST") ||
+		strings.Contains(message, "PRCE_NOTHINGLIKEIT (") {
+		t.Errorf("Unexpected variables in %s\n", message)
+	}
+
+	// secrets are redacted when the configuration cannot be read
+	t.Setenv(ConfigTypeEnvName, string(FileConfig))
+	t.Setenv(ConfigFileEnvName, "testdata/missing")
+	for _, v := range ConfigSpec() {
+		if v.Name == "PRLOG_KINESISCFG_CREDENTIALS_SECRETACCESSKEY" &&
+			v.Value != redactedSecret {
+			t.Errorf("Expected redacted environment value, got %+v\n", v)
+		}
+	}
+}
+
+func TestConfigWarnings(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	t.Setenv(ProfileEnvName, "dev")
+	cfg := new(LoggerConfiguration)
+	err := FillConfiguration(DefaultCompleteCfg(), cfg, FileConfig,
+		"testdata/ConfigDeprecated", LogEnvPrefix)
+	if err != nil {
+		t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+	}
+
+	// deprecated keys apply unless their replacement is set
+	if !cfg.EnableColorLevels || cfg.EnableTimeStamps ||
+		cfg.KafkaProducerCfg.ProdFlushFreq != 2*time.Second ||
+		cfg.KafkaProducerCfg.ProdRetryFreq != 3*time.Second ||
+		cfg.CloudEventsCfg.SetID != CEUUID {
+		t.Errorf("Deprecated keys not applied: %+v\n", cfg)
+	}
+
+	expected := []ConfigWarning{
+		{"cloudeventscfg.cloudeventsid", "cloudeventscfg.setid", true},
+		{"enablecolors", "enablecolorlevels", true},
+		{"kafkaproducercfg.flushfreq", "kafkaproducercfg.prodflushfreq", true},
+		{"kafkaproducercfg.retryfreq", "kafkaproducercfg.prodretryfreq", true},
+		{"kafkaproducercfg.topics", "kafkaproducercfg.topic", false},
+		{"profiles.dev.enabletimestamp", "profiles.dev.enabletimestamps", true},
+		{"profiles.dev.loglevl", "profiles.dev.loglevel", false},
+	}
+	warnings := ConfigWarnings()
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %v, got %v\n", expected, warnings)
+	}
+	if message := warnings[4].String(); message != "Unknown config key "+
+		"kafkaproducercfg.topics, did you mean kafkaproducercfg.topic?" {
+		t.Errorf("Unexpected warning message: %s\n", message)
+	}
+
+	err = FillConfiguration(DefaultCompleteCfg(), cfg, FileConfig,
+		"testdata/ConfigProfiles", LogEnvPrefix)
+	if err != nil {
+		t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+	}
+	if warnings := ConfigWarnings(); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v\n", warnings)
+	}
+}
+
+func TestWriteConfiguration(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	cfg := DefaultLoggerCfg()
+	cfg.EnableFile = false
+	cfg.LogLevel = WarnType
+	if _, err := NewLogger(cfg); err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+
+	for _, format := range []ExportFormatType{YAMLExport, JSONExport} {
+		var buf bytes.Buffer
+		var actual LoggerConfiguration
+		if err := WriteConfiguration(&buf, format); err != nil {
+			t.Fatalf("Failed to write %s config: %s\n", format, err.Error())
+		}
+		var err error
+		if format == JSONExport {
+			err = json.Unmarshal(buf.Bytes(), &actual)
+		} else {
+			err = yaml.Unmarshal(buf.Bytes(), &actual)
+		}
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s config: %s\n", format, err.Error())
+		}
+		if actual.LogLevel != WarnType || actual.EnableFile {
+			t.Errorf("Unexpected %s config: %+v\n", format, actual)
+		}
+	}
+
+	if err := WriteConfiguration(ioutil.Discard, "toml"); err == nil {
+		t.Errorf("Invalid format should fail\n")
+	}
+}
+
+func TestKafkaWriteTimeout(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	kp := &KafkaProducer{
+		producer: &stalledProducer{input: make(chan *sarama.ProducerMessage)},
+		config: ProducerConfiguration{
+			Topic:        "logs",
+			Key:          FixedKey,
+			KeyName:      "user",
+			WriteTimeout: 10 * time.Millisecond,
+		},
+	}
+
+	before := SinkTimeouts()[KafkaSink]
+	err := kp.sendMessage([]byte(`{"level":"info","msg":"stalled"}`), nil)
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("Expected write timeout, got %v\n", err)
+	}
+	if after := SinkTimeouts()[KafkaSink]; after != before+1 {
+		t.Errorf("Expected %d timeouts, got %d\n", before+1, after)
+	}
+}
+
+func TestUninitializedPolicy(t *testing.T) {
+	if testinit || testenv || logger != nil {
+		t.SkipNow()
+	}
+	defer SetUninitializedPolicy(UninitStderr)
+
+	expectPanic := func(name string, fn func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s should panic when uninitialized\n", name)
+			}
+		}()
+		fn()
+	}
+
+	SetUninitializedPolicy(UninitPanic)
+	expectPanic("Info", func() { Info("uninitialized") })
+
+	SetUninitializedPolicy(UninitStderr)
+	expectPanic("Panic", func() { Panic("uninitialized") })
+	expectPanic("Panicf", func() { Panicf("uninitialized %s", "panicf") })
+}
+
+func TestInitOnce(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	saved := logger
+	logger = nil
+	defer func() { logger = saved }()
+
+	cfg := DefaultLoggerCfg()
+	cfg.EnableFile = false
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() { results <- Init(cfg) }()
+	}
+
+	var initialized int
+	for i := 0; i < cap(results); i++ {
+		err := <-results
+		if err == nil {
+			initialized++
+		} else if !errors.Is(err, ErrInitialized) {
+			t.Errorf("Unexpected Init error: %s\n", err.Error())
+		}
+	}
+	if initialized != 1 {
+		t.Errorf("Expected one initialization, got %d\n", initialized)
+	}
+	if err := InitDefault(); !errors.Is(err, ErrInitialized) {
+		t.Errorf("InitDefault should not reinitialize: %v\n", err)
+	}
+}
+
+func TestDerivedKafkaFns(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	keyFn := func(*map[string]interface{}) string { return "key" }
+	filterFn := func(*map[string]interface{}) {}
+	partitionFn := func(*map[string]interface{}) int32 { return 2 }
+
+	// kafka disabled, kafka methods are no-ops on all derived loggers
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.WithFields(LogFields{"a": 1}).WithFields(LogFields{"b": 2}).
+			WithKafkaKeyFn(keyFn).WithKafkaFilterFn(filterFn).
+			WithKafkaPartitionFn(partitionFn)
+	}
+
+	// kafka enabled, functions apply to the derived logger and its children
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		MockBrokerPartitions(4)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableCloudEvents = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Key = FunctionKey
+		cfg.KafkaProducerCfg.Partition = ManualPartition
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		child := log.WithFields(LogFields{"a": 1}).WithKafkaKeyFn(keyFn).
+			WithKafkaFilterFn(func(msgMap *map[string]interface{}) {
+				(*msgMap)["filtered"] = true
+			}).WithKafkaPartitionFn(partitionFn)
+		grandchild := child.WithFields(LogFields{"b": 2}).WithKafkaKeyFn(
+			func(*map[string]interface{}) string { return "grandchild" })
+
+		// deriving loggers concurrently leaves the others unchanged
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(partition int32) {
+				defer wg.Done()
+				log.WithKafkaPartitionFn(func(*map[string]interface{}) int32 {
+					return partition
+				})
+			}(int32(i))
+		}
+		log.Info("root")
+		child.Info("child")
+		grandchild.Info("grandchild")
+		wg.Wait()
+		log.Close()
+
+		expected := map[string][3]interface{}{
+			"root":       {"info", nil, int32(0)},
+			"child":      {"key", true, int32(2)},
+			"grandchild": {"grandchild", true, int32(2)},
+		}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %s %d messages, got %v\n", pkg, len(expected),
+				messages)
+		}
+		for _, message := range messages {
+			var record map[string]interface{}
+			json.Unmarshal([]byte(message.Value), &record)
+			e, ok := expected[fmt.Sprint(record["msg"])]
+			if !ok || message.Key != e[0] || record["filtered"] != e[1] ||
+				message.Partition != e[2] {
+				t.Errorf("Unexpected %s message key %s partition %d record %v\n",
+					pkg, message.Key, message.Partition, record)
+			}
+		}
+	}
+}
+
+// Run with -race to detect unsafe id generation
+func TestConcurrentCEIDs(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	const count = 100
+
+	for _, setID := range []ceSetIDType{CEIncrID, CEHMAC} {
+		cfg := DefaultCloudEventsCfg()
+		cfg.SetID = setID
+		ce := newCloudEvents(cfg, nil)
+
+		ids := make(chan string, count)
+		for i := 0; i < count; i++ {
+			go func() {
+				id, err := ce.ceGetID(map[string]interface{}{
+					CEDataKey: "concurrent message",
+				})
+				if err != nil {
+					t.Errorf("Failed to get %s id: %s\n", setID, err.Error())
+				}
+				ids <- id
+			}()
+		}
+
+		unique := make(map[string]bool)
+		for i := 0; i < count; i++ {
+			unique[<-ids] = true
+		}
+		// incremental ids are all distinct, identical data same hmac id
+		if setID == CEIncrID && len(unique) != count ||
+			setID == CEHMAC && len(unique) != 1 {
+			t.Errorf("Unexpected %d unique %s ids\n", len(unique), setID)
+		}
+	}
+}
+
+func TestDeriveSource(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	os.Setenv(PodNameEnvName, "pod-1234")
+	defer os.Unsetenv(PodNameEnvName)
+
+	cfg := DefaultCloudEventsCfg()
+	cfg.DeriveSource = true
+	cfg.ServiceName = "users"
+	ce := newCloudEvents(cfg, nil)
+
+	expected := "//pavedroad.io/users/pod-1234"
+	if ce.fields[CESourceKey] != expected {
+		t.Errorf("Expected source %s, got %v\n", expected, ce.fields[CESourceKey])
+	}
+}
+
+func TestCETypes(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	cfg := DefaultCloudEventsCfg()
+	cfg.LevelTypes = map[LevelType]string{
+		ErrorType: "io.pavedroad.log.error",
+		WarnType:  "io.pavedroad.log.warn",
+	}
+	cfg.EventTypes = map[string]string{
+		"access": "io.pavedroad.audit.access",
+	}
+	ce := newCloudEvents(cfg, nil)
+
+	tests := []struct {
+		record   map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{CESubjectKey: "error"},
+			"io.pavedroad.log.error"},
+		{map[string]interface{}{CESubjectKey: "warning"},
+			"io.pavedroad.log.warn"},
+		{map[string]interface{}{CESubjectKey: "info"},
+			cfg.Type},
+		{map[string]interface{}{CESubjectKey: "error", EventNameKey: "access"},
+			"io.pavedroad.audit.access"},
+	}
+	for _, test := range tests {
+		test.record[CETypeKey] = cfg.Type
+		if _, err := ce.ceAddFields(test.record); err != nil {
+			t.Fatalf("Failed to add fields: %s\n", err.Error())
+		}
+		if test.record[CETypeKey] != test.expected {
+			t.Errorf("Expected type %s, got %v\n",
+				test.expected, test.record[CETypeKey])
+		}
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	RegisterContextExtractor(func(ctx context.Context) LogFields {
+		if user, ok := ctx.Value(contextKey("user")).(string); ok {
+			return LogFields{"user": user}
+		}
+		return nil
+	})
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	ctx = ContextWithTraceID(ctx, "trace-1")
+	ctx = context.WithValue(ctx, contextKey("user"), "bob")
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		file := filepath.Join(t.TempDir(), "context.log")
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = file
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.InfoCtx(ctx, "context message")
+		log.WithFields(LogFields{"a": 1}).WithContext(ctx).Info("derived")
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", file, err.Error())
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			if record[RequestIDKey] != "req-1" || record[TraceIDKey] != "trace-1" ||
+				record["user"] != "bob" {
+				t.Errorf("Missing %s context fields: %v\n", pkg, record)
+			}
+		}
+	}
+}
+
+func TestConsumerConfig(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	config := DefaultConsumerCfg()
+	if _, err := NewConsumer(config); err == nil {
+		t.Errorf("Expected error for consumer without group\n")
+	}
+	config.Group = "testgroup"
+	config.Rebalance = "invalid"
+	if _, err := NewConsumer(config); err == nil {
+		t.Errorf("Expected error for invalid rebalance type\n")
+	}
+}
+
+func TestFlushClose(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+
+		log.Info("before flush")
+		log.WithFields(LogFields{"a": 1}).Info("derived before flush")
+		if err := log.Flush(time.Second); err != nil {
+			t.Errorf("Failed to flush %s logger: %s\n", pkg, err.Error())
+		}
+		if err := log.Close(); err != nil {
+			t.Errorf("Failed to close %s logger: %s\n", pkg, err.Error())
+		}
+		if err := log.Close(); err != nil {
+			t.Errorf("Failed to close %s logger twice: %s\n", pkg, err.Error())
+		}
+
+		if messages := MockBrokerMessages(); len(messages) != 2 {
+			t.Errorf("Expected 2 %s messages, got %d\n", pkg, len(messages))
+		}
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		file := filepath.Join(t.TempDir(), "fields.log")
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = file
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.With(String("s", "v"), Int("n", 3), Bool("b", true),
+			Float64("f", 1.5), Duration("d", 1500*time.Millisecond),
+			Err(errors.New("boom")), Err(nil)).Info("typed fields")
+		log.Close()
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", file, err.Error())
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+		}
+		if record["s"] != "v" || record["n"] != 3.0 || record["b"] != true ||
+			record["f"] != 1.5 || record["d"] != "1.5s" ||
+			record[ErrorKey] != "boom" {
+			t.Errorf("Unexpected %s typed fields: %v\n", pkg, record)
+		}
+	}
+}
+
+func TestWithTopic(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+
+		audit := log.WithTopic("audit")
+		audit.Info("routed")
+		audit.WithFields(LogFields{"topic": "user field"}).
+			WithContext(context.Background()).Info("routed with fields")
+		log.Info("default")
+		log.Close()
+
+		expected := []string{"audit", "audit", cfg.KafkaProducerCfg.Topic}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %d %s messages, got %d\n",
+				len(expected), pkg, len(messages))
+		}
+		for i, msg := range messages {
+			if msg.Topic != expected[i] {
+				t.Errorf("Expected %s topic %s, got %s\n",
+					pkg, expected[i], msg.Topic)
+			}
+			if strings.Contains(msg.Value, "audit") {
+				t.Errorf("Topic leaked into %s record: %s\n", pkg, msg.Value)
+			}
+		}
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.RouteRules = []RouteRule{
+			{Level: ErrorType, Topic: "errors"},
+			{Fields: map[string]string{"service": "payments"},
+				Topic: "payments-logs"},
+		}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+
+		payments := log.WithFields(LogFields{"service": "payments"})
+		log.Error("failed")
+		payments.Info("paid")
+		payments.Error("payment failed")
+		payments.WithFields(LogFields{TopicKey: "explicit"}).Info("explicit")
+		log.WithFields(LogFields{"service": "orders"}).Warn("default")
+		log.Close()
+
+		expected := []string{"errors", "payments-logs", "errors", "explicit",
+			cfg.KafkaProducerCfg.Topic}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %d %s messages, got %d\n",
+				len(expected), pkg, len(messages))
+		}
+		for i, msg := range messages {
+			if msg.Topic != expected[i] {
+				t.Errorf("Expected %s topic %s, got %s\n",
+					pkg, expected[i], msg.Topic)
+			}
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.RouteRules = []RouteRule{{Level: "loud"}}
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected error for invalid route rule\n")
+	}
+}
+
+func TestWithCESource(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = CEFormat
+		cfg.EnableCloudEvents = true
+		cfg.CloudEventsCfg.Source = "//pavedroad.io/main"
+		cfg.KafkaProducerCfg.EnableMock = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+
+		billing := log.WithCESource("//pavedroad.io/billing")
+		billing.Info("billing event")
+		billing.WithFields(LogFields{"key": "value"}).
+			WithContext(context.Background()).Info("billing with fields")
+		log.Info("main event")
+		log.Close()
+
+		expected := []string{"//pavedroad.io/billing",
+			"//pavedroad.io/billing", "//pavedroad.io/main"}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %d %s messages, got %d\n",
+				len(expected), pkg, len(messages))
+		}
+		for i, msg := range messages {
+			var msgMap map[string]interface{}
+			if err := json.Unmarshal([]byte(msg.Value), &msgMap); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			if msgMap[CESourceKey] != expected[i] {
+				t.Errorf("Expected %s source %s, got %v\n",
+					pkg, expected[i], msgMap[CESourceKey])
+			}
+			if strings.Count(msg.Value, `"source"`) != 1 {
+				t.Errorf("Expected one source in %s record: %s\n",
+					pkg, msg.Value)
+			}
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	sink := &testSink{}
+	RegisterSink("test", func(config SinkConfiguration) (Sink, error) {
+		return sink, nil
+	})
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		*sink = testSink{}
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.Sinks = []SinkConfiguration{{Type: "test", Format: JSONFormat}}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.With(String("s", "v")).Warn("to sink")
+		log.Close()
+
+		if !sink.closed {
+			t.Errorf("Sink not closed by %s logger\n", pkg)
+		}
+		if len(sink.entries) != 1 {
+			t.Fatalf("Expected 1 %s sink entry, got %d\n", pkg, len(sink.entries))
+		}
+		entry := sink.entries[0]
+		if entry.Level != WarnType || entry.Message != "to sink" ||
+			entry.Time.IsZero() {
+			t.Errorf("Unexpected %s sink entry: %v\n", pkg, entry)
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(sink.records[0]), &record); err != nil {
+			t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+		}
+		if record["s"] != "v" || record["msg"] != "to sink" {
+			t.Errorf("Unexpected %s sink record: %v\n", pkg, record)
+		}
+	}
+
+	cfg := DefaultLoggerCfg()
+	cfg.Sinks = []SinkConfiguration{{Type: "unregistered"}}
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected error for unregistered sink type\n")
+	}
+}
+
+func TestConsoleLineWrites(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	const routines, records = 20, 50
+	console := getConsoleWriter(Stdout).(*lockedWriter)
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		out := &chunkWriter{}
+		console.mutex.Lock()
+		stdout := console.out
+		console.out = out
+		console.mutex.Unlock()
+
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = true
+		cfg.ConsoleFormat = JSONFormat
+		cfg.ConsoleWriter = Stdout
+		cfg.FileLocation = filepath.Join(t.TempDir(), "console.log")
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		var wg sync.WaitGroup
+		for r := 0; r < routines; r++ {
+			wg.Add(1)
+			go func(r int) {
+				defer wg.Done()
+				for i := 0; i < records; i++ {
+					log.Infof("routine %d record %d", r, i)
+				}
+			}(r)
+		}
+		wg.Wait()
+		log.Close()
+
+		console.mutex.Lock()
+		console.out = stdout
+		console.mutex.Unlock()
+
+		lines := strings.Split(strings.TrimSuffix(out.buf.String(), "\n"), "\n")
+		if len(lines) != routines*records {
+			t.Fatalf("Expected %d %s console lines, got %d\n",
+				routines*records, pkg, len(lines))
+		}
+		for _, line := range lines {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("Interleaved %s console line: %s\n", pkg, line)
+			}
+		}
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		file := filepath.Join(t.TempDir(), "deadletter.log")
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Key = ExtractedKey
+		cfg.KafkaProducerCfg.KeyName = "user"
+		cfg.KafkaProducerCfg.DeadLetterTopic = "deadletter"
+		cfg.KafkaProducerCfg.DeadLetterFile = file
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("missing key")
+		log.WithFields(LogFields{"user": "alice"}).Info("extracted key")
+		log.Close()
+		lockedMutex.Lock()
+		for out := range lockedWriters {
+			if f, ok := out.(*os.File); ok && f.Name() == file {
+				t.Errorf("Expected %s dead letter writer released\n", pkg)
+			}
+		}
+		lockedMutex.Unlock()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 2 || messages[0].Topic != "deadletter" ||
+			messages[1].Topic != cfg.KafkaProducerCfg.Topic {
+			t.Fatalf("Unexpected %s messages: %v\n", pkg, messages)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", file, err.Error())
+		}
+		for _, raw := range []string{messages[0].Value, string(data)} {
+			var record map[string]string
+			if err := json.Unmarshal([]byte(raw), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s dead letter: %s\n",
+					pkg, err.Error())
+			}
+			if record[DeadLetterErrorKey] != "Extracted key missing" ||
+				!strings.Contains(record[DeadLetterRecordKey], "missing key") {
+				t.Errorf("Unexpected %s dead letter: %v\n", pkg, record)
+			}
+		}
+	}
+
+	// the files opened are released when the producer fails to be created
+	errFactory := errors.New("no broker")
+	SetProducerFactory(func(config *sarama.Config) (sarama.AsyncProducer,
+		error) {
+		return nil, errFactory
+	})
+	defer SetProducerFactory(nil)
+	pc := DefaultProducerCfg()
+	pc.DeadLetterFile = filepath.Join(t.TempDir(), "deadletter.log")
+	pc.SpillFile = filepath.Join(t.TempDir(), "spill.log")
+	if _, err := NewSender(pc); err != errFactory {
+		t.Fatalf("Expected producer factory error, got %v\n", err)
+	}
+	lockedMutex.Lock()
+	for out := range lockedWriters {
+		if f, ok := out.(*os.File); ok && f.Name() == pc.DeadLetterFile {
+			t.Errorf("Expected dead letter writer released\n")
+		}
+	}
+	lockedMutex.Unlock()
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	spill := filepath.Join(t.TempDir(), "spill.log")
+	var failed []string
+	var failedErr error
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.DeliveryRetryMax = 2
+	cfg.KafkaProducerCfg.DeliveryRetryFreq = time.Millisecond
+	cfg.KafkaProducerCfg.SpillFile = spill
+	cfg.KafkaProducerCfg.OnDeliveryError(func(msg []byte, err error) {
+		failed = append(failed, string(msg))
+		failedErr = err
+	})
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+
+	// delivered on the last retry
+	MockBrokerFail(2)
+	log.Info("retried")
+	if err := log.Flush(time.Second); err != nil {
+		t.Fatalf("Flush failed: %s\n", err.Error())
+	}
+	messages := MockBrokerMessages()
+	if len(messages) != 1 || !strings.Contains(messages[0].Value, "retried") {
+		t.Fatalf("Unexpected retried messages: %v\n", messages)
+	}
+
+	// retries exhausted
+	MockBrokerFail(3)
+	log.Info("undelivered")
+	if err := log.Flush(time.Second); err != nil {
+		t.Fatalf("Flush failed: %s\n", err.Error())
+	}
+	log.Close()
+
+	if len(MockBrokerMessages()) != 1 {
+		t.Errorf("Undelivered message recorded by broker\n")
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0], "undelivered") ||
+		failedErr != ErrMockDelivery {
+		t.Errorf("Unexpected delivery errors: %v %v\n", failed, failedErr)
+	}
+	data, err := ioutil.ReadFile(spill)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s\n", spill, err.Error())
+	}
+	var record map[string]string
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Failed to unmarshal spill record: %s\n", err.Error())
+	}
+	if record[SpillTopicKey] != cfg.KafkaProducerCfg.Topic ||
+		record[DeadLetterErrorKey] != ErrMockDelivery.Error() ||
+		!strings.Contains(record[DeadLetterRecordKey], "undelivered") {
+		t.Errorf("Unexpected spill record: %v\n", record)
+	}
+}
+
+func TestProducerThrottle(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.ProdFlushFreq = 0
+	cfg.KafkaProducerCfg.ThrottleLatency = 5 * time.Millisecond
+	cfg.KafkaProducerCfg.ThrottleMaxDelay = 20 * time.Millisecond
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	defer log.Close()
+
+	events := ProducerThrottle().Events
+	MockBrokerLatency(10 * time.Millisecond)
+	for i := 0; i < 6; i++ {
+		log.Info("throttled")
+		log.Flush(time.Second)
+	}
+	stats := ProducerThrottle()
+	if stats.Events < events+5 || stats.Delay != 20*time.Millisecond {
+		t.Errorf("Expected throttling, got %+v\n", stats)
+	}
+
+	MockBrokerLatency(0)
+	for i := 0; i < 10; i++ {
+		log.Info("recovered")
+		log.Flush(time.Second)
+	}
+	if stats = ProducerThrottle(); stats.Delay != 0 {
+		t.Errorf("Expected throttling to recover, got %+v\n", stats)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	versions := map[sarama.KafkaVersion]sarama.CompressionCodec{
+		sarama.V0_9_0_0: sarama.CompressionSnappy,
+		sarama.V1_0_0_0: sarama.CompressionLZ4,
+		sarama.V2_1_0_0: sarama.CompressionZSTD,
+	}
+	for version, expected := range versions {
+		if codec := autoCompression(version); codec != expected {
+			t.Errorf("Expected %s compression for %s, got %s\n",
+				expected, version, codec)
+		}
+	}
+	// the configured version is capped at the version of the brokers
+	negotiated := []struct {
+		produce  int16
+		expected sarama.CompressionCodec
+	}{{7, sarama.CompressionZSTD}, {3, sarama.CompressionLZ4},
+		{1, sarama.CompressionSnappy}}
+	for _, n := range negotiated {
+		version := negotiatedVersion(sarama.V2_1_0_0, n.produce)
+		if codec := autoCompression(version); codec != n.expected {
+			t.Errorf("Expected %s compression for produce version %d, got %s\n",
+				n.expected, n.produce, codec)
+		}
+	}
+	if version := negotiatedVersion(sarama.V1_0_0_0, 7); version != sarama.V1_0_0_0 {
+		t.Errorf("Expected configured version kept, got %s\n", version)
+	}
+
+	levels := []struct {
+		compression compressionType
+		level       int
+		valid       bool
+	}{
+		{CompressionGZIP, 9, true},
+		{CompressionGZIP, 10, false},
+		{CompressionZSTD, 22, true},
+		{CompressionSnappy, 1, false},
+		{CompressionAuto, 0, true},
+	}
+	for _, l := range levels {
+		cfg := *DefaultCompleteCfg()
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Compression = l.compression
+		cfg.KafkaProducerCfg.CompressionLevel = l.level
+		log, err := NewLogger(cfg)
+		if (err == nil) != l.valid {
+			t.Errorf("Unexpected %s level %d result: %v\n",
+				l.compression, l.level, err)
+		}
+		if err == nil {
+			log.Close()
+		}
+	}
+}
+
+func TestSender(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.DeliveryRetryMax = 0
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate sender: %s\n", err.Error())
+	}
+
+	type result struct {
+		partition, offset int64
+		err               error
+	}
+	results := make(chan result, 3)
+	callback := func(partition, offset int64, err error) {
+		results <- result{partition, offset, err}
+	}
+	for _, value := range []string{"first", "second"} {
+		if err := sender.SendWithCallback("events", "id", []byte(value),
+			callback); err != nil {
+			t.Fatalf("Send failed: %s\n", err.Error())
+		}
+	}
+	sender.Flush(time.Second)
+	MockBrokerFail(1)
+	sender.SendWithCallback("events", "id", []byte("failed"), callback)
+	sender.Close()
+
+	expected := []result{{0, 0, nil}, {0, 1, nil}, {-1, -1, ErrMockDelivery}}
+	for _, e := range expected {
+		if r := <-results; r != e {
+			t.Errorf("Expected callback %v, got %v\n", e, r)
+		}
+	}
+	if err := sender.Send("events", "", []byte("closed")); err != ErrProducerClosed {
+		t.Errorf("Expected closed error, got %v\n", err)
+	}
+}
+
+func TestSenderBatchSync(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.Partition = HashPartition
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate sender: %s\n", err.Error())
+	}
+
+	if err := sender.SendBatch("events", [][]byte{[]byte("b1"),
+		[]byte("b2"), []byte("b3")}); err != nil {
+		t.Fatalf("SendBatch failed: %s\n", err.Error())
+	}
+	if err := sender.Flush(time.Second); err != nil {
+		t.Fatalf("Flush failed: %s\n", err.Error())
+	}
+	for i, value := range []string{"s1", "s2"} {
+		partition, offset, err := sender.SendSync("events", "id", []byte(value))
+		if err != nil {
+			t.Fatalf("SendSync failed: %s\n", err.Error())
+		}
+		if partition != 0 || offset != int64(3+i) {
+			t.Errorf("Expected sync partition 0 offset %d, got %d %d\n", 3+i,
+				partition, offset)
+		}
+	}
+	MockBrokerFail(1)
+	if _, _, err := sender.SendSync("events", "", []byte("failed")); err != ErrMockDelivery {
+		t.Errorf("Expected sync delivery error, got %v\n", err)
+	}
+
+	messages := MockBrokerMessages()
+	expected := []string{"b1", "b2", "b3", "s1", "s2"}
+	if len(messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d\n", len(expected), len(messages))
+	}
+	for i, message := range messages {
+		if message.Value != expected[i] || message.Topic != "events" {
+			t.Errorf("Expected message %s, got %v\n", expected[i], message)
+		}
+	}
+
+	sender.Close()
+	if _, _, err := sender.SendSync("events", "", []byte("closed")); err != ErrProducerClosed {
+		t.Errorf("Expected sync closed error, got %v\n", err)
+	}
+	err = sender.SendBatch("events", [][]byte{[]byte("closed")})
+	if !errors.Is(err, ErrProducerClosed) {
+		t.Errorf("Expected batch closed error, got %v\n", err)
+	}
+}
+
+func TestOutputLevels(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	sink := &testSink{}
+	RegisterSink("leveltest", func(config SinkConfiguration) (Sink, error) {
+		return sink, nil
+	})
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		*sink = testSink{}
+		file := filepath.Join(t.TempDir(), "levels.log")
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.LogLevel = InfoType
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = file
+		cfg.FileLevel = DebugType
+		cfg.Sinks = []SinkConfiguration{
+			{Type: "leveltest", Format: JSONFormat, Level: WarnType}}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Debug("debug")
+		log.Info("info")
+		log.Warn("warn")
+		log.Close()
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", file, err.Error())
+		}
+		if lines := strings.Count(string(data), "\n"); lines != 3 {
+			t.Errorf("Expected 3 %s file records, got %d\n", pkg, lines)
+		}
+		if len(sink.entries) != 1 || sink.entries[0].Level != WarnType {
+			t.Errorf("Expected %s sink warn record only, got %v\n",
+				pkg, sink.entries)
+		}
+	}
+
+	cfg := DefaultLoggerCfg()
+	cfg.KafkaLevel = "verbose"
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected error for invalid KafkaLevel\n")
+	}
+}
+
+func TestProducerVersion(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for version, valid := range map[string]bool{
+		"2.1.0": true, "": true, "two": false} {
+		cfg := DefaultProducerCfg()
+		cfg.EnableMock = true
+		cfg.KafkaVersion = version
+		cfg.ClientID = "logger-test"
+		sender, err := NewSender(cfg)
+		if (err == nil) != valid {
+			t.Errorf("Unexpected KafkaVersion %q result: %v\n", version, err)
+		}
+		if err == nil {
+			sender.Close()
+		}
+	}
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	var requests []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/subjects/logs-value/versions" {
+				http.NotFound(w, r)
+				return
+			}
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			requests = append(requests, request)
+			w.Write([]byte(`{"id":7}`))
+		}))
+	defer server.Close()
+
+	for _, format := range []schemaFormatType{SchemaAvro, SchemaJSON} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.EnableTimeStamps = false
+		cfg.EnableCloudEvents = false
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Key = LevelKey
+		cfg.KafkaProducerCfg.EnableSchemaRegistry = true
+		cfg.KafkaProducerCfg.SchemaRegistryCfg.URL = server.URL
+		cfg.KafkaProducerCfg.SchemaRegistryCfg.Format = format
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", format, err.Error())
+		}
+		log.Info("hi")
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 %s message, got %d\n", format, len(messages))
+		}
+		value := []byte(messages[0].Value)
+		if !bytes.Equal(value[:5], []byte{0, 0, 0, 0, 7}) {
+			t.Errorf("Unexpected %s wire format header: %v\n", format, value[:5])
+		}
+		var expected []byte
+		if format == SchemaJSON {
+			expected = []byte(`{"level":"info","msg":"hi"}`)
+		} else {
+			// map block of 2, "level" string "info", "msg" string "hi", end
+			expected = []byte("\x04\x0alevel\x08\x08info\x06msg\x08\x04hi\x00")
+		}
+		if !bytes.Equal(value[5:], expected) {
+			t.Errorf("Unexpected %s payload: %q\n", format, value[5:])
+		}
+	}
+	if len(requests) != 2 || requests[0]["schema"] != avroLogSchema ||
+		requests[1]["schemaType"] != "JSON" {
+		t.Errorf("Unexpected schema registrations: %v\n", requests)
+	}
+
+	// records are encoded with the configured avro schema
+	schema := `{"type":"record","name":"Log","fields":[` +
+		`{"name":"level","type":{"type":"enum","name":"Level",` +
+		`"symbols":["debug","info"]}},{"name":"msg","type":"string"},` +
+		`{"name":"count","type":["null","long"],"default":null}]}`
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.EnableTimeStamps = false
+	cfg.EnableCloudEvents = false
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.EnableSchemaRegistry = true
+	cfg.KafkaProducerCfg.SchemaRegistryCfg.URL = server.URL
+	cfg.KafkaProducerCfg.SchemaRegistryCfg.Schema = schema
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	log.Info("hi")
+	log.WithFields(LogFields{"count": 3}).Info("hi")
+	log.Close()
+
+	messages := MockBrokerMessages()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d\n", len(messages))
+	}
+	// enum index 1, "msg" string "hi", count null then long 3
+	for i, expected := range [][]byte{[]byte("\x02\x04hi\x00"),
+		[]byte("\x02\x04hi\x02\x06")} {
+		if value := []byte(messages[i].Value); !bytes.Equal(value[5:], expected) {
+			t.Errorf("Unexpected configured schema payload: %q\n", value[5:])
+		}
+	}
+	if requests[len(requests)-1]["schema"] != schema {
+		t.Errorf("Configured schema not registered: %v\n", requests)
+	}
+
+	errCount := 0
+	checkSchemaRegistryConfig(SchemaRegistryConfiguration{URL: server.URL,
+		Schema: `{"type":"record","name":"Log","fields":[{"name":"msg","type":"text"}]}`},
+		&errCount)
+	if errCount == 0 {
+		t.Errorf("Unknown avro type should fail\n")
+	}
+}
+
+func TestCEBinaryMode(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = CEFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.CEBinaryMode = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("binary")
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 %s message, got %d\n", pkg, len(messages))
+		}
+		headers := messages[0].Headers
+		for _, attr := range []string{CEIDKey, CESourceKey, CESpecVersionKey,
+			CETypeKey, CETimeKey} {
+			if headers[CEHeaderPrefix+attr] == "" {
+				t.Errorf("Missing %s header %s: %v\n", pkg, attr, headers)
+			}
+		}
+		if headers[CEContentTypeHeader] != CEJSONContentType {
+			t.Errorf("Unexpected %s content type: %v\n", pkg, headers)
+		}
+		// the value is the event data alone
+		if messages[0].Value != `"binary"` {
+			t.Errorf("Unexpected %s value: %s\n", pkg, messages[0].Value)
+		}
+	}
+
+	// record headers require kafka 0.11, the version defaults to it
+	errCaptured := errors.New("captured")
+	var captured *sarama.Config
+	SetProducerFactory(func(config *sarama.Config) (sarama.AsyncProducer,
+		error) {
+		captured = config
+		return nil, errCaptured
+	})
+	defer SetProducerFactory(nil)
+	pc := DefaultProducerCfg()
+	pc.CEBinaryMode = true
+	if _, err := NewSender(pc); err != errCaptured {
+		t.Fatalf("Expected producer factory call, got %v\n", err)
+	}
+	if !captured.Version.IsAtLeast(sarama.V0_11_0_0) {
+		t.Errorf("Expected binary mode kafka version 0.11, got %s\n",
+			captured.Version)
+	}
+	errCount := 0
+	checkProducerConfig(ProducerConfiguration{CEBinaryMode: true,
+		KafkaVersion: "0.10.2.0"}, &errCount)
+	if errCount == 0 {
+		t.Errorf("Binary mode with kafka 0.10 passed\n")
+	}
+}
+
+func TestCEExtensions(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	RegisterCEExtension(func(record map[string]interface{}) map[string]string {
+		tenant, _ := record["tenant"].(string)
+		return map[string]string{"tenant": tenant, "Invalid": "x", CEIDKey: "x"}
+	})
+	defer func() {
+		ceExtensionMutex.Lock()
+		ceExtensionFuncs = nil
+		atomic.StoreInt32(&ceExtensionCount, 0)
+		ceExtensionMutex.Unlock()
+		SetTraceContextFunc(nil)
+	}()
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	SetTraceContextFunc(func(ctx context.Context) (string, string) {
+		return traceparent, "span=func"
+	})
+
+	for _, binary := range []bool{false, true} {
+		for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+			ResetMockBroker()
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableFile = false
+			cfg.EnableKafka = true
+			cfg.KafkaFormat = CEFormat
+			cfg.KafkaProducerCfg.EnableMock = true
+			cfg.KafkaProducerCfg.CEBinaryMode = binary
+			cfg.CloudEventsCfg.Extensions = map[string]string{"region": "eu"}
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			ctx := ContextWithTraceParent(context.Background(), traceparent,
+				"vendor=1")
+			log.WithFields(LogFields{"tenant": "acme"}).InfoCtx(ctx, "traced")
+			log.InfoCtx(context.Background(), "from func")
+			ctx = ContextWithTraceParent(context.Background(), "invalid", "x")
+			log.InfoCtx(ctx, "untraced")
+			log.Close()
+
+			messages := MockBrokerMessages()
+			if len(messages) != 3 {
+				t.Fatalf("Expected 3 %s messages, got %d\n", pkg, len(messages))
+			}
+			attributes := make([]map[string]string, len(messages))
+			for i, message := range messages {
+				attributes[i] = map[string]string{}
+				if binary {
+					for key, value := range message.Headers {
+						attributes[i][strings.TrimPrefix(key, CEHeaderPrefix)] = value
+					}
+					continue
+				}
+				var value map[string]interface{}
+				if err := json.Unmarshal([]byte(message.Value), &value); err != nil {
+					t.Fatalf("Failed to unmarshal %s value: %s\n", pkg, err.Error())
+				}
+				for key, val := range value {
+					if s, ok := val.(string); ok {
+						attributes[i][key] = s
+					}
+				}
+			}
+
+			traced := attributes[0]
+			if traced["region"] != "eu" || traced["tenant"] != "acme" ||
+				traced[CETraceParentKey] != traceparent ||
+				traced[CETraceStateKey] != "vendor=1" ||
+				traced["Invalid"] != "" || traced[CEIDKey] == "x" {
+				t.Errorf("Unexpected %s binary %v attributes: %v\n", pkg, binary,
+					traced)
+			}
+			if attributes[1][CETraceStateKey] != "span=func" {
+				t.Errorf("Expected %s trace context func, got %v\n", pkg,
+					attributes[1])
+			}
+			if _, ok := attributes[2][CETraceParentKey]; ok {
+				t.Errorf("Expected %s invalid traceparent dropped, got %v\n", pkg,
+					attributes[2])
+			}
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableKafka = true
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.CloudEventsCfg.Extensions = map[string]string{CESubjectKey: "x"}
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected core attribute extension to fail\n")
+	}
+}
+
+func TestManualPartition(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		MockBrokerPartitions(4)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Partition = ManualPartition
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		parent := log
+		log = log.WithKafkaPartitionFn(func(msg *map[string]interface{}) int32 {
+			if (*msg)["tenant"] == "acme" {
+				return 3
+			}
+			return 1
+		})
+		log.WithFields(LogFields{PartitionKey: 2}).Info("pinned")
+		log.WithFields(LogFields{PartitionKey: "0", "tenant": "acme"}).
+			Info("pinned string")
+		log.WithFields(LogFields{"tenant": "acme"}).Info("tenant")
+		log.Info("other")
+		// the partition function is not set on the parent logger
+		parent.WithFields(LogFields{"tenant": "acme"}).Info("parent")
+		log.Close()
+
+		expected := []int32{2, 0, 3, 1, 0}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %d %s messages, got %d\n", len(expected), pkg,
+				len(messages))
+		}
+		for i, message := range messages {
+			if message.Partition != expected[i] {
+				t.Errorf("Expected %s message %d partition %d, got %d\n", pkg, i,
+					expected[i], message.Partition)
+			}
+			if strings.Contains(message.Value, `"`+PartitionKey+`"`) {
+				t.Errorf("Expected %s partition field removed: %s\n", pkg,
+					message.Value)
+			}
+		}
+	}
+
+	// keys keep their partition with hash partitioning
+	ResetMockBroker()
+	MockBrokerPartitions(4)
+	defer ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.Partition = HashPartition
+	cfg.KafkaProducerCfg.Key = FixedKey
+	cfg.KafkaProducerCfg.KeyName = "tenant-a"
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		log.Infof("hashed %d", i)
+	}
+	log.Close()
+	partition, err := HashPartitionFor("tenant-a", 4)
+	if err != nil {
+		t.Fatalf("Failed to get hash partition: %s\n", err.Error())
+	}
+	for _, message := range MockBrokerMessages() {
+		if message.Partition != partition {
+			t.Errorf("Expected hash partition %d, got %d\n", partition,
+				message.Partition)
+		}
+	}
+}
+
+func TestServiceMetadata(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.ServiceName = "billing"
+		cfg.ServiceVersion = "1.2.3"
+		cfg.Environment = "staging"
+		cfg.CloudEventsCfg.Source = "//pavedroad.io/{service}"
+		cfg.CloudEventsCfg.Type = "io.pavedroad.{service}.{environment}"
+		cfg.CloudEventsCfg.LevelTypes = map[LevelType]string{
+			ErrorType: "io.pavedroad.{service}.error"}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("service")
+		log.WithFields(LogFields{"user": "test"}).Error("service error")
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 %s messages, got %d\n", pkg, len(messages))
+		}
+		expectedTypes := []string{"io.pavedroad.billing.staging",
+			"io.pavedroad.billing.error"}
+		for i, message := range messages {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(message.Value), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			if record[ServiceNameKey] != "billing" ||
+				record[ServiceVersionKey] != "1.2.3" ||
+				record[EnvironmentKey] != "staging" {
+				t.Errorf("Missing %s service metadata: %v\n", pkg, record)
+			}
+			if hostName, _ := os.Hostname(); record[HostNameKey] != hostName {
+				t.Errorf("Expected %s system host name: %v\n", pkg, record)
+			}
+			if record[CESourceKey] != "//pavedroad.io/billing" ||
+				record[CETypeKey] != expectedTypes[i] {
+				t.Errorf("Unexpected %s cloudevents source and type: %v\n", pkg,
+					record)
+			}
+		}
+		if cfg.CloudEventsCfg.Type != "io.pavedroad.{service}.{environment}" {
+			t.Errorf("Config %s cloudevents type modified\n", pkg)
+		}
+	}
+
+	// environment overrides under the logger prefix
+	t.Setenv(LogEnvPrefix+"_SERVICENAME", "envservice")
+	t.Setenv(LogEnvPrefix+"_HOSTNAME", "envhost")
+	envCfg, err := GetLoggerConfiguration(EnvConfig, "")
+	if err != nil {
+		t.Fatalf("Failed to get configuration: %s\n", err.Error())
+	}
+	if envCfg.ServiceName != "envservice" || envCfg.HostName != "envhost" {
+		t.Errorf("Environment overrides not applied: %+v\n", envCfg)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+
+		handler := DiagnosticsMiddleware(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+				if !AddDiagnostics(ctx, LogFields{"userid": "u1"}) {
+					t.Errorf("Expected %s diagnostic context\n", pkg)
+				}
+				log.InfoCtx(ctx, "authenticated")
+				step := ContextWithDiagnostics(ctx, LogFields{"step": "charge"})
+				AddDiagnostics(step, LogFields{"amount": 10})
+				log.InfoCtx(step, "charged")
+				log.InfoCtx(ctx, "done")
+			}))
+		request := httptest.NewRequest(http.MethodPost, "/pay", nil)
+		request.Header.Set(RequestIDHeader, "r1")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		if AddDiagnostics(context.Background(), LogFields{"userid": "u2"}) {
+			t.Errorf("Expected %s no diagnostic context\n", pkg)
+		}
+		log.InfoCtx(context.Background(), "outside")
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 4 {
+			t.Fatalf("Expected 4 %s messages, got %d\n", pkg, len(messages))
+		}
+		records := make([]map[string]interface{}, len(messages))
+		for i, message := range messages {
+			if err := json.Unmarshal([]byte(message.Value), &records[i]); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+		}
+		for i, record := range records[:3] {
+			if record[RequestIDKey] != "r1" || record["userid"] != "u1" {
+				t.Errorf("Missing %s diagnostics in record %d: %v\n", pkg, i, record)
+			}
+		}
+		if records[1]["step"] != "charge" || records[1]["amount"] != float64(10) {
+			t.Errorf("Missing %s step diagnostics: %v\n", pkg, records[1])
+		}
+		if _, ok := records[2]["step"]; ok {
+			t.Errorf("Unexpected %s step diagnostics: %v\n", pkg, records[2])
+		}
+		if _, ok := records[3]["userid"]; ok {
+			t.Errorf("Unexpected %s diagnostics outside: %v\n", pkg, records[3])
+		}
+	}
+}
+
+func TestCrashReporter(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	saved := logger
+	defer func() {
+		loggerMutex.Lock()
+		logger = saved
+		loggerMutex.Unlock()
+		crashExit = os.Exit
+	}()
+	exits := make(chan int, 1)
+	crashExit = func(code int) { exits <- code }
+
+	record := func(pkg PackageType) map[string]interface{} {
+		messages := MockBrokerMessages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 %s crash message, got %d\n", pkg, len(messages))
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(messages[0].Value), &record); err != nil {
+			t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+		}
+		return record
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		start := func() {
+			ResetMockBroker()
+			loggerMutex.Lock()
+			logger = nil
+			loggerMutex.Unlock()
+			if err := ReloadConfiguration(cfg); err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+		}
+
+		// panic
+		start()
+		repanicked := func() (value interface{}) {
+			defer func() { value = recover() }()
+			defer RecoverAndLog()
+			panic("boom")
+		}()
+		if repanicked != "boom" {
+			t.Errorf("Expected %s panic to continue, got %v\n", pkg, repanicked)
+		}
+		crash := record(pkg)
+		if crash[PanicKey] != "boom" || crash["msg"] != "Unhandled panic" {
+			t.Errorf("Expected %s panic record, got %v\n", pkg, crash)
+		}
+		if stack, _ := crash[StackTraceKey].(string); !strings.Contains(stack, "TestCrashReporter") {
+			t.Errorf("Expected %s panic stack trace, got %v\n", pkg, crash)
+		}
+		// the logger is still open after the recovered panic
+		ResetMockBroker()
+		Info("recovered")
+		if err := packageLogger().Flush(time.Second); err != nil {
+			t.Fatalf("Failed to flush %s logger: %s\n", pkg, err.Error())
+		}
+		if after := record(pkg); after["msg"] != "recovered" {
+			t.Errorf("Expected %s record after recovered panic, got %v\n", pkg, after)
+		}
+
+		// fatal signal
+		start()
+		stop := EnableCrashHandler(syscall.SIGUSR2)
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+			t.Fatalf("Failed to send SIGUSR2: %s\n", err.Error())
+		}
+		select {
+		case code := <-exits:
+			if code != 128+int(syscall.SIGUSR2) {
+				t.Errorf("Expected %s exit code %d, got %d\n", pkg,
+					128+int(syscall.SIGUSR2), code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %s exit on SIGUSR2\n", pkg)
+		}
+		stop()
+		stop()
+		crash = record(pkg)
+		if crash[SignalKey] != syscall.SIGUSR2.String() {
+			t.Errorf("Expected %s signal record, got %v\n", pkg, crash)
+		}
+		if stack, _ := crash[StackTraceKey].(string); !strings.Contains(stack, "goroutine") {
+			t.Errorf("Expected %s goroutine stacks, got %v\n", pkg, crash)
+		}
+	}
+}
+
+func TestStartupEvent(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+
+	defer atomic.StoreInt32(&startupLogged, 0)
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		atomic.StoreInt32(&startupLogged, 0)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.ServiceName = "billing"
+		cfg.ServiceVersion = "1.2.3"
+		cfg.EnableStartup = true
+		cfg.CloudEventsCfg.EventTypes = map[string]string{
+			StartupEventName: "io.pavedroad.{service}.startup"}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("running")
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) != 2 {
+			t.Fatalf("Expected 2 %s messages, got %d\n", pkg, len(messages))
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(messages[0].Value), &record); err != nil {
+			t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+		}
+		if record[CETypeKey] != "io.pavedroad.billing.startup" ||
+			record[ServiceNameKey] != "billing" ||
+			record[ServiceVersionKey] != "1.2.3" {
+			t.Errorf("Unexpected %s startup event: %v\n", pkg, record)
+		}
+		if record[GoVersionKey] != runtime.Version() {
+			t.Errorf("Expected %s go version %s: %v\n", pkg, runtime.Version(),
+				record)
+		}
+		if hash, _ := record[ConfigHashKey].(string); len(hash) != 64 {
+			t.Errorf("Expected %s config hash: %v\n", pkg, record)
+		}
+		if sinks, _ := record[SinksKey].([]interface{}); len(sinks) != 1 ||
+			sinks[0] != "kafka" {
+			t.Errorf("Expected %s kafka sink: %v\n", pkg, record)
+		}
+
+		hash, _ := cfg.hash()
+		other := cfg
+		other.HostName = "other-host"
+		if otherHash, _ := other.hash(); otherHash != hash {
+			t.Errorf("Expected %s config hash independent of the host\n", pkg)
+		}
+		other.ServiceVersion = "1.2.4"
+		if otherHash, _ := other.hash(); otherHash == hash {
+			t.Errorf("Expected %s config hash to identify the config\n", pkg)
+		}
+
+		// logged once per process, at a level every output writes
+		ResetMockBroker()
+		cfg.KafkaLevel = WarnType
+		if level := cfg.startupLevel(); level != WarnType {
+			t.Errorf("Expected %s startup level warn, got %s\n", pkg, level)
+		}
+		log, err = NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Close()
+		if messages := MockBrokerMessages(); len(messages) != 0 {
+			t.Errorf("Expected %s startup event logged once, got %v\n", pkg,
+				messages)
+		}
+		atomic.StoreInt32(&startupLogged, 0)
+		ResetMockBroker()
+		log, err = NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Close()
+		if messages := MockBrokerMessages(); len(messages) != 1 {
+			t.Errorf("Expected %s startup event at warn, got %v\n", pkg,
+				messages)
+		}
+	}
+}
+
+func TestCaller(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	saved := logger
+	defer func() {
+		loggerMutex.Lock()
+		logger = saved
+		loggerMutex.Unlock()
+	}()
+	helper := func(log Logger, msg string) {
+		log.Info(msg)
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		for _, format := range []FormatType{JSONFormat, CEFormat} {
+			ResetMockBroker()
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableFile = false
+			cfg.EnableKafka = true
+			cfg.KafkaFormat = format
+			cfg.KafkaProducerCfg.EnableMock = true
+			cfg.EnableCaller = true
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			cfg.CallerSkip = 1
+			skipLog, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			loggerMutex.Lock()
+			logger = log
+			loggerMutex.Unlock()
+
+			_, _, line, _ := runtime.Caller(0)
+			log.Info("method")
+			log.WithFields(LogFields{"k": "v"}).InfoCtx(context.Background(), "ctx")
+			Info("package")
+			helper(skipLog, "skip")
+			lines := map[string]int{"method": line + 1, "ctx": line + 2,
+				"package": line + 3, "skip": line + 4}
+			log.Close()
+			skipLog.Close()
+
+			messages := MockBrokerMessages()
+			if len(messages) != len(lines) {
+				t.Fatalf("Expected %d %s messages, got %d\n", len(lines), pkg,
+					len(messages))
+			}
+			for _, message := range messages {
+				var record map[string]interface{}
+				if err := json.Unmarshal([]byte(message.Value), &record); err != nil {
+					t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+				}
+				msg, _ := record["msg"].(string)
+				if format == CEFormat {
+					msg, _ = record[CEDataKey].(string)
+				}
+				expected := fmt.Sprintf("/logger_test.go:%d", lines[msg])
+				if caller, _ := record[CallerKey].(string); !strings.HasSuffix(
+					caller, expected) {
+					t.Errorf("Expected %s %s caller %s, got %v\n", pkg, format,
+						expected, record[CallerKey])
+				}
+				if function, _ := record[CallerFunctionKey].(string); !strings.HasPrefix(
+					function, packagePath+".TestCaller") {
+					t.Errorf("Expected %s %s function TestCaller, got %v\n", pkg,
+						format, record[CallerFunctionKey])
+				}
+			}
+		}
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.LogLevel = ErrorType
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.HeartbeatInterval = 10 * time.Millisecond
+		cfg.KafkaProducerCfg.HeartbeatFields = map[string]string{"region": "eu"}
+		cfg.ServiceName = "billing"
+		cfg.CloudEventsCfg.EventTypes = map[string]string{
+			HeartbeatEventName: "io.pavedroad.heartbeat"}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(MockBrokerMessages()) < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		log.Close()
+
+		messages := MockBrokerMessages()
+		if len(messages) < 2 {
+			t.Fatalf("Expected %s heartbeats, got %d\n", pkg, len(messages))
+		}
+		for i, message := range messages[:2] {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(message.Value), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			if record[HeartbeatSequenceKey] != float64(i+1) ||
+				record[CEDataKey] != HeartbeatMessage ||
+				record[CETypeKey] != "io.pavedroad.heartbeat" {
+				t.Errorf("Unexpected %s heartbeat: %v\n", pkg, record)
+			}
+			if record["region"] != "eu" || record[ServiceNameKey] != "billing" {
+				t.Errorf("Missing %s heartbeat fields: %v\n", pkg, record)
+			}
+			if _, ok := record[CEIDKey]; !ok {
+				t.Errorf("Missing %s heartbeat cloudevents id: %v\n", pkg, record)
+			}
+		}
+		if _, ok := cfg.KafkaProducerCfg.HeartbeatFields[ServiceNameKey]; ok {
+			t.Errorf("Config %s heartbeat fields modified\n", pkg)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	SetClock(NewFixedClock(time.Now()))
+	defer SetClock(nil)
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		collector := &testCollector{counts: make(map[string]int)}
+		SetMetricsCollector(collector)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.QuotaKey = "tenant"
+		cfg.KafkaProducerCfg.QuotaRate = 1
+		cfg.KafkaProducerCfg.QuotaBurst = 2
+		cfg.KafkaProducerCfg.QuotaInterval = 10 * time.Millisecond
+		cfg.KafkaProducerCfg.QuotaMetricValues = 1
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		for i := 0; i < 5; i++ {
+			log.WithFields(LogFields{"tenant": "noisy"}).Info("noisy")
+		}
+		for i := 0; i < 3; i++ {
+			log.WithFields(LogFields{"tenant": "loud"}).Info("loud")
+		}
+		log.WithFields(LogFields{"tenant": "quiet"}).Info("quiet")
+		log.Info("untenanted")
+
+		summary := func() map[string]interface{} {
+			for _, message := range MockBrokerMessages() {
+				var record map[string]interface{}
+				if err := json.Unmarshal([]byte(message.Value), &record); err != nil {
+					t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+				}
+				if record[EventNameKey] == QuotaEventName && record["tenant"] == "noisy" {
+					return record
+				}
+			}
+			return nil
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for summary() == nil && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		log.Close()
+		SetMetricsCollector(nil)
+
+		counts := map[string]int{}
+		for _, message := range MockBrokerMessages() {
+			var record map[string]interface{}
+			json.Unmarshal([]byte(message.Value), &record)
+			if record[EventNameKey] != QuotaEventName {
+				counts[fmt.Sprint(record["msg"])]++
+			}
+		}
+		if counts["noisy"] != 2 || counts["loud"] != 2 || counts["quiet"] != 1 ||
+			counts["untenanted"] != 1 {
+			t.Errorf("Expected %s records within quota, got %v\n", pkg, counts)
+		}
+		record := summary()
+		if record == nil || record["tenant"] != "noisy" ||
+			record[QuotaDroppedKey] != float64(3) || record[QuotaRateKey] != float64(1) {
+			t.Errorf("Expected %s quota summary, got %v\n", pkg, record)
+		}
+		if n := collector.counts[MetricQuotaExceeded+"map[tenant:noisy]"]; n != 3 {
+			t.Errorf("Expected %s 3 quota exceeded, got %d\n", pkg, n)
+		}
+		if n := collector.counts[MetricQuotaExceeded+"map[tenant:other]"]; n != 1 {
+			t.Errorf("Expected %s 1 quota exceeded over the labelled values, got %d\n", pkg, n)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	clock := NewFixedClock(time.Now())
+	SetClock(clock)
+	defer SetClock(nil)
+
+	records := func() []map[string]interface{} {
+		var records []map[string]interface{}
+		for _, message := range MockBrokerMessages() {
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(message.Value), &record); err != nil {
+				t.Fatalf("Failed to unmarshal record: %s\n", err.Error())
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+	summaries := func() []map[string]interface{} {
+		var summaries []map[string]interface{}
+		for _, record := range records() {
+			if record[EventNameKey] == DedupEventName {
+				summaries = append(summaries, record)
+			}
+		}
+		return summaries
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.DedupWindow = 40 * time.Millisecond
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		for i := 0; i < 5; i++ {
+			log.WithFields(LogFields{"code": "E1"}).Error("storm")
+		}
+		log.WithFields(LogFields{"code": "E2"}).Error("storm")
+
+		clock.Add(time.Minute)
+		deadline := time.Now().Add(2 * time.Second)
+		for len(summaries()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		log.WithFields(LogFields{"code": "E1"}).Error("storm")
+		log.Close()
+
+		counts := map[string]int{}
+		for _, record := range records() {
+			if record[EventNameKey] != DedupEventName {
+				counts[fmt.Sprint(record["code"])]++
+			}
+		}
+		if counts["E1"] != 2 || counts["E2"] != 1 {
+			t.Errorf("Expected %s records not repeated, got %v\n", pkg, counts)
+		}
+		if summaries := summaries(); len(summaries) != 1 ||
+			summaries[0][CEDataKey] != "storm (repeated 4 times)" ||
+			summaries[0][DedupRepeatedKey] != float64(4) ||
+			summaries[0][CESubjectKey] != "error" {
+			t.Errorf("Expected %s repeated summary, got %v\n", pkg, summaries)
+		}
+	}
+
+	// records with the dedup fields are identical, open windows are
+	// summarized on close
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.DedupWindow = time.Hour
+	cfg.KafkaProducerCfg.DedupFields = []string{"code"}
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	log.WithFields(LogFields{"code": "E1"}).Warn("timeout to db-1")
+	log.WithFields(LogFields{"code": "E1"}).Warn("timeout to db-2")
+	log.WithFields(LogFields{"code": "E1"}).Warn("timeout to db-3")
+	log.Close()
+	if records := records(); len(records) != 2 {
+		t.Errorf("Expected record and summary, got %v\n", records)
+	}
+	if summaries := summaries(); len(summaries) != 1 ||
+		summaries[0]["code"] != "E1" || summaries[0][DedupRepeatedKey] != float64(2) ||
+		summaries[0][CEDataKey] != "timeout to db-1 (repeated 2 times)" {
+		t.Errorf("Expected summary on close, got %v\n", summaries)
+	}
+
+	cfg.KafkaProducerCfg.DedupWindow = -time.Second
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid dedup window to fail\n")
+	}
+}
+
+func TestTimeRotation(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	clock := NewFixedClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	SetClock(clock)
+	defer SetClock(nil)
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		clock.Set(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+		dir := t.TempDir()
+		rotated := make(chan string, 1)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join(dir, "app.log")
+		cfg.EnableRotation = true
+		cfg.RotationCfg.Interval = RotateDaily
+		cfg.RotationCfg.OnRotate = func(filename string) {
+			rotated <- filename
+		}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("first day")
+		clock.Set(time.Date(2024, 5, 2, 0, 0, 1, 0, time.UTC))
+		log.Info("second day")
+		log.Close()
+
+		var filename string
+		select {
+		case filename = <-rotated:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %s OnRotate call\n", pkg)
+		}
+		if filename != filepath.Join(dir, "app-2024-05-01.log") {
+			t.Errorf("Expected %s rotated file app-2024-05-01.log, got %s\n",
+				pkg, filename)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", filename, err.Error())
+		}
+		if !strings.Contains(string(data), "first day") ||
+			strings.Contains(string(data), "second day") {
+			t.Errorf("Expected %s first day in rotated file: %s\n", pkg, data)
+		}
+		data, err = ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", cfg.FileLocation, err.Error())
+		}
+		if !strings.Contains(string(data), "second day") ||
+			strings.Contains(string(data), "first day") {
+			t.Errorf("Expected %s second day in log file: %s\n", pkg, data)
+		}
+	}
+
+	// hourly rotation compressed keeping the latest file
+	dir := t.TempDir()
+	rotated := make(chan string, 3)
+	clock.Set(time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC))
+	writer := rotationLogger(filepath.Join(dir, "app.log"),
+		RotationConfiguration{Interval: RotateHourly, Compress: true,
+			MaxBackups: 1, OnRotate: func(filename string) {
+				rotated <- filename
+			}})
+	for hour := 0; hour < 3; hour++ {
+		fmt.Fprintf(writer, "hour %d\n", hour)
+		clock.Add(time.Hour)
+	}
+	fmt.Fprintf(writer, "hour 3\n")
+	writer.(io.Closer).Close()
+	for hour := 10; hour < 13; hour++ {
+		select {
+		case filename := <-rotated:
+			expected := filepath.Join(dir,
+				fmt.Sprintf("app-2024-05-01-%d.log.gz", hour))
+			if filename != expected {
+				t.Errorf("Expected rotated file %s, got %s\n", expected,
+					filename)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected OnRotate call of hour %d\n", hour)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "app-*"))
+	if len(files) != 1 ||
+		files[0] != filepath.Join(dir, "app-2024-05-01-12.log.gz") {
+		t.Errorf("Expected latest rotated file kept, got %v\n", files)
+	}
+
+	// an idle file is rotated by the timer once its period ends
+	dir = t.TempDir()
+	writer = rotationLogger(filepath.Join(dir, "app.log"),
+		RotationConfiguration{Interval: RotateHourly})
+	fmt.Fprintf(writer, "idle\n")
+	clock.Add(time.Hour)
+	writer.(*rotationWriter).rotateIdle()
+	writer.(io.Closer).Close()
+	if !fileExists(filepath.Join(dir, "app-2024-05-01-13.log")) {
+		t.Errorf("Expected idle file rotated\n")
+	}
+
+	// size rotation by lumberjack
+	dir = t.TempDir()
+	writer = rotationLogger(filepath.Join(dir, "app.log"),
+		RotationConfiguration{MaxSize: 1, OnRotate: func(filename string) {
+			rotated <- filename
+		}})
+	line := strings.Repeat("x", 600*1024) + "\n"
+	io.WriteString(writer, line)
+	io.WriteString(writer, line)
+	writer.(io.Closer).Close()
+	select {
+	case filename := <-rotated:
+		name := filepath.Base(filename)
+		if filepath.Dir(filename) != dir || !strings.HasPrefix(name, "app-") ||
+			!strings.HasSuffix(name, ".log") {
+			t.Errorf("Expected lumberjack rotated file, got %s\n", filename)
+		} else if info, err := os.Stat(filename); err != nil ||
+			info.Size() != int64(len(line)) {
+			t.Errorf("Expected first write in rotated file %s\n", filename)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected OnRotate call of size rotation\n")
+	}
+
+	for _, invalid := range []RotationConfiguration{
+		{Interval: "weekly"},
+		{Interval: RotateDaily, FileTemplate: "{name}{ext}"},
+		{Interval: RotateDaily, FileTemplate: "old/{name}-{date}{ext}"},
+	} {
+		errCount := 0
+		checkRotationConfig(invalid, &errCount)
+		if errCount == 0 {
+			t.Errorf("Invalid rotation config passed: %+v\n", invalid)
+		}
+	}
+}
+
+func TestFileSinks(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		dir := t.TempDir()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.LogLevel = InfoType
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join(dir, "app.log")
+		cfg.Files = []FileSinkConfiguration{
+			{Location: filepath.Join(dir, "errors.log"), Format: JSONFormat,
+				Level: WarnType},
+			{Location: filepath.Join(dir, "debug.log"), Format: TextFormat,
+				Level: DebugType, EnableRotation: true,
+				RotationCfg: RotationConfiguration{MaxSize: 1}},
+		}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Debug("debug record")
+		log.Info("info record")
+		log.Warn("warn record")
+		log.Close()
+
+		expected := map[string][]string{
+			"app.log":    {"info record", "warn record"},
+			"errors.log": {"warn record"},
+			"debug.log":  {"debug record", "info record", "warn record"},
+		}
+		for name, messages := range expected {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %s\n", name, err.Error())
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != len(messages) {
+				t.Fatalf("Expected %d %s records in %s, got %d\n",
+					len(messages), pkg, name, len(lines))
+			}
+			for i, message := range messages {
+				if !strings.Contains(lines[i], message) {
+					t.Errorf("Expected %s %s in %s, got %s\n", pkg, message,
+						name, lines[i])
+				}
+			}
+			isJSON := json.Valid([]byte(lines[0]))
+			if isJSON != (name != "debug.log") {
+				t.Errorf("Expected %s %s format, got %s\n", pkg, name,
+					lines[0])
+			}
+		}
+
+		// files opened before a later entry fails are closed
+		opened := filepath.Join(dir, "opened.log")
+		cfg.EnableFile = false
+		cfg.Files = []FileSinkConfiguration{{Location: opened},
+			{Location: filepath.Join(dir, "missing", "failed.log")}}
+		if _, err := NewLogger(cfg); err == nil {
+			t.Fatalf("Expected %s logger with missing directory to fail\n", pkg)
+		}
+		fds, _ := ioutil.ReadDir("/proc/self/fd")
+		for _, fd := range fds {
+			if target, _ := os.Readlink(filepath.Join("/proc/self/fd",
+				fd.Name())); target == opened {
+				t.Errorf("Expected %s %s closed\n", pkg, opened)
+			}
+		}
+	}
+
+	for _, invalid := range [][]FileSinkConfiguration{
+		{{Format: JSONFormat}},
+		{{Location: "pavedroad.log"}},
+		{{Location: "a.log"}, {Location: "./a.log"}},
+		{{Location: "a.log", Format: "xml"}},
+		{{Location: "a.log", Format: CEFormat}},
+		{{Location: "a.log", Level: "verbose"}},
+		{{Location: "a.log", EnableRotation: true,
+			RotationCfg: RotationConfiguration{MaxSize: -1}}},
+	} {
+		cfg := *DefaultCompleteCfg()
+		cfg.EnableKafka = false
+		cfg.EnableCloudEvents = false
+		cfg.Files = invalid
+		if err := checkConfig(cfg); err == nil {
+			t.Errorf("Invalid Files config passed: %+v\n", invalid)
+		}
+	}
+}
+
+func TestPrettyConsole(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	console := getConsoleWriter(Stdout).(*lockedWriter)
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		for _, color := range []bool{false, true} {
+			ResetMockBroker()
+			out := &chunkWriter{}
+			console.mutex.Lock()
+			stdout := console.out
+			console.out = out
+			console.mutex.Unlock()
+
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableTimeStamps = false
+			cfg.EnableColorLevels = color
+			cfg.EnableFile = false
+			cfg.EnableConsole = true
+			cfg.ConsoleFormat = CEFormat
+			cfg.PrettyConsole = true
+			cfg.EnableKafka = true
+			cfg.KafkaProducerCfg.EnableMock = true
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			log.WithFields(LogFields{"payload": `{"a":1}`, "user": "u1"}).
+				Warn("hello")
+			log.Close()
+
+			console.mutex.Lock()
+			console.out = stdout
+			console.mutex.Unlock()
+
+			expected := "WARN  hello\n" +
+				"    payload:\n        {\n          \"a\": 1\n        }\n" +
+				"    user: u1\n"
+			if color {
+				expected = ansiYellow + "WARN " + ansiReset + " hello\n" +
+					"    " + ansiCyan + "payload" + ansiReset +
+					":\n        {\n          \"a\": 1\n        }\n" +
+					"    " + ansiCyan + "user" + ansiReset + ": u1\n"
+			}
+			if got := out.buf.String(); got != expected {
+				t.Errorf("Expected %s pretty console:\n%q\ngot:\n%q\n", pkg,
+					expected, got)
+			}
+
+			messages := MockBrokerMessages()
+			if len(messages) != 1 || !json.Valid([]byte(messages[0].Value)) {
+				t.Errorf("Expected %s kafka JSON record, got %v\n", pkg, messages)
+			}
+		}
+	}
+}
+
+func TestFileCompression(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		for _, compression := range []compressionType{CompressionGZIP,
+			CompressionZSTD} {
+			location := filepath.Join(t.TempDir(), "compressed.log")
+			cfg := DefaultLoggerCfg()
+			cfg.LogPackage = pkg
+			cfg.FileFormat = JSONFormat
+			cfg.FileLocation = location
+			cfg.FileCompression = compression
+			file := cfg.fileLocation()
+			if file != location+compressionExtensions[compression] {
+				t.Fatalf("Unexpected %s file location: %s\n", compression, file)
+			}
+
+			// appending to the file adds a gzip member or zstd frame
+			for _, msg := range []string{"first", "second"} {
+				log, err := NewLogger(cfg)
+				if err != nil {
+					t.Fatalf("Failed to instantiate %s logger: %s\n", pkg,
+						err.Error())
+				}
+				log.Info(msg)
+				if err := log.Flush(time.Second); err != nil {
+					t.Fatalf("Failed to flush %s logger: %s\n", pkg, err.Error())
+				}
+				log.Close()
+			}
+
+			compressed, err := os.Open(file)
+			if err != nil {
+				t.Fatalf("Failed to open %s: %s\n", file, err.Error())
+			}
+			var reader io.Reader
+			if compression == CompressionGZIP {
+				reader, err = gzip.NewReader(compressed)
+			} else {
+				var decoder *zstd.Decoder
+				decoder, err = zstd.NewReader(compressed)
+				if err == nil {
+					defer decoder.Close()
+				}
+				reader = decoder
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %s %s: %s\n", compression, file,
+					err.Error())
+			}
+			data, err := ioutil.ReadAll(reader)
+			compressed.Close()
+			if err != nil {
+				t.Fatalf("Failed to decompress %s %s: %s\n", pkg, compression,
+					err.Error())
+			}
+			var messages []string
+			for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+				var record map[string]interface{}
+				if err := json.Unmarshal(line, &record); err != nil {
+					t.Fatalf("Failed to unmarshal %s record: %s\n", pkg,
+						err.Error())
+				}
+				messages = append(messages, fmt.Sprint(record["msg"]))
+			}
+			if !reflect.DeepEqual(messages, []string{"first", "second"}) {
+				t.Errorf("Unexpected %s %s messages: %v\n", pkg, compression,
+					messages)
+			}
+		}
+	}
+
+	// records are flushed periodically so they can be read while written
+	cfg := DefaultLoggerCfg()
+	cfg.FileLocation = filepath.Join(t.TempDir(), "periodic.log")
+	cfg.FileCompression = CompressionGZIP
+	out, err := cfg.openLogFile()
+	if err != nil {
+		t.Fatalf("Failed to open compressed file: %s\n", err.Error())
+	}
+	defer out.(io.Closer).Close()
+	out.Write([]byte("periodic\n"))
+	if err := out.(*compressWriter).flushWritten(); err != nil {
+		t.Fatalf("Failed to flush compressed file: %s\n", err.Error())
+	}
+	data, err := ioutil.ReadFile(cfg.fileLocation())
+	if err != nil {
+		t.Fatalf("Failed to read compressed file: %s\n", err.Error())
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read gzip file: %s\n", err.Error())
+	}
+	line, _ := bufio.NewReader(reader).ReadString('\n')
+	if line != "periodic\n" {
+		t.Errorf("Expected flushed record, got %q\n", line)
+	}
+}
+
+func TestAWSSinks(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	type request struct {
+		target string
+		auth   string
+		token  string
+		body   map[string]interface{}
+	}
+	var mutex sync.Mutex
+	var requests []request
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			mutex.Lock()
+			requests = append(requests, request{r.Header.Get("X-Amz-Target"),
+				auth, r.Header.Get("X-Amz-Security-Token"), body})
+			first := len(requests) == 1
+			mutex.Unlock()
+			switch r.Header.Get("X-Amz-Target") {
+			case "Kinesis_20131202.PutRecords":
+				if first {
+					// second record throttled
+					fmt.Fprint(w, `{"FailedRecordCount":1,"Records":[`+
+						`{"SequenceNumber":"1","ShardId":"s"},`+
+						`{"ErrorCode":"ProvisionedThroughputExceededException"}]}`)
+					return
+				}
+				fmt.Fprint(w, `{"FailedRecordCount":0}`)
+			case "AmazonSQS.GetQueueUrl":
+				fmt.Fprint(w, `{"QueueUrl":"https://sqs/queue/logs.fifo"}`)
+			case "AmazonSQS.SendMessageBatch":
+				fmt.Fprint(w, `{"Successful":[]}`)
+			}
+		}))
+	defer server.Close()
+	credentials := AWSCredentialsConfiguration{AccessKeyID: "AKID",
+		SecretAccessKey: "secret"}
+
+	for _, sink := range []string{KinesisSinkType, SQSSinkType} {
+		for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+			mutex.Lock()
+			requests = nil
+			mutex.Unlock()
+
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableConsole = false
+			cfg.EnableFile = false
+			cfg.EnableKafka = false
+			if sink == KinesisSinkType {
+				cfg.EnableKinesis = true
+				cfg.KinesisCfg.StreamName = "logs"
+				cfg.KinesisCfg.Region = "us-east-1"
+				cfg.KinesisCfg.Endpoint = server.URL
+				cfg.KinesisCfg.Credentials = credentials
+				cfg.KinesisCfg.FlushInterval = time.Hour
+				cfg.KinesisCfg.RetryFreq = time.Millisecond
+			} else {
+				cfg.EnableSQS = true
+				cfg.SQSCfg.QueueName = "logs.fifo"
+				cfg.SQSCfg.Region = "us-east-1"
+				cfg.SQSCfg.Endpoint = server.URL
+				cfg.SQSCfg.Credentials = credentials
+				cfg.SQSCfg.Key = ExtractedKey
+				cfg.SQSCfg.KeyName = "tenant"
+				cfg.SQSCfg.FlushInterval = time.Hour
+			}
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			log.WithFields(LogFields{"tenant": "t1"}).Info("first")
+			log.Warn("second")
+			if err := log.Flush(5 * time.Second); err != nil {
+				t.Fatalf("Failed to flush %s %s sink: %s\n", pkg, sink,
+					err.Error())
+			}
+			log.Close()
+
+			mutex.Lock()
+			sent := requests
+			mutex.Unlock()
+			if len(sent) != 2 {
+				t.Fatalf("Expected 2 %s %s requests, got %d\n", pkg, sink,
+					len(sent))
+			}
+			if sink == KinesisSinkType {
+				if records := sent[0].body["Records"].([]interface{}); len(records) != 2 ||
+					records[1].(map[string]interface{})["PartitionKey"] != string(WarnType) {
+					t.Errorf("Unexpected %s kinesis records: %v\n", pkg, records)
+				}
+				// only the throttled record is put again
+				records := sent[1].body["Records"].([]interface{})
+				if len(records) != 1 || sent[1].body["StreamName"] != "logs" {
+					t.Fatalf("Unexpected %s kinesis retry: %v\n", pkg, sent[1].body)
+				}
+				data, _ := base64.StdEncoding.DecodeString(
+					records[0].(map[string]interface{})["Data"].(string))
+				if !strings.Contains(string(data), "second") {
+					t.Errorf("Expected %s second record, got %s\n", pkg, data)
+				}
+				continue
+			}
+			if sent[0].target != "AmazonSQS.GetQueueUrl" ||
+				sent[1].body["QueueUrl"] != "https://sqs/queue/logs.fifo" {
+				t.Fatalf("Unexpected %s sqs requests: %v\n", pkg, sent)
+			}
+			entries := sent[1].body["Entries"].([]interface{})
+			if len(entries) != 2 {
+				t.Fatalf("Expected 2 %s sqs entries, got %d\n", pkg, len(entries))
+			}
+			first := entries[0].(map[string]interface{})
+			second := entries[1].(map[string]interface{})
+			if first["MessageGroupId"] != "t1" ||
+				second["MessageGroupId"] != string(WarnType) ||
+				first["MessageDeduplicationId"] == "" ||
+				!strings.Contains(first["MessageBody"].(string), "first") {
+				t.Errorf("Unexpected %s sqs entries: %v\n", pkg, entries)
+			}
+		}
+	}
+
+	// web identity credentials are assumed with the token file
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("web-token"), 0600); err != nil {
+		t.Fatalf("Failed to write token file: %s\n", err.Error())
+	}
+	sts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" ||
+				r.Form.Get("WebIdentityToken") != "web-token" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse>`+
+				`<AssumeRoleWithWebIdentityResult><Credentials>`+
+				`<AccessKeyId>AKIDWEB</AccessKeyId>`+
+				`<SecretAccessKey>websecret</SecretAccessKey>`+
+				`<SessionToken>websession</SessionToken>`+
+				`<Expiration>2100-01-01T00:00:00Z</Expiration>`+
+				`</Credentials></AssumeRoleWithWebIdentityResult>`+
+				`</AssumeRoleWithWebIdentityResponse>`)
+		}))
+	defer sts.Close()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/logger")
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	client, err := newAWSClient("sqs", "us-east-1", server.URL,
+		"application/x-amz-json-1.0", AWSCredentialsConfiguration{}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create web identity client: %s\n", err.Error())
+	}
+	mutex.Lock()
+	requests = nil
+	mutex.Unlock()
+	if err := client.call("AmazonSQS.GetQueueUrl",
+		map[string]string{"QueueName": "logs"}, nil); err != nil {
+		t.Fatalf("Web identity call failed: %s\n", err.Error())
+	}
+	mutex.Lock()
+	sent := requests
+	mutex.Unlock()
+	if len(sent) != 1 ||
+		!strings.HasPrefix(sent[0].auth, "AWS4-HMAC-SHA256 Credential=AKIDWEB/") ||
+		sent[0].token != "websession" {
+		t.Errorf("Unexpected web identity requests: %+v\n", sent)
+	}
+}
+
+func TestOfflineJournal(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	defer ResetMockBroker()
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		dir := t.TempDir()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.SpoolDir = dir
+		cfg.KafkaProducerCfg.EnableOffline = true
+		cfg.KafkaProducerCfg.DeliveryRetryMax = 0
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("first")
+		log.Info("second")
+		if err := log.Flush(time.Second); err != nil {
+			t.Fatalf("Failed to flush %s offline logger: %s\n", pkg, err.Error())
+		}
+		log.Close()
+		if messages := MockBrokerMessages(); len(messages) != 0 {
+			t.Fatalf("Unexpected %s messages while offline: %v\n", pkg, messages)
+		}
+		entries, _ := ioutil.ReadDir(dir)
+		suffix := fmt.Sprintf("-%d%s", os.Getpid(), spoolSuffix)
+		if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), suffix) {
+			t.Fatalf("Expected %s journal segment, got %v\n", pkg, entries)
+		}
+
+		// a segment with failed messages is kept for the next upload
+		MockBrokerFail(1)
+		err = UploadJournal(context.Background(), cfg.KafkaProducerCfg)
+		if err != ErrMockDelivery {
+			t.Fatalf("Expected %s upload delivery failure, got %v\n", pkg, err)
+		}
+		ResetMockBroker()
+		if err := UploadJournal(context.Background(),
+			cfg.KafkaProducerCfg); err != nil {
+			t.Fatalf("Failed to upload %s journal: %s\n", pkg, err.Error())
+		}
+		messages := MockBrokerMessages()
+		if len(messages) != 2 || !strings.Contains(messages[0].Value, "first") ||
+			!strings.Contains(messages[1].Value, "second") {
+			t.Errorf("Unexpected %s uploaded messages: %v\n", pkg, messages)
+		}
+		if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected %s journal removed, got %d segments\n", pkg,
+				len(entries))
+		}
+
+		// segments of a running process are still journaled so skipped
+		record, _ := encodeRecord(&sarama.ProducerMessage{Topic: "logs",
+			Value: sarama.StringEncoder("live")})
+		live := filepath.Join(dir, fmt.Sprintf("%s%020d-%d%s", spoolPrefix, 0,
+			os.Getppid(), spoolSuffix))
+		if err := ioutil.WriteFile(live, record, 0644); err != nil {
+			t.Fatalf("Failed to write %s segment: %s\n", pkg, err.Error())
+		}
+		ResetMockBroker()
+		if err := UploadJournal(context.Background(),
+			cfg.KafkaProducerCfg); err != nil {
+			t.Fatalf("Failed to upload %s journal: %s\n", pkg, err.Error())
+		}
+		if messages := MockBrokerMessages(); len(messages) != 0 {
+			t.Errorf("Unexpected %s live segment upload: %v\n", pkg, messages)
+		}
+		if _, err := os.Stat(live); err != nil {
+			t.Errorf("Expected %s live segment kept: %s\n", pkg, err.Error())
+		}
+	}
+	if err := UploadJournal(context.Background(),
+		DefaultProducerCfg()); err != ErrJournalNotSet {
+		t.Errorf("Expected journal not set error, got %v\n", err)
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	defer ResetMockBroker()
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		hashes := []interface{}{}
+		for _, c := range []struct {
+			enable bool
+			level  LevelType
+		}{{true, InfoType}, {true, InfoType}, {true, DebugType}, {false, InfoType}} {
+			ResetMockBroker()
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.LogLevel = c.level
+			cfg.EnableFile = false
+			cfg.EnableKafka = true
+			cfg.KafkaFormat = JSONFormat
+			cfg.KafkaProducerCfg.EnableMock = true
+			cfg.EnableConfigHash = c.enable
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			log.Info("hashed")
+			log.Close()
+			messages := MockBrokerMessages()
+			if len(messages) != 1 {
+				t.Fatalf("Expected 1 %s message, got %d\n", pkg, len(messages))
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(messages[0].Value), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			hashes = append(hashes, record[ConfigHashFieldKey])
+		}
+		if hash, ok := hashes[0].(string); !ok || len(hash) != configHashLength {
+			t.Fatalf("Expected %s %s field, got %v\n", pkg, ConfigHashFieldKey,
+				hashes[0])
+		}
+		if hashes[0] != hashes[1] || hashes[0] == hashes[2] || hashes[3] != nil {
+			t.Errorf("Unexpected %s configuration hashes: %v\n", pkg, hashes)
+		}
+	}
+}
+
+func TestSampledFields(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	url := "https://example.com/orders/12345?session=abc"
+	hashed := hashValue(url)
+	if !strings.HasPrefix(hashed, SampledHashPrefix) || hashed != hashValue(url) {
+		t.Errorf("Expected stable hash of %s, got %s\n", url, hashed)
+	}
+	buckets := map[string]bool{}
+	for i := 0; i < 10*SampledHashBuckets; i++ {
+		buckets[hashValue(fmt.Sprintf("%s&id=%d", url, i))] = true
+	}
+	if len(buckets) > SampledHashBuckets {
+		t.Errorf("Expected at most %d hashed values, got %d\n",
+			SampledHashBuckets, len(buckets))
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join("testdata", "sampled.log")
+		os.Remove(cfg.FileLocation)
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		fields := LogFields{"url": url}
+		log.WithSampledFields(1, fields).Info("kept")
+		log.WithSampledFields(0, fields).Info("hashed")
+		sampled := log.WithSampledFields(0.5, fields)
+		for i := 0; i < 100; i++ {
+			sampled.Info("sampled")
+		}
+		log.Close()
+
+		actual, err := ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read file %s: %s\n",
+				cfg.FileLocation, err.Error())
+		}
+		counts := map[string]int{}
+		for _, line := range bytes.Split(bytes.TrimSpace(actual), []byte("\n")) {
+			var record map[string]interface{}
+			if err := json.Unmarshal(line, &record); err != nil {
+				t.Fatalf("Failed to decode %s record %s: %s\n", pkg, line,
+					err.Error())
+			}
+			value, _ := record["url"].(string)
+			if value != url && value != hashed {
+				t.Errorf("Expected %s url or its hash, got %s\n", pkg, line)
+			}
+			counts[record["msg"].(string)+" "+value]++
+		}
+		if counts["kept "+url] != 1 || counts["hashed "+hashed] != 1 ||
+			counts["sampled "+url] == 0 || counts["sampled "+hashed] == 0 {
+			t.Errorf("Expected %s kept, hashed and sampled urls, got %v\n",
+				pkg, counts)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	policy := RetryPolicy{MaxAttempts: 4, Backoff: 10 * time.Millisecond,
+		MaxBackoff: 25 * time.Millisecond}
+	for retry, expected := range []time.Duration{10 * time.Millisecond,
+		20 * time.Millisecond, 25 * time.Millisecond, 25 * time.Millisecond} {
+		if delay := policy.Delay(retry); delay != expected {
+			t.Errorf("Expected retry %d delay %s, got %s\n", retry, expected,
+				delay)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(1); delay < 10*time.Millisecond ||
+			delay > 20*time.Millisecond {
+			t.Fatalf("Expected jittered delay within half, got %s\n", delay)
+		}
+	}
+	if (RetryPolicy{Backoff: time.Second}).Delay(100) <= 0 {
+		t.Errorf("Expected uncapped delay not to overflow\n")
+	}
+
+	permanent := errors.New("permanent")
+	policy.Retryable = func(err error) bool { return err != permanent }
+	if !policy.ShouldRetry(2, io.EOF) || policy.ShouldRetry(3, io.EOF) ||
+		policy.ShouldRetry(0, permanent) || policy.ShouldRetry(0, nil) {
+		t.Errorf("Expected 3 retries of retryable errors\n")
+	}
+	legacy := RetryPolicy{}.resolve(2, time.Millisecond, nil)
+	if legacy.Retries() != 2 || legacy.Delay(1) != 2*time.Millisecond {
+		t.Errorf("Expected legacy settings used, got %+v\n", legacy)
+	}
+	partial := RetryPolicy{Backoff: 5 * time.Millisecond}.resolve(2,
+		time.Millisecond, nil)
+	if partial.Retries() != 2 || partial.Delay(0) != 5*time.Millisecond {
+		t.Errorf("Expected legacy attempts with set backoff, got %+v\n", partial)
+	}
+	partial = RetryPolicy{MaxAttempts: 4}.resolve(2, time.Millisecond, nil)
+	if partial.Retries() != 3 || partial.Delay(0) != time.Millisecond {
+		t.Errorf("Expected legacy backoff with set attempts, got %+v\n", partial)
+	}
+	calls := 0
+	policy.Jitter = 0
+	policy.Backoff = time.Millisecond
+	if err := policy.do(func() error {
+		calls++
+		return io.EOF
+	}); err != io.EOF || calls != 4 {
+		t.Errorf("Expected 4 attempts failing, got %d %v\n", calls, err)
+	}
+
+	// a classifier overriding the default retries rejected requests
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+	defer server.Close()
+	for _, retryable := range []func(error) bool{nil,
+		func(error) bool { return true }} {
+		mutex.Lock()
+		requests = 0
+		mutex.Unlock()
+		cfg := *DefaultCompleteCfg()
+		cfg.EnableConsole = false
+		cfg.EnableFile = false
+		cfg.EnableKafka = false
+		cfg.EnableHTTP = true
+		cfg.HTTPCfg.URL = server.URL
+		cfg.HTTPCfg.FlushInterval = time.Hour
+		cfg.HTTPCfg.RetryPolicy = RetryPolicy{MaxAttempts: 3,
+			Backoff: time.Millisecond, Retryable: retryable}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+		}
+		log.Info("rejected")
+		log.Close()
+		expected := 1
+		if retryable != nil {
+			expected = 3
+		}
+		mutex.Lock()
+		if requests != expected {
+			t.Errorf("Expected %d requests, got %d\n", expected, requests)
+		}
+		mutex.Unlock()
+	}
+
+	t.Setenv(RetryEnvPrefix+"_"+RetryHTTP,
+		"attempts=5, backoff=200ms,maxbackoff=2s,jitter=0.2")
+	t.Setenv(RetryEnvPrefix+"_"+RetryDelivery, "attempts=2")
+	t.Setenv(RetryEnvPrefix+"_"+RetrySyslog, "attempts=3")
+	cfg, err := GetLoggerConfiguration(EnvConfig, "")
+	if err != nil {
+		t.Fatalf("Failed to get configuration: %s\n", err.Error())
+	}
+	if http := cfg.HTTPCfg.RetryPolicy; http.MaxAttempts != 5 ||
+		http.Backoff != 200*time.Millisecond || http.MaxBackoff != 2*time.Second ||
+		http.Jitter != 0.2 || cfg.KafkaProducerCfg.DeliveryRetryPolicy.MaxAttempts != 2 ||
+		cfg.SyslogCfg.RetryPolicy.MaxAttempts != 3 {
+		t.Errorf("Expected retry policies from environment, got %+v %+v\n",
+			cfg.HTTPCfg.RetryPolicy, cfg.KafkaProducerCfg.DeliveryRetryPolicy)
+	}
+	t.Setenv(RetryEnvPrefix+"_"+RetryFile, "attempts=many")
+	if _, err := GetLoggerConfiguration(EnvConfig, ""); err == nil {
+		t.Errorf("Expected invalid retry environment to fail\n")
+	}
+
+	cfg = *DefaultCompleteCfg()
+	cfg.FileRetryPolicy.Jitter = 2
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid retry policy to fail\n")
+	}
+
+	// a producer classifier retries failed messages in the logger
+	kp := &KafkaProducer{prodRetry: RetryPolicy{MaxAttempts: 2,
+		Retryable: func(err error) bool { return err != permanent }},
+		done: make(chan struct{})}
+	msg := &sarama.ProducerMessage{Metadata: messageMeta{}}
+	if kp.retry(&sarama.ProducerError{Msg: msg, Err: permanent}) {
+		t.Errorf("Expected permanent producer error not retried\n")
+	}
+	kp.prodRetry.Backoff = time.Hour
+	if !kp.retry(&sarama.ProducerError{Msg: msg, Err: io.EOF}) {
+		t.Errorf("Expected retryable producer error retried\n")
+	}
+	close(kp.done)
+	kp.retries.Wait()
+}
+
+func TestTopicNaming(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	naming := TopicNamingConfiguration{
+		Template:    "{env}.{service}.{name}",
+		Environment: "prod",
+		Service:     "billing",
+		Charset:     "a-z0-9.-",
+		MaxLength:   32,
+	}
+	namer, err := NewTopicNamer(naming)
+	if err != nil {
+		t.Fatalf("Failed to instantiate topic namer: %s\n", err.Error())
+	}
+	if topic, err := namer.Name("orders"); err != nil ||
+		topic != "prod.billing.orders" {
+		t.Errorf("Expected prod.billing.orders, got %s %v\n", topic, err)
+	}
+	for _, name := range []string{"", "Orders", "orders_v2",
+		"a-very-long-name-over-the-limit"} {
+		if _, err := namer.Name(name); !errors.Is(err, ErrInvalidTopic) {
+			t.Errorf("Expected invalid topic %q, got %v\n", name, err)
+		}
+	}
+	if err := namer.Validate("dev.billing.orders"); !errors.Is(err,
+		ErrInvalidTopic) {
+		t.Errorf("Expected topic without prefix invalid, got %v\n", err)
+	}
+	for _, invalid := range []TopicNamingConfiguration{
+		{Template: "{env}.logs"}, {MaxLength: 300}, {Charset: "z-a"},
+	} {
+		if _, err := NewTopicNamer(invalid); err == nil {
+			t.Errorf("Expected invalid naming %+v to fail\n", invalid)
+		}
+	}
+
+	ResetMockBroker()
+	senderCfg := DefaultProducerCfg()
+	senderCfg.EnableMock = true
+	senderCfg.TopicNaming = naming
+	sender, err := NewSender(senderCfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate sender: %s\n", err.Error())
+	}
+	if err := sender.Send("orders", "", []byte("named")); err != nil {
+		t.Errorf("Send failed: %s\n", err.Error())
+	}
+	if err := sender.Send("", "", []byte("default")); err != nil {
+		t.Errorf("Send failed: %s\n", err.Error())
+	}
+	if err := sender.Send("Orders", "", []byte("invalid")); !errors.Is(err,
+		ErrInvalidTopic) {
+		t.Errorf("Expected invalid topic error, got %v\n", err)
+	}
+	sender.Close()
+	messages := MockBrokerMessages()
+	if len(messages) != 2 || messages[0].Topic != "prod.billing.orders" ||
+		messages[1].Topic != "prod.billing.logs" {
+		t.Errorf("Expected named sender topics, got %+v\n", messages)
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.TopicNaming = naming
+		cfg.KafkaProducerCfg.RouteRules = []RouteRule{
+			{Level: ErrorType, Topic: "errors"},
+		}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("default")
+		log.Error("failed")
+		log.WithTopic("audit").Info("audited")
+		log.WithFields(LogFields{TopicKey: "explicit"}).Info("explicit")
+		log.WithTopic("Invalid").Info("dropped")
+		log.Close()
+
+		expected := []string{"prod.billing.logs", "prod.billing.errors",
+			"prod.billing.audit", "prod.billing.explicit"}
+		messages := MockBrokerMessages()
+		if len(messages) != len(expected) {
+			t.Fatalf("Expected %d %s messages, got %+v\n",
+				len(expected), pkg, messages)
+		}
+		for i, msg := range messages {
+			if msg.Topic != expected[i] {
+				t.Errorf("Expected %s topic %s, got %s\n",
+					pkg, expected[i], msg.Topic)
+			}
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.TopicNaming = naming
+	cfg.KafkaProducerCfg.DeadLetterTopic = "Dead_Letters"
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid dead letter topic to fail\n")
+	}
+}
+
+func TestSenderIdempotent(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	errCaptured := errors.New("captured")
+	var captured *sarama.Config
+	SetProducerFactory(func(config *sarama.Config) (sarama.AsyncProducer,
+		error) {
+		captured = config
+		return nil, errCaptured
+	})
+	defer SetProducerFactory(nil)
+
+	cfg := DefaultProducerCfg()
+	cfg.EnableIdempotent = true
+	if _, err := NewSender(cfg); err != errCaptured {
+		t.Fatalf("Expected producer factory call, got %v\n", err)
+	}
+	if !captured.Producer.Idempotent ||
+		captured.Producer.RequiredAcks != sarama.WaitForAll ||
+		captured.Net.MaxOpenRequests != 1 ||
+		!captured.Version.IsAtLeast(sarama.V0_11_0_0) {
+		t.Errorf("Expected idempotent producer config: %+v\n",
+			captured.Producer)
+	}
+	if err := captured.Validate(); err != nil {
+		t.Errorf("Invalid idempotent producer config: %s\n", err.Error())
+	}
+
+	errCount := 0
+	checkProducerConfig(cfg, &errCount)
+	transactional := cfg
+	transactional.TransactionalID = "audit"
+	checkProducerConfig(transactional, &errCount)
+	if errCount != 1 {
+		t.Errorf("Expected TransactionalID unsupported, got %d errors\n",
+			errCount)
+	}
+
+	for _, invalid := range []ProducerConfiguration{
+		{EnableIdempotent: true, AckWait: WaitForNone, ProdRetryMax: 1},
+		{EnableIdempotent: true, ProdRetryMax: 0},
+		{EnableIdempotent: true, ProdRetryMax: 1,
+			ProdRetryPolicy: RetryPolicy{MaxAttempts: 1}},
+		{EnableIdempotent: true, ProdRetryMax: 1, KafkaVersion: "0.10.2.0"},
+	} {
+		errCount := 0
+		checkProducerConfig(invalid, &errCount)
+		if errCount == 0 {
+			t.Errorf("Invalid idempotent config passed: %+v\n", invalid)
+		}
+	}
+}
+
+func TestIdempotentNotResent(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	defer ResetMockBroker()
+	dir := t.TempDir()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.EnableIdempotent = true
+	cfg.AckWait = WaitForAll
+	cfg.DeliveryRetryMax = 2
+	cfg.DeliveryRetryFreq = time.Millisecond
+	cfg.SpoolDir = dir
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %s\n", err.Error())
+	}
+	defer sender.Close()
+
+	var mutex sync.Mutex
+	var errs []error
+	callback := func(partition, offset int64, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	}
+
+	// failed messages are neither retried nor spooled as they may have
+	// been delivered
+	MockBrokerFail(1)
+	sender.SendWithCallback("", "", []byte("failed"), callback)
+	sender.Flush(time.Second)
+	MockBrokerDown(true)
+	sender.SendWithCallback("", "", []byte("unavailable"), callback)
+	sender.Flush(time.Second)
+	MockBrokerDown(false)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(errs) != 2 || errs[0] != ErrMockDelivery ||
+		errs[1] != sarama.ErrOutOfBrokers {
+		t.Errorf("Expected delivery failures, got %v\n", errs)
+	}
+	if messages := MockBrokerMessages(); len(messages) != 0 {
+		t.Errorf("Unexpected messages resent: %v\n", messages)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Unexpected spooled messages: %d segments\n", len(entries))
+	}
+}
+
+func TestSenderEvent(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	fixed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	SetClock(NewFixedClock(fixed))
+	defer SetClock(nil)
+	ResetMockBroker()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate sender: %s\n", err.Error())
+	}
+
+	type order struct {
+		OrderID string `json:"order_id"`
+	}
+	event := Event{
+		Type:       "io.pavedroad.order.created",
+		Subject:    "o-1",
+		Extensions: map[string]string{"tenant": "acme"},
+		Data:       order{OrderID: "o-1"},
+	}
+	if err := sender.SendEvent("orders", "o-1", event); err != nil {
+		t.Fatalf("SendEvent failed: %s\n", err.Error())
+	}
+	if err := sender.SendEvent("", "", Event{}); err == nil {
+		t.Errorf("Expected error for event without type\n")
+	}
+	event.Extensions = map[string]string{"id": "x"}
+	if err := sender.SendEvent("", "", event); err == nil {
+		t.Errorf("Expected error for invalid extension\n")
+	}
+	sender.Close()
+
+	messages := MockBrokerMessages()
+	if len(messages) != 1 || messages[0].Topic != "orders" ||
+		messages[0].Key != "o-1" {
+		t.Fatalf("Expected event message, got %v\n", messages)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(messages[0].Value), &record); err != nil {
+		t.Fatalf("Failed to unmarshal event: %s\n", err.Error())
+	}
+	if id, _ := record[CEIDKey].(string); id == "" {
+		t.Errorf("Expected event id, got %v\n", record)
+	}
+	expected := map[string]interface{}{
+		CESourceKey:       defaultCloudEventsConfiguration.Source,
+		CESpecVersionKey:  "1.0",
+		CETypeKey:         "io.pavedroad.order.created",
+		CESubjectKey:      "o-1",
+		CETimeKey:         fixed.Format(time.RFC3339),
+		CEDataContentType: CEJSONContentType,
+		"tenant":          "acme",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Expected event %s %v, got %v\n", key, value, record[key])
+		}
+	}
+	if data, _ := record[CEDataKey].(map[string]interface{}); data == nil ||
+		data["order_id"] != "o-1" {
+		t.Errorf("Expected event data, got %v\n", record[CEDataKey])
+	}
+}
+
+func TestSenderMetadata(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate sender: %s\n", err.Error())
+	}
+	defer sender.Close()
 
-var (
-	testenv     bool
-	testinit    bool
-	testshort   bool
-	testverbose bool
-)
+	if count, err := sender.PartitionCount("events"); err != nil || count != 1 {
+		t.Errorf("Unexpected partition count: %d %v\n", count, err)
+	}
+	if brokers := sender.Brokers(); len(brokers) != 1 ||
+		brokers[0] != MockBrokerInfo {
+		t.Errorf("Unexpected brokers: %v\n", brokers)
+	}
+	if leader, err := sender.Leader("events", 0); err != nil ||
+		leader != MockBrokerInfo {
+		t.Errorf("Unexpected leader: %v %v\n", leader, err)
+	}
+	if _, err := sender.Leader("events", 1); err == nil {
+		t.Errorf("Expected error for unknown partition\n")
+	}
+}
+
+func TestDumpState(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaLevel = WarnType
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.Key = ExtractedKey
+	cfg.KafkaProducerCfg.KeyName = "user"
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	defer log.Close()
+	log.Warn("missing key")
+
+	var buf bytes.Buffer
+	if err := DumpState(&buf); err != nil {
+		t.Fatalf("DumpState failed: %s\n", err.Error())
+	}
+	var state LoggerState
+	if err := yaml.Unmarshal(buf.Bytes(), &state); err != nil {
+		t.Fatalf("Failed to unmarshal state: %s\n", err.Error())
+	}
+	if state.OutputLevels["kafka"] != WarnType ||
+		len(state.Outputs) != 1 || state.Outputs[0] != "kafka" ||
+		len(state.Producers) == 0 {
+		t.Errorf("Unexpected state: %+v\n", state)
+	}
+	errs := state.Errors
+	if len(errs) == 0 || errs[len(errs)-1].Error != "Extracted key missing" {
+		t.Errorf("Unexpected internal errors: %v\n", errs)
+	}
+	if state.Configuration.KafkaProducerCfg.KeyName != "user" {
+		t.Errorf("Unexpected state configuration: %+v\n", state.Configuration)
+	}
+
+	// the TLS config of the producer is neither dumped nor exported
+	cfg.KafkaProducerCfg.EnableTLS = true
+	cfg.KafkaProducerCfg.TLSCfg = &tls.Config{ServerName: "broker"}
+	tlsLog, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	defer tlsLog.Close()
+	buf.Reset()
+	if err := DumpState(&buf); err != nil {
+		t.Fatalf("DumpState with TLS failed: %s\n", err.Error())
+	}
+	state = LoggerState{}
+	if err := yaml.Unmarshal(buf.Bytes(), &state); err != nil {
+		t.Fatalf("Failed to unmarshal state: %s\n", err.Error())
+	}
+	if !state.Configuration.KafkaProducerCfg.EnableTLS ||
+		state.Configuration.KafkaProducerCfg.TLSCfg != nil {
+		t.Errorf("Unexpected TLS state: %+v\n", state.Configuration.KafkaProducerCfg)
+	}
+	for _, format := range []ExportFormatType{YAMLExport, JSONExport} {
+		if err := WriteConfiguration(&buf, format); err != nil {
+			t.Errorf("WriteConfiguration %s with TLS failed: %s\n", format, err.Error())
+		}
+	}
+	if err := ExportState(filepath.Join(t.TempDir(), ExportConfigFileName)); err != nil {
+		t.Errorf("ExportState with TLS failed: %s\n", err.Error())
+	}
+}
+
+func TestProducerStats(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.Topic = "stats"
+	cfg.KafkaProducerCfg.DeliveryRetryMax = 2
+	cfg.KafkaProducerCfg.DeliveryRetryFreq = time.Millisecond
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	defer log.Close()
+
+	MockBrokerFail(1)
+	log.Info("retried")
+	if err := log.Flush(time.Second); err != nil {
+		t.Fatalf("Flush failed: %s\n", err.Error())
+	}
+
+	var stats *ProducerState
+	for _, state := range CurrentState().Producers {
+		if state.Topic == "stats" {
+			stats = &state
+		}
+	}
+	if stats == nil || stats.Retries != 1 ||
+		stats.Errors[ErrMockDelivery.Error()] != 1 ||
+		stats.LastBroker != MockBrokerInfo.Addr {
+		t.Fatalf("Unexpected producer stats: %+v\n", stats)
+	}
+
+	file := filepath.Join(t.TempDir(), ExportConfigFileName)
+	if err := ExportState(file); err != nil {
+		t.Fatalf("ExportState failed: %s\n", err.Error())
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s\n", file, err.Error())
+	}
+	var exported struct {
+		KafkaProducerCfg ProducerConfiguration `yaml:"kafkaproducercfg"`
+		ProducerStats    []ProducerState       `yaml:"producerstats"`
+	}
+	if err := yaml.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to unmarshal export: %s\n", err.Error())
+	}
+	if exported.KafkaProducerCfg.Topic != "stats" ||
+		len(exported.ProducerStats) == 0 {
+		t.Errorf("Unexpected export: %s\n", data)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		collector := &testCollector{counts: make(map[string]int)}
+		SetMetricsCollector(collector)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.DeliveryRetryMax = 0
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("counted")
+		log.Warn("counted")
+		log.Flush(time.Second)
+		MockBrokerFail(1)
+		log.Info("dropped")
+		log.Close()
+		SetMetricsCollector(nil)
+
+		expected := map[string]int{
+			MetricMessages + "map[level:info]":                 2,
+			MetricMessages + "map[level:warn]":                 1,
+			MetricKafkaSuccesses + "map[]":                     2,
+			MetricKafkaLatency + "map[]":                       2,
+			MetricKafkaFailures + "map[]":                      1,
+			MetricDropped + "map[reason:" + DropDelivery + "]": 1,
+		}
+		for key, count := range expected {
+			if collector.counts[key] != count {
+				t.Errorf("Expected %s %s count %d, got %v\n",
+					pkg, key, count, collector.counts)
+			}
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE " + MetricMessages + " counter",
+		MetricMessages + `{level="warn"}`,
+		MetricKafkaLatency + `_bucket{le="+Inf"}`,
+		MetricQueueDepth + " 0",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Metrics missing %q:\n%s\n", line, body)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	fixed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := NewFixedClock(fixed)
+	SetClock(clock)
+	defer SetClock(nil)
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableTimeStamps = true
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join("testdata", "clock.log")
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.KafkaProducerCfg.Key = TimeSecondKey
+		os.Remove(cfg.FileLocation)
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Info("fixed time")
+		clock.Add(time.Second)
+		log.Info("advanced time")
+		log.Close()
+		clock.Set(fixed)
+
+		actual, err := ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read file %s: %s\n",
+				cfg.FileLocation, err.Error())
+		}
+		for _, stamp := range []string{
+			`"time":"2020-01-02T03:04:05Z"`,
+			`"time":"2020-01-02T03:04:06Z"`,
+		} {
+			if !bytes.Contains(actual, []byte(stamp)) {
+				t.Errorf("Expected %s file to contain %s, got %s\n",
+					pkg, stamp, actual)
+			}
+		}
+
+		messages := MockBrokerMessages()
+		if len(messages) != 2 ||
+			messages[0].Key != strconv.Itoa(int(fixed.Unix())) ||
+			messages[1].Key != strconv.Itoa(int(fixed.Unix()+1)) {
+			t.Errorf("Expected %s keys from fixed clock, got %+v\n",
+				pkg, messages)
+		}
+	}
+}
+
+// syncWriter counts the syncs of the records written
+type syncWriter struct {
+	bytes.Buffer
+	synced int
+}
+
+func (w *syncWriter) Sync() error {
+	w.synced = w.Len()
+	return nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	out := &gateWriter{gate: make(chan struct{})}
+	async := newAsyncWriter(out, 2, AsyncDropOldest)
+	for i := 0; i < 6; i++ {
+		async.Write([]byte(fmt.Sprintf("record %d\n", i)))
+	}
+	close(out.gate)
+	async.Close()
+	// first record may be held by the goroutine, others beyond two dropped
+	if dropped := async.Dropped(); dropped < 3 || dropped > 4 {
+		t.Errorf("Expected 3 or 4 dropped records, got %d\n", dropped)
+	}
+	if !strings.HasSuffix(out.buf.String(), "record 4\nrecord 5\n") {
+		t.Errorf("Expected newest records written, got %q\n", out.buf.String())
+	}
+	if _, err := async.Write([]byte("closed\n")); err != ErrWriterClosed {
+		t.Errorf("Expected ErrWriterClosed, got %v\n", err)
+	}
+
+	synced := &syncWriter{}
+	async = newAsyncWriter(synced, 2, AsyncBlock)
+	async.Write([]byte("record\n"))
+	if err := async.Sync(); err != nil || synced.synced != len("record\n") {
+		t.Errorf("Expected record synced, got %d %v\n", synced.synced, err)
+	}
+	async.Close()
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = TextFormat
+		cfg.FileLocation = filepath.Join("testdata", "async.log")
+		cfg.EnableAsync = true
+		cfg.AsyncBufferSize = 4
+		cfg.AsyncPolicy = AsyncBlock
+		os.Remove(cfg.FileLocation)
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		for i := 0; i < 100; i++ {
+			log.Infof("async %d", i)
+		}
+		log.Close()
+
+		actual, err := ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read file %s: %s\n",
+				cfg.FileLocation, err.Error())
+		}
+		if lines := bytes.Count(actual, []byte("\n")); lines != 100 {
+			t.Errorf("Expected %s 100 lines written, got %d\n", pkg, lines)
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.AsyncPolicy = "newest"
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid AsyncPolicy to fail\n")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	levels := map[string]LevelType{
+		"TRACE":    DebugType,
+		"debug":    DebugType,
+		"Notice":   InfoType,
+		"WARNING":  WarnType,
+		"err":      ErrorType,
+		"CRITICAL": FatalType,
+		"emerg":    PanicType,
+		"0":        PanicType,
+		"3":        ErrorType,
+		" 6 ":      InfoType,
+		"7":        DebugType,
+	}
+	for name, expected := range levels {
+		level, err := ParseLevel(name)
+		if err != nil || level != expected {
+			t.Errorf("Expected %q to parse as %s, got %s %v\n",
+				name, expected, level, err)
+		}
+	}
+	for _, name := range []string{"", "loud", "8", "-1"} {
+		if _, err := ParseLevel(name); err == nil {
+			t.Errorf("Expected %q to fail to parse\n", name)
+		}
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = false
+		cfg.LogLevel = "WARNING"
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.Close()
+	}
+}
 
-func TestMain(m *testing.M) {
-	var (
-		err     error
-		code    int
-		pubsub  bool
-		subtest string
-	)
-	flag.Parse()
+func TestSampling(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	clock := NewFixedClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	SetClock(clock)
+	defer SetClock(nil)
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		ResetMockBroker()
+		collector := &testCollector{counts: make(map[string]int)}
+		SetMetricsCollector(collector)
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaFormat = JSONFormat
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.EnableSampling = true
+		cfg.SamplingCfg.Initial = 2
+		cfg.SamplingCfg.Thereafter = 3
+		cfg.SamplingCfg.Levels = map[LevelType]SamplingLevel{
+			WarnType: {Initial: 1, Thereafter: 0},
+		}
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		// info records 1, 2, 5 and 8 are sent, warn record 1 only
+		for i := 0; i < 10; i++ {
+			log.Info("repeated")
+		}
+		for i := 0; i < 5; i++ {
+			log.Warn("repeated")
+		}
+		log.Close()
+		SetMetricsCollector(nil)
+
+		if count := len(MockBrokerMessages()); count != 5 {
+			t.Errorf("Expected %s 5 sampled messages, got %d\n", pkg, count)
+		}
+		if collector.counts[MetricSuppressed+"map[level:info]"] != 6 ||
+			collector.counts[MetricSuppressed+"map[level:warn]"] != 4 {
+			t.Errorf("Expected %s suppressed counts, got %v\n",
+				pkg, collector.counts)
+		}
+
+		ResetMockBroker()
+		cfg.SamplingCfg = DefaultSamplingCfg()
+		cfg.SamplingCfg.BurstRate = 1
+		cfg.SamplingCfg.BurstSize = 2
+		log, err = NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		for i := 0; i < 5; i++ {
+			log.Infof("burst %d", i)
+		}
+		clock.Add(time.Second)
+		for i := 5; i < 10; i++ {
+			log.Infof("burst %d", i)
+		}
+		log.Close()
+		if count := len(MockBrokerMessages()); count != 3 {
+			t.Errorf("Expected %s 3 rate limited messages, got %d\n",
+				pkg, count)
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableSampling = true
+	cfg.SamplingCfg.Thereafter = -1
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid sampling configuration to fail\n")
+	}
+}
+
+func TestLazyFields(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join("testdata", "lazy.log")
+		os.Remove(cfg.FileLocation)
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		calls := 0
+		lazy := log.WithLazyFields(func() LogFields {
+			calls++
+			return LogFields{"size": calls}
+		}).WithFields(LogFields{"eager": "yes"})
+		lazy.Debug("suppressed")
+		if calls != 0 {
+			t.Errorf("Expected %s lazy fields not computed, got %d calls\n",
+				pkg, calls)
+		}
+		lazy.Info("emitted")
+		lazy.Warn("emitted")
+		log.Close()
+		if calls != 2 {
+			t.Errorf("Expected %s lazy fields computed twice, got %d\n",
+				pkg, calls)
+		}
+
+		actual, err := ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read file %s: %s\n",
+				cfg.FileLocation, err.Error())
+		}
+		for _, field := range []string{`"size":1`, `"size":2`, `"eager":"yes"`} {
+			if !bytes.Contains(actual, []byte(field)) {
+				t.Errorf("Expected %s file to contain %s, got %s\n",
+					pkg, field, actual)
+			}
+		}
+		if bytes.Contains(actual, []byte(lazyFieldsKey)) {
+			t.Errorf("Expected %s lazy fields key removed, got %s\n",
+				pkg, actual)
+		}
+
+		// not computed for records dropped by the only output enabled
+		if pkg != ZapType {
+			continue
+		}
+		ResetMockBroker()
+		cfg.EnableFile = false
+		cfg.EnableKafka = true
+		cfg.KafkaProducerCfg.EnableMock = true
+		cfg.EnableSampling = true
+		cfg.SamplingCfg = SamplingConfiguration{Initial: 1, Thereafter: 0,
+			Tick: time.Minute}
+		log, err = NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		calls = 0
+		sampled := log.WithLazyFields(func() LogFields {
+			calls++
+			return LogFields{"size": calls}
+		})
+		for i := 0; i < 3; i++ {
+			sampled.Info("sampled")
+		}
+		log.Close()
+		if calls != 1 {
+			t.Errorf("Expected %s lazy fields computed once, got %d\n",
+				pkg, calls)
+		}
+	}
+}
+
+func TestFieldLimits(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join("testdata", "limits.log")
+		cfg.MaxFields = 2
+		cfg.MaxFieldLength = 5
+		os.Remove(cfg.FileLocation)
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.WithFields(LogFields{"a": "1234567890", "b": "x", "c": "y"}).
+			Info("limited")
+		log.With(String("a", "1234567890")).With(Int("b", 1)).
+			With(String("c", "y")).Info("limited")
+		log.Close()
+
+		file, err := os.Open(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %s\n", cfg.FileLocation, err.Error())
+		}
+		records := 0
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			records++
+			var record map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("Failed to unmarshal %s record: %s\n", pkg, err.Error())
+			}
+			fields := 0
+			for _, key := range []string{"a", "b", "c"} {
+				if _, ok := record[key]; ok {
+					fields++
+				}
+			}
+			if fields != 2 || record[DroppedFieldsKey] != float64(1) {
+				t.Errorf("Expected %s 2 fields and 1 dropped, got %v\n",
+					pkg, record)
+			}
+			if a, ok := record["a"]; ok && a != "12345"+TruncatedSuffix {
+				t.Errorf("Expected %s truncated field, got %v\n", pkg, a)
+			}
+		}
+		file.Close()
+		if records != 2 {
+			t.Errorf("Expected %s 2 records, got %d\n", pkg, records)
+		}
+	}
+
+	cfg := *DefaultCompleteCfg()
+	cfg.MaxFields = -1
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid MaxFields to fail\n")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	defer ResetMockBroker()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.EnablePreflight = true
+	cfg.PreflightPrincipal = "User:logger"
+	cfg.DeadLetterTopic = "dead"
+
+	ResetMockBroker()
+	MockBrokerTopics(cfg.Topic, cfg.DeadLetterTopic)
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Expected preflight to pass: %s\n", err.Error())
+	}
+	sender.Close()
+
+	MockBrokerTopics(cfg.Topic)
+	MockBrokerDenyWrite(cfg.Topic)
+	MockBrokerMaxMessageBytes(1000)
+	_, err = NewSender(cfg)
+	if !errors.Is(err, ErrPreflight) {
+		t.Fatalf("Expected preflight error, got %v\n", err)
+	}
+	for _, problem := range []string{
+		"topic dead does not exist",
+		"no ACL allows User:logger to write to topic logs",
+		"exceeds topic logs max.message.bytes 1000",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected preflight error to contain %q, got %s\n",
+				problem, err.Error())
+		}
+	}
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		logCfg := *DefaultCompleteCfg()
+		logCfg.LogPackage = pkg
+		logCfg.EnableFile = false
+		logCfg.EnableKafka = true
+		logCfg.KafkaProducerCfg = cfg
+		if _, err := NewLogger(logCfg); !errors.Is(err, ErrPreflight) {
+			t.Errorf("Expected %s logger preflight error, got %v\n", pkg, err)
+		}
+	}
+}
+
+func TestCreateTopics(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	defer ResetMockBroker()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.EnablePreflight = true
+	cfg.DeadLetterTopic = "dead"
+
+	ResetMockBroker()
+	MockBrokerTopics(cfg.Topic)
+	if _, err := NewSender(cfg); !errors.Is(err, ErrPreflight) {
+		t.Fatalf("Expected preflight error for missing topic, got %v\n", err)
+	}
+
+	cfg.CreateTopics = true
+	cfg.TopicPartitions = 3
+	cfg.TopicRetention = 24 * time.Hour
+	sender, err := NewSender(cfg)
+	if err != nil {
+		t.Fatalf("Expected missing topic to be created: %s\n", err.Error())
+	}
+	sender.Close()
+
+	if _, ok := MockBrokerCreatedTopic(cfg.Topic); ok {
+		t.Errorf("Unexpected creation of existing topic %s\n", cfg.Topic)
+	}
+	detail, ok := MockBrokerCreatedTopic(cfg.DeadLetterTopic)
+	if !ok {
+		t.Fatalf("Expected topic %s to be created\n", cfg.DeadLetterTopic)
+	}
+	if detail.NumPartitions != 3 || detail.ReplicationFactor != 1 {
+		t.Errorf("Unexpected topic detail: %+v\n", detail)
+	}
+	retention := detail.ConfigEntries["retention.ms"]
+	if retention == nil || *retention != "86400000" {
+		t.Errorf("Expected retention.ms 86400000, got %v\n", retention)
+	}
+
+	cfg.TopicPartitions = -1
+	logCfg := *DefaultCompleteCfg()
+	logCfg.EnableFile = false
+	logCfg.EnableKafka = true
+	logCfg.KafkaProducerCfg = cfg
+	if _, err := NewLogger(logCfg); err == nil {
+		t.Errorf("Expected error for negative TopicPartitions\n")
+	}
+}
+
+func TestKafkaSecurity(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+
+	pc := DefaultProducerCfg()
+	pc.EnableTLS = true
+	pc.CACertFile = certFile
+	pc.CertFile = certFile
+	pc.KeyFile = keyFile
+	pc.SASLMechanism = SASLSCRAMSHA512
+	pc.SASLUser = "logger"
+	pc.SASLPassword = "secret"
+
+	cfg := sarama.NewConfig()
+	if err := setSecurity(cfg, pc); err != nil {
+		t.Fatalf("Failed to set security: %s\n", err.Error())
+	}
+	if !cfg.Net.TLS.Enable || cfg.Net.TLS.Config.RootCAs == nil ||
+		len(cfg.Net.TLS.Config.Certificates) != 1 {
+		t.Errorf("TLS config not built from files: %+v\n", cfg.Net.TLS)
+	}
+	if cfg.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 ||
+		cfg.Net.SASL.User != "logger" || cfg.Net.SASL.Password != "secret" {
+		t.Errorf("SASL config not set: %+v\n", cfg.Net.SASL)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Invalid sarama config: %s\n", err.Error())
+	}
+
+	// first SCRAM message carries the user name
+	client := cfg.Net.SASL.SCRAMClientGeneratorFunc()
+	if err := client.Begin("logger", "secret", ""); err != nil {
+		t.Fatalf("Failed to begin SCRAM exchange: %s\n", err.Error())
+	}
+	msg, err := client.Step("")
+	if err != nil || !strings.HasPrefix(msg, "n,,n=logger,r=") || client.Done() {
+		t.Errorf("Unexpected SCRAM client first message: %s\n", msg)
+	}
+
+	pc.CACertFile = filepath.Join(t.TempDir(), "missing.pem")
+	if err := setSecurity(sarama.NewConfig(), pc); err == nil {
+		t.Errorf("Missing CACertFile should fail\n")
+	}
+
+	// environment overrides under the kafka prefix
+	t.Setenv(KafkaEnvPrefix+"_SASLMECHANISM", string(SASLSCRAMSHA256))
+	t.Setenv(KafkaEnvPrefix+"_SASLUSER", "envuser")
+	t.Setenv(KafkaEnvPrefix+"_SASLPASSWORD", "envsecret")
+	t.Setenv(KafkaEnvPrefix+"_CACERTFILE", certFile)
+	envCfg := new(ProducerConfiguration)
+	err = FillConfiguration(DefaultProducerCfg(), envCfg, EnvConfig, "",
+		KafkaEnvPrefix)
+	if err != nil {
+		t.Fatalf("Failed to fill configuration: %s\n", err.Error())
+	}
+	if envCfg.SASLMechanism != SASLSCRAMSHA256 ||
+		envCfg.SASLUser != "envuser" || envCfg.SASLPassword != "envsecret" ||
+		envCfg.CACertFile != certFile {
+		t.Errorf("Environment overrides not applied: %+v\n", envCfg)
+	}
+
+	for _, invalid := range []ProducerConfiguration{
+		{SASLMechanism: "GSSAPI", SASLUser: "logger"},
+		{SASLMechanism: SASLPlain},
+		{CACertFile: certFile},
+		{EnableTLS: true, CertFile: certFile},
+	} {
+		errCount := 0
+		checkProducerConfig(invalid, &errCount)
+		if errCount == 0 {
+			t.Errorf("Invalid security config passed: %+v\n", invalid)
+		}
+	}
+}
+
+func TestRedactedSecrets(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	ResetMockBroker()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableFile = false
+	cfg.EnableKafka = true
+	cfg.KafkaFormat = JSONFormat
+	cfg.KafkaProducerCfg.EnableMock = true
+	cfg.KafkaProducerCfg.SASLMechanism = SASLPlain
+	cfg.KafkaProducerCfg.SASLUser = "logger"
+	cfg.KafkaProducerCfg.SASLPassword = "sasl-secret"
+	t.Setenv(ConfigTypeEnvName, string(EnvConfig))
+	cfg.KafkaProducerCfg.SchemaRegistryCfg.Username = "registry"
+	cfg.KafkaProducerCfg.SchemaRegistryCfg.Password = "registry-secret"
+	t.Setenv(KafkaEnvPrefix+"_SASLPASSWORD", "sasl-secret")
+	cfg.KinesisCfg.Credentials = AWSCredentialsConfiguration{
+		AccessKeyID: "AKID", SecretAccessKey: "kinesis-secret",
+		SessionToken: "kinesis-token"}
+	cfg.SQSCfg.Credentials = AWSCredentialsConfiguration{
+		AccessKeyID: "AKID", SecretAccessKey: "sqs-secret"}
+	secrets := []string{"sasl-secret", "registry-secret", "kinesis-secret",
+		"kinesis-token", "sqs-secret"}
+
+	log, err := NewLogger(cfg)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	defer log.Close()
 
-	testEnv := os.Getenv("PRTEST_ENV")
-	if testEnv == "true" {
-		testenv = true
+	// every exported view of the configuration
+	var buf bytes.Buffer
+	if err := DumpState(&buf); err != nil {
+		t.Fatalf("DumpState failed: %s\n", err.Error())
 	}
-	testInit := os.Getenv("PRTEST_INIT")
-	if testInit == "true" {
-		testinit = true
+	if err := WriteConfiguration(&buf, JSONExport); err != nil {
+		t.Fatalf("WriteConfiguration failed: %s\n", err.Error())
 	}
-	if testing.Short() {
-		testshort = true
+	file := filepath.Join(t.TempDir(), ExportConfigFileName)
+	if err := ExportState(file); err != nil {
+		t.Fatalf("ExportState failed: %s\n", err.Error())
 	}
-	if testing.Verbose() {
-		testverbose = true
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s\n", file, err.Error())
 	}
-
-	listval := flag.Lookup("test.list").Value.String()
-	runval := flag.Lookup("test.run").Value.String()
-	runsplit := strings.Split(runval, "/")
-	runtest := runsplit[0]
-	if len(runsplit) > 1 {
-		subtest = runsplit[1]
+	buf.Write(data)
+	for _, v := range ConfigSpec() {
+		buf.WriteString(v.Name + "=" + v.Default + "," + v.Value + "\n")
 	}
 
-	if testshort {
-		fmt.Printf("=== INFO  Short enabled\n")
+	for _, secret := range secrets {
+		if bytes.Contains(buf.Bytes(), []byte(secret)) {
+			t.Errorf("Secret %s not redacted:\n%s\n", secret, buf.String())
+		}
 	}
-	if testverbose {
-		fmt.Printf("=== INFO  Verbose enabled\n")
+	if !bytes.Contains(buf.Bytes(), []byte(redactedSecret)) {
+		t.Errorf("Expected redacted secrets:\n%s\n", buf.String())
 	}
-	if *debug {
-		fmt.Printf("=== INFO  Debug enabled\n")
-		fmt.Printf("--- explicit = <%+v>\n", *explicit)
-		fmt.Printf("--- runtest = <%+v>\n", runtest)
-		fmt.Printf("--- subtest = <%+v>\n", subtest)
+	if CurrentConfiguration().KafkaProducerCfg.SASLPassword != "sasl-secret" {
+		t.Errorf("Configuration in use should not be redacted\n")
 	}
+}
 
-	if *rewrite {
-		fmt.Printf("=== INFO  Rewriting config files\n")
+func TestStackTrace(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := *DefaultCompleteCfg()
+		cfg.LogPackage = pkg
+		cfg.EnableConsole = false
+		cfg.EnableKafka = false
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join(t.TempDir(), "stack.log")
+		cfg.EnableStackTrace = true
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		log.WithError(errors.New("boom")).Error("failed")
+		log.WithError(nil).Warn("no stack")
+		log.Close()
 
-	if testenv || testinit || testshort {
-		// Server started externally for env/init tests
-		// Skip Pubsub tests in short mode
-		pubsub = false
-	} else if runtest == "" && subtest == "" {
-		pubsub = true
-	} else {
-		pubsub = regexp.MustCompile(tPub).MatchString(runtest) ||
-			subtest != "" && regexp.MustCompile(tPub).MatchString(subtest)
-	}
+		data, err := ioutil.ReadFile(cfg.FileLocation)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s\n", cfg.FileLocation, err.Error())
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 %s records, got %d\n", pkg, len(lines))
+		}
+		var failed, warned map[string]interface{}
+		json.Unmarshal([]byte(lines[0]), &failed)
+		json.Unmarshal([]byte(lines[1]), &warned)
 
-	if pubsub && listval == "" {
-		fmt.Printf("=== START Pubsub server\n")
-		err = pubsubStartup()
+		if failed[ErrorKey] != "boom" {
+			t.Errorf("Expected %s error field, got %v\n", pkg, failed)
+		}
+		stack, _ := failed[StackTraceKey].(string)
+		if !strings.HasPrefix(stack, packagePath+".TestStackTrace") {
+			t.Errorf("Expected %s stack trace from the caller, got %q\n",
+				pkg, stack)
+		}
+		if _, ok := warned[StackTraceKey]; ok {
+			t.Errorf("Unexpected %s stack trace below level: %v\n", pkg, warned)
+		}
+		if _, ok := warned[ErrorKey]; ok {
+			t.Errorf("Unexpected %s nil error field: %v\n", pkg, warned)
+		}
 	}
+}
 
-	if err == nil {
-		code = m.Run()
-	} else {
-		code = 1
+func TestHTTPSink(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
-
-	if pubsub && listval == "" {
-		fmt.Printf("=== STOP  Pubsub server\n")
-		pubsubShutdown()
+	type request struct {
+		header http.Header
+		body   []byte
 	}
+	var mutex sync.Mutex
+	var requests []request
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if r.Header.Get("Content-Encoding") == "gzip" {
+				zr, err := gzip.NewReader(r.Body)
+				if err == nil {
+					body, _ = ioutil.ReadAll(zr)
+				}
+			} else {
+				body, _ = ioutil.ReadAll(r.Body)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			requests = append(requests, request{r.Header, body})
+		}))
+	defer server.Close()
 
-	os.Exit(code)
-}
+	for _, mode := range []httpModeType{HTTPLoki, HTTPNDJSON} {
+		for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+			mutex.Lock()
+			requests = nil
+			failures = 1
+			mutex.Unlock()
 
-func readConfiguration(t *testing.T, testname string) (
-	LoggerConfiguration, error) {
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableConsole = false
+			cfg.EnableFile = false
+			cfg.EnableKafka = false
+			cfg.EnableHTTP = true
+			cfg.HTTPCfg.URL = server.URL
+			cfg.HTTPCfg.Mode = mode
+			cfg.HTTPCfg.Headers = map[string]string{"X-Scope-OrgID": "tenant"}
+			cfg.HTTPCfg.Labels = map[string]string{"app": "test"}
+			cfg.HTTPCfg.EnableGzip = mode == HTTPLoki
+			cfg.HTTPCfg.FlushInterval = time.Hour
+			cfg.HTTPCfg.RetryFreq = time.Millisecond
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			log.Info("first")
+			log.Warn("second")
+			log.Info("third")
+			if err := log.Flush(5 * time.Second); err != nil {
+				t.Fatalf("Failed to flush %s %s sink: %s\n", pkg, mode, err.Error())
+			}
 
-	var cfg LoggerConfiguration
-	input := filepath.Join("testdata", testname+".yaml")
-	yamlbytes, err := ioutil.ReadFile(input)
-	if err != nil {
-		t.Errorf("Failed to read %s config: %s\n", input, err.Error())
-		return cfg, err
+			mutex.Lock()
+			if len(requests) != 1 {
+				t.Fatalf("Expected 1 %s %s request after retry, got %d\n",
+					pkg, mode, len(requests))
+			}
+			req := requests[0]
+			mutex.Unlock()
+			if req.header.Get("X-Scope-OrgID") != "tenant" {
+				t.Errorf("Expected %s %s header, got %v\n", pkg, mode, req.header)
+			}
+
+			switch mode {
+			case HTTPLoki:
+				var push struct {
+					Streams []lokiStream `json:"streams"`
+				}
+				if err := json.Unmarshal(req.body, &push); err != nil {
+					t.Fatalf("Failed to decode %s push: %s\n", pkg, err.Error())
+				}
+				if len(push.Streams) != 2 {
+					t.Fatalf("Expected 2 %s streams, got %d\n", pkg,
+						len(push.Streams))
+				}
+				info := push.Streams[0]
+				if info.Stream["level"] != string(InfoType) ||
+					info.Stream["app"] != "test" || len(info.Values) != 2 {
+					t.Errorf("Unexpected %s info stream: %v\n", pkg, info)
+				}
+				if !strings.Contains(info.Values[1][1], "third") {
+					t.Errorf("Expected %s third record, got %q\n", pkg,
+						info.Values[1][1])
+				}
+				if push.Streams[1].Stream["level"] != string(WarnType) {
+					t.Errorf("Unexpected %s warn stream: %v\n", pkg,
+						push.Streams[1])
+				}
+			case HTTPNDJSON:
+				lines := strings.Split(strings.TrimSpace(string(req.body)), "\n")
+				if len(lines) != 3 {
+					t.Fatalf("Expected 3 %s records, got %d\n", pkg, len(lines))
+				}
+				for _, line := range lines {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("Invalid %s record: %s\n", pkg, line)
+					}
+				}
+			}
+			log.Close()
+		}
 	}
 
-	err = yaml.Unmarshal(yamlbytes, &cfg)
+	// the built-in sinks are registered for Sinks entries
+	mutex.Lock()
+	requests = nil
+	mutex.Unlock()
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableConsole = false
+	cfg.EnableFile = false
+	cfg.EnableKafka = false
+	cfg.Sinks = []SinkConfiguration{{Type: HTTPSinkType, Format: JSONFormat,
+		Options: map[string]string{"url": server.URL}}}
+	log, err := NewLogger(cfg)
 	if err != nil {
-		t.Errorf("Failed to unmarshal %s config %s\n", input, err.Error())
-		return cfg, err
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
 	}
-	if *rewrite {
-		err = writeConfiguration(t, input, cfg)
-		return cfg, err
+	log.Info("registered")
+	if err := log.Flush(5 * time.Second); err != nil {
+		t.Fatalf("Failed to flush registered http sink: %s\n", err.Error())
 	}
-	return cfg, nil
-}
-
-func writeConfiguration(t *testing.T, file string,
-	cfg LoggerConfiguration) error {
-
-	ybytes, err := yaml.Marshal(cfg)
-	if err != nil {
-		t.Errorf("Failed to marshal %s config %s\n", file, err.Error())
-		return err
+	log.Close()
+	mutex.Lock()
+	if len(requests) != 1 || !bytes.Contains(requests[0].body,
+		[]byte("registered")) {
+		t.Errorf("Expected registered http sink request, got %d\n",
+			len(requests))
 	}
-	ioutil.WriteFile(file, ybytes, 0644)
-	return nil
+	mutex.Unlock()
 }
 
-func executeTests(t *testing.T, cfg LoggerConfiguration) error {
-	log, err := NewLogger(cfg)
-
-	if err != nil {
-		t.Errorf("Failed to instantiate %s logger: %s",
-			cfg.LogPackage, err.Error())
-		return err
+func TestBatchSinkQueue(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
+	var mutex sync.Mutex
+	var posted []string
+	release := make(chan struct{})
+	sink := &batchSink{
+		name:          HTTPSinkType,
+		batchSize:     2,
+		flushInterval: time.Millisecond,
+		writeTimeout:  20 * time.Millisecond,
+		post: func(batch []sinkRecord) error {
+			<-release
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, record := range batch {
+				posted = append(posted, string(record.line))
+			}
+			return nil
+		},
+		closedErr: ErrHTTPSinkClosed,
+	}
+	sink.start()
 
-	log.Debugf("Debugf using %s", "Debugf (should not appear)")
-	log.Infof("Infof using %s", cfg.LogPackage)
-	log.Warnf("Warnf using %s", cfg.LogPackage)
-	log.Errorf("Errorf using %s", cfg.LogPackage)
-	log.Print("Print using", cfg.LogPackage)
-	log.Printf("Printf using %s", cfg.LogPackage)
-	log.Println("Println using", cfg.LogPackage)
-	return nil
-}
-
-func executeInitTests(t *testing.T, cfg LoggerConfiguration) error {
-	Debugf("Debugf using %s", "Debugf (should not appear)")
-	Infof("Infof using %s", cfg.LogPackage)
-	Warnf("Warnf using %s", cfg.LogPackage)
-	Errorf("Errorf using %s", cfg.LogPackage)
-	Print("Print using", cfg.LogPackage)
-	Printf("Printf using %s", cfg.LogPackage)
-	Println("Println using", cfg.LogPackage)
-	return nil
-}
-
-func executeTopicTests(t *testing.T, cfg LoggerConfiguration,
-	topic string) error {
-
-	log, err := NewLogger(cfg)
-	if err != nil {
-		t.Errorf("Failed to instantiate %s logger: %s",
-			cfg.LogPackage, err.Error())
-		return err
+	// the sender is blocked so writes time out once the queue is full
+	before := SinkTimeouts()[HTTPSinkType]
+	written := 0
+	for i := 0; i < 2*maxQueuedBatches; i++ {
+		if err := sink.Write([]byte(strconv.Itoa(i)+"\n"), Entry{}); err != nil {
+			if err != ErrWriteTimeout {
+				t.Fatalf("Expected write timeout, got %s\n", err.Error())
+			}
+			continue
+		}
+		written++
+	}
+	if written != 2*maxQueuedBatches {
+		t.Fatalf("Expected %d records queued, got %d\n", 2*maxQueuedBatches, written)
+	}
+	start := time.Now()
+	if err := sink.Write([]byte("dropped\n"), Entry{}); err != ErrWriteTimeout {
+		t.Fatalf("Expected write timeout, got %v\n", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected write not to block, took %s\n", time.Since(start))
+	}
+	if after := SinkTimeouts()[HTTPSinkType]; after != before+1 {
+		t.Errorf("Expected 1 %s timeout, got %d\n", HTTPSinkType, after-before)
 	}
 
-	topicfield := LogFields{TopicKey: topic}
-	log.WithFields(topicfield).Infof("Infof using %s", cfg.LogPackage)
-	log.WithFields(topicfield).Warnf("Warnf using %s", cfg.LogPackage)
-	log.WithFields(topicfield).Errorf("Errorf using %s", cfg.LogPackage)
-	log.WithFields(topicfield).Print("Print using", cfg.LogPackage)
-	log.WithFields(topicfield).Printf("Printf using %s", cfg.LogPackage)
-	log.WithFields(topicfield).Println("Println using", cfg.LogPackage)
-	return nil
+	close(release)
+	if err := sink.Flush(5 * time.Second); err != nil {
+		t.Fatalf("Failed to flush: %s\n", err.Error())
+	}
+	// full and partial batches are sent in the order written
+	for i := 0; i < 5; i++ {
+		sink.Write([]byte(strconv.Itoa(written+i)+"\n"), Entry{})
+		time.Sleep(time.Millisecond)
+	}
+	sink.Close()
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(posted) != written+5 {
+		t.Fatalf("Expected %d records sent, got %v\n", written+5, posted)
+	}
+	for i, line := range posted {
+		if line != strconv.Itoa(i) {
+			t.Fatalf("Expected records in order, got %v\n", posted)
+		}
+	}
 }
 
-func normalizeJSONFile(t *testing.T, filename string) ([]byte, error) {
-	var jsonbytes []byte
-
-	jsonlog, err := os.Open(filename)
-	defer jsonlog.Close()
-	if err != nil {
-		t.Errorf("Failed to open %s: %s", filename, err.Error())
-		return nil, err
+func TestSyslogSink(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
+	// readMessages returns the messages received over the network
+	readMessages := func(network syslogNetworkType, format syslogFormatType,
+		addr chan<- string, count int) <-chan []string {
+		received := make(chan []string, 1)
+		messages := []string{}
+		if network == SyslogUDP {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %s\n", err.Error())
+			}
+			addr <- conn.LocalAddr().String()
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64*1024)
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				for len(messages) < count {
+					n, _, err := conn.ReadFrom(buf)
+					if err != nil {
+						break
+					}
+					messages = append(messages, string(buf[:n]))
+				}
+				received <- messages
+			}()
+			return received
+		}
 
-	scanner := bufio.NewScanner(jsonlog)
-	for scanner.Scan() {
-		normbytes, err := normalizeJSONLine(t, scanner.Text())
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
 		if err != nil {
-			t.Errorf("Failed to normalize %s: %s\n",
-				scanner.Text(), err.Error())
-			return nil, err
+			t.Fatalf("Failed to listen: %s\n", err.Error())
 		}
-		jsonbytes = append(jsonbytes, normbytes...)
+		addr <- listener.Addr().String()
+		go func() {
+			defer listener.Close()
+			conn, err := listener.Accept()
+			if err != nil {
+				received <- messages
+				return
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			for len(messages) < count {
+				if format == SyslogRFC3164 {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						break
+					}
+					messages = append(messages, strings.TrimSuffix(line, "\n"))
+					continue
+				}
+				// octet counting framing
+				length, err := reader.ReadString(' ')
+				if err != nil {
+					break
+				}
+				n, _ := strconv.Atoi(strings.TrimSpace(length))
+				message := make([]byte, n)
+				if _, err := io.ReadFull(reader, message); err != nil {
+					break
+				}
+				messages = append(messages, string(message))
+			}
+			received <- messages
+		}()
+		return received
 	}
-	if err := scanner.Err(); err != nil {
-		t.Errorf("Failed to scan %s: %s", filename, err.Error())
-		return nil, err
+
+	cases := []struct {
+		network syslogNetworkType
+		format  syslogFormatType
+		header  *regexp.Regexp
+	}{
+		{SyslogUDP, SyslogRFC5424, regexp.MustCompile(
+			`^<13[24]>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ testhost testapp \d+ - - \{`)},
+		{SyslogTCP, SyslogRFC5424, regexp.MustCompile(
+			`^<13[24]>1 \S+ testhost testapp \d+ - - \{`)},
+		{SyslogTCP, SyslogRFC3164, regexp.MustCompile(
+			`^<13[24]>[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d testhost testapp\[\d+\]: \{`)},
 	}
-	return jsonbytes, nil
-}
+	for _, c := range cases {
+		for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+			addr := make(chan string, 1)
+			received := readMessages(c.network, c.format, addr, 2)
 
-func normalizeJSONLine(t *testing.T, line string) ([]byte, error) {
-	var jsonmap map[string]interface{}
-	var prejson, jsontext string
+			cfg := *DefaultCompleteCfg()
+			cfg.LogPackage = pkg
+			cfg.EnableConsole = false
+			cfg.EnableFile = false
+			cfg.EnableKafka = false
+			cfg.EnableSyslog = true
+			cfg.SyslogLevel = InfoType
+			cfg.SyslogCfg.Network = c.network
+			cfg.SyslogCfg.Format = c.format
+			cfg.SyslogCfg.Address = <-addr
+			cfg.SyslogCfg.Facility = "local0"
+			cfg.SyslogCfg.Tag = "testapp"
+			cfg.SyslogCfg.Hostname = "testhost"
+			log, err := NewLogger(cfg)
+			if err != nil {
+				t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+			}
+			log.Debug("skipped")
+			log.Info("first")
+			log.Warn("second")
+			log.Close()
 
-	index := strings.IndexByte(line, '{')
-	if index == -1 {
-		t.Errorf("Failed to find JSON in line <%s>\n", line)
-		return nil, errors.New("JSON scan failure")
-	} else if index == 0 {
-		jsontext = line
-	} else {
-		prejson = line[:index]
-		jsontext = line[index:]
+			messages := <-received
+			if len(messages) != 2 {
+				t.Fatalf("Expected 2 %s %s %s messages, got %d\n", pkg,
+					c.network, c.format, len(messages))
+			}
+			for i, pri := range []string{"<134>", "<132>"} {
+				message := messages[i]
+				if !strings.HasPrefix(message, pri) || !c.header.MatchString(message) {
+					t.Errorf("Unexpected %s %s %s header: %s\n", pkg, c.network,
+						c.format, message)
+				}
+				record := message[strings.Index(message, "{"):]
+				if !json.Valid([]byte(record)) {
+					t.Errorf("Invalid %s record: %s\n", pkg, record)
+				}
+			}
+			if !strings.Contains(messages[1], "second") {
+				t.Errorf("Expected %s second record, got %s\n", pkg, messages[1])
+			}
+		}
 	}
-	err := json.Unmarshal([]byte(jsontext), &jsonmap)
+
+	// a server not reading times out the writes, after the retries
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		t.Errorf("Failed to unmarshal %+v err: %s\n", jsontext, err.Error())
-		return nil, err
+		t.Fatalf("Failed to listen: %s\n", err.Error())
 	}
-	jsonbytes, err := json.Marshal(jsonmap)
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	sink, err := newSyslogSink(SyslogConfiguration{Network: SyslogTCP,
+		Address: listener.Addr().String(), Timeout: 50 * time.Millisecond,
+		RetryPolicy: RetryPolicy{MaxAttempts: 2}})
 	if err != nil {
-		t.Errorf("Failed to marshal %+v err: %s\n", jsonmap, err.Error())
-		return nil, err
+		t.Fatalf("Failed to create syslog sink: %s\n", err.Error())
 	}
-	jsonbytes = append(jsonbytes, "\n"...)
-	return append([]byte(prejson), jsonbytes...), nil
-}
-
-func dockerCompose(file string, args ...string) error {
-	var myargs []string
-	if file != "" {
-		myargs = append(myargs, "-f", file)
+	before := SinkTimeouts()[SyslogSinkType]
+	large := bytes.Repeat([]byte("x"), 64<<20)
+	var netErr net.Error
+	if err := sink.Write(large, Entry{Level: InfoType}); !errors.As(err, &netErr) ||
+		!netErr.Timeout() {
+		t.Errorf("Expected syslog write timeout, got %v\n", err)
+	}
+	if after := SinkTimeouts()[SyslogSinkType]; after != before+1 {
+		t.Errorf("Expected 1 syslog timeout, got %d\n", after-before)
+	}
+	if len(accepted) != 2 {
+		t.Errorf("Expected 2 syslog connections, got %d\n", len(accepted))
+	}
+	sink.Close()
+	if err := sink.Write([]byte("closed"), Entry{}); err != ErrSyslogSinkClosed {
+		t.Errorf("Expected syslog sink closed, got %v\n", err)
+	}
+	listener.Close()
+	for len(accepted) > 0 {
+		(<-accepted).Close()
 	}
-	myargs = append(myargs, args...)
 
-	cmd := exec.Command("docker-compose", myargs...)
-	err := cmd.Run()
-	if err != nil {
-		fmt.Printf("Failed to exec docker-compose %+v: %s\n", myargs,
-			err.Error())
-		return err
+	cfg := *DefaultCompleteCfg()
+	cfg.EnableSyslog = true
+	cfg.SyslogCfg.Facility = "local9"
+	if _, err := NewLogger(cfg); err == nil {
+		t.Errorf("Expected invalid syslog facility to fail\n")
 	}
-	return nil
 }
 
-func pubsubStartup() error {
-	err := dockerCompose("testdata/docker-compose.yaml", "up", "-d")
-	if err != nil {
-		fmt.Printf("Failed to startup kafka server\n")
-	}
-	return err
+// fastPathRecords are records formatted as by the log packages
+var fastPathRecords = []string{
+	`{"level":"info","time":"2020-01-01T00:00:00Z","msg":"hello","count":3}` + "\n",
+	`{"level":"error","data":"Errorf using zap","source":"src","type":"t"}`,
+	`{"level":"warning","msg":"quote \" and é","nested":{"b":1,"a":[1,"x"]}}`,
+	`{"level":"info","msg":"first","msg":"last","event":"login"}`,
+	`{ "level" : "debug" , "data" : "spaced\ttab" }`,
 }
 
-func pubsubShutdown() error {
-	err := dockerCompose("testdata/docker-compose.yaml", "down")
+func newFastPathProducer(t testing.TB) *KafkaProducer {
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.Key = LevelKey
+	ceCfg := DefaultCloudEventsCfg()
+	ceCfg.SetSubjectLevel = false
+	ceCfg.EventTypes = map[string]string{"login": "io.pavedroad.login"}
+	ceCfg.LevelTypes = map[LevelType]string{WarnType: "io.pavedroad.warn"}
+	kp, err := newKafkaProducer(cfg, newCloudEvents(ceCfg, nil), ceCfg, nil)
 	if err != nil {
-		fmt.Printf("Failed to shutdown kafka server\n")
+		t.Fatalf("Failed to create producer: %s\n", err.Error())
 	}
-	return err
+	return kp
 }
 
-func setupConsole(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration) *os.File {
-
-	if testinit {
-		return nil
-	}
-
-	fname := filepath.Join("testdata", name+".out")
-	file, err := os.Create(fname)
-	if err != nil {
-		t.Fatalf("Failed to create file %s: %s\n", fname, err.Error())
+func TestKafkaFastPath(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
-
-	var output *os.File
-	if cfg.ConsoleWriter == Stderr {
-		output = os.Stderr
-		os.Stderr = file
-	} else {
-		output = os.Stdout
-		os.Stdout = file
+	kp := newFastPathProducer(t)
+	defer kp.close()
+	if !kp.fastPath(nil) {
+		t.Fatalf("Expected fast path to be used\n")
 	}
-	return output
-}
 
-func checkConsole(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration, output *os.File) {
-	var containsUnsortedJSON bool
-	var err error
-
-	if output != nil {
-		if cfg.ConsoleWriter == Stderr {
-			os.Stderr.Close()
-			os.Stderr = output
-		} else {
-			os.Stdout.Close()
-			os.Stdout = output
-		}
+	// a filter function of the logger route requires the map
+	mapRoute := &kafkaRoute{filterFn: func(*map[string]interface{}) {}}
+	if kp.fastPath(mapRoute) {
+		t.Fatalf("Expected map path for filter function\n")
 	}
 
-	var actual []byte
-	fname := filepath.Join("testdata", name+".out")
-	if containsUnsortedJSON {
-		actual, err = normalizeJSONFile(t, fname)
-		if err != nil {
-			t.FailNow()
+	for _, record := range fastPathRecords {
+		fast, ok := kp.processFast([]byte(record), nil)
+		if !ok {
+			t.Fatalf("Expected fast path for %s\n", record)
 		}
-	} else {
-		actual, err = ioutil.ReadFile(fname)
+		slow, err := kp.processMessage([]byte(record), mapRoute)
 		if err != nil {
-			t.Fatalf("Failed to read file %s: %s\n", fname,
-				err.Error())
+			t.Fatalf("Failed to process %s: %s\n", record, err.Error())
 		}
-	}
 
-	golden := filepath.Join("testdata", name+".golden")
-	if *update {
-		t.Logf("Updating %s\n", golden)
-		ioutil.WriteFile(golden, actual, 0644)
+		if fast.Topic != slow.Topic || fast.Key != slow.Key {
+			t.Errorf("Expected topic %s key %v, got %s %v\n",
+				slow.Topic, slow.Key, fast.Topic, fast.Key)
+		}
+		var fastValue, slowValue interface{}
+		json.Unmarshal(fast.Value.(sarama.ByteEncoder), &fastValue)
+		json.Unmarshal(slow.Value.(sarama.ByteEncoder), &slowValue)
+		if !reflect.DeepEqual(fastValue, slowValue) {
+			t.Errorf("Expected fast record %s, got %s\n",
+				slow.Value, fast.Value)
+		}
 	}
 
-	expected, err := ioutil.ReadFile(golden)
-	if err != nil {
-		t.Fatalf("Failed to read file %s: %s\n", golden, err.Error())
+	// byte identical to the map encoding for records without escapes
+	record := []byte(fastPathRecords[0])
+	fast, _ := kp.processFast(record, nil)
+	slow, _ := kp.processMessage(record, mapRoute)
+	if !bytes.Equal(fast.Value.(sarama.ByteEncoder),
+		slow.Value.(sarama.ByteEncoder)) {
+		t.Errorf("Expected fast record %s, got %s\n", slow.Value, fast.Value)
 	}
 
-	if !bytes.Equal(actual, expected) {
-		t.FailNow()
+	for _, record := range []string{
+		`{"level":"info","topic":"other"}`,
+		`{"msg":"no level"}`,
+		`not json`,
+		`["level","info"]`,
+	} {
+		if _, ok := kp.processFast([]byte(record), nil); ok {
+			t.Errorf("Expected map path for %s\n", record)
+		}
 	}
 }
 
-func setupLogfile(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration) *os.File {
+func BenchmarkKafkaProcessMessage(b *testing.B) {
+	kp := newFastPathProducer(b)
+	defer kp.close()
+	record := []byte(fastPathRecords[0])
 
-	flogfile, err := os.Create(cfg.FileLocation)
-	if err != nil {
-		t.Fatalf("Failed to create file %s: %s\n",
-			cfg.FileLocation, err.Error())
-	}
-	return flogfile
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			kp.processMessage(record, nil)
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		route := &kafkaRoute{filterFn: func(*map[string]interface{}) {}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			kp.processMessage(record, route)
+		}
+	})
 }
 
-func checkLogfile(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration, output *os.File) {
-	var err error
-
-	output.Close()
-	var actual []byte
-	if cfg.LogPackage == ZapType {
-		actual, err = normalizeJSONFile(t, cfg.FileLocation)
-		if err != nil {
-			t.FailNow()
-		}
-	} else {
-		actual, err = ioutil.ReadFile(cfg.FileLocation)
+func BenchmarkWithFields(b *testing.B) {
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.FileFormat = JSONFormat
+		cfg.FileLocation = filepath.Join(b.TempDir(), "bench.log")
+		log, err := NewLogger(cfg)
 		if err != nil {
-			t.Fatalf("Failed to read file %s: %s\n",
-				cfg.FileLocation, err.Error())
+			b.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
 		}
-	}
+		err = errors.New("boom")
 
-	golden := filepath.Join("testdata", name+".golden")
-	if *update {
-		t.Logf("Updating %s\n", golden)
-		ioutil.WriteFile(golden, actual, 0644)
+		b.Run(string(pkg)+"/typed", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				log.With(String("s", "v"), Int("n", i),
+					Duration("d", time.Second), Err(err)).Info("typed")
+			}
+		})
+		b.Run(string(pkg)+"/map", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				log.WithFields(LogFields{"s": "v", "n": i,
+					"d": time.Second.String()}).WithError(err).Info("map")
+			}
+		})
+		log.Close()
 	}
+}
 
-	expected, err := ioutil.ReadFile(golden)
-	if err != nil {
-		t.Fatalf("Failed to read file %s: %s\n", golden, err.Error())
+func TestReload(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
+	saved := logger
+	defer func() {
+		loggerMutex.Lock()
+		logger = saved
+		loggerMutex.Unlock()
+	}()
+	dir := t.TempDir()
+
+	for _, pkg := range []PackageType{LogrusType, ZapType, SlogType} {
+		loggerMutex.Lock()
+		logger = nil
+		loggerMutex.Unlock()
+
+		cfg := DefaultLoggerCfg()
+		cfg.LogPackage = pkg
+		cfg.FileLocation = filepath.Join(dir, string(pkg)+"-info.log")
+		if err := ReloadConfiguration(cfg); err != nil {
+			t.Fatalf("Failed to initialize %s logger: %s\n", pkg, err.Error())
+		}
+		Debug("dropped debug")
+		Info("before reload")
+		derived := With(String("request", "derived"))
+
+		reloaded := cfg
+		reloaded.LogLevel = DebugType
+		reloaded.FileLocation = filepath.Join(dir, string(pkg)+"-debug.log")
+		if err := ReloadConfiguration(reloaded); err != nil {
+			t.Fatalf("Failed to reload %s logger: %s\n", pkg, err.Error())
+		}
+		Debug("after reload")
+		derived.Debug("derived after reload")
+
+		invalid := reloaded
+		invalid.LogLevel = "verbose"
+		if err := ReloadConfiguration(invalid); err == nil {
+			t.Errorf("%s reload with invalid level should fail\n", pkg)
+		}
+		if current := CurrentConfiguration(); current.LogLevel != DebugType ||
+			current.FileLocation != reloaded.FileLocation {
+			t.Errorf("Expected %s reloaded configuration kept, got %s %s\n",
+				pkg, current.LogLevel, current.FileLocation)
+		}
+		Debug("kept logger")
+		if err := Close(); err != nil {
+			t.Errorf("Failed to close %s logger: %s\n", pkg, err.Error())
+		}
 
-	if !bytes.Equal(actual, expected) {
-		t.FailNow()
+		before, _ := ioutil.ReadFile(cfg.FileLocation)
+		after, _ := ioutil.ReadFile(reloaded.FileLocation)
+		if !strings.Contains(string(before), "before reload") ||
+			strings.Contains(string(before), "dropped debug") ||
+			strings.Contains(string(before), "after reload") {
+			t.Errorf("Unexpected %s records before reload: %s\n", pkg, before)
+		}
+		if !strings.Contains(string(after), "after reload") ||
+			!strings.Contains(string(after), "kept logger") ||
+			!strings.Contains(string(after), "derived after reload") {
+			t.Errorf("Unexpected %s records after reload: %s\n", pkg, after)
+		}
 	}
 }
 
-func setupPubsub(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration) {
-
-	if testing.Short() {
-		// Skip Pubsub tests in short mode
+func TestSignalCatcher(t *testing.T) {
+	if testinit || testenv {
 		t.SkipNow()
 	}
-	time.Sleep(5 * time.Second)
+	for i := 0; i < 3; i++ {
+		cfg := *DefaultCompleteCfg()
+		cfg.EnableFile = false
+		log, err := NewLogger(cfg)
+		if err != nil {
+			t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+		}
+		log.Close()
+	}
+	stacks := make([]byte, 1<<20)
+	stacks = stacks[:runtime.Stack(stacks, true)]
+	if n := bytes.Count(stacks, []byte(packagePath+".signalCatcher(")); n != 1 {
+		t.Errorf("Expected a single signal catcher, got %d\n", n)
+	}
 }
 
-func checkPubsub(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration) {
-	var actual []byte
-	var message string
-
-	var (
-		brokers = []string{"localhost:9092"}
-		group   = "testgroup"
-		topics  = []string{"logs", "test"}
-		config  = cluster.NewConfig()
-	)
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	consumer, err := cluster.NewConsumer(brokers, group, topics, config)
-	if err != nil {
-		t.Errorf("Failed to initialize consumer: %s\n", err.Error())
+func TestWatchConfiguration(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
 	}
-	defer consumer.Close()
+	saved := logger
+	defer func() {
+		loggerMutex.Lock()
+		logger = saved
+		loggerMutex.Unlock()
+	}()
 
-	time.Sleep(2 * time.Second)
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	done := time.After(2 * time.Second)
-readpubsub:
-	for {
-		select {
-		case msg := <-consumer.Messages():
-			consumer.MarkOffset(msg, "kafka-test")
-			message = fmt.Sprintf("T:%s P:%d K:%s V:%s\n",
-				msg.Topic, msg.Partition, msg.Key, msg.Value)
-			actual = append(actual, message...)
-			if *debug {
-				t.Log(message)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(ConfigTypeEnvName, string(FileConfig))
+	t.Setenv(ConfigFileEnvName, "pr_reload_test")
+	cfgFile := filepath.Join(home, "pr_reload_test.yaml")
+	writeConfig := func(level LevelType) {
+		data := fmt.Sprintf("logpackage: zap\nloglevel: %s\n"+
+			"enableconsole: false\nfilelocation: %s\n",
+			level, filepath.Join(home, string(level)+".log"))
+		if err := ioutil.WriteFile(cfgFile, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %s\n", err.Error())
+		}
+	}
+	current := func() Logger {
+		loggerMutex.RLock()
+		defer loggerMutex.RUnlock()
+		return logger.(*reloadLogger).current()
+	}
+	waitReload := func(prev Logger, what string) Logger {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if l := current(); l != prev {
+				return l
 			}
-		case err := <-consumer.Errors():
-			t.Logf("Consumer message error: %s\n", err.Error())
-		case <-interrupt:
-			t.Logf("Consumer caught interrupt signal\n")
-			break readpubsub
-		case <-done:
-			break readpubsub
+			time.Sleep(5 * time.Millisecond)
 		}
+		t.Fatalf("Logger not reloaded on %s\n", what)
+		return nil
 	}
 
-	pub := filepath.Join("testdata", name+".pub")
-	ioutil.WriteFile(pub, actual, 0644)
-
-	golden := filepath.Join("testdata", name+".golden")
-	if *update {
-		t.Logf("Updating %s\n", golden)
-		ioutil.WriteFile(golden, actual, 0644)
+	writeConfig(InfoType)
+	if err := Reload(); err != nil {
+		t.Fatalf("Failed to load config: %s\n", err.Error())
+	}
+	if found := findConfigFile("pr_reload_test"); found != cfgFile {
+		t.Errorf("Expected config file %s, found %s\n", cfgFile, found)
 	}
+	stop := WatchConfiguration(10 * time.Millisecond)
+	defer stop()
 
-	expected, err := ioutil.ReadFile(golden)
-	if err != nil {
-		t.Fatalf("Failed to read file %s: %s\n", golden, err.Error())
+	// file change
+	first := current()
+	writeConfig(DebugType)
+	second := waitReload(first, "file change")
+	if CurrentConfiguration().LogLevel != DebugType {
+		t.Errorf("Expected reloaded level %s, got %s\n", DebugType,
+			CurrentConfiguration().LogLevel)
 	}
 
-	if !bytes.Equal(actual, expected) {
-		t.FailNow()
+	// SIGHUP
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("Failed to send SIGHUP: %s\n", err.Error())
 	}
+	waitReload(second, "SIGHUP")
+
+	stop()
+	stop()
+	Close()
 }
 
-func getConfiguration(t *testing.T, testname string,
-	prefix string) LoggerConfiguration {
-	var cfg LoggerConfiguration
-	var err error
+func TestKafkaSpool(t *testing.T) {
+	if testinit || testenv {
+		t.SkipNow()
+	}
+	defer ResetMockBroker()
+	ResetMockBroker()
+	collector := &testCollector{counts: make(map[string]int)}
+	SetMetricsCollector(collector)
+	defer SetMetricsCollector(nil)
 
-	if testenv {
-		cfg, err = GetLoggerConfiguration(EnvConfig, "")
-	} else if testinit {
-		cfg = globalLoggerConfiguration
-	} else {
-		cfg, err = readConfiguration(t, testname)
+	dir := t.TempDir()
+	cfg := DefaultProducerCfg()
+	cfg.EnableMock = true
+	cfg.SpoolDir = dir
+	cfg.SpoolFileBytes = 64
+	cfg.SpoolRetryFreq = 10 * time.Millisecond
+	segments := func() int {
+		entries, _ := ioutil.ReadDir(dir)
+		return len(entries)
 	}
-	if err != nil {
-		t.FailNow()
+	waitMessages := func(count int) []MockMessage {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if messages := MockBrokerMessages(); len(messages) >= count {
+				return messages
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Spooled messages not replayed: %v\n", MockBrokerMessages())
+		return nil
 	}
-	return cfg
-}
 
-func runTests(t *testing.T, name string, pkg string,
-	cfg LoggerConfiguration) {
-	var err error
-
-	topicTest := regexp.MustCompile("Topic").MatchString(name)
-	if testinit {
-		err = executeInitTests(t, cfg)
-	} else if topicTest {
-		err = executeTopicTests(t, cfg, "test")
-	} else {
-		err = executeTests(t, cfg)
-	}
+	sender, err := NewSender(cfg)
 	if err != nil {
-		t.FailNow()
+		t.Fatalf("Failed to create sender: %s\n", err.Error())
 	}
-}
-
-func testHarness(t *testing.T, name string, prefix string, pkg string,
-	console bool, logfile bool, pubsub bool) {
-	var conOutput *os.File
-	var logOutput *os.File
-
-	cfg := getConfiguration(t, name, prefix)
-
-	if console {
-		conOutput = setupConsole(t, name, pkg, cfg)
+	MockBrokerDown(true)
+	var callbackErrs []error
+	var callbackMutex sync.Mutex
+	callback := func(partition, offset int64, err error) {
+		callbackMutex.Lock()
+		defer callbackMutex.Unlock()
+		callbackErrs = append(callbackErrs, err)
 	}
-	if logfile {
-		logOutput = setupLogfile(t, name, pkg, cfg)
+	for _, value := range []string{"one", "two", "three"} {
+		if err := sender.SendWithCallback("", "", []byte(value), callback); err != nil {
+			t.Fatalf("Send failed while spooling: %s\n", err.Error())
+		}
+		sender.Flush(time.Second)
 	}
-	if pubsub {
-		setupPubsub(t, name, pkg, cfg)
+	if len(MockBrokerMessages()) != 0 {
+		t.Errorf("Unexpected messages while broker down\n")
 	}
-
-	runTests(t, name, pkg, cfg)
-
-	if console {
-		checkConsole(t, name, pkg, cfg, conOutput)
+	if segments() < 2 {
+		t.Errorf("Expected rotated spool segments, got %d\n", segments())
 	}
-	if logfile {
-		checkLogfile(t, name, pkg, cfg, logOutput)
+	callbackMutex.Lock()
+	for _, err := range callbackErrs {
+		if err != ErrSpooled {
+			t.Errorf("Expected ErrSpooled callback, got %v\n", err)
+		}
 	}
-	if pubsub {
-		checkPubsub(t, name, pkg, cfg)
+	if len(callbackErrs) != 3 {
+		t.Errorf("Expected 3 callbacks, got %d\n", len(callbackErrs))
 	}
-}
-
-const (
-	tNil = ""
-	tLru = "Logrus"
-	tZap = "Zap"
-	tCon = "Console"
-	tLog = "Logfile"
-	tPub = "Pubsub"
-	tIni = "Init"
-	tEnv = "Env"
-)
-
-type TestCases struct {
-	Prefix string
-	Pkg    string
-	Con    string
-	Log    string
-	Pub    string
-	Test   string
-	Desc   string
-}
-
-func runTestCases(t *testing.T, testCases []TestCases) {
-	for _, tc := range testCases {
-		name := fmt.Sprintf("%s%s%s%s%s%s",
-			tc.Prefix, tc.Pkg, tc.Con, tc.Log, tc.Pub, tc.Test)
+	callbackMutex.Unlock()
 
-		if *explicit != "" && *explicit != name {
-			t.SkipNow()
-		}
-		t.Run(name, func(t *testing.T) {
-			testHarness(t, name, tc.Prefix, tc.Pkg, tc.Con == tCon,
-				tc.Log == tLog, tc.Pub == tPub)
-		})
+	// replayed in order once reachable
+	MockBrokerDown(false)
+	messages := waitMessages(3)
+	if len(messages) != 3 || messages[0].Value != "one" ||
+		messages[1].Value != "two" || messages[2].Value != "three" {
+		t.Errorf("Unexpected replayed messages: %v\n", messages)
 	}
-}
-
-func TestConsole(t *testing.T) {
-	var testCases = []TestCases{
-		{tNil, tLru, tCon, tNil, tNil, "Default",
-			"logrus logger to console with default config"},
-		{tNil, tZap, tCon, tNil, tNil, "Default",
-			"zap logger to console with default config"},
+	sender.Send("", "", []byte("direct"))
+	sender.Flush(time.Second)
+	if messages := MockBrokerMessages(); len(messages) != 4 ||
+		messages[3].Value != "direct" {
+		t.Errorf("Expected message sent once replayed: %v\n", messages)
 	}
-	if testinit || testenv {
-		t.SkipNow()
+	// the callbacks are called again once the spooled messages are sent
+	callbackMutex.Lock()
+	if len(callbackErrs) != 6 || callbackErrs[3] != nil ||
+		callbackErrs[4] != nil || callbackErrs[5] != nil {
+		t.Errorf("Expected replayed callbacks, got %v\n", callbackErrs)
 	}
-	runTestCases(t, testCases)
-}
+	callbackMutex.Unlock()
 
-func TestLogfile(t *testing.T) {
-	var testCases = []TestCases{
-		{tNil, tLru, tNil, tLog, tNil, "Default",
-			"logrus logger to file with default config"},
-		{tNil, tZap, tNil, tLog, tNil, "Default",
-			"zap logger to file with default config"},
+	// spooled messages outlive the producer
+	MockBrokerDown(true)
+	sender.Send("", "", []byte("four"))
+	sender.Flush(time.Second)
+	sender.Close()
+	MockBrokerDown(false)
+	if sender, err = NewSender(cfg); err != nil {
+		t.Fatalf("Failed to recreate sender: %s\n", err.Error())
 	}
-	if testinit || testenv {
-		t.SkipNow()
+	if messages := waitMessages(5); messages[4].Value != "four" {
+		t.Errorf("Expected spooled message replayed by new producer: %v\n",
+			messages)
+	}
+	sender.Close()
+	if segments() != 0 {
+		t.Errorf("Expected empty spool, got %d segments\n", segments())
 	}
-	runTestCases(t, testCases)
-}
 
-func TestPubsub(t *testing.T) {
-	var testCases = []TestCases{
-		{tNil, tLru, tNil, tNil, tPub, "Default",
-			"logrus logger to kafka with default config"},
-		{tNil, tZap, tNil, tNil, tPub, "Default",
-			"zap logger to kafka with default config"},
-		{tNil, tLru, tNil, tNil, tPub, "Topic",
-			"logrus logger to kafka with test topic"},
-		{tNil, tZap, tNil, tNil, tPub, "Topic",
-			"zap logger to kafka with test topic"},
-		{tNil, tLru, tNil, tNil, tPub, "IncrID",
-			"logrus logger to kafka with incremental ID"},
-		{tNil, tZap, tNil, tNil, tPub, "IncrID",
-			"zap logger to kafka with incremental ID"},
+	// messages dropped once the spool is full
+	cfg.SpoolMaxBytes = 16
+	if sender, err = NewSender(cfg); err != nil {
+		t.Fatalf("Failed to recreate sender: %s\n", err.Error())
 	}
-	if testinit || testenv || testshort {
-		t.SkipNow()
+	MockBrokerDown(true)
+	sender.Send("", "", []byte("too large to spool"))
+	sender.Flush(time.Second)
+	if err := sender.Send("", "", []byte("dropped")); err != ErrSpoolFull {
+		t.Errorf("Expected ErrSpoolFull, got %v\n", err)
 	}
-	runTestCases(t, testCases)
-}
+	sender.Close()
 
-func TestEnv(t *testing.T) {
-	var testCases = []TestCases{
-		{tEnv, tLru, tCon, tNil, tNil, "Default", tNil},
-		{tEnv, tLru, tNil, tLog, tNil, "Default", tNil},
-		{tEnv, tLru, tNil, tNil, tPub, "Default", tNil},
-		{tEnv, tZap, tCon, tNil, tNil, "Default", tNil},
-		{tEnv, tZap, tNil, tLog, tNil, "Default", tNil},
-		{tEnv, tZap, tNil, tNil, tPub, "Default", tNil},
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	if collector.counts[MetricSpooled+"map[]"] != 4 ||
+		collector.counts[MetricReplayed+"map[]"] != 4 ||
+		collector.counts[MetricDropped+"map[reason:"+DropSpool+"]"] != 2 ||
+		collector.counts[MetricDropped+"map[reason:"+DropDelivery+"]"] != 0 {
+		t.Errorf("Unexpected spool metrics: %v\n", collector.counts)
 	}
-	if !testenv {
-		t.SkipNow()
+
+	cfg.SpoolFileBytes = 0
+	logCfg := *DefaultCompleteCfg()
+	logCfg.EnableFile = false
+	logCfg.EnableKafka = true
+	logCfg.KafkaProducerCfg = cfg
+	if _, err := NewLogger(logCfg); err == nil {
+		t.Errorf("Expected error for zero SpoolFileBytes\n")
 	}
-	runTestCases(t, testCases)
-}
 
-func TestInit(t *testing.T) {
-	var testCases = []TestCases{
-		{tIni, tLru, tCon, tNil, tNil, "Default", tNil},
-		{tIni, tLru, tNil, tLog, tNil, "Default", tNil},
-		{tIni, tLru, tNil, tNil, tPub, "Default", tNil},
-		{tIni, tZap, tCon, tNil, tNil, "Default", tNil},
-		{tIni, tZap, tNil, tLog, tNil, "Default", tNil},
-		{tIni, tZap, tNil, tNil, tPub, "Default", tNil},
+	// the messages not sent are kept in the segment replayed
+	cfg.SpoolDir = t.TempDir()
+	cfg.SpoolMaxBytes = 1024
+	cfg.SpoolFileBytes = 1024
+	journal, err := newSpool(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create spool: %s\n", err.Error())
 	}
-	if !testinit {
-		t.SkipNow()
+	for _, value := range []string{"one", "two", "three"} {
+		journal.write(&sarama.ProducerMessage{Value: sarama.StringEncoder(value),
+			Metadata: messageMeta{callback: callback}})
+	}
+	segment, _ := journal.next()
+	spooled, _ := readSegment(segment.path)
+	if err := journal.keep(segment, spooled, []int{2, 0}); err != nil {
+		t.Fatalf("Failed to keep messages: %s\n", err.Error())
+	}
+	kept, _ := readSegment(segment.path)
+	if len(kept) != 2 || string(kept[0].Value.(sarama.ByteEncoder)) != "one" ||
+		string(kept[1].Value.(sarama.ByteEncoder)) != "three" ||
+		len(journal.segmentCallbacks(segment)) != 2 {
+		t.Errorf("Expected first and third messages kept, got %v\n", kept)
+	}
+	info, _ := os.Stat(segment.path)
+	if journal.size != info.Size() || journal.segments[0].size != info.Size() {
+		t.Errorf("Expected spool size %d, got %d\n", info.Size(), journal.size)
 	}
-	runTestCases(t, testCases)
 }
@@ -3,6 +3,7 @@
 package logger
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
@@ -15,12 +16,14 @@ import (
 type logrusLogger struct {
 	logger    *logrus.Logger
 	kafkaHook *LogrusKafkaHook
+	outputs   *logOutputs
 }
 
 // logrusLogEntry provides object for logrus logger with Entry set by WithFields
 type logrusLogEntry struct {
 	entry     *logrus.Entry
 	kafkaHook *LogrusKafkaHook
+	outputs   *logOutputs
 }
 
 // ceFormatter provides wrapper for the JSONFormatter (to insert CE fields)
@@ -34,9 +37,18 @@ func (ce *ceFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	// CE fields are added here, not by using WithFields at logger level
 	// make a deep copy of entry with the CE fields to format
 	// modifying entry directly would affect other formatters
-	ceEntry := entry.WithFields(ce.fields)
+	fields := ce.fields
+	if source := contextCESource(entry.Context); source != "" {
+		fields = logrus.Fields{}
+		for key, val := range ce.fields {
+			fields[key] = val
+		}
+		fields[CESourceKey] = source
+	}
+	ceEntry := entry.WithFields(fields)
 	ceEntry.Level = entry.Level
 	ceEntry.Message = entry.Message
+	ceEntry.Caller = entry.Caller
 	msg, err := ce.JSONFormatter.Format(ceEntry)
 	if err != nil {
 		return nil, err
@@ -53,11 +65,14 @@ func getFormatter(format FormatType, config LoggerConfiguration,
 		return &logrus.JSONFormatter{
 			DisableTimestamp: !config.EnableTimeStamps,
 			TimestampFormat:  time.RFC3339,
+			FieldMap:         getLogrusFieldMap(config.FieldMap),
+			CallerPrettyfier: logrusCallerPrettyfier,
 		}
 	case CEFormat:
 		// Change keys for cloudevents
-		fieldmap := logrus.FieldMap{}
+		fieldmap := getLogrusFieldMap(config.FieldMap)
 		if config.EnableCloudEvents {
+			fieldmap[logrus.FieldKeyTime] = CETimeKey
 			fieldmap[logrus.FieldKeyMsg] = CEDataKey
 			if config.CloudEventsCfg.SetSubjectLevel {
 				fieldmap[logrus.FieldKeyLevel] = CESubjectKey
@@ -72,6 +87,7 @@ func getFormatter(format FormatType, config LoggerConfiguration,
 				DisableTimestamp: !config.EnableTimeStamps,
 				TimestampFormat:  time.RFC3339,
 				FieldMap:         fieldmap,
+				CallerPrettyfier: logrusCallerPrettyfier,
 			},
 			ceFields,
 		}
@@ -82,6 +98,8 @@ func getFormatter(format FormatType, config LoggerConfiguration,
 			DisableTimestamp: !config.EnableTimeStamps,
 			TimestampFormat:  time.RFC3339,
 			FullTimestamp:    true,
+			FieldMap:         getLogrusFieldMap(config.FieldMap),
+			CallerPrettyfier: logrusCallerPrettyfier,
 		}
 		// these settings create identical output for ttys and logs
 		if config.EnableColorLevels {
@@ -93,11 +111,50 @@ func getFormatter(format FormatType, config LoggerConfiguration,
 	}
 }
 
+// getLogrusFieldMap converts field map to logrus type
+func getLogrusFieldMap(fields FieldMap) logrus.FieldMap {
+	fieldmap := logrus.FieldMap{
+		logrus.FieldKeyFunc: CallerFunctionKey,
+		logrus.FieldKeyFile: CallerKey,
+	}
+	for key, name := range fields {
+		if name == "" {
+			continue
+		}
+		switch key {
+		case FieldKeyTime:
+			fieldmap[logrus.FieldKeyTime] = name
+		case FieldKeyLevel:
+			fieldmap[logrus.FieldKeyLevel] = name
+		case FieldKeyMsg:
+			fieldmap[logrus.FieldKeyMsg] = name
+		}
+	}
+	return fieldmap
+}
+
+// getLogrusLevel converts log level to logrus log level
+func getLogrusLevel(level LevelType) logrus.Level {
+	logrusLevel, err := logrus.ParseLevel(string(level))
+	if err != nil {
+		return logrus.InfoLevel
+	}
+	return logrusLevel
+}
+
 // newLogrusLogger return a logrus logger instance
 func newLogrusLogger(config LoggerConfiguration) (Logger, error) {
 	var kafkaHook *LogrusKafkaHook
 	var cloudEvents *CloudEvents
 	var fields LogFields
+	outputs := &logOutputs{}
+	// the outputs opened are closed if a later output fails
+	created := false
+	defer func() {
+		if !created {
+			outputs.close()
+		}
+	}()
 
 	logLevel := config.LogLevel
 	if logLevel == "" {
@@ -107,6 +164,34 @@ func newLogrusLogger(config LoggerConfiguration) (Logger, error) {
 	if err != nil {
 		return nil, err
 	}
+	consoleLevel := getLogrusLevel(config.outputLevel(config.ConsoleLevel))
+	fileLevel := getLogrusLevel(config.outputLevel(config.FileLevel))
+	kafkaLevel := getLogrusLevel(config.outputLevel(config.KafkaLevel))
+
+	// logger level must enable the most verbose output level
+	outputLevels := []logrus.Level{}
+	if config.EnableConsole {
+		outputLevels = append(outputLevels, consoleLevel)
+	}
+	if config.EnableFile {
+		outputLevels = append(outputLevels, fileLevel)
+	}
+	if config.EnableKafka {
+		outputLevels = append(outputLevels, kafkaLevel)
+	}
+	for _, fileCfg := range config.Files {
+		outputLevels = append(outputLevels,
+			getLogrusLevel(config.outputLevel(fileCfg.Level)))
+	}
+	for _, sinkCfg := range config.sinks() {
+		outputLevels = append(outputLevels,
+			getLogrusLevel(config.outputLevel(sinkCfg.Level)))
+	}
+	for _, outputLevel := range outputLevels {
+		if outputLevel > level {
+			level = outputLevel
+		}
+	}
 
 	// set default to discard for kafka only, otherwise overridden
 	lLogger := &logrus.Logger{
@@ -115,45 +200,83 @@ func newLogrusLogger(config LoggerConfiguration) (Logger, error) {
 		Hooks:        make(logrus.LevelHooks),
 		Level:        level,
 		ExitFunc:     os.Exit,
-		ReportCaller: false,
+		ReportCaller: config.EnableCaller,
+	}
+	// entry time is set from the logger clock before other hooks fire
+	lLogger.Hooks.Add(&LogrusClockHook{})
+	if config.EnableCaller {
+		// the caller is set before the output hooks fire
+		lLogger.Hooks.Add(&LogrusCallerHook{config.CallerSkip})
+	}
+	// lazy fields are computed before other hooks fire
+	lLogger.Hooks.Add(&LogrusLazyHook{})
+	if config.EnableStackTrace {
+		lLogger.Hooks.Add(newLogrusStackHook(config.stackTraceLevel()))
+	}
+	if limits := config.fieldLimits(); limits.enabled() {
+		// fields are limited before other hooks fire
+		lLogger.Hooks.Add(&LogrusGuardHook{limits})
 	}
 
 	if config.EnableCloudEvents {
-		cloudEvents = newCloudEvents(config.CloudEventsCfg)
+		cloudEvents = newCloudEvents(config.CloudEventsCfg, config.FieldMap)
 		fields = cloudEvents.fields
 	}
 
 	if config.EnableFile {
 		var fwriter io.Writer
 		var err error
-		fileLocation := config.FileLocation
-		if fileLocation == "" {
-			fileLocation = defaultLoggerConfiguration.FileLocation
-		}
 		if config.EnableRotation {
-			fwriter = rotationLogger(fileLocation, config.RotationCfg)
+			fwriter = rotationLogger(config.fileLocation(), config.RotationCfg)
 		} else {
-			fwriter, err = os.OpenFile(fileLocation,
-				os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			fwriter, err = config.openLogFile()
 			if err != nil {
 				return nil, err
 			}
 		}
-		lLogger.SetOutput(fwriter)
-		lLogger.SetFormatter(getFormatter(config.FileFormat, config, fields))
-	} else if config.EnableConsole {
-		var cwriter io.Writer
-		if debugCapture != nil {
-			cwriter = debugCapture
-		} else if config.ConsoleWriter == Stderr {
-			cwriter = os.Stderr
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if config.FileFormat == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
+		formatter := getFormatter(config.FileFormat, config, fields)
+		if fileLevel == level {
+			lLogger.SetOutput(fwriter)
+			lLogger.SetFormatter(formatter)
 		} else {
-			cwriter = os.Stdout
+			// use hook to filter file output by level
+			hook := newLogrusConsoleHook(fwriter, formatter, fileLevel)
+			lLogger.Hooks.Add(hook)
+		}
+	}
+
+	// additional files filtered by level with hooks
+	for _, fileCfg := range config.Files {
+		fwriter, err := config.openFileSink(fileCfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if fileCfg.Format == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
+		formatter := getFormatter(fileCfg.Format, config, fields)
+		lLogger.Hooks.Add(newLogrusConsoleHook(fwriter, formatter,
+			getLogrusLevel(config.outputLevel(fileCfg.Level))))
+	}
+
+	if config.EnableConsole {
+		cwriter := outputs.asyncOutput(config.consoleOutput(), config)
+		if config.consoleFormat() == CEFormat && cloudEvents != nil {
+			cwriter = newCEWriter(cwriter, cloudEvents)
 		}
-		formatter := getFormatter(config.ConsoleFormat, config, fields)
-		if config.EnableFile {
-			// use hook to provide separate formatting for console
-			hook := newLogrusConsoleHook(cwriter, formatter)
+		formatter := getFormatter(config.consoleFormat(), config, fields)
+		if lLogger.Out != ioutil.Discard || consoleLevel != level {
+			// use hook to provide separate formatting and level for console
+			hook := newLogrusConsoleHook(cwriter, formatter, consoleLevel)
 			lLogger.Hooks.Add(hook)
 		} else {
 			// otherwise override logger defaults with console settings
@@ -165,23 +288,51 @@ func newLogrusLogger(config LoggerConfiguration) (Logger, error) {
 	if config.EnableKafka {
 		formatter := getFormatter(config.KafkaFormat, config, fields)
 		kafkaHook, err = newLogrusKafkaHook(config.KafkaProducerCfg,
-			cloudEvents, config.CloudEventsCfg, formatter)
+			cloudEvents, config.CloudEventsCfg, config.FieldMap, formatter,
+			kafkaLevel)
+		if err != nil {
+			return nil, err
+		}
+		// add the hook, sampled by a wrapping hook if enabled
+		if config.EnableSampling {
+			lLogger.Hooks.Add(newLogrusSamplingHook(kafkaHook,
+				newSampler(config.SamplingCfg)))
+		} else {
+			lLogger.Hooks.Add(kafkaHook)
+		}
+		outputs.kafka = kafkaHook.kp
+	}
+
+	for _, sinkCfg := range config.sinks() {
+		sink, err := newSink(sinkCfg)
 		if err != nil {
 			return nil, err
 		}
-		// add the hook
-		lLogger.Hooks.Add(kafkaHook)
+		outputs.addFlusher(sink)
+		outputs.addCloser(sink)
+		var sinkCE *CloudEvents
+		if sinkCfg.Format == CEFormat {
+			sinkCE = cloudEvents
+		}
+		formatter := getFormatter(sinkCfg.Format, config, fields)
+		lLogger.Hooks.Add(newLogrusSinkHook(newSinkWriter(sink, sinkCE),
+			formatter, getLogrusLevel(config.outputLevel(sinkCfg.Level))))
 	}
 
+	// use hook to count messages logged per level
+	lLogger.Hooks.Add(&LogrusMetricsHook{})
+
 	if config.EnableDebug {
 		// use hook to provide log entry printing
 		hook := &LogrusDebugHook{}
 		lLogger.Hooks.Add(hook)
 	}
 
+	created = true
 	return &logrusLogger{
 		logger:    lLogger,
 		kafkaHook: kafkaHook,
+		outputs:   outputs,
 	}, nil
 }
 
@@ -264,7 +415,7 @@ func (l *logrusLogger) Panic(args ...interface{}) {
 }
 
 func (l *logrusLogger) Panicf(format string, args ...interface{}) {
-	l.logger.Fatalf(format, args...)
+	l.logger.Panicf(format, args...)
 }
 
 func (l *logrusLogger) Panicln(args ...interface{}) {
@@ -272,22 +423,136 @@ func (l *logrusLogger) Panicln(args ...interface{}) {
 }
 
 // WithFields adds more fields to logger, uses logrusLogEntry
+// The kafka hook is inherited so kafka methods work on the derived logger
 func (l *logrusLogger) WithFields(fields LogFields) Logger {
 	return &logrusLogEntry{
-		entry: l.logger.WithFields(convertToLogrusFields(fields)),
+		entry:     l.logger.WithFields(convertToLogrusFields(fields)),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithError adds the error to each log record with the ErrorKey key
+func (l *logrusLogger) WithError(err error) Logger {
+	return l.With(Err(err))
+}
+
+// WithLazyFields adds fields computed for each record emitted
+func (l *logrusLogger) WithLazyFields(fieldsFn func() LogFields) Logger {
+	return &logrusLogEntry{
+		entry: l.logger.WithField(lazyFieldsKey,
+			lazyFields{[]func() LogFields{fieldsFn}}),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
 	}
 }
 
-// WithKafkaFilterFn adds a filter function for each kafka record
+// WithSampledFields adds the fields with their values kept on a rate
+// fraction of records and hashed on the others, e.g. high cardinality URLs
+func (l *logrusLogger) WithSampledFields(rate float64,
+	keyValues LogFields) Logger {
+	return l.WithLazyFields(sampledFields(rate, keyValues))
+}
+
+// With adds typed fields to each log record
+func (l *logrusLogger) With(fields ...Field) Logger {
+	return l.WithFields(fieldsToLogFields(fields))
+}
+
+// WithTopic sends each kafka record to the topic, not added to the record
+func (l *logrusLogger) WithTopic(topic string) Logger {
+	ctx := withRoute(nil, &kafkaRoute{topic: topic})
+	return &logrusLogEntry{
+		entry:     l.logger.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithCESource sets the cloudevents source of each record
+func (l *logrusLogger) WithCESource(source string) Logger {
+	return &logrusLogEntry{
+		entry:     l.logger.WithContext(withCESource(nil, source)),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithKafkaFilterFn adds a filter function for each kafka record of the
+// derived logger, the logger is unchanged
 func (l *logrusLogger) WithKafkaFilterFn(filterFn FilterFunc) Logger {
-	l.kafkaHook.kp.config.filterFn = filterFn
-	return l
+	ctx := withRoute(nil, &kafkaRoute{filterFn: filterFn})
+	return &logrusLogEntry{
+		entry:     l.logger.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
 }
 
-// WithKafkaKeyFn adds a key function for each kafka record
+// WithKafkaKeyFn adds a key function for each kafka record of the derived
+// logger, the logger is unchanged
 func (l *logrusLogger) WithKafkaKeyFn(keyFn KeyFunc) Logger {
-	l.kafkaHook.kp.config.keyFn = keyFn
-	return l
+	ctx := withRoute(nil, &kafkaRoute{keyFn: keyFn})
+	return &logrusLogEntry{
+		entry:     l.logger.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithKafkaPartitionFn adds a partition function for each kafka record of
+// the derived logger, the logger is unchanged
+func (l *logrusLogger) WithKafkaPartitionFn(partitionFn PartitionFunc) Logger {
+	ctx := withRoute(nil, &kafkaRoute{partitionFn: partitionFn})
+	return &logrusLogEntry{
+		entry:     l.logger.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// Flush waits for buffered records to be sent
+func (l *logrusLogger) Flush(timeout time.Duration) error {
+	return l.outputs.flush(timeout)
+}
+
+// Close flushes and closes all outputs, the logger must not be used after
+func (l *logrusLogger) Close() error {
+	return l.outputs.close()
+}
+
+// WithContext adds fields extracted from the context to each log record
+func (l *logrusLogger) WithContext(ctx context.Context) Logger {
+	return &logrusLogEntry{
+		entry: l.logger.WithContext(ctx).WithFields(
+			convertToLogrusFields(contextFields(ctx))),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+func (l *logrusLogger) DebugCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Debug(args...)
+}
+
+func (l *logrusLogger) InfoCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Info(args...)
+}
+
+func (l *logrusLogger) WarnCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Warn(args...)
+}
+
+func (l *logrusLogger) ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Error(args...)
+}
+
+func (l *logrusLogger) FatalCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Fatal(args...)
+}
+
+func (l *logrusLogger) PanicCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Panic(args...)
 }
 
 func (l *logrusLogEntry) Print(args ...interface{}) {
@@ -367,7 +632,7 @@ func (l *logrusLogEntry) Panic(args ...interface{}) {
 }
 
 func (l *logrusLogEntry) Panicf(format string, args ...interface{}) {
-	l.entry.Fatalf(format, args...)
+	l.entry.Panicf(format, args...)
 }
 
 func (l *logrusLogEntry) Panicln(args ...interface{}) {
@@ -377,20 +642,142 @@ func (l *logrusLogEntry) Panicln(args ...interface{}) {
 // WithFields adds more fields to logger with Entry
 func (l *logrusLogEntry) WithFields(fields LogFields) Logger {
 	return &logrusLogEntry{
-		entry: l.entry.WithFields(convertToLogrusFields(fields)),
+		entry:     l.entry.WithFields(convertToLogrusFields(fields)),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithError adds the error to each log record with the ErrorKey key
+func (l *logrusLogEntry) WithError(err error) Logger {
+	return l.With(Err(err))
+}
+
+// WithLazyFields adds fields computed for each record emitted
+func (l *logrusLogEntry) WithLazyFields(fieldsFn func() LogFields) Logger {
+	lazy, _ := l.entry.Data[lazyFieldsKey].(lazyFields)
+	fieldsFns := append(append([]func() LogFields{}, lazy.fieldsFns...),
+		fieldsFn)
+	return &logrusLogEntry{
+		entry:     l.entry.WithField(lazyFieldsKey, lazyFields{fieldsFns}),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
 	}
 }
 
-// WithKafkaFilterFn adds a filter function for each kafka record
+// WithSampledFields adds the fields with their values kept on a rate
+// fraction of records and hashed on the others, e.g. high cardinality URLs
+func (l *logrusLogEntry) WithSampledFields(rate float64,
+	keyValues LogFields) Logger {
+	return l.WithLazyFields(sampledFields(rate, keyValues))
+}
+
+// With adds typed fields to each log record
+func (l *logrusLogEntry) With(fields ...Field) Logger {
+	return l.WithFields(fieldsToLogFields(fields))
+}
+
+// WithTopic sends each kafka record to the topic, not added to the record
+func (l *logrusLogEntry) WithTopic(topic string) Logger {
+	ctx := withRoute(l.entry.Context, &kafkaRoute{topic: topic})
+	return &logrusLogEntry{
+		entry:     l.entry.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithCESource sets the cloudevents source of each record
+func (l *logrusLogEntry) WithCESource(source string) Logger {
+	return &logrusLogEntry{
+		entry:     l.entry.WithContext(withCESource(l.entry.Context, source)),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithKafkaFilterFn adds a filter function for each kafka record of the
+// derived logger, the logger is unchanged
 func (l *logrusLogEntry) WithKafkaFilterFn(filterFn FilterFunc) Logger {
-	l.kafkaHook.kp.config.filterFn = filterFn
-	return l
+	ctx := withRoute(l.entry.Context, &kafkaRoute{filterFn: filterFn})
+	return &logrusLogEntry{
+		entry:     l.entry.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
 }
 
-// WithKafkaKeyFn adds a key function for each kafka record
+// WithKafkaKeyFn adds a key function for each kafka record of the derived
+// logger, the logger is unchanged
 func (l *logrusLogEntry) WithKafkaKeyFn(keyFn KeyFunc) Logger {
-	l.kafkaHook.kp.config.keyFn = keyFn
-	return l
+	ctx := withRoute(l.entry.Context, &kafkaRoute{keyFn: keyFn})
+	return &logrusLogEntry{
+		entry:     l.entry.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// WithKafkaPartitionFn adds a partition function for each kafka record of
+// the derived logger, the logger is unchanged
+func (l *logrusLogEntry) WithKafkaPartitionFn(partitionFn PartitionFunc) Logger {
+	ctx := withRoute(l.entry.Context, &kafkaRoute{partitionFn: partitionFn})
+	return &logrusLogEntry{
+		entry:     l.entry.WithContext(ctx),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+// Flush waits for buffered records to be sent
+func (l *logrusLogEntry) Flush(timeout time.Duration) error {
+	return l.outputs.flush(timeout)
+}
+
+// Close flushes and closes all outputs, the logger must not be used after
+func (l *logrusLogEntry) Close() error {
+	return l.outputs.close()
+}
+
+// WithContext adds fields extracted from the context to each log record
+func (l *logrusLogEntry) WithContext(ctx context.Context) Logger {
+	// keep kafka routing set by WithTopic and source set by WithCESource
+	if route := contextRoute(l.entry.Context); route != nil {
+		ctx = withRoute(ctx, route)
+	}
+	if source := contextCESource(l.entry.Context); source != "" {
+		ctx = withCESource(ctx, source)
+	}
+	return &logrusLogEntry{
+		entry: l.entry.WithContext(ctx).WithFields(
+			convertToLogrusFields(contextFields(ctx))),
+		kafkaHook: l.kafkaHook,
+		outputs:   l.outputs,
+	}
+}
+
+func (l *logrusLogEntry) DebugCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Debug(args...)
+}
+
+func (l *logrusLogEntry) InfoCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Info(args...)
+}
+
+func (l *logrusLogEntry) WarnCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Warn(args...)
+}
+
+func (l *logrusLogEntry) ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Error(args...)
+}
+
+func (l *logrusLogEntry) FatalCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Fatal(args...)
+}
+
+func (l *logrusLogEntry) PanicCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Panic(args...)
 }
 
 // convertToLogrusFields converts fields to logrus type
@@ -3,6 +3,7 @@
 package logger
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -22,11 +23,11 @@ type LogrusKafkaHook struct {
 // newLogrusKafkaHook returns a kafka producer hook instance
 func newLogrusKafkaHook(
 	kpCfg ProducerConfiguration, cloudEvents *CloudEvents,
-	ceCfg CloudEventsConfiguration,
-	fmt logrus.Formatter) (*LogrusKafkaHook, error) {
+	ceCfg CloudEventsConfiguration, fieldMap FieldMap,
+	fmt logrus.Formatter, level logrus.Level) (*LogrusKafkaHook, error) {
 
 	// create an async producer
-	kafkaProducer, err := newKafkaProducer(kpCfg, cloudEvents, ceCfg)
+	kafkaProducer, err := newKafkaProducer(kpCfg, cloudEvents, ceCfg, fieldMap)
 	if err != nil {
 		return nil, err
 	}
@@ -36,7 +37,7 @@ func newLogrusKafkaHook(
 		kp:        kafkaProducer,
 		ce:        cloudEvents,
 		formatter: fmt,
-		levels:    logrus.AllLevels,
+		levels:    getLogrusLevels(level),
 	}, nil
 }
 
@@ -56,7 +57,28 @@ func (h *LogrusKafkaHook) Fire(entry *logrus.Entry) error {
 		return errors.New("No producer defined")
 	}
 
-	return h.kp.sendMessage(msg)
+	return h.kp.sendMessage(msg, contextRoute(entry.Context))
+}
+
+// routeContextKey is the entry context key for kafka routing
+const routeContextKey contextKey = "kafkaroute"
+
+// contextRoute returns the kafka routing carried by the entry context
+func contextRoute(ctx context.Context) *kafkaRoute {
+	if ctx == nil {
+		return nil
+	}
+	route, _ := ctx.Value(routeContextKey).(*kafkaRoute)
+	return route
+}
+
+// withRoute returns the context carrying the merged kafka routing
+func withRoute(ctx context.Context, route *kafkaRoute) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, routeContextKey,
+		contextRoute(ctx).merge(route))
 }
 
 // LogrusConsoleHook provides a console hook
@@ -68,12 +90,12 @@ type LogrusConsoleHook struct {
 
 // newLogrusConsoleHook returns a debug hook instance
 func newLogrusConsoleHook(out io.Writer,
-	fmt logrus.Formatter) *LogrusConsoleHook {
+	fmt logrus.Formatter, level logrus.Level) *LogrusConsoleHook {
 	// return the console hook
 	return &LogrusConsoleHook{
 		out:       out,
 		formatter: fmt,
-		levels:    logrus.AllLevels,
+		levels:    getLogrusLevels(level),
 	}
 }
 
@@ -88,7 +110,160 @@ func (h *LogrusConsoleHook) Fire(entry *logrus.Entry) error {
 	if err != nil {
 		return err
 	}
-	fmt.Fprint(h.out, string(msg))
+	_, err = h.out.Write(msg)
+	return err
+}
+
+// LogrusSinkHook provides a sink hook
+type LogrusSinkHook struct {
+	writer    *sinkWriter
+	formatter logrus.Formatter
+	levels    []logrus.Level
+}
+
+// newLogrusSinkHook returns a sink hook instance
+func newLogrusSinkHook(writer *sinkWriter,
+	fmt logrus.Formatter, level logrus.Level) *LogrusSinkHook {
+	return &LogrusSinkHook{
+		writer:    writer,
+		formatter: fmt,
+		levels:    getLogrusLevels(level),
+	}
+}
+
+// Levels returns all log levels that are enabled
+func (h *LogrusSinkHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire passes the formatted entry to the sink
+func (h *LogrusSinkHook) Fire(entry *logrus.Entry) error {
+	msg, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	return h.writer.writeEntry(msg, Entry{
+		Level:   getLogrusLevelType(entry.Level),
+		Time:    entry.Time,
+		Message: entry.Message,
+	})
+}
+
+// getLogrusLevels returns the logrus levels enabled by the level
+func getLogrusLevels(level logrus.Level) []logrus.Level {
+	levels := []logrus.Level{}
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// getLogrusLevelType converts logrus log level to log level
+func getLogrusLevelType(level logrus.Level) LevelType {
+	switch level {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		return DebugType
+	case logrus.InfoLevel:
+		return InfoType
+	case logrus.WarnLevel:
+		return WarnType
+	case logrus.ErrorLevel:
+		return ErrorType
+	case logrus.FatalLevel:
+		return FatalType
+	default:
+		return PanicType
+	}
+}
+
+// LogrusSamplingHook provides a hook sampling the entries fired on its hook
+type LogrusSamplingHook struct {
+	hook    logrus.Hook
+	sampler *sampler
+}
+
+// newLogrusSamplingHook returns a sampling hook instance
+func newLogrusSamplingHook(hook logrus.Hook,
+	sampler *sampler) *LogrusSamplingHook {
+	return &LogrusSamplingHook{
+		hook:    hook,
+		sampler: sampler,
+	}
+}
+
+// Levels returns the log levels of the sampled hook
+func (h *LogrusSamplingHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+// Fire fires the sampled hook if the entry is allowed by the sampler
+func (h *LogrusSamplingHook) Fire(entry *logrus.Entry) error {
+	if !h.sampler.allow(getLogrusLevelType(entry.Level), entry.Message) {
+		return nil
+	}
+	return h.hook.Fire(entry)
+}
+
+// lazyFieldsKey is the entry data key holding the lazy fields functions
+const lazyFieldsKey = "_lazyfields"
+
+// lazyFields holds the functions computing the lazy fields of an entry
+type lazyFields struct {
+	fieldsFns []func() LogFields
+}
+
+// LogrusLazyHook provides a hook replacing lazy fields with the fields computed
+// Must be added before hooks that format the entry
+type LogrusLazyHook struct{}
+
+// Levels returns all log levels
+func (h *LogrusLazyHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire computes the lazy fields, entry data is a copy made for the record
+func (h *LogrusLazyHook) Fire(entry *logrus.Entry) error {
+	lazy, ok := entry.Data[lazyFieldsKey].(lazyFields)
+	if !ok {
+		return nil
+	}
+	delete(entry.Data, lazyFieldsKey)
+	for _, fieldsFn := range lazy.fieldsFns {
+		for key, val := range fieldsFn() {
+			entry.Data[key] = val
+		}
+	}
+	return nil
+}
+
+// LogrusClockHook provides a hook setting the entry time from the logger clock
+// Must be added before hooks that format the entry
+type LogrusClockHook struct{}
+
+// Levels returns all log levels
+func (h *LogrusClockHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire sets the entry time
+func (h *LogrusClockHook) Fire(entry *logrus.Entry) error {
+	entry.Time = now()
+	return nil
+}
+
+// LogrusMetricsHook provides a hook counting messages logged per level
+type LogrusMetricsHook struct{}
+
+// Levels returns all log levels
+func (h *LogrusMetricsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire counts the entry
+func (h *LogrusMetricsHook) Fire(entry *logrus.Entry) error {
+	countMessage(getLogrusLevelType(entry.Level))
 	return nil
 }
 
@@ -0,0 +1,80 @@
+package logtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// NormalizeJSON returns the lines with their JSON records encoded with
+// sorted keys and without the drop keys, e.g. time, text before the record
+// of a line is kept
+func NormalizeJSON(data []byte, dropKeys ...string) ([]byte, error) {
+	var normalized []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		line = bytes.TrimRight(line, "\n")
+		if len(line) == 0 {
+			continue
+		}
+		index := bytes.IndexByte(line, '{')
+		if index == -1 {
+			return nil, errors.New("Failed to find JSON in line: " +
+				string(line))
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(line[index:], &record); err != nil {
+			return nil, err
+		}
+		for _, key := range dropKeys {
+			delete(record, key)
+		}
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, line[:index]...)
+		normalized = append(normalized, encoded...)
+		normalized = append(normalized, '\n')
+	}
+	return normalized, nil
+}
+
+// Golden fails the test unless actual is the content of the golden file,
+// the file is written with actual first if update is set
+// Example: logtest.Golden(t, "testdata/name.golden", out, *update)
+func Golden(t testing.TB, golden string, actual []byte, update bool) {
+	t.Helper()
+	if update {
+		t.Logf("Updating %s\n", golden)
+		if err := ioutil.WriteFile(golden, actual, 0644); err != nil {
+			t.Fatalf("Failed to write file %s: %s\n", golden, err.Error())
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read file %s: %s\n", golden, err.Error())
+	}
+	if bytes.Equal(actual, expected) {
+		return
+	}
+	actualLines := bytes.Split(actual, []byte("\n"))
+	expectedLines := bytes.Split(expected, []byte("\n"))
+	for i := 0; ; i++ {
+		if i >= len(actualLines) || i >= len(expectedLines) ||
+			!bytes.Equal(actualLines[i], expectedLines[i]) {
+			var got, want []byte
+			if i < len(actualLines) {
+				got = actualLines[i]
+			}
+			if i < len(expectedLines) {
+				want = expectedLines[i]
+			}
+			t.Errorf("Mismatch with %s at line %d:\n got: %s\nwant: %s\n",
+				golden, i+1, got, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package logtest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/pavedroad-io/go-core/logger"
+)
+
+// Kafka provides kafka producers faked with a sarama mock producer, the
+// loggers with kafka enabled created after NewKafka send to it
+// The values of the messages expected to succeed are recorded
+// Tests using it must not run in parallel as the producer factory is global
+type Kafka struct {
+	t        testing.TB
+	mutex    sync.Mutex
+	producer *mocks.AsyncProducer
+	messages []string
+}
+
+// NewKafka returns a fake kafka, replaced by the brokers when the test ends
+// Messages are only accepted once expected, e.g. with ExpectMessages
+func NewKafka(t testing.TB) *Kafka {
+	k := &Kafka{t: t}
+	logger.SetProducerFactory(func(config *sarama.Config) (sarama.AsyncProducer,
+		error) {
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+		k.producer = mocks.NewAsyncProducer(t, config)
+		return k.producer, nil
+	})
+	t.Cleanup(func() {
+		logger.SetProducerFactory(nil)
+	})
+	return k
+}
+
+// Producer returns the mock producer of the latest kafka producer created
+// Expectations must be set after the logger is created
+func (k *Kafka) Producer() *mocks.AsyncProducer {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.producer == nil {
+		k.t.Fatalf("No kafka producer created\n")
+	}
+	return k.producer
+}
+
+// ExpectMessages expects count messages to succeed, recording their values
+func (k *Kafka) ExpectMessages(count int) {
+	producer := k.Producer()
+	for i := 0; i < count; i++ {
+		producer.ExpectInputWithCheckerFunctionAndSucceed(k.record)
+	}
+}
+
+// ExpectFailures expects count messages to fail with the error
+func (k *Kafka) ExpectFailures(count int, err error) {
+	producer := k.Producer()
+	for i := 0; i < count; i++ {
+		producer.ExpectInputAndFail(err)
+	}
+}
+
+// record records the value of a message expected to succeed
+func (k *Kafka) record(value []byte) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.messages = append(k.messages, string(value))
+	return nil
+}
+
+// Messages returns the values of the messages sent in order
+func (k *Kafka) Messages() []string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return append([]string{}, k.messages...)
+}
@@ -0,0 +1,108 @@
+package logtest
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavedroad-io/go-core/logger"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRecorder(t *testing.T) {
+	for _, pkg := range []logger.PackageType{logger.LogrusType,
+		logger.ZapType, logger.SlogType} {
+		log, recorder := New(t, Config(pkg))
+		log.WithFields(logger.LogFields{"user": "u1", "count": 3}).
+			Info("user logged in")
+		log.Warn("disk almost full")
+
+		entry := recorder.AssertLogged(t, logger.InfoType, "logged in",
+			logger.LogFields{"user": "u1", "count": 3})
+		if entry.Time.IsZero() {
+			t.Errorf("Expected %s record time\n", pkg)
+		}
+		recorder.AssertNotLogged(t, logger.ErrorType, "", nil)
+		recorder.AssertCount(t, logger.WarnType, 1)
+		recorder.AssertCount(t, "", 2)
+
+		// failures are reported to the test
+		fake := &testing.T{}
+		recorder.AssertLogged(fake, logger.InfoType, "", logger.LogFields{
+			"user": "u2"})
+		if !fake.Failed() {
+			t.Errorf("Expected %s assertion failure\n", pkg)
+		}
+		recorder.Reset()
+		recorder.AssertCount(t, "", 0)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	data := []byte(`{"time":"now","msg":"first","level":"info"}` + "\n" +
+		`prefix {"b":2,"a":1}` + "\n")
+	normalized, err := NormalizeJSON(data, "time")
+	if err != nil {
+		t.Fatalf("Failed to normalize: %s\n", err.Error())
+	}
+	expected := `{"level":"info","msg":"first"}` + "\n" +
+		`prefix {"a":1,"b":2}` + "\n"
+	if string(normalized) != expected {
+		t.Errorf("Unexpected normalized records: %s\n", normalized)
+	}
+	if _, err := NormalizeJSON([]byte("no record\n")); err == nil {
+		t.Errorf("Expected error normalizing a line without JSON\n")
+	}
+
+	golden := filepath.Join(t.TempDir(), "records.golden")
+	Golden(t, golden, normalized, true)
+	Golden(t, golden, normalized, *update)
+	fake := &testing.T{}
+	Golden(fake, golden, []byte(strings.Replace(expected, "first", "second",
+		1)), false)
+	if !fake.Failed() {
+		t.Errorf("Expected golden mismatch failure\n")
+	}
+	if data, _ := ioutil.ReadFile(golden); string(data) != expected {
+		t.Errorf("Unexpected golden file: %s\n", data)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	for _, pkg := range []logger.PackageType{logger.LogrusType,
+		logger.ZapType, logger.SlogType} {
+		kafka := NewKafka(t)
+		config := Config(pkg)
+		config.EnableKafka = true
+		config.KafkaFormat = logger.JSONFormat
+		config.KafkaProducerCfg.DeliveryRetryMax = 0
+		log, err := logger.NewLogger(config)
+		if err != nil {
+			t.Fatalf("Failed to instantiate %s logger: %s\n", pkg, err.Error())
+		}
+		kafka.ExpectMessages(1)
+		kafka.ExpectFailures(1, errors.New("broker failure"))
+		log.Info("sent")
+		log.Info("failed")
+		if err := log.Flush(time.Second); err != nil {
+			t.Fatalf("Failed to flush %s logger: %s\n", pkg, err.Error())
+		}
+		log.Close()
+
+		messages := kafka.Messages()
+		if len(messages) != 1 || !strings.Contains(messages[0], "sent") {
+			t.Errorf("Unexpected %s kafka messages: %v\n", pkg, messages)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,192 @@
+// Package logtest provides helpers to unit test logging done with the logger
+// package without running kafka: an in-memory sink recording records,
+// assertions on their levels, messages and fields, golden file comparison
+// and a kafka producer faked with the sarama mocks
+
+package logtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pavedroad-io/go-core/logger"
+)
+
+// sinkSequence numbers the sink types registered for recorders
+var sinkSequence int64
+
+// Entry provides a recorded record, fields hold the decoded JSON record
+// including the level, time and message keys
+type Entry struct {
+	logger.Entry
+	Fields map[string]interface{}
+	Raw    []byte
+}
+
+// Recorder provides a sink recording records in memory
+type Recorder struct {
+	mutex   sync.Mutex
+	entries []Entry
+}
+
+// Write records the record, it is recorded with no fields if not JSON
+func (r *Recorder) Write(msg []byte, entry logger.Entry) error {
+	record := Entry{Entry: entry, Raw: append([]byte{}, msg...)}
+	if err := json.Unmarshal(msg, &record.Fields); err != nil {
+		record.Fields = map[string]interface{}{}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, record)
+	return nil
+}
+
+// Close meets the logger.Sink interface, records are kept
+func (r *Recorder) Close() error {
+	return nil
+}
+
+// Entries returns the records in the order written
+func (r *Recorder) Entries() []Entry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Entry{}, r.entries...)
+}
+
+// Reset discards the records
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = nil
+}
+
+// Config returns a configuration of the package with the console, file and
+// kafka outputs disabled, timestamps are kept
+func Config(pkg logger.PackageType) logger.LoggerConfiguration {
+	config := *logger.DefaultCompleteCfg()
+	config.LogPackage = pkg
+	config.LogLevel = logger.DebugType
+	config.EnableConsole = false
+	config.EnableFile = false
+	config.EnableKafka = false
+	return config
+}
+
+// New returns a logger also writing JSON records to a new recorder, the
+// logger is closed when the test ends
+func New(t testing.TB, config logger.LoggerConfiguration) (logger.Logger,
+	*Recorder) {
+	t.Helper()
+	recorder := &Recorder{}
+	sinkType := fmt.Sprintf("logtest-%d", atomic.AddInt64(&sinkSequence, 1))
+	logger.RegisterSink(sinkType, func(logger.SinkConfiguration) (logger.Sink,
+		error) {
+		return recorder, nil
+	})
+	config.Sinks = append(append([]logger.SinkConfiguration{}, config.Sinks...),
+		logger.SinkConfiguration{Type: sinkType, Format: logger.JSONFormat})
+
+	log, err := logger.NewLogger(config)
+	if err != nil {
+		t.Fatalf("Failed to instantiate logger: %s\n", err.Error())
+	}
+	t.Cleanup(func() {
+		log.Close()
+	})
+	return log, recorder
+}
+
+// Filter returns the records of the level with the message containing msg
+// and the fields, an empty level or msg matches any record
+// Field values match if equal once encoded as JSON, so Int(3) matches 3.0
+func (r *Recorder) Filter(level logger.LevelType, msg string,
+	fields logger.LogFields) []Entry {
+	matched := []Entry{}
+	for _, entry := range r.Entries() {
+		if entry.matches(level, msg, fields) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// matches returns true if the record has the level, message and fields
+func (e Entry) matches(level logger.LevelType, msg string,
+	fields logger.LogFields) bool {
+	if level != "" && e.Level != level {
+		return false
+	}
+	if msg != "" && !strings.Contains(e.Message, msg) {
+		return false
+	}
+	for key, expected := range fields {
+		actual, ok := e.Fields[key]
+		if !ok || !equalJSON(expected, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalJSON returns true if the expected value encodes as the decoded value
+func equalJSON(expected, actual interface{}) bool {
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(decoded, actual)
+}
+
+// AssertLogged fails the test unless a record matches as by Filter, the
+// first matching record is returned
+func (r *Recorder) AssertLogged(t testing.TB, level logger.LevelType,
+	msg string, fields logger.LogFields) Entry {
+	t.Helper()
+	matched := r.Filter(level, msg, fields)
+	if len(matched) == 0 {
+		t.Errorf("No %s record %q with fields %v in:\n%s", level, msg, fields,
+			r.dump())
+		return Entry{}
+	}
+	return matched[0]
+}
+
+// AssertNotLogged fails the test if a record matches as by Filter
+func (r *Recorder) AssertNotLogged(t testing.TB, level logger.LevelType,
+	msg string, fields logger.LogFields) {
+	t.Helper()
+	if matched := r.Filter(level, msg, fields); len(matched) > 0 {
+		t.Errorf("Unexpected %s record %q with fields %v: %s", level, msg,
+			fields, matched[0].Raw)
+	}
+}
+
+// AssertCount fails the test unless count records have the level, an empty
+// level counts all records
+func (r *Recorder) AssertCount(t testing.TB, level logger.LevelType,
+	count int) {
+	t.Helper()
+	if matched := r.Filter(level, "", nil); len(matched) != count {
+		t.Errorf("Expected %d %s records, got %d in:\n%s", count, level,
+			len(matched), r.dump())
+	}
+}
+
+// dump returns the records for failure messages
+func (r *Recorder) dump() string {
+	var b strings.Builder
+	for _, entry := range r.Entries() {
+		b.WriteString(strings.TrimRight(string(entry.Raw), "\n"))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
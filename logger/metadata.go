@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// BrokerInfo provides kafka broker metadata
+type BrokerInfo struct {
+	ID   int32
+	Addr string
+}
+
+// kafkaMetadata provides cluster metadata for a kafka producer
+type kafkaMetadata interface {
+	Partitions(topic string) ([]int32, error)
+	Brokers() []BrokerInfo
+	Leader(topic string, partition int32) (BrokerInfo, error)
+	TopicExists(topic string) (bool, error)
+	MaxMessageBytes(topic string) (int, error)
+	WriteAllowed(topic, principal string) (bool, error)
+	CreateTopic(topic string, detail *sarama.TopicDetail) error
+	Reachable() error
+	Close() error
+}
+
+// saramaMetadata provides metadata from the sarama client of the producer
+// Requests are retried by the policy when sarama metadata retries are
+// disabled for its Retryable
+type saramaMetadata struct {
+	client sarama.Client
+	retry  RetryPolicy
+}
+
+// The following methods meet the contract for the kafka metadata
+
+func (m *saramaMetadata) Partitions(topic string) ([]int32, error) {
+	var partitions []int32
+	err := m.retry.do(func() (err error) {
+		partitions, err = m.client.Partitions(topic)
+		return err
+	})
+	return partitions, err
+}
+
+func (m *saramaMetadata) Brokers() []BrokerInfo {
+	brokers := []BrokerInfo{}
+	for _, broker := range m.client.Brokers() {
+		brokers = append(brokers, BrokerInfo{broker.ID(), broker.Addr()})
+	}
+	return brokers
+}
+
+func (m *saramaMetadata) Leader(topic string,
+	partition int32) (BrokerInfo, error) {
+	var broker *sarama.Broker
+	err := m.retry.do(func() (err error) {
+		broker, err = m.client.Leader(topic, partition)
+		return err
+	})
+	if err != nil {
+		return BrokerInfo{}, err
+	}
+	return BrokerInfo{broker.ID(), broker.Addr()}, nil
+}
+
+func (m *saramaMetadata) Reachable() error {
+	return m.retry.do(func() error {
+		return m.client.RefreshMetadata()
+	})
+}
+
+func (m *saramaMetadata) Close() error {
+	return m.client.Close()
+}
+
+// mockMetadata provides the metadata of the mock broker
+// Every topic has the mock broker partitions led by the mock broker
+type mockMetadata struct{}
+
+// MockBrokerInfo is the broker metadata returned for the mock broker
+var MockBrokerInfo = BrokerInfo{ID: 0, Addr: "mock:9092"}
+
+// The following methods meet the contract for the kafka metadata
+
+func (m *mockMetadata) Partitions(topic string) ([]int32, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	partitions := make([]int32, broker.partitions)
+	for i := range partitions {
+		partitions[i] = int32(i)
+	}
+	return partitions, nil
+}
+
+func (m *mockMetadata) Brokers() []BrokerInfo {
+	return []BrokerInfo{MockBrokerInfo}
+}
+
+func (m *mockMetadata) Leader(topic string,
+	partition int32) (BrokerInfo, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if partition < 0 || partition >= broker.partitions {
+		return BrokerInfo{}, sarama.ErrUnknownTopicOrPartition
+	}
+	return MockBrokerInfo, nil
+}
+
+func (m *mockMetadata) Reachable() error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.down {
+		return sarama.ErrOutOfBrokers
+	}
+	return nil
+}
+
+func (m *mockMetadata) Close() error {
+	return nil
+}
+
+// PartitionCount returns the number of partitions of the topic
+func (s *Sender) PartitionCount(topic string) (int, error) {
+	partitions, err := s.kp.metadata.Partitions(topic)
+	if err != nil {
+		return 0, err
+	}
+	return len(partitions), nil
+}
+
+// Brokers returns the brokers of the kafka cluster
+func (s *Sender) Brokers() []BrokerInfo {
+	return s.kp.metadata.Brokers()
+}
+
+// Leader returns the broker leading the topic partition
+func (s *Sender) Leader(topic string, partition int32) (BrokerInfo, error) {
+	return s.kp.metadata.Leader(topic, partition)
+}
@@ -0,0 +1,188 @@
+package logger
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric names exposed by MetricsHandler
+const (
+	MetricMessages       = "logger_messages_total" // label level
+	MetricKafkaSuccesses = "logger_kafka_publish_successes_total"
+	MetricKafkaFailures  = "logger_kafka_publish_failures_total"
+	MetricKafkaLatency   = "logger_kafka_publish_latency_seconds"
+	MetricDropped        = "logger_dropped_messages_total" // label reason
+	MetricQueueDepth     = "logger_kafka_queue_depth"
+	MetricRotations      = "logger_rotations_total"
+	MetricSuppressed     = "logger_suppressed_messages_total" // label level
+	MetricSpooled        = "logger_kafka_spooled_total"
+	MetricReplayed       = "logger_kafka_replayed_total"
+	MetricQuotaExceeded  = "logger_quota_exceeded_total" // label quota key
+)
+
+// Reasons for dropped messages
+const (
+	DropProcessing = "processing" // record could not be processed
+	DropTimeout    = "timeout"    // write timeout expired
+	DropClosed     = "closed"     // producer closed
+	DropDelivery   = "delivery"   // delivery retries exhausted
+	DropOverflow   = "overflow"   // async buffer full
+	DropSpool      = "spool"      // spool full or record unreadable
+	DropQuota      = "quota"      // quota of the record key value exceeded
+)
+
+// latencyBuckets are the upper bounds of the publish latency histogram
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsCollector receives the logging pipeline metrics as they occur
+// Used to forward metrics to an application metrics system
+type MetricsCollector interface {
+	Count(name string, labels map[string]string)
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// metricKey identifies a counter by name and optional label
+type metricKey struct {
+	name  string
+	label string
+	value string
+}
+
+var (
+	metricCounters  sync.Map // metricKey to *uint64
+	latencyMutex    sync.Mutex
+	latencyCounts   = make([]uint64, len(latencyBuckets))
+	latencySum      float64
+	latencyCount    uint64
+	collectorMutex  sync.RWMutex
+	metricCollector MetricsCollector
+)
+
+// SetMetricsCollector sets the collector receiving the metrics
+func SetMetricsCollector(collector MetricsCollector) {
+	collectorMutex.Lock()
+	defer collectorMutex.Unlock()
+	metricCollector = collector
+}
+
+// getMetricsCollector returns the metrics collector, nil if not set
+func getMetricsCollector() MetricsCollector {
+	collectorMutex.RLock()
+	defer collectorMutex.RUnlock()
+	return metricCollector
+}
+
+// countMetric increments the counter for the name and label
+func countMetric(name, label, value string) {
+	key := metricKey{name, label, value}
+	counter, _ := metricCounters.LoadOrStore(key, new(uint64))
+	atomic.AddUint64(counter.(*uint64), 1)
+
+	if collector := getMetricsCollector(); collector != nil {
+		labels := map[string]string{}
+		if label != "" {
+			labels[label] = value
+		}
+		collector.Count(name, labels)
+	}
+}
+
+// countMessage increments the messages logged counter for the level
+func countMessage(level LevelType) {
+	countMetric(MetricMessages, "level", string(level))
+}
+
+// countDropped increments the dropped messages counter for the reason
+func countDropped(reason string) {
+	countMetric(MetricDropped, "reason", reason)
+}
+
+// observeLatency adds a kafka publish latency to the histogram
+func observeLatency(latency time.Duration) {
+	seconds := latency.Seconds()
+	latencyMutex.Lock()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			latencyCounts[i]++
+		}
+	}
+	latencySum += seconds
+	latencyCount++
+	latencyMutex.Unlock()
+
+	if collector := getMetricsCollector(); collector != nil {
+		collector.Observe(MetricKafkaLatency, map[string]string{}, seconds)
+	}
+}
+
+// queueDepth returns the messages pending in all kafka producers
+func queueDepth() int64 {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	var depth int64
+	for kp := range producers {
+		depth += atomic.LoadInt64(&kp.pending)
+	}
+	return depth
+}
+
+// MetricsHandler returns a handler serving the metrics in the prometheus
+// text exposition format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(formatMetrics()))
+	})
+}
+
+// formatMetrics returns the metrics in the prometheus text format
+func formatMetrics() string {
+	var sb strings.Builder
+
+	// counters sorted by name then label value for stable output
+	keys := []metricKey{}
+	metricCounters.Range(func(key, counter interface{}) bool {
+		keys = append(keys, key.(metricKey))
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].value < keys[j].value
+	})
+	for i, key := range keys {
+		if i == 0 || keys[i-1].name != key.name {
+			fmt.Fprintf(&sb, "# TYPE %s counter\n", key.name)
+		}
+		counter, _ := metricCounters.Load(key)
+		value := atomic.LoadUint64(counter.(*uint64))
+		if key.label == "" {
+			fmt.Fprintf(&sb, "%s %d\n", key.name, value)
+		} else {
+			fmt.Fprintf(&sb, "%s{%s=%q} %d\n", key.name, key.label,
+				key.value, value)
+		}
+	}
+
+	fmt.Fprintf(&sb, "# TYPE %s gauge\n", MetricQueueDepth)
+	fmt.Fprintf(&sb, "%s %d\n", MetricQueueDepth, queueDepth())
+
+	latencyMutex.Lock()
+	defer latencyMutex.Unlock()
+	fmt.Fprintf(&sb, "# TYPE %s histogram\n", MetricKafkaLatency)
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(&sb, "%s_bucket{le=\"%g\"} %d\n", MetricKafkaLatency,
+			bound, latencyCounts[i])
+	}
+	fmt.Fprintf(&sb, "%s_bucket{le=\"+Inf\"} %d\n", MetricKafkaLatency,
+		latencyCount)
+	fmt.Fprintf(&sb, "%s_sum %g\n", MetricKafkaLatency, latencySum)
+	fmt.Fprintf(&sb, "%s_count %d\n", MetricKafkaLatency, latencyCount)
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrOffline is returned for kafka requests made by an offline producer
+var ErrOffline = errors.New("Kafka producer offline")
+
+// ErrJournalNotSet is returned by Upload when SpoolDir is not set
+var ErrJournalNotSet = errors.New("Journal SpoolDir not set")
+
+// offlineProducer stands in for the sarama producer of an offline producer
+// Nothing is passed to it as messages are written to the journal
+type offlineProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	closeOnce sync.Once
+}
+
+// newOfflineProducer returns an offline producer instance
+func newOfflineProducer() *offlineProducer {
+	return &offlineProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (p *offlineProducer) AsyncClose() {
+	p.closeOnce.Do(func() {
+		close(p.successes)
+		close(p.errors)
+	})
+}
+
+func (p *offlineProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *offlineProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *offlineProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *offlineProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+// offlineMetadata provides the metadata of an offline producer, no broker
+// is contacted
+type offlineMetadata struct{}
+
+func (m offlineMetadata) Partitions(topic string) ([]int32, error) {
+	return nil, ErrOffline
+}
+
+func (m offlineMetadata) Brokers() []BrokerInfo {
+	return nil
+}
+
+func (m offlineMetadata) Leader(topic string,
+	partition int32) (BrokerInfo, error) {
+	return BrokerInfo{}, ErrOffline
+}
+
+func (m offlineMetadata) TopicExists(topic string) (bool, error) {
+	return false, ErrOffline
+}
+
+func (m offlineMetadata) MaxMessageBytes(topic string) (int, error) {
+	return 0, ErrOffline
+}
+
+func (m offlineMetadata) WriteAllowed(topic, principal string) (bool, error) {
+	return false, ErrOffline
+}
+
+func (m offlineMetadata) CreateTopic(topic string,
+	detail *sarama.TopicDetail) error {
+	return ErrOffline
+}
+
+func (m offlineMetadata) Reachable() error {
+	return ErrOffline
+}
+
+func (m offlineMetadata) Close() error {
+	return nil
+}
+
+// journaling returns true if the segment is named for another process
+// that is running, signal 0 checks the process exists without signaling it
+func journaling(segment spoolSegment) bool {
+	name := strings.TrimSuffix(filepath.Base(segment.path), spoolSuffix)
+	parts := strings.SplitN(strings.TrimPrefix(name, spoolPrefix), "-", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	if err != nil || pid == os.Getpid() {
+		return false
+	}
+	err = syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Upload sends the journal of the current kafka configuration
+// Example: defer logger.Upload(ctx) once the offline logger is closed
+func Upload(ctx context.Context) error {
+	return UploadJournal(ctx, CurrentConfiguration().KafkaProducerCfg)
+}
+
+// UploadJournal sends the messages journaled in SpoolDir by offline
+// producers to kafka, oldest first, removing each segment once all its
+// messages are acknowledged
+// A segment with failed messages is kept and its error returned, so
+// messages may be sent again by the next upload
+// Segments named for another live process are still journaled so skipped,
+// those of the calling process are uploaded once its offline logger is
+// closed
+func UploadJournal(ctx context.Context, config ProducerConfiguration) error {
+	if config.SpoolDir == "" {
+		return ErrJournalNotSet
+	}
+	config.EnableOffline = false
+	journal, err := newSpool(config)
+	if err != nil {
+		return err
+	}
+	segments := journal.segments[:0]
+	for _, segment := range journal.segments {
+		if !journaling(segment) {
+			segments = append(segments, segment)
+		}
+	}
+	journal.segments = segments
+	if len(journal.segments) == 0 {
+		return nil
+	}
+
+	// the uploading producer neither journals nor adds messages
+	config.SpoolDir = ""
+	config.HeartbeatInterval = 0
+	config.QuotaRate = 0
+	kp, err := newKafkaProducer(config, nil, CloudEventsConfiguration{}, nil)
+	if err != nil {
+		return err
+	}
+	defer kp.close()
+
+	var mutex sync.Mutex
+	var failure error
+	callback := func(partition, offset int64, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil && failure == nil {
+			failure = err
+		}
+	}
+	for len(journal.segments) > 0 {
+		segment := journal.segments[0]
+		messages, err := readSegment(segment.path)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			msg.Metadata = messageMeta{callback: callback}
+			if err := kp.produce(msg); err != nil {
+				return err
+			}
+			countMetric(MetricReplayed, "", "")
+		}
+
+		flushed := make(chan error, 1)
+		go func() {
+			flushed <- kp.flush(0)
+		}()
+		select {
+		case err = <-flushed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		err = failure
+		mutex.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := journal.remove(segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package logger
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// logOutputs provides the outputs shared by a logger and its derived loggers
+type logOutputs struct {
+	kafka     *KafkaProducer
+	asyncs    []*asyncWriter
+	flushers  []flusher
+	closers   []io.Closer
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// flusher provides an output that buffers records, such as a sink
+type flusher interface {
+	Flush(timeout time.Duration) error
+}
+
+// addFlusher adds the output to be flushed if it is a flusher
+func (o *logOutputs) addFlusher(w interface{}) {
+	if f, ok := w.(flusher); ok {
+		o.flushers = append(o.flushers, f)
+	}
+}
+
+// addCloser adds the output to be closed if it is an io.Closer
+func (o *logOutputs) addCloser(w interface{}) {
+	if closer, ok := w.(io.Closer); ok {
+		o.closers = append(o.closers, closer)
+	}
+}
+
+// flush waits for buffered records and pending kafka messages to be sent
+func (o *logOutputs) flush(timeout time.Duration) error {
+	for _, async := range o.asyncs {
+		async.Drain()
+	}
+	for _, f := range o.flushers {
+		if err := f.Flush(timeout); err != nil {
+			return err
+		}
+	}
+	if o.kafka == nil {
+		return nil
+	}
+	return o.kafka.flush(timeout)
+}
+
+// close drains the async writers, closes the writers then the kafka producer,
+// sending pending messages
+func (o *logOutputs) close() error {
+	o.closeOnce.Do(func() {
+		for _, async := range o.asyncs {
+			async.Close()
+		}
+		for _, closer := range o.closers {
+			if err := closer.Close(); err != nil && o.closeErr == nil {
+				o.closeErr = err
+			}
+		}
+		if o.kafka != nil {
+			if err := o.kafka.close(); err != nil && o.closeErr == nil {
+				o.closeErr = err
+			}
+		}
+	})
+	return o.closeErr
+}
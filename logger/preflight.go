@@ -0,0 +1,189 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrPreflight is wrapped by the error returned when preflight checks fail
+var ErrPreflight = errors.New("Kafka preflight failed")
+
+// topicMaxMessageBytes is the topic config limiting the record batch size
+const topicMaxMessageBytes = "max.message.bytes"
+
+// preflight validates the topics the producer sends to before any record
+// is sent, all problems found are returned in a single error
+func (kp *KafkaProducer) preflight(maxMessageBytes int) error {
+	checkTopics := []string{kp.config.Topic}
+	if kp.config.DeadLetterTopic != "" {
+		checkTopics = append(checkTopics, kp.config.DeadLetterTopic)
+	}
+
+	problems := []string{}
+	for _, topic := range checkTopics {
+		exists, err := kp.metadata.TopicExists(topic)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"could not list topics: %s", err.Error()))
+			break
+		} else if !exists {
+			problems = append(problems, fmt.Sprintf(
+				"topic %s does not exist, create it before logging", topic))
+			continue
+		}
+		if _, err := kp.metadata.Partitions(topic); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"topic %s metadata unavailable: %s", topic, err.Error()))
+			continue
+		}
+
+		if principal := kp.config.PreflightPrincipal; principal != "" {
+			allowed, err := kp.metadata.WriteAllowed(topic, principal)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf(
+					"could not check ACLs of topic %s: %s", topic, err.Error()))
+			} else if !allowed {
+				problems = append(problems, fmt.Sprintf(
+					"no ACL allows %s to write to topic %s", principal, topic))
+			}
+		}
+
+		topicMax, err := kp.metadata.MaxMessageBytes(topic)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"could not read %s of topic %s: %s", topicMaxMessageBytes,
+				topic, err.Error()))
+		} else if maxMessageBytes > topicMax {
+			problems = append(problems, fmt.Sprintf(
+				"producer MaxMessageBytes %d exceeds topic %s %s %d, "+
+					"lower MaxMessageBytes or raise the topic limit",
+				maxMessageBytes, topic, topicMaxMessageBytes, topicMax))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrPreflight, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// The following methods meet the contract for the kafka metadata
+
+func (m *saramaMetadata) TopicExists(topic string) (bool, error) {
+	// listed from metadata, requesting the topic could auto create it
+	var topics []string
+	err := m.retry.do(func() (err error) {
+		topics, err = m.client.Topics()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range topics {
+		if existing == topic {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *saramaMetadata) MaxMessageBytes(topic string) (int, error) {
+	admin, err := sarama.NewClusterAdminFromClient(m.client)
+	if err != nil {
+		return 0, err
+	}
+	// admin is not closed, closing it would close the producer client
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        topic,
+		ConfigNames: []string{topicMaxMessageBytes},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.Name == topicMaxMessageBytes {
+			return strconv.Atoi(entry.Value)
+		}
+	}
+	return 0, errors.New("Topic config missing: " + topicMaxMessageBytes)
+}
+
+func (m *saramaMetadata) WriteAllowed(topic, principal string) (bool, error) {
+	controller, err := m.client.Controller()
+	if err != nil {
+		return false, err
+	}
+	request := &sarama.DescribeAclsRequest{AclFilter: sarama.AclFilter{
+		ResourceType:              sarama.AclResourceTopic,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	}}
+	if m.client.Config().Version.IsAtLeast(sarama.V2_0_0_0) {
+		request.Version = 1
+	}
+	response, err := controller.DescribeAcls(request)
+	if err != nil {
+		return false, err
+	}
+	if response.Err == sarama.ErrSecurityDisabled {
+		// no authorizer configured, all principals may write
+		return true, nil
+	} else if response.Err != sarama.ErrNoError {
+		return false, response.Err
+	}
+
+	allowed := false
+	for _, resource := range response.ResourceAcls {
+		if !aclResourceMatches(resource.Resource, topic) {
+			continue
+		}
+		for _, acl := range resource.Acls {
+			if (acl.Principal != principal && acl.Principal != "User:*") ||
+				(acl.Operation != sarama.AclOperationWrite &&
+					acl.Operation != sarama.AclOperationAll) {
+				continue
+			}
+			switch acl.PermissionType {
+			case sarama.AclPermissionDeny:
+				return false, nil
+			case sarama.AclPermissionAllow:
+				allowed = true
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// aclResourceMatches returns true if the ACL resource pattern matches the topic
+func aclResourceMatches(resource sarama.Resource, topic string) bool {
+	switch resource.ResourcePatternType {
+	case sarama.AclPatternPrefixed:
+		return strings.HasPrefix(topic, resource.ResourceName)
+	default:
+		return resource.ResourceName == topic || resource.ResourceName == "*"
+	}
+}
+
+func (m *mockMetadata) TopicExists(topic string) (bool, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.topics == nil || broker.topics[topic], nil
+}
+
+func (m *mockMetadata) MaxMessageBytes(topic string) (int, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.maxMessageBytes, nil
+}
+
+func (m *mockMetadata) WriteAllowed(topic, principal string) (bool, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return !broker.denyWrite[topic], nil
+}
@@ -0,0 +1,165 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ANSI escape sequences of the pretty console output
+const (
+	ansiReset   = "\x1b[0m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// prettyIndent indents the fields of a record and the lines of their values
+const prettyIndent = "    "
+
+// prettyLevelColors are the colors of the levels
+var prettyLevelColors = map[string]string{
+	"DEBUG": ansiMagenta,
+	"INFO":  ansiBlue,
+	"WARN":  ansiYellow,
+	"ERROR": ansiRed,
+	"FATAL": ansiRed,
+	"PANIC": ansiRed,
+}
+
+// consoleFormat returns the console format, JSON records are rendered by
+// the pretty writer if PrettyConsole is set
+func (lc LoggerConfiguration) consoleFormat() FormatType {
+	if lc.PrettyConsole {
+		return JSONFormat
+	}
+	return lc.ConsoleFormat
+}
+
+// consoleOutput returns the console writer, rendering the records in a
+// human friendly layout if PrettyConsole is set
+func (lc LoggerConfiguration) consoleOutput() io.Writer {
+	out := getConsoleWriter(lc.ConsoleWriter)
+	if !lc.PrettyConsole {
+		return out
+	}
+	return &prettyWriter{
+		out:      out,
+		timeKey:  lc.FieldMap.resolve(FieldKeyTime),
+		levelKey: lc.FieldMap.resolve(FieldKeyLevel),
+		msgKey:   lc.FieldMap.resolve(FieldKeyMsg),
+		color:    lc.EnableColorLevels,
+	}
+}
+
+// prettyWriter renders JSON records on multiple lines, the level aligned
+// and colored, the timestamp dimmed and the fields indented, one per line,
+// with JSON values pretty printed
+// Lines that are not JSON objects are written unchanged
+type prettyWriter struct {
+	out      io.Writer
+	timeKey  string
+	levelKey string
+	msgKey   string
+	color    bool
+}
+
+// Write renders each record of the message as a whole
+func (w *prettyWriter) Write(msg []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(msg, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			w.render(&buf, line)
+		}
+	}
+	if _, err := w.out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(msg), nil
+}
+
+// render writes the record to the buffer
+func (w *prettyWriter) render(buf *bytes.Buffer, line []byte) {
+	var record map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		buf.Write(line)
+		return
+	}
+
+	if ts, ok := record[w.timeKey]; ok {
+		buf.WriteString(w.paint(ansiDim, fmt.Sprint(ts)))
+		buf.WriteByte(' ')
+		delete(record, w.timeKey)
+	}
+	level := strings.ToUpper(fmt.Sprint(record[w.levelKey]))
+	if level == "WARNING" {
+		level = "WARN" // logrus
+	}
+	delete(record, w.levelKey)
+	buf.WriteString(w.paint(prettyLevelColors[level], fmt.Sprintf("%-5s", level)))
+	buf.WriteByte(' ')
+	buf.WriteString(fmt.Sprint(record[w.msgKey]))
+	delete(record, w.msgKey)
+	buf.WriteByte('\n')
+
+	keys := make([]string, 0, len(record))
+	for key := range record {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(prettyIndent)
+		buf.WriteString(w.paint(ansiCyan, key))
+		buf.WriteByte(':')
+		value := prettyValue(record[key])
+		if strings.Contains(value, "\n") {
+			value = "\n" + prettyIndent + prettyIndent +
+				strings.ReplaceAll(value, "\n", "\n"+prettyIndent+prettyIndent)
+		} else {
+			value = " " + value
+		}
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+}
+
+// paint returns the text in the color if colors are enabled
+func (w *prettyWriter) paint(color, text string) string {
+	if !w.color || color == "" {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// prettyValue returns the field value, objects, arrays and strings holding
+// them are indented
+func prettyValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) &&
+			json.Valid([]byte(trimmed)) {
+			var indented bytes.Buffer
+			if json.Indent(&indented, []byte(trimmed), "", "  ") == nil {
+				return indented.String()
+			}
+		}
+		return v
+	case map[string]interface{}, []interface{}:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
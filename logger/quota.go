@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+)
+
+// QuotaExceededMessage is the message of quota summary events
+const QuotaExceededMessage = "Quota exceeded"
+
+// QuotaEventName is the event name of quota summary events, mapped to a
+// cloudevents type by EventTypes
+const QuotaEventName = "quota"
+
+// Keys of the fields of quota summary events, which also have the quota
+// key set to the value exceeding its quota
+const (
+	QuotaDroppedKey = "dropped" // records dropped since the last summary
+	QuotaRateKey    = "quotarate"
+)
+
+// QuotaOtherValue is the metric label of the values exceeding their quota
+// once QuotaMetricValues values are labelled
+const QuotaOtherValue = "other"
+
+// quota limits the rate of records per value of the quota key, counting
+// the records dropped per value until summarized
+type quota struct {
+	mutex     sync.Mutex
+	key       string
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	dropped   map[string]int64
+	labels    map[string]bool // values with their own metric label
+	maxLabels int
+}
+
+// newQuota returns a quota instance for the producer configuration, nil if
+// quotas are disabled
+func newQuota(config ProducerConfiguration) *quota {
+	if config.QuotaRate <= 0 || config.QuotaKey == "" {
+		return nil
+	}
+	burst := config.QuotaBurst
+	if burst <= 0 {
+		burst = int(math.Ceil(config.QuotaRate))
+	}
+	return &quota{
+		key:       config.QuotaKey,
+		rate:      config.QuotaRate,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+		dropped:   make(map[string]int64),
+		labels:    make(map[string]bool),
+		maxLabels: config.QuotaMetricValues,
+	}
+}
+
+// allow returns true if the record is within the quota of its key value,
+// records without the key or that are not objects are always allowed
+func (q *quota) allow(msg []byte) bool {
+	value, ok := q.value(msg)
+	if !ok {
+		return true
+	}
+
+	q.mutex.Lock()
+	bucket, ok := q.buckets[value]
+	if !ok {
+		bucket = newTokenBucket(q.rate, q.burst)
+		q.buckets[value] = bucket
+	}
+	q.mutex.Unlock()
+	if bucket.allow() {
+		return true
+	}
+
+	q.mutex.Lock()
+	q.dropped[value]++
+	label := q.label(value)
+	q.mutex.Unlock()
+	countDropped(DropQuota)
+	countMetric(MetricQuotaExceeded, q.key, label)
+	return false
+}
+
+// label returns the metric label of the value, the first values to exceed
+// their quota are labelled and the others share QuotaOtherValue so the
+// label cardinality is bounded, called with the mutex held
+func (q *quota) label(value string) string {
+	if q.labels[value] {
+		return value
+	}
+	if len(q.labels) < q.maxLabels {
+		q.labels[value] = true
+		return value
+	}
+	return QuotaOtherValue
+}
+
+// value returns the quota key value of the record
+func (q *quota) value(msg []byte) (string, bool) {
+	return recordMember(msg, q.key)
+}
+
+// recordMember returns the value of the top level member of a JSON record,
+// strings unquoted and other values as encoded
+func recordMember(msg []byte, key string) (string, bool) {
+	if !json.Valid(msg) {
+		return "", false
+	}
+	var stack [maxStackMembers]jsonMember
+	members, ok := scanMembers(msg, stack[:0])
+	if !ok {
+		return "", false
+	}
+	raw, ok := findMember(members, key)
+	if !ok {
+		return "", false
+	}
+	var value string
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", false
+		}
+		return value, true
+	}
+	return string(raw), true
+}
+
+// summarize returns the records dropped per value since the last summary,
+// and forgets the values idle long enough for their bucket to be full
+func (q *quota) summarize() map[string]int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	dropped := q.dropped
+	q.dropped = make(map[string]int64)
+	t := now()
+	for value, bucket := range q.buckets {
+		if bucket.full(t) {
+			delete(q.buckets, value)
+		}
+	}
+	return dropped
+}
+
+// full returns true if the bucket is full at the time
+func (b *tokenBucket) full(t time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.tokens+t.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// summarizeQuota sends a summary event at the interval for each value that
+// exceeded its quota until closed
+func (kp *KafkaProducer) summarizeQuota() {
+	ticker := time.NewTicker(kp.config.QuotaInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kp.done:
+			return
+		case <-ticker.C:
+		}
+		for value, dropped := range kp.quota.summarize() {
+			kp.sendEvent(WarnType, QuotaExceededMessage, QuotaEventName,
+				LogFields{
+					kp.quota.key:    value,
+					QuotaDroppedKey: dropped,
+					QuotaRateKey:    kp.quota.rate,
+				})
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultWatchInterval is the config file polling interval if none is given
+const DefaultWatchInterval = 5 * time.Second
+
+// reloadFlushTimeout bounds the wait for the records of a replaced logger
+const reloadFlushTimeout = 10 * time.Second
+
+// Reload replaces the package logger with one created from the environment
+// configuration, the current logger is kept if the configuration is invalid
+func Reload() error {
+	config, err := environmentConfiguration()
+	if err != nil {
+		return err
+	}
+	return ReloadConfiguration(config)
+}
+
+// ReloadConfiguration replaces the package logger with one created from the
+// configuration, then flushes and closes the replaced logger
+// The current logger is kept if the new one cannot be created
+// Loggers derived from the package logger write to the new logger, derived
+// again with the same fields on their first record after the reload
+func ReloadConfiguration(config LoggerConfiguration) error {
+	newLogger, err := NewLogger(config)
+	if err != nil {
+		return err
+	}
+
+	loggerMutex.Lock()
+	var old Logger
+	if current, ok := logger.(*reloadLogger); ok {
+		old = current.swap(newLogger)
+	} else {
+		old = logger
+		logger = newReloadLogger(newLogger)
+	}
+	loggerMutex.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	old.Flush(reloadFlushTimeout)
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("Failed to close replaced logger: %w", err)
+	}
+	return nil
+}
+
+// reloadRoot provides the logger currently replacing the package logger
+// and its generation, counting the reloads
+type reloadRoot struct {
+	mutex      sync.RWMutex
+	logger     Logger
+	generation uint64
+}
+
+// reloadLogger provides the package logger and the loggers derived from it,
+// writing to the logger of the current configuration
+// Derived loggers apply their derivation to the current logger of their
+// parent once per reload
+type reloadLogger struct {
+	root       *reloadRoot
+	parent     *reloadLogger // nil for the package logger
+	derive     func(Logger) Logger
+	mutex      sync.Mutex
+	generation uint64
+	derived    Logger
+}
+
+// newReloadLogger returns the package logger writing to the logger
+func newReloadLogger(logger Logger) *reloadLogger {
+	return &reloadLogger{root: &reloadRoot{logger: logger}}
+}
+
+// swap replaces the logger written to, returns the replaced logger
+func (l *reloadLogger) swap(logger Logger) Logger {
+	l.root.mutex.Lock()
+	defer l.root.mutex.Unlock()
+	old := l.root.logger
+	l.root.logger = logger
+	l.root.generation++
+	return old
+}
+
+// current returns the logger written to, derived again once reloaded
+func (l *reloadLogger) current() Logger {
+	l.root.mutex.RLock()
+	logger, generation := l.root.logger, l.root.generation
+	l.root.mutex.RUnlock()
+	if l.parent == nil {
+		return logger
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.derived == nil || l.generation != generation {
+		l.derived = l.derive(l.parent.current())
+		l.generation = generation
+	}
+	return l.derived
+}
+
+// with returns a logger derived by the function, again after each reload
+func (l *reloadLogger) with(derive func(Logger) Logger) Logger {
+	return &reloadLogger{root: l.root, parent: l, derive: derive}
+}
+
+// The following methods meet the contract for the logger interface
+
+func (l *reloadLogger) Print(args ...interface{}) {
+	l.current().Print(args...)
+}
+
+func (l *reloadLogger) Printf(format string, args ...interface{}) {
+	l.current().Printf(format, args...)
+}
+
+func (l *reloadLogger) Println(args ...interface{}) {
+	l.current().Println(args...)
+}
+
+func (l *reloadLogger) Debug(args ...interface{}) {
+	l.current().Debug(args...)
+}
+
+func (l *reloadLogger) Debugf(format string, args ...interface{}) {
+	l.current().Debugf(format, args...)
+}
+
+func (l *reloadLogger) Debugln(args ...interface{}) {
+	l.current().Debugln(args...)
+}
+
+func (l *reloadLogger) Info(args ...interface{}) {
+	l.current().Info(args...)
+}
+
+func (l *reloadLogger) Infof(format string, args ...interface{}) {
+	l.current().Infof(format, args...)
+}
+
+func (l *reloadLogger) Infoln(args ...interface{}) {
+	l.current().Infoln(args...)
+}
+
+func (l *reloadLogger) Warn(args ...interface{}) {
+	l.current().Warn(args...)
+}
+
+func (l *reloadLogger) Warnf(format string, args ...interface{}) {
+	l.current().Warnf(format, args...)
+}
+
+func (l *reloadLogger) Warnln(args ...interface{}) {
+	l.current().Warnln(args...)
+}
+
+func (l *reloadLogger) Error(args ...interface{}) {
+	l.current().Error(args...)
+}
+
+func (l *reloadLogger) Errorf(format string, args ...interface{}) {
+	l.current().Errorf(format, args...)
+}
+
+func (l *reloadLogger) Errorln(args ...interface{}) {
+	l.current().Errorln(args...)
+}
+
+func (l *reloadLogger) Fatal(args ...interface{}) {
+	l.current().Fatal(args...)
+}
+
+func (l *reloadLogger) Fatalf(format string, args ...interface{}) {
+	l.current().Fatalf(format, args...)
+}
+
+func (l *reloadLogger) Fatalln(args ...interface{}) {
+	l.current().Fatalln(args...)
+}
+
+func (l *reloadLogger) Panic(args ...interface{}) {
+	l.current().Panic(args...)
+}
+
+func (l *reloadLogger) Panicf(format string, args ...interface{}) {
+	l.current().Panicf(format, args...)
+}
+
+func (l *reloadLogger) Panicln(args ...interface{}) {
+	l.current().Panicln(args...)
+}
+
+func (l *reloadLogger) WithFields(fields LogFields) Logger {
+	return l.with(func(logger Logger) Logger { return logger.WithFields(fields) })
+}
+
+func (l *reloadLogger) WithError(err error) Logger {
+	return l.with(func(logger Logger) Logger { return logger.WithError(err) })
+}
+
+func (l *reloadLogger) WithLazyFields(fieldsFn func() LogFields) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithLazyFields(fieldsFn)
+	})
+}
+
+func (l *reloadLogger) WithSampledFields(rate float64,
+	keyValues LogFields) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithSampledFields(rate, keyValues)
+	})
+}
+
+func (l *reloadLogger) With(fields ...Field) Logger {
+	return l.with(func(logger Logger) Logger { return logger.With(fields...) })
+}
+
+func (l *reloadLogger) WithTopic(topic string) Logger {
+	return l.with(func(logger Logger) Logger { return logger.WithTopic(topic) })
+}
+
+func (l *reloadLogger) WithCESource(source string) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithCESource(source)
+	})
+}
+
+func (l *reloadLogger) WithKafkaFilterFn(filterFn FilterFunc) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithKafkaFilterFn(filterFn)
+	})
+}
+
+func (l *reloadLogger) WithKafkaKeyFn(keyFn KeyFunc) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithKafkaKeyFn(keyFn)
+	})
+}
+
+func (l *reloadLogger) WithKafkaPartitionFn(partitionFn PartitionFunc) Logger {
+	return l.with(func(logger Logger) Logger {
+		return logger.WithKafkaPartitionFn(partitionFn)
+	})
+}
+
+func (l *reloadLogger) WithContext(ctx context.Context) Logger {
+	if len(contextFields(ctx)) == 0 {
+		return l
+	}
+	return l.with(func(logger Logger) Logger { return logger.WithContext(ctx) })
+}
+
+func (l *reloadLogger) DebugCtx(ctx context.Context, args ...interface{}) {
+	l.current().DebugCtx(ctx, args...)
+}
+
+func (l *reloadLogger) InfoCtx(ctx context.Context, args ...interface{}) {
+	l.current().InfoCtx(ctx, args...)
+}
+
+func (l *reloadLogger) WarnCtx(ctx context.Context, args ...interface{}) {
+	l.current().WarnCtx(ctx, args...)
+}
+
+func (l *reloadLogger) ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.current().ErrorCtx(ctx, args...)
+}
+
+func (l *reloadLogger) FatalCtx(ctx context.Context, args ...interface{}) {
+	l.current().FatalCtx(ctx, args...)
+}
+
+func (l *reloadLogger) PanicCtx(ctx context.Context, args ...interface{}) {
+	l.current().PanicCtx(ctx, args...)
+}
+
+func (l *reloadLogger) Flush(timeout time.Duration) error {
+	return l.current().Flush(timeout)
+}
+
+func (l *reloadLogger) Close() error {
+	return l.current().Close()
+}
+
+// WatchConfiguration reloads the package logger on SIGHUP and, for file
+// configuration, when a config file changes, checked at the interval
+// Returns a function to stop watching
+func WatchConfiguration(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	last := configFileVersion()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				last = configFileVersion()
+				reportReload(Reload())
+			case <-ticker.C:
+				current := configFileVersion()
+				if current.changed(last) {
+					last = current
+					reportReload(Reload())
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(hup)
+			close(done)
+		})
+	}
+}
+
+// reportReload writes the error of a watched reload to stderr
+func reportReload(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Logger reload failed: %s\n", err.Error())
+	}
+}
+
+// fileVersion identifies the contents of a config file by path, size and
+// modification time
+type fileVersion struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// changed returns true if the file differs from the previous version
+func (f fileVersion) changed(prev fileVersion) bool {
+	return f.path != prev.path || f.size != prev.size ||
+		!f.modTime.Equal(prev.modTime)
+}
+
+// configVersion provides the versions of the merged config files in order
+type configVersion []fileVersion
+
+// changed returns true if any file differs from the previous version
+func (c configVersion) changed(prev configVersion) bool {
+	if len(c) != len(prev) {
+		return true
+	}
+	for i := range c {
+		if c[i].changed(prev[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// configFileVersion returns the versions of the config files selected by
+// the environment, empty if the configuration is not read from a file
+func configFileVersion() configVersion {
+	cfgType, cfgFile := environmentConfigType()
+	if cfgType != FileConfig && cfgType != BothConfig {
+		return nil
+	}
+	var versions configVersion
+	for _, name := range configFileNames(cfgFile) {
+		var version fileVersion
+		if path := findConfigFile(name); path != "" {
+			if info, err := os.Stat(path); err == nil {
+				version = fileVersion{path, info.Size(), info.ModTime()}
+			}
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// findConfigFile returns the first config file found as searched by viper
+func findConfigFile(name string) string {
+	for _, dir := range configPaths {
+		dir = os.ExpandEnv(dir)
+		for _, ext := range viper.SupportedExts {
+			path := filepath.Join(dir, name+"."+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
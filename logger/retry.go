@@ -0,0 +1,191 @@
+package logger
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the retry policies overridden by RetryEnvPrefix_<name> with
+// attempts, backoff, maxbackoff and jitter settings
+const (
+	RetryProducer = "PRODUCER" // kafka ProdRetryPolicy
+	RetryMetadata = "METADATA" // kafka MetaRetryPolicy
+	RetryDelivery = "DELIVERY" // kafka DeliveryRetryPolicy
+	RetryHTTP     = "HTTP"
+	RetryKinesis  = "KINESIS"
+	RetrySQS      = "SQS"
+	RetrySyslog   = "SYSLOG"
+	RetryFile     = "FILE"
+)
+
+// RetryPolicy provides the retries of a failed operation shared by kafka,
+// the sinks and file operations
+// Retry n waits Backoff doubled n times, capped at MaxBackoff, less a random
+// Jitter fraction of the delay
+// A zero MaxAttempts selects the RetryMax setting of the sink and a zero
+// Backoff its RetryFreq setting, each independently
+type RetryPolicy struct {
+	MaxAttempts int           // including the first, 1 disables retries
+	Backoff     time.Duration // delay of the first retry
+	MaxBackoff  time.Duration // 0 for no cap
+	Jitter      float64       // 0 to 1, 0 for fixed delays
+
+	// Retryable classifies errors, nil selects the default of the sink
+	Retryable func(err error) bool `json:"-" yaml:"-" mapstructure:"-"`
+}
+
+// resolve returns the policy with the legacy settings and classifier of
+// the sink used for each unset value
+func (p RetryPolicy) resolve(retryMax int, retryFreq time.Duration,
+	retryable func(err error) bool) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = retryMax + 1
+	}
+	if p.Backoff == 0 {
+		p.Backoff = retryFreq
+	}
+	if p.Retryable == nil {
+		p.Retryable = retryable
+	}
+	return p
+}
+
+// Retries returns the number of retries after the first attempt
+func (p RetryPolicy) Retries() int {
+	if p.MaxAttempts <= 1 {
+		return 0
+	}
+	return p.MaxAttempts - 1
+}
+
+// Delay returns the delay before the retry, counting from zero
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < retry && delay > 0; i++ {
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+		if delay > time.Duration(1<<62) {
+			break // no overflow
+		}
+		delay <<= 1
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 && delay > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// ShouldRetry returns true if the error may succeed on the retry, counting
+// from zero
+func (p RetryPolicy) ShouldRetry(retry int, err error) bool {
+	if err == nil || retry >= p.Retries() {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// do calls fn until it succeeds or its error is not retried, the last
+// error is returned
+func (p RetryPolicy) do(fn func() error) error {
+	err := fn()
+	for retry := 0; p.ShouldRetry(retry, err); retry++ {
+		time.Sleep(p.Delay(retry))
+		err = fn()
+	}
+	return err
+}
+
+// fileRetryable returns true unless the file error is permanent
+func fileRetryable(err error) bool {
+	return !os.IsNotExist(err) && !os.IsPermission(err)
+}
+
+// parseRetryPolicy returns the policy overridden by the comma separated
+// attempts, backoff, maxbackoff and jitter settings
+func parseRetryPolicy(policy RetryPolicy, value string) (RetryPolicy,
+	error) {
+	for _, setting := range strings.Split(value, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return policy, fmt.Errorf("Invalid retry setting: %s", setting)
+		}
+		var err error
+		switch key, val := strings.ToLower(parts[0]), parts[1]; key {
+		case "attempts":
+			policy.MaxAttempts, err = strconv.Atoi(val)
+		case "backoff":
+			policy.Backoff, err = time.ParseDuration(val)
+		case "maxbackoff":
+			policy.MaxBackoff, err = time.ParseDuration(val)
+		case "jitter":
+			policy.Jitter, err = strconv.ParseFloat(val, 64)
+		default:
+			return policy, fmt.Errorf("Invalid retry setting: %s", setting)
+		}
+		if err != nil {
+			return policy, fmt.Errorf("Invalid retry setting %s: %s",
+				setting, err.Error())
+		}
+	}
+	return policy, nil
+}
+
+// withRetryEnvironment returns the configuration with the retry policies
+// overridden by the PRRETRY_<name> environment variables
+func (lc LoggerConfiguration) withRetryEnvironment() (LoggerConfiguration,
+	error) {
+	policies := map[string]*RetryPolicy{
+		RetryProducer: &lc.KafkaProducerCfg.ProdRetryPolicy,
+		RetryMetadata: &lc.KafkaProducerCfg.MetaRetryPolicy,
+		RetryDelivery: &lc.KafkaProducerCfg.DeliveryRetryPolicy,
+		RetryHTTP:     &lc.HTTPCfg.RetryPolicy,
+		RetryKinesis:  &lc.KinesisCfg.RetryPolicy,
+		RetrySQS:      &lc.SQSCfg.RetryPolicy,
+		RetrySyslog:   &lc.SyslogCfg.RetryPolicy,
+		RetryFile:     &lc.FileRetryPolicy,
+	}
+	for name, policy := range policies {
+		value := os.Getenv(RetryEnvPrefix + "_" + name)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseRetryPolicy(*policy, value)
+		if err != nil {
+			return lc, fmt.Errorf("%s_%s: %w", RetryEnvPrefix, name, err)
+		}
+		*policy = parsed
+	}
+	return lc, nil
+}
+
+// checkRetryPolicy checks the retry policy of the named output
+func checkRetryPolicy(name string, policy RetryPolicy, errCount *int) {
+	if policy.MaxAttempts < 0 {
+		fmt.Fprintf(os.Stderr, "%s MaxAttempts less than zero\n", name)
+		*errCount++
+	}
+	if policy.Backoff < 0 {
+		fmt.Fprintf(os.Stderr, "%s Backoff less than zero\n", name)
+		*errCount++
+	}
+	if policy.MaxBackoff < 0 {
+		fmt.Fprintf(os.Stderr, "%s MaxBackoff less than zero\n", name)
+		*errCount++
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		fmt.Fprintf(os.Stderr, "%s Jitter not between zero and one\n", name)
+		*errCount++
+	}
+}
@@ -1,28 +1,498 @@
 package logger
 
 import (
+	"compress/gzip"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
+// rotationIntervalType provides time based rotation interval type
+type rotationIntervalType string
+
+// Types of time based rotation intervals
+const (
+	RotateNever  rotationIntervalType = ""       // default, size based only
+	RotateHourly rotationIntervalType = "hourly" // at the start of each hour
+	RotateDaily  rotationIntervalType = "daily"  // at midnight
+)
+
+// Placeholders of the rotated file template, the date and hour are those
+// of the period the file was written in
+const (
+	RotationNamePlaceholder = "{name}" // file name without extension
+	RotationExtPlaceholder  = "{ext}"  // file extension, e.g. .log
+	RotationDatePlaceholder = "{date}" // 2006-01-02
+	RotationHourPlaceholder = "{hour}" // 15
+)
+
+// Default rotated file templates by interval
+// Example: app.log is rotated daily to app-2024-05-01.log
+const (
+	defaultDailyTemplate  = "{name}-{date}{ext}"
+	defaultHourlyTemplate = "{name}-{date}-{hour}{ext}"
+)
+
 // RotationConfiguration stores the config for log rotation
+// Files are rotated by size and, if Interval is set, by time
+// Time rotated files are named by FileTemplate, the interval default if
+// empty, in the log directory and kept as by MaxBackups and MaxAge apart
+// from the size rotated files
+// OnRotate is called in a goroutine with each rotated file once compressed,
+// time rotated files one at a time in rotation order
+// Idle files are rotated by a timer at the end of their period
 type RotationConfiguration struct {
-	MaxSize    int
-	MaxAge     int
-	MaxBackups int
-	LocalTime  bool
-	Compress   bool
+	MaxSize      int
+	MaxAge       int
+	MaxBackups   int
+	LocalTime    bool
+	Compress     bool
+	Interval     rotationIntervalType
+	FileTemplate string
+	OnRotate     func(filename string) `json:"-" yaml:"-" mapstructure:"-"`
+}
+
+// defaultRotationMaxSize is the lumberjack max size when MaxSize is zero
+const defaultRotationMaxSize = 100
+
+// lumberjackTimeFormat is the time format of lumberjack backup file names
+const lumberjackTimeFormat = "2006-01-02T15-04-05.000"
+
+// compressWait is how long OnRotate waits for lumberjack to compress a
+// size rotated file
+const compressWait = time.Minute
+
+// rotationWriter counts the rotations made by the lumberjack logger and
+// rotates the file by time
+// Rotation is predicted with the same size check lumberjack makes
+type rotationWriter struct {
+	*lumberjack.Logger
+	mutex      sync.Mutex
+	size       int64
+	maxSize    int64
+	opened     bool
+	closed     bool
+	config     RotationConfiguration
+	period     time.Time   // start of the period the file is written in
+	timer      *time.Timer // rotates the file once the period ends
+	rotated    []string    // time rotated files not yet processed
+	queued     chan struct{}
+	processing bool
+	processed  chan struct{} // closed once the processing goroutine exits
 }
 
 func rotationLogger(filename string, config RotationConfiguration) io.Writer {
+	maxSize := config.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultRotationMaxSize
+	}
+	if config.FileTemplate == "" {
+		switch config.Interval {
+		case RotateDaily:
+			config.FileTemplate = defaultDailyTemplate
+		case RotateHourly:
+			config.FileTemplate = defaultHourlyTemplate
+		}
+	}
+
+	return &rotationWriter{
+		Logger: &lumberjack.Logger{
+			Filename:   filename,
+			MaxSize:    config.MaxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+			LocalTime:  config.LocalTime,
+			Compress:   config.Compress,
+		},
+		maxSize:   int64(maxSize) * 1024 * 1024,
+		config:    config,
+		queued:    make(chan struct{}, 1),
+		processed: make(chan struct{}),
+	}
+}
+
+// Write writes to the lumberjack logger counting rotations, the file is
+// first rotated if the period it was written in has ended
+func (w *rotationWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.opened {
+		w.period = w.periodStart(now())
+		if info, err := os.Stat(w.Filename); err == nil {
+			w.size = info.Size()
+			w.period = w.periodStart(info.ModTime())
+		}
+		w.opened = true
+		w.schedule()
+	}
+	w.rotatePeriod()
+
+	var rotated bool
+	var before time.Time
+	writeLen := int64(len(p))
+	if w.size+writeLen > w.maxSize && writeLen <= w.maxSize {
+		countMetric(MetricRotations, "", "")
+		w.size = 0
+		rotated, before = true, time.Now()
+	}
+	n, err := w.Logger.Write(p)
+	w.size += int64(n)
+	if rotated && w.config.OnRotate != nil {
+		go w.sizeRotated(before, time.Now())
+	}
+	return n, err
+}
+
+// rotatePeriod rotates the file if the period it was written in has ended,
+// called with the mutex held
+func (w *rotationWriter) rotatePeriod() {
+	if w.config.Interval == RotateNever || w.closed {
+		return
+	}
+	if period := w.periodStart(now()); period.After(w.period) {
+		if w.size > 0 {
+			w.rotate()
+		}
+		w.period = period
+	}
+}
+
+// schedule starts the timer rotating the file at the end of its period,
+// called with the mutex held
+func (w *rotationWriter) schedule() {
+	if w.config.Interval == RotateNever || w.closed {
+		return
+	}
+	var end time.Time
+	switch w.config.Interval {
+	case RotateHourly:
+		end = w.period.Add(time.Hour)
+	default:
+		end = w.period.AddDate(0, 0, 1)
+	}
+	w.timer = time.AfterFunc(end.Sub(now()), w.rotateIdle)
+}
+
+// rotateIdle rotates the file not written since its period ended
+func (w *rotationWriter) rotateIdle() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	w.rotatePeriod()
+	w.schedule()
+}
+
+// periodStart returns the start of the interval period of the time
+func (w *rotationWriter) periodStart(t time.Time) time.Time {
+	if w.config.LocalTime {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	hour := 0
+	if w.config.Interval == RotateHourly {
+		hour = t.Hour()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0,
+		t.Location())
+}
+
+// rotate renames the file to the template name of its period, the
+// lumberjack logger opens a new file on the next write
+func (w *rotationWriter) rotate() {
+	if err := w.Logger.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close log file: %s\n", err.Error())
+		return
+	}
+	name := w.rotatedName()
+	if err := os.Rename(w.Filename, name); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to rotate log file: %s\n", err.Error())
+		return
+	}
+	countMetric(MetricRotations, "", "")
+	w.size = 0
+	w.rotated = append(w.rotated, name)
+	if !w.processing {
+		w.processing = true
+		go w.processRotated()
+	}
+	select {
+	case w.queued <- struct{}{}:
+	default:
+	}
+}
+
+// rotatedName returns the unused template name of the file of the period,
+// a sequence number is appended if the name is in use
+func (w *rotationWriter) rotatedName() string {
+	base := filepath.Base(w.Filename)
+	ext := filepath.Ext(base)
+	name := strings.NewReplacer(
+		RotationNamePlaceholder, strings.TrimSuffix(base, ext),
+		RotationExtPlaceholder, ext,
+		RotationDatePlaceholder, w.period.Format("2006-01-02"),
+		RotationHourPlaceholder, w.period.Format("15"),
+	).Replace(w.config.FileTemplate)
+	path := filepath.Join(filepath.Dir(w.Filename), name)
+
+	rotated := path
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = path + "." + strconv.Itoa(i)
+	}
+	return rotated
+}
+
+// processRotated processes the time rotated files in rotation order until
+// the writer is closed
+func (w *rotationWriter) processRotated() {
+	defer close(w.processed)
+	for range w.queued {
+		for {
+			w.mutex.Lock()
+			if len(w.rotated) == 0 {
+				w.mutex.Unlock()
+				break
+			}
+			name := w.rotated[0]
+			w.rotated = w.rotated[1:]
+			w.mutex.Unlock()
+			w.timeRotated(name)
+		}
+	}
+}
+
+// Close stops the rotation timer, waits for the time rotated files to be
+// processed and closes the file
+func (w *rotationWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return w.Logger.Close()
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	processing := w.processing
+	close(w.queued)
+	w.mutex.Unlock()
+
+	if processing {
+		<-w.processed
+	}
+	return w.Logger.Close()
+}
+
+// timeRotated compresses the rotated file, removes expired rotated files
+// and calls OnRotate
+func (w *rotationWriter) timeRotated(name string) {
+	if w.config.Compress {
+		if err := compressFile(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compress log file: %s\n",
+				err.Error())
+		} else {
+			name += ".gz"
+		}
+	}
+	w.removeExpired(name)
+	if w.config.OnRotate != nil {
+		w.config.OnRotate(name)
+	}
+}
+
+// rotatedFile provides a time rotated file and its period
+type rotatedFile struct {
+	path   string
+	period time.Time
+}
+
+// removeExpired removes the time rotated files beyond MaxBackups or of
+// periods started more than MaxAge days ago, counting those rotated up to
+// the processed file, later files are still queued
+func (w *rotationWriter) removeExpired(processed string) {
+	if w.config.MaxBackups == 0 && w.config.MaxAge == 0 {
+		return
+	}
+	all := w.rotatedFiles()
+	var latest time.Time
+	for _, file := range all {
+		if file.path == processed {
+			latest = file.period
+		}
+	}
+	var files []rotatedFile
+	for _, file := range all {
+		if !file.period.After(latest) {
+			files = append(files, file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].period.After(files[j].period)
+	})
+	cutoff := now().Add(-time.Duration(w.config.MaxAge) * 24 * time.Hour)
+	for i, file := range files {
+		if (w.config.MaxBackups > 0 && i >= w.config.MaxBackups) ||
+			(w.config.MaxAge > 0 && file.period.Before(cutoff)) {
+			os.Remove(file.path)
+		}
+	}
+}
+
+// rotatedFiles returns the time rotated files matching the template
+func (w *rotationWriter) rotatedFiles() []rotatedFile {
+	base := filepath.Base(w.Filename)
+	ext := filepath.Ext(base)
+	pattern := regexp.QuoteMeta(w.config.FileTemplate)
+	pattern = strings.NewReplacer(
+		regexp.QuoteMeta(RotationNamePlaceholder),
+		regexp.QuoteMeta(strings.TrimSuffix(base, ext)),
+		regexp.QuoteMeta(RotationExtPlaceholder), regexp.QuoteMeta(ext),
+		regexp.QuoteMeta(RotationDatePlaceholder),
+		`(?P<date>\d{4}-\d{2}-\d{2})`,
+		regexp.QuoteMeta(RotationHourPlaceholder), `(?P<hour>\d{2})`,
+	).Replace(pattern)
+	re, err := regexp.Compile(`^` + pattern + `(\.(?P<seq>\d+))?(\.gz)?$`)
+	if err != nil {
+		return nil
+	}
 
-	return &lumberjack.Logger{
-		Filename:   filename,
-		MaxSize:    config.MaxSize,
-		MaxBackups: config.MaxBackups,
-		MaxAge:     config.MaxAge,
-		LocalTime:  config.LocalTime,
-		Compress:   config.Compress,
+	location := time.UTC
+	if w.config.LocalTime {
+		location = time.Local
 	}
+	dir := filepath.Dir(w.Filename)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []rotatedFile
+	for _, entry := range entries {
+		match := re.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		var date, hour string
+		seq := 0
+		for i, group := range re.SubexpNames() {
+			switch group {
+			case "date":
+				date = match[i]
+			case "hour":
+				hour = match[i]
+			case "seq":
+				seq, _ = strconv.Atoi(match[i])
+			}
+		}
+		if hour == "" {
+			hour = "00"
+		}
+		period, err := time.ParseInLocation("2006-01-02 15", date+" "+hour,
+			location)
+		if err != nil {
+			continue
+		}
+		// later files of the same period have higher sequence numbers
+		period = period.Add(time.Duration(seq) * time.Nanosecond)
+		files = append(files, rotatedFile{filepath.Join(dir, entry.Name()),
+			period})
+	}
+	return files
+}
+
+// sizeRotated calls OnRotate with the file lumberjack rotated to between
+// the times, once compressed if Compress is set
+func (w *rotationWriter) sizeRotated(before, after time.Time) {
+	base := filepath.Base(w.Filename)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	location := time.UTC
+	if w.config.LocalTime {
+		location = time.Local
+	}
+
+	dir := filepath.Dir(w.Filename)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var backup string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(lumberjackTimeFormat,
+			name[len(prefix):len(name)-len(ext)], location)
+		if err != nil || t.Before(before.Truncate(time.Millisecond)) ||
+			t.After(after) {
+			continue
+		}
+		backup = filepath.Join(dir, name)
+	}
+	if backup == "" {
+		return
+	}
+	if w.config.Compress {
+		backup = waitCompressed(backup)
+	}
+	w.config.OnRotate(backup)
+}
+
+// waitCompressed returns the name of the file compressed by lumberjack,
+// the name itself if not compressed within compressWait
+func waitCompressed(name string) string {
+	deadline := time.Now().Add(compressWait)
+	for time.Now().Before(deadline) {
+		if !fileExists(name) && fileExists(name+".gz") {
+			return name + ".gz"
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return name
+}
+
+// compressFile replaces the file with its gzip compressed .gz file
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
+
+// fileExists returns true if the file exists
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
 }
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"fmt"
+)
+
+// RouteRule provides a rule sending matching records to a topic
+// A record matches if its level is at or above Level and it has all the
+// Fields values, an empty Level or Fields matches any record
+type RouteRule struct {
+	Level  LevelType
+	Fields map[string]string
+	Topic  string
+}
+
+// levelSeverities orders the log levels from least to most severe
+var levelSeverities = map[LevelType]int{
+	DebugType: 0,
+	InfoType:  1,
+	WarnType:  2,
+	ErrorType: 3,
+	FatalType: 4,
+	PanicType: 5,
+}
+
+// matches returns true if the record level and fields match the rule
+func (r RouteRule) matches(level string, msgMap map[string]interface{}) bool {
+	if r.Level != "" {
+		recordLevel, err := ParseLevel(level)
+		if err != nil ||
+			levelSeverities[recordLevel] <
+				levelSeverities[normalizeLevel(r.Level)] {
+			return false
+		}
+	}
+	for key, value := range r.Fields {
+		field, ok := msgMap[key]
+		if !ok || fmt.Sprint(field) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTopic returns the topic of the first rule matching the record
+func (kp *KafkaProducer) routeTopic(msgMap map[string]interface{}) (string,
+	bool) {
+	level, ok := msgMap[kp.levelKey].(string)
+	if !ok {
+		level, _ = msgMap[kp.recordLevel].(string)
+	}
+	for _, rule := range kp.config.RouteRules {
+		if rule.matches(level, msgMap) {
+			return rule.Topic, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+)
+
+// SampledHashPrefix prefixes the hashed values of sampled fields
+const SampledHashPrefix = "hash:"
+
+// SampledHashBuckets is the number of hashed values of a sampled field, so
+// the cardinality of the field stays bounded whatever its values
+const SampledHashBuckets = 1024
+
+// sampledFields returns the fields function of WithSampledFields, the values
+// are kept on a rate fraction of records and hashed on the others
+func sampledFields(rate float64, keyValues LogFields) func() LogFields {
+	hashed := make(LogFields, len(keyValues))
+	for key, value := range keyValues {
+		hashed[key] = hashValue(value)
+	}
+	return func() LogFields {
+		if rate >= 1 || (rate > 0 && rand.Float64() < rate) {
+			return keyValues
+		}
+		return hashed
+	}
+}
+
+// hashValue returns the bucket of the sha256 of the value printed, equal
+// values hash the same so records can still be grouped by them
+func hashValue(value interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % SampledHashBuckets
+	return fmt.Sprintf("%s%d", SampledHashPrefix, bucket)
+}
@@ -0,0 +1,228 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConfiguration provides sampling of records sent to kafka
+// Identical messages beyond Initial per Tick are sampled every Thereafter,
+// then records are limited to BurstRate per second with bursts of BurstSize
+type SamplingConfiguration struct {
+	Initial    int
+	Thereafter int // 0 drops all records beyond Initial
+	Tick       time.Duration
+	Levels     map[LevelType]SamplingLevel // per level Initial and Thereafter
+	BurstRate  float64                     // 0 disables the token bucket
+	BurstSize  int
+}
+
+// SamplingLevel provides sampling overrides for a log level
+type SamplingLevel struct {
+	Initial    int
+	Thereafter int
+}
+
+// defaultSamplingTick is the sampling interval when Tick is zero
+const defaultSamplingTick = time.Second
+
+// samplingKey identifies identical messages
+type samplingKey struct {
+	level   LevelType
+	message string
+}
+
+// sampler decides which records are sent, used by logrus and slog
+type sampler struct {
+	config SamplingConfiguration
+	mutex  sync.Mutex
+	tick   time.Time
+	counts map[samplingKey]int
+	bucket *tokenBucket
+}
+
+// newSampler returns a sampler instance for the configuration
+func newSampler(config SamplingConfiguration) *sampler {
+	if config.Tick <= 0 {
+		config.Tick = defaultSamplingTick
+	}
+	s := &sampler{
+		config: config,
+		counts: make(map[samplingKey]int),
+	}
+	if config.BurstRate > 0 {
+		s.bucket = newTokenBucket(config.BurstRate, config.BurstSize)
+	}
+	return s
+}
+
+// limits returns the initial and thereafter counts for the level
+func (sc SamplingConfiguration) limits(level LevelType) (int, int) {
+	if override, ok := sc.Levels[level]; ok {
+		return override.Initial, override.Thereafter
+	}
+	return sc.Initial, sc.Thereafter
+}
+
+// allow returns true if the record is sent, counting suppressed records
+func (s *sampler) allow(level LevelType, message string) bool {
+	if !s.sample(level, message) ||
+		(s.bucket != nil && !s.bucket.allow()) {
+		countSuppressed(level)
+		return false
+	}
+	return true
+}
+
+// sample counts the message in the current tick, zap sampler semantics
+func (s *sampler) sample(level LevelType, message string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	t := now()
+	if t.Sub(s.tick) >= s.config.Tick {
+		s.tick = t
+		s.counts = make(map[samplingKey]int)
+	}
+	key := samplingKey{level, message}
+	s.counts[key]++
+	n := s.counts[key]
+
+	initial, thereafter := s.config.limits(level)
+	if n <= initial {
+		return true
+	}
+	return thereafter > 0 && (n-initial)%thereafter == 0
+}
+
+// tokenBucket limits the rate of records allowing bursts
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket instance
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now(),
+	}
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t := now()
+	b.tokens += t.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = t
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// countSuppressed increments the suppressed records counter for the level
+func countSuppressed(level LevelType) {
+	countMetric(MetricSuppressed, "level", string(level))
+}
+
+// zapLevelFilterCore passes entries for the levels selected by enabled
+type zapLevelFilterCore struct {
+	zapcore.Core
+	enabled func(zapcore.Level) bool
+}
+
+// Enabled meets the interface for the zap core
+func (c *zapLevelFilterCore) Enabled(level zapcore.Level) bool {
+	return c.enabled(level) && c.Core.Enabled(level)
+}
+
+// With meets the interface for the zap core
+func (c *zapLevelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapLevelFilterCore{c.Core.With(fields), c.enabled}
+}
+
+// Check meets the interface for the zap core
+func (c *zapLevelFilterCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// zapBurstCore limits the rate of entries with a token bucket
+type zapBurstCore struct {
+	zapcore.Core
+	bucket *tokenBucket
+}
+
+// With meets the interface for the zap core
+func (c *zapBurstCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapBurstCore{c.Core.With(fields), c.bucket}
+}
+
+// Check meets the interface for the zap core
+func (c *zapBurstCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+	if !c.bucket.allow() {
+		countSuppressed(getZapLevelType(entry.Level))
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// newZapSamplingCore wraps the core in zap sampler cores for the configuration
+// Levels with overrides are sampled by their own sampler core
+func newZapSamplingCore(core zapcore.Core,
+	config SamplingConfiguration) zapcore.Core {
+
+	if config.Tick <= 0 {
+		config.Tick = defaultSamplingTick
+	}
+	if config.BurstRate > 0 {
+		core = &zapBurstCore{core,
+			newTokenBucket(config.BurstRate, config.BurstSize)}
+	}
+	hook := zapcore.SamplerHook(func(entry zapcore.Entry,
+		dec zapcore.SamplingDecision) {
+		if dec&zapcore.LogDropped != 0 {
+			countSuppressed(getZapLevelType(entry.Level))
+		}
+	})
+
+	cores := []zapcore.Core{}
+	overridden := map[zapcore.Level]bool{}
+	for level, override := range config.Levels {
+		zapLevel := getZapLevel(level)
+		overridden[zapLevel] = true
+		filter := &zapLevelFilterCore{core, func(l zapcore.Level) bool {
+			return l == zapLevel
+		}}
+		cores = append(cores, zapcore.NewSamplerWithOptions(filter,
+			config.Tick, override.Initial, override.Thereafter, hook))
+	}
+	filter := &zapLevelFilterCore{core, func(l zapcore.Level) bool {
+		return !overridden[l]
+	}}
+	cores = append(cores, zapcore.NewSamplerWithOptions(filter, config.Tick,
+		config.Initial, config.Thereafter, hook))
+	return zapcore.NewTee(cores...)
+}
@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// schemaFormatType provides schema registry serialization type
+type schemaFormatType string
+
+// Types of schema registry serialization
+const (
+	SchemaAvro schemaFormatType = "avro" // default
+	SchemaJSON schemaFormatType = "json"
+)
+
+// Schema registry wire format header
+const (
+	schemaMagicByte  = 0
+	schemaHeaderSize = 5
+)
+
+// avroLogSchema is the default avro schema, records are maps of primitives
+// Nested values are encoded as JSON strings
+const avroLogSchema = `{"type":"map","values":` +
+	`["null","boolean","long","double","string"]}`
+
+// jsonLogSchema is the default JSON schema, records are JSON objects
+const jsonLogSchema = `{"type":"object"}`
+
+// SchemaRegistryConfiguration provides schema registry configuration type
+type SchemaRegistryConfiguration struct {
+	URL      string
+	Subject  string // default <topic>-value
+	Format   schemaFormatType
+	Schema   string // default generic log record schema
+	Username string
+	Password string `secret:"true"`
+	Timeout  time.Duration
+}
+
+// schemaRegistry serializes records with the registered schema id
+type schemaRegistry struct {
+	format   schemaFormatType
+	schemaID uint32
+	avro     *avroSchema // records are encoded with, if avro
+}
+
+// newSchemaRegistry registers the schema and returns a registry instance
+func newSchemaRegistry(config SchemaRegistryConfiguration,
+	topic string) (*schemaRegistry, error) {
+
+	if config.Format == "" {
+		config.Format = defaultSchemaRegistryConfiguration.Format
+	}
+	if config.Subject == "" {
+		config.Subject = topic + "-value"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSchemaRegistryConfiguration.Timeout
+	}
+	if config.Schema == "" {
+		config.Schema = avroLogSchema
+		if config.Format == SchemaJSON {
+			config.Schema = jsonLogSchema
+		}
+	}
+
+	var avro *avroSchema
+	if config.Format == SchemaAvro {
+		var err error
+		if avro, err = parseAvroSchema(config.Schema); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := registerSchema(config)
+	if err != nil {
+		return nil, err
+	}
+	return &schemaRegistry{
+		format:   config.Format,
+		schemaID: id,
+		avro:     avro,
+	}, nil
+}
+
+// registerSchema registers the schema under the subject and returns its id
+// The id of an identical schema already registered is returned
+func registerSchema(config SchemaRegistryConfiguration) (uint32, error) {
+	request := map[string]string{"schema": config.Schema}
+	if config.Format == SchemaJSON {
+		request["schemaType"] = "JSON"
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions", config.URL,
+		url.PathEscape(config.Subject))
+	req, err := http.NewRequest(http.MethodPost, endpoint,
+		bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if config.Username != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+
+	client := &http.Client{Timeout: config.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Schema registration failed: %s", resp.Status)
+	}
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.ID, nil
+}
+
+// encode serializes the record in the wire format with the schema id,
+// avro records are encoded with the configured schema
+func (sr *schemaRegistry) encode(value interface{}) ([]byte, error) {
+	buf := make([]byte, schemaHeaderSize)
+	buf[0] = schemaMagicByte
+	binary.BigEndian.PutUint32(buf[1:], sr.schemaID)
+
+	if sr.format == SchemaJSON {
+		payload, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, payload...), nil
+	}
+	return sr.avro.encode(buf, value)
+}
@@ -0,0 +1,178 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// SendCallback func called once a message is acknowledged or has failed
+// Partition and offset are -1 when err is not nil, err is ErrSpooled when
+// the message is spooled until kafka is reachable, the callback is called
+// again once the spooled message is replayed by the producer
+type SendCallback func(partition, offset int64, err error)
+
+// Sender publishes events to kafka using the producer configuration
+// Messages are sent as is, without log record processing
+type Sender struct {
+	kp           *KafkaProducer
+	syncMutex    sync.RWMutex
+	syncProducer sarama.SyncProducer // created by the first SendSync
+}
+
+// NewSender returns a sender instance
+func NewSender(config ProducerConfiguration) (*Sender, error) {
+	errCount := 0
+	checkProducerConfig(config, &errCount)
+	if errCount > 0 {
+		return nil, errors.New("Invalid producer configuration")
+	}
+
+	kp, err := newKafkaProducer(config, nil, CloudEventsConfiguration{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{kp: kp}, nil
+}
+
+// Send sends a message to the topic, the default topic if empty
+func (s *Sender) Send(topic, key string, value []byte) error {
+	return s.SendWithCallback(topic, key, value, nil)
+}
+
+// SendWithCallback sends a message to the topic, the default topic if empty
+// The callback is called from the producer goroutine once the message is
+// acknowledged or has failed, it is not called if an error is returned
+func (s *Sender) SendWithCallback(topic, key string, value []byte,
+	callback SendCallback) error {
+	topic, err := s.TopicName(topic)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:    topic,
+		Value:    sarama.ByteEncoder(value),
+		Metadata: messageMeta{callback: callback},
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return s.kp.enqueue(msg)
+}
+
+// SendBatch sends the messages to the topic, the default topic if empty,
+// without waiting for them to be acknowledged, use Flush to wait
+// Returns the error of the first message not sent, the messages before it
+// are sent
+func (s *Sender) SendBatch(topic string, msgs [][]byte) error {
+	for i, value := range msgs {
+		if err := s.SendWithCallback(topic, "", value, nil); err != nil {
+			return fmt.Errorf("Sent %d of %d messages: %w", i, len(msgs), err)
+		}
+	}
+	return nil
+}
+
+// SendSync sends a message to the topic, the default topic if empty, and
+// waits until it is acknowledged, returning its partition and offset
+// Messages are sent by a sync producer sharing the producer client, they
+// are not spooled, retried or written to the dead letter outputs
+func (s *Sender) SendSync(topic, key string, value []byte) (int32, int64,
+	error) {
+	topic, err := s.TopicName(topic)
+	if err != nil {
+		return -1, -1, err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	producer, err := s.getSyncProducer()
+	if err != nil {
+		return -1, -1, err
+	}
+	s.syncMutex.RLock()
+	defer s.syncMutex.RUnlock()
+	if s.syncProducer == nil {
+		return -1, -1, ErrProducerClosed
+	}
+	partition, offset, err := producer.SendMessage(msg)
+	if err != nil {
+		countMetric(MetricKafkaFailures, "", "")
+		s.kp.stats.failed(err)
+		recordError(err)
+		return -1, -1, err
+	}
+	countMetric(MetricKafkaSuccesses, "", "")
+	s.kp.stats.delivered(topic, partition)
+	return partition, offset, nil
+}
+
+// TopicName returns the topic messages sent to the name are sent to, the
+// default topic if empty, as by the TopicNaming convention
+// An error wrapping ErrInvalidTopic is returned if the topic is not valid
+func (s *Sender) TopicName(name string) (string, error) {
+	if name == "" {
+		return s.kp.config.Topic, nil
+	}
+	return s.kp.topicName(name)
+}
+
+// getSyncProducer returns the sync producer, created on first use
+func (s *Sender) getSyncProducer() (sarama.SyncProducer, error) {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	if s.syncProducer != nil {
+		return s.syncProducer, nil
+	}
+	s.kp.closeMutex.RLock()
+	defer s.kp.closeMutex.RUnlock()
+	if s.kp.closed {
+		return nil, ErrProducerClosed
+	}
+	producer, err := s.kp.newSyncProducer()
+	if err != nil {
+		return nil, err
+	}
+	s.syncProducer = producer
+	return producer, nil
+}
+
+// newSyncProducer returns a sync producer sharing the client of the
+// producer, or recording to the mock broker
+func (kp *KafkaProducer) newSyncProducer() (sarama.SyncProducer, error) {
+	if kp.config.EnableOffline {
+		return nil, ErrOffline
+	}
+	if metadata, ok := kp.metadata.(*saramaMetadata); ok {
+		return sarama.NewSyncProducerFromClient(metadata.client)
+	}
+	return newMockSyncProducer(kp.partitioner), nil
+}
+
+// Flush waits until all sent messages are acknowledged or failed
+func (s *Sender) Flush(timeout time.Duration) error {
+	return s.kp.flush(timeout)
+}
+
+// Close sends pending messages and closes the sender
+func (s *Sender) Close() error {
+	s.syncMutex.Lock()
+	var err error
+	if s.syncProducer != nil {
+		err = s.syncProducer.Close()
+		s.syncProducer = nil
+	}
+	s.syncMutex.Unlock()
+	if kerr := s.kp.close(); kerr != nil && err == nil {
+		err = kerr
+	}
+	return err
+}
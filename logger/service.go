@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"os"
+	"strings"
+)
+
+// Keys of the service metadata fields added to every record, namespaced
+// so they do not collide with the fields of applications
+const (
+	ServiceNameKey    = "service.name"
+	ServiceVersionKey = "service.version"
+	EnvironmentKey    = "deployment.environment"
+	HostNameKey       = "host.name"
+)
+
+// Placeholders replaced by the service metadata in the cloudevents source,
+// type, mapped types and extension values
+// Example: Type: "io.pavedroad.{service}.{environment}"
+const (
+	ServiceNameTemplate    = "{service}"
+	ServiceVersionTemplate = "{version}"
+	EnvironmentTemplate    = "{environment}"
+	HostNameTemplate       = "{host}"
+)
+
+// serviceFields returns the service metadata fields that are set
+func (lc LoggerConfiguration) serviceFields() LogFields {
+	fields := LogFields{}
+	for key, value := range map[string]string{
+		ServiceNameKey:     lc.ServiceName,
+		ServiceVersionKey:  lc.ServiceVersion,
+		EnvironmentKey:     lc.Environment,
+		HostNameKey:        lc.HostName,
+		ConfigHashFieldKey: lc.configHash,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// withHostName returns the config with the host name of the system if the
+// service is named and the host name is not set
+func (lc LoggerConfiguration) withHostName() LoggerConfiguration {
+	if lc.ServiceName == "" || lc.HostName != "" {
+		return lc
+	}
+	hostName, err := os.Hostname()
+	if err != nil {
+		recordError(err)
+		return lc
+	}
+	lc.HostName = hostName
+	return lc
+}
+
+// expandServiceTemplates returns the config with the cloudevents templates
+// replaced by the service metadata, the service name is also the
+// cloudevents service name if that is not set
+func (lc LoggerConfiguration) expandServiceTemplates() LoggerConfiguration {
+	replacer := strings.NewReplacer(
+		ServiceNameTemplate, lc.ServiceName,
+		ServiceVersionTemplate, lc.ServiceVersion,
+		EnvironmentTemplate, lc.Environment,
+		HostNameTemplate, lc.HostName,
+	)
+	expandMap := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		expanded := make(map[string]string, len(values))
+		for key, value := range values {
+			expanded[key] = replacer.Replace(value)
+		}
+		return expanded
+	}
+
+	ce := lc.CloudEventsCfg
+	if ce.ServiceName == "" {
+		ce.ServiceName = lc.ServiceName
+	}
+	ce.Source = replacer.Replace(ce.Source)
+	ce.Type = replacer.Replace(ce.Type)
+	ce.EventTypes = expandMap(ce.EventTypes)
+	ce.Extensions = expandMap(ce.Extensions)
+	if ce.LevelTypes != nil {
+		levelTypes := make(map[LevelType]string, len(ce.LevelTypes))
+		for level, value := range ce.LevelTypes {
+			levelTypes[level] = replacer.Replace(value)
+		}
+		ce.LevelTypes = levelTypes
+	}
+	lc.CloudEventsCfg = ce
+	return lc
+}
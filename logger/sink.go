@@ -0,0 +1,212 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry provides the record metadata passed to a sink with the record
+type Entry struct {
+	Level   LevelType
+	Time    time.Time
+	Message string
+}
+
+// Sink is the contract for a log output destination
+// Write receives each record formatted with the sink format
+type Sink interface {
+	Write(msg []byte, entry Entry) error
+
+	Close() error
+}
+
+// SinkConfiguration selects a registered sink and its record format
+type SinkConfiguration struct {
+	Type    string
+	Format  FormatType
+	Level   LevelType
+	Options map[string]string
+	http    *HTTPSinkConfiguration // set for the http sink only
+	syslog  *SyslogConfiguration   // set for the syslog sink only
+	kinesis *KinesisConfiguration  // set for the kinesis sink only
+	sqs     *SQSConfiguration      // set for the sqs sink only
+}
+
+// SinkFactory returns a sink instance for the configuration
+type SinkFactory func(config SinkConfiguration) (Sink, error)
+
+// Built-in sink types, with HTTPSinkType, SyslogSinkType, KinesisSinkType
+// and SQSSinkType
+const (
+	ConsoleSinkType = "console" // Options: "writer" stdout (default) or stderr
+	FileSinkType    = "file"    // Options: "location" file path
+)
+
+var (
+	sinkMutex     sync.RWMutex
+	sinkFactories = map[string]SinkFactory{
+		ConsoleSinkType: newConsoleSink,
+		FileSinkType:    newFileSink,
+		HTTPSinkType:    httpSinkFactory,
+		SyslogSinkType:  syslogSinkFactory,
+		KinesisSinkType: kinesisSinkFactory,
+		SQSSinkType:     sqsSinkFactory,
+	}
+)
+
+// RegisterSink adds a sink factory for the type name
+func RegisterSink(sinkType string, factory SinkFactory) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	sinkFactories[sinkType] = factory
+}
+
+// lookupSink returns the sink factory for the type name
+func lookupSink(sinkType string) (SinkFactory, bool) {
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+	factory, ok := sinkFactories[sinkType]
+	return factory, ok
+}
+
+// sinks returns the sink configurations with the http, syslog, kinesis and
+// sqs sinks if enabled
+func (lc LoggerConfiguration) sinks() []SinkConfiguration {
+	if !lc.EnableHTTP && !lc.EnableSyslog && !lc.EnableKinesis &&
+		!lc.EnableSQS {
+		return lc.Sinks
+	}
+	sinks := append([]SinkConfiguration{}, lc.Sinks...)
+	if lc.EnableHTTP {
+		httpCfg := lc.HTTPCfg
+		sinks = append(sinks, SinkConfiguration{
+			Type:   HTTPSinkType,
+			Format: lc.HTTPFormat,
+			Level:  lc.HTTPLevel,
+			http:   &httpCfg,
+		})
+	}
+	if lc.EnableSyslog {
+		syslogCfg := lc.SyslogCfg
+		sinks = append(sinks, SinkConfiguration{
+			Type:   SyslogSinkType,
+			Format: lc.SyslogFormat,
+			Level:  lc.SyslogLevel,
+			syslog: &syslogCfg,
+		})
+	}
+	if lc.EnableKinesis {
+		kinesisCfg := lc.KinesisCfg
+		sinks = append(sinks, SinkConfiguration{
+			Type:    KinesisSinkType,
+			Format:  lc.KinesisFormat,
+			Level:   lc.KinesisLevel,
+			kinesis: &kinesisCfg,
+		})
+	}
+	if lc.EnableSQS {
+		sqsCfg := lc.SQSCfg
+		sinks = append(sinks, SinkConfiguration{
+			Type:   SQSSinkType,
+			Format: lc.SQSFormat,
+			Level:  lc.SQSLevel,
+			sqs:    &sqsCfg,
+		})
+	}
+	return sinks
+}
+
+// newSink returns a sink instance for the configuration from the factory
+// registered for its type
+func newSink(config SinkConfiguration) (Sink, error) {
+	factory, ok := lookupSink(config.Type)
+	if !ok {
+		return nil, errors.New("Sink type not registered: " + config.Type)
+	}
+	return factory(config)
+}
+
+// sinkWriter provides an io.Writer for a sink
+// Used by packages that format records to a writer, entry set per record
+type sinkWriter struct {
+	mutex sync.Mutex
+	sink  Sink
+	ce    *CloudEvents
+	entry Entry
+}
+
+// newSinkWriter returns a sink writer instance
+// Cloudevents fields are added to the record if cloudEvents is not nil
+func newSinkWriter(sink Sink, cloudEvents *CloudEvents) *sinkWriter {
+	return &sinkWriter{
+		sink: sink,
+		ce:   cloudEvents,
+	}
+}
+
+// writeEntry passes the formatted record and entry to the sink
+func (w *sinkWriter) writeEntry(msg []byte, entry Entry) error {
+	var err error
+	if w.ce != nil {
+		msg, err = w.ce.ceTransform(msg)
+	}
+	if err == nil {
+		err = w.sink.Write(msg, entry)
+	}
+	recordError(err)
+	return err
+}
+
+// Write passes the record with the entry set before formatting
+func (w *sinkWriter) Write(msg []byte) (int, error) {
+	return len(msg), w.writeEntry(msg, w.entry)
+}
+
+// ioSink provides a sink writing records to an io.Writer
+type ioSink struct {
+	out    io.Writer
+	closer io.Closer
+}
+
+// Write writes the record to the writer
+func (s *ioSink) Write(msg []byte, entry Entry) error {
+	_, err := s.out.Write(msg)
+	return err
+}
+
+// Close closes the writer unless it is stdout or stderr
+func (s *ioSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// newConsoleSink returns a sink for stdout or stderr
+func newConsoleSink(config SinkConfiguration) (Sink, error) {
+	switch ConsoleType(config.Options["writer"]) {
+	case Stderr, Stdout, "":
+		return &ioSink{out: getConsoleWriter(
+			ConsoleType(config.Options["writer"]))}, nil
+	default:
+		return nil, errors.New("Invalid console sink writer: " +
+			config.Options["writer"])
+	}
+}
+
+// newFileSink returns a sink appending to a file
+func newFileSink(config SinkConfiguration) (Sink, error) {
+	location := config.Options["location"]
+	if location == "" {
+		location = defaultLoggerConfiguration.FileLocation
+	}
+	file, err := os.OpenFile(location,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &ioSink{out: file, closer: file}, nil
+}
@@ -0,0 +1,672 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels beyond slog error level
+const (
+	slogLevelFatal = slog.LevelError + 4
+	slogLevelPanic = slog.LevelError + 8
+)
+
+// slogLogger represents a log/slog logger
+type slogLogger struct {
+	logger     *slog.Logger
+	route      *kafkaRoute
+	ceSource   string
+	lazy       []func() LogFields
+	stackLevel *slog.Level // nil if stack traces are disabled
+	outputs    *logOutputs
+}
+
+// slogTeeHandler passes records to all output handlers
+type slogTeeHandler struct {
+	handlers []slog.Handler
+}
+
+// Enabled meets the interface for the slog handler
+func (t *slogTeeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle meets the interface for the slog handler
+func (t *slogTeeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	handled := false
+	record.Time = now()
+	for _, h := range t.handlers {
+		if !h.Enabled(ctx, record.Level) {
+			continue
+		}
+		if !handled {
+			countMessage(LevelType(getSlogLevelName(record.Level)))
+			handled = true
+		}
+		if herr := h.Handle(ctx, record.Clone()); herr != nil && err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+// WithAttrs meets the interface for the slog handler
+func (t *slogTeeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(t.handlers))
+	for i, h := range t.handlers {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return &slogTeeHandler{handlers}
+}
+
+// WithGroup meets the interface for the slog handler
+func (t *slogTeeHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(t.handlers))
+	for i, h := range t.handlers {
+		handlers[i] = h.WithGroup(name)
+	}
+	return &slogTeeHandler{handlers}
+}
+
+// slogKafkaWriter sends each record written by the JSON handler to kafka
+type slogKafkaWriter struct {
+	mutex sync.Mutex
+	kp    *KafkaProducer
+	route *kafkaRoute
+}
+
+// Write sends the record using the route of the record being handled
+func (w *slogKafkaWriter) Write(msg []byte) (int, error) {
+	return len(msg), w.kp.sendMessage(msg, w.route)
+}
+
+// slogKafkaHandler passes the logger route to the kafka writer
+// Records are sampled if sampler is not nil
+type slogKafkaHandler struct {
+	slog.Handler
+	writer  *slogKafkaWriter
+	sampler *sampler
+}
+
+// Handle meets the interface for the slog handler
+func (h *slogKafkaHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.sampler != nil && !h.sampler.allow(
+		LevelType(getSlogLevelName(record.Level)), record.Message) {
+		return nil
+	}
+	h.writer.mutex.Lock()
+	defer h.writer.mutex.Unlock()
+	h.writer.route = contextRoute(ctx)
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs meets the interface for the slog handler
+func (h *slogKafkaHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogKafkaHandler{h.Handler.WithAttrs(attrs), h.writer, h.sampler}
+}
+
+// WithGroup meets the interface for the slog handler
+func (h *slogKafkaHandler) WithGroup(name string) slog.Handler {
+	return &slogKafkaHandler{h.Handler.WithGroup(name), h.writer, h.sampler}
+}
+
+// slogCEHandler adds the cloudevents fields to each record
+// The source is replaced when set by WithCESource
+type slogCEHandler struct {
+	slog.Handler
+	fields LogFields
+}
+
+// Handle meets the interface for the slog handler
+func (h *slogCEHandler) Handle(ctx context.Context, record slog.Record) error {
+	source := contextCESource(ctx)
+	for key, val := range h.fields {
+		if key == CESourceKey && source != "" {
+			val = source
+		}
+		record.AddAttrs(slog.String(key, val.(string)))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs meets the interface for the slog handler
+func (h *slogCEHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogCEHandler{h.Handler.WithAttrs(attrs), h.fields}
+}
+
+// WithGroup meets the interface for the slog handler
+func (h *slogCEHandler) WithGroup(name string) slog.Handler {
+	return &slogCEHandler{h.Handler.WithGroup(name), h.fields}
+}
+
+// slogSinkHandler passes records and entries to a sink
+type slogSinkHandler struct {
+	slog.Handler
+	writer *sinkWriter
+}
+
+// Handle meets the interface for the slog handler
+func (h *slogSinkHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.writer.mutex.Lock()
+	defer h.writer.mutex.Unlock()
+	h.writer.entry = Entry{
+		Level:   LevelType(getSlogLevelName(record.Level)),
+		Time:    record.Time,
+		Message: record.Message,
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+// WithAttrs meets the interface for the slog handler
+func (h *slogSinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogSinkHandler{h.Handler.WithAttrs(attrs), h.writer}
+}
+
+// WithGroup meets the interface for the slog handler
+func (h *slogSinkHandler) WithGroup(name string) slog.Handler {
+	return &slogSinkHandler{h.Handler.WithGroup(name), h.writer}
+}
+
+// getSlogLevel converts log level to slog log level
+func getSlogLevel(level LevelType) slog.Level {
+	switch level {
+	case DebugType:
+		return slog.LevelDebug
+	case InfoType:
+		return slog.LevelInfo
+	case WarnType:
+		return slog.LevelWarn
+	case ErrorType:
+		return slog.LevelError
+	case FatalType:
+		return slogLevelFatal
+	case PanicType:
+		return slogLevelPanic
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// getSlogLevelName returns the level name used by the other log packages
+func getSlogLevelName(level slog.Level) string {
+	switch {
+	case level >= slogLevelPanic:
+		return string(PanicType)
+	case level >= slogLevelFatal:
+		return string(FatalType)
+	case level >= slog.LevelError:
+		return string(ErrorType)
+	case level >= slog.LevelWarn:
+		return string(WarnType)
+	case level >= slog.LevelInfo:
+		return string(InfoType)
+	default:
+		return string(DebugType)
+	}
+}
+
+// getSlogHandler returns a slog handler
+func getSlogHandler(w io.Writer, format FormatType, level LevelType,
+	config LoggerConfiguration, fields LogFields) slog.Handler {
+
+	timeKey := config.FieldMap.resolve(FieldKeyTime)
+	levelKey := config.FieldMap.resolve(FieldKeyLevel)
+	msgKey := config.FieldMap.resolve(FieldKeyMsg)
+	if format == CEFormat && config.EnableCloudEvents {
+		// Change keys for cloudevents
+		timeKey = CETimeKey
+		msgKey = CEDataKey
+		if config.CloudEventsCfg.SetSubjectLevel {
+			levelKey = CESubjectKey
+		}
+	}
+
+	options := &slog.HandlerOptions{
+		Level:     getSlogLevel(level),
+		AddSource: config.EnableCaller,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				if !config.EnableTimeStamps {
+					return slog.Attr{}
+				}
+				a.Key = timeKey
+				a.Value = slog.StringValue(a.Value.Time().Format(time.RFC3339))
+			case slog.LevelKey:
+				a.Key = levelKey
+				a.Value = slog.StringValue(
+					getSlogLevelName(a.Value.Any().(slog.Level)))
+			case slog.MessageKey:
+				a.Key = msgKey
+			case slog.SourceKey:
+				if source, ok := a.Value.Any().(*slog.Source); ok {
+					return slogCallerAttr(source)
+				}
+			}
+			return a
+		},
+	}
+
+	switch format {
+	case JSONFormat:
+		return slog.NewJSONHandler(w, options)
+	case CEFormat:
+		return &slogCEHandler{slog.NewJSONHandler(w, options), fields}
+	case TextFormat:
+		fallthrough
+	default:
+		return slog.NewTextHandler(w, options)
+	}
+}
+
+// newSlogLogger returns a log/slog logger instance
+func newSlogLogger(config LoggerConfiguration) (Logger, error) {
+	var kafkaProducer *KafkaProducer
+	var cloudEvents *CloudEvents
+	var fields LogFields
+	var err error
+	handlers := []slog.Handler{}
+	outputs := &logOutputs{}
+	// the outputs opened are closed if a later output fails
+	created := false
+	defer func() {
+		if !created {
+			outputs.close()
+		}
+	}()
+
+	if config.EnableCloudEvents {
+		cloudEvents = newCloudEvents(config.CloudEventsCfg, config.FieldMap)
+		fields = cloudEvents.fields
+	}
+
+	if config.EnableDebug {
+		handlers = append(handlers, slog.NewTextHandler(os.Stderr,
+			&slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	if config.EnableKafka {
+		kafkaProducer, err = newKafkaProducer(config.KafkaProducerCfg,
+			cloudEvents, config.CloudEventsCfg, config.FieldMap)
+		if err != nil {
+			return nil, err
+		}
+		outputs.kafka = kafkaProducer
+		writer := &slogKafkaWriter{kp: kafkaProducer}
+		var kafkaSampler *sampler
+		if config.EnableSampling {
+			kafkaSampler = newSampler(config.SamplingCfg)
+		}
+		handlers = append(handlers, &slogKafkaHandler{
+			getSlogHandler(writer, config.KafkaFormat,
+				config.outputLevel(config.KafkaLevel), config, fields),
+			writer,
+			kafkaSampler,
+		})
+	}
+
+	if config.EnableConsole {
+		cwriter := outputs.asyncOutput(config.consoleOutput(), config)
+		if config.consoleFormat() == CEFormat && cloudEvents != nil {
+			cwriter = newCEWriter(cwriter, cloudEvents)
+		}
+		handlers = append(handlers,
+			getSlogHandler(cwriter, config.consoleFormat(),
+				config.outputLevel(config.ConsoleLevel), config, fields))
+	}
+
+	if config.EnableFile {
+		var fwriter io.Writer
+		if config.EnableRotation {
+			fwriter = rotationLogger(config.fileLocation(), config.RotationCfg)
+		} else {
+			fwriter, err = config.openLogFile()
+			if err != nil {
+				return nil, err
+			}
+		}
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if config.FileFormat == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
+		handlers = append(handlers,
+			getSlogHandler(fwriter, config.FileFormat,
+				config.outputLevel(config.FileLevel), config, fields))
+	}
+
+	for _, fileCfg := range config.Files {
+		fwriter, err := config.openFileSink(fileCfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if fileCfg.Format == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
+		handlers = append(handlers,
+			getSlogHandler(fwriter, fileCfg.Format,
+				config.outputLevel(fileCfg.Level), config, fields))
+	}
+
+	for _, sinkCfg := range config.sinks() {
+		sink, err := newSink(sinkCfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs.addFlusher(sink)
+		outputs.addCloser(sink)
+		var sinkCE *CloudEvents
+		if sinkCfg.Format == CEFormat {
+			sinkCE = cloudEvents
+		}
+		writer := newSinkWriter(sink, sinkCE)
+		handlers = append(handlers, &slogSinkHandler{
+			getSlogHandler(writer, sinkCfg.Format,
+				config.outputLevel(sinkCfg.Level), config, fields),
+			writer,
+		})
+	}
+
+	var handler slog.Handler = &slogTeeHandler{handlers}
+	if limits := config.fieldLimits(); limits.enabled() {
+		handler = &slogGuardHandler{Handler: handler, limits: limits}
+	}
+	if config.EnableCaller {
+		handler = &slogCallerHandler{Handler: handler, skip: config.CallerSkip}
+	}
+
+	var stackLevel *slog.Level
+	if config.EnableStackTrace {
+		level := getSlogLevel(config.stackTraceLevel())
+		stackLevel = &level
+	}
+
+	created = true
+	return &slogLogger{
+		logger:     slog.New(handler),
+		stackLevel: stackLevel,
+		outputs:    outputs,
+	}, nil
+}
+
+// log passes the message with the logger route to the handlers
+func (l *slogLogger) log(level slog.Level, msg string) {
+	ctx := context.Background()
+	if l.route != nil {
+		ctx = withRoute(ctx, l.route)
+	}
+	if l.ceSource != "" {
+		ctx = withCESource(ctx, l.ceSource)
+	}
+	stack := l.stackLevel != nil && level >= *l.stackLevel
+	if (len(l.lazy) == 0 && !stack) || !l.logger.Enabled(ctx, level) {
+		l.logger.Log(ctx, level, msg)
+		return
+	}
+	attrs := []interface{}{}
+	for _, fieldsFn := range l.lazy {
+		for key, val := range fieldsFn() {
+			attrs = append(attrs, slog.Any(key, val))
+		}
+	}
+	if stack {
+		attrs = append(attrs, slog.String(StackTraceKey,
+			captureStack(packagePath+".(*slogLogger)", reloadLoggerPrefix)))
+	}
+	l.logger.Log(ctx, level, msg, attrs...)
+}
+
+// derive returns a logger sharing the outputs with a new slog logger
+func (l *slogLogger) derive(logger *slog.Logger) *slogLogger {
+	return &slogLogger{
+		logger:     logger,
+		route:      l.route,
+		ceSource:   l.ceSource,
+		lazy:       l.lazy,
+		stackLevel: l.stackLevel,
+		outputs:    l.outputs,
+	}
+}
+
+// sprintln returns the message without the trailing newline
+func sprintln(args ...interface{}) string {
+	return strings.TrimRight(fmt.Sprintln(args...), "\n")
+}
+
+// The following methods meet the contract for the logger interface
+
+func (l *slogLogger) Print(args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (l *slogLogger) Printf(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Println(args ...interface{}) {
+	l.log(slog.LevelInfo, sprintln(args...))
+}
+
+func (l *slogLogger) Debug(args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprint(args...))
+}
+
+func (l *slogLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Debugln(args ...interface{}) {
+	l.log(slog.LevelDebug, sprintln(args...))
+}
+
+func (l *slogLogger) Info(args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (l *slogLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Infoln(args ...interface{}) {
+	l.log(slog.LevelInfo, sprintln(args...))
+}
+
+func (l *slogLogger) Warn(args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprint(args...))
+}
+
+func (l *slogLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Warnln(args ...interface{}) {
+	l.log(slog.LevelWarn, sprintln(args...))
+}
+
+func (l *slogLogger) Error(args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprint(args...))
+}
+
+func (l *slogLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *slogLogger) Errorln(args ...interface{}) {
+	l.log(slog.LevelError, sprintln(args...))
+}
+
+func (l *slogLogger) Fatal(args ...interface{}) {
+	l.log(slogLevelFatal, fmt.Sprint(args...))
+	l.Close()
+	os.Exit(1)
+}
+
+func (l *slogLogger) Fatalf(format string, args ...interface{}) {
+	l.log(slogLevelFatal, fmt.Sprintf(format, args...))
+	l.Close()
+	os.Exit(1)
+}
+
+func (l *slogLogger) Fatalln(args ...interface{}) {
+	l.log(slogLevelFatal, sprintln(args...))
+	l.Close()
+	os.Exit(1)
+}
+
+func (l *slogLogger) Panic(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	l.log(slogLevelPanic, msg)
+	panic(msg)
+}
+
+func (l *slogLogger) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.log(slogLevelPanic, msg)
+	panic(msg)
+}
+
+func (l *slogLogger) Panicln(args ...interface{}) {
+	msg := sprintln(args...)
+	l.log(slogLevelPanic, msg)
+	panic(msg)
+}
+
+// WithFields adds fixed fields to each log record
+func (l *slogLogger) WithFields(fields LogFields) Logger {
+	attrs := make([]interface{}, 0, len(fields))
+	for key, val := range fields {
+		attrs = append(attrs, slog.Any(key, val))
+	}
+	return l.derive(l.logger.With(attrs...))
+}
+
+// WithError adds the error to each log record with the ErrorKey key
+func (l *slogLogger) WithError(err error) Logger {
+	return l.With(Err(err))
+}
+
+// WithLazyFields adds fields computed for each record emitted
+func (l *slogLogger) WithLazyFields(fieldsFn func() LogFields) Logger {
+	derived := l.derive(l.logger)
+	derived.lazy = append(append([]func() LogFields{}, l.lazy...), fieldsFn)
+	return derived
+}
+
+// WithSampledFields adds the fields with their values kept on a rate
+// fraction of records and hashed on the others, e.g. high cardinality URLs
+func (l *slogLogger) WithSampledFields(rate float64,
+	keyValues LogFields) Logger {
+	return l.WithLazyFields(sampledFields(rate, keyValues))
+}
+
+// With adds typed fields to each log record
+func (l *slogLogger) With(fields ...Field) Logger {
+	attrs := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		if field.ftype == errorField && field.iface == nil {
+			continue
+		}
+		attrs = append(attrs, field.slogAttr())
+	}
+	return l.derive(l.logger.With(attrs...))
+}
+
+// WithTopic sends each kafka record to the topic, not added to the record
+func (l *slogLogger) WithTopic(topic string) Logger {
+	derived := l.derive(l.logger)
+	derived.route = l.route.merge(&kafkaRoute{topic: topic})
+	return derived
+}
+
+// WithCESource sets the cloudevents source of each record
+func (l *slogLogger) WithCESource(source string) Logger {
+	derived := l.derive(l.logger)
+	derived.ceSource = source
+	return derived
+}
+
+// WithKafkaFilterFn adds a filter function for each kafka record of the
+// derived logger, the logger is unchanged
+func (l *slogLogger) WithKafkaFilterFn(filterFn FilterFunc) Logger {
+	derived := l.derive(l.logger)
+	derived.route = l.route.merge(&kafkaRoute{filterFn: filterFn})
+	return derived
+}
+
+// WithKafkaKeyFn adds a key function for each kafka record of the derived
+// logger, the logger is unchanged
+func (l *slogLogger) WithKafkaKeyFn(keyFn KeyFunc) Logger {
+	derived := l.derive(l.logger)
+	derived.route = l.route.merge(&kafkaRoute{keyFn: keyFn})
+	return derived
+}
+
+// WithKafkaPartitionFn adds a partition function for each kafka record of
+// the derived logger, the logger is unchanged
+func (l *slogLogger) WithKafkaPartitionFn(partitionFn PartitionFunc) Logger {
+	derived := l.derive(l.logger)
+	derived.route = l.route.merge(&kafkaRoute{partitionFn: partitionFn})
+	return derived
+}
+
+// Flush waits for buffered records to be sent
+func (l *slogLogger) Flush(timeout time.Duration) error {
+	return l.outputs.flush(timeout)
+}
+
+// Close flushes and closes all outputs, the logger must not be used after
+func (l *slogLogger) Close() error {
+	return l.outputs.close()
+}
+
+// WithContext adds fields extracted from the context to each log record
+func (l *slogLogger) WithContext(ctx context.Context) Logger {
+	fields := contextFields(ctx)
+	if len(fields) == 0 {
+		return l
+	}
+	return l.WithFields(fields)
+}
+
+func (l *slogLogger) DebugCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Debug(args...)
+}
+
+func (l *slogLogger) InfoCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Info(args...)
+}
+
+func (l *slogLogger) WarnCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Warn(args...)
+}
+
+func (l *slogLogger) ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Error(args...)
+}
+
+func (l *slogLogger) FatalCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Fatal(args...)
+}
+
+func (l *slogLogger) PanicCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Panic(args...)
+}
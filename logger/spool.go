@@ -0,0 +1,459 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Spool segment file names, the sequence number orders the segments
+const (
+	spoolPrefix = "spool-"
+	spoolSuffix = ".jsonl"
+)
+
+// ErrSpooled is passed to the send callback of a message written to the
+// spool while kafka is unavailable, the message is sent once replayed and
+// the callback called again with the outcome
+var ErrSpooled = errors.New("Kafka unavailable, message spooled")
+
+// ErrSpoolFull is returned when a message is dropped as the spool is full
+var ErrSpoolFull = errors.New("Kafka spool full")
+
+// spoolRecord provides a spooled kafka message
+type spoolRecord struct {
+	Topic     string                `json:"topic"`
+	Partition int32                 `json:"partition,omitempty"` // manual only
+	Key       []byte                `json:"key,omitempty"`
+	Value     []byte                `json:"value"`
+	Headers   []sarama.RecordHeader `json:"headers,omitempty"`
+}
+
+// spoolSegment provides a spool file
+type spoolSegment struct {
+	path string
+	size int64
+}
+
+// spool buffers messages in segment files while kafka is unavailable
+// Messages are spooled while active so they are replayed in order
+type spool struct {
+	mutex          sync.Mutex
+	dir            string
+	maxBytes       int64
+	fileBytes      int64
+	segments       []spoolSegment // closed, oldest first
+	current        *os.File
+	currentSegment spoolSegment
+	size           int64
+	seq            uint64
+	owner          string // process id in the segment names if offline
+	active         bool
+	stopped        chan struct{}
+
+	// send callbacks of the messages spooled by the producer, by segment
+	// path and line, the callbacks of previous producers are lost
+	callbacks map[string][]SendCallback
+}
+
+// spoolReplay tracks the acknowledgements of the messages of a segment
+// replayed, messages kafka was unavailable for are kept in the segment
+type spoolReplay struct {
+	acked  sync.WaitGroup
+	mutex  sync.Mutex
+	unsent []int // lines to replay again
+}
+
+// done records the outcome of the replayed message of the line
+func (r *spoolReplay) done(line int, unsent bool) {
+	if unsent {
+		r.mutex.Lock()
+		r.unsent = append(r.unsent, line)
+		r.mutex.Unlock()
+	}
+	r.acked.Done()
+}
+
+// newSpool returns a spool instance or nil if not configured
+// Segments left by a previous producer are replayed first
+func newSpool(config ProducerConfiguration) (*spool, error) {
+	if config.SpoolDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(config.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(config.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		dir:       config.SpoolDir,
+		maxBytes:  config.SpoolMaxBytes,
+		fileBytes: config.SpoolFileBytes,
+		stopped:   make(chan struct{}),
+		callbacks: map[string][]SendCallback{},
+	}
+	// offline processes may journal to the directory at the same time
+	if config.EnableOffline {
+		s.owner = "-" + strconv.Itoa(os.Getpid())
+	}
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, spoolPrefix) &&
+			strings.HasSuffix(name, spoolSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments,
+			spoolSegment{filepath.Join(s.dir, name), info.Size()})
+		s.size += info.Size()
+		seq, _ := strconv.ParseUint(strings.SplitN(strings.TrimSuffix(
+			strings.TrimPrefix(name, spoolPrefix), spoolSuffix), "-", 2)[0],
+			10, 64)
+		if seq >= s.seq {
+			s.seq = seq + 1
+		}
+	}
+	s.active = len(s.segments) > 0
+	return s, nil
+}
+
+// spooling returns true if messages are written to the spool
+func (s *spool) spooling() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.active
+}
+
+// pending returns true if the spool holds messages
+func (s *spool) pending() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.segments) > 0 || s.currentSegment.size > 0
+}
+
+// encodeRecord returns the spool line of the message
+func encodeRecord(msg *sarama.ProducerMessage) ([]byte, error) {
+	record := spoolRecord{Topic: msg.Topic, Partition: msg.Partition,
+		Headers: msg.Headers}
+	var err error
+	if msg.Key != nil {
+		if record.Key, err = msg.Key.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Value != nil {
+		if record.Value, err = msg.Value.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// write appends the message to the current segment, rotating it when full
+// The spool is active until all the messages are replayed
+func (s *spool) write(msg *sarama.ProducerMessage) error {
+	data, err := encodeRecord(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active = true
+	if s.size+int64(len(data)) > s.maxBytes {
+		return ErrSpoolFull
+	}
+	if s.current == nil {
+		path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s%s", spoolPrefix,
+			s.seq, s.owner, spoolSuffix))
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+			0644)
+		if err != nil {
+			return err
+		}
+		s.seq++
+		s.current = file
+		s.currentSegment = spoolSegment{path: path}
+	}
+	n, err := s.current.Write(data)
+	s.currentSegment.size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	meta, _ := msg.Metadata.(messageMeta)
+	path := s.currentSegment.path
+	s.callbacks[path] = append(s.callbacks[path], meta.callback)
+	if s.currentSegment.size >= s.fileBytes {
+		return s.rotate()
+	}
+	return nil
+}
+
+// rotate closes the current segment, called with the mutex held
+func (s *spool) rotate() error {
+	err := s.current.Close()
+	s.segments = append(s.segments, s.currentSegment)
+	s.current = nil
+	s.currentSegment = spoolSegment{}
+	return err
+}
+
+// next returns the oldest segment, rotating the current segment if it is
+// the only one, the spool is no longer active if it is empty
+func (s *spool) next() (spoolSegment, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.segments) == 0 {
+		if s.currentSegment.size == 0 {
+			s.active = false
+			return spoolSegment{}, false
+		}
+		if err := s.rotate(); err != nil {
+			return spoolSegment{}, false
+		}
+	}
+	return s.segments[0], true
+}
+
+// remove deletes the oldest segment once replayed
+func (s *spool) remove(segment spoolSegment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.segments = s.segments[1:]
+	s.size -= segment.size
+	delete(s.callbacks, segment.path)
+	return os.Remove(segment.path)
+}
+
+// segmentCallbacks returns the send callbacks of the lines of the segment
+func (s *spool) segmentCallbacks(segment spoolSegment) []SendCallback {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.callbacks[segment.path]
+}
+
+// keep rewrites the oldest segment with the messages of the lines only, to
+// be replayed again
+func (s *spool) keep(segment spoolSegment, messages []*sarama.ProducerMessage,
+	lines []int) error {
+	sort.Ints(lines)
+	var data []byte
+	for _, line := range lines {
+		record, err := encodeRecord(messages[line])
+		if err != nil {
+			return err
+		}
+		data = append(data, record...)
+	}
+	temp := segment.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, segment.path); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.segments[0].size = int64(len(data))
+	s.size += int64(len(data)) - segment.size
+	if callbacks := s.callbacks[segment.path]; callbacks != nil {
+		kept := make([]SendCallback, 0, len(lines))
+		for _, line := range lines {
+			if line < len(callbacks) {
+				kept = append(kept, callbacks[line])
+			}
+		}
+		s.callbacks[segment.path] = kept
+	}
+	return nil
+}
+
+// close closes the current segment, spooled messages remain for replay by
+// the next producer
+func (s *spool) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	return s.rotate()
+}
+
+// readSegment returns the messages of the segment
+// Undecodable lines are dropped
+func readSegment(path string) ([]*sarama.ProducerMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	messages := []*sarama.ProducerMessage{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var record spoolRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			countDropped(DropSpool)
+			continue
+		}
+		msg := &sarama.ProducerMessage{
+			Topic:     record.Topic,
+			Partition: record.Partition,
+			Value:     sarama.ByteEncoder(record.Value),
+			Headers:   record.Headers,
+		}
+		if record.Key != nil {
+			msg.Key = sarama.ByteEncoder(record.Key)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
+
+// unavailable returns true if the delivery error means kafka is unreachable
+func unavailable(err error) bool {
+	switch err {
+	case sarama.ErrOutOfBrokers, sarama.ErrNotConnected,
+		sarama.ErrBrokerNotAvailable, sarama.ErrLeaderNotAvailable,
+		sarama.ErrNotLeaderForPartition, sarama.ErrRequestTimedOut,
+		sarama.ErrNetworkException:
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// spoolMessage writes the message to the spool in place of sending it
+// The message is dropped if the spool is full
+func (kp *KafkaProducer) spoolMessage(msg *sarama.ProducerMessage) error {
+	if err := kp.spool.write(msg); err != nil {
+		countDropped(DropSpool)
+		return err
+	}
+	kp.spooled(msg)
+	return nil
+}
+
+// spoolFailed writes a message that failed delivery as kafka is unavailable
+// to the spool, returns false if not spooled
+// Failed messages of the idempotent producer are not spooled as they may
+// have been delivered, replayed messages are kept in their segment
+func (kp *KafkaProducer) spoolFailed(perr *sarama.ProducerError) bool {
+	if kp.spool == nil || kp.config.EnableIdempotent ||
+		!unavailable(perr.Err) {
+		return false
+	}
+	if meta, _ := perr.Msg.Metadata.(messageMeta); meta.replay != nil {
+		meta.replay.done(meta.line, true)
+		return true
+	}
+	if err := kp.spool.write(perr.Msg); err != nil {
+		return false
+	}
+	kp.spooled(perr.Msg)
+	return true
+}
+
+// spooled counts the spooled message and passes ErrSpooled to its callback
+func (kp *KafkaProducer) spooled(msg *sarama.ProducerMessage) {
+	countMetric(MetricSpooled, "", "")
+	if meta, ok := msg.Metadata.(messageMeta); ok && meta.callback != nil {
+		meta.callback(-1, -1, ErrSpooled)
+	}
+}
+
+// replay sends the spooled messages in order once kafka is reachable,
+// checked at the spool retry frequency until the producer is closed
+func (kp *KafkaProducer) replay() {
+	defer close(kp.spool.stopped)
+	ticker := time.NewTicker(kp.config.SpoolRetryFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-kp.done:
+			return
+		case <-ticker.C:
+		}
+		if kp.spool.pending() {
+			kp.replaySegments()
+		}
+	}
+}
+
+// replaySegments sends the segments oldest first, stopping if kafka is
+// unreachable or the producer is closed
+// A segment is removed once its messages are acknowledged or failed, the
+// messages not sent as kafka is unavailable are kept for the next replay
+func (kp *KafkaProducer) replaySegments() {
+	for {
+		if err := kp.metadata.Reachable(); err != nil {
+			return
+		}
+		segment, ok := kp.spool.next()
+		if !ok {
+			return
+		}
+		messages, err := readSegment(segment.path)
+		if err != nil {
+			recordError(err)
+			return
+		}
+		callbacks := kp.spool.segmentCallbacks(segment)
+		replay := &spoolReplay{}
+		for line, msg := range messages {
+			meta := messageMeta{replay: replay, line: line}
+			if line < len(callbacks) {
+				meta.callback = callbacks[line]
+			}
+			msg.Metadata = meta
+			replay.acked.Add(1)
+			if err := kp.produce(msg); err != nil {
+				replay.acked.Done()
+				for ; line < len(messages); line++ {
+					replay.unsent = append(replay.unsent, line)
+				}
+				break
+			}
+			countMetric(MetricReplayed, "", "")
+		}
+		replay.acked.Wait()
+
+		if len(replay.unsent) > 0 {
+			if err := kp.spool.keep(segment, messages, replay.unsent); err != nil {
+				recordError(err)
+			}
+			return
+		}
+		if err := kp.spool.remove(segment); err != nil {
+			recordError(err)
+		}
+	}
+}
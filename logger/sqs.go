@@ -0,0 +1,188 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQSSinkType is the output name of the sqs sink
+const SQSSinkType = "sqs"
+
+// ErrSQSSinkClosed is returned when writing to a closed sqs sink
+var ErrSQSSinkClosed = errors.New("SQS sink closed")
+
+// maxSQSBatchSize is the most messages of a SendMessageBatch request
+const maxSQSBatchSize = 10
+
+// SQSConfiguration provides Amazon SQS configuration
+// Records are sent with SendMessageBatch in batches of BatchSize or every
+// FlushInterval, the queue URL is looked up from QueueName if not set
+// For FIFO queues the message group is set as the kafka Key and KeyName
+type SQSConfiguration struct {
+	QueueURL      string
+	QueueName     string
+	Region        string // AWS_REGION or AWS_DEFAULT_REGION if empty
+	Endpoint      string // regional endpoint if empty
+	Credentials   AWSCredentialsConfiguration
+	Key           kafkaKeyType
+	KeyName       string
+	BatchSize     int // at most 10
+	FlushInterval time.Duration
+	RetryMax      int
+	RetryFreq     time.Duration // doubled on each retry
+	RetryPolicy   RetryPolicy   // overrides RetryMax and RetryFreq
+	Timeout       time.Duration
+}
+
+// sqsSink provides a sink sending batches of records to a queue
+type sqsSink struct {
+	*batchSink
+	config   SQSConfiguration
+	client   *awsClient
+	queueURL string // set by the sending goroutine only
+}
+
+// newSQSSink returns an sqs sink instance sending in the background
+func newSQSSink(config SQSConfiguration) (*sqsSink, error) {
+	if config.QueueURL == "" && config.QueueName == "" {
+		return nil, errors.New("SQS QueueURL or QueueName not set")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultSQSConfiguration.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultSQSConfiguration.FlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSQSConfiguration.Timeout
+	}
+	client, err := newAWSClient("sqs", config.Region, config.Endpoint,
+		"application/x-amz-json-1.0", config.Credentials, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqsSink{
+		config:   config,
+		client:   client,
+		queueURL: config.QueueURL,
+	}
+	retry := config.RetryPolicy.resolve(config.RetryMax, config.RetryFreq,
+		awsRetryable)
+	s.batchSink = &batchSink{
+		name:          SQSSinkType,
+		batchSize:     config.BatchSize,
+		flushInterval: config.FlushInterval,
+		retry:         retry,
+		post:          s.post,
+		closedErr:     ErrSQSSinkClosed,
+	}
+	s.start()
+	return s, nil
+}
+
+// sqsSinkFactory returns the sqs sink of SQSCfg if enabled, else of the
+// default configuration with the Sinks Options "queue" URL
+func sqsSinkFactory(config SinkConfiguration) (Sink, error) {
+	sqsCfg := defaultSQSConfiguration
+	if config.sqs != nil {
+		sqsCfg = *config.sqs
+	} else if queue := config.Options["queue"]; queue != "" {
+		sqsCfg.QueueURL = queue
+	}
+	sink, err := newSQSSink(sqsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// sqsEntry provides a SendMessageBatch request entry
+type sqsEntry struct {
+	ID                     string `json:"Id"`
+	MessageBody            string
+	MessageGroupId         string `json:",omitempty"`
+	MessageDeduplicationId string `json:",omitempty"`
+}
+
+// post sends the batch in a single request, the messages failed that may
+// be sent again are returned in a partial error
+func (s *sqsSink) post(batch []sinkRecord) error {
+	if s.queueURL == "" {
+		var output struct {
+			QueueURL string `json:"QueueUrl"`
+		}
+		if err := s.client.call("AmazonSQS.GetQueueUrl", struct {
+			QueueName string
+		}{s.config.QueueName}, &output); err != nil {
+			return err
+		}
+		s.queueURL = output.QueueURL
+	}
+
+	fifo := strings.HasSuffix(s.queueURL, ".fifo")
+	input := struct {
+		QueueURL string `json:"QueueUrl"`
+		Entries  []sqsEntry
+	}{QueueURL: s.queueURL}
+	for i, record := range batch {
+		entry := sqsEntry{
+			ID:          strconv.Itoa(i),
+			MessageBody: string(record.line),
+		}
+		if fifo {
+			// the same record sent again is deduplicated
+			hash := sha256.Sum256(append([]byte(
+				strconv.FormatInt(record.entry.Time.UnixNano(), 10)),
+				record.line...))
+			entry.MessageGroupId = awsRecordKey(s.config.Key,
+				s.config.KeyName, record)
+			entry.MessageDeduplicationId = hex.EncodeToString(hash[:])
+		}
+		input.Entries = append(input.Entries, entry)
+	}
+
+	var output struct {
+		Failed []struct {
+			ID          string `json:"Id"`
+			SenderFault bool
+			Code        string
+			Message     string
+		}
+	}
+	if err := s.client.call("AmazonSQS.SendMessageBatch", input,
+		&output); err != nil {
+		return err
+	}
+	if len(output.Failed) == 0 {
+		return nil
+	}
+	partial := &partialError{}
+	for _, result := range output.Failed {
+		i, err := strconv.Atoi(result.ID)
+		if err != nil || i < 0 || i >= len(batch) {
+			continue
+		}
+		if partial.err == nil {
+			partial.err = fmt.Errorf("SQS send %d messages failed: %s: %s",
+				len(output.Failed), result.Code, result.Message)
+		}
+		if result.SenderFault {
+			// rejected messages are not sent again
+			recordError(fmt.Errorf("SQS message rejected: %s: %s",
+				result.Code, result.Message))
+			countDropped(DropDelivery)
+			continue
+		}
+		partial.failed = append(partial.failed, batch[i])
+	}
+	if len(partial.failed) == 0 {
+		return nil
+	}
+	return partial
+}
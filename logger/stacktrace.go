@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StackTraceKey is the field key of the stack trace added to records
+const StackTraceKey = "stacktrace"
+
+// maxStackDepth is the number of frames captured in a stack trace
+const maxStackDepth = 64
+
+// packagePath is the import path of this package, used to skip its frames
+var packagePath = reflect.TypeOf(Field{}).PkgPath()
+
+// stackTraceLevel returns the level from which stack traces are captured
+func (lc LoggerConfiguration) stackTraceLevel() LevelType {
+	if lc.StackTraceLevel != "" {
+		return lc.StackTraceLevel
+	}
+	return ErrorType
+}
+
+// captureStack returns the stack of the caller in the zap stacktrace format
+// Leading frames of functions with any of the prefixes are omitted
+func captureStack(prefixes ...string) string {
+	pcs := make([]uintptr, maxStackDepth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	var builder strings.Builder
+	leading := true
+	for {
+		frame, more := frames.Next()
+		if leading && hasAnyPrefix(frame.Function, prefixes) {
+			if !more {
+				break
+			}
+			continue
+		}
+		leading = false
+		if builder.Len() > 0 {
+			builder.WriteByte('\n')
+		}
+		builder.WriteString(frame.Function)
+		builder.WriteString("\n\t")
+		builder.WriteString(frame.File)
+		builder.WriteByte(':')
+		builder.WriteString(strconv.Itoa(frame.Line))
+		if !more {
+			break
+		}
+	}
+	return builder.String()
+}
+
+// hasAnyPrefix returns true if the string has any of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogrusStackHook provides a hook adding the stack trace to entries
+type LogrusStackHook struct {
+	levels []logrus.Level
+}
+
+// newLogrusStackHook returns a stack hook for the level and those above
+func newLogrusStackHook(level LevelType) *LogrusStackHook {
+	return &LogrusStackHook{getLogrusLevels(getLogrusLevel(level))}
+}
+
+// Levels returns the levels stack traces are captured for
+func (h *LogrusStackHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire adds the stack of the logging call to the entry
+func (h *LogrusStackHook) Fire(entry *logrus.Entry) error {
+	entry.Data[StackTraceKey] = captureStack("github.com/sirupsen/logrus.",
+		packagePath+".(*LogrusStackHook)", packagePath+".(*logrusLog",
+		reloadLoggerPrefix)
+	return nil
+}
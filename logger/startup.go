@@ -0,0 +1,238 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	rtdebug "runtime/debug"
+	"sync/atomic"
+)
+
+// StartupMessage is the message of the startup event
+const StartupMessage = "Logger started"
+
+// StartupEventName is the event name of the startup event, mapped to a
+// cloudevents type by EventTypes
+const StartupEventName = "startup"
+
+// Keys of the build and configuration fields of the startup event
+const (
+	GitRevisionKey = "gitrevision"
+	GitModifiedKey = "gitmodified"
+	GoVersionKey   = "goversion"
+	ModuleKey      = "module"
+	ConfigHashKey  = "confighash"
+	SinksKey       = "sinks"
+)
+
+// ConfigHashFieldKey is the key of the configuration hash added to every
+// record if EnableConfigHash is set, it changes with the configuration
+const ConfigHashFieldKey = "cfg_hash"
+
+// configHashLength is the length of the configuration hash of records
+const configHashLength = 16
+
+// startupLogged is set once the startup event is logged, it is logged once
+// per process and not again by the loggers created on reload
+var startupLogged int32
+
+// startupLevels are the levels the startup event may be logged at, fatal
+// and panic would exit
+var startupLevels = []LevelType{InfoType, WarnType, ErrorType}
+
+// logStartup logs the startup event unless logged by an earlier logger
+func (lc LoggerConfiguration) logStartup(log Logger) {
+	if !atomic.CompareAndSwapInt32(&startupLogged, 0, 1) {
+		return
+	}
+	log = log.WithFields(lc.startupFields())
+	switch lc.startupLevel() {
+	case WarnType:
+		log.Warn(StartupMessage)
+	case ErrorType:
+		log.Error(StartupMessage)
+	default:
+		log.Info(StartupMessage)
+	}
+}
+
+// startupLevel returns the least severe startup level written by every
+// enabled output, error if an output writes fatal records only
+func (lc LoggerConfiguration) startupLevel() LevelType {
+	levels := []LevelType{}
+	if lc.EnableConsole {
+		levels = append(levels, lc.ConsoleLevel)
+	}
+	if lc.EnableFile {
+		levels = append(levels, lc.FileLevel)
+	}
+	if lc.EnableKafka {
+		levels = append(levels, lc.KafkaLevel)
+	}
+	if lc.EnableHTTP {
+		levels = append(levels, lc.HTTPLevel)
+	}
+	if lc.EnableSyslog {
+		levels = append(levels, lc.SyslogLevel)
+	}
+	if lc.EnableKinesis {
+		levels = append(levels, lc.KinesisLevel)
+	}
+	if lc.EnableSQS {
+		levels = append(levels, lc.SQSLevel)
+	}
+	for _, sc := range lc.Sinks {
+		levels = append(levels, sc.Level)
+	}
+	for _, fc := range lc.Files {
+		levels = append(levels, fc.Level)
+	}
+
+	startup := 0
+	for _, level := range levels {
+		level = lc.outputLevel(level)
+		written := len(startupLevels) - 1
+		for i, startupLevel := range startupLevels {
+			if startupLevel == level {
+				written = i
+			}
+		}
+		if level == DebugType {
+			written = 0
+		}
+		if written > startup {
+			startup = written
+		}
+	}
+	return startupLevels[startup]
+}
+
+// startupFields returns the build info and configuration fields of the
+// startup event, the service metadata is added by the logger
+func (lc LoggerConfiguration) startupFields() LogFields {
+	fields := LogFields{
+		EventNameKey: StartupEventName,
+		GoVersionKey: runtime.Version(),
+		SinksKey:     lc.outputs(),
+	}
+	if info, ok := rtdebug.ReadBuildInfo(); ok {
+		if info.Main.Path != "" {
+			fields[ModuleKey] = info.Main.Path
+		}
+		if lc.ServiceVersion == "" && info.Main.Version != "" &&
+			info.Main.Version != "(devel)" {
+			fields[ServiceVersionKey] = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				fields[GitRevisionKey] = setting.Value
+			case "vcs.modified":
+				fields[GitModifiedKey] = setting.Value == "true"
+			}
+		}
+	}
+	if hash, err := lc.hash(); err != nil {
+		recordError(err)
+	} else {
+		fields[ConfigHashKey] = hash
+	}
+	return fields
+}
+
+// withConfigHash returns the config with the hash added to records set if
+// EnableConfigHash is set, computed before fields are added to the config
+func (lc LoggerConfiguration) withConfigHash() LoggerConfiguration {
+	lc.configHash = ""
+	if !lc.EnableConfigHash {
+		return lc
+	}
+	hash, err := lc.hash()
+	if err != nil {
+		recordError(err)
+		return lc
+	}
+	lc.configHash = hash[:configHashLength]
+	return lc
+}
+
+// hashedConfiguration is the subset of the configuration hashed, the
+// settings shared by the instances of a service, excluding host names,
+// locations, endpoints and secrets that differ between hosts
+type hashedConfiguration struct {
+	LogPackage        PackageType
+	LogLevel          LevelType
+	ServiceName       string
+	ServiceVersion    string
+	Environment       string
+	FieldMap          FieldMap
+	EnableCloudEvents bool
+	Outputs           []string
+	Formats           map[string]FormatType
+	Levels            map[string]LevelType
+	KafkaTopic        string
+	EnableSampling    bool
+	SamplingCfg       SamplingConfiguration
+	EnableAsync       bool
+	AsyncPolicy       AsyncPolicyType
+	MaxFields         int
+	MaxFieldLength    int
+	EnableStackTrace  bool
+	StackTraceLevel   LevelType
+	EnableCaller      bool
+}
+
+// hash returns the sha256 of the hashed subset of the configuration,
+// identifying instances running with the same configuration on any host
+func (lc LoggerConfiguration) hash() (string, error) {
+	hashed := hashedConfiguration{
+		LogPackage:        lc.LogPackage,
+		LogLevel:          lc.outputLevel(""),
+		ServiceName:       lc.ServiceName,
+		ServiceVersion:    lc.ServiceVersion,
+		Environment:       lc.Environment,
+		FieldMap:          lc.FieldMap,
+		EnableCloudEvents: lc.EnableCloudEvents,
+		Outputs:           lc.outputs(),
+		Formats: map[string]FormatType{
+			"console":       lc.ConsoleFormat,
+			"file":          lc.FileFormat,
+			"kafka":         lc.KafkaFormat,
+			HTTPSinkType:    lc.HTTPFormat,
+			SyslogSinkType:  lc.SyslogFormat,
+			KinesisSinkType: lc.KinesisFormat,
+			SQSSinkType:     lc.SQSFormat,
+		},
+		Levels: map[string]LevelType{
+			"console":       lc.outputLevel(lc.ConsoleLevel),
+			"file":          lc.outputLevel(lc.FileLevel),
+			"kafka":         lc.outputLevel(lc.KafkaLevel),
+			HTTPSinkType:    lc.outputLevel(lc.HTTPLevel),
+			SyslogSinkType:  lc.outputLevel(lc.SyslogLevel),
+			KinesisSinkType: lc.outputLevel(lc.KinesisLevel),
+			SQSSinkType:     lc.outputLevel(lc.SQSLevel),
+		},
+		KafkaTopic:       lc.KafkaProducerCfg.Topic,
+		EnableSampling:   lc.EnableSampling,
+		SamplingCfg:      lc.SamplingCfg,
+		EnableAsync:      lc.EnableAsync,
+		AsyncPolicy:      lc.AsyncPolicy,
+		MaxFields:        lc.MaxFields,
+		MaxFieldLength:   lc.MaxFieldLength,
+		EnableStackTrace: lc.EnableStackTrace,
+		StackTraceLevel:  lc.StackTraceLevel,
+		EnableCaller:     lc.EnableCaller,
+	}
+	for _, fc := range lc.Files {
+		hashed.Formats[fc.outputName()] = fc.Format
+		hashed.Levels[fc.outputName()] = lc.outputLevel(fc.Level)
+	}
+	data, err := json.Marshal(hashed)
+	if err != nil {
+		return "", fmt.Errorf("Config hash failed: %s", err.Error())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
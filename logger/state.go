@@ -0,0 +1,214 @@
+package logger
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxInternalErrors is the number of internal errors kept for DumpState
+const maxInternalErrors = 10
+
+// InternalError provides an error raised while writing log records
+type InternalError struct {
+	Time  time.Time
+	Error string
+}
+
+// ProducerState provides the state and runtime statistics of a kafka producer
+type ProducerState struct {
+	Topic      string
+	Pending    int64
+	Retries    uint64            // delivery retries scheduled
+	Errors     map[string]uint64 // delivery failures by error
+	LastBroker string            // leader of the last partition delivered to
+}
+
+// ProducerStatsKey is the key of the producer states in the SIGUSR1 export
+const ProducerStatsKey = "producerstats"
+
+// producerStats provides the runtime statistics of a kafka producer
+type producerStats struct {
+	mutex         sync.Mutex
+	retries       uint64
+	errors        map[string]uint64
+	lastTopic     string
+	lastPartition int32
+}
+
+// retried counts a delivery retry
+func (s *producerStats) retried() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retries++
+}
+
+// failed counts a delivery failure by error message
+func (s *producerStats) failed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.errors == nil {
+		s.errors = make(map[string]uint64)
+	}
+	s.errors[err.Error()]++
+}
+
+// delivered keeps the partition of the last message delivered
+func (s *producerStats) delivered(topic string, partition int32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastTopic = topic
+	s.lastPartition = partition
+}
+
+// state returns the producer state, the last broker is looked up from the
+// metadata so may be empty if the partition leader is unknown
+func (kp *KafkaProducer) state() ProducerState {
+	kp.stats.mutex.Lock()
+	state := ProducerState{
+		Topic:   kp.config.Topic,
+		Pending: atomic.LoadInt64(&kp.pending),
+		Retries: kp.stats.retries,
+		Errors:  make(map[string]uint64, len(kp.stats.errors)),
+	}
+	for err, count := range kp.stats.errors {
+		state.Errors[err] = count
+	}
+	topic, partition := kp.stats.lastTopic, kp.stats.lastPartition
+	kp.stats.mutex.Unlock()
+
+	if topic != "" && kp.metadata != nil {
+		if leader, err := kp.metadata.Leader(topic, partition); err == nil {
+			state.LastBroker = leader.Addr
+		}
+	}
+	return state
+}
+
+// LoggerState provides the snapshot written by DumpState
+type LoggerState struct {
+	Level         LevelType
+	OutputLevels  map[string]LevelType
+	Outputs       []string
+	Producers     []ProducerState
+	Timeouts      map[string]uint64
+	Errors        []InternalError
+	Configuration LoggerConfiguration
+}
+
+var (
+	stateMutex     sync.Mutex
+	internalErrors []InternalError
+	producers      = make(map[*KafkaProducer]bool)
+)
+
+// recordError keeps the error in the last internal errors
+func recordError(err error) {
+	if err == nil {
+		return
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	internalErrors = append(internalErrors,
+		InternalError{Time: now().UTC(), Error: err.Error()})
+	if len(internalErrors) > maxInternalErrors {
+		internalErrors = internalErrors[1:]
+	}
+}
+
+// addProducer adds a kafka producer to the state
+func addProducer(kp *KafkaProducer) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	producers[kp] = true
+}
+
+// removeProducer removes a closed kafka producer from the state
+func removeProducer(kp *KafkaProducer) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	delete(producers, kp)
+}
+
+// outputs returns the names of the enabled outputs
+func (lc LoggerConfiguration) outputs() []string {
+	outputs := []string{}
+	if lc.EnableConsole {
+		outputs = append(outputs, "console")
+	}
+	if lc.EnableFile {
+		outputs = append(outputs, "file")
+	}
+	if lc.EnableKafka {
+		outputs = append(outputs, "kafka")
+	}
+	if lc.EnableHTTP {
+		outputs = append(outputs, HTTPSinkType)
+	}
+	if lc.EnableSyslog {
+		outputs = append(outputs, SyslogSinkType)
+	}
+	if lc.EnableKinesis {
+		outputs = append(outputs, KinesisSinkType)
+	}
+	if lc.EnableSQS {
+		outputs = append(outputs, SQSSinkType)
+	}
+	for _, sc := range lc.Sinks {
+		outputs = append(outputs, sc.Type)
+	}
+	for _, fc := range lc.Files {
+		outputs = append(outputs, fc.outputName())
+	}
+	return outputs
+}
+
+// CurrentState returns a snapshot of the logger state
+// Level, outputs and configuration are those of the latest logger created,
+// the secrets of the configuration are redacted
+func CurrentState() LoggerState {
+	config := CurrentConfiguration()
+	state := LoggerState{
+		Level: config.outputLevel(""),
+		OutputLevels: map[string]LevelType{
+			"console": config.outputLevel(config.ConsoleLevel),
+			"file":    config.outputLevel(config.FileLevel),
+			"kafka":   config.outputLevel(config.KafkaLevel),
+		},
+		Outputs:       config.outputs(),
+		Producers:     []ProducerState{},
+		Timeouts:      SinkTimeouts(),
+		Configuration: config.redacted(),
+	}
+	for _, fc := range config.Files {
+		state.OutputLevels[fc.outputName()] = config.outputLevel(fc.Level)
+	}
+
+	// metadata lookups are made without holding the state lock
+	stateMutex.Lock()
+	current := make([]*KafkaProducer, 0, len(producers))
+	for kp := range producers {
+		current = append(current, kp)
+	}
+	state.Errors = append([]InternalError{}, internalErrors...)
+	stateMutex.Unlock()
+
+	for _, kp := range current {
+		state.Producers = append(state.Producers, kp.state())
+	}
+	return state
+}
+
+// DumpState writes the logger state in YAML format
+// Richer than the configuration exported on SIGUSR1, for admin handlers
+func DumpState(w io.Writer) error {
+	bytes, err := yaml.Marshal(CurrentState())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bytes)
+	return err
+}
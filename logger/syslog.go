@@ -0,0 +1,308 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslogNetworkType provides syslog transport type
+type syslogNetworkType string
+
+// Types of syslog transports
+const (
+	SyslogUDP syslogNetworkType = "udp" // default, one message per datagram
+	SyslogTCP syslogNetworkType = "tcp"
+	SyslogTLS syslogNetworkType = "tls" // tcp with TLS, RFC5425
+)
+
+// syslogFormatType provides syslog message header type
+type syslogFormatType string
+
+// Types of syslog message headers
+const (
+	SyslogRFC5424 syslogFormatType = "rfc5424" // default
+	SyslogRFC3164 syslogFormatType = "rfc3164" // BSD syslog
+)
+
+// SyslogSinkType is the output name of the syslog sink
+const SyslogSinkType = "syslog"
+
+// ErrSyslogSinkClosed is returned when writing to a closed syslog sink
+var ErrSyslogSinkClosed = errors.New("Syslog sink closed")
+
+// syslogFacilities maps facility names to facility codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities maps log levels to syslog severities
+var syslogSeverities = map[LevelType]int{
+	PanicType: 1, // alert
+	FatalType: 2, // critical
+	ErrorType: 3,
+	WarnType:  4,
+	InfoType:  6,
+	DebugType: 7,
+}
+
+// SyslogConfiguration provides syslog forwarding configuration type
+// Records are sent as the message of a syslog header in the sink format
+// Stream transports use octet counting framing for RFC5424, RFC6587, and
+// newline framing for RFC3164
+type SyslogConfiguration struct {
+	Network            syslogNetworkType
+	Address            string // host:port
+	Facility           string // e.g. user, daemon, local0
+	Tag                string // app name, the program name if empty
+	Format             syslogFormatType
+	Hostname           string // os.Hostname if empty
+	Timeout            time.Duration
+	CACertFile         string      // tls only
+	CertFile           string      // tls only
+	KeyFile            string      // tls only
+	InsecureSkipVerify bool        // tls only
+	RetryPolicy        RetryPolicy // one immediate retry if not set
+}
+
+// syslogSink provides a sink writing records to a syslog server
+// The connection is made on the first write and again after a failure,
+// outside of the mutex so writes are not held by a slow server
+type syslogSink struct {
+	config   SyslogConfiguration
+	tlsCfg   *tls.Config
+	retry    RetryPolicy
+	facility int
+	hostname string
+	pid      string
+	mutex    sync.Mutex
+	conn     net.Conn
+	closed   bool
+}
+
+// newSyslogSink returns a syslog sink instance
+func newSyslogSink(config SyslogConfiguration) (*syslogSink, error) {
+	if config.Network == "" {
+		config.Network = defaultSyslogConfiguration.Network
+	}
+	if config.Address == "" {
+		config.Address = defaultSyslogConfiguration.Address
+	}
+	if config.Facility == "" {
+		config.Facility = defaultSyslogConfiguration.Facility
+	}
+	if config.Tag == "" {
+		config.Tag = filepath.Base(os.Args[0])
+	}
+	if config.Format == "" {
+		config.Format = defaultSyslogConfiguration.Format
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSyslogConfiguration.Timeout
+	}
+	facility, ok := syslogFacilities[config.Facility]
+	if !ok {
+		return nil, errors.New("Invalid syslog Facility: " + config.Facility)
+	}
+
+	s := &syslogSink{
+		config:   config,
+		retry:    config.RetryPolicy.resolve(1, 0, syslogRetryable),
+		facility: facility,
+		hostname: config.Hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	if config.Network == SyslogTLS {
+		tlsCfg, err := loadTLSConfig(config.CACertFile, config.CertFile,
+			config.KeyFile, config.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsCfg = tlsCfg
+	}
+	return s, nil
+}
+
+// syslogSinkFactory returns the syslog sink of SyslogCfg if enabled, else
+// of the default configuration with the Sinks Options "network" and
+// "address"
+func syslogSinkFactory(config SinkConfiguration) (Sink, error) {
+	syslogCfg := defaultSyslogConfiguration
+	if config.syslog != nil {
+		syslogCfg = *config.syslog
+	} else {
+		if network := config.Options["network"]; network != "" {
+			syslogCfg.Network = syslogNetworkType(network)
+		}
+		if address := config.Options["address"]; address != "" {
+			syslogCfg.Address = address
+		}
+	}
+	sink, err := newSyslogSink(syslogCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Write sends the record as a syslog message, reconnecting on failure as
+// by the retry policy, timed out records are counted as sink timeouts
+func (s *syslogSink) Write(msg []byte, entry Entry) error {
+	frame := s.frame(s.message(bytes.TrimRight(msg, "\n"), entry))
+
+	err := s.retry.do(func() error { return s.send(frame) })
+	var netErr net.Error
+	switch {
+	case err == nil:
+	case err == ErrSyslogSinkClosed:
+		countDropped(DropClosed)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		countTimeout(SyslogSinkType)
+		countDropped(DropTimeout)
+	default:
+		countDropped(DropDelivery)
+	}
+	return err
+}
+
+// syslogRetryable returns true unless the sink is closed
+func syslogRetryable(err error) bool {
+	return err != ErrSyslogSinkClosed
+}
+
+// send writes the frame within the timeout, the connection is dropped on
+// failure
+func (s *syslogSink) send(frame []byte) error {
+	conn, err := s.connection()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrSyslogSinkClosed
+	}
+	conn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+	if _, err := conn.Write(frame); err != nil {
+		conn.Close()
+		if s.conn == conn {
+			s.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// connection returns the connection to the syslog server, dialed without
+// holding the mutex if there is none
+func (s *syslogSink) connection() (net.Conn, error) {
+	s.mutex.Lock()
+	conn, closed := s.conn, s.closed
+	s.mutex.Unlock()
+	if closed {
+		return nil, ErrSyslogSinkClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case s.closed:
+		conn.Close()
+		return nil, ErrSyslogSinkClosed
+	case s.conn != nil:
+		// dialed concurrently by another write
+		conn.Close()
+		return s.conn, nil
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// dial connects to the syslog server, including the TLS handshake, within
+// the timeout
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Deadline: time.Now().Add(s.config.Timeout)}
+	switch s.config.Network {
+	case SyslogUDP, SyslogTCP:
+		return dialer.Dial(string(s.config.Network), s.config.Address)
+	case SyslogTLS:
+		return tls.DialWithDialer(dialer, "tcp", s.config.Address, s.tlsCfg)
+	default:
+		return nil, fmt.Errorf("Invalid syslog Network: %s", s.config.Network)
+	}
+}
+
+// message returns the record with the syslog header of the format
+func (s *syslogSink) message(record []byte, entry Entry) []byte {
+	severity, ok := syslogSeverities[entry.Level]
+	if !ok {
+		severity = syslogSeverities[InfoType]
+	}
+	pri := s.facility*8 + severity
+	timestamp := entry.Time
+	if timestamp.IsZero() {
+		timestamp = now()
+	}
+
+	var buf bytes.Buffer
+	switch s.config.Format {
+	case SyslogRFC3164:
+		fmt.Fprintf(&buf, "<%d>%s %s %s[%s]: ", pri,
+			timestamp.Format(time.Stamp), s.hostname, s.config.Tag, s.pid)
+	default:
+		// no message id or structured data
+		fmt.Fprintf(&buf, "<%d>1 %s %s %s %s - - ", pri,
+			timestamp.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname,
+			s.config.Tag, s.pid)
+	}
+	buf.Write(record)
+	return buf.Bytes()
+}
+
+// frame returns the message framed for the transport
+func (s *syslogSink) frame(message []byte) []byte {
+	switch {
+	case s.config.Network == SyslogUDP:
+		return message
+	case s.config.Format == SyslogRFC3164:
+		return append(message, '\n')
+	default:
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+}
+
+// Close closes the connection to the syslog server
+func (s *syslogSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minThrottleDelay is the first delay applied when throttling starts
+const minThrottleDelay = time.Millisecond
+
+// ThrottleStats provides produce throttling metrics
+type ThrottleStats struct {
+	Events uint64        // acknowledgments slower than the throttle latency
+	Delay  time.Duration // delay currently applied to each message
+}
+
+var (
+	throttleEvents uint64
+	throttleDelay  int64
+)
+
+// ProducerThrottle returns the produce throttling metrics
+func ProducerThrottle() ThrottleStats {
+	return ThrottleStats{
+		Events: atomic.LoadUint64(&throttleEvents),
+		Delay:  time.Duration(atomic.LoadInt64(&throttleDelay)),
+	}
+}
+
+// throttle adaptively delays messages when the broker slows acknowledgments
+// Brokers enforce quotas by delaying responses, the throttle time is not
+// returned by the async producer so acknowledgment latency is the feedback
+type throttle struct {
+	mutex    sync.Mutex
+	latency  time.Duration
+	maxDelay time.Duration
+	delay    time.Duration
+}
+
+// newThrottle returns a throttle instance or nil if disabled
+func newThrottle(config ProducerConfiguration) *throttle {
+	if config.ThrottleLatency <= 0 {
+		return nil
+	}
+	maxDelay := config.ThrottleMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultProducerConfiguration.ThrottleMaxDelay
+	}
+	return &throttle{
+		// batching delays acknowledgments by up to the flush frequency
+		latency:  config.ProdFlushFreq + config.ThrottleLatency,
+		maxDelay: maxDelay,
+	}
+}
+
+// acknowledged adjusts the delay, doubling it while acknowledgments are slow
+// and halving it once they recover
+func (t *throttle) acknowledged(latency time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if latency > t.latency {
+		atomic.AddUint64(&throttleEvents, 1)
+		t.delay *= 2
+		if t.delay < minThrottleDelay {
+			t.delay = minThrottleDelay
+		}
+		if t.delay > t.maxDelay {
+			t.delay = t.maxDelay
+		}
+	} else {
+		t.delay /= 2
+		if t.delay < minThrottleDelay {
+			t.delay = 0
+		}
+	}
+	atomic.StoreInt64(&throttleDelay, int64(t.delay))
+}
+
+// wait sleeps for the current delay
+func (t *throttle) wait() {
+	t.mutex.Lock()
+	delay := t.delay
+	t.mutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWriteTimeout is returned when a record is dropped by a sink write timeout
+var ErrWriteTimeout = errors.New("Sink write timeout")
+
+// ErrFlushTimeout is returned when pending records are not sent in time
+var ErrFlushTimeout = errors.New("Sink flush timeout")
+
+// Sink names used for write timeout counters
+const (
+	KafkaSink = "kafka"
+)
+
+// sinkTimeouts holds a counter of timed out writes per sink name
+var sinkTimeouts sync.Map
+
+// countTimeout increments the timed out writes counter for the sink
+func countTimeout(sink string) {
+	counter, _ := sinkTimeouts.LoadOrStore(sink, new(uint64))
+	atomic.AddUint64(counter.(*uint64), 1)
+}
+
+// SinkTimeouts returns the number of timed out writes for each sink
+// Records that time out are dropped rather than stalling the caller
+func SinkTimeouts() map[string]uint64 {
+	timeouts := make(map[string]uint64)
+	sinkTimeouts.Range(func(sink, counter interface{}) bool {
+		timeouts[sink.(string)] = atomic.LoadUint64(counter.(*uint64))
+		return true
+	})
+	return timeouts
+}
@@ -0,0 +1,155 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTopic is wrapped by the error returned for topics breaking the
+// topic naming convention
+var ErrInvalidTopic = errors.New("Invalid topic name")
+
+// Placeholders of the topic naming template
+const (
+	TopicEnvPlaceholder     = "{env}"
+	TopicServicePlaceholder = "{service}"
+	TopicNamePlaceholder    = "{name}"
+)
+
+// maxTopicLength is the longest topic name kafka accepts
+const maxTopicLength = 249
+
+// defaultTopicCharset is the class of the characters kafka accepts
+const defaultTopicCharset = "a-zA-Z0-9._-"
+
+// TopicNamingConfiguration provides the topic naming convention shared by
+// the services, the topics given to the producer are expanded by Template
+// and rejected unless valid, names are unchanged if Template is empty
+// Example: Template "{env}.{service}.{name}" names "orders" prod.billing.orders
+type TopicNamingConfiguration struct {
+	Template    string // must contain {name}
+	Environment string // {env}, required topic prefix if set
+	Service     string // {service}
+	Charset     string // regexp character class, e.g. a-z0-9.-
+	MaxLength   int    // at most 249, the kafka limit if zero
+}
+
+// enabled returns true if a naming convention is configured
+func (tc TopicNamingConfiguration) enabled() bool {
+	return tc != TopicNamingConfiguration{}
+}
+
+// TopicNamer provides the expansion and validation of topic names
+type TopicNamer struct {
+	config   TopicNamingConfiguration
+	replacer *strings.Replacer
+	charset  *regexp.Regexp
+}
+
+// NewTopicNamer returns a topic namer instance for the convention
+func NewTopicNamer(config TopicNamingConfiguration) (*TopicNamer, error) {
+	if config.Template != "" &&
+		!strings.Contains(config.Template, TopicNamePlaceholder) {
+		return nil, fmt.Errorf("Topic Template missing %s: %s",
+			TopicNamePlaceholder, config.Template)
+	}
+	if config.MaxLength < 0 || config.MaxLength > maxTopicLength {
+		return nil, fmt.Errorf("Topic MaxLength not between 0 and %d",
+			maxTopicLength)
+	}
+	if config.MaxLength == 0 {
+		config.MaxLength = maxTopicLength
+	}
+	charset := config.Charset
+	if charset == "" {
+		charset = defaultTopicCharset
+	}
+	re, err := regexp.Compile("^[" + charset + "]+$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid topic Charset %s: %s", charset,
+			err.Error())
+	}
+	return &TopicNamer{
+		config: config,
+		replacer: strings.NewReplacer(
+			TopicEnvPlaceholder, config.Environment,
+			TopicServicePlaceholder, config.Service),
+		charset: re,
+	}, nil
+}
+
+// Name returns the topic of the name as by the template once validated
+func (n *TopicNamer) Name(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: empty name", ErrInvalidTopic)
+	}
+	topic := name
+	if n.config.Template != "" {
+		topic = strings.Replace(n.replacer.Replace(n.config.Template),
+			TopicNamePlaceholder, name, 1)
+	}
+	return topic, n.Validate(topic)
+}
+
+// Validate returns an error wrapping ErrInvalidTopic unless the topic has
+// the environment prefix, allowed characters and length
+func (n *TopicNamer) Validate(topic string) error {
+	if len(topic) > n.config.MaxLength {
+		return fmt.Errorf("%w: %s longer than %d", ErrInvalidTopic, topic,
+			n.config.MaxLength)
+	}
+	if !n.charset.MatchString(topic) {
+		return fmt.Errorf("%w: %s has characters outside %s", ErrInvalidTopic,
+			topic, n.charset.String())
+	}
+	if topic == "." || topic == ".." {
+		return fmt.Errorf("%w: %s", ErrInvalidTopic, topic)
+	}
+	if env := n.config.Environment; env != "" &&
+		(!strings.HasPrefix(topic, env) || len(topic) == len(env)) {
+		return fmt.Errorf("%w: %s missing prefix %s", ErrInvalidTopic, topic,
+			env)
+	}
+	return nil
+}
+
+// nameTopics returns the configuration with its topics named, including
+// the default topic, dead letter topic and route rule topics
+func (n *TopicNamer) nameTopics(config ProducerConfiguration) (
+	ProducerConfiguration, error) {
+	var err error
+	if config.Topic == "" {
+		config.Topic = defaultProducerConfiguration.Topic
+	}
+	if config.Topic, err = n.Name(config.Topic); err != nil {
+		return config, err
+	}
+	if config.DeadLetterTopic != "" {
+		if config.DeadLetterTopic, err = n.Name(
+			config.DeadLetterTopic); err != nil {
+			return config, err
+		}
+	}
+	rules := make([]RouteRule, len(config.RouteRules))
+	for i, rule := range config.RouteRules {
+		if rule.Topic, err = n.Name(rule.Topic); err != nil {
+			return config, err
+		}
+		rules[i] = rule
+	}
+	if config.RouteRules != nil {
+		config.RouteRules = rules
+	}
+	return config, nil
+}
+
+// topicName returns the topic of the name given to the producer, unchanged
+// unless a naming convention is configured
+func (kp *KafkaProducer) topicName(name string) (string, error) {
+	if kp.namer == nil {
+		return name, nil
+	}
+	return kp.namer.Name(name)
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrCreateTopic is wrapped by the error returned when topic creation fails
+var ErrCreateTopic = errors.New("Kafka topic creation failed")
+
+// topicRetentionMs is the topic config limiting the age of records
+const topicRetentionMs = "retention.ms"
+
+// topicDetail returns the details of the topics created by the producer
+func (kp *KafkaProducer) topicDetail() *sarama.TopicDetail {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     kp.config.TopicPartitions,
+		ReplicationFactor: kp.config.TopicReplication,
+	}
+	if detail.NumPartitions == 0 {
+		detail.NumPartitions = defaultProducerConfiguration.TopicPartitions
+	}
+	if detail.ReplicationFactor == 0 {
+		detail.ReplicationFactor = defaultProducerConfiguration.TopicReplication
+	}
+	if kp.config.TopicRetention > 0 {
+		retention := strconv.FormatInt(
+			int64(kp.config.TopicRetention/time.Millisecond), 10)
+		detail.ConfigEntries = map[string]*string{topicRetentionMs: &retention}
+	}
+	return detail
+}
+
+// createTopics creates the topics the producer sends to if missing
+// Existing topics are left unchanged whatever their configuration
+func (kp *KafkaProducer) createTopics() error {
+	createTopics := []string{kp.config.Topic}
+	if kp.config.DeadLetterTopic != "" {
+		createTopics = append(createTopics, kp.config.DeadLetterTopic)
+	}
+
+	detail := kp.topicDetail()
+	for _, topic := range createTopics {
+		exists, err := kp.metadata.TopicExists(topic)
+		if err != nil {
+			return fmt.Errorf("%w: could not list topics: %s",
+				ErrCreateTopic, err.Error())
+		} else if exists {
+			continue
+		}
+		if err := kp.metadata.CreateTopic(topic, detail); err != nil {
+			return fmt.Errorf("%w: topic %s: %s", ErrCreateTopic, topic,
+				err.Error())
+		}
+	}
+	return nil
+}
+
+// The following methods meet the contract for the kafka metadata
+
+func (m *saramaMetadata) CreateTopic(topic string,
+	detail *sarama.TopicDetail) error {
+	admin, err := sarama.NewClusterAdminFromClient(m.client)
+	if err != nil {
+		return err
+	}
+	// admin is not closed, closing it would close the producer client
+	err = admin.CreateTopic(topic, detail, false)
+	if topicErr, ok := err.(*sarama.TopicError); ok &&
+		topicErr.Err == sarama.ErrTopicAlreadyExists {
+		// created by another producer since listed
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	// metadata refreshed so the topic is listed, may lag on the brokers
+	m.client.RefreshMetadata(topic)
+	return nil
+}
+
+func (m *mockMetadata) CreateTopic(topic string,
+	detail *sarama.TopicDetail) error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.topics != nil {
+		broker.topics[topic] = true
+	}
+	if broker.created == nil {
+		broker.created = make(map[string]sarama.TopicDetail)
+	}
+	broker.created[topic] = *detail
+	return nil
+}
+
+// MockBrokerCreatedTopic returns the details of a topic created by a
+// producer on the mock broker
+func MockBrokerCreatedTopic(topic string) (sarama.TopicDetail, bool) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	detail, ok := broker.created[topic]
+	return detail, ok
+}
@@ -3,27 +3,33 @@
 package logger
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
 )
 
-// zapLogger represents a zap sugar logger
+// zapLogger represents a zap logger, records are checked and written on
+// the core without the SugaredLogger boxing of the typed fields
 type zapLogger struct {
-	sugaredLogger *zap.SugaredLogger
-	kafkaWriter   *ZapKafkaWriter
+	logger      *zap.Logger
+	kafkaWriter *ZapKafkaWriter
+	outputs     *logOutputs
 }
 
 // ceEncoder provides wrapper for the JSONEncoder (to insert CE fields)
+// The source is replaced when set by WithCESource
 type ceEncoder struct {
 	zapcore.Encoder
 	fields []zapcore.Field
+	source string
 }
 
 // Clone meets the interface for the zapcore encoder
@@ -31,6 +37,7 @@ func (ce *ceEncoder) Clone() zapcore.Encoder {
 	return &ceEncoder{
 		ce.Encoder.Clone(),
 		ce.fields,
+		ce.source,
 	}
 }
 
@@ -38,24 +45,51 @@ func (ce *ceEncoder) Clone() zapcore.Encoder {
 func (ce *ceEncoder) EncodeEntry(entry zapcore.Entry,
 	fields []zapcore.Field) (*buffer.Buffer, error) {
 	// CE fields are added here, not by using WithFields
-	fields = append(fields, ce.fields...)
+	for _, field := range ce.fields {
+		if field.Key == CESourceKey && ce.source != "" {
+			field.String = ce.source
+		}
+		fields = append(fields, field)
+	}
 	return ce.Encoder.EncodeEntry(entry, fields)
 }
 
+// ceSourceMarshaler sets the source of the cloudevents encoder it is added to
+// Added inline to other encoders it adds nothing to the record
+type ceSourceMarshaler string
+
+// MarshalLogObject meets the interface for the zapcore object marshaler
+func (s ceSourceMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if ce, ok := enc.(*ceEncoder); ok {
+		ce.source = string(s)
+	}
+	return nil
+}
+
 // getEncoder returns a zap encoder
 func getEncoder(format FormatType, config LoggerConfiguration,
 	fields LogFields) zapcore.Encoder {
 
 	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.MessageKey = config.FieldMap.resolve(FieldKeyMsg)
+	encoderConfig.LevelKey = config.FieldMap.resolve(FieldKeyLevel)
+	encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
 	if config.EnableTimeStamps {
 		encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
-		encoderConfig.TimeKey = CETimeKey
+		encoderConfig.TimeKey = config.FieldMap.resolve(FieldKeyTime)
 	} else {
 		encoderConfig.TimeKey = zapcore.OmitKey
 	}
 	encoderConfig.NameKey = zapcore.OmitKey
 	encoderConfig.CallerKey = zapcore.OmitKey
+	if config.EnableCaller {
+		encoderConfig.CallerKey = CallerKey
+		encoderConfig.FunctionKey = CallerFunctionKey
+	}
 	encoderConfig.StacktraceKey = zapcore.OmitKey
+	if config.EnableStackTrace {
+		encoderConfig.StacktraceKey = StackTraceKey
+	}
 
 	switch format {
 	case JSONFormat:
@@ -63,6 +97,9 @@ func getEncoder(format FormatType, config LoggerConfiguration,
 	case CEFormat:
 		// Change keys for cloudevents
 		if config.EnableCloudEvents {
+			if config.EnableTimeStamps {
+				encoderConfig.TimeKey = CETimeKey
+			}
 			encoderConfig.MessageKey = CEDataKey
 			if config.CloudEventsCfg.SetSubjectLevel {
 				encoderConfig.LevelKey = CESubjectKey
@@ -78,8 +115,8 @@ func getEncoder(format FormatType, config LoggerConfiguration,
 			ceFields = append(ceFields, ceField)
 		}
 		return &ceEncoder{
-			zapcore.NewJSONEncoder(encoderConfig),
-			ceFields,
+			Encoder: zapcore.NewJSONEncoder(encoderConfig),
+			fields:  ceFields,
 		}
 	case TextFormat:
 		fallthrough
@@ -111,6 +148,81 @@ func getZapLevel(level LevelType) zapcore.Level {
 	}
 }
 
+// getZapLevelType converts zap log level to log level
+func getZapLevelType(level zapcore.Level) LevelType {
+	switch level {
+	case zapcore.DebugLevel:
+		return DebugType
+	case zapcore.InfoLevel:
+		return InfoType
+	case zapcore.WarnLevel:
+		return WarnType
+	case zapcore.ErrorLevel:
+		return ErrorType
+	case zapcore.FatalLevel:
+		return FatalType
+	default:
+		return PanicType
+	}
+}
+
+// zapMetricsHook counts the messages logged per level
+func zapMetricsHook(entry zapcore.Entry) error {
+	countMessage(getZapLevelType(entry.Level))
+	return nil
+}
+
+// zapLazyCore adds the fields computed by fieldsFn to entries written
+type zapLazyCore struct {
+	zapcore.Core
+	fieldsFn func() LogFields
+}
+
+// With meets the interface for the zap core
+func (c *zapLazyCore) With(fields []zapcore.Field) zapcore.Core {
+	return &zapLazyCore{c.Core.With(fields), c.fieldsFn}
+}
+
+// Check meets the interface for the zap core
+func (c *zapLazyCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Core.Enabled(entry.Level) {
+		return checked
+	}
+	return checked.AddCore(entry, c)
+}
+
+// Write computes the fields then writes to the cores enabled for the entry,
+// the fields are not computed if no core writes the entry, e.g. sampled out
+func (c *zapLazyCore) Write(entry zapcore.Entry,
+	fields []zapcore.Field) error {
+	downstream := c.Core.Check(entry, nil)
+	if downstream == nil {
+		return nil
+	}
+	lazyFields := c.fieldsFn()
+	allFields := make([]zapcore.Field, 0, len(lazyFields)+len(fields))
+	for key, val := range lazyFields {
+		allFields = append(allFields, zap.Any(key, val))
+	}
+	allFields = append(allFields, fields...)
+	downstream.Write(allFields...)
+	return nil
+}
+
+// zapClock provides the logger clock to zap
+type zapClock struct{}
+
+// Now returns the logger clock time
+func (zapClock) Now() time.Time {
+	return now()
+}
+
+// NewTicker returns a system ticker, zap only uses it for sampling
+func (zapClock) NewTicker(d time.Duration) *time.Ticker {
+	return time.NewTicker(d)
+}
+
 // zapDebugHook is a hook for testing
 func zapDebugHook(entry zapcore.Entry) error {
 	fmt.Fprintf(os.Stderr, "%+v\n", entry)
@@ -123,11 +235,18 @@ func newZapLogger(config LoggerConfiguration) (Logger, error) {
 	var cloudEvents *CloudEvents
 	var fields LogFields
 	var err error
-	level := getZapLevel(config.LogLevel)
 	cores := []zapcore.Core{}
+	outputs := &logOutputs{}
+	// the outputs opened are closed if a later output fails
+	created := false
+	defer func() {
+		if !created {
+			outputs.close()
+		}
+	}()
 
 	if config.EnableCloudEvents {
-		cloudEvents = newCloudEvents(config.CloudEventsCfg)
+		cloudEvents = newCloudEvents(config.CloudEventsCfg, config.FieldMap)
 		fields = cloudEvents.fields
 	}
 
@@ -141,166 +260,359 @@ func newZapLogger(config LoggerConfiguration) (Logger, error) {
 
 	if config.EnableKafka {
 		kafkaWriter, err = newZapKafkaWriter(config.KafkaProducerCfg,
-			cloudEvents, config.CloudEventsCfg)
+			cloudEvents, config.CloudEventsCfg, config.FieldMap)
 		if err != nil {
 			return nil, err
 		}
+		outputs.kafka = kafkaWriter.kp
+		outputs.addCloser(kafkaWriter)
 		encoder := getEncoder(config.KafkaFormat, config, fields)
-		core := zapcore.NewCore(encoder, kafkaWriter, level)
+		level := getZapLevel(config.outputLevel(config.KafkaLevel))
+		var core zapcore.Core = newZapKafkaCore(encoder, kafkaWriter, level)
+		if config.EnableSampling {
+			core = newZapSamplingCore(core, config.SamplingCfg)
+		}
 		cores = append(cores, core)
 	}
 
 	if config.EnableConsole {
-		var cwriter io.Writer
-		if debugCapture != nil {
-			cwriter = debugCapture
-		} else if config.ConsoleWriter == Stderr {
-			cwriter = os.Stderr
-		} else {
-			cwriter = os.Stdout
+		cwriter := outputs.asyncOutput(config.consoleOutput(), config)
+		if config.consoleFormat() == CEFormat && cloudEvents != nil {
+			cwriter = newCEWriter(cwriter, cloudEvents)
 		}
-		writer := zapcore.Lock(zapcore.AddSync(cwriter))
-		encoder := getEncoder(config.ConsoleFormat, config, fields)
+		writer := zapcore.AddSync(cwriter)
+		encoder := getEncoder(config.consoleFormat(), config, fields)
+		level := getZapLevel(config.outputLevel(config.ConsoleLevel))
 		core := zapcore.NewCore(encoder, writer, level)
 		cores = append(cores, core)
 	}
 
 	if config.EnableFile {
 		var fwriter io.Writer
-		fileLocation := config.FileLocation
-		if fileLocation == "" {
-			fileLocation = defaultLoggerConfiguration.FileLocation
-		}
 		if config.EnableRotation {
-			fwriter = rotationLogger(fileLocation, config.RotationCfg)
+			fwriter = rotationLogger(config.fileLocation(), config.RotationCfg)
 		} else {
-			fwriter, err = os.OpenFile(fileLocation,
-				os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			fwriter, err = config.openLogFile()
 			if err != nil {
 				return nil, err
 			}
 		}
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if config.FileFormat == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
 		writer := zapcore.AddSync(fwriter)
 		encoder := getEncoder(config.FileFormat, config, fields)
+		level := getZapLevel(config.outputLevel(config.FileLevel))
 		core := zapcore.NewCore(encoder, writer, level)
 		cores = append(cores, core)
 	}
 
-	combinedCore := zapcore.NewTee(cores...)
-	logger := zap.New(combinedCore).Sugar()
+	for _, fileCfg := range config.Files {
+		fwriter, err := config.openFileSink(fileCfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs.addFlusher(fwriter)
+		outputs.addCloser(fwriter)
+		fwriter = outputs.asyncOutput(fwriter, config)
+		if fileCfg.Format == CEFormat && cloudEvents != nil {
+			fwriter = newCEWriter(fwriter, cloudEvents)
+		}
+		writer := zapcore.AddSync(fwriter)
+		encoder := getEncoder(fileCfg.Format, config, fields)
+		level := getZapLevel(config.outputLevel(fileCfg.Level))
+		cores = append(cores, zapcore.NewCore(encoder, writer, level))
+	}
+
+	for _, sinkCfg := range config.sinks() {
+		sink, err := newSink(sinkCfg)
+		if err != nil {
+			return nil, err
+		}
+		outputs.addFlusher(sink)
+		outputs.addCloser(sink)
+		var sinkCE *CloudEvents
+		if sinkCfg.Format == CEFormat {
+			sinkCE = cloudEvents
+		}
+		writer := newSinkWriter(sink, sinkCE)
+		encoder := getEncoder(sinkCfg.Format, config, fields)
+		level := getZapLevel(config.outputLevel(sinkCfg.Level))
+		cores = append(cores, newZapSinkCore(encoder, writer, level))
+	}
+
+	combinedCore := zapcore.RegisterHooks(zapcore.NewTee(cores...),
+		zapMetricsHook)
+	if limits := config.fieldLimits(); limits.enabled() {
+		combinedCore = &zapGuardCore{Core: combinedCore, limits: limits}
+	}
+	if config.EnableCaller {
+		combinedCore = &zapCallerCore{Core: combinedCore,
+			skip: config.CallerSkip}
+	}
+	options := []zap.Option{zap.WithClock(zapClock{})}
+	if config.EnableStackTrace {
+		// skip the frames of the zapLogger method and of its log helper
+		options = append(options, zap.AddCallerSkip(2),
+			zap.AddStacktrace(getZapLevel(config.stackTraceLevel())))
+	}
+	logger := zap.New(combinedCore, options...)
 	defer logger.Sync()
 
+	created = true
 	return &zapLogger{
-		sugaredLogger: logger,
-		kafkaWriter:   kafkaWriter,
+		logger:      logger,
+		kafkaWriter: kafkaWriter,
+		outputs:     outputs,
 	}, nil
 }
 
+// log writes the record if its level is enabled, the message is formatted
+// as by the SugaredLogger only then
+func (l *zapLogger) log(level zapcore.Level, template string,
+	args []interface{}) {
+	if level < zapcore.DPanicLevel && !l.logger.Core().Enabled(level) {
+		return
+	}
+	var msg string
+	switch {
+	case len(args) == 0:
+		msg = template
+	case template != "":
+		msg = fmt.Sprintf(template, args...)
+	default:
+		msg = fmt.Sprint(args...)
+	}
+	if ce := l.logger.Check(level, msg); ce != nil {
+		ce.Write()
+	}
+}
+
+// logln writes the record with the message formatted as by fmt.Sprintln,
+// without the trailing newline
+func (l *zapLogger) logln(level zapcore.Level, args []interface{}) {
+	if level < zapcore.DPanicLevel && !l.logger.Core().Enabled(level) {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintln(args...), "\n")
+	if ce := l.logger.Check(level, msg); ce != nil {
+		ce.Write()
+	}
+}
+
 // The following methods meet the contract for the logger interface
 
 func (l *zapLogger) Print(args ...interface{}) {
-	l.sugaredLogger.Info(args...)
+	l.log(zapcore.InfoLevel, "", args)
 }
 
 func (l *zapLogger) Printf(format string, args ...interface{}) {
-	l.sugaredLogger.Infof(format, args...)
+	l.log(zapcore.InfoLevel, format, args)
 }
 
 func (l *zapLogger) Println(args ...interface{}) {
-	l.sugaredLogger.Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.InfoLevel, args)
 }
 
 func (l *zapLogger) Debug(args ...interface{}) {
-	l.sugaredLogger.Debug(args...)
+	l.log(zapcore.DebugLevel, "", args)
 }
 
 func (l *zapLogger) Debugf(format string, args ...interface{}) {
-	l.sugaredLogger.Debugf(format, args...)
+	l.log(zapcore.DebugLevel, format, args)
 }
 
 func (l *zapLogger) Debugln(args ...interface{}) {
-	l.sugaredLogger.Debug(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.DebugLevel, args)
 }
 
 func (l *zapLogger) Info(args ...interface{}) {
-	l.sugaredLogger.Info(args...)
+	l.log(zapcore.InfoLevel, "", args)
 }
 
 func (l *zapLogger) Infof(format string, args ...interface{}) {
-	l.sugaredLogger.Infof(format, args...)
+	l.log(zapcore.InfoLevel, format, args)
 }
 
 func (l *zapLogger) Infoln(args ...interface{}) {
-	l.sugaredLogger.Info(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.InfoLevel, args)
 }
 
 func (l *zapLogger) Warn(args ...interface{}) {
-	l.sugaredLogger.Warn(args...)
+	l.log(zapcore.WarnLevel, "", args)
 }
 
 func (l *zapLogger) Warnf(format string, args ...interface{}) {
-	l.sugaredLogger.Warnf(format, args...)
+	l.log(zapcore.WarnLevel, format, args)
 }
 
 func (l *zapLogger) Warnln(args ...interface{}) {
-	l.sugaredLogger.Warn(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.WarnLevel, args)
 }
 
 func (l *zapLogger) Error(args ...interface{}) {
-	l.sugaredLogger.Error(args...)
+	l.log(zapcore.ErrorLevel, "", args)
 }
 
 func (l *zapLogger) Errorf(format string, args ...interface{}) {
-	l.sugaredLogger.Errorf(format, args...)
+	l.log(zapcore.ErrorLevel, format, args)
 }
 
 func (l *zapLogger) Errorln(args ...interface{}) {
-	l.sugaredLogger.Error(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.ErrorLevel, args)
 }
 
 func (l *zapLogger) Fatal(args ...interface{}) {
-	l.sugaredLogger.Fatal(args...)
+	l.log(zapcore.FatalLevel, "", args)
 }
 
 func (l *zapLogger) Fatalf(format string, args ...interface{}) {
-	l.sugaredLogger.Fatalf(format, args...)
+	l.log(zapcore.FatalLevel, format, args)
 }
 
 func (l *zapLogger) Fatalln(args ...interface{}) {
-	l.sugaredLogger.Fatal(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.FatalLevel, args)
 }
 
 func (l *zapLogger) Panic(args ...interface{}) {
-	l.sugaredLogger.Panic(args...)
+	l.log(zapcore.PanicLevel, "", args)
 }
 
 func (l *zapLogger) Panicf(format string, args ...interface{}) {
-	l.sugaredLogger.Fatalf(format, args...)
+	l.log(zapcore.PanicLevel, format, args)
 }
 
 func (l *zapLogger) Panicln(args ...interface{}) {
-	l.sugaredLogger.Panic(strings.TrimRight(fmt.Sprintln(args...), "\n"))
+	l.logln(zapcore.PanicLevel, args)
 }
 
 // WithFields adds fixed fields to each log record
 func (l *zapLogger) WithFields(fields LogFields) Logger {
-	var f = make([]interface{}, 0)
+	f := make([]zap.Field, 0, len(fields))
 	for k, v := range fields {
-		f = append(f, k)
-		f = append(f, v)
+		f = append(f, zap.Any(k, v))
+	}
+	newLogger := l.logger.With(f...)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// WithError adds the error to each log record with the ErrorKey key
+func (l *zapLogger) WithError(err error) Logger {
+	return l.With(Err(err))
+}
+
+// WithLazyFields adds fields computed for each record emitted
+func (l *zapLogger) WithLazyFields(fieldsFn func() LogFields) Logger {
+	newLogger := l.logger.WithOptions(
+		zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return &zapLazyCore{core, fieldsFn}
+		}))
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// WithSampledFields adds the fields with their values kept on a rate
+// fraction of records and hashed on the others, e.g. high cardinality URLs
+func (l *zapLogger) WithSampledFields(rate float64,
+	keyValues LogFields) Logger {
+	return l.WithLazyFields(sampledFields(rate, keyValues))
+}
+
+// With adds typed fields to each log record
+func (l *zapLogger) With(fields ...Field) Logger {
+	zapFields := make([]zap.Field, len(fields))
+	for i, field := range fields {
+		zapFields[i] = field.zapField()
+	}
+	newLogger := l.logger.With(zapFields...)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// WithTopic sends each kafka record to the topic, not added to the record
+func (l *zapLogger) WithTopic(topic string) Logger {
+	route := routeField(&kafkaRoute{topic: topic})
+	newLogger := l.logger.With(route)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// WithCESource sets the cloudevents source of each record
+func (l *zapLogger) WithCESource(source string) Logger {
+	field := zapcore.Field{
+		Key:       CESourceKey,
+		Type:      zapcore.InlineMarshalerType,
+		Interface: ceSourceMarshaler(source),
 	}
-	newLogger := l.sugaredLogger.With(f...)
-	return &zapLogger{newLogger, l.kafkaWriter}
+	newLogger := l.logger.With(field)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
 }
 
-// WithKafkaFilterFn adds a filter function for each kafka record
+// WithKafkaFilterFn adds a filter function for each kafka record of the
+// derived logger, the logger is unchanged
 func (l *zapLogger) WithKafkaFilterFn(filterFn FilterFunc) Logger {
-	l.kafkaWriter.kp.config.filterFn = filterFn
-	return l
+	route := routeField(&kafkaRoute{filterFn: filterFn})
+	newLogger := l.logger.With(route)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
 }
 
-// WithKafkaKeyFn adds a key function for each kafka record
+// WithKafkaKeyFn adds a key function for each kafka record of the derived
+// logger, the logger is unchanged
 func (l *zapLogger) WithKafkaKeyFn(keyFn KeyFunc) Logger {
-	l.kafkaWriter.kp.config.keyFn = keyFn
-	return l
+	route := routeField(&kafkaRoute{keyFn: keyFn})
+	newLogger := l.logger.With(route)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// WithKafkaPartitionFn adds a partition function for each kafka record of
+// the derived logger, the logger is unchanged
+func (l *zapLogger) WithKafkaPartitionFn(partitionFn PartitionFunc) Logger {
+	route := routeField(&kafkaRoute{partitionFn: partitionFn})
+	newLogger := l.logger.With(route)
+	return &zapLogger{newLogger, l.kafkaWriter, l.outputs}
+}
+
+// Flush waits for buffered records to be written and sent
+func (l *zapLogger) Flush(timeout time.Duration) error {
+	l.logger.Sync()
+	return l.outputs.flush(timeout)
+}
+
+// Close flushes and closes all outputs, the logger must not be used after
+func (l *zapLogger) Close() error {
+	l.logger.Sync()
+	return l.outputs.close()
+}
+
+// WithContext adds fields extracted from the context to each log record
+func (l *zapLogger) WithContext(ctx context.Context) Logger {
+	fields := contextFields(ctx)
+	if len(fields) == 0 {
+		return l
+	}
+	return l.WithFields(fields)
+}
+
+func (l *zapLogger) DebugCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Debug(args...)
+}
+
+func (l *zapLogger) InfoCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Info(args...)
+}
+
+func (l *zapLogger) WarnCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Warn(args...)
+}
+
+func (l *zapLogger) ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Error(args...)
+}
+
+func (l *zapLogger) FatalCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Fatal(args...)
+}
+
+func (l *zapLogger) PanicCtx(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Panic(args...)
 }
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+
+	"go.uber.org/zap/zapcore"
 )
 
 // ZapKafkaWriter is a zap WriteSyncer (io.Writer) that writes messages to Kafka
@@ -21,10 +23,11 @@ type ZapKafkaWriter struct {
 // newZapKafkaWriter returns a kafka io.writer instance
 func newZapKafkaWriter(
 	kpCfg ProducerConfiguration, cloudEvents *CloudEvents,
-	ceCfg CloudEventsConfiguration) (*ZapKafkaWriter, error) {
+	ceCfg CloudEventsConfiguration, fieldMap FieldMap) (*ZapKafkaWriter,
+	error) {
 
 	// create an async producer
-	kp, err := newKafkaProducer(kpCfg, cloudEvents, ceCfg)
+	kp, err := newKafkaProducer(kpCfg, cloudEvents, ceCfg, fieldMap)
 	if err != nil {
 		return nil, err
 	}
@@ -52,10 +55,15 @@ func (zw *ZapKafkaWriter) Write(msg []byte) (int, error) {
 		return 0, errors.New("No producer defined")
 	}
 
+	return zw.write(msg, nil)
+}
+
+// write sends the message to Kafka using the route of the logger
+func (zw *ZapKafkaWriter) write(msg []byte, route *kafkaRoute) (int, error) {
 	zw.pendingWg.Add(1)
 	defer zw.pendingWg.Done()
 
-	err := zw.kp.sendMessage(msg)
+	err := zw.kp.sendMessage(msg, route)
 	return len(msg), err
 }
 
@@ -76,5 +84,134 @@ func (zw *ZapKafkaWriter) Close() (err error) {
 	atomic.StoreInt32(&zw.closed, 1)
 
 	zw.pendingWg.Wait()
+	return zw.kp.close()
+}
+
+// routeField returns a zap field carrying kafka routing
+// Encoders ignore skip fields so routing never reaches any output
+func routeField(route *kafkaRoute) zapcore.Field {
+	return zapcore.Field{Type: zapcore.SkipType, Interface: route}
+}
+
+// zapKafkaCore is a zap core that passes the logger route to the kafka writer
+type zapKafkaCore struct {
+	zapcore.LevelEnabler
+	enc    zapcore.Encoder
+	writer *ZapKafkaWriter
+	route  *kafkaRoute
+}
+
+// newZapKafkaCore returns a kafka core instance
+func newZapKafkaCore(enc zapcore.Encoder, writer *ZapKafkaWriter,
+	enab zapcore.LevelEnabler) zapcore.Core {
+	return &zapKafkaCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		writer:       writer,
+	}
+}
+
+// With meets the interface for the zapcore core, capturing route fields
+func (c *zapKafkaCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &zapKafkaCore{
+		LevelEnabler: c.LevelEnabler,
+		enc:          c.enc.Clone(),
+		writer:       c.writer,
+		route:        c.route,
+	}
+	for _, field := range fields {
+		if route, ok := field.Interface.(*kafkaRoute); ok &&
+			field.Type == zapcore.SkipType {
+			clone.route = c.route.merge(route)
+			continue
+		}
+		field.AddTo(clone.enc)
+	}
+	return clone
+}
+
+// Check meets the interface for the zapcore core
+func (c *zapKafkaCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write meets the interface for the zapcore core
+func (c *zapKafkaCore) Write(entry zapcore.Entry,
+	fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	_, err = c.writer.write(buf.Bytes(), c.route)
+	buf.Free()
+	return err
+}
+
+// Sync meets the interface for the zapcore core
+func (c *zapKafkaCore) Sync() error {
+	return c.writer.Sync()
+}
+
+// zapSinkCore is a zap core that passes records and entries to a sink
+type zapSinkCore struct {
+	zapcore.LevelEnabler
+	enc    zapcore.Encoder
+	writer *sinkWriter
+}
+
+// newZapSinkCore returns a sink core instance
+func newZapSinkCore(enc zapcore.Encoder, writer *sinkWriter,
+	enab zapcore.LevelEnabler) zapcore.Core {
+	return &zapSinkCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		writer:       writer,
+	}
+}
+
+// With meets the interface for the zapcore core
+func (c *zapSinkCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &zapSinkCore{
+		LevelEnabler: c.LevelEnabler,
+		enc:          c.enc.Clone(),
+		writer:       c.writer,
+	}
+	for _, field := range fields {
+		field.AddTo(clone.enc)
+	}
+	return clone
+}
+
+// Check meets the interface for the zapcore core
+func (c *zapSinkCore) Check(entry zapcore.Entry,
+	checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write meets the interface for the zapcore core
+func (c *zapSinkCore) Write(entry zapcore.Entry,
+	fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	err = c.writer.writeEntry(buf.Bytes(), Entry{
+		Level:   getZapLevelType(entry.Level),
+		Time:    entry.Time,
+		Message: entry.Message,
+	})
+	buf.Free()
+	return err
+}
+
+// Sync meets the interface for the zapcore core
+func (c *zapSinkCore) Sync() error {
 	return nil
 }